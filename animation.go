@@ -0,0 +1,212 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	ANIMATION_TARGET_NODE     = 0
+	ANIMATION_TARGET_INSTANCE = 1
+)
+
+const (
+	ANIMATION_PATH_TRANSLATION = 0
+	ANIMATION_PATH_ROTATION    = 1
+	ANIMATION_PATH_SCALE       = 2
+)
+
+const (
+	ANIMATION_INTERPOLATION_LINEAR = 0
+	ANIMATION_INTERPOLATION_STEP   = 1
+)
+
+type AnimationTrack struct {
+	TargetType    uint8     `json:"targetType"`
+	Target        uint32    `json:"target"`
+	Transform     uint32    `json:"transform,omitempty"`
+	Path          uint8     `json:"path"`
+	Interpolation uint8     `json:"interpolation,omitempty"`
+	Times         []float32 `json:"times"`
+	Values        []float32 `json:"values"`
+}
+
+type Animation struct {
+	Name   string            `json:"name,omitempty"`
+	Tracks []*AnimationTrack `json:"tracks"`
+}
+
+func (t *AnimationTrack) Components() int {
+	if t.Path == ANIMATION_PATH_ROTATION {
+		return 4
+	}
+	return 3
+}
+
+func (t *AnimationTrack) validate(ms *Mesh) error {
+	switch t.TargetType {
+	case ANIMATION_TARGET_NODE:
+		if int(t.Target) >= len(ms.Nodes) {
+			return &IndexError{Kind: "node", Index: t.Target, Count: len(ms.Nodes)}
+		}
+	case ANIMATION_TARGET_INSTANCE:
+		if int(t.Target) >= len(ms.InstanceNode) {
+			return &IndexError{Kind: "instance", Index: t.Target, Count: len(ms.InstanceNode)}
+		}
+		if n := len(ms.InstanceNode[t.Target].Transfors); int(t.Transform) >= n {
+			return &IndexError{Kind: "transform", Index: t.Transform, Count: n}
+		}
+	default:
+		return fmt.Errorf("mst: invalid animation target type %d", t.TargetType)
+	}
+	if t.Path > ANIMATION_PATH_SCALE {
+		return fmt.Errorf("mst: invalid animation path %d", t.Path)
+	}
+	if t.Interpolation > ANIMATION_INTERPOLATION_STEP {
+		return fmt.Errorf("mst: invalid animation interpolation %d", t.Interpolation)
+	}
+	if len(t.Times) == 0 {
+		return fmt.Errorf("mst: animation track without keyframes")
+	}
+	if len(t.Values) != len(t.Times)*t.Components() {
+		return fmt.Errorf("mst: animation track has %d values for %d keyframes", len(t.Values), len(t.Times))
+	}
+	for i, tm := range t.Times {
+		if !finite(tm) || (i > 0 && tm <= t.Times[i-1]) {
+			return fmt.Errorf("mst: animation keyframe times must be finite and increasing")
+		}
+	}
+	for _, v := range t.Values {
+		if !finite(v) {
+			return fmt.Errorf("mst: animation track has non-finite values")
+		}
+	}
+	return nil
+}
+
+func (m *Mesh) ValidateAnimations() error {
+	errs := &MultiError{}
+	for i, a := range m.Animations {
+		for j, t := range a.Tracks {
+			errs.Append(fmt.Sprintf("animations[%d].tracks[%d]", i, j), t.validate(m))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func (t *AnimationTrack) Sample(time float32) []float32 {
+	c := t.Components()
+	if len(t.Times) == 0 || len(t.Values) < len(t.Times)*c {
+		return nil
+	}
+	i := sort.Search(len(t.Times), func(i int) bool { return t.Times[i] > time })
+	switch {
+	case i == 0:
+		return append([]float32(nil), t.Values[:c]...)
+	case i == len(t.Times) || t.Interpolation == ANIMATION_INTERPOLATION_STEP:
+		return append([]float32(nil), t.Values[(i-1)*c:i*c]...)
+	}
+	a, b := t.Values[(i-1)*c:i*c], t.Values[i*c:(i+1)*c]
+	f := (time - t.Times[i-1]) / (t.Times[i] - t.Times[i-1])
+	if t.Path == ANIMATION_PATH_ROTATION {
+		return slerp(a, b, f)
+	}
+	out := make([]float32, c)
+	for k := range out {
+		out[k] = a[k] + (b[k]-a[k])*f
+	}
+	return out
+}
+
+func slerp(a, b []float32, f float32) []float32 {
+	dot := float64(a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3])
+	sign := 1.0
+	if dot < 0 {
+		dot, sign = -dot, -1
+	}
+	wa, wb := 1-float64(f), float64(f)
+	if dot < 0.9995 {
+		theta := math.Acos(dot)
+		s := math.Sin(theta)
+		wa, wb = math.Sin((1-float64(f))*theta)/s, math.Sin(float64(f)*theta)/s
+	}
+	out := make([]float32, 4)
+	var l float64
+	for k := range out {
+		v := wa*float64(a[k]) + sign*wb*float64(b[k])
+		out[k] = float32(v)
+		l += v * v
+	}
+	if l = math.Sqrt(l); l > 0 {
+		for k := range out {
+			out[k] = float32(float64(out[k]) / l)
+		}
+	}
+	return out
+}
+
+func animationsMarshal(wt io.Writer, anims []*Animation) {
+	writeLittleByte(wt, uint32(len(anims)))
+	for _, a := range anims {
+		writeLittleByte(wt, uint32(len(a.Name)))
+		wt.Write([]byte(a.Name))
+		writeLittleByte(wt, uint32(len(a.Tracks)))
+		for _, t := range a.Tracks {
+			writeLittleByte(wt, t.TargetType)
+			writeLittleByte(wt, t.Target)
+			writeLittleByte(wt, t.Transform)
+			writeLittleByte(wt, t.Path)
+			writeLittleByte(wt, t.Interpolation)
+			writeLittleByte(wt, uint32(len(t.Times)))
+			writeLittleByte(wt, t.Times)
+			values := make([]float32, len(t.Times)*t.Components())
+			copy(values, t.Values)
+			writeLittleByte(wt, values)
+		}
+	}
+}
+
+func (d *decoder) float32s(n int) []float32 {
+	out := make([]float32, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]float32, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) animationTrack() *AnimationTrack {
+	t := &AnimationTrack{}
+	d.read(&t.TargetType)
+	d.read(&t.Target)
+	d.read(&t.Transform)
+	d.read(&t.Path)
+	d.read(&t.Interpolation)
+	if d.err == nil && t.Path > ANIMATION_PATH_SCALE {
+		d.fail(fmt.Errorf("mst: invalid animation path %d", t.Path))
+		return t
+	}
+	n := d.count("keyframe count", func(l *DecodeLimits) uint32 { return l.MaxKeyframes })
+	t.Times = d.float32s(n)
+	t.Values = d.float32s(n * t.Components())
+	return t
+}
+
+func (d *decoder) animations() []*Animation {
+	n := d.count("animation count", func(l *DecodeLimits) uint32 { return l.MaxNodes })
+	anims := make([]*Animation, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		a := &Animation{Name: d.string("animation name length")}
+		m := d.count("animation track count", func(l *DecodeLimits) uint32 { return l.MaxNodes })
+		a.Tracks = make([]*AnimationTrack, 0, capHint(m))
+		for j := 0; j < m && d.err == nil; j++ {
+			a.Tracks = append(a.Tracks, d.animationTrack())
+		}
+		anims = append(anims, a)
+	}
+	return anims
+}
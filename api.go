@@ -0,0 +1,170 @@
+package mst
+
+import "io"
+
+type errWriter struct {
+	wt  io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.wt.Write(p)
+	w.err = err
+	return n, err
+}
+
+type Encoder struct {
+	wt      *errWriter
+	opts    []WriteOption
+	version uint32
+}
+
+func NewEncoder(wt io.Writer, opts ...WriteOption) *Encoder {
+	return &Encoder{wt: &errWriter{wt: wt}, opts: opts}
+}
+
+func (e *Encoder) SetVersion(v uint32) error {
+	if !IsSupportedVersion(v) {
+		return ErrUnsupportedVersion
+	}
+	e.version = v
+	return nil
+}
+
+func (e *Encoder) sectionVersion() uint32 {
+	if e.version != 0 {
+		return e.version
+	}
+	return MESH_LATEST_VERSION
+}
+
+func (e *Encoder) Encode(ms *Mesh) error {
+	if err := newWriteOptions(e.opts).validate(); err != nil {
+		return err
+	}
+	if e.version != 0 && e.version != ms.Version {
+		cp := *ms
+		cp.Version = e.version
+		ms = &cp
+	}
+	if err := meshMarshal(e.wt, ms, newWriteOptions(e.opts)); err != nil {
+		return err
+	}
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeMaterials(mtls []MeshMaterial) error {
+	mtlsMarshal(e.wt, mtls, e.sectionVersion())
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeNode(nd *MeshNode) error {
+	meshNodeMarshal(e.wt, nd, e.sectionVersion())
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeNodes(nds []*MeshNode) error {
+	meshNodesMarshal(e.wt, nds, e.sectionVersion())
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeInstance(inst *InstanceMesh) error {
+	instanceNodeMarshal(e.wt, inst, e.sectionVersion())
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeInstances(insts []*InstanceMesh) error {
+	instanceNodesMarshal(e.wt, insts, e.sectionVersion())
+	return e.wt.err
+}
+
+func (e *Encoder) EncodeProps(props Properties) error {
+	PropertiesMarshal(e.wt, props)
+	return e.wt.err
+}
+
+type Decoder struct {
+	rd      io.Reader
+	opts    DecodeOptions
+	version uint32
+}
+
+func NewDecoder(rd io.Reader, opts *DecodeOptions) *Decoder {
+	if opts == nil {
+		opts = &DefaultDecodeOptions
+	}
+	return &Decoder{rd: rd, opts: *opts, version: MESH_LATEST_VERSION}
+}
+
+func (d *Decoder) SetVersion(v uint32) error {
+	if !IsSupportedVersion(v) {
+		return ErrUnsupportedVersion
+	}
+	d.version = v
+	return nil
+}
+
+func (d *Decoder) Decode() (*Mesh, error) {
+	return MeshUnMarshalWithOptions(d.rd, &d.opts)
+}
+
+func (d *Decoder) section() *decoder {
+	return newDecoder(d.rd, d.version, d.opts.Limits)
+}
+
+func (d *Decoder) DecodeMaterials() ([]MeshMaterial, error) {
+	sd := d.section()
+	mtls := sd.materials()
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return mtls, nil
+}
+
+func (d *Decoder) DecodeNode() (*MeshNode, error) {
+	sd := d.section()
+	nd := sd.meshNode()
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return nd, nil
+}
+
+func (d *Decoder) DecodeNodes() ([]*MeshNode, error) {
+	sd := d.section()
+	nds := sd.meshNodes()
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return nds, nil
+}
+
+func (d *Decoder) DecodeInstance() (*InstanceMesh, error) {
+	sd := d.section()
+	inst := sd.instanceNode()
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return inst, nil
+}
+
+func (d *Decoder) DecodeInstances() ([]*InstanceMesh, error) {
+	sd := d.section()
+	insts := sd.instanceNodes()
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return insts, nil
+}
+
+func (d *Decoder) DecodeProps() (Properties, error) {
+	sd := d.section()
+	props := sd.props(0)
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	return props, nil
+}
@@ -0,0 +1,137 @@
+package mst
+
+import (
+	"fmt"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	AXIS_CONVENTION_NONE = 0
+	AXIS_CONVENTION_Z_UP = 1
+	AXIS_CONVENTION_Y_UP = 2
+)
+
+type frameChange struct {
+	perm  [4]int
+	sign  [4]float64
+	scale float64
+}
+
+var identityFrame = frameChange{perm: [4]int{0, 1, 2, 3}, sign: [4]float64{1, 1, 1, 1}, scale: 1}
+
+func axisChange(from, to uint8) (*frameChange, error) {
+	for _, a := range []uint8{from, to} {
+		if a > AXIS_CONVENTION_Y_UP {
+			return nil, fmt.Errorf("mst: unknown axis convention %d", a)
+		}
+	}
+	if from == to || from == AXIS_CONVENTION_NONE || to == AXIS_CONVENTION_NONE {
+		return nil, nil
+	}
+	if from == AXIS_CONVENTION_Z_UP {
+		return &frameChange{perm: [4]int{0, 2, 1, 3}, sign: [4]float64{1, 1, -1, 1}, scale: 1}, nil
+	}
+	return &frameChange{perm: [4]int{0, 2, 1, 3}, sign: [4]float64{1, -1, 1, 1}, scale: 1}, nil
+}
+
+func (b *frameChange) vecs(vs []vec3.T, scale float64) {
+	for i, v := range vs {
+		for c := 0; c < 3; c++ {
+			vs[i][c] = float32(b.sign[c] * scale * float64(v[b.perm[c]]))
+		}
+	}
+}
+
+func (b *frameChange) mat(mt *dmat.T) {
+	src := *mt
+	s := [4]float64{b.scale, b.scale, b.scale, 1}
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			mt[c][r] = b.sign[c] * b.sign[r] * src[b.perm[c]][b.perm[r]] * s[r] / s[c]
+		}
+	}
+}
+
+func (b *frameChange) box(bx *[6]float64) {
+	src := *bx
+	for i := 0; i < 3; i++ {
+		p := b.perm[i]
+		if b.sign[i] > 0 {
+			bx[i], bx[i+3] = src[p]*b.scale, src[p+3]*b.scale
+		} else {
+			bx[i], bx[i+3] = -src[p+3]*b.scale, -src[p]*b.scale
+		}
+	}
+}
+
+func (b *frameChange) track(t *AnimationTrack) {
+	n := 3
+	if t.Path == ANIMATION_PATH_ROTATION {
+		n = 4
+	}
+	for k := 0; k+n <= len(t.Values); k += n {
+		src := append([]float32(nil), t.Values[k:k+n]...)
+		for i := 0; i < 3; i++ {
+			t.Values[k+i] = src[b.perm[i]]
+			switch t.Path {
+			case ANIMATION_PATH_TRANSLATION:
+				t.Values[k+i] = float32(b.sign[i] * b.scale * float64(src[b.perm[i]]))
+			case ANIMATION_PATH_ROTATION:
+				t.Values[k+i] *= float32(b.sign[i])
+			}
+		}
+	}
+}
+
+func (b *frameChange) baseMesh(mh *BaseMesh) {
+	for _, nd := range mh.Nodes {
+		b.vecs(nd.Vertices, b.scale)
+		b.vecs(nd.Normals, 1)
+		for _, mt := range nd.MorphTargets {
+			b.vecs(mt.Positions, b.scale)
+			b.vecs(mt.Normals, 1)
+		}
+		if nd.Mat != nil {
+			b.mat(nd.Mat)
+		}
+	}
+}
+
+func (b *frameChange) mesh(out *Mesh) {
+	b.baseMesh(&out.BaseMesh)
+	seen := make(map[*BaseMesh]bool)
+	for _, inst := range out.InstanceNode {
+		for _, mt := range inst.Transfors {
+			if mt != nil {
+				b.mat(mt)
+			}
+		}
+		if inst.BBox != nil {
+			b.box(inst.BBox)
+		}
+		for i := range inst.Bounds {
+			b.box(&inst.Bounds[i])
+		}
+		if inst.Mesh != nil && !seen[inst.Mesh] {
+			seen[inst.Mesh] = true
+			b.baseMesh(inst.Mesh)
+		}
+	}
+	for _, anim := range out.Animations {
+		for _, t := range anim.Tracks {
+			b.track(t)
+		}
+	}
+}
+
+func (m *Mesh) ConvertAxis(from, to uint8) (*Mesh, error) {
+	b, err := axisChange(from, to)
+	if err != nil || b == nil {
+		return m, err
+	}
+	out := m.Clone(WithSharedTextures())
+	b.mesh(out)
+	return out, nil
+}
@@ -0,0 +1,158 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+const BAKE_MAX_RESOLUTION = 8192
+
+// BakeFaceColorsToTexture rasterizes the material color of every face, modulated
+// by vertex colors when present, into a texture addressed by the node's
+// TexCoords. A UV atlas is generated first when the node has none. The node is
+// moved onto a single new white TextureMaterial appended to the mesh materials
+// and its vertex colors are dropped, since they now live in the texture.
+func (m *BaseMesh) BakeFaceColorsToTexture(nd *MeshNode, resolution int) (*TextureMaterial, error) {
+	if resolution < 1 || resolution > BAKE_MAX_RESOLUTION {
+		return nil, fmt.Errorf("mst: bake resolution must be between 1 and %d", BAKE_MAX_RESOLUTION)
+	}
+	if err := nd.validateVertexIndices(); err != nil {
+		return nil, err
+	}
+	if len(nd.TexCoords) == 0 {
+		opts := DefaultUVAtlasOptions
+		opts.Resolution = resolution
+		if err := nd.GenerateUVAtlas(&opts); err != nil {
+			return nil, err
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, resolution, resolution))
+	covered := make([]bool, resolution*resolution)
+	vertexColors := len(nd.Colors) == len(nd.Vertices)
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			uvi := &f.Vertex
+			if f.Uv != nil {
+				uvi = f.Uv
+			}
+			if checkIndices("texcoord", uvi[:], len(nd.TexCoords)) != nil {
+				return nil, errors.New("mst: node texcoords do not cover its vertices")
+			}
+			mid := g.Batchid
+			if f.Material != nil {
+				mid = *f.Material
+			}
+			base := [3]byte{255, 255, 255}
+			if mid >= 0 && int(mid) < len(m.Materials) && m.Materials[mid] != nil {
+				base = m.Materials[mid].GetColor()
+			}
+			var uvs [3]vec2.T
+			var cls [3][3]float64
+			for k := 0; k < 3; k++ {
+				uvs[k] = nd.TexCoords[uvi[k]]
+				for c := 0; c < 3; c++ {
+					cls[k][c] = float64(base[c])
+					if vertexColors {
+						cls[k][c] *= float64(nd.Colors[f.Vertex[k]][c]) / 255
+					}
+				}
+			}
+			rasterizeUVTriangle(img, covered, uvs, cls)
+		}
+	}
+	dilateTexels(img, covered, 2)
+	tex, err := imageTexture(img)
+	if err != nil {
+		return nil, err
+	}
+	tex.Repeated = false
+	for _, mtl := range m.Materials {
+		if tm := materialTextures(mtl); tm != nil {
+			for _, t := range []*Texture{tm.Texture, tm.Normal} {
+				if t != nil && t.Id >= tex.Id {
+					tex.Id = t.Id + 1
+				}
+			}
+		}
+	}
+	tex.Name = fmt.Sprintf("baked_%d.png", tex.Id)
+	mtl := &TextureMaterial{BaseMaterial: BaseMaterial{Color: [3]byte{255, 255, 255}}, Texture: tex}
+	m.Materials = append(m.Materials, mtl)
+	id := int32(len(m.Materials) - 1)
+	nd.remapBatchids(func(int32) int32 { return id })
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			f.Material = nil
+		}
+	}
+	nd.Colors = nil
+	return mtl, nil
+}
+
+func rasterizeUVTriangle(img *image.NRGBA, covered []bool, uvs [3]vec2.T, cls [3][3]float64) {
+	size := img.Bounds().Dx()
+	var p [3][2]float64
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for k, uv := range uvs {
+		p[k] = [2]float64{float64(uv[0]) * float64(size), float64(uv[1]) * float64(size)}
+		minX, maxX = math.Min(minX, p[k][0]), math.Max(maxX, p[k][0])
+		minY, maxY = math.Min(minY, p[k][1]), math.Max(maxY, p[k][1])
+	}
+	area := (p[1][0]-p[0][0])*(p[2][1]-p[0][1]) - (p[2][0]-p[0][0])*(p[1][1]-p[0][1])
+	if area == 0 || math.IsNaN(area) {
+		return
+	}
+	x0, x1 := int(math.Max(math.Floor(minX), 0)), int(math.Min(math.Ceil(maxX), float64(size-1)))
+	y0, y1 := int(math.Max(math.Floor(minY), 0)), int(math.Min(math.Ceil(maxY), float64(size-1)))
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			cx, cy := float64(x)+0.5, float64(y)+0.5
+			var w [3]float64
+			inside := true
+			for k := 0; k < 3; k++ {
+				a, b := p[(k+1)%3], p[(k+2)%3]
+				w[k] = ((b[0]-a[0])*(cy-a[1]) - (b[1]-a[1])*(cx-a[0])) / area
+				inside = inside && w[k] >= 0
+			}
+			if !inside {
+				continue
+			}
+			var c [3]uint8
+			for ch := 0; ch < 3; ch++ {
+				c[ch] = uint8(math.Round(math.Min(255, w[0]*cls[0][ch]+w[1]*cls[1][ch]+w[2]*cls[2][ch])))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: c[0], G: c[1], B: c[2], A: 255})
+			covered[y*size+x] = true
+		}
+	}
+}
+
+// dilateTexels grows the rasterized charts outwards so bilinear filtering and
+// mipmapping near chart borders do not pull in the empty background.
+func dilateTexels(img *image.NRGBA, covered []bool, passes int) {
+	size := img.Bounds().Dx()
+	for ; passes > 0; passes-- {
+		next := append([]bool(nil), covered...)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if covered[y*size+x] {
+					continue
+				}
+				for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					nx, ny := x+d[0], y+d[1]
+					if nx >= 0 && ny >= 0 && nx < size && ny < size && covered[ny*size+nx] {
+						img.SetNRGBA(x, y, img.NRGBAAt(nx, ny))
+						next[y*size+x] = true
+						break
+					}
+				}
+			}
+		}
+		covered = next
+	}
+}
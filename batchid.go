@@ -0,0 +1,77 @@
+package mst
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrInvalidMaterialOrder = errors.New("mst: material order must be a permutation of the material indices")
+
+func (m *BaseMesh) RemapBatchids(mapping map[int32]int32) error {
+	for _, id := range mapping {
+		if id < 0 || int(id) >= len(m.Materials) {
+			return &IndexError{Kind: "material", Index: uint32(id), Count: len(m.Materials)}
+		}
+	}
+	remap := func(id int32) int32 {
+		if nid, ok := mapping[id]; ok {
+			return nid
+		}
+		return id
+	}
+	for _, nd := range m.Nodes {
+		nd.remapBatchids(remap)
+	}
+	return nil
+}
+
+func (n *MeshNode) remapBatchids(remap func(int32) int32) {
+	var groups []*MeshTriangle
+	index := make(map[int32]*MeshTriangle)
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if f.Material != nil {
+				id := remap(*f.Material)
+				f.Material = &id
+			}
+		}
+		g.Batchid = remap(g.Batchid)
+		if tg, ok := index[g.Batchid]; ok {
+			for i, f := range g.Faces {
+				tg.addFace(f, g, i)
+			}
+			continue
+		}
+		index[g.Batchid] = g
+		groups = append(groups, g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Batchid < groups[j].Batchid })
+	n.FaceGroup = groups
+	for _, g := range n.EdgeGroup {
+		g.Batchid = remap(g.Batchid)
+	}
+	for _, g := range n.PolygonGroup {
+		g.Batchid = remap(g.Batchid)
+	}
+	sort.SliceStable(n.PolygonGroup, func(i, j int) bool { return n.PolygonGroup[i].Batchid < n.PolygonGroup[j].Batchid })
+	sort.SliceStable(n.EdgeGroup, func(i, j int) bool { return n.EdgeGroup[i].Batchid < n.EdgeGroup[j].Batchid })
+}
+
+func (m *BaseMesh) ReorderMaterials(order []int) error {
+	if len(order) != len(m.Materials) {
+		return ErrInvalidMaterialOrder
+	}
+	seen := make([]bool, len(order))
+	mtls := make([]MeshMaterial, len(order))
+	mapping := make(map[int32]int32, len(order))
+	for i, o := range order {
+		if o < 0 || o >= len(order) || seen[o] {
+			return ErrInvalidMaterialOrder
+		}
+		seen[o] = true
+		mtls[i] = m.Materials[o]
+		mapping[int32(o)] = int32(i)
+	}
+	m.Materials = mtls
+	return m.RemapBatchids(mapping)
+}
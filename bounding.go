@@ -0,0 +1,278 @@
+package mst
+
+import (
+	"math"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+type BBoxOptions struct {
+	IgnoreNodeTransforms bool
+	Transform            *dmat.T
+}
+
+func (m *Mesh) ComputeBBoxWorld(mt *dmat.T) dvec3.Box {
+	return m.ComputeBBoxWithOptions(&BBoxOptions{Transform: mt})
+}
+
+func (m *Mesh) ComputeBBoxWithOptions(opts *BBoxOptions) dvec3.Box {
+	if opts == nil {
+		opts = &BBoxOptions{}
+	}
+	bbox := dvec3.MinBox
+	joined := false
+	for _, nd := range m.Nodes {
+		if len(nd.Vertices) == 0 {
+			continue
+		}
+		mt := opts.Transform
+		if nd.Mat != nil && !opts.IgnoreNodeTransforms {
+			mt = nd.Mat
+			if opts.Transform != nil {
+				mt = (&dmat.T{}).AssignMul(opts.Transform, nd.Mat)
+			}
+		}
+		for _, p := range nd.boundingPoints() {
+			if mt != nil {
+				mt.TransformVec3(&p)
+			}
+			bbox.Extend(&p)
+		}
+		joined = true
+	}
+	for _, inst := range m.InstanceNode {
+		for _, bx := range inst.instanceBounds(&BBoxOptions{IgnoreNodeTransforms: opts.IgnoreNodeTransforms}) {
+			for _, p := range boxCorners(&bx) {
+				if opts.Transform != nil {
+					opts.Transform.TransformVec3(&p)
+				}
+				bbox.Extend(&p)
+			}
+			joined = true
+		}
+	}
+	if !joined {
+		return dvec3.Box{}
+	}
+	return bbox
+}
+
+type OBB struct {
+	Center   [3]float64    `json:"center"`
+	HalfAxes [3][3]float64 `json:"halfAxes"`
+}
+
+type BoundingSphere struct {
+	Center [3]float64 `json:"center"`
+	Radius float64    `json:"radius"`
+}
+
+func (o OBB) TilesBox() [12]float64 {
+	return [12]float64{
+		o.Center[0], o.Center[1], o.Center[2],
+		o.HalfAxes[0][0], o.HalfAxes[0][1], o.HalfAxes[0][2],
+		o.HalfAxes[1][0], o.HalfAxes[1][1], o.HalfAxes[1][2],
+		o.HalfAxes[2][0], o.HalfAxes[2][1], o.HalfAxes[2][2],
+	}
+}
+
+func (o OBB) Volume() float64 {
+	v := 8.0
+	for _, a := range o.HalfAxes {
+		v *= math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+	}
+	return v
+}
+
+func (s BoundingSphere) TilesSphere() [4]float64 {
+	return [4]float64{s.Center[0], s.Center[1], s.Center[2], s.Radius}
+}
+
+func (nd *MeshNode) boundingPoints() []dvec3.T {
+	pts := make([]dvec3.T, len(nd.Vertices))
+	for i, v := range nd.Vertices {
+		pts[i] = dvec3.T{float64(v[0]), float64(v[1]), float64(v[2])}
+	}
+	return pts
+}
+
+func (m *Mesh) boundingPoints() []dvec3.T {
+	var pts []dvec3.T
+	for _, nd := range m.Nodes {
+		pts = append(pts, nd.worldPoints()...)
+	}
+	for _, inst := range m.InstanceNode {
+		for _, bx := range inst.instanceBounds(nil) {
+			pts = append(pts, boxCorners(&bx)...)
+		}
+	}
+	return pts
+}
+
+func boxCorners(bx *[6]float64) []dvec3.T {
+	pts := make([]dvec3.T, 8)
+	for i := range pts {
+		pts[i] = dvec3.T{bx[0], bx[1], bx[2]}
+		if i&1 != 0 {
+			pts[i][0] = bx[3]
+		}
+		if i&2 != 0 {
+			pts[i][1] = bx[4]
+		}
+		if i&4 != 0 {
+			pts[i][2] = bx[5]
+		}
+	}
+	return pts
+}
+
+func (nd *MeshNode) ComputeOBB() OBB {
+	return computeOBB(nd.boundingPoints())
+}
+
+func (m *Mesh) ComputeOBB() OBB {
+	return computeOBB(m.boundingPoints())
+}
+
+func (nd *MeshNode) ComputeBoundingSphere() BoundingSphere {
+	return computeBoundingSphere(nd.boundingPoints())
+}
+
+func (m *Mesh) ComputeBoundingSphere() BoundingSphere {
+	return computeBoundingSphere(m.boundingPoints())
+}
+
+func fitOBB(pts []dvec3.T, axes [3]dvec3.T) OBB {
+	var lo, hi [3]float64
+	for k := range axes {
+		lo[k], hi[k] = math.MaxFloat64, -math.MaxFloat64
+		for i := range pts {
+			d := dvec3.Dot(&pts[i], &axes[k])
+			lo[k] = math.Min(lo[k], d)
+			hi[k] = math.Max(hi[k], d)
+		}
+	}
+	var o OBB
+	for k, a := range axes {
+		mid, half := (lo[k]+hi[k])/2, (hi[k]-lo[k])/2
+		for c := 0; c < 3; c++ {
+			o.Center[c] += a[c] * mid
+			o.HalfAxes[k][c] = a[c] * half
+		}
+	}
+	return o
+}
+
+func computeOBB(pts []dvec3.T) OBB {
+	if len(pts) == 0 {
+		return OBB{}
+	}
+	aabb := fitOBB(pts, [3]dvec3.T{dvec3.UnitX, dvec3.UnitY, dvec3.UnitZ})
+	if len(pts) < 4 {
+		return aabb
+	}
+	var mean dvec3.T
+	for i := range pts {
+		mean.Add(&pts[i])
+	}
+	mean.Scale(1 / float64(len(pts)))
+	var cov [3][3]float64
+	for i := range pts {
+		d := dvec3.Sub(&pts[i], &mean)
+		for r := 0; r < 3; r++ {
+			for c := r; c < 3; c++ {
+				cov[r][c] += d[r] * d[c]
+			}
+		}
+	}
+	for r := 0; r < 3; r++ {
+		for c := 0; c < r; c++ {
+			cov[r][c] = cov[c][r]
+		}
+	}
+	vecs := jacobiEigenvectors(cov)
+	axes := [3]dvec3.T{{vecs[0][0], vecs[1][0], vecs[2][0]}, {vecs[0][1], vecs[1][1], vecs[2][1]}}
+	for k := 0; k < 2; k++ {
+		axes[k].Normalize()
+	}
+	axes[2] = dvec3.Cross(&axes[0], &axes[1])
+	axes[2].Normalize()
+	pca := fitOBB(pts, axes)
+	if pca.Volume() < aabb.Volume() {
+		return pca
+	}
+	return aabb
+}
+
+func jacobiEigenvectors(a [3][3]float64) [3][3]float64 {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off < 1e-24 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	return v
+}
+
+func farthestPoint(pts []dvec3.T, from *dvec3.T) *dvec3.T {
+	best, dist := &pts[0], -1.0
+	for i := range pts {
+		if d := dvec3.SquareDistance(&pts[i], from); d > dist {
+			best, dist = &pts[i], d
+		}
+	}
+	return best
+}
+
+func computeBoundingSphere(pts []dvec3.T) BoundingSphere {
+	if len(pts) == 0 {
+		return BoundingSphere{}
+	}
+	y := farthestPoint(pts, &pts[0])
+	z := farthestPoint(pts, y)
+	center := dvec3.Interpolate(y, z, 0.5)
+	radius := dvec3.Distance(y, z) / 2
+	for i := range pts {
+		d := dvec3.Distance(&pts[i], &center)
+		if d <= radius {
+			continue
+		}
+		grow := (d - radius) / 2
+		radius += grow
+		dir := dvec3.Sub(&pts[i], &center)
+		dir.Scale(grow / d)
+		center.Add(&dir)
+	}
+	return BoundingSphere{Center: center, Radius: radius}
+}
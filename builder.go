@@ -0,0 +1,73 @@
+package mst
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrBuilderFinished = errors.New("mst: mesh builder already finished")
+
+type MeshBuilder struct {
+	mu   sync.Mutex
+	mesh *Mesh
+}
+
+func NewMeshBuilder() *MeshBuilder {
+	return &MeshBuilder{mesh: NewMesh()}
+}
+
+func (b *MeshBuilder) AddMaterial(mtl MeshMaterial) (int32, error) {
+	if mtl == nil {
+		return 0, errors.New("mst: nil material")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mesh == nil {
+		return 0, ErrBuilderFinished
+	}
+	b.mesh.Materials = append(b.mesh.Materials, mtl)
+	return int32(len(b.mesh.Materials) - 1), nil
+}
+
+func (b *MeshBuilder) AddNode(nd *MeshNode) (int, error) {
+	if nd == nil {
+		return 0, errors.New("mst: nil node")
+	}
+	if err := nd.validateVertexIndices(); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mesh == nil {
+		return 0, ErrBuilderFinished
+	}
+	b.mesh.Nodes = append(b.mesh.Nodes, nd)
+	return len(b.mesh.Nodes) - 1, nil
+}
+
+func (b *MeshBuilder) AddInstance(inst *InstanceMesh) (int, error) {
+	if inst == nil {
+		return 0, errors.New("mst: nil instance")
+	}
+	if inst.Mesh == nil && inst.Ref == nil {
+		return 0, ErrUnresolvedInstanceRef
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mesh == nil {
+		return 0, ErrBuilderFinished
+	}
+	b.mesh.InstanceNode = append(b.mesh.InstanceNode, inst)
+	return len(b.mesh.InstanceNode) - 1, nil
+}
+
+func (b *MeshBuilder) Finish() (*Mesh, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.mesh == nil {
+		return nil, ErrBuilderFinished
+	}
+	ms := b.mesh
+	b.mesh = nil
+	return ms, nil
+}
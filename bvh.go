@@ -0,0 +1,231 @@
+package mst
+
+import (
+	"math"
+	"sort"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+const bvhLeafSize = 4
+
+type bvhTriangle struct {
+	v           [3]dvec3.T
+	node        int
+	group, face int
+	instance    int
+	transform   int
+}
+
+func (t *bvhTriangle) box() dvec3.Box {
+	bx := dvec3.Box{Min: t.v[0], Max: t.v[0]}
+	bx.Extend(&t.v[1])
+	bx.Extend(&t.v[2])
+	return bx
+}
+
+func (t *bvhTriangle) centroid() dvec3.T {
+	return dvec3.T{
+		(t.v[0][0] + t.v[1][0] + t.v[2][0]) / 3,
+		(t.v[0][1] + t.v[1][1] + t.v[2][1]) / 3,
+		(t.v[0][2] + t.v[1][2] + t.v[2][2]) / 3,
+	}
+}
+
+type bvhNode struct {
+	box          dvec3.Box
+	left, right  int
+	start, count int
+}
+
+type bvh struct {
+	nodes []bvhNode
+	tris  []bvhTriangle
+}
+
+func nodeTriangles(nd *MeshNode, node int, tris []bvhTriangle) ([]bvhTriangle, error) {
+	nd = nd.withPolygonFaces()
+	pts := nd.worldPoints()
+	a, b := 1, 2
+	if nd.Mat != nil && nd.Mat.Determinant3x3() < 0 {
+		a, b = 2, 1
+	}
+	for gi, g := range nd.FaceGroup {
+		for fi, f := range g.Faces {
+			if err := checkIndices("vertex", f.Vertex[:], len(pts)); err != nil {
+				return nil, err
+			}
+			tris = append(tris, bvhTriangle{v: [3]dvec3.T{pts[f.Vertex[0]], pts[f.Vertex[a]], pts[f.Vertex[b]]}, node: node, group: gi, face: fi, instance: -1})
+		}
+	}
+	return tris, nil
+}
+
+func meshTriangles(ms *Mesh) ([]bvhTriangle, error) {
+	var tris []bvhTriangle
+	var err error
+	for i, nd := range ms.Nodes {
+		if tris, err = nodeTriangles(nd, i, tris); err != nil {
+			return nil, err
+		}
+	}
+	for ii, inst := range ms.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		var local []bvhTriangle
+		for i, nd := range inst.Mesh.Nodes {
+			if local, err = nodeTriangles(nd, i, local); err != nil {
+				return nil, err
+			}
+		}
+		for j, mt := range inst.Transfors {
+			mirrored := mt.Determinant3x3() < 0
+			for _, t := range local {
+				for k := range t.v {
+					mt.TransformVec3(&t.v[k])
+				}
+				if mirrored {
+					t.v[1], t.v[2] = t.v[2], t.v[1]
+				}
+				t.instance, t.transform = ii, j
+				tris = append(tris, t)
+			}
+		}
+	}
+	return tris, nil
+}
+
+func (m *Mesh) triangleFeature(t *bvhTriangle) (uint64, bool) {
+	nds := m.Nodes
+	if t.instance >= 0 {
+		inst := m.InstanceNode[t.instance]
+		if t.transform < len(inst.Features) {
+			return inst.Features[t.transform], true
+		}
+		nds = inst.Mesh.Nodes
+	}
+	return nds[t.node].withPolygonFaces().FaceGroup[t.group].FaceFeature(t.face)
+}
+
+func newBVH(tris []bvhTriangle) *bvh {
+	b := &bvh{tris: tris}
+	if len(tris) > 0 {
+		b.build(0, len(tris))
+	}
+	return b
+}
+
+func (b *bvh) build(start, end int) int {
+	idx := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{left: -1, right: -1})
+	bx := b.tris[start].box()
+	cb := dvec3.Box{Min: b.tris[start].centroid(), Max: b.tris[start].centroid()}
+	for i := start + 1; i < end; i++ {
+		tb := b.tris[i].box()
+		bx.Join(&tb)
+		c := b.tris[i].centroid()
+		cb.Extend(&c)
+	}
+	b.nodes[idx].box = bx
+	if end-start <= bvhLeafSize {
+		b.nodes[idx].start, b.nodes[idx].count = start, end-start
+		return idx
+	}
+	axis, ext := 0, cb.Diagonal()
+	if ext[1] > ext[axis] {
+		axis = 1
+	}
+	if ext[2] > ext[axis] {
+		axis = 2
+	}
+	part := b.tris[start:end]
+	sort.Slice(part, func(i, j int) bool {
+		return part[i].centroid()[axis] < part[j].centroid()[axis]
+	})
+	mid := (start + end) / 2
+	left := b.build(start, mid)
+	right := b.build(mid, end)
+	b.nodes[idx].left, b.nodes[idx].right = left, right
+	return idx
+}
+
+func (b *bvh) bounds() dvec3.Box {
+	if len(b.nodes) == 0 {
+		return dvec3.Box{}
+	}
+	return b.nodes[0].box
+}
+
+func rayBox(bx *dvec3.Box, org, inv *dvec3.T, maxDist float64) bool {
+	tmin, tmax := 0.0, maxDist
+	for c := 0; c < 3; c++ {
+		t0 := (bx.Min[c] - org[c]) * inv[c]
+		t1 := (bx.Max[c] - org[c]) * inv[c]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		if t0 > tmin {
+			tmin = t0
+		}
+		if t1 < tmax {
+			tmax = t1
+		}
+		if tmin > tmax {
+			return false
+		}
+	}
+	return true
+}
+
+func rayTriangle(t *bvhTriangle, org, dir *dvec3.T) (float64, bool) {
+	const eps = 1e-12
+	e1 := dvec3.Sub(&t.v[1], &t.v[0])
+	e2 := dvec3.Sub(&t.v[2], &t.v[0])
+	p := dvec3.Cross(dir, &e2)
+	det := dvec3.Dot(&e1, &p)
+	if math.Abs(det) < eps {
+		return 0, false
+	}
+	s := dvec3.Sub(org, &t.v[0])
+	u := dvec3.Dot(&s, &p) / det
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+	q := dvec3.Cross(&s, &e1)
+	v := dvec3.Dot(dir, &q) / det
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+	d := dvec3.Dot(&e2, &q) / det
+	return d, d > 0
+}
+
+func (b *bvh) raycast(org, dir dvec3.T, maxDist float64, any bool) (float64, int) {
+	if len(b.nodes) == 0 {
+		return 0, -1
+	}
+	inv := dvec3.T{1 / dir[0], 1 / dir[1], 1 / dir[2]}
+	hit, best := -1, maxDist
+	stack := []int{0}
+	for len(stack) > 0 {
+		n := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !rayBox(&n.box, &org, &inv, best) {
+			continue
+		}
+		if n.count == 0 {
+			stack = append(stack, n.left, n.right)
+			continue
+		}
+		for i := n.start; i < n.start+n.count; i++ {
+			if d, ok := rayTriangle(&b.tris[i], &org, &dir); ok && d < best {
+				hit, best = i, d
+				if any {
+					return best, hit
+				}
+			}
+		}
+	}
+	return best, hit
+}
@@ -0,0 +1,534 @@
+package mst
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	MESH_CACHE_SIGNATURE = "fwmc"
+	MESH_CACHE_VERSION   = uint32(19)
+	MESH_CACHE_EXT       = ".mstc"
+)
+
+const (
+	cacheFaceNormal   = 1 << 0
+	cacheFaceUv       = 1 << 1
+	cacheFaceMaterial = 1 << 2
+)
+
+var ErrCacheMiss = errors.New("mst: mesh cache miss")
+
+type cacheWriter struct {
+	wt  *bufio.Writer
+	buf []byte
+}
+
+func (w *cacheWriter) u8(v uint8) {
+	w.wt.WriteByte(v)
+}
+
+func (w *cacheWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.wt.Write(w.buf[:4])
+}
+
+func (w *cacheWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], v)
+	w.wt.Write(w.buf[:8])
+}
+
+func (w *cacheWriter) f32(v float32) {
+	w.u32(math.Float32bits(v))
+}
+
+func (w *cacheWriter) f64s(vs []float64) {
+	for _, v := range vs {
+		w.u64(math.Float64bits(v))
+	}
+}
+
+func (w *cacheWriter) mat(mt *dmat.T) {
+	for i := range mt {
+		w.f64s(mt[i][:])
+	}
+}
+
+func (w *cacheWriter) node(nd *MeshNode) {
+	w.u32(uint32(len(nd.Vertices)))
+	for _, v := range nd.Vertices {
+		w.f32(v[0])
+		w.f32(v[1])
+		w.f32(v[2])
+	}
+	w.u32(uint32(len(nd.Normals)))
+	for _, v := range nd.Normals {
+		w.f32(v[0])
+		w.f32(v[1])
+		w.f32(v[2])
+	}
+	w.u32(uint32(len(nd.Colors)))
+	for _, c := range nd.Colors {
+		w.wt.Write(c[:])
+	}
+	w.u32(uint32(len(nd.TexCoords)))
+	for _, v := range nd.TexCoords {
+		w.f32(v[0])
+		w.f32(v[1])
+	}
+	if nd.Mat != nil {
+		w.u8(1)
+		w.mat(nd.Mat)
+	} else {
+		w.u8(0)
+	}
+	w.u32(uint32(len(nd.FaceGroup)))
+	for _, g := range nd.FaceGroup {
+		w.u32(uint32(g.Batchid))
+		w.u8(g.Mode)
+		w.u32(uint32(len(g.Faces)))
+		for _, f := range g.Faces {
+			var mask uint8
+			if f.Normal != nil {
+				mask |= cacheFaceNormal
+			}
+			if f.Uv != nil {
+				mask |= cacheFaceUv
+			}
+			if f.Material != nil {
+				mask |= cacheFaceMaterial
+			}
+			w.u8(mask)
+			w.u32(f.Vertex[0])
+			w.u32(f.Vertex[1])
+			w.u32(f.Vertex[2])
+			if f.Normal != nil {
+				w.u32(f.Normal[0])
+				w.u32(f.Normal[1])
+				w.u32(f.Normal[2])
+			}
+			if f.Uv != nil {
+				w.u32(f.Uv[0])
+				w.u32(f.Uv[1])
+				w.u32(f.Uv[2])
+			}
+			if f.Material != nil {
+				w.u32(uint32(*f.Material))
+			}
+		}
+		faceFeaturesMarshal(w.wt, g)
+	}
+	w.u32(uint32(len(nd.EdgeGroup)))
+	for _, g := range nd.EdgeGroup {
+		w.u32(uint32(g.Batchid))
+		w.u32(uint32(len(g.Edges)))
+		for _, e := range g.Edges {
+			w.u32(e[0])
+			w.u32(e[1])
+		}
+		outlineStyleMarshal(w.wt, g)
+	}
+	PropertiesMarshal(w.wt, nd.Props)
+	morphTargetsMarshal(w.wt, nd.MorphTargets)
+	hierarchyMarshal(w.wt, nd)
+	texCoords2Marshal(w.wt, nd, INDEX_WIDTH_32)
+	lightmapMarshal(w.wt, nd, MESH_LATEST_VERSION)
+	polygonsMarshal(w.wt, nd, INDEX_WIDTH_32)
+	w.u8(nd.IndexingMode)
+	w.u8(nd.IndexWidth)
+	w.u8(nd.Quantization)
+}
+
+func (w *cacheWriter) baseMesh(bm *BaseMesh) {
+	mtlsMarshal(w.wt, embedResolvedMaterialRefs(bm.Materials), MESH_LATEST_VERSION)
+	w.u32(uint32(len(bm.Nodes)))
+	for _, nd := range bm.Nodes {
+		w.node(nd)
+	}
+	w.u32(bm.Code)
+}
+
+func (w *cacheWriter) instance(inst *InstanceMesh) {
+	w.u32(uint32(len(inst.Transfors)))
+	for _, mt := range inst.Transfors {
+		w.mat(mt)
+	}
+	w.u32(uint32(len(inst.Features)))
+	for _, f := range inst.Features {
+		w.u64(f)
+	}
+	if inst.BBox != nil {
+		w.u8(1)
+		w.f64s(inst.BBox[:])
+	} else {
+		w.u8(0)
+	}
+	if inst.Mesh != nil {
+		w.u8(1)
+		w.baseMesh(inst.Mesh)
+	} else {
+		w.u8(0)
+	}
+	w.u64(inst.Hash)
+	PropertiesMarshal(w.wt, inst.Props)
+	instanceRefMarshal(w.wt, inst.Ref)
+	w.u32(uint32(len(inst.Bounds)))
+	for _, bx := range inst.Bounds {
+		w.f64s(bx[:])
+	}
+}
+
+func MeshCacheMarshal(wt io.Writer, ms *Mesh) error {
+	w := &cacheWriter{wt: bufio.NewWriter(wt), buf: make([]byte, 8)}
+	w.wt.WriteString(MESH_CACHE_SIGNATURE)
+	w.u32(MESH_CACHE_VERSION)
+	w.u32(ms.Version)
+	w.baseMesh(&ms.BaseMesh)
+	w.u32(uint32(len(ms.InstanceNode)))
+	for _, inst := range ms.InstanceNode {
+		w.instance(inst)
+	}
+	PropertiesMarshal(w.wt, ms.Props)
+	w.u8(ms.Units)
+	animationsMarshal(w.wt, ms.Animations)
+	return w.wt.Flush()
+}
+
+type cacheReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *cacheReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.off {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *cacheReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *cacheReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *cacheReader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *cacheReader) count(elemSize int) int {
+	n := int(r.u32())
+	if r.err == nil && n*elemSize > len(r.data)-r.off {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
+
+func (r *cacheReader) f32s(out []float32) {
+	b := r.take(len(out) * 4)
+	if b == nil {
+		return
+	}
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+}
+
+func (r *cacheReader) f64s(out []float64) {
+	b := r.take(len(out) * 8)
+	if b == nil {
+		return
+	}
+	for i := range out {
+		out[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+	}
+}
+
+func (r *cacheReader) mat() *dmat.T {
+	mt := &dmat.T{}
+	for i := range mt {
+		r.f64s(mt[i][:])
+	}
+	return mt
+}
+
+func (r *cacheReader) decoder(fn func(d *decoder)) {
+	if r.err != nil {
+		return
+	}
+	rd := bytes.NewReader(r.data[r.off:])
+	d := newDecoder(rd, MESH_LATEST_VERSION, nil)
+	fn(d)
+	r.err = d.err
+	r.off = len(r.data) - rd.Len()
+}
+
+func (r *cacheReader) props() Properties {
+	var props Properties
+	r.decoder(func(d *decoder) { props = d.props(0) })
+	return props
+}
+
+func (r *cacheReader) triple() [3]uint32 {
+	b := r.take(12)
+	if b == nil {
+		return [3]uint32{}
+	}
+	return [3]uint32{binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:]), binary.LittleEndian.Uint32(b[8:])}
+}
+
+func (r *cacheReader) node() *MeshNode {
+	nd := &MeshNode{}
+	if n := r.count(12); n > 0 {
+		nd.Vertices = make([]vec3.T, n)
+		for i := range nd.Vertices {
+			r.f32s(nd.Vertices[i][:])
+		}
+	}
+	if n := r.count(12); n > 0 {
+		nd.Normals = make([]vec3.T, n)
+		for i := range nd.Normals {
+			r.f32s(nd.Normals[i][:])
+		}
+	}
+	if n := r.count(3); n > 0 {
+		b := r.take(n * 3)
+		nd.Colors = make([][3]byte, n)
+		for i := range nd.Colors {
+			copy(nd.Colors[i][:], b[i*3:])
+		}
+	}
+	if n := r.count(8); n > 0 {
+		nd.TexCoords = make([]vec2.T, n)
+		for i := range nd.TexCoords {
+			r.f32s(nd.TexCoords[i][:])
+		}
+	}
+	if r.u8() == 1 {
+		nd.Mat = r.mat()
+	}
+	nd.FaceGroup = make([]*MeshTriangle, r.count(8))
+	for i := range nd.FaceGroup {
+		g := &MeshTriangle{Batchid: int32(r.u32()), Mode: r.u8()}
+		n := r.count(13)
+		faces := make([]Face, n)
+		extra := make([][3]uint32, 0, n)
+		mtls := make([]int32, 0, n)
+		g.Faces = make([]*Face, n)
+		for j := 0; j < n && r.err == nil; j++ {
+			f := &faces[j]
+			mask := r.u8()
+			f.Vertex = r.triple()
+			if mask&cacheFaceNormal != 0 {
+				extra = append(extra, r.triple())
+				f.Normal = &extra[len(extra)-1]
+			}
+			if mask&cacheFaceUv != 0 {
+				extra = append(extra, r.triple())
+				f.Uv = &extra[len(extra)-1]
+			}
+			if mask&cacheFaceMaterial != 0 {
+				mtls = append(mtls, int32(r.u32()))
+				f.Material = &mtls[len(mtls)-1]
+			}
+			g.Faces[j] = f
+		}
+		r.decoder(func(d *decoder) { d.faceFeatures(g) })
+		nd.FaceGroup[i] = g
+	}
+	nd.EdgeGroup = make([]*MeshOutline, r.count(8))
+	for i := range nd.EdgeGroup {
+		g := &MeshOutline{Batchid: int32(r.u32())}
+		g.Edges = make([][2]uint32, r.count(8))
+		for j := range g.Edges {
+			g.Edges[j] = [2]uint32{r.u32(), r.u32()}
+		}
+		r.decoder(func(d *decoder) { d.outlineStyle(g) })
+		nd.EdgeGroup[i] = g
+	}
+	nd.Props = r.props()
+	r.decoder(func(d *decoder) {
+		nd.MorphTargets = d.morphTargets()
+		d.hierarchy(nd)
+		d.texCoords2(nd, INDEX_WIDTH_32)
+		d.lightmap(nd)
+		d.polygons(nd, INDEX_WIDTH_32)
+	})
+	nd.IndexingMode = r.u8()
+	nd.IndexWidth = r.u8()
+	nd.Quantization = r.u8()
+	return nd
+}
+
+func (r *cacheReader) baseMesh() *BaseMesh {
+	bm := &BaseMesh{}
+	r.decoder(func(d *decoder) { bm.Materials = d.materials() })
+	bm.Nodes = make([]*MeshNode, r.count(1))
+	for i := range bm.Nodes {
+		bm.Nodes[i] = r.node()
+	}
+	bm.Code = r.u32()
+	return bm
+}
+
+func (r *cacheReader) instance() *InstanceMesh {
+	inst := &InstanceMesh{}
+	inst.Transfors = make([]*dmat.T, r.count(128))
+	for i := range inst.Transfors {
+		inst.Transfors[i] = r.mat()
+	}
+	if n := r.count(8); n > 0 {
+		inst.Features = make([]uint64, n)
+		for i := range inst.Features {
+			inst.Features[i] = r.u64()
+		}
+	}
+	if r.u8() == 1 {
+		inst.BBox = &[6]float64{}
+		r.f64s(inst.BBox[:])
+	}
+	if r.u8() == 1 {
+		inst.Mesh = r.baseMesh()
+	}
+	inst.Hash = r.u64()
+	inst.Props = r.props()
+	r.decoder(func(d *decoder) { inst.Ref = d.instanceRef() })
+	if n := r.count(48); n > 0 {
+		inst.Bounds = make([][6]float64, n)
+		for i := range inst.Bounds {
+			r.f64s(inst.Bounds[i][:])
+		}
+	}
+	return inst
+}
+
+func MeshCacheUnMarshal(rd io.Reader) (*Mesh, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	r := &cacheReader{data: data}
+	head := r.take(8)
+	if head == nil || string(head[:4]) != MESH_CACHE_SIGNATURE || binary.LittleEndian.Uint32(head[4:]) != MESH_CACHE_VERSION {
+		return nil, ErrCacheMiss
+	}
+	ms := &Mesh{Version: r.u32()}
+	ms.BaseMesh = *r.baseMesh()
+	ms.InstanceNode = make([]*InstanceMesh, r.count(1))
+	for i := range ms.InstanceNode {
+		ms.InstanceNode[i] = r.instance()
+	}
+	ms.Props = r.props()
+	ms.Units = r.u8()
+	r.decoder(func(d *decoder) { ms.Animations = d.animations() })
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ms, nil
+}
+
+type MeshCache struct {
+	Dir string
+}
+
+func NewMeshCache(dir string) (*MeshCache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &MeshCache{Dir: dir}, nil
+}
+
+func SourceHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *MeshCache) entryPath(key string) string {
+	return filepath.Join(c.Dir, key+MESH_CACHE_EXT)
+}
+
+func (c *MeshCache) Get(key string) (*Mesh, error) {
+	f, err := os.Open(c.entryPath(key))
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return MeshCacheUnMarshal(f)
+}
+
+func (c *MeshCache) Put(key string, ms *Mesh) error {
+	tmp, err := ioutil.TempFile(c.Dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := MeshCacheMarshal(tmp, ms); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.entryPath(key))
+}
+
+func (c *MeshCache) Load(path string) (*Mesh, error) {
+	key, err := SourceHash(path)
+	if err != nil {
+		return nil, err
+	}
+	if ms, err := c.Get(key); err == nil {
+		return ms, nil
+	}
+	ms, err := MeshReadFrom(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Put(key, ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
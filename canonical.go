@@ -0,0 +1,142 @@
+package mst
+
+import (
+	"sort"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+func sortedPropKeys(props Properties) []string {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func WithCanonical() WriteOption {
+	return func(o *writeOptions) {
+		o.canonical = true
+	}
+}
+
+func canonicalProps(props Properties) Properties {
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+func canonicalVec3s(vs []vec3.T) []vec3.T {
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs
+}
+
+func canonicalNode(nd *MeshNode) *MeshNode {
+	cp := *nd
+	cp.Vertices = canonicalVec3s(nd.Vertices)
+	cp.Normals = canonicalVec3s(nd.Normals)
+	if len(nd.Colors) == 0 {
+		cp.Colors = nil
+	}
+	if len(nd.TexCoords) == 0 {
+		cp.TexCoords = nil
+	}
+	if len(nd.TexCoords2) == 0 {
+		cp.TexCoords2 = nil
+	}
+	cp.FaceGroup = nil
+	for _, g := range nd.FaceGroup {
+		cg := *g
+		if len(g.Faces) == 0 {
+			cg.Faces = nil
+		}
+		if len(g.Features) == 0 {
+			cg.Features = nil
+		}
+		cp.FaceGroup = append(cp.FaceGroup, &cg)
+	}
+	cp.PolygonGroup = nil
+	for _, g := range nd.PolygonGroup {
+		cp.PolygonGroup = append(cp.PolygonGroup, canonicalPolygons(g))
+	}
+	cp.EdgeGroup = nil
+	for _, g := range nd.EdgeGroup {
+		cg := *g
+		if len(g.Edges) == 0 {
+			cg.Edges = nil
+		}
+		if len(g.Dash) == 0 {
+			cg.Dash = nil
+		}
+		cp.EdgeGroup = append(cp.EdgeGroup, &cg)
+	}
+	cp.MorphTargets = nil
+	for _, t := range nd.MorphTargets {
+		ct := *t
+		ct.Positions = canonicalVec3s(t.Positions)
+		ct.Normals = canonicalVec3s(t.Normals)
+		cp.MorphTargets = append(cp.MorphTargets, &ct)
+	}
+	if len(nd.Children) == 0 {
+		cp.Children = nil
+	}
+	cp.Props = canonicalProps(nd.Props)
+	return &cp
+}
+
+func canonicalBaseMesh(ms *BaseMesh) *BaseMesh {
+	cp := *ms
+	if len(ms.Materials) == 0 {
+		cp.Materials = nil
+	}
+	cp.Nodes = nil
+	for _, nd := range ms.Nodes {
+		cp.Nodes = append(cp.Nodes, canonicalNode(nd))
+	}
+	return &cp
+}
+
+func CanonicalMesh(ms *Mesh) *Mesh {
+	out := &Mesh{BaseMesh: *canonicalBaseMesh(&ms.BaseMesh), Version: ms.Version, Props: canonicalProps(ms.Props)}
+	for _, inst := range ms.InstanceNode {
+		cp := *inst
+		if len(inst.Transfors) == 0 {
+			cp.Transfors = nil
+		}
+		if len(inst.Features) == 0 {
+			cp.Features = nil
+		}
+		if len(inst.Bounds) == 0 {
+			cp.Bounds = nil
+		}
+		if inst.Mesh != nil {
+			cp.Mesh = canonicalBaseMesh(inst.Mesh)
+		}
+		cp.Props = canonicalProps(inst.Props)
+		out.InstanceNode = append(out.InstanceNode, &cp)
+	}
+	for _, a := range ms.Animations {
+		ca := *a
+		if len(a.Tracks) == 0 {
+			ca.Tracks = nil
+		}
+		out.Animations = append(out.Animations, &ca)
+	}
+	return out
+}
+
+func canonicalPolygons(g *MeshPolygon) *MeshPolygon {
+	cg := &MeshPolygon{Batchid: g.Batchid}
+	for _, p := range g.Polygons {
+		cp := &Polygon{Outer: p.Outer}
+		if len(p.Holes) > 0 {
+			cp.Holes = p.Holes
+		}
+		cg.Polygons = append(cg.Polygons, cp)
+	}
+	return cg
+}
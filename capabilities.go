@@ -0,0 +1,114 @@
+package mst
+
+type Capabilities struct {
+	Version           uint32
+	PbrPadding        bool
+	Features64        bool
+	Code              bool
+	Props             bool
+	HeaderFlags       bool
+	Checksums         bool
+	SectionTable      bool
+	InstanceRefs      bool
+	NodeProps         bool
+	IndexWidth        bool
+	Quantization      bool
+	Compression       bool
+	OutlineStyles     bool
+	Animations        bool
+	MorphTargets      bool
+	Hierarchy         bool
+	MaterialNames     bool
+	InstanceBounds    bool
+	TexCoords2        bool
+	Lightmaps         bool
+	FaceIndices       bool
+	TextureURIs       bool
+	MaterialRefs      bool
+	CompactAttributes bool
+	Units             bool
+	ColorSpaces       bool
+	FaceFeatures      bool
+	MaterialLengths   bool
+	PrimitiveModes    bool
+	Polygons          bool
+	KnownFlags        uint32
+	LatestFormat      bool
+}
+
+// formatFeature ties a capability to the version introducing it and, when
+// the encoder must upgrade the version for it, to the test for its use.
+type formatFeature struct {
+	since uint32
+	flag  func(c *Capabilities) *bool
+	used  func(ms *Mesh) bool
+}
+
+var formatFeatures = []formatFeature{
+	{V3, func(c *Capabilities) *bool { return &c.Features64 }, nil},
+	{V4, func(c *Capabilities) *bool { return &c.Code }, nil},
+	{V5, func(c *Capabilities) *bool { return &c.Props }, nil},
+	{V6, func(c *Capabilities) *bool { return &c.HeaderFlags }, nil},
+	{V6, func(c *Capabilities) *bool { return &c.Checksums }, nil},
+	{V6, func(c *Capabilities) *bool { return &c.SectionTable }, nil},
+	{V6, func(c *Capabilities) *bool { return &c.Compression }, nil},
+	{V7, func(c *Capabilities) *bool { return &c.InstanceRefs }, hasUnresolvedInstanceRefs},
+	{V8, func(c *Capabilities) *bool { return &c.NodeProps }, func(ms *Mesh) bool {
+		return anyNode(ms, func(nd *MeshNode) bool { return len(nd.Props) > 0 })
+	}},
+	{V9, func(c *Capabilities) *bool { return &c.IndexWidth }, nil},
+	{V10, func(c *Capabilities) *bool { return &c.Quantization }, func(ms *Mesh) bool {
+		return anyNode(ms, func(nd *MeshNode) bool { return nd.Quantization != 0 })
+	}},
+	{V11, func(c *Capabilities) *bool { return &c.OutlineStyles }, hasOutlineStyles},
+	{V12, func(c *Capabilities) *bool { return &c.Animations }, func(ms *Mesh) bool { return len(ms.Animations) > 0 }},
+	{V13, func(c *Capabilities) *bool { return &c.MorphTargets }, hasMorphTargets},
+	{V14, func(c *Capabilities) *bool { return &c.Hierarchy }, func(ms *Mesh) bool { return anyNode(ms, inHierarchy) }},
+	{V15, func(c *Capabilities) *bool { return &c.MaterialNames }, hasMaterialNames},
+	{V16, func(c *Capabilities) *bool { return &c.InstanceBounds }, hasInstanceBounds},
+	{V17, func(c *Capabilities) *bool { return &c.TexCoords2 }, hasTexCoords2},
+	{V18, func(c *Capabilities) *bool { return &c.Lightmaps }, hasLightmaps},
+	{V19, func(c *Capabilities) *bool { return &c.FaceIndices }, hasFaceIndices},
+	{V20, func(c *Capabilities) *bool { return &c.TextureURIs }, hasTextureURIs},
+	{V21, func(c *Capabilities) *bool { return &c.MaterialRefs }, func(ms *Mesh) bool { return hasMaterialRefs(ms, false) }},
+	{V22, func(c *Capabilities) *bool { return &c.CompactAttributes }, func(ms *Mesh) bool { return anyNode(ms, compactAttributes) }},
+	{V23, func(c *Capabilities) *bool { return &c.Units }, func(ms *Mesh) bool { return ms.Units != UNITS_UNKNOWN }},
+	{V24, func(c *Capabilities) *bool { return &c.ColorSpaces }, hasTextureColorSpaces},
+	{V25, func(c *Capabilities) *bool { return &c.FaceFeatures }, hasFaceFeatures},
+	{V26, func(c *Capabilities) *bool { return &c.MaterialLengths }, hasUnknownMaterials},
+	{V27, func(c *Capabilities) *bool { return &c.PrimitiveModes }, hasPrimitiveModes},
+	{V28, func(c *Capabilities) *bool { return &c.Polygons }, hasPolygons},
+}
+
+func FormatCapabilities(v uint32) Capabilities {
+	caps := Capabilities{Version: v}
+	caps.PbrPadding = v < V2
+	for _, f := range formatFeatures {
+		*f.flag(&caps) = v >= f.since
+	}
+	if caps.Checksums {
+		caps.KnownFlags |= MESH_FLAG_CHECKSUM | MESH_FLAG_SECTION_TABLE | MESH_FLAG_MANIFEST | MESH_FLAG_COMPRESSED
+	}
+	caps.LatestFormat = v == MESH_LATEST_VERSION
+	return caps
+}
+
+// requiredVersion returns v, or the version of the newest feature used by ms
+// that v cannot store.
+func requiredVersion(ms *Mesh, v uint32) uint32 {
+	caps := FormatCapabilities(v)
+	for i := len(formatFeatures) - 1; i >= 0; i-- {
+		if f := formatFeatures[i]; f.used != nil && !*f.flag(&caps) && f.used(ms) {
+			return f.since
+		}
+	}
+	return v
+}
+
+func IsSupportedVersion(v uint32) bool {
+	return v >= V1 && v <= MESH_LATEST_VERSION
+}
+
+func (d *decoder) caps() Capabilities {
+	return FormatCapabilities(d.v)
+}
@@ -0,0 +1,231 @@
+package mst
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+type CleanupOptions struct {
+	AreaEpsilon  float64
+	MaxHoleEdges int
+}
+
+var DefaultCleanupOptions = CleanupOptions{
+	AreaEpsilon: 1e-12,
+}
+
+type CleanupReport struct {
+	DegenerateFaces int
+	DuplicateFaces  int
+	UnusedVertices  int
+	FilledHoles     int
+}
+
+func faceArea(a, b, c *vec3.T) float64 {
+	e1, e2 := vec3.Sub(b, a), vec3.Sub(c, a)
+	n := vec3.Cross(&e1, &e2)
+	return float64(n.Length()) / 2
+}
+
+func (n *MeshNode) Cleanup(opts CleanupOptions) (CleanupReport, error) {
+	var rep CleanupReport
+	if opts.AreaEpsilon < 0 || opts.MaxHoleEdges < 0 {
+		return rep, errors.New("mst: cleanup options must not be negative")
+	}
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if err := n.validateFace(f); err != nil {
+				return rep, err
+			}
+		}
+	}
+	if err := n.validateVertexIndices(); err != nil {
+		return rep, err
+	}
+	seen := make(map[[3]uint32]bool)
+	groups := n.FaceGroup[:0]
+	for _, g := range n.FaceGroup {
+		faces := g.Faces[:0]
+		for _, f := range g.Faces {
+			if n.isDegenerateFace(f, opts.AreaEpsilon) {
+				rep.DegenerateFaces++
+				continue
+			}
+			key := f.Vertex
+			sort.Slice(key[:], func(i, j int) bool { return key[i] < key[j] })
+			if seen[key] {
+				rep.DuplicateFaces++
+				continue
+			}
+			seen[key] = true
+			faces = append(faces, f)
+		}
+		if g.Faces = faces; len(faces) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	n.FaceGroup = groups
+	if opts.MaxHoleEdges >= 3 {
+		var err error
+		if rep.FilledHoles, err = n.fillHoles(opts.MaxHoleEdges); err != nil {
+			return rep, err
+		}
+	}
+	rep.UnusedVertices = n.removeUnusedVertices()
+	return rep, nil
+}
+
+type boundaryEdge struct {
+	to      uint32
+	batchid int32
+}
+
+func (n *MeshNode) fillHoles(maxEdges int) (int, error) {
+	count := make(map[[2]uint32]int)
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for k := 0; k < 3; k++ {
+				count[[2]uint32{f.Vertex[k], f.Vertex[(k+1)%3]}]++
+			}
+		}
+	}
+	next := make(map[uint32][]boundaryEdge)
+	var starts []uint32
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for k := 0; k < 3; k++ {
+				a, b := f.Vertex[k], f.Vertex[(k+1)%3]
+				if count[[2]uint32{b, a}] == 0 && count[[2]uint32{a, b}] == 1 {
+					if len(next[a]) == 0 {
+						starts = append(starts, a)
+					}
+					next[a] = append(next[a], boundaryEdge{to: b, batchid: g.Batchid})
+				}
+			}
+		}
+	}
+	filled := 0
+	for _, s := range starts {
+		if len(next[s]) != 1 {
+			continue
+		}
+		loop := []uint32{s}
+		batchid := next[s][0].batchid
+		closed := false
+		for cur := s; len(loop) <= maxEdges; {
+			edges := next[cur]
+			if len(edges) != 1 {
+				break
+			}
+			if cur = edges[0].to; cur == s {
+				closed = true
+				break
+			}
+			loop = append(loop, cur)
+		}
+		if !closed || len(loop) < 3 || len(loop) >= maxEdges {
+			continue
+		}
+		faces := make([]*Face, 0, len(loop)-2)
+		for i := 1; i+1 < len(loop); i++ {
+			faces = append(faces, &Face{Vertex: [3]uint32{loop[0], loop[i+1], loop[i]}})
+		}
+		for _, v := range loop {
+			next[v] = nil
+		}
+		if err := n.AddFaces(batchid, faces); err != nil {
+			return filled, err
+		}
+		filled++
+	}
+	return filled, nil
+}
+
+func (n *MeshNode) removeUnusedVertices() int {
+	used := make([]bool, len(n.Vertices))
+	sharedNormals, sharedUvs := true, true
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for _, v := range f.Vertex {
+				used[v] = true
+			}
+			sharedNormals = sharedNormals && f.Normal == nil
+			sharedUvs = sharedUvs && f.Uv == nil
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		for _, e := range g.Edges {
+			used[e[0]], used[e[1]] = true, true
+		}
+	}
+	for _, g := range n.PolygonGroup {
+		for _, p := range g.Polygons {
+			for _, ring := range p.rings() {
+				for _, v := range ring {
+					used[v] = true
+				}
+			}
+		}
+	}
+	remap := make([]uint32, len(n.Vertices))
+	kept := 0
+	for i, u := range used {
+		if u {
+			remap[i] = uint32(kept)
+			kept++
+		}
+	}
+	removed := len(n.Vertices) - kept
+	if removed == 0 {
+		return 0
+	}
+	nv := len(n.Vertices)
+	compact := func(l int, move func(dst, src int)) bool {
+		if l != nv {
+			return false
+		}
+		for i, u := range used {
+			if u {
+				move(int(remap[i]), i)
+			}
+		}
+		return true
+	}
+	compact(nv, func(d, s int) { n.Vertices[d] = n.Vertices[s] })
+	n.Vertices = n.Vertices[:kept]
+	normals := sharedNormals && compact(len(n.Normals), func(d, s int) { n.Normals[d] = n.Normals[s] })
+	if normals {
+		n.Normals = n.Normals[:kept]
+	}
+	if sharedUvs && compact(len(n.TexCoords), func(d, s int) { n.TexCoords[d] = n.TexCoords[s] }) {
+		n.TexCoords = n.TexCoords[:kept]
+	}
+	if compact(len(n.TexCoords2), func(d, s int) { n.TexCoords2[d] = n.TexCoords2[s] }) {
+		n.TexCoords2 = n.TexCoords2[:kept]
+	}
+	if compact(len(n.Colors), func(d, s int) { n.Colors[d] = n.Colors[s] }) {
+		n.Colors = n.Colors[:kept]
+	}
+	for _, t := range n.MorphTargets {
+		if compact(len(t.Positions), func(d, s int) { t.Positions[d] = t.Positions[s] }) {
+			t.Positions = t.Positions[:kept]
+		}
+		if normals && compact(len(t.Normals), func(d, s int) { t.Normals[d] = t.Normals[s] }) {
+			t.Normals = t.Normals[:kept]
+		}
+	}
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			f.Vertex = [3]uint32{remap[f.Vertex[0]], remap[f.Vertex[1]], remap[f.Vertex[2]]}
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		for i, e := range g.Edges {
+			g.Edges[i] = [2]uint32{remap[e[0]], remap[e[1]]}
+		}
+	}
+	n.remapPolygonVertices(func(v uint32) uint32 { return remap[v] })
+	return removed
+}
@@ -0,0 +1,266 @@
+package mst
+
+import (
+	dmat "github.com/flywave/go3d/float64/mat4"
+)
+
+type cloneOptions struct {
+	shareTextures bool
+}
+
+type CloneOption func(*cloneOptions)
+
+func WithSharedTextures() CloneOption {
+	return func(o *cloneOptions) {
+		o.shareTextures = true
+	}
+}
+
+type cloner struct {
+	cloneOptions
+	textures map[*Texture]*Texture
+	meshes   map[*BaseMesh]*BaseMesh
+}
+
+func newCloner(opts []CloneOption) *cloner {
+	c := &cloner{textures: make(map[*Texture]*Texture), meshes: make(map[*BaseMesh]*BaseMesh)}
+	for _, opt := range opts {
+		opt(&c.cloneOptions)
+	}
+	return c
+}
+
+func (c *cloner) texture(t *Texture) *Texture {
+	if t == nil || c.shareTextures {
+		return t
+	}
+	if cp, ok := c.textures[t]; ok {
+		return cp
+	}
+	cp := *t
+	cp.Data = append(t.Data[:0:0], t.Data...)
+	c.textures[t] = &cp
+	return &cp
+}
+
+func (c *cloner) textureMaterial(m *TextureMaterial) {
+	m.Texture = c.texture(m.Texture)
+	m.Normal = c.texture(m.Normal)
+}
+
+func (c *cloner) material(mtl MeshMaterial) MeshMaterial {
+	switch ml := mtl.(type) {
+	case *BaseMaterial:
+		cp := *ml
+		return &cp
+	case *TextureMaterial:
+		cp := *ml
+		c.textureMaterial(&cp)
+		return &cp
+	case *PbrMaterial:
+		cp := *ml
+		c.textureMaterial(&cp.TextureMaterial)
+		return &cp
+	case *LambertMaterial:
+		cp := *ml
+		c.textureMaterial(&cp.TextureMaterial)
+		return &cp
+	case *PhongMaterial:
+		cp := *ml
+		c.textureMaterial(&cp.TextureMaterial)
+		return &cp
+	case *UnknownMaterial:
+		cp := *ml
+		cp.Data = append(ml.Data[:0:0], ml.Data...)
+		return &cp
+	case *TemplateMaterial:
+		cp := *ml
+		if ml.Color != nil {
+			cl := *ml.Color
+			cp.Color = &cl
+		}
+		if ml.Transparency != nil {
+			tr := *ml.Transparency
+			cp.Transparency = &tr
+		}
+		if ml.Emissive != nil {
+			em := *ml.Emissive
+			cp.Emissive = &em
+		}
+		cp.Texture = c.texture(ml.Texture)
+		return &cp
+	case *MaterialRef:
+		cp := *ml
+		return &cp
+	}
+	return mtl
+}
+
+func (c *cloner) materials(mtls []MeshMaterial) []MeshMaterial {
+	if mtls == nil {
+		return nil
+	}
+	out := make([]MeshMaterial, len(mtls))
+	for i, mtl := range mtls {
+		out[i] = c.material(mtl)
+	}
+	return out
+}
+
+func (c *cloner) node(n *MeshNode) *MeshNode {
+	if n == nil {
+		return nil
+	}
+	out := *n
+	out.Vertices = append(n.Vertices[:0:0], n.Vertices...)
+	out.Normals = append(n.Normals[:0:0], n.Normals...)
+	out.Colors = append(n.Colors[:0:0], n.Colors...)
+	out.TexCoords = append(n.TexCoords[:0:0], n.TexCoords...)
+	out.TexCoords2 = append(n.TexCoords2[:0:0], n.TexCoords2...)
+	out.Lightmap = c.texture(n.Lightmap)
+	if n.Mat != nil {
+		mt := *n.Mat
+		out.Mat = &mt
+	}
+	identity := func(b int32) int32 { return b }
+	if n.FaceGroup != nil {
+		out.FaceGroup = cloneFaceGroups(n.FaceGroup, identity)
+	}
+	if n.EdgeGroup != nil {
+		out.EdgeGroup = cloneEdgeGroups(n.EdgeGroup, identity)
+	}
+	out.PolygonGroup = clonePolygonGroups(n.PolygonGroup, identity)
+	out.Props = n.Props.Clone()
+	if n.MorphTargets != nil {
+		out.MorphTargets = make([]*MorphTarget, len(n.MorphTargets))
+		for i, t := range n.MorphTargets {
+			cp := *t
+			cp.Positions = append(t.Positions[:0:0], t.Positions...)
+			cp.Normals = append(t.Normals[:0:0], t.Normals...)
+			out.MorphTargets[i] = &cp
+		}
+	}
+	out.Children = append(n.Children[:0:0], n.Children...)
+	return &out
+}
+
+func (c *cloner) baseMesh(m *BaseMesh) *BaseMesh {
+	if m == nil {
+		return nil
+	}
+	if cp, ok := c.meshes[m]; ok {
+		return cp
+	}
+	out := &BaseMesh{Materials: c.materials(m.Materials), Code: m.Code}
+	if m.Nodes != nil {
+		out.Nodes = make([]*MeshNode, len(m.Nodes))
+		for i, nd := range m.Nodes {
+			out.Nodes[i] = c.node(nd)
+		}
+	}
+	c.meshes[m] = out
+	return out
+}
+
+func (c *cloner) instance(inst *InstanceMesh) *InstanceMesh {
+	out := *inst
+	if inst.Transfors != nil {
+		out.Transfors = make([]*dmat.T, len(inst.Transfors))
+		for i, mt := range inst.Transfors {
+			if mt != nil {
+				cp := *mt
+				out.Transfors[i] = &cp
+			}
+		}
+	}
+	out.Features = append(inst.Features[:0:0], inst.Features...)
+	if inst.BBox != nil {
+		bx := *inst.BBox
+		out.BBox = &bx
+	}
+	out.Mesh = c.baseMesh(inst.Mesh)
+	out.Props = inst.Props.Clone()
+	if inst.Ref != nil {
+		ref := *inst.Ref
+		out.Ref = &ref
+	}
+	out.Bounds = append(inst.Bounds[:0:0], inst.Bounds...)
+	return &out
+}
+
+func (c *cloner) mesh(m *Mesh) *Mesh {
+	out := *m
+	out.BaseMesh = *c.baseMesh(&m.BaseMesh)
+	if m.InstanceNode != nil {
+		out.InstanceNode = make([]*InstanceMesh, len(m.InstanceNode))
+		for i, inst := range m.InstanceNode {
+			out.InstanceNode[i] = c.instance(inst)
+		}
+	}
+	out.Props = m.Props.Clone()
+	if m.Animations != nil {
+		out.Animations = make([]*Animation, len(m.Animations))
+		for i, a := range m.Animations {
+			cp := &Animation{Name: a.Name, Tracks: make([]*AnimationTrack, len(a.Tracks))}
+			for j, t := range a.Tracks {
+				ct := *t
+				ct.Times = append(t.Times[:0:0], t.Times...)
+				ct.Values = append(t.Values[:0:0], t.Values...)
+				cp.Tracks[j] = &ct
+			}
+			out.Animations[i] = cp
+		}
+	}
+	return &out
+}
+
+func (t *Texture) Clone() *Texture {
+	return newCloner(nil).texture(t)
+}
+
+func CloneMaterial(mtl MeshMaterial, opts ...CloneOption) MeshMaterial {
+	return newCloner(opts).material(mtl)
+}
+
+func (m *BaseMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *TextureMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *PbrMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *LambertMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *PhongMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *TemplateMaterial) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (m *MaterialRef) Clone(opts ...CloneOption) MeshMaterial {
+	return CloneMaterial(m, opts...)
+}
+
+func (n *MeshNode) Clone(opts ...CloneOption) *MeshNode {
+	return newCloner(opts).node(n)
+}
+
+func (m *BaseMesh) Clone(opts ...CloneOption) *BaseMesh {
+	return newCloner(opts).baseMesh(m)
+}
+
+func (m *Mesh) Clone(opts ...CloneOption) *Mesh {
+	if m == nil {
+		return nil
+	}
+	return newCloner(opts).mesh(m)
+}
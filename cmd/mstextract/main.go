@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	mst "github.com/flywave/go-mst"
+)
+
+func main() {
+	in := flag.String("in", "", "input .mst file")
+	node := flag.String("node", "0", "node selector (index or name)")
+	out := flag.String("out", "", "output .mst file")
+	flag.Parse()
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ms, err := mst.ExtractNode(*in, *node)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := mst.MeshWriteTo(*out, ms); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
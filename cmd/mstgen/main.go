@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	mst "github.com/flywave/go-mst"
+)
+
+func main() {
+	opts := mst.DefaultGenerateOptions
+	out := flag.String("out", "", "output .mst file")
+	count := flag.Int("count", 1, "number of files to generate (seed is incremented per file)")
+	checksum := flag.Bool("checksum", false, "write a checksum footer")
+	flag.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed")
+	flag.IntVar(&opts.Nodes, "nodes", opts.Nodes, "number of nodes")
+	flag.IntVar(&opts.Subdivisions, "subdivisions", opts.Subdivisions, "grid subdivisions per node")
+	flag.IntVar(&opts.Materials, "materials", opts.Materials, "number of materials")
+	flag.IntVar(&opts.Textures, "textures", opts.Textures, "number of textured materials")
+	flag.IntVar(&opts.TextureSize, "texture-size", opts.TextureSize, "texture width and height")
+	flag.IntVar(&opts.Instances, "instances", opts.Instances, "number of instance meshes")
+	flag.IntVar(&opts.Transforms, "transforms", opts.Transforms, "transforms per instance mesh")
+	flag.IntVar(&opts.Props, "props", opts.Props, "properties per mesh and instance")
+	flag.Parse()
+	if *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var wopts []mst.WriteOption
+	if *checksum {
+		wopts = append(wopts, mst.WithChecksum())
+	}
+	for i := 0; i < *count; i++ {
+		path := *out
+		if *count > 1 {
+			path = fmt.Sprintf("%s_%d%s", *out, i, mst.MSTEXT)
+		}
+		ms := mst.GenerateMesh(&opts)
+		if err := mst.MeshWriteTo(path, ms, wopts...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.Seed++
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/flywave/go-mst/serve"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	maxUpload := flag.Int64("max-upload", serve.DEFAULT_MAX_UPLOAD_SIZE, "maximum upload size in bytes")
+	flag.Parse()
+	srv := serve.NewServer(&serve.Options{MaxUploadSize: *maxUpload})
+	if err := http.ListenAndServe(*addr, srv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,291 @@
+package mst
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	COLLISION_METHOD_DECIMATE = 0
+	COLLISION_METHOD_VOXEL    = 1
+	COLLISION_METHOD_CONVEX   = 2
+)
+
+const COLLISION_PROPS_KEY = "collision"
+
+var ErrEmptyMesh = errors.New("mst: mesh has no triangles")
+
+type CollisionOptions struct {
+	Method      uint8
+	TargetFaces int
+	Resolution  int
+	MaxHulls    int
+	Concavity   float64
+}
+
+var DefaultCollisionOptions = CollisionOptions{Method: COLLISION_METHOD_DECIMATE, TargetFaces: 512, Resolution: 32, MaxHulls: 16, Concavity: 0.02}
+
+func GenerateCollisionMesh(ms *Mesh, opts *CollisionOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &DefaultCollisionOptions
+	}
+	tris, err := meshTriangles(ms)
+	if err != nil {
+		return nil, err
+	}
+	if len(tris) == 0 {
+		return nil, ErrEmptyMesh
+	}
+	var nd *MeshNode
+	switch opts.Method {
+	case COLLISION_METHOD_DECIMATE:
+		if opts.TargetFaces < 4 {
+			return nil, errors.New("mst: collision target faces must be at least 4")
+		}
+		nd = decimateTriangles(tris, opts.TargetFaces)
+		nd.ReComputeNormal()
+	case COLLISION_METHOD_VOXEL:
+		if opts.Resolution < 1 || opts.Resolution > 1024 {
+			return nil, errors.New("mst: collision resolution must be between 1 and 1024")
+		}
+		nd = newVoxelRemesher(tris, opts.Resolution).boundary()
+		nd.ReComputeNormal()
+	case COLLISION_METHOD_CONVEX:
+		hulls, err := convexDecompose(tris, &ConvexDecompositionOptions{Resolution: opts.Resolution, MaxHulls: opts.MaxHulls, Concavity: opts.Concavity})
+		if err != nil {
+			return nil, err
+		}
+		return collisionMesh(hulls), nil
+	default:
+		return nil, errors.New("mst: unknown collision method")
+	}
+	return collisionMesh([]*MeshNode{nd}), nil
+}
+
+func collisionMesh(nds []*MeshNode) *Mesh {
+	for _, nd := range nds {
+		nd.Name = COLLISION_PROPS_KEY
+		nd.Props = Properties{COLLISION_PROPS_KEY: true}
+	}
+	out := NewMesh()
+	out.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{128, 128, 128}}}
+	out.Nodes = nds
+	out.Props = Properties{COLLISION_PROPS_KEY: true}
+	return out
+}
+
+func trianglesBox(tris []bvhTriangle) dvec3.Box {
+	bx := tris[0].box()
+	for i := 1; i < len(tris); i++ {
+		tb := tris[i].box()
+		bx.Join(&tb)
+	}
+	return bx
+}
+
+func clusterTriangles(tris []bvhTriangle, key func(p *dvec3.T) [3]int64) *MeshNode {
+	index := make(map[[3]int64]uint32)
+	var sums []dvec3.T
+	var counts []float64
+	vertex := func(p *dvec3.T) uint32 {
+		k := key(p)
+		i, ok := index[k]
+		if !ok {
+			i = uint32(len(sums))
+			index[k] = i
+			sums = append(sums, dvec3.T{})
+			counts = append(counts, 0)
+		}
+		sums[i].Add(p)
+		counts[i]++
+		return i
+	}
+	g := &MeshTriangle{}
+	seen := make(map[[3]uint32]bool)
+	for i := range tris {
+		t := &tris[i]
+		v := [3]uint32{vertex(&t.v[0]), vertex(&t.v[1]), vertex(&t.v[2])}
+		if v[0] == v[1] || v[1] == v[2] || v[2] == v[0] {
+			continue
+		}
+		k := v
+		sort.Slice(k[:], func(i, j int) bool { return k[i] < k[j] })
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		g.Faces = append(g.Faces, &Face{Vertex: v})
+	}
+	nd := &MeshNode{Vertices: make([]vec3.T, len(sums)), FaceGroup: []*MeshTriangle{g}}
+	for i, s := range sums {
+		s.Scale(1 / counts[i])
+		nd.Vertices[i] = vec3.T{float32(s[0]), float32(s[1]), float32(s[2])}
+	}
+	nd.removeUnusedVertices()
+	return nd
+}
+
+func decimateTriangles(tris []bvhTriangle, target int) *MeshNode {
+	nd := clusterTriangles(tris, func(p *dvec3.T) [3]int64 {
+		return [3]int64{int64(math.Float64bits(p[0])), int64(math.Float64bits(p[1])), int64(math.Float64bits(p[2]))}
+	})
+	bx := trianglesBox(tris)
+	ext := bx.Diagonal()
+	size := math.Max(ext[0], math.Max(ext[1], ext[2]))
+	for res := math.Ceil(2 * math.Sqrt(float64(target))); len(nd.FaceGroup[0].Faces) > target && res >= 1; res = math.Floor(res * 0.8) {
+		cell := size / res
+		nd = clusterTriangles(tris, func(p *dvec3.T) [3]int64 {
+			return [3]int64{int64((p[0] - bx.Min[0]) / cell), int64((p[1] - bx.Min[1]) / cell), int64((p[2] - bx.Min[2]) / cell)}
+		})
+	}
+	return nd
+}
+
+type voxelRemesher struct {
+	dims   [3]int
+	origin dvec3.T
+	cell   float64
+	cells  []uint8
+}
+
+const (
+	voxelEmpty = iota
+	voxelSurface
+	voxelExterior
+)
+
+func (r *voxelRemesher) index(x, y, z int) int {
+	return (z*r.dims[1]+y)*r.dims[0] + x
+}
+
+func (r *voxelRemesher) mark(p *dvec3.T) {
+	var c [3]int
+	for a := 0; a < 3; a++ {
+		c[a] = int((p[a] - r.origin[a]) / r.cell)
+		if c[a] < 1 {
+			c[a] = 1
+		} else if c[a] > r.dims[a]-2 {
+			c[a] = r.dims[a] - 2
+		}
+	}
+	r.cells[r.index(c[0], c[1], c[2])] = voxelSurface
+}
+
+func (r *voxelRemesher) rasterize(t *bvhTriangle) {
+	e1, e2 := dvec3.Sub(&t.v[1], &t.v[0]), dvec3.Sub(&t.v[2], &t.v[0])
+	e3 := dvec3.Sub(&t.v[2], &t.v[1])
+	longest := math.Max(e1.Length(), math.Max(e2.Length(), e3.Length()))
+	n := int(math.Ceil(2*longest/r.cell)) + 1
+	for i := 0; i <= n; i++ {
+		for j := 0; i+j <= n; j++ {
+			a, b := e1.Scaled(float64(i)/float64(n)), e2.Scaled(float64(j)/float64(n))
+			p := dvec3.Add(&t.v[0], &a)
+			p.Add(&b)
+			r.mark(&p)
+		}
+	}
+}
+
+func (r *voxelRemesher) floodExterior() {
+	stack := []int{0}
+	r.cells[0] = voxelExterior
+	nx, nxy := r.dims[0], r.dims[0]*r.dims[1]
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y, z := i%nx, i/nx%r.dims[1], i/nxy
+		for _, nb := range [6][4]int{{x - 1, y, z, i - 1}, {x + 1, y, z, i + 1}, {x, y - 1, z, i - nx}, {x, y + 1, z, i + nx}, {x, y, z - 1, i - nxy}, {x, y, z + 1, i + nxy}} {
+			if nb[0] < 0 || nb[1] < 0 || nb[2] < 0 || nb[0] >= r.dims[0] || nb[1] >= r.dims[1] || nb[2] >= r.dims[2] {
+				continue
+			}
+			if r.cells[nb[3]] == voxelEmpty {
+				r.cells[nb[3]] = voxelExterior
+				stack = append(stack, nb[3])
+			}
+		}
+	}
+}
+
+func (r *voxelRemesher) boundary() *MeshNode {
+	nd := &MeshNode{}
+	g := &MeshTriangle{}
+	corners := make(map[[3]int]uint32)
+	corner := func(c [3]int) uint32 {
+		if i, ok := corners[c]; ok {
+			return i
+		}
+		i := uint32(len(nd.Vertices))
+		corners[c] = i
+		nd.Vertices = append(nd.Vertices, vec3.T{
+			float32(r.origin[0] + float64(c[0])*r.cell),
+			float32(r.origin[1] + float64(c[1])*r.cell),
+			float32(r.origin[2] + float64(c[2])*r.cell),
+		})
+		return i
+	}
+	quad := [4][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	for z := 1; z < r.dims[2]-1; z++ {
+		for y := 1; y < r.dims[1]-1; y++ {
+			for x := 1; x < r.dims[0]-1; x++ {
+				if r.cells[r.index(x, y, z)] == voxelExterior {
+					continue
+				}
+				for a := 0; a < 3; a++ {
+					u, v := (a+1)%3, (a+2)%3
+					for _, s := range [2]int{-1, 1} {
+						nb := [3]int{x, y, z}
+						nb[a] += s
+						if r.cells[r.index(nb[0], nb[1], nb[2])] != voxelExterior {
+							continue
+						}
+						var q [4]uint32
+						for k, uv := range quad {
+							c := [3]int{x, y, z}
+							if s > 0 {
+								c[a]++
+							}
+							c[u] += uv[0]
+							c[v] += uv[1]
+							q[k] = corner(c)
+						}
+						if s < 0 {
+							q[1], q[3] = q[3], q[1]
+						}
+						g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{q[0], q[1], q[2]}}, &Face{Vertex: [3]uint32{q[0], q[2], q[3]}})
+					}
+				}
+			}
+		}
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	return nd
+}
+
+func newVoxelRemesher(tris []bvhTriangle, res int) *voxelRemesher {
+	bx := trianglesBox(tris)
+	ext := bx.Diagonal()
+	size := math.Max(ext[0], math.Max(ext[1], ext[2]))
+	if size == 0 {
+		size = 1
+	}
+	return voxelizeTriangles(tris, bx, size/float64(res))
+}
+
+func voxelizeTriangles(tris []bvhTriangle, bx dvec3.Box, cell float64) *voxelRemesher {
+	ext := bx.Diagonal()
+	r := &voxelRemesher{cell: cell}
+	for a := 0; a < 3; a++ {
+		r.dims[a] = int(ext[a]/r.cell) + 3
+		r.origin[a] = bx.Min[a] - r.cell
+	}
+	r.cells = make([]uint8, r.dims[0]*r.dims[1]*r.dims[2])
+	for i := range tris {
+		r.rasterize(&tris[i])
+	}
+	r.floodExterior()
+	return r
+}
@@ -0,0 +1,162 @@
+package mst
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	COMPRESSION_NONE = 0
+	COMPRESSION_GZIP = 1
+	COMPRESSION_ZSTD = 2
+)
+
+var ErrUnknownCompression = errors.New("mst: unknown compression codec")
+
+func WithCompression(codec uint8, level int) WriteOption {
+	return func(o *writeOptions) {
+		o.compression = codec
+		o.level = level
+	}
+}
+
+func validCompression(codec uint8, level int) error {
+	switch codec {
+	case COMPRESSION_NONE, COMPRESSION_ZSTD:
+		return nil
+	case COMPRESSION_GZIP:
+		if level != 0 && (level < gzip.HuffmanOnly || level > gzip.BestCompression) {
+			return fmt.Errorf("mst: invalid gzip compression level %d", level)
+		}
+		return nil
+	}
+	return ErrUnknownCompression
+}
+
+func (o *writeOptions) validate() error {
+	return validCompression(o.compression, o.level)
+}
+
+func compressSection(sec []byte, codec uint8, level int) []byte {
+	var data []byte
+	switch codec {
+	case COMPRESSION_GZIP:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		buf := &bytes.Buffer{}
+		zw, _ := gzip.NewWriterLevel(buf, level)
+		zw.Write(sec)
+		zw.Close()
+		data = buf.Bytes()
+	case COMPRESSION_ZSTD:
+		zl := zstd.SpeedDefault
+		if level != 0 {
+			zl = zstd.EncoderLevelFromZstd(level)
+		}
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zl), zstd.WithEncoderConcurrency(1))
+		data = zw.EncodeAll(sec, nil)
+		zw.Close()
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 8+len(data)))
+	writeLittleByte(buf, uint64(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func compressSections(sections [][]byte, codec uint8, level int) [][]byte {
+	out := make([][]byte, len(sections))
+	for i, sec := range sections {
+		out[i] = compressSection(sec, codec, level)
+	}
+	return out
+}
+
+type sectionDecompressor interface {
+	io.Reader
+	close()
+}
+
+type gzipDecompressor struct{ *gzip.Reader }
+
+func (r gzipDecompressor) close() { r.Close() }
+
+type zstdDecompressor struct{ *zstd.Decoder }
+
+func (r zstdDecompressor) close() { r.Close() }
+
+func newDecompressor(codec uint8, rd io.Reader) (sectionDecompressor, error) {
+	switch codec {
+	case COMPRESSION_GZIP:
+		zr, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		return gzipDecompressor{zr}, nil
+	case COMPRESSION_ZSTD:
+		zr, err := zstd.NewReader(rd, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdDecompressor{zr}, nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+func (d *decoder) compression() uint8 {
+	var codec uint8
+	if d.read(&codec) && codec != COMPRESSION_GZIP && codec != COMPRESSION_ZSTD {
+		d.fail(ErrUnknownCompression)
+	}
+	return codec
+}
+
+func (d *decoder) beginSection() func() {
+	if d.flags&MESH_FLAG_COMPRESSED == 0 {
+		return func() {}
+	}
+	var n uint64
+	if !d.read(&n) {
+		return func() {}
+	}
+	src := d.rd
+	lr := &io.LimitedReader{R: src, N: int64(n)}
+	zr, err := newDecompressor(d.codec, lr)
+	if err != nil {
+		d.fail(err)
+		return func() {}
+	}
+	br := bufio.NewReader(zr)
+	d.rd = br
+	return func() {
+		d.rd = src
+		defer zr.close()
+		if d.err != nil {
+			return
+		}
+		if _, err := br.ReadByte(); err != io.EOF {
+			if err == nil {
+				err = errors.New("mst: trailing data in compressed section")
+			}
+			d.fail(err)
+			return
+		}
+		io.Copy(ioutil.Discard, lr)
+		if lr.N != 0 {
+			d.fail(io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func (d *decoder) section(body func()) {
+	end := d.beginSection()
+	body()
+	end()
+}
@@ -0,0 +1,433 @@
+package mst
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const MESH_LATEST_VERSION = V28
+
+const MESH_FOOTER_SIGNATURE string = "fwte"
+
+const (
+	MESH_FLAG_CHECKSUM      = 1 << 0
+	MESH_FLAG_SECTION_TABLE = 1 << 1
+	MESH_FLAG_MANIFEST      = 1 << 2
+	MESH_FLAG_COMPRESSED    = 1 << 3
+)
+
+const maxSectionTableEntries = 64
+
+var (
+	ErrNoSectionTable  = errors.New("mst: file has no section table")
+	ErrSectionNotFound = errors.New("mst: section not found")
+)
+
+const (
+	MESH_SECTION_MATERIALS = 0
+	MESH_SECTION_NODES     = 1
+	MESH_SECTION_INSTANCES = 2
+	MESH_SECTION_PROPS     = 3
+	MESH_SECTION_COUNT     = 4
+
+	MESH_SECTION_ANIMATIONS = 4
+)
+
+var meshSectionNames = []string{"materials", "nodes", "instances", "props", "animations"}
+
+func meshSectionCount(v uint32) int {
+	if FormatCapabilities(v).Animations {
+		return MESH_SECTION_ANIMATIONS + 1
+	}
+	return MESH_SECTION_COUNT
+}
+
+type writeOptions struct {
+	checksum         bool
+	sectionTable     bool
+	manifest         *Manifest
+	compression      uint8
+	level            int
+	canonical        bool
+	externalTextures bool
+	ctx              context.Context
+	progress         ProgressFunc
+}
+
+type WriteOption func(*writeOptions)
+
+func WithChecksum() WriteOption {
+	return func(o *writeOptions) {
+		o.checksum = true
+	}
+}
+
+func WithSectionTable() WriteOption {
+	return func(o *writeOptions) {
+		o.sectionTable = true
+	}
+}
+
+func WithContext(ctx context.Context) WriteOption {
+	return func(o *writeOptions) {
+		o.ctx = ctx
+	}
+}
+
+func WithProgress(fn ProgressFunc) WriteOption {
+	return func(o *writeOptions) {
+		o.progress = fn
+	}
+}
+
+func newWriteOptions(opts []WriteOption) *writeOptions {
+	o := &writeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *writeOptions) header(v uint32) (uint32, uint32) {
+	var flags uint32
+	if o.checksum {
+		flags |= MESH_FLAG_CHECKSUM
+	}
+	if o.sectionTable {
+		flags |= MESH_FLAG_SECTION_TABLE
+	}
+	if o.manifest != nil {
+		flags |= MESH_FLAG_MANIFEST
+	}
+	if o.compression != COMPRESSION_NONE {
+		flags |= MESH_FLAG_COMPRESSED
+	}
+	if flags != 0 && !FormatCapabilities(v).HeaderFlags {
+		v = V6
+	}
+	return v, flags
+}
+
+type ChecksumError struct {
+	Section  string
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("mst: %s checksum mismatch (expected %08x, got %08x)", e.Section, e.Expected, e.Actual)
+}
+
+type DecodeOptions struct {
+	Limits          *DecodeLimits
+	VerifyIntegrity bool
+	Resolver        PrototypeResolver
+	Textures        TextureResolver
+	Materials       MaterialResolver
+	Context         context.Context
+	Progress        ProgressFunc
+	Logger          Logger
+}
+
+var DefaultDecodeOptions = DecodeOptions{Limits: &DefaultDecodeLimits, VerifyIntegrity: true}
+
+type checksumWriter struct {
+	wt       io.Writer
+	enabled  bool
+	whole    hash.Hash32
+	section  hash.Hash32
+	sections []uint32
+}
+
+func newChecksumWriter(wt io.Writer, enabled bool) *checksumWriter {
+	return &checksumWriter{wt: wt, enabled: enabled, whole: crc32.NewIEEE(), section: crc32.NewIEEE()}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	if w.enabled {
+		w.whole.Write(p)
+		w.section.Write(p)
+	}
+	return w.wt.Write(p)
+}
+
+func (w *checksumWriter) begin() {
+	w.section.Reset()
+}
+
+func (w *checksumWriter) next() {
+	w.sections = append(w.sections, w.section.Sum32())
+	w.section.Reset()
+}
+
+func (w *checksumWriter) footer() {
+	if !w.enabled {
+		return
+	}
+	writeLittleByte(w.wt, uint32(len(w.sections)))
+	writeLittleByte(w.wt, w.sections)
+	writeLittleByte(w.wt, w.whole.Sum32())
+	w.wt.Write([]byte(MESH_FOOTER_SIGNATURE))
+}
+
+type checksumReader struct {
+	rd       io.Reader
+	whole    hash.Hash32
+	section  hash.Hash32
+	sections []uint32
+}
+
+func newChecksumReader(rd io.Reader, header []byte) *checksumReader {
+	r := &checksumReader{rd: rd, whole: crc32.NewIEEE(), section: crc32.NewIEEE()}
+	r.whole.Write(header)
+	return r
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.whole.Write(p[:n])
+	r.section.Write(p[:n])
+	return n, err
+}
+
+func (r *checksumReader) next() {
+	r.sections = append(r.sections, r.section.Sum32())
+	r.section.Reset()
+}
+
+type MeshSection struct {
+	Offset uint64
+	Length uint64
+}
+
+type MeshHeader struct {
+	Version     uint32
+	Flags       uint32
+	Compression uint8
+	Sections    []MeshSection
+	Manifest    *Manifest
+}
+
+type sectionBuffer struct {
+	bytes.Buffer
+	sections [][]byte
+}
+
+func (b *sectionBuffer) next() {
+	b.sections = append(b.sections, append([]byte(nil), b.Bytes()...))
+	b.Reset()
+}
+
+func headerSize(v, flags uint32, sections int, manifest int) int {
+	n := len(MESH_SIGNATURE) + 4
+	if FormatCapabilities(v).HeaderFlags {
+		n += 4
+		if flags&MESH_FLAG_COMPRESSED != 0 {
+			n++
+		}
+		if flags&MESH_FLAG_SECTION_TABLE != 0 {
+			n += 4 + sections*16
+		}
+		if flags&MESH_FLAG_MANIFEST != 0 {
+			n += 4 + manifest
+		}
+	}
+	return n
+}
+
+func sectionTable(v, flags uint32, sections [][]byte, manifest int) []MeshSection {
+	table := make([]MeshSection, len(sections))
+	offset := uint64(headerSize(v, flags, len(sections), manifest))
+	for i, sec := range sections {
+		table[i] = MeshSection{Offset: offset, Length: uint64(len(sec))}
+		offset += uint64(len(sec))
+	}
+	return table
+}
+
+func headerBytes(v, flags uint32, codec uint8, table []MeshSection, manifest []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte(MESH_SIGNATURE))
+	writeLittleByte(buf, v)
+	if FormatCapabilities(v).HeaderFlags {
+		writeLittleByte(buf, flags)
+		if flags&MESH_FLAG_COMPRESSED != 0 {
+			writeLittleByte(buf, codec)
+		}
+		if flags&MESH_FLAG_SECTION_TABLE != 0 {
+			writeLittleByte(buf, uint32(len(table)))
+			for _, s := range table {
+				writeLittleByte(buf, s.Offset)
+				writeLittleByte(buf, s.Length)
+			}
+		}
+		if flags&MESH_FLAG_MANIFEST != 0 {
+			writeLittleByte(buf, uint32(len(manifest)))
+			buf.Write(manifest)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (d *decoder) sectionTable() []MeshSection {
+	n := d.count("section table size", func(l *DecodeLimits) uint32 { return maxSectionTableEntries })
+	table := make([]MeshSection, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		var s MeshSection
+		d.read(&s.Offset)
+		d.read(&s.Length)
+		table = append(table, s)
+	}
+	return table
+}
+
+func ReadMeshHeader(rd io.Reader) (*MeshHeader, error) {
+	d := newDecoder(rd, 0, &DefaultDecodeLimits)
+	if !d.header() {
+		return nil, d.err
+	}
+	hdr := &MeshHeader{Version: d.v, Flags: d.flags, Compression: d.codec, Sections: d.table}
+	if d.manifest != nil {
+		hdr.Manifest = &Manifest{}
+		if err := json.Unmarshal(d.manifest, hdr.Manifest); err != nil {
+			return nil, err
+		}
+	}
+	return hdr, nil
+}
+
+func ReadMeshSection(ra io.ReaderAt, hdr *MeshHeader, section int) (*Mesh, error) {
+	if hdr.Flags&MESH_FLAG_SECTION_TABLE == 0 {
+		return nil, ErrNoSectionTable
+	}
+	if section < 0 || section >= len(hdr.Sections) || section >= meshSectionCount(hdr.Version) {
+		return nil, ErrSectionNotFound
+	}
+	s := hdr.Sections[section]
+	return decodeSection(bufio.NewReader(io.NewSectionReader(ra, int64(s.Offset), int64(s.Length))), hdr, section)
+}
+
+func newSectionDecoder(rd io.Reader, hdr *MeshHeader) *decoder {
+	d := newDecoder(rd, hdr.Version, &DefaultDecodeLimits)
+	d.flags = hdr.Flags
+	d.codec = hdr.Compression
+	return d
+}
+
+func decodeSection(rd io.Reader, hdr *MeshHeader, section int) (*Mesh, error) {
+	d := newSectionDecoder(rd, hdr)
+	caps := d.caps()
+	ms := &Mesh{Version: hdr.Version}
+	end := d.beginSection()
+	switch section {
+	case MESH_SECTION_MATERIALS:
+		ms.Materials = d.materials()
+	case MESH_SECTION_NODES:
+		ms.Nodes = d.meshNodes()
+		if caps.Code {
+			d.read(&ms.Code)
+		}
+	case MESH_SECTION_INSTANCES:
+		ms.InstanceNode = d.instanceNodes()
+	case MESH_SECTION_PROPS:
+		if caps.Props {
+			ms.Props = d.props(0)
+		}
+		if caps.Units {
+			d.read(&ms.Units)
+		}
+	case MESH_SECTION_ANIMATIONS:
+		ms.Animations = d.animations()
+	}
+	end()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return ms, nil
+}
+
+func (d *decoder) footer(cr *checksumReader) {
+	whole := cr.whole.Sum32()
+	n := d.count("footer section count", func(l *DecodeLimits) uint32 { return uint32(meshSectionCount(d.v)) })
+	sections := d.uint32s(n)
+	var expected uint32
+	d.read(&expected)
+	sig := d.bytes(len(MESH_FOOTER_SIGNATURE))
+	if d.err != nil {
+		return
+	}
+	if string(sig) != MESH_FOOTER_SIGNATURE {
+		d.fail(ErrInvalidSignature)
+		return
+	}
+	var err error
+	for i := 0; i < len(sections) && i < len(cr.sections) && err == nil; i++ {
+		if sections[i] != cr.sections[i] {
+			err = &ChecksumError{Section: meshSectionNames[i], Expected: sections[i], Actual: cr.sections[i]}
+		}
+	}
+	if err == nil && expected != whole {
+		err = &ChecksumError{Section: "file", Expected: expected, Actual: whole}
+	}
+	switch {
+	case err == nil:
+	case d.verify:
+		d.fail(err)
+	default:
+		logWarn(d.log, "ignored checksum mismatch", "error", err)
+	}
+}
+
+func MeshUnMarshalWithOptions(rd io.Reader, opts *DecodeOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &DefaultDecodeOptions
+	}
+	d := newDecoder(rd, 0, opts.Limits)
+	d.verify = opts.VerifyIntegrity
+	d.progress = newProgress(opts.Context, opts.Progress)
+	d.log = opts.Logger
+	if err := d.progress.err(); err != nil {
+		return nil, err
+	}
+	ms := d.mesh()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if opts.Resolver != nil {
+		if err := ms.ResolveInstanceRefs(opts.Resolver); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Materials != nil && hasMaterialRefs(ms, true) {
+		if err := ms.ResolveMaterialRefs(opts.Materials); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Textures != nil {
+		if err := ms.ResolveTextures(opts.Textures); err != nil {
+			return nil, err
+		}
+	}
+	return ms, nil
+}
+
+func MeshUnMarshalContext(ctx context.Context, rd io.Reader, opts *DecodeOptions) (*Mesh, error) {
+	o := DefaultDecodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Context = ctx
+	return MeshUnMarshalWithOptions(rd, &o)
+}
+
+func VerifyIntegrity(rd io.Reader) error {
+	_, err := MeshUnMarshalWithOptions(rd, &DefaultDecodeOptions)
+	return err
+}
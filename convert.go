@@ -0,0 +1,240 @@
+package mst
+
+import "fmt"
+
+type Warning struct {
+	Field   string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+func ConvertVersion(mesh *Mesh, target uint32) (*Mesh, []Warning) {
+	if !IsSupportedVersion(target) {
+		return nil, []Warning{{Field: "version", Message: fmt.Sprintf("unsupported target version %d", target)}}
+	}
+	caps := FormatCapabilities(target)
+	var warns []Warning
+	out := *mesh
+	out.Version = target
+	if !caps.Props && len(out.Props) > 0 {
+		warns = append(warns, Warning{Field: "props", Message: fmt.Sprintf("dropped %d mesh properties", len(out.Props))})
+		out.Props = nil
+	}
+	if !caps.Units && out.Units != UNITS_UNKNOWN {
+		warns = append(warns, Warning{Field: "units", Message: fmt.Sprintf("dropped mesh units %d", out.Units)})
+		out.Units = UNITS_UNKNOWN
+	}
+	if !caps.Animations && len(out.Animations) > 0 {
+		warns = append(warns, Warning{Field: "animations", Message: fmt.Sprintf("dropped %d animations", len(out.Animations))})
+		out.Animations = nil
+	}
+	var bm *BaseMesh
+	bm, warns = convertBaseMesh("", &mesh.BaseMesh, caps, warns)
+	out.BaseMesh = *bm
+	out.InstanceNode = make([]*InstanceMesh, len(mesh.InstanceNode))
+	for i, inst := range mesh.InstanceNode {
+		cp := *inst
+		if !caps.Props && len(cp.Props) > 0 {
+			warns = append(warns, Warning{Field: fmt.Sprintf("instances[%d].props", i), Message: fmt.Sprintf("dropped %d instance properties", len(cp.Props))})
+			cp.Props = nil
+		}
+		if !caps.InstanceRefs && cp.Ref != nil {
+			if cp.Mesh == nil {
+				warns = append(warns, Warning{Field: fmt.Sprintf("instances[%d].ref", i), Message: fmt.Sprintf("unresolved reference to %s replaced by an empty mesh", cp.Ref.URI)})
+				cp.Mesh = &BaseMesh{}
+			} else {
+				warns = append(warns, Warning{Field: fmt.Sprintf("instances[%d].ref", i), Message: fmt.Sprintf("reference to %s embedded", cp.Ref.URI)})
+			}
+			cp.Ref = nil
+		}
+		if cp.Mesh != nil {
+			cp.Mesh, warns = convertBaseMesh(fmt.Sprintf("instances[%d].mesh", i), cp.Mesh, caps, warns)
+		}
+		if !caps.InstanceBounds && len(cp.Bounds) > 0 {
+			warns = append(warns, Warning{Field: fmt.Sprintf("instances[%d].bounds", i), Message: fmt.Sprintf("dropped %d per-instance bounds", len(cp.Bounds))})
+			cp.Bounds = nil
+		}
+		if !caps.Features64 {
+			truncated := 0
+			features := make([]uint64, len(cp.Features))
+			for j, f := range cp.Features {
+				if f > 0xFFFFFFFF {
+					truncated++
+				}
+				features[j] = uint64(uint32(f))
+			}
+			if truncated > 0 {
+				warns = append(warns, Warning{Field: fmt.Sprintf("instances[%d].features", i), Message: fmt.Sprintf("truncated %d features to 32 bits", truncated)})
+			}
+			cp.Features = features
+		}
+		out.InstanceNode[i] = &cp
+	}
+	if mesh.InstanceNode == nil {
+		out.InstanceNode = nil
+	}
+	res := &out
+	if !caps.ColorSpaces && hasTextureColorSpaces(res) {
+		res, warns = dropTextureColorSpaces(res, warns)
+	}
+	if !caps.TextureURIs && hasTextureURIs(res) {
+		return dropTextureURIs(res, warns)
+	}
+	return res, warns
+}
+
+// convertBaseMesh applies the node and material downgrades of ConvertVersion
+// to the mesh or an instance prototype. bm is returned when nothing changed.
+func convertBaseMesh(field string, bm *BaseMesh, caps Capabilities, warns []Warning) (*BaseMesh, []Warning) {
+	path := func(name string) string {
+		if field == "" {
+			return name
+		}
+		return field + "." + name
+	}
+	out := *bm
+	changed := false
+	nodes := func(drop func(string, []*MeshNode, []Warning) ([]*MeshNode, []Warning)) {
+		var nds []*MeshNode
+		if nds, warns = drop(path("nodes"), out.Nodes, warns); nds != nil {
+			out.Nodes, changed = nds, true
+		}
+	}
+	materials := func(drop func(string, []MeshMaterial, []Warning) ([]MeshMaterial, []Warning)) {
+		var mtls []MeshMaterial
+		if mtls, warns = drop(path("materials"), out.Materials, warns); mtls != nil {
+			out.Materials, changed = mtls, true
+		}
+	}
+	if !caps.Code && out.Code != 0 {
+		warns = append(warns, Warning{Field: path("code"), Message: fmt.Sprintf("dropped mesh code %d", out.Code)})
+		out.Code, changed = 0, true
+	}
+	if !caps.NodeProps {
+		nodes(dropNodeProps)
+	}
+	if !caps.MorphTargets {
+		nodes(dropMorphTargets)
+	}
+	if !caps.Hierarchy {
+		nodes(dropHierarchy)
+	}
+	if !caps.MaterialRefs {
+		materials(embedMaterialRefs)
+	}
+	if !caps.MaterialLengths {
+		materials(dropUnknownMaterials)
+	}
+	if !caps.MaterialNames {
+		materials(dropMaterialNames)
+	}
+	if !caps.TexCoords2 {
+		nodes(dropTexCoords2)
+		materials(dropTexCoordSets)
+	}
+	if !caps.Lightmaps {
+		nodes(dropLightmaps)
+	}
+	if !caps.FaceIndices {
+		nodes(dropFaceIndices)
+	}
+	if !caps.FaceFeatures {
+		nodes(dropFaceFeatures)
+	}
+	if !caps.PrimitiveModes {
+		nodes(dropPrimitiveModes)
+	}
+	if !caps.Polygons {
+		nodes(dropPolygons)
+	}
+	if !caps.OutlineStyles {
+		nodes(dropOutlineStyles)
+	}
+	if !caps.Quantization {
+		nodes(dropQuantization)
+	}
+	if !caps.CompactAttributes {
+		if nds := dropCompactAttributes(out.Nodes); nds != nil {
+			out.Nodes, changed = nds, true
+		}
+	}
+	if !changed {
+		return bm, warns
+	}
+	return &out, warns
+}
+
+func dropNodeProps(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if len(nd.Props) == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].props", field, i), Message: fmt.Sprintf("dropped %d node properties", len(nd.Props))})
+		cp := *nd
+		cp.Props = nil
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func dropMorphTargets(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if len(nd.MorphTargets) == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].morphTargets", field, i), Message: fmt.Sprintf("dropped %d morph targets", len(nd.MorphTargets))})
+		cp := *nd
+		cp.MorphTargets = nil
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func dropHierarchy(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if !inHierarchy(nd) {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.Name, cp.Children = "", nil
+		out[i] = &cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "dropped node names and hierarchy"})
+	}
+	return out, warns
+}
+
+func dropMaterialNames(field string, mtls []MeshMaterial, warns []Warning) ([]MeshMaterial, []Warning) {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		if MaterialName(mtl) == "" {
+			continue
+		}
+		if out == nil {
+			out = append([]MeshMaterial(nil), mtls...)
+		}
+		cp := cloneMaterial(resolveMaterial(mtl))
+		materialBase(cp).Name = ""
+		out[i] = cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "dropped material names"})
+	}
+	return out, warns
+}
@@ -0,0 +1,429 @@
+package mst
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrDegenerateHull = errors.New("mst: points do not span a volume")
+
+type ConvexDecompositionOptions struct {
+	Resolution int
+	MaxHulls   int
+	Concavity  float64
+}
+
+var DefaultConvexDecompositionOptions = ConvexDecompositionOptions{Resolution: 32, MaxHulls: 16, Concavity: 0.02}
+
+type hullFace struct {
+	v       [3]int
+	normal  dvec3.T
+	offset  float64
+	outside []int
+	dead    bool
+}
+
+type hullBuilder struct {
+	pts   []dvec3.T
+	faces []*hullFace
+	eps   float64
+}
+
+func (h *hullBuilder) dist(f *hullFace, p int) float64 {
+	return dvec3.Dot(&f.normal, &h.pts[p]) - f.offset
+}
+
+func (h *hullBuilder) face(a, b, c int) *hullFace {
+	e1, e2 := dvec3.Sub(&h.pts[b], &h.pts[a]), dvec3.Sub(&h.pts[c], &h.pts[a])
+	n := dvec3.Cross(&e1, &e2)
+	if l := n.Length(); l > 0 {
+		n.Scale(1 / l)
+	}
+	return &hullFace{v: [3]int{a, b, c}, normal: n, offset: dvec3.Dot(&n, &h.pts[a])}
+}
+
+func (h *hullBuilder) farthest(from func(p int) float64) int {
+	best, bestDist := -1, h.eps
+	for i := range h.pts {
+		if d := from(i); d > bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func (h *hullBuilder) simplex() ([4]int, error) {
+	var s [4]int
+	for i, p := range h.pts {
+		if p[0] < h.pts[s[0]][0] {
+			s[0] = i
+		}
+		if p[0] > h.pts[s[1]][0] {
+			s[1] = i
+		}
+	}
+	if s[0] == s[1] {
+		s[1] = h.farthest(func(p int) float64 { return dvec3.Distance(&h.pts[p], &h.pts[s[0]]) })
+		if s[1] < 0 {
+			return s, ErrDegenerateHull
+		}
+	}
+	a, b := h.pts[s[0]], h.pts[s[1]]
+	ab := dvec3.Sub(&b, &a)
+	s[2] = h.farthest(func(p int) float64 {
+		ap := dvec3.Sub(&h.pts[p], &a)
+		c := dvec3.Cross(&ab, &ap)
+		return c.Length() / ab.Length()
+	})
+	if s[2] < 0 {
+		return s, ErrDegenerateHull
+	}
+	base := h.face(s[0], s[1], s[2])
+	s[3] = h.farthest(func(p int) float64 { return math.Abs(h.dist(base, p)) })
+	if s[3] < 0 {
+		return s, ErrDegenerateHull
+	}
+	return s, nil
+}
+
+func (h *hullBuilder) build() error {
+	s, err := h.simplex()
+	if err != nil {
+		return err
+	}
+	var center dvec3.T
+	for _, i := range s {
+		center.Add(&h.pts[i])
+	}
+	center.Scale(0.25)
+	for _, t := range [4][3]int{{0, 1, 2}, {0, 3, 1}, {0, 2, 3}, {1, 3, 2}} {
+		f := h.face(s[t[0]], s[t[1]], s[t[2]])
+		if dvec3.Dot(&f.normal, &center)-f.offset > 0 {
+			f = h.face(s[t[0]], s[t[2]], s[t[1]])
+		}
+		h.faces = append(h.faces, f)
+	}
+	all := make([]int, len(h.pts))
+	for i := range all {
+		all[i] = i
+	}
+	h.assign(all, h.faces)
+	for {
+		var cur *hullFace
+		for _, f := range h.faces {
+			if !f.dead && len(f.outside) > 0 {
+				cur = f
+				break
+			}
+		}
+		if cur == nil {
+			return nil
+		}
+		p, far := cur.outside[0], h.dist(cur, cur.outside[0])
+		for _, q := range cur.outside[1:] {
+			if d := h.dist(cur, q); d > far {
+				p, far = q, d
+			}
+		}
+		edges := make(map[[2]int]bool)
+		var orphans []int
+		for _, f := range h.faces {
+			if f.dead || h.dist(f, p) <= h.eps {
+				continue
+			}
+			f.dead = true
+			orphans = append(orphans, f.outside...)
+			f.outside = nil
+			for k := 0; k < 3; k++ {
+				edges[[2]int{f.v[k], f.v[(k+1)%3]}] = true
+			}
+		}
+		horizon := make([][2]int, 0, len(edges))
+		for e := range edges {
+			if !edges[[2]int{e[1], e[0]}] {
+				horizon = append(horizon, e)
+			}
+		}
+		sort.Slice(horizon, func(i, j int) bool {
+			return horizon[i][0] < horizon[j][0] || horizon[i][0] == horizon[j][0] && horizon[i][1] < horizon[j][1]
+		})
+		added := make([]*hullFace, len(horizon))
+		for i, e := range horizon {
+			added[i] = h.face(e[0], e[1], p)
+		}
+		live := h.faces[:0]
+		for _, f := range h.faces {
+			if !f.dead {
+				live = append(live, f)
+			}
+		}
+		h.faces = append(live, added...)
+		h.assign(orphans, added)
+	}
+}
+
+func (h *hullBuilder) assign(pts []int, faces []*hullFace) {
+	for _, p := range pts {
+		for _, f := range faces {
+			if h.dist(f, p) > h.eps {
+				f.outside = append(f.outside, p)
+				break
+			}
+		}
+	}
+}
+
+func (h *hullBuilder) node() *MeshNode {
+	nd := &MeshNode{}
+	index := make(map[int]uint32)
+	g := &MeshTriangle{}
+	for _, f := range h.faces {
+		var v [3]uint32
+		for k, p := range f.v {
+			i, ok := index[p]
+			if !ok {
+				i = uint32(len(nd.Vertices))
+				index[p] = i
+				q := h.pts[p]
+				nd.Vertices = append(nd.Vertices, vec3.T{float32(q[0]), float32(q[1]), float32(q[2])})
+			}
+			v[k] = i
+		}
+		g.Faces = append(g.Faces, &Face{Vertex: v})
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.ReComputeNormal()
+	return nd
+}
+
+func (h *hullBuilder) volume() float64 {
+	var v float64
+	for _, f := range h.faces {
+		a, b, c := h.pts[f.v[0]], h.pts[f.v[1]], h.pts[f.v[2]]
+		bc := dvec3.Cross(&b, &c)
+		v += dvec3.Dot(&a, &bc)
+	}
+	return v / 6
+}
+
+func newHull(pts []dvec3.T) (*hullBuilder, error) {
+	seen := make(map[dvec3.T]bool, len(pts))
+	h := &hullBuilder{}
+	bx := dvec3.MinBox
+	for _, p := range pts {
+		if !seen[p] {
+			seen[p] = true
+			h.pts = append(h.pts, p)
+			bx.Extend(&p)
+		}
+	}
+	if len(h.pts) < 4 {
+		return nil, ErrDegenerateHull
+	}
+	ext := bx.Diagonal()
+	h.eps = 1e-9 * (math.Abs(bx.Max[0]) + math.Abs(bx.Max[1]) + math.Abs(bx.Max[2]) + ext.Length())
+	if err := h.build(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func ComputeConvexHull(nd *MeshNode) (*MeshNode, error) {
+	pts := make([]dvec3.T, len(nd.Vertices))
+	for i, v := range nd.Vertices {
+		pts[i] = dvec3.T{float64(v[0]), float64(v[1]), float64(v[2])}
+	}
+	h, err := newHull(pts)
+	if err != nil {
+		return nil, err
+	}
+	out := h.node()
+	out.Mat = nd.Mat
+	return out, nil
+}
+
+type convexPart struct {
+	cells     [][3]int
+	hull      *hullBuilder
+	concavity float64
+}
+
+type convexDecomposer struct {
+	grid  *voxelRemesher
+	total float64
+}
+
+func (c *convexDecomposer) part(cells [][3]int) (*convexPart, error) {
+	rows := make(map[[2]int][2]int)
+	for _, cl := range cells {
+		k := [2]int{cl[1], cl[2]}
+		if r, ok := rows[k]; !ok {
+			rows[k] = [2]int{cl[0], cl[0]}
+		} else if cl[0] < r[0] {
+			rows[k] = [2]int{cl[0], r[1]}
+		} else if cl[0] > r[1] {
+			rows[k] = [2]int{r[0], cl[0]}
+		}
+	}
+	g := c.grid
+	pts := make([]dvec3.T, 0, len(rows)*8)
+	for k, r := range rows {
+		for _, x := range [2]int{r[0], r[1] + 1} {
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pts = append(pts, dvec3.T{
+					g.origin[0] + float64(x)*g.cell,
+					g.origin[1] + float64(k[0]+d[0])*g.cell,
+					g.origin[2] + float64(k[1]+d[1])*g.cell,
+				})
+			}
+		}
+	}
+	h, err := newHull(pts)
+	if err != nil {
+		return nil, err
+	}
+	volume := float64(len(cells)) * g.cell * g.cell * g.cell
+	return &convexPart{cells: cells, hull: h, concavity: (h.volume() - volume) / c.total}, nil
+}
+
+func (c *convexDecomposer) split(p *convexPart) (*convexPart, *convexPart) {
+	lo, hi := p.cells[0], p.cells[0]
+	for _, cl := range p.cells {
+		for a := 0; a < 3; a++ {
+			if cl[a] < lo[a] {
+				lo[a] = cl[a]
+			}
+			if cl[a] > hi[a] {
+				hi[a] = cl[a]
+			}
+		}
+	}
+	var bestL, bestR *convexPart
+	best := math.Inf(1)
+	for a := 0; a < 3; a++ {
+		span := hi[a] - lo[a]
+		step := 1
+		if span > 8 {
+			step = span / 8
+		}
+		for at := lo[a] + 1; at <= hi[a]; at += step {
+			var l, r [][3]int
+			for _, cl := range p.cells {
+				if cl[a] < at {
+					l = append(l, cl)
+				} else {
+					r = append(r, cl)
+				}
+			}
+			pl, err := c.part(l)
+			if err != nil {
+				continue
+			}
+			pr, err := c.part(r)
+			if err != nil {
+				continue
+			}
+			if cost := pl.concavity + pr.concavity; cost < best {
+				best, bestL, bestR = cost, pl, pr
+			}
+		}
+	}
+	return bestL, bestR
+}
+
+func ConvexDecomposition(nd *MeshNode, opts *ConvexDecompositionOptions) ([]*MeshNode, error) {
+	var tris []bvhTriangle
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			if err := checkIndices("vertex", f.Vertex[:], len(nd.Vertices)); err != nil {
+				return nil, err
+			}
+			var t bvhTriangle
+			for k, v := range f.Vertex {
+				p := nd.Vertices[v]
+				t.v[k] = dvec3.T{float64(p[0]), float64(p[1]), float64(p[2])}
+			}
+			tris = append(tris, t)
+		}
+	}
+	hulls, err := convexDecompose(tris, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hulls {
+		h.Mat = nd.Mat
+	}
+	return hulls, nil
+}
+
+func convexDecompose(tris []bvhTriangle, opts *ConvexDecompositionOptions) ([]*MeshNode, error) {
+	if opts == nil {
+		opts = &DefaultConvexDecompositionOptions
+	}
+	if opts.Resolution < 1 || opts.Resolution > 1024 || opts.MaxHulls < 1 || opts.Concavity < 0 {
+		return nil, errors.New("mst: invalid convex decomposition options")
+	}
+	if len(tris) == 0 {
+		return nil, ErrEmptyMesh
+	}
+	grid := newVoxelRemesher(tris, opts.Resolution)
+	var cells [][3]int
+	for z := 1; z < grid.dims[2]-1; z++ {
+		for y := 1; y < grid.dims[1]-1; y++ {
+			for x := 1; x < grid.dims[0]-1; x++ {
+				if grid.cells[grid.index(x, y, z)] != voxelExterior {
+					cells = append(cells, [3]int{x, y, z})
+				}
+			}
+		}
+	}
+	c := &convexDecomposer{grid: grid, total: float64(len(cells)) * grid.cell * grid.cell * grid.cell}
+	root, err := c.part(cells)
+	if err != nil {
+		return nil, err
+	}
+	parts := []*convexPart{root}
+	final := make(map[*convexPart]bool)
+	for len(parts) < opts.MaxHulls {
+		worst := -1
+		for i, p := range parts {
+			if !final[p] && p.concavity > opts.Concavity && (worst < 0 || p.concavity > parts[worst].concavity) {
+				worst = i
+			}
+		}
+		if worst < 0 {
+			break
+		}
+		l, r := c.split(parts[worst])
+		if l == nil {
+			final[parts[worst]] = true
+			continue
+		}
+		parts[worst] = l
+		parts = append(parts, r)
+	}
+	out := make([]*MeshNode, len(parts))
+	for i, p := range parts {
+		out[i] = p.hull.node()
+	}
+	return out, nil
+}
+
+func (inst *InstanceMesh) ConvexDecomposition(opts *ConvexDecompositionOptions) ([][]*MeshNode, error) {
+	if inst.Mesh == nil {
+		return nil, ErrUnresolvedInstanceRef
+	}
+	out := make([][]*MeshNode, len(inst.Mesh.Nodes))
+	for i, nd := range inst.Mesh.Nodes {
+		hulls, err := ConvexDecomposition(nd, opts)
+		if err != nil && err != ErrEmptyMesh {
+			return nil, err
+		}
+		out[i] = hulls
+	}
+	return out, nil
+}
@@ -0,0 +1,657 @@
+package mst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const allocChunk = 1 << 14
+
+var (
+	ErrInvalidSignature   = errors.New("mst: invalid signature")
+	ErrUnsupportedVersion = errors.New("mst: unsupported version")
+)
+
+type DecodeLimits struct {
+	MaxVertices     uint32
+	MaxFaces        uint32
+	MaxNodes        uint32
+	MaxMaterials    uint32
+	MaxInstances    uint32
+	MaxTransforms   uint32
+	MaxTextureBytes uint32
+	MaxNameLength   uint32
+	MaxProps        uint32
+
+	MaxPropKeyLength   uint32
+	MaxPropValueLength uint32
+	MaxKeyframes       uint32
+}
+
+var DefaultDecodeLimits = DecodeLimits{
+	MaxVertices:     1 << 26,
+	MaxFaces:        1 << 26,
+	MaxNodes:        1 << 20,
+	MaxMaterials:    1 << 16,
+	MaxInstances:    1 << 20,
+	MaxTransforms:   1 << 24,
+	MaxTextureBytes: 1 << 30,
+	MaxNameLength:   1 << 12,
+	MaxProps:        1 << 20,
+
+	MaxPropKeyLength:   1 << 16,
+	MaxPropValueLength: 1 << 26,
+	MaxKeyframes:       1 << 24,
+}
+
+type LimitError struct {
+	Field string
+	Value uint64
+	Limit uint64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("mst: %s %d exceeds limit %d", e.Field, e.Value, e.Limit)
+}
+
+type UnknownMaterialError struct {
+	Type uint32
+}
+
+func (e *UnknownMaterialError) Error() string {
+	return fmt.Sprintf("mst: unknown material type %d", e.Type)
+}
+
+type decoder struct {
+	rd       io.Reader
+	v        uint32
+	limits   *DecodeLimits
+	verify   bool
+	flags    uint32
+	codec    uint8
+	table    []MeshSection
+	manifest []byte
+	progress *progress
+	log      Logger
+	depth    int
+	err      error
+}
+
+func newDecoder(rd io.Reader, v uint32, limits *DecodeLimits) *decoder {
+	return &decoder{rd: rd, v: v, limits: limits}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) tick(stage string, done, total int) {
+	if d.err != nil || d.progress == nil {
+		return
+	}
+	var err error
+	if d.depth > 0 {
+		err = d.progress.err()
+	} else {
+		err = d.progress.report(stage, done, total)
+	}
+	if err != nil {
+		d.fail(err)
+	}
+}
+
+func (d *decoder) read(v interface{}) bool {
+	if d.err != nil {
+		return false
+	}
+	if err := binary.Read(d.rd, binary.LittleEndian, v); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.fail(err)
+		return false
+	}
+	return true
+}
+
+func (d *decoder) count(field string, limit func(*DecodeLimits) uint32) int {
+	var n uint32
+	if !d.read(&n) {
+		return 0
+	}
+	if d.limits != nil {
+		if l := limit(d.limits); l > 0 && n > l {
+			d.fail(&LimitError{Field: field, Value: uint64(n), Limit: uint64(l)})
+			return 0
+		}
+	}
+	return int(n)
+}
+
+func capHint(n int) int {
+	if n > allocChunk {
+		return allocChunk
+	}
+	return n
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, capHint(n)))
+	if _, err := io.CopyN(buf, d.rd, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.fail(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (d *decoder) string(field string) string {
+	return d.limitedString(field, func(l *DecodeLimits) uint32 { return l.MaxNameLength })
+}
+
+func (d *decoder) limitedString(field string, limit func(*DecodeLimits) uint32) string {
+	n := d.count(field, limit)
+	return string(d.bytes(n))
+}
+
+func (d *decoder) vec3s(n int) []vec3.T {
+	out := make([]vec3.T, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]vec3.T, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) vec2s(n int) []vec2.T {
+	out := make([]vec2.T, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]vec2.T, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) colors(n int) [][3]byte {
+	out := make([][3]byte, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([][3]byte, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) uint64s(n int) []uint64 {
+	out := make([]uint64, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]uint64, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) uint32s(n int) []uint32 {
+	out := make([]uint32, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]uint32, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) mat() *dmat.T {
+	mt := &dmat.T{}
+	d.read(&mt[0])
+	d.read(&mt[1])
+	d.read(&mt[2])
+	d.read(&mt[3])
+	return mt
+}
+
+func (d *decoder) baseMaterial() *BaseMaterial {
+	mtl := &BaseMaterial{}
+	d.read(mtl.Color[:])
+	d.read(&mtl.Transparency)
+	return mtl
+}
+
+func (d *decoder) texture() *Texture {
+	tex := &Texture{}
+	d.read(&tex.Id)
+	tex.Name = d.string("texture name length")
+	d.read(&tex.Size)
+	d.read(&tex.Format)
+	d.read(&tex.Type)
+	d.read(&tex.Compressed)
+	n := d.count("texture bytes", func(l *DecodeLimits) uint32 { return l.MaxTextureBytes })
+	tex.Data = d.bytes(n)
+	d.read(&tex.Repeated)
+	if d.err == nil && d.limits != nil && (len(tex.Data) > 0 || !d.caps().TextureURIs) {
+		if err := tex.Validate(); err != nil {
+			d.fail(err)
+		}
+	}
+	return tex
+}
+
+func (d *decoder) textureMaterial() *TextureMaterial {
+	tmtl := &TextureMaterial{}
+	tmtl.BaseMaterial = *d.baseMaterial()
+	var hasTex uint16
+	d.read(&hasTex)
+	if hasTex == 1 {
+		tmtl.Texture = d.texture()
+	}
+	hasTex = 0
+	d.read(&hasTex)
+	if hasTex == 1 {
+		tmtl.Normal = d.texture()
+	}
+	return tmtl
+}
+
+func (d *decoder) pbrMaterial() *PbrMaterial {
+	mtl := &PbrMaterial{}
+	mtl.TextureMaterial = *d.textureMaterial()
+	d.read(mtl.Emissive[:])
+	if d.caps().PbrPadding {
+		var b byte
+		d.read(&b)
+	}
+	d.read(&mtl.Metallic)
+	d.read(&mtl.Roughness)
+	d.read(&mtl.Reflectance)
+	d.read(&mtl.AmbientOcclusion)
+	d.read(&mtl.ClearCoat)
+	d.read(&mtl.ClearCoatRoughness)
+	d.read(&mtl.ClearCoatNormal)
+	d.read(&mtl.Anisotropy)
+	d.read(mtl.AnisotropyDirection[:])
+	d.read(&mtl.Thickness)
+	d.read(&mtl.SubSurfacePower)
+	d.read(&mtl.SheenColor)
+	d.read(mtl.SubSurfaceColor[:])
+	return mtl
+}
+
+func (d *decoder) lambertMaterial() *LambertMaterial {
+	mtl := &LambertMaterial{}
+	mtl.TextureMaterial = *d.textureMaterial()
+	d.read(mtl.Ambient[:])
+	d.read(mtl.Diffuse[:])
+	d.read(mtl.Emissive[:])
+	return mtl
+}
+
+func (d *decoder) phongMaterial() *PhongMaterial {
+	mtl := &PhongMaterial{}
+	mtl.LambertMaterial = *d.lambertMaterial()
+	d.read(mtl.Specular[:])
+	d.read(&mtl.Shininess)
+	d.read(&mtl.Specularity)
+	return mtl
+}
+
+func (d *decoder) material() MeshMaterial {
+	var ty uint32
+	if !d.read(&ty) {
+		return nil
+	}
+	if d.caps().MaterialLengths {
+		return d.framedMaterial(ty)
+	}
+	return d.materialBody(ty)
+}
+
+func (d *decoder) materialBody(ty uint32) MeshMaterial {
+	if ty == MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE && d.caps().MaterialRefs {
+		return d.materialRef()
+	}
+	var mtl MeshMaterial
+	switch int(ty) {
+	case MESH_TRIANGLE_MATERIAL_TYPE_COLOR:
+		mtl = d.baseMaterial()
+	case MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE:
+		mtl = d.textureMaterial()
+	case MESH_TRIANGLE_MATERIAL_TYPE_PBR:
+		mtl = d.pbrMaterial()
+	case MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT:
+		mtl = d.lambertMaterial()
+	case MESH_TRIANGLE_MATERIAL_TYPE_PHONG:
+		mtl = d.phongMaterial()
+	default:
+		d.fail(&UnknownMaterialError{Type: ty})
+		return nil
+	}
+	if d.caps().MaterialNames {
+		materialBase(mtl).Name = d.string("material name length")
+	}
+	if d.caps().TexCoords2 {
+		d.texCoordSets(mtl)
+	}
+	if d.caps().TextureURIs {
+		d.textureURIs(mtl)
+	}
+	if d.caps().ColorSpaces {
+		d.textureColorSpaces(mtl)
+	}
+	return mtl
+}
+
+func (d *decoder) materials() []MeshMaterial {
+	n := d.count("material count", func(l *DecodeLimits) uint32 { return l.MaxMaterials })
+	mtls := make([]MeshMaterial, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		mtls = append(mtls, d.material())
+	}
+	return mtls
+}
+
+func (d *decoder) meshTriangle(width uint8) *MeshTriangle {
+	nd := &MeshTriangle{}
+	d.read(&nd.Batchid)
+	if d.caps().PrimitiveModes {
+		d.primitive(nd, width)
+	} else {
+		nd.Faces = d.faces(width)
+	}
+	return nd
+}
+
+func (d *decoder) faces(width uint8) []*Face {
+	n := d.count("face count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	faces := make([]*Face, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		f := &Face{}
+		d.indices(f.Vertex[:], width)
+		faces = append(faces, f)
+	}
+	return faces
+}
+
+func (d *decoder) meshOutline(width uint8) *MeshOutline {
+	nd := &MeshOutline{}
+	d.read(&nd.Batchid)
+	n := d.count("edge count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	nd.Edges = make([][2]uint32, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		var e [2]uint32
+		d.indices(e[:], width)
+		nd.Edges = append(nd.Edges, e)
+	}
+	return nd
+}
+
+func (d *decoder) meshNode() *MeshNode {
+	nd := &MeshNode{}
+	width := d.indexWidth()
+	nd.Quantization = d.quantization()
+	maxVertices := func(l *DecodeLimits) uint32 { return l.MaxVertices }
+	if n := d.count("vertex count", maxVertices); nd.Quantization&QUANTIZE_POSITION != 0 {
+		nd.Vertices = d.quantizedVec3s(n)
+	} else {
+		nd.Vertices = d.vec3s(n)
+	}
+	if n := d.count("normal count", maxVertices); nd.Quantization&QUANTIZE_NORMAL_OCT16 != 0 {
+		nd.Normals = d.octNormals16(n)
+	} else if nd.Quantization&QUANTIZE_NORMAL != 0 {
+		nd.Normals = d.octNormals(n)
+	} else {
+		nd.Normals = d.vec3s(n)
+	}
+	nd.Colors = d.colors(d.count("color count", maxVertices))
+	if n := d.count("texcoord count", maxVertices); nd.Quantization&QUANTIZE_TEXCOORD_HALF != 0 {
+		nd.TexCoords = d.halfVec2s(n)
+	} else if nd.Quantization&QUANTIZE_TEXCOORD != 0 {
+		nd.TexCoords = d.quantizedVec2s(n)
+	} else {
+		nd.TexCoords = d.vec2s(n)
+	}
+	var isMat uint8
+	d.read(&isMat)
+	if isMat == 1 {
+		nd.Mat = d.mat()
+	}
+	n := d.count("face group count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	nd.FaceGroup = make([]*MeshTriangle, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		g := d.meshTriangle(width)
+		if d.caps().FaceIndices {
+			d.faceIndices(g, width)
+		}
+		if d.caps().FaceFeatures {
+			d.faceFeatures(g)
+		}
+		nd.FaceGroup = append(nd.FaceGroup, g)
+	}
+	n = d.count("edge group count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	nd.EdgeGroup = make([]*MeshOutline, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		g := d.meshOutline(width)
+		if d.caps().OutlineStyles {
+			d.outlineStyle(g)
+		}
+		nd.EdgeGroup = append(nd.EdgeGroup, g)
+	}
+	if d.caps().NodeProps {
+		nd.Props = d.props(0)
+	}
+	if d.caps().MorphTargets {
+		nd.MorphTargets = d.morphTargets()
+	}
+	if d.caps().Hierarchy {
+		d.hierarchy(nd)
+	}
+	if d.caps().TexCoords2 {
+		d.texCoords2(nd, width)
+	}
+	if d.caps().Lightmaps {
+		d.lightmap(nd)
+	}
+	if d.caps().Polygons {
+		d.polygons(nd, width)
+	}
+	if d.err == nil && d.caps().FaceIndices {
+		if err := nd.validateFaceIndices(); err != nil {
+			d.fail(err)
+		}
+	}
+	return nd
+}
+
+func (d *decoder) meshNodes() []*MeshNode {
+	n := d.count("node count", func(l *DecodeLimits) uint32 { return l.MaxNodes })
+	nds := make([]*MeshNode, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		nds = append(nds, d.meshNode())
+		d.tick(PROGRESS_STAGE_NODES, i+1, n)
+	}
+	if d.err == nil && d.caps().Hierarchy {
+		if err := validateHierarchy(nds); err != nil {
+			d.fail(err)
+		}
+	}
+	return nds
+}
+
+func (d *decoder) baseMesh() *BaseMesh {
+	ms := &BaseMesh{}
+	ms.Materials = d.materials()
+	ms.Nodes = d.meshNodes()
+	if d.caps().Code {
+		d.read(&ms.Code)
+	}
+	return ms
+}
+
+func (d *decoder) instanceNode() *InstanceMesh {
+	inst := &InstanceMesh{}
+	n := d.count("transform count", func(l *DecodeLimits) uint32 { return l.MaxTransforms })
+	inst.Transfors = make([]*dmat.T, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		inst.Transfors = append(inst.Transfors, d.mat())
+	}
+	n = d.count("feature count", func(l *DecodeLimits) uint32 { return l.MaxTransforms })
+	if !d.caps().Features64 {
+		fs := d.uint32s(n)
+		inst.Features = make([]uint64, len(fs))
+		for i, f := range fs {
+			inst.Features[i] = uint64(f)
+		}
+	} else {
+		inst.Features = d.uint64s(n)
+	}
+	inst.BBox = &[6]float64{}
+	d.read(inst.BBox)
+	d.depth++
+	inst.Mesh = d.baseMesh()
+	d.depth--
+	d.read(&inst.Hash)
+	if d.caps().Props {
+		inst.Props = d.props(0)
+	}
+	if d.caps().InstanceRefs {
+		inst.Ref = d.instanceRef()
+		if inst.Ref != nil {
+			inst.Mesh = nil
+		}
+	}
+	if d.caps().InstanceBounds {
+		d.instanceBounds(inst)
+	}
+	return inst
+}
+
+func (d *decoder) instanceNodes() []*InstanceMesh {
+	n := d.count("instance count", func(l *DecodeLimits) uint32 { return l.MaxInstances })
+	nds := make([]*InstanceMesh, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		nds = append(nds, d.instanceNode())
+		d.tick(PROGRESS_STAGE_INSTANCES, i+1, n)
+	}
+	return nds
+}
+
+func (d *decoder) header() bool {
+	sig := make([]byte, len(MESH_SIGNATURE))
+	if _, err := io.ReadFull(d.rd, sig); err != nil {
+		d.fail(err)
+		return false
+	}
+	if d.limits != nil && string(sig) != MESH_SIGNATURE {
+		d.fail(ErrInvalidSignature)
+		return false
+	}
+	if !d.read(&d.v) {
+		return false
+	}
+	if d.limits != nil && !IsSupportedVersion(d.v) {
+		d.fail(ErrUnsupportedVersion)
+		return false
+	}
+	caps := d.caps()
+	if caps.HeaderFlags {
+		d.read(&d.flags)
+		if d.limits != nil && d.flags&^caps.KnownFlags != 0 {
+			d.fail(fmt.Errorf("mst: unknown header flags %x", d.flags&^caps.KnownFlags))
+			return false
+		}
+		if d.flags&MESH_FLAG_COMPRESSED != 0 {
+			d.codec = d.compression()
+		}
+		if d.flags&MESH_FLAG_SECTION_TABLE != 0 {
+			d.table = d.sectionTable()
+		}
+		if d.flags&MESH_FLAG_MANIFEST != 0 {
+			d.manifest = d.bytes(d.count("manifest length", func(l *DecodeLimits) uint32 { return maxManifestSize }))
+		}
+	}
+	return d.err == nil
+}
+
+func (d *decoder) mesh() *Mesh {
+	ms := &Mesh{}
+	d.header()
+	ms.Version = d.v
+	caps := d.caps()
+	var cr *checksumReader
+	if d.flags&MESH_FLAG_CHECKSUM != 0 {
+		cr = newChecksumReader(d.rd, headerBytes(d.v, d.flags, d.codec, d.table, d.manifest))
+		d.rd = cr
+	}
+	d.section(func() {
+		ms.Materials = d.materials()
+		d.tick(PROGRESS_STAGE_MATERIALS, len(ms.Materials), len(ms.Materials))
+	})
+	d.nextSection(cr)
+	d.section(func() {
+		ms.Nodes = d.meshNodes()
+		if caps.Code {
+			d.read(&ms.Code)
+		}
+	})
+	d.nextSection(cr)
+	d.section(func() {
+		ms.InstanceNode = d.instanceNodes()
+		if caps.Code {
+			d.read(&ms.Code)
+		}
+	})
+	d.nextSection(cr)
+	d.section(func() {
+		if caps.Props {
+			ms.Props = d.props(0)
+		}
+		if caps.Units {
+			d.read(&ms.Units)
+		}
+	})
+	d.nextSection(cr)
+	if caps.Animations {
+		d.section(func() {
+			ms.Animations = d.animations()
+			d.tick(PROGRESS_STAGE_ANIMATIONS, len(ms.Animations), len(ms.Animations))
+		})
+		d.nextSection(cr)
+	}
+	if cr != nil {
+		d.rd = cr.rd
+		d.footer(cr)
+	}
+	return ms
+}
+
+func (d *decoder) nextSection(cr *checksumReader) {
+	if cr != nil {
+		cr.next()
+	}
+}
+
+// Deprecated: Use NewDecoder with DecodeOptions.Limits.
+func MeshUnMarshalWithLimits(rd io.Reader, limits *DecodeLimits) (*Mesh, error) {
+	return MeshUnMarshalWithOptions(rd, &DecodeOptions{Limits: limits, VerifyIntegrity: true})
+}
@@ -0,0 +1,3757 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/ext/specular"
+)
+
+func TestMeshUnMarshalWithLimits(t *testing.T) {
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, newTestMesh())
+	ms, err := MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Nodes) != 2 || len(ms.InstanceNode) != 1 || len(ms.Materials) != 2 {
+		t.Fatal("unexpected mesh content")
+	}
+
+	limits := DefaultDecodeLimits
+	limits.MaxVertices = 4
+	_, err = MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &limits)
+	var le *LimitError
+	if !errors.As(err, &le) || le.Field != "vertex count" {
+		t.Fatalf("expected vertex limit error, got %v", err)
+	}
+
+	if _, err = MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), &DefaultDecodeLimits); err == nil {
+		t.Fatal("expected error on truncated input")
+	}
+	if _, err = MeshUnMarshalWithLimits(bytes.NewReader([]byte("abcd\x04\x00\x00\x00")), &DefaultDecodeLimits); err != ErrInvalidSignature {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+}
+
+func FuzzMeshUnMarshal(f *testing.F) {
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, newTestMesh())
+	f.Add(buf.Bytes())
+	f.Add([]byte(MESH_SIGNATURE + "\x04\x00\x00\x00\xff\xff\xff\xff"))
+	buf.Reset()
+	MeshMarshal(buf, GenerateMesh(&GenerateOptions{Seed: 2, Nodes: 1, Subdivisions: 1, Materials: 1, Textures: 1, TextureSize: 2, Instances: 1, Transforms: 1, Props: 4}), WithChecksum())
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ms, err := MeshUnMarshalWithLimits(bytes.NewReader(data), &DefaultDecodeLimits)
+		if err != nil {
+			return
+		}
+		MeshMarshal(&bytes.Buffer{}, ms)
+	})
+}
+
+func TestTextureCompressionDetect(t *testing.T) {
+	raw := make([]byte, 2*2*4)
+	for i := range raw {
+		raw[i] = byte(i * 7)
+	}
+	tex := &Texture{Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_RGBA, Compressed: TEXTURE_COMPRESSED_NONE, Data: raw}
+	if err := tex.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	img, err := LoadTexture(tex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, img)
+	src := &Texture{Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_RGBA, Compressed: TEXTURE_COMPRESSED_SOURCE, Data: pngBuf.Bytes()}
+	if DetectTextureCompression(src.Data) != TEXTURE_COMPRESSED_SOURCE {
+		t.Fatal("png not detected")
+	}
+	px, err := src.Pixels()
+	if err != nil || !bytes.Equal(px, raw) {
+		t.Fatal("source pixels mismatch")
+	}
+
+	zl := &Texture{Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_RGBA, Compressed: TEXTURE_COMPRESSED_ZLIB, Data: CompressImage(raw)}
+	if DetectTextureCompression(zl.Data) != TEXTURE_COMPRESSED_ZLIB {
+		t.Fatal("zlib not detected")
+	}
+	zl.Compressed = TEXTURE_COMPRESSED_SOURCE
+	var ce *TextureCompressionError
+	if !errors.As(zl.Validate(), &ce) || ce.Detected != TEXTURE_COMPRESSED_ZLIB {
+		t.Fatal("expected compression mismatch")
+	}
+}
+
+func TestMeshChecksumFooter(t *testing.T) {
+	ms := newTestMesh()
+	ms.Props = Properties{"name": "tile", "level": int64(3), "nested": map[string]interface{}{"ok": true}}
+	ms.InstanceNode[0].Props = Properties{"height": 1.5}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms, WithChecksum())
+
+	dec, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Version != V6 || dec.Props["name"] != "tile" || dec.Props["level"] != int64(3) || dec.InstanceNode[0].Props["height"] != 1.5 {
+		t.Fatal("unexpected decoded mesh")
+	}
+	if nested, ok := dec.Props["nested"].(map[string]interface{}); !ok || nested["ok"] != true {
+		t.Fatal("nested props lost")
+	}
+
+	bad := append([]byte{}, buf.Bytes()...)
+	bad[len(bad)/2] ^= 0xff
+	_, err = MeshUnMarshalWithOptions(bytes.NewReader(bad), &DefaultDecodeOptions)
+	var ce *ChecksumError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if _, err = MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()[:buf.Len()-6]), &DefaultDecodeOptions); err == nil {
+		t.Fatal("expected error on truncated footer")
+	}
+	if _, err = MeshUnMarshalWithOptions(bytes.NewReader(bad), &DecodeOptions{}); errors.As(err, &ce) {
+		t.Fatal("checksum verified although disabled")
+	}
+}
+
+func TestExtractNode(t *testing.T) {
+	ms := newTestMesh()
+	ms.Nodes[1].EdgeGroup = []*MeshOutline{{Batchid: 1, Edges: [][2]uint32{{0, 1}}}}
+	mt := dmat.Ident
+	ms.Nodes[0].Mat = &mt
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	ex, err := ExtractNodeFrom(bytes.NewReader(buf.Bytes()), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Nodes) != 1 || len(ex.Materials) != 1 || ex.Nodes[0].FaceGroup[0].Batchid != 0 {
+		t.Fatal("unexpected extracted node")
+	}
+	if _, ok := ex.Materials[0].(*PbrMaterial); !ok {
+		t.Fatal("wrong material extracted")
+	}
+	if _, err := ExtractNodeFrom(bytes.NewReader(buf.Bytes()), "5"); err != ErrNodeNotFound {
+		t.Fatal("expected not found")
+	}
+
+	ms.Nodes[1].Name = "wall"
+	buf.Reset()
+	MeshMarshal(buf, ms, WithSectionTable())
+	for _, rd := range []func(string) (*Mesh, error){
+		func(sel string) (*Mesh, error) { return ExtractNodeFrom(bytes.NewReader(buf.Bytes()), sel) },
+		func(sel string) (*Mesh, error) { return ExtractNodeAt(bytes.NewReader(buf.Bytes()), sel) },
+	} {
+		ex, err := rd("wall")
+		if err != nil || ex.Nodes[0].Name != "wall" || len(ex.Nodes[0].EdgeGroup) != 1 {
+			t.Fatalf("node not extracted by name: %v", err)
+		}
+		if _, err := rd("roof"); err != ErrNodeNotFound {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+}
+
+func TestFormatFeatures(t *testing.T) {
+	caps := reflect.ValueOf(FormatCapabilities(MESH_LATEST_VERSION))
+	for i := 0; i < caps.NumField(); i++ {
+		name := caps.Type().Field(i).Name
+		if f := caps.Field(i); f.Kind() == reflect.Bool && !f.Bool() && name != "PbrPadding" {
+			t.Errorf("capability %s is not in formatFeatures", name)
+		}
+	}
+	for i := 1; i < len(formatFeatures); i++ {
+		if formatFeatures[i].since < formatFeatures[i-1].since {
+			t.Fatalf("formatFeatures not ordered by version at %d", i)
+		}
+	}
+}
+
+func TestFormatCapabilitiesRoundTrip(t *testing.T) {
+	for v := uint32(V1); v <= MESH_LATEST_VERSION; v++ {
+		ms := newTestMesh()
+		ms.Version = v
+		ms.Props = nil
+		for _, inst := range ms.InstanceNode {
+			inst.Props = nil
+		}
+		buf := &bytes.Buffer{}
+		MeshMarshal(buf, ms)
+		out, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions)
+		if err != nil {
+			t.Fatalf("v%d: %v", v, err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("v%d: %d trailing bytes", v, buf.Len())
+		}
+		if len(out.InstanceNode) != 1 || len(out.InstanceNode[0].Features) != len(ms.InstanceNode[0].Features) {
+			t.Fatalf("v%d: instance features not preserved", v)
+		}
+		if want, _ := ConvertVersion(ms, v); !out.Equal(want) {
+			d, _ := Diff(want, out, DiffOptions{})
+			t.Fatalf("v%d: round trip changed the mesh: %+v", v, d)
+		}
+	}
+	if IsSupportedVersion(MESH_LATEST_VERSION + 1) {
+		t.Fatal("unexpected supported version")
+	}
+}
+
+func BenchmarkMeshUnMarshal(b *testing.B) {
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, GenerateMesh(&DefaultGenerateOptions))
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMeshCacheUnMarshal(b *testing.B) {
+	buf := &bytes.Buffer{}
+	if err := MeshCacheMarshal(buf, GenerateMesh(&DefaultGenerateOptions)); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MeshCacheUnMarshal(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConvertVersion(t *testing.T) {
+	ms := newTestMesh()
+	ms.Code = 7
+	ms.Props = Properties{"a": int64(1)}
+	ms.InstanceNode[0].Features = []uint64{1, 1 << 33}
+	out, warns := ConvertVersion(ms, V2)
+	if out.Version != V2 || out.Props != nil || out.Code != 0 {
+		t.Fatal("unsupported fields not dropped")
+	}
+	if len(warns) != 3 {
+		t.Fatalf("expected 3 warnings, got %v", warns)
+	}
+	if out.InstanceNode[0].Features[1] != 0 || ms.InstanceNode[0].Features[1] != 1<<33 {
+		t.Fatal("features not truncated on a copy")
+	}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, out)
+	if _, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions); err != nil {
+		t.Fatal(err)
+	}
+	if _, warns = ConvertVersion(ms, MESH_LATEST_VERSION+1); len(warns) != 1 {
+		t.Fatal("expected unsupported version warning")
+	}
+}
+
+func TestMeshSectionTable(t *testing.T) {
+	ms := newTestMesh()
+	ms.Props = Properties{"name": "cube"}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms, WithSectionTable(), WithChecksum())
+	data := buf.Bytes()
+	if _, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := ReadMeshHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hdr.Sections) != MESH_SECTION_COUNT {
+		t.Fatalf("expected %d sections, got %d", MESH_SECTION_COUNT, len(hdr.Sections))
+	}
+	ra := bytes.NewReader(data)
+	nodes, err := ReadMeshSection(ra, hdr, MESH_SECTION_NODES)
+	if err != nil || len(nodes.Nodes) != 2 {
+		t.Fatalf("nodes section: %v", err)
+	}
+	insts, err := ReadMeshSection(ra, hdr, MESH_SECTION_INSTANCES)
+	if err != nil || len(insts.InstanceNode) != 1 {
+		t.Fatalf("instances section: %v", err)
+	}
+	props, err := ReadMeshSection(ra, hdr, MESH_SECTION_PROPS)
+	if err != nil || props.Props["name"] != "cube" {
+		t.Fatalf("props section: %v", err)
+	}
+	buf.Reset()
+	MeshMarshal(buf, ms)
+	hdr, _ = ReadMeshHeader(bytes.NewReader(buf.Bytes()))
+	if _, err := ReadMeshSection(bytes.NewReader(buf.Bytes()), hdr, MESH_SECTION_NODES); err != ErrNoSectionTable {
+		t.Fatalf("expected ErrNoSectionTable, got %v", err)
+	}
+}
+
+func TestProvenanceTolerances(t *testing.T) {
+	ms := newTestMesh()
+	tol := DefaultTolerances
+	tol.WeldEpsilon = 0.01
+	ms.RecordProvenance("weld", &tol)
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov := out.Provenance()
+	if len(prov) != 1 || prov[0]["op"] != "weld" {
+		t.Fatalf("unexpected provenance %v", prov)
+	}
+	stored, _ := prov[0]["tolerances"].(map[string]interface{})
+	if got := TolerancesFromProps(stored); *got != tol {
+		t.Fatalf("expected %+v, got %+v", tol, *got)
+	}
+}
+
+func TestMeshOpenURL(t *testing.T) {
+	ms := newTestMesh()
+	ms.Props = Properties{"name": "cube"}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms, WithSectionTable())
+	data := buf.Bytes()
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Range") == "" {
+			t.Error("request without range header")
+		}
+		http.ServeContent(w, r, "mesh.mst", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	rm, err := MeshOpenURL(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := rm.Node(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.Nodes) != 1 || len(nd.Materials) != 1 {
+		t.Fatal("unexpected node content")
+	}
+	props, err := rm.Section(MESH_SECTION_PROPS)
+	if err != nil || props.Props["name"] != "cube" {
+		t.Fatalf("props section: %v", err)
+	}
+	if requests != 4 {
+		t.Fatalf("expected 4 range requests, got %d", requests)
+	}
+}
+
+func TestPropertiesAccessors(t *testing.T) {
+	props := Properties{}
+	props.SetString("name", "tower")
+	props.SetInt("floors", 12)
+	props.SetMap("building", Properties{"floor": map[string]interface{}{"height": 3.5}})
+	if v, ok := props.GetString("name"); !ok || v != "tower" {
+		t.Fatal("GetString failed")
+	}
+	if v, ok := props.GetFloat("floors"); !ok || v != 12 {
+		t.Fatal("GetFloat should accept integers")
+	}
+	if _, ok := props.GetBool("name"); ok {
+		t.Fatal("GetBool accepted a string")
+	}
+	if v, ok := props.Lookup("building.floor.height"); !ok || v != 3.5 {
+		t.Fatalf("Lookup failed: %v", v)
+	}
+	if _, ok := props.Lookup("building.roof.height"); ok {
+		t.Fatal("Lookup found a missing path")
+	}
+	props.Merge(Properties{"building": Properties{"floor": Properties{"area": int64(100)}}})
+	if v, ok := props.Lookup("building.floor.area"); !ok || v != int64(100) {
+		t.Fatal("Merge did not merge nested maps")
+	}
+	if _, ok := props.Lookup("building.floor.height"); !ok {
+		t.Fatal("Merge dropped nested keys")
+	}
+	buf := &bytes.Buffer{}
+	PropertiesMarshal(buf, props)
+	if out, err := PropertiesUnMarshal(buf); err != nil || !out.Equal(props) {
+		t.Fatal("decoded properties not equal")
+	}
+}
+
+func TestPropertiesLimits(t *testing.T) {
+	props := Properties{strings.Repeat("k", 200): "long key"}
+	for i := 0; i < 5000; i++ {
+		props.SetInt(fmt.Sprintf("attr_%d", i), int64(i))
+	}
+	buf := &bytes.Buffer{}
+	PropertiesMarshal(buf, props)
+	data := buf.Bytes()
+	out, err := PropertiesUnMarshalWithLimits(bytes.NewReader(data), &DefaultDecodeLimits)
+	if err != nil || !out.Equal(props) {
+		t.Fatalf("large properties not decoded: %v", err)
+	}
+	limits := DefaultDecodeLimits
+	limits.MaxPropKeyLength = 100
+	var lerr *LimitError
+	if _, err := PropertiesUnMarshalWithLimits(bytes.NewReader(data), &limits); !errors.As(err, &lerr) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+	if _, err := PropertiesUnMarshal(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Fatal("expected error for truncated properties")
+	}
+}
+
+func TestMeshManifest(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/manifest.mst"
+	if err := MeshWriteTo(path, newTestMesh(), WithManifest(&Manifest{CRS: "EPSG:4326"}), WithSectionTable(), WithChecksum()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := MeshManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CRS != "EPSG:4326" || m.Nodes != 2 || m.Instances != 1 || m.Transforms != 2 || m.Faces != 48 || m.BBox == nil {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	if _, err := MeshReadFrom(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	hdr, _ := ReadMeshHeader(bytes.NewReader(data))
+	if nodes, err := ReadMeshSection(bytes.NewReader(data), hdr, MESH_SECTION_NODES); err != nil || len(nodes.Nodes) != 2 {
+		t.Fatalf("section table offsets wrong with manifest: %v", err)
+	}
+	if err := MeshWriteTo(path, newTestMesh()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MeshManifest(path); err != ErrNoManifest {
+		t.Fatalf("expected ErrNoManifest, got %v", err)
+	}
+}
+
+func TestInstanceRefs(t *testing.T) {
+	dir := t.TempDir()
+	proto := NewMesh()
+	proto.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{1, 2, 3}}}
+	proto.Nodes = []*MeshNode{newTestCubeNode()}
+	if err := MeshWriteTo(dir+"/proto.mst", proto); err != nil {
+		t.Fatal(err)
+	}
+	ms := NewMesh()
+	ms.InstanceNode = []*InstanceMesh{{
+		Transfors: []*dmat.T{&dmat.Ident},
+		BBox:      &[6]float64{0, 0, 0, 1, 1, 1},
+		Ref:       &InstanceRef{URI: "proto.mst", Hash: ComputeBaseMeshHash(&proto.BaseMesh)},
+	}}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DecodeOptions{Limits: &DefaultDecodeLimits, Resolver: NewFilePrototypeResolver(dir)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V7 || out.InstanceNode[0].Mesh == nil || len(out.InstanceNode[0].Mesh.Nodes) != 1 {
+		t.Fatal("instance reference not resolved")
+	}
+	ms.InstanceNode[0].Ref.Hash++
+	buf.Reset()
+	MeshMarshal(buf, ms)
+	_, err = MeshUnMarshalWithOptions(buf, &DecodeOptions{Limits: &DefaultDecodeLimits, Resolver: NewFilePrototypeResolver(dir)})
+	var herr *PrototypeHashError
+	if !errors.As(err, &herr) {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+}
+
+func TestValidateProps(t *testing.T) {
+	schema := NewPropsSchema().Require("feature_class", PROP_TYPE_STRING).Require("source_epsg", PROP_TYPE_INT)
+	schema.Field("extent", &PropSchema{Type: PROP_TYPE_MAP, Required: true, Fields: NewPropsSchema().Require("min", PROP_TYPE_FLOAT)})
+	schema.Field("tags", &PropSchema{Type: PROP_TYPE_ARRAY, Items: &PropSchema{Type: PROP_TYPE_STRING}})
+
+	ms := NewMesh()
+	ms.Props = Properties{"feature_class": "building", "source_epsg": int64(4326), "extent": map[string]interface{}{"min": int64(0)}, "tags": []interface{}{"a"}}
+	if err := ms.ValidateProps(schema); err != nil {
+		t.Fatal(err)
+	}
+	ms.Props = Properties{"source_epsg": "4326", "extent": map[string]interface{}{}, "tags": []interface{}{"a", true}}
+	err := ms.ValidateProps(schema)
+	var verr *PropsValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 4 {
+		t.Fatalf("unexpected validation result %v", err)
+	}
+	if !verr.Errors[0].Missing || verr.Errors[0].Path != "extent.min" || verr.Errors[3].Path != "tags[1]" {
+		t.Fatalf("unexpected errors %v", err)
+	}
+}
+
+func TestNodePropsDefaultVersion(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{255, 0, 0}}}
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	nd.Props = Properties{"feature_class": "wall"}
+	ms.Nodes = []*MeshNode{nd}
+	inst := &InstanceMesh{Transfors: []*dmat.T{&dmat.Ident}, BBox: &[6]float64{}, Mesh: &BaseMesh{Nodes: []*MeshNode{{Props: Properties{"lod": "1"}}}}}
+	for _, insts := range [][]*InstanceMesh{nil, {inst}} {
+		if insts != nil {
+			nd.Props = nil
+		}
+		ms.InstanceNode = insts
+		buf := &bytes.Buffer{}
+		MeshMarshal(buf, ms)
+		out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Version < V8 || !out.Nodes[0].Props.Equal(nd.Props) {
+			t.Fatalf("node properties not preserved at version %d: %v", out.Version, out.Nodes[0].Props)
+		}
+		if insts != nil && !out.InstanceNode[0].Mesh.Nodes[0].Props.Equal(inst.Mesh.Nodes[0].Props) {
+			t.Fatal("instance node properties not preserved")
+		}
+	}
+}
+
+func TestNodeProps(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V8
+	ms.Nodes[0].Props = Properties{"feature_class": "wall"}
+	ms.Nodes[1].Props = Properties{"level": int64(3)}
+	ms.InstanceNode[0].Mesh.Nodes[0].Props = Properties{"kind": "tree"}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Nodes[0].Props.Equal(ms.Nodes[0].Props) || !out.Nodes[1].Props.Equal(ms.Nodes[1].Props) || !out.InstanceNode[0].Mesh.Nodes[0].Props.Equal(ms.InstanceNode[0].Mesh.Nodes[0].Props) {
+		t.Fatal("node properties not preserved")
+	}
+	ex, err := ExtractNodeFrom(bytes.NewReader(data), "1")
+	if err != nil || !ex.Nodes[0].Props.Equal(ms.Nodes[1].Props) {
+		t.Fatalf("extracted node properties not preserved: %v", err)
+	}
+
+	bt, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec Mesh
+	if err := json.Unmarshal(bt, &dec); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Nodes[1].Props["level"] != int64(3) || dec.InstanceNode[0].Mesh.Nodes[0].Props["kind"] != "tree" {
+		t.Fatalf("json node properties not preserved: %#v", dec.Nodes[1].Props)
+	}
+
+	old, warns := ConvertVersion(ms, V7)
+	if len(warns) != 3 || old.Nodes[0].Props != nil || ms.Nodes[0].Props == nil {
+		t.Fatalf("unexpected conversion warnings %v", warns)
+	}
+
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	extras, ok := doc.Nodes[0].Extras.(map[string]interface{})
+	if !ok || extras["feature_class"] != "wall" || doc.Meshes[len(doc.Meshes)-1].Extras == nil {
+		t.Fatal("node properties not exported to glTF extras")
+	}
+}
+
+func TestGltfPropsRoundTrip(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V8
+	ms.Props = Properties{"name": "site", "floors": int64(4), "tags": []interface{}{"a", "b"}}
+	ms.Nodes[0].Props = Properties{"feature_class": "wall"}
+	ms.InstanceNode[0].Props = Properties{"species": "oak", "height": 12.5}
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(dec); err != nil {
+		t.Fatal(err)
+	}
+	out, err := GltfToMst(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Props.Equal(ms.Props) {
+		t.Fatalf("mesh properties not preserved: %v", out.Props)
+	}
+	if len(out.Nodes) != 2 || !out.Nodes[0].Props.Equal(ms.Nodes[0].Props) || out.Nodes[1].Props != nil {
+		t.Fatal("node properties not preserved")
+	}
+	if len(out.InstanceNode) != 1 || !out.InstanceNode[0].Props.Equal(ms.InstanceNode[0].Props) || len(out.InstanceNode[0].Transfors) != len(ms.InstanceNode[0].Transfors) {
+		t.Fatal("instance properties not preserved")
+	}
+	if out.Version != V8 {
+		t.Fatalf("unexpected version %d", out.Version)
+	}
+}
+
+func TestMeshCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mst_cache")
+	defer os.RemoveAll(dir)
+	src := dir + "/src.mst"
+	ms := newTestMesh()
+	ms.Props = Properties{"list": []interface{}{int64(1), "x"}, "nested": map[string]interface{}{"ok": true}}
+	ms.Animations = []*Animation{{Name: "spin", Tracks: []*AnimationTrack{{Path: ANIMATION_PATH_ROTATION, Times: []float32{0, 1}, Values: []float32{0, 0, 0, 1, 0, 0, 1, 0}}}}}
+	if err := MeshWriteTo(src, ms); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewMeshCache(dir + "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := cache.Load(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := SourceHash(src)
+	cached, err := cache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(first)
+	b, _ := json.Marshal(cached)
+	if !bytes.Equal(a, b) {
+		t.Fatal("cached mesh differs from decoded mesh")
+	}
+	if !reflect.DeepEqual(cached.Animations, ms.Animations) {
+		t.Fatal("cache lost animations")
+	}
+
+	ms.Props = Properties{"changed": true}
+	if err := MeshWriteTo(src, ms); err != nil {
+		t.Fatal(err)
+	}
+	second, err := cache.Load(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := second.Props.GetBool("changed"); !v {
+		t.Fatal("stale cache entry returned after source change")
+	}
+	if _, err := cache.Get("missing"); err != ErrCacheMiss {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func newTestStripNode(n int) *MeshNode {
+	nd := &MeshNode{Vertices: make([]vec3.T, n), Colors: make([][3]byte, n)}
+	for i := range nd.Vertices {
+		nd.Vertices[i] = vec3.T{float32(i), float32(i % 2), 0}
+		nd.Colors[i] = [3]byte{byte(i), 0, 0}
+	}
+	g := &MeshTriangle{}
+	for i := 0; i+2 < n; i++ {
+		g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{uint32(i), uint32(i + 1), uint32(i + 2)}})
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.EdgeGroup = []*MeshOutline{{Edges: [][2]uint32{{0, uint32(n - 1)}}}}
+	return nd
+}
+
+func TestIndexWidth(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V9
+	ms.Nodes[1].IndexWidth = INDEX_WIDTH_32
+	ms.Nodes = append(ms.Nodes, newTestStripNode(70000))
+	if w := ms.Nodes[0].GetIndexWidth(); w != INDEX_WIDTH_16 {
+		t.Fatalf("unexpected index width %d", w)
+	}
+	if w := ms.Nodes[2].GetIndexWidth(); w != INDEX_WIDTH_32 {
+		t.Fatalf("unexpected index width %d", w)
+	}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, nd := range ms.Nodes {
+		got := out.Nodes[i]
+		if len(got.Vertices) != len(nd.Vertices) || len(got.FaceGroup[0].Faces) != len(nd.FaceGroup[0].Faces) {
+			t.Fatalf("node %d not preserved", i)
+		}
+		last := len(nd.FaceGroup[0].Faces) - 1
+		if got.FaceGroup[0].Faces[last].Vertex != nd.FaceGroup[0].Faces[last].Vertex {
+			t.Fatalf("node %d faces not preserved", i)
+		}
+	}
+	if out.Nodes[2].EdgeGroup[0].Edges[0] != [2]uint32{0, 69999} {
+		t.Fatal("edges not preserved")
+	}
+	ex, err := ExtractNodeFrom(bytes.NewReader(data), "2")
+	if err != nil || len(ex.Nodes[0].Vertices) != 70000 {
+		t.Fatalf("extract after wide node failed: %v", err)
+	}
+
+	ms.Nodes = ms.Nodes[:1]
+	narrow, wide := &bytes.Buffer{}, &bytes.Buffer{}
+	NewEncoder(narrow).EncodeNode(ms.Nodes[0])
+	ms.Nodes[0].IndexWidth = INDEX_WIDTH_32
+	NewEncoder(wide).EncodeNode(ms.Nodes[0])
+	if narrow.Len() >= wide.Len() {
+		t.Fatalf("16-bit node encoding is not smaller: %d >= %d", narrow.Len(), wide.Len())
+	}
+}
+
+func TestSplitLargeNodes(t *testing.T) {
+	ms := newTestMesh()
+	ms.Nodes = append(ms.Nodes, newTestStripNode(2500))
+	ms.Nodes[2].Props = Properties{"id": "strip"}
+	if err := ms.SplitLargeNodes(2); err == nil {
+		t.Fatal("expected error for tiny limit")
+	}
+	if err := ms.SplitLargeNodes(1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Nodes) != 5 {
+		t.Fatalf("unexpected node count %d", len(ms.Nodes))
+	}
+	faces, edges := 0, 0
+	for _, nd := range ms.Nodes[2:] {
+		if len(nd.Vertices) > 1000 || len(nd.Colors) != len(nd.Vertices) || nd.Props["id"] != "strip" {
+			t.Fatalf("bad split node with %d vertices", len(nd.Vertices))
+		}
+		if err := nd.validateVertexIndices(); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range nd.FaceGroup[0].Faces {
+			a, b := nd.Vertices[f.Vertex[0]], nd.Vertices[f.Vertex[1]]
+			if b[0]-a[0] != 1 {
+				t.Fatal("split faces reference wrong vertices")
+			}
+			faces++
+		}
+		for _, g := range nd.EdgeGroup {
+			edges += len(g.Edges)
+		}
+	}
+	if faces != 2498 || edges != 1 {
+		t.Fatalf("split lost primitives: %d faces, %d edges", faces, edges)
+	}
+	if len(ms.InstanceNode[0].Mesh.Nodes) != 1 {
+		t.Fatal("small instance node should not be split")
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V9
+	ms.Nodes[0].Props = Properties{"id": int64(1)}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	if err := enc.SetVersion(MESH_LATEST_VERSION + 1); err != ErrUnsupportedVersion {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := enc.EncodeMaterials(ms.Materials); err != nil {
+		t.Fatal(err)
+	}
+	enc.EncodeNodes(ms.Nodes)
+	enc.EncodeInstances(ms.InstanceNode)
+	enc.EncodeProps(Properties{"k": "v"})
+	if err := enc.Encode(ms); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(buf, nil)
+	mtls, err := dec.DecodeMaterials()
+	if err != nil || len(mtls) != 2 {
+		t.Fatalf("materials: %v", err)
+	}
+	nds, err := dec.DecodeNodes()
+	if err != nil || len(nds) != 2 || !nds[0].Props.Equal(ms.Nodes[0].Props) {
+		t.Fatalf("nodes: %v", err)
+	}
+	insts, err := dec.DecodeInstances()
+	if err != nil || len(insts) != 1 || len(insts[0].Transfors) != 2 {
+		t.Fatalf("instances: %v", err)
+	}
+	props, err := dec.DecodeProps()
+	if err != nil || props["k"] != "v" {
+		t.Fatalf("props: %v", err)
+	}
+	out, err := dec.Decode()
+	if err != nil || out.Version != V9 || len(out.Nodes) != 2 {
+		t.Fatalf("mesh: %v", err)
+	}
+	if _, err := dec.DecodeNode(); err == nil {
+		t.Fatal("expected error at end of stream")
+	}
+
+	old := &bytes.Buffer{}
+	enc = NewEncoder(old, WithChecksum())
+	enc.SetVersion(V6)
+	if err := enc.Encode(ms); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := NewDecoder(old, nil).Decode(); err != nil || out.Version != V8 || !out.Nodes[0].Props.Equal(ms.Nodes[0].Props) {
+		t.Fatalf("versioned encode: %v", err)
+	}
+	if err := NewEncoder(failWriter{}).Encode(ms); err == nil {
+		t.Fatal("expected write error")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+var updateAPI = flag.Bool("update-api", false, "rewrite tests/api.txt with the current exported API")
+
+func apiExpr(fset *token.FileSet, node interface{}) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, node)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+func apiFields(fset *token.FileSet, fl *ast.FieldList) string {
+	if fl == nil {
+		return ""
+	}
+	var types []string
+	for _, f := range fl.List {
+		ft := apiExpr(fset, f.Type)
+		for i := 0; i < len(f.Names) || (i == 0 && len(f.Names) == 0); i++ {
+			types = append(types, ft)
+		}
+	}
+	return strings.Join(types, ", ")
+}
+
+func apiSignature(fset *token.FileSet, ft *ast.FuncType) string {
+	sig := "(" + apiFields(fset, ft.Params) + ")"
+	if res := apiFields(fset, ft.Results); res != "" {
+		if ft.Results.NumFields() > 1 {
+			res = "(" + res + ")"
+		}
+		sig += " " + res
+	}
+	return sig
+}
+
+func apiRecv(fset *token.FileSet, fd *ast.FuncDecl) (string, bool) {
+	if fd.Recv == nil {
+		return "", true
+	}
+	typ := fd.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	id, ok := typ.(*ast.Ident)
+	if !ok || !id.IsExported() {
+		return "", false
+	}
+	return "(" + apiExpr(fset, fd.Recv.List[0].Type) + ") ", true
+}
+
+func apiTypeLines(fset *token.FileSet, ts *ast.TypeSpec) []string {
+	name := ts.Name.Name
+	switch typ := ts.Type.(type) {
+	case *ast.StructType:
+		lines := []string{"type " + name + " struct"}
+		for _, f := range typ.Fields.List {
+			ft := apiExpr(fset, f.Type)
+			if len(f.Names) == 0 {
+				lines = append(lines, "type "+name+" struct, embedded "+ft)
+			}
+			for _, n := range f.Names {
+				if n.IsExported() {
+					lines = append(lines, "type "+name+" struct, "+n.Name+" "+ft)
+				}
+			}
+		}
+		return lines
+	case *ast.InterfaceType:
+		lines := []string{"type " + name + " interface"}
+		for _, m := range typ.Methods.List {
+			for _, n := range m.Names {
+				lines = append(lines, "type "+name+" interface, "+n.Name+apiSignature(fset, m.Type.(*ast.FuncType)))
+			}
+			if len(m.Names) == 0 {
+				lines = append(lines, "type "+name+" interface, embedded "+apiExpr(fset, m.Type))
+			}
+		}
+		return lines
+	}
+	return []string{"type " + name + " " + apiExpr(fset, ts.Type)}
+}
+
+func qualifyImports(f *ast.File) {
+	paths := make(map[string]string)
+	for _, imp := range f.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		paths[name] = p
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && paths[id.Name] != "" {
+				id.Name = paths[id.Name]
+			}
+		}
+		return true
+	})
+}
+
+func exportedAPI(dir string) ([]string, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var lines []string
+	for _, name := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		qualifyImports(f)
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				recv, ok := apiRecv(fset, d)
+				if !ok || !d.Name.IsExported() {
+					continue
+				}
+				lines = append(lines, "func "+recv+d.Name.Name+apiSignature(fset, d.Type))
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.IsExported() {
+							lines = append(lines, apiTypeLines(fset, s)...)
+						}
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							if !n.IsExported() {
+								continue
+							}
+							line := strings.ToLower(d.Tok.String()) + " " + n.Name
+							if s.Type != nil {
+								line += " " + apiExpr(fset, s.Type)
+							}
+							lines = append(lines, line)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+func TestAPICompatibility(t *testing.T) {
+	lines, err := exportedAPI(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("tests", "api.txt")
+	if *updateAPI {
+		if err := ioutil.WriteFile(golden, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := make(map[string]bool, len(lines))
+	for _, l := range lines {
+		current[l] = true
+	}
+	recorded := make(map[string]bool)
+	for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		recorded[l] = true
+		if !current[l] {
+			t.Errorf("incompatible API change, removed: %s", l)
+		}
+	}
+	for _, l := range lines {
+		if !recorded[l] {
+			t.Errorf("unrecorded API addition: %s (run go test -run TestAPICompatibility -update-api)", l)
+		}
+	}
+}
+
+func TestQuantizationDefaultVersion(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	ms.Nodes = []*MeshNode{newTestStripNode(2500)}
+	plain := &bytes.Buffer{}
+	MeshMarshal(plain, ms)
+	ms.SetQuantization(QUANTIZE_POSITION)
+	quant := &bytes.Buffer{}
+	MeshMarshal(quant, ms)
+	if quant.Len() >= plain.Len() {
+		t.Fatalf("quantization ignored on a default mesh: %d vs %d bytes", quant.Len(), plain.Len())
+	}
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(quant.Bytes()), &DefaultDecodeOptions)
+	if err != nil || out.Version < V10 || out.Nodes[0].Quantization != QUANTIZE_POSITION {
+		t.Fatalf("quantized node not preserved: %v", err)
+	}
+
+	ms.SetQuantization(QUANTIZE_POSITION | QUANTIZE_COMPACT)
+	compact := &bytes.Buffer{}
+	MeshMarshal(compact, ms)
+	if out, err := MeshUnMarshalWithOptions(bytes.NewReader(compact.Bytes()), &DefaultDecodeOptions); err != nil || out.Version < V22 {
+		t.Fatalf("compact attributes not written: %v", err)
+	}
+}
+
+func TestQuantization(t *testing.T) {
+	for _, n := range []vec3.T{{0, 0, 1}, {0, 0, -1}, {0.6, -0.8, 0}, {-0.48, 0.6, -0.64}} {
+		d := OctDecode(OctEncode(n))
+		if dot := n[0]*d[0] + n[1]*d[1] + n[2]*d[2]; dot < 0.9999 {
+			t.Fatalf("octahedral round trip of %v gave %v", n, d)
+		}
+	}
+
+	ms := NewMesh()
+	ms.Version = V10
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	nd := newTestStripNode(2500)
+	for _, v := range nd.Vertices {
+		a := float64(v[0]) / 100
+		nd.Normals = append(nd.Normals, vec3.T{float32(math.Cos(a) * 0.6), float32(math.Sin(a) * 0.6), -0.8})
+		nd.TexCoords = append(nd.TexCoords, vec2.T{v[0] / 2500, v[1]})
+	}
+	ms.Nodes = []*MeshNode{nd, newTestCubeNode()}
+	plain := &bytes.Buffer{}
+	MeshMarshal(plain, ms)
+	ms.SetQuantization(QUANTIZE_ALL)
+	quant := &bytes.Buffer{}
+	MeshMarshal(quant, ms)
+	if quant.Len() >= plain.Len()*3/4 {
+		t.Fatalf("quantized file is not smaller: %d vs %d", quant.Len(), plain.Len())
+	}
+	data := quant.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.Nodes[0]
+	if got.Quantization != QUANTIZE_ALL || len(got.Vertices) != len(nd.Vertices) {
+		t.Fatal("quantized node not preserved")
+	}
+	grid, _ := PositionGrid(nd.Vertices)
+	for i, v := range nd.Vertices {
+		for c := 0; c < 3; c++ {
+			if math.Abs(float64(got.Vertices[i][c]-v[c])) > float64(grid.Scale[c]) {
+				t.Fatalf("vertex %d dequantized to %v, want %v", i, got.Vertices[i], v)
+			}
+		}
+		if math.Abs(float64(got.TexCoords[i][0]-nd.TexCoords[i][0])) > 1e-3 {
+			t.Fatalf("texcoord %d dequantized to %v", i, got.TexCoords[i])
+		}
+		if n := got.Normals[i]; n[0]*nd.Normals[i][0]+n[1]*nd.Normals[i][1]+n[2]*nd.Normals[i][2] < 0.9999 {
+			t.Fatalf("normal %d dequantized to %v", i, n)
+		}
+	}
+	if ex, err := ExtractNodeFrom(bytes.NewReader(data), "1"); err != nil || len(ex.Nodes[0].Vertices) != 8 {
+		t.Fatalf("extract after quantized node failed: %v", err)
+	}
+
+	nan := newTestCubeNode()
+	nan.Vertices[0][0] = float32(math.NaN())
+	nan.Quantization = QUANTIZE_POSITION
+	buf := &bytes.Buffer{}
+	NewEncoder(buf).EncodeNode(nan)
+	back, err := NewDecoder(buf, nil).DecodeNode()
+	if err != nil || back.Quantization != QUANTIZE_NONE || back.Vertices[1] != nan.Vertices[1] {
+		t.Fatalf("non-finite positions should fall back to floats: %v", err)
+	}
+
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.ExtensionsRequired) != 1 || doc.ExtensionsRequired[0] != KHR_MESH_QUANTIZATION {
+		t.Fatalf("unexpected required extensions %v", doc.ExtensionsRequired)
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(dec); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := GltfToMst(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range nd.Vertices {
+		w := imp.Nodes[0].Vertices[i]
+		for c := 0; c < 3; c++ {
+			if math.Abs(float64(w[c]-v[c])) > float64(grid.Scale[c]) {
+				t.Fatalf("glTF vertex %d dequantized to %v, want %v", i, w, v)
+			}
+		}
+	}
+
+	conv, warns := ConvertVersion(ms, V9)
+	if len(warns) != 2 || conv.Nodes[0].Quantization != 0 || conv.Nodes[1].Quantization != 0 || nd.Quantization != QUANTIZE_ALL {
+		t.Fatalf("quantization not dropped below V10: %v", warns)
+	}
+
+	ms.Nodes[1].IndexWidth = INDEX_WIDTH_16
+	cached := &bytes.Buffer{}
+	if err := MeshCacheMarshal(cached, ms); err != nil {
+		t.Fatal(err)
+	}
+	if back, err := MeshCacheUnMarshal(cached); err != nil || back.Nodes[0].Quantization != QUANTIZE_ALL || back.Nodes[1].IndexWidth != INDEX_WIDTH_16 {
+		t.Fatalf("cache lost quantization or index width: %v", err)
+	}
+}
+
+func TestCompactAttributes(t *testing.T) {
+	for _, f := range []float32{0, 1, -2.5, 0.1, 1e-6, 1e-8, 65504, 70000, float32(math.Inf(-1))} {
+		h := Float16Value(Float16Bits(f))
+		if f == 70000 {
+			if !math.IsInf(float64(h), 1) {
+				t.Fatalf("expected %v to overflow, got %v", f, h)
+			}
+		} else if math.Abs(float64(h-f)) > math.Abs(float64(f))/1024+6e-8 {
+			t.Fatalf("half round trip of %v gave %v", f, h)
+		}
+	}
+	for _, n := range []vec3.T{{0, 0, 1}, {0, 0, -1}, {0.6, -0.8, 0}, {-0.48, 0.6, -0.64}} {
+		d := OctDecode16(OctEncode16(n))
+		if dot := n[0]*d[0] + n[1]*d[1] + n[2]*d[2]; dot < 0.999 {
+			t.Fatalf("oct16 round trip of %v gave %v", n, d)
+		}
+	}
+
+	ms := NewMesh()
+	ms.Version = V10
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	nd := newTestStripNode(2500)
+	for _, v := range nd.Vertices {
+		a := float64(v[0]) / 100
+		nd.Normals = append(nd.Normals, vec3.T{float32(math.Cos(a) * 0.6), float32(math.Sin(a) * 0.6), -0.8})
+		nd.TexCoords = append(nd.TexCoords, vec2.T{v[0] / 100, -v[1] * 4})
+	}
+	ms.Nodes = []*MeshNode{nd, newTestCubeNode()}
+	plain := &bytes.Buffer{}
+	MeshMarshal(plain, ms)
+	ms.SetQuantization(QUANTIZE_NORMAL | QUANTIZE_COMPACT)
+	compact := &bytes.Buffer{}
+	MeshMarshal(compact, ms)
+	if saved := plain.Len() - compact.Len(); saved < len(nd.Vertices)*(10+4) {
+		t.Fatalf("compact attributes saved only %d bytes", saved)
+	}
+	if est, err := ms.EstimateSerializedSize(V22); err != nil || est.Total() != int64(compact.Len()) {
+		t.Fatalf("estimate %d does not match %d bytes: %v", est.Total(), compact.Len(), err)
+	}
+	data := compact.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.Nodes[0]
+	if out.Version != V22 || got.Quantization != QUANTIZE_COMPACT {
+		t.Fatalf("compact node not preserved: version %d flags %#x", out.Version, got.Quantization)
+	}
+	for i := range nd.Vertices {
+		if got.Vertices[i] != nd.Vertices[i] {
+			t.Fatalf("vertex %d changed to %v", i, got.Vertices[i])
+		}
+		for c := 0; c < 2; c++ {
+			if w := nd.TexCoords[i][c]; math.Abs(float64(got.TexCoords[i][c]-w)) > math.Abs(float64(w))/1024 {
+				t.Fatalf("texcoord %d stored as %v, want %v", i, got.TexCoords[i], nd.TexCoords[i])
+			}
+		}
+		if n := got.Normals[i]; n[0]*nd.Normals[i][0]+n[1]*nd.Normals[i][1]+n[2]*nd.Normals[i][2] < 0.999 {
+			t.Fatalf("normal %d stored as %v", i, n)
+		}
+	}
+	if ex, err := ExtractNodeFrom(bytes.NewReader(data), "1"); err != nil || len(ex.Nodes[0].Vertices) != 8 {
+		t.Fatalf("extract after compact node failed: %v", err)
+	}
+
+	old, _ := ConvertVersion(ms, V21)
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, old)
+	if back, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions); err != nil || back.Version != V21 || back.Nodes[0].Quantization != QUANTIZE_NORMAL {
+		t.Fatalf("expected full precision texcoords at V21: %v", err)
+	}
+	if nd.Quantization != QUANTIZE_NORMAL|QUANTIZE_COMPACT {
+		t.Fatal("conversion modified the source node")
+	}
+
+	cube := newTestCubeNode()
+	cube.Quantization = QUANTIZE_COMPACT
+	doc, err := MstToGltfWithOptions([]*Mesh{{BaseMesh: BaseMesh{Materials: ms.Materials, Nodes: []*MeshNode{cube}}}}, &GltfExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := doc.Meshes[0].Primitives[0].Attributes
+	if doc.Accessors[attrs["NORMAL"]].ComponentType != gltf.ComponentByte || doc.Accessors[attrs["TEXCOORD_0"]].ComponentType != gltf.ComponentUshort {
+		t.Fatal("compact attributes not exported as quantized accessors")
+	}
+	if len(doc.ExtensionsRequired) != 1 || doc.ExtensionsRequired[0] != KHR_MESH_QUANTIZATION {
+		t.Fatalf("unexpected required extensions %v", doc.ExtensionsRequired)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	ms := newTestMesh()
+	ms.Props = Properties{"name": "strip"}
+	ms.Nodes = append(ms.Nodes, newTestStripNode(4000))
+	plain := &bytes.Buffer{}
+	MeshMarshal(plain, ms)
+	dir, err := ioutil.TempDir("", "mst-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, codec := range []uint8{COMPRESSION_GZIP, COMPRESSION_ZSTD} {
+		p := filepath.Join(dir, fmt.Sprintf("mesh-%d.mst", codec))
+		if err := MeshWriteTo(p, ms, WithCompression(codec, 0), WithChecksum(), WithSectionTable()); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(p)
+		if len(data) >= plain.Len()/2 {
+			t.Fatalf("codec %d: compressed file is not smaller: %d vs %d", codec, len(data), plain.Len())
+		}
+		out, err := MeshReadFrom(p)
+		if err != nil {
+			t.Fatalf("codec %d: %v", codec, err)
+		}
+		if len(out.Nodes) != 3 || len(out.Nodes[2].Vertices) != len(ms.Nodes[2].Vertices) || out.Props["name"] != "strip" || len(out.InstanceNode) != 1 {
+			t.Fatalf("codec %d: unexpected decoded mesh", codec)
+		}
+		hdr, err := ReadMeshHeader(bytes.NewReader(data))
+		if err != nil || hdr.Compression != codec || hdr.Flags&MESH_FLAG_COMPRESSED == 0 {
+			t.Fatalf("codec %d: unexpected header %+v: %v", codec, hdr, err)
+		}
+		props, err := ReadMeshSection(bytes.NewReader(data), hdr, MESH_SECTION_PROPS)
+		if err != nil || props.Props["name"] != "strip" {
+			t.Fatalf("codec %d: props section: %v", codec, err)
+		}
+		if ex, err := ExtractNode(p, "2"); err != nil || len(ex.Nodes[0].Vertices) != len(ms.Nodes[2].Vertices) {
+			t.Fatalf("codec %d: extract: %v", codec, err)
+		}
+		bad := append([]byte{}, data...)
+		bad[len(bad)/2] ^= 0xff
+		if _, err := MeshUnMarshalWithOptions(bytes.NewReader(bad), &DefaultDecodeOptions); err == nil {
+			t.Fatalf("codec %d: corruption not detected", codec)
+		}
+	}
+	if err := MeshWriteTo(filepath.Join(dir, "bad.mst"), ms, WithCompression(9, 0)); err != ErrUnknownCompression {
+		t.Fatalf("expected ErrUnknownCompression, got %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := MeshMarshal(buf, ms, WithCompression(9, 0)); err != ErrUnknownCompression || buf.Len() != 0 {
+		t.Fatalf("expected ErrUnknownCompression from MeshMarshal, got %v", err)
+	}
+	if err := MeshMarshal(buf, ms, WithCompression(COMPRESSION_GZIP, 42)); err == nil || buf.Len() != 0 {
+		t.Fatal("invalid gzip level silently accepted")
+	}
+}
+
+func TestOutlineStylesDefaultVersion(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{255, 0, 0}}}
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	nd.EdgeGroup = []*MeshOutline{{Edges: [][2]uint32{{0, 1}, {1, 3}, {3, 2}}, Width: 1.5, Color: &[3]byte{0, 0, 255}, Dash: []float32{2, 1}, Closed: true}}
+	ms.Nodes = []*MeshNode{nd}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := out.Nodes[0].EdgeGroup[0]
+	if out.Version < V11 || g.Width != 1.5 || g.Color == nil || *g.Color != [3]byte{0, 0, 255} || len(g.Dash) != 2 || !g.Closed {
+		t.Fatalf("outline style not preserved at version %d: %+v", out.Version, g)
+	}
+}
+
+func TestOutlineStyles(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V11
+	nd := ms.Nodes[0]
+	nd.EdgeGroup = []*MeshOutline{
+		{Batchid: 0, Edges: [][2]uint32{{0, 1}, {1, 2}, {2, 3}}, Width: 2.5, Color: &[3]byte{255, 128, 0}, Dash: []float32{4, 2}, Closed: true},
+		{Batchid: 1, Edges: [][2]uint32{{4, 5}}},
+	}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := out.Nodes[0].EdgeGroup[0]
+	if g.Width != 2.5 || g.Color == nil || *g.Color != [3]byte{255, 128, 0} || len(g.Dash) != 2 || !g.Closed {
+		t.Fatalf("outline style not preserved: %+v", g)
+	}
+	if g := out.Nodes[0].EdgeGroup[1]; g.styled() {
+		t.Fatalf("unexpected style on plain outline: %+v", g)
+	}
+	if ex, err := ExtractNodeFrom(bytes.NewReader(data), "1"); err != nil || len(ex.Nodes) != 1 {
+		t.Fatalf("extract after styled outline failed: %v", err)
+	}
+	conv, warns := ConvertVersion(ms, V10)
+	dropped := false
+	for _, w := range warns {
+		dropped = dropped || w.Field == "nodes[0].edgeGroup"
+	}
+	if !dropped || conv.Nodes[0].EdgeGroup[0].styled() || len(conv.Nodes[0].EdgeGroup[0].Edges) != 3 || !nd.EdgeGroup[0].Closed {
+		t.Fatalf("outline styles not dropped below V11: %v", warns)
+	}
+	cached := &bytes.Buffer{}
+	if err := MeshCacheMarshal(cached, ms); err != nil {
+		t.Fatal(err)
+	}
+	if back, err := MeshCacheUnMarshal(cached); err != nil || !reflect.DeepEqual(back.Nodes[0].EdgeGroup, nd.EdgeGroup) {
+		t.Fatalf("cache lost outline styles: %v", err)
+	}
+	if lines := g.Polylines(); len(lines) != 1 || len(lines[0]) != 5 || lines[0][4] != 0 {
+		t.Fatalf("unexpected polylines %v", lines)
+	}
+
+	obj := &bytes.Buffer{}
+	if err := MeshObjMarshal(obj, ms, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(obj.String(), "l 1 2 3 4 1\n") || !strings.Contains(obj.String(), "l 5 6\n") {
+		t.Fatalf("missing polyline statements:\n%s", obj.String())
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{ExportOutline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := doc.Meshes[0].Primitives[0]
+	if ps.Mode != gltf.PrimitiveLines || doc.Accessors[*ps.Indices].Count != 8 {
+		t.Fatalf("unexpected outline primitive %+v", ps)
+	}
+	if extras, ok := ps.Extras.(map[string]interface{}); !ok || extras["lineWidth"] != float32(2.5) {
+		t.Fatalf("missing outline extras %v", ps.Extras)
+	}
+	cl := doc.Materials[*ps.Material].PBRMetallicRoughness.BaseColorFactor
+	if *ps.Material < uint32(len(ms.Materials)) || cl[0] != 1 || cl[2] != 0 {
+		t.Fatalf("outline color override not exported as material %d", *ps.Material)
+	}
+	if p := doc.Meshes[0].Primitives[1]; *p.Material != 1 {
+		t.Fatalf("plain outline should use its batch material, got %d", *p.Material)
+	}
+
+	doc, err = MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{ExportOutline: true, OutlineWithTriangles: true, Quantization: QUANTIZE_ALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prims := doc.Meshes[0].Primitives
+	if len(prims) != 3 || prims[0].Mode != gltf.PrimitiveTriangles || prims[1].Mode != gltf.PrimitiveLines || prims[2].Mode != gltf.PrimitiveLines {
+		t.Fatalf("expected triangles followed by lines, got %d primitives", len(prims))
+	}
+	if prims[1].Attributes["POSITION"] != prims[0].Attributes["POSITION"] || doc.Accessors[*prims[1].Indices].Count != 8 {
+		t.Fatalf("outline primitive does not share the triangle positions %+v", prims[1])
+	}
+	if *prims[2].Material == *prims[0].Material || *prims[2].Material < uint32(len(ms.Materials)) {
+		t.Fatalf("plain outline should get a distinct line material, got %d", *prims[2].Material)
+	}
+	if len(doc.Meshes[1].Primitives) != 1 || len(doc.Materials) != len(ms.Materials)+len(ms.InstanceNode[0].Mesh.Materials)+2 {
+		t.Fatalf("unexpected meshes or materials: %d materials", len(doc.Materials))
+	}
+}
+
+func TestAnimations(t *testing.T) {
+	ms := newTestMesh()
+	ms.Materials = ms.Materials[:1]
+	ms.Nodes = ms.Nodes[:1]
+	ms.Animations = []*Animation{{
+		Name: "radar",
+		Tracks: []*AnimationTrack{
+			{TargetType: ANIMATION_TARGET_NODE, Path: ANIMATION_PATH_ROTATION, Times: []float32{0, 1, 2}, Values: []float32{0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 1}},
+			{TargetType: ANIMATION_TARGET_INSTANCE, Transform: 1, Path: ANIMATION_PATH_TRANSLATION, Interpolation: ANIMATION_INTERPOLATION_STEP, Times: []float32{0, 5}, Values: []float32{10, 0, 0, 10, 5, 0}},
+		},
+	}}
+	if err := ms.ValidateAnimations(); err != nil {
+		t.Fatal(err)
+	}
+	if v := ms.Animations[0].Tracks[0].Sample(0.5); math.Abs(float64(v[2])-math.Sqrt(0.5)) > 1e-5 || math.Abs(float64(v[3])-math.Sqrt(0.5)) > 1e-5 {
+		t.Fatalf("unexpected slerp result %v", v)
+	}
+	if v := ms.Animations[0].Tracks[1].Sample(4); v[1] != 0 {
+		t.Fatalf("step interpolation returned %v", v)
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms, WithSectionTable(), WithChecksum())
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V12 || !out.Equal(ms) {
+		t.Fatal("animations not preserved")
+	}
+	hdr, _ := ReadMeshHeader(bytes.NewReader(data))
+	sec, err := ReadMeshSection(bytes.NewReader(data), hdr, MESH_SECTION_ANIMATIONS)
+	if err != nil || len(sec.Animations) != 1 || sec.Animations[0].Tracks[1].Transform != 1 {
+		t.Fatalf("animation section: %v", err)
+	}
+	if conv, warns := ConvertVersion(ms, V11); len(conv.Animations) != 0 || len(warns) != 1 {
+		t.Fatalf("expected animations to be dropped, got %v", warns)
+	}
+
+	if _, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{GpuInstance: true}); !errors.Is(err, errGpuInstanceAnimation) {
+		t.Fatalf("expected GPU instancing error, got %v", err)
+	}
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{Quantization: QUANTIZE_ALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Animations) != 1 || len(doc.Animations[0].Channels) != 2 {
+		t.Fatalf("unexpected glTF animations %+v", doc.Animations)
+	}
+	for _, nd := range doc.Nodes {
+		if nd.Matrix != [16]float32{} && nd.Matrix != gltf.DefaultMatrix {
+			t.Fatal("animated node exported with a matrix")
+		}
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(back); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := GltfToMst(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.Animations) != 1 || len(imp.Animations[0].Tracks) != 2 {
+		t.Fatalf("unexpected imported animations %+v", imp.Animations)
+	}
+	for i, tr := range imp.Animations[0].Tracks {
+		want := ms.Animations[0].Tracks[i]
+		if tr.TargetType != want.TargetType || tr.Target != want.Target || tr.Transform != want.Transform || tr.Path != want.Path || tr.Interpolation != want.Interpolation {
+			t.Fatalf("track %d imported as %+v", i, tr)
+		}
+		for k := range want.Values {
+			if math.Abs(float64(tr.Values[k]-want.Values[k])) > 1e-5 {
+				t.Fatalf("track %d values %v, want %v", i, tr.Values, want.Values)
+			}
+		}
+	}
+}
+
+func TestMorphTargets(t *testing.T) {
+	ms := newTestMesh()
+	ms.Materials = ms.Materials[:1]
+	ms.Nodes = ms.Nodes[:1]
+	ms.InstanceNode = nil
+	nd := ms.Nodes[0]
+	deltas := make([]vec3.T, len(nd.Vertices))
+	for i, v := range nd.Vertices {
+		deltas[i] = vec3.T{0, 0, v[2]}
+	}
+	if err := nd.AddMorphTarget("stretch", 0.5, deltas, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddMorphTarget("bad", 0, deltas[:1], nil); err == nil {
+		t.Fatal("expected mismatched morph target to be rejected")
+	}
+	if top := nd.ApplyMorph(nil).GetBoundbox(); top[5] != 1.5 {
+		t.Fatalf("default weights applied as %v", top)
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	data := buf.Bytes()
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V13 || len(out.Nodes[0].MorphTargets) != 1 || out.Nodes[0].MorphTargets[0].Name != "stretch" || out.Nodes[0].MorphTargets[0].Weight != 0.5 {
+		t.Fatal("morph targets not preserved")
+	}
+	if ex, err := ExtractNodeFrom(bytes.NewReader(data), "0"); err != nil || len(ex.Nodes[0].MorphTargets) != 1 {
+		t.Fatalf("extract lost morph targets: %v", err)
+	}
+	if conv, warns := ConvertVersion(ms, V12); len(conv.Nodes[0].MorphTargets) != 0 || len(warns) != 1 || len(nd.MorphTargets) != 1 {
+		t.Fatalf("expected morph targets to be dropped, got %v", warns)
+	}
+	mt := dmat.Ident
+	mt[2][2] = 2
+	if tr := TransformNode(nd, &mt, nil); tr.MorphTargets[0].Positions[7][2] != 2 {
+		t.Fatalf("transformed morph delta %v", tr.MorphTargets[0].Positions[7])
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{Quantization: QUANTIZE_ALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gm := doc.Meshes[0]; len(gm.Primitives[0].Targets) != 1 || len(gm.Weights) != 1 || gm.Weights[0] != 0.5 {
+		t.Fatalf("unexpected glTF morph targets %+v", gm)
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(back); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := GltfToMst(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := imp.Nodes[0]
+	if imp.Version != V13 || len(got.MorphTargets) != 1 || got.MorphTargets[0].Name != "stretch" || got.MorphTargets[0].Weight != 0.5 || got.Props != nil {
+		t.Fatalf("unexpected imported morph targets %+v", got.MorphTargets)
+	}
+	for i, d := range got.MorphTargets[0].Positions {
+		if d != deltas[i] {
+			t.Fatalf("delta %d imported as %v, want %v", i, d, deltas[i])
+		}
+	}
+}
+
+func TestNodeHierarchy(t *testing.T) {
+	ms := newTestMesh()
+	ms.Materials = ms.Materials[:1]
+	ms.InstanceNode = nil
+	ms.Nodes[0].Name, ms.Nodes[1].Name = "left", "right"
+	ms.Nodes = append([]*MeshNode{{Name: "site", Children: []uint32{1, 2}}}, ms.Nodes...)
+	var order []string
+	if err := ms.Walk(func(i, parent int, nd *MeshNode) error {
+		order = append(order, fmt.Sprintf("%s:%d", nd.Name, parent))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "site:-1,left:0,right:0" {
+		t.Fatalf("unexpected walk order %v", order)
+	}
+	if i, nd := ms.FindByName("right"); i != 2 || nd != ms.Nodes[2] {
+		t.Fatalf("FindByName returned %d", i)
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V14 || out.Nodes[0].Name != "site" || len(out.Nodes[0].Children) != 2 || out.Nodes[2].Name != "right" {
+		t.Fatal("hierarchy not preserved")
+	}
+	ms.Nodes[2].Children = []uint32{0}
+	buf.Reset()
+	MeshMarshal(buf, ms)
+	if _, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions); !errors.Is(err, ErrInvalidHierarchy) {
+		t.Fatalf("expected cyclic hierarchy to be rejected, got %v", err)
+	}
+	ms.Nodes[2].Children = nil
+	if conv, warns := ConvertVersion(ms, V13); conv.Nodes[0].Name != "" || len(conv.Nodes[0].Children) != 0 || len(warns) != 1 || ms.Nodes[0].Name != "site" {
+		t.Fatalf("expected hierarchy to be dropped, got %v", warns)
+	}
+
+	split := &Mesh{BaseMesh: BaseMesh{Materials: ms.Materials, Nodes: append([]*MeshNode(nil), ms.Nodes...)}}
+	if err := split.SplitLargeNodes(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := split.ValidateHierarchy(); err != nil || len(split.Roots()) != 1 || len(split.Nodes) <= 3 {
+		t.Fatalf("split broke the hierarchy: %v, %d nodes", err, len(split.Nodes))
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{Quantization: QUANTIZE_ALL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Scenes[0].Nodes) != 1 || doc.Nodes[0].Name != "site" || doc.Nodes[0].Mesh != nil || len(doc.Nodes[0].Children) != 2 {
+		t.Fatalf("unexpected glTF hierarchy %+v", doc.Nodes[0])
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(back); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := GltfToMst(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Version != V14 || len(imp.Roots()) != 1 {
+		t.Fatalf("imported hierarchy has roots %v", imp.Roots())
+	}
+	root := imp.Nodes[imp.Roots()[0]]
+	if root.Name != "site" || len(root.Children) != 2 || imp.Nodes[root.Children[0]].Name != "left" || imp.Nodes[root.Children[1]].Name != "right" {
+		t.Fatalf("unexpected imported hierarchy %+v", root)
+	}
+}
+
+func TestMaterialNames(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode = nil
+	ms.Materials[0].(*BaseMaterial).Name = "brick"
+	ms.Materials[1].(*PbrMaterial).Name = "glass"
+	ms.Nodes[0].Name = "wall"
+	hash := ComputeBaseMeshHash(&ms.BaseMesh)
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V15 || MaterialName(out.Materials[0]) != "brick" || MaterialName(out.Materials[1]) != "glass" {
+		t.Fatal("material names not preserved")
+	}
+	conv, warns := ConvertVersion(ms, V14)
+	if MaterialName(conv.Materials[0]) != "" || len(warns) != 1 || MaterialName(ms.Materials[0]) != "brick" {
+		t.Fatalf("expected material names to be dropped, got %v", warns)
+	}
+	if ComputeBaseMeshHash(&conv.BaseMesh) == hash {
+		t.Fatal("material names do not contribute to the mesh hash")
+	}
+
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Materials[0].Name != "brick" || doc.Materials[1].Name != "glass" || doc.Nodes[0].Name != "wall" || doc.Meshes[0].Name != "wall" {
+		t.Fatal("names not exported to glTF")
+	}
+	imp, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if MaterialName(imp.Materials[0]) != "brick" || MaterialName(imp.Materials[1]) != "glass" || imp.Nodes[0].Name != "wall" {
+		t.Fatal("names not imported from glTF")
+	}
+}
+
+func TestInstanceBounds(t *testing.T) {
+	ms := newTestMesh()
+	local := baseMeshBBox(ms.InstanceNode[0].Mesh)
+	if bx := ms.ComputeBBox(); bx.Max[0] != local[3]+10 {
+		t.Fatalf("instances not included in bbox: %v", bx)
+	}
+	ms.ComputePerInstanceBBoxes()
+	bounds := ms.InstanceNode[0].Bounds
+	if len(bounds) != 2 || bounds[0] != local || bounds[1][0] != local[0]+10 || bounds[1][3] != local[3]+10 {
+		t.Fatalf("unexpected per-instance bounds %v", bounds)
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V16 || len(out.InstanceNode[0].Bounds) != 2 || out.InstanceNode[0].Bounds[1] != bounds[1] {
+		t.Fatal("instance bounds not preserved")
+	}
+	conv, warns := ConvertVersion(ms, V15)
+	if conv.InstanceNode[0].Bounds != nil || len(warns) != 1 || ms.InstanceNode[0].Bounds == nil {
+		t.Fatalf("expected instance bounds to be dropped, got %v", warns)
+	}
+
+	ms.InstanceNode[0].Bounds = bounds[:1]
+	buf.Reset()
+	MeshMarshal(buf, ms)
+	if out, err = MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions); err != nil || out.InstanceNode[0].Bounds != nil {
+		t.Fatal("mismatched instance bounds should not be written")
+	}
+}
+
+func TestComputeBBoxTransforms(t *testing.T) {
+	ms := newTestMesh()
+	moved := dmat.Ident
+	moved.SetTranslation(&dvec3.T{0, 5, 0})
+	ms.Nodes[1].Mat = &moved
+	scaled := dmat.Ident
+	scaled.ScaleVec3(&dvec3.T{2, 2, 2})
+	ms.InstanceNode[0].Mesh.Nodes[0].Mat = &scaled
+
+	bx := ms.ComputeBBox()
+	if bx.Max[1] != 6 || bx.Max[0] != 12 || bx.Min != (dvec3.T{0, 0, 0}) {
+		t.Fatalf("node and instance transforms not applied: %v", bx)
+	}
+	if old := ms.ComputeBBoxWithOptions(&BBoxOptions{IgnoreNodeTransforms: true}); old.Max != (dvec3.T{11, 1, 1}) {
+		t.Fatalf("unexpected untransformed bbox %v", old)
+	}
+	up := dmat.Ident
+	up.SetTranslation(&dvec3.T{0, 0, 100})
+	if world := ms.ComputeBBoxWorld(&up); world.Min[2] != 100 || world.Max[2] != 102 || world.Max[1] != 6 {
+		t.Fatalf("extra matrix not applied: %v", world)
+	}
+	if empty := NewMesh().ComputeBBox(); empty != (dvec3.Box{}) {
+		t.Fatalf("expected empty box, got %v", empty)
+	}
+}
+
+func TestFeatureTable(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode[0].Props = Properties{"kind": "tree", "meta": map[string]interface{}{"a": int64(1)}}
+	mt := dmat.Ident
+	mt.SetTranslation(&dvec3.T{0, 20, 0})
+	ms.InstanceNode = append(ms.InstanceNode, &InstanceMesh{
+		Transfors: []*dmat.T{&mt},
+		Features:  []uint64{2},
+		Mesh:      ms.InstanceNode[0].Mesh,
+		Props:     Properties{"meta": map[string]interface{}{"b": int64(2)}},
+	})
+	ft := ms.BuildFeatureIndex()
+	if ft.Len() != 2 || fmt.Sprint(ft.IDs()) != "[1 2]" || ft.Has(3) {
+		t.Fatalf("unexpected feature ids %v", ft.IDs())
+	}
+	refs := ft.Lookup(2)
+	if len(refs) != 2 || refs[0].Instance != 0 || refs[0].Transform != 1 || refs[0].Props["kind"] != "tree" || refs[1].Instance != 1 || refs[1].Transform != 0 {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+	trs := ft.TransformsForFeature(2)
+	if len(trs) != 2 || trs[0] != ms.InstanceNode[0].Transfors[1] || trs[1] != &mt {
+		t.Fatal("unexpected transforms for feature")
+	}
+	props := ft.PropsForFeature(2)
+	meta, _ := asProperties(props["meta"])
+	if props["kind"] != "tree" || meta["a"] != int64(1) || meta["b"] != int64(2) {
+		t.Fatalf("unexpected merged props %v", props)
+	}
+	if _, ok := asProperties(ms.InstanceNode[0].Props["meta"]); !ok || len(ms.InstanceNode[0].Props["meta"].(map[string]interface{})) != 1 {
+		t.Fatal("merging modified instance props")
+	}
+	if ft.TransformsForFeature(9) != nil || ft.PropsForFeature(9) != nil {
+		t.Fatal("expected nothing for unknown feature")
+	}
+}
+
+func TestFaceFeatures(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V8
+	g := ms.Nodes[0].FaceGroup[0]
+	for i := range g.Faces {
+		g.Features = append(g.Features, uint64(100+i/2))
+	}
+	id := uint64(7)
+	ms.Nodes[1].FaceGroup[0].FeatureID = &id
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec, err := MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if err != nil || dec.Version != V25 || !dec.Equal(ms) {
+		t.Fatalf("face features did not round trip: %v", err)
+	}
+	if f, ok := dec.Nodes[0].FeatureForFace(0, 3); !ok || f != 101 {
+		t.Fatalf("unexpected feature %d", f)
+	}
+	if f, ok := dec.Nodes[1].FeatureForFace(0, 5); !ok || f != 7 {
+		t.Fatalf("unexpected group feature %d", f)
+	}
+	if _, ok := dec.Nodes[0].FeatureForFace(1, 0); ok {
+		t.Fatal("expected no feature for a missing group")
+	}
+	dec.Nodes[0].FaceGroup[0].Features[0] = 1
+	if dec.Equal(ms) {
+		t.Fatal("diff ignored face features")
+	}
+
+	old, warns := ConvertVersion(ms, V24)
+	if len(warns) != 1 || warns[0].Message != "dropped face feature ids" || old.Nodes[0].FaceGroup[0].HasFeatures() || !g.HasFeatures() {
+		t.Fatalf("unexpected conversion %v", warns)
+	}
+
+	nd := ms.Nodes[1]
+	n := len(nd.FaceGroup[0].Faces)
+	if err := nd.AddFaces(1, []*Face{{Vertex: [3]uint32{0, 1, 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := nd.FaceGroup[0].FaceFeature(0); f != 7 || len(nd.FaceGroup[0].Features) != n+1 {
+		t.Fatal("adding faces lost the group feature")
+	}
+	nd.FaceGroup = nd.FaceGroup[:1]
+	nd.FaceGroup[0].Faces, nd.FaceGroup[0].Features = nd.FaceGroup[0].Faces[:n], nil
+
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasString(doc.ExtensionsUsed, EXT_MESH_FEATURES) || hasString(doc.ExtensionsRequired, EXT_MESH_FEATURES) {
+		t.Fatalf("unexpected extensions %v", doc.ExtensionsUsed)
+	}
+	if _, ok := doc.Meshes[0].Primitives[0].Attributes["_FEATURE_ID_0"]; !ok {
+		t.Fatal("missing feature id attribute")
+	}
+	mf := doc.Meshes[0].Primitives[0].Extensions[EXT_MESH_FEATURES].(*MeshFeatures)
+	if len(mf.FeatureIDs) != 1 || mf.FeatureIDs[0].FeatureCount != uint32(len(g.Faces)/2) || mf.FeatureIDs[0].NullFeatureID != nil {
+		t.Fatalf("unexpected feature ids %+v", mf.FeatureIDs)
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gd := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(gd); err != nil {
+		t.Fatal(err)
+	}
+	imp, err := GltfToMst(gd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(imp.Nodes[0].FaceGroup[0].Features) != fmt.Sprint(g.Features) {
+		t.Fatalf("unexpected imported features %v", imp.Nodes[0].FaceGroup[0].Features)
+	}
+	if fg := imp.Nodes[1].FaceGroup[0]; fg.FeatureID == nil || *fg.FeatureID != 7 || fg.Features != nil {
+		t.Fatal("group feature id did not survive glTF")
+	}
+
+	big := uint64(GLTF_MAX_FEATURE_ID)
+	ms.Nodes[1].FaceGroup[0].FeatureID = &big
+	if err := BuildGltfWithOptions(CreateDoc(), ms, &GltfExportOptions{}); err == nil {
+		t.Fatal("expected an error for an out of range feature id")
+	}
+}
+
+func TestMeshStats(t *testing.T) {
+	ms := newTestMesh()
+	ms.Nodes[1].FaceGroup[0].Faces = append(ms.Nodes[1].FaceGroup[0].Faces, &Face{Vertex: [3]uint32{0, 0, 1}}, &Face{Vertex: [3]uint32{0, 1, 9}})
+	ms.Nodes[0].EdgeGroup = []*MeshOutline{{Edges: [][2]uint32{{0, 1}}}}
+	tex := &Texture{Size: [2]uint64{4, 2}, Format: TEXTURE_FORMAT_RGBA, Type: TEXTURE_PIXEL_TYPE_UBYTE, Compressed: TEXTURE_COMPRESSED_ZLIB, Data: make([]byte, 10)}
+	ms.Materials = append(ms.Materials, &PbrMaterial{TextureMaterial: TextureMaterial{Texture: tex, Normal: tex}})
+	ms.Nodes[0].Lightmap = &Texture{Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_R, Type: TEXTURE_PIXEL_TYPE_USHORT, Data: make([]byte, 8)}
+	ms.InstanceNode = append(ms.InstanceNode, &InstanceMesh{Transfors: []*dmat.T{&dmat.Ident}, Mesh: ms.InstanceNode[0].Mesh})
+	st := ms.Stats()
+	if st.Triangles != 38 || st.Vertices != 24 || st.Edges != 56 || st.OutlineEdges != 1 || st.Nodes != 3 || st.Instances != 3 {
+		t.Fatalf("unexpected geometry stats %+v", st)
+	}
+	if st.Textures != 2 || st.Texels != 12 || st.CompressedBytes != 18 || st.UncompressedBytes != 40 {
+		t.Fatalf("unexpected texture stats %+v", st)
+	}
+	if fmt.Sprint(st.MaterialFaces) != "[12 14 0]" || st.DegenerateFaces != 2 {
+		t.Fatalf("unexpected face stats %+v", st)
+	}
+}
+
+func TestUnknownMaterial(t *testing.T) {
+	ms := newTestMesh()
+	ms.Version = V8
+	ms.Materials = append(ms.Materials, &UnknownMaterial{Type: 42, Data: []byte{1, 2, 3, 4, 5}})
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec, err := MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if err != nil || dec.Version != V26 || !dec.Equal(ms) {
+		t.Fatalf("unknown material did not pass through: %v", err)
+	}
+	if um, ok := dec.Materials[2].(*UnknownMaterial); !ok || um.Type != 42 || !bytes.Equal(um.Data, []byte{1, 2, 3, 4, 5}) {
+		t.Fatalf("unexpected material %#v", dec.Materials[2])
+	}
+
+	buf.Reset()
+	MaterialMarshal(buf, &BaseMaterial{Color: [3]byte{1, 2, 3}, Name: "wall"}, V26)
+	data := append(buf.Bytes(), 9, 9)
+	binary.LittleEndian.PutUint32(data[4:], binary.LittleEndian.Uint32(data[4:])+2)
+	if mtl, ok := MaterialUnMarshal(bytes.NewReader(data), V26).(*BaseMaterial); !ok || mtl.Color != [3]byte{1, 2, 3} || mtl.Name != "wall" {
+		t.Fatal("trailing material fields were not skipped")
+	}
+	d := newDecoder(bytes.NewReader(data[:len(data)-3]), V26, &DefaultDecodeLimits)
+	if d.material(); d.err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected truncation error, got %v", d.err)
+	}
+	d = newDecoder(bytes.NewReader([]byte{42, 0, 0, 0, 5, 0, 0, 0}), V25, &DefaultDecodeLimits)
+	d.material()
+	if ue, ok := d.err.(*UnknownMaterialError); !ok || ue.Type != 42 {
+		t.Fatalf("expected unknown material error, got %v", d.err)
+	}
+
+	old, warns := ConvertVersion(ms, V25)
+	if len(warns) != 1 || warns[0].Field != "materials[2]" {
+		t.Fatalf("unexpected warnings %v", warns)
+	}
+	if _, ok := old.Materials[2].(*BaseMaterial); !ok {
+		t.Fatal("unknown material was not replaced")
+	}
+	buf.Reset()
+	MaterialMarshal(buf, ms.Materials[2], V25)
+	if _, ok := MaterialUnMarshal(bytes.NewReader(buf.Bytes()), V25).(*BaseMaterial); !ok {
+		t.Fatal("expected a placeholder for older versions")
+	}
+
+	js, err := MaterialMarshalJSON(ms.Materials[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtl, err := MaterialUnmarshalJSON(js); err != nil || !reflect.DeepEqual(mtl, ms.Materials[2]) {
+		t.Fatalf("unknown material json round trip failed: %v", err)
+	}
+	if err := BuildGltfWithOptions(CreateDoc(), ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testWaterMaterial struct {
+	Color     [3]byte
+	WaveSpeed float32
+}
+
+func (m *testWaterMaterial) HasTexture() bool     { return false }
+func (m *testWaterMaterial) GetTexture() *Texture { return nil }
+func (m *testWaterMaterial) GetColor() [3]byte    { return m.Color }
+func (m *testWaterMaterial) GetEmissive() [3]byte { return [3]byte{} }
+
+type testWaterCodec struct{}
+
+func (testWaterCodec) Match(mtl MeshMaterial) bool {
+	_, ok := mtl.(*testWaterMaterial)
+	return ok
+}
+
+func (testWaterCodec) Marshal(wt io.Writer, mtl MeshMaterial) {
+	m := mtl.(*testWaterMaterial)
+	writeLittleByte(wt, m.Color[:])
+	writeLittleByte(wt, m.WaveSpeed)
+}
+
+func (testWaterCodec) Unmarshal(rd io.Reader) (MeshMaterial, error) {
+	m := &testWaterMaterial{}
+	if err := binary.Read(rd, binary.LittleEndian, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (testWaterCodec) FillGltf(doc *gltf.Document, mtl MeshMaterial, gm *gltf.Material) error {
+	gm.Extensions["EXT_test_water"] = map[string]interface{}{"waveSpeed": mtl.(*testWaterMaterial).WaveSpeed}
+	return nil
+}
+
+func TestMaterialRegistry(t *testing.T) {
+	if err := RegisterMaterialType(MESH_TRIANGLE_MATERIAL_TYPE_PBR, testWaterCodec{}); err != ErrMaterialTypeRegistered {
+		t.Fatalf("expected built-in tag conflict, got %v", err)
+	}
+	ms := newTestMesh()
+	water := &testWaterMaterial{Color: [3]byte{0, 64, 128}, WaveSpeed: 1.5}
+	ms.Materials = append(ms.Materials, water)
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec, err := MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dec.Materials[2].(*BaseMaterial); !ok {
+		t.Fatalf("unregistered material should degrade to a color material, got %T", dec.Materials[2])
+	}
+
+	if err := RegisterMaterialType(100, testWaterCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterMaterialType(100)
+	if err := RegisterMaterialType(100, testWaterCodec{}); err != ErrMaterialTypeRegistered {
+		t.Fatalf("expected duplicate registration error, got %v", err)
+	}
+	buf.Reset()
+	MeshMarshal(buf, ms)
+	UnregisterMaterialType(100)
+	dec, err = MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if um, ok := dec.Materials[2].(*UnknownMaterial); err != nil || !ok || um.Type != 100 || len(um.Data) != 7 {
+		t.Fatalf("expected an unknown material without the codec: %v", err)
+	}
+	RegisterMaterialType(100, testWaterCodec{})
+	dec, err = MeshUnMarshalWithLimits(bytes.NewReader(buf.Bytes()), &DefaultDecodeLimits)
+	if err != nil || !reflect.DeepEqual(dec.Materials[2], water) || !dec.Equal(ms) {
+		t.Fatalf("custom material did not round trip: %v", err)
+	}
+
+	js, err := MaterialMarshalJSON(water)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtl, err := MaterialUnmarshalJSON(js); err != nil || !reflect.DeepEqual(mtl, water) {
+		t.Fatalf("custom material json round trip failed: %v", err)
+	}
+
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	gm := doc.Materials[2]
+	if _, ok := gm.Extensions["EXT_test_water"]; !ok || !hasString(doc.ExtensionsUsed, "EXT_test_water") || gm.PBRMetallicRoughness.BaseColorFactor[2] != 128.0/255 {
+		t.Fatal("custom material was not exported to glTF")
+	}
+
+	old, warns := ConvertVersion(ms, V25)
+	if len(warns) != 1 || old.Materials[2].GetColor() != water.Color {
+		t.Fatalf("unexpected conversion warnings %v", warns)
+	}
+}
+
+func TestCanonicalMarshal(t *testing.T) {
+	newMesh := func(empty bool) *Mesh {
+		ms := newTestMesh()
+		ms.Props = Properties{}
+		for i := 0; i < 32; i++ {
+			ms.Props[fmt.Sprintf("key%d", i)] = map[string]interface{}{"a": int64(i), "b": "x", "c": true}
+		}
+		ms.Nodes[0].Props = Properties{"z": 1.5, "y": nil, "x": []interface{}{"q"}}
+		if empty {
+			ms.Nodes[1].Colors = [][3]byte{}
+			ms.Nodes[1].Children = []uint32{}
+			ms.Nodes[1].Props = Properties{}
+			ms.InstanceNode[0].Bounds = [][6]float64{}
+		}
+		return ms
+	}
+	marshal := func(ms *Mesh) []byte {
+		buf := &bytes.Buffer{}
+		MeshMarshal(buf, ms, WithCanonical(), WithChecksum(), WithSectionTable())
+		return buf.Bytes()
+	}
+	want := marshal(newMesh(false))
+	for i := 0; i < 8; i++ {
+		if !bytes.Equal(marshal(newMesh(false)), want) {
+			t.Fatal("canonical output differs across runs")
+		}
+	}
+	if !bytes.Equal(marshal(newMesh(true)), want) {
+		t.Fatal("empty and nil slices serialize differently")
+	}
+	a, _ := json.Marshal(CanonicalMesh(newMesh(true)))
+	b, _ := json.Marshal(CanonicalMesh(newMesh(false)))
+	if !bytes.Equal(a, b) {
+		t.Fatal("canonical JSON differs for empty and nil slices")
+	}
+	ms := newMesh(true)
+	if CanonicalMesh(ms); ms.Nodes[1].Colors == nil {
+		t.Fatal("CanonicalMesh modified its input")
+	}
+}
+
+func TestObjImport(t *testing.T) {
+	ms := newTestMesh()
+	obj := &bytes.Buffer{}
+	if err := MeshObjMarshal(obj, ms, ""); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ObjToMst(bytes.NewReader(obj.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Nodes) != len(ms.Nodes) {
+		t.Fatalf("got %d nodes, want %d", len(out.Nodes), len(ms.Nodes))
+	}
+	faces := func(nd *MeshNode) int {
+		n := 0
+		for _, g := range nd.FaceGroup {
+			n += len(g.Faces)
+		}
+		return n
+	}
+	for i, nd := range ms.Nodes {
+		got := out.Nodes[i]
+		if faces(got) != faces(nd) || len(got.EdgeGroup) != len(nd.EdgeGroup) {
+			t.Fatalf("node %d geometry not preserved", i)
+		}
+		if len(nd.TexCoords) > 0 && len(got.TexCoords) != len(got.Vertices) {
+			t.Fatalf("node %d texcoords not preserved", i)
+		}
+	}
+
+	src := "v 0 0 0\nv 2 0 0\nv 2 2 0\nv 1 1 0\nv 0 2 0\nvt 0 0\nusemtl red\nf 1/1 2/1 3/1 4/1 5/1\ng lines\nl -1 -2 -3\n"
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "m.mtl"), []byte("newmtl red\nKd 1 0 0\nd 0.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "m.obj"), []byte("mtllib m.mtl\n"+src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = ObjToMstFromFile(filepath.Join(dir, "m.obj"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Materials) != 1 || out.Materials[0].GetColor() != [3]byte{255, 0, 0} || MaterialName(out.Materials[0]) != "red" {
+		t.Fatalf("unexpected materials %+v", out.Materials)
+	}
+	nd := out.Nodes[0]
+	if len(out.Nodes) != 2 || faces(nd) != 3 {
+		t.Fatal("concave polygon not triangulated")
+	}
+	area := 0.0
+	for _, f := range nd.FaceGroup[0].Faces {
+		n := faceNormal(nd.Vertices, f)
+		if n[2] <= 0 {
+			t.Fatalf("face %v lost its winding", f.Vertex)
+		}
+		area += float64(n.Length()) / 2
+	}
+	if math.Abs(area-3) > 1e-6 {
+		t.Fatalf("concave polygon covers %v instead of 3", area)
+	}
+	if out.Nodes[1].Name != "lines" || len(out.Nodes[1].EdgeGroup[0].Edges) != 2 {
+		t.Fatal("polyline not imported")
+	}
+	if _, err := ObjToMst(strings.NewReader("mtllib ../m.mtl\nv 0 0 0\n"), &ObjImportOptions{BaseDir: filepath.Join(dir, "sub")}); err == nil {
+		t.Fatal("expected a material library outside the base directory to be rejected")
+	}
+	if _, err := ObjToMst(strings.NewReader("v 0 0 0\nf 1 2 3\n"), nil); err == nil {
+		t.Fatal("expected an index error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := newTestMesh()
+	a.Props = Properties{"n": 1, "m": map[string]interface{}{"f": 0.5}}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, a)
+	b, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Diff(a, b, DefaultDiffOptions)
+	if err != nil || !d.Empty() {
+		t.Fatalf("expected no differences after a round trip, got %+v %v", d, err)
+	}
+
+	if !a.Equal(b) {
+		t.Fatal("expected equal meshes after a round trip")
+	}
+	b.Nodes[0].Vertices[0][0] += 1e-3
+	if a.Equal(b) || !a.AlmostEqual(b, 1e-2) || a.AlmostEqual(b, 1e-4) {
+		t.Fatal("unexpected equality with a moved vertex")
+	}
+	b.Nodes[0].Vertices[0][0] = a.Nodes[0].Vertices[0][0] + 1e-8
+	if d, _ = Diff(a, b, DefaultDiffOptions); !d.Empty() {
+		t.Fatalf("difference within tolerance reported: %+v", d)
+	}
+	b.Nodes[0].Vertices[0][0] += 0.5
+	b.Nodes = append(b.Nodes, newTestCubeNode())
+	b.Materials[0].(*BaseMaterial).Color = [3]byte{1, 2, 3}
+	b.Props["n"] = 2
+	b.Props["extra"] = true
+	mt := dmat.Ident
+	mt[3][1] = 5
+	b.InstanceNode[0].Transfors[1] = &mt
+	d, err = Diff(a, b, DefaultDiffOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Nodes) != 2 || d.Nodes[0].Kind != DIFF_CHANGED || d.Nodes[0].Path != "nodes[0]" || d.Nodes[1].Kind != DIFF_ADDED {
+		t.Fatalf("unexpected node differences %+v", d.Nodes)
+	}
+	if len(d.Materials) != 1 || d.Materials[0].Path != "materials[0]" {
+		t.Fatalf("unexpected material differences %+v", d.Materials)
+	}
+	if len(d.Props) != 2 || d.Props[0].Path != "props.n" || d.Props[1].Kind != DIFF_ADDED {
+		t.Fatalf("unexpected props differences %+v", d.Props)
+	}
+	if len(d.Instances) != 1 || d.Instances[0].Path != "instances[0]" {
+		t.Fatalf("unexpected instance differences %+v", d.Instances)
+	}
+	if _, err := Diff(a, nil, DefaultDiffOptions); err != ErrNilMesh {
+		t.Fatalf("expected ErrNilMesh, got %v", err)
+	}
+}
+
+func TestGltfToMstFromReader(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode = nil
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	glb := &bytes.Buffer{}
+	enc := gltf.NewEncoder(glb)
+	enc.AsBinary = true
+	if err := enc.Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	out, err := GltfToMstFromReader(bytes.NewReader(glb.Bytes()), nil)
+	if err != nil || len(out.Nodes) != len(ms.Nodes) {
+		t.Fatalf("glb import failed: %v", err)
+	}
+
+	data := doc.Buffers[0].Data
+	dir, _ := ioutil.TempDir("", "mst_gltf")
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "mesh data.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc.Buffers[0] = &gltf.Buffer{ByteLength: uint32(len(data)), URI: "mesh%20data.bin"}
+	bt, _ := json.Marshal(doc)
+	path := filepath.Join(dir, "mesh.gltf")
+	if err := ioutil.WriteFile(path, bt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err = GltfToMstFromFile(path, nil); err != nil || len(out.Nodes) != len(ms.Nodes) {
+		t.Fatalf("external buffer import failed: %v", err)
+	}
+	if _, err = GltfToMstFromReader(bytes.NewReader(bt), nil); err == nil {
+		t.Fatal("expected external buffer without base directory to fail")
+	}
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0755)
+	doc.Buffers[0] = &gltf.Buffer{ByteLength: uint32(len(data)), URI: "../../etc/passwd"}
+	esc, _ := json.Marshal(doc)
+	if _, err = GltfToMstFromReader(bytes.NewReader(esc), &GltfImportOptions{BaseDir: sub}); err == nil {
+		t.Fatal("expected a buffer outside the base directory to be rejected")
+	}
+	for _, uri := range []string{"../mesh%20data.bin", "../../etc/passwd", "/etc/passwd", "sub/../../mesh%20data.bin"} {
+		if _, err := readGltfResource(sub, uri); err == nil || !strings.Contains(err.Error(), "escapes the base directory") {
+			t.Fatalf("expected %q to be rejected, got %v", uri, err)
+		}
+	}
+	if _, err := readGltfResource(dir, "sub/../mesh%20data.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	doc.Buffers[0] = &gltf.Buffer{ByteLength: uint32(len(data)), URI: "data:application/gltf-buffer;base64," + base64.StdEncoding.EncodeToString(data)}
+	if out, err = GltfToMst(doc); err != nil || len(out.Nodes) != len(ms.Nodes) {
+		t.Fatalf("data URI import failed: %v", err)
+	}
+	if doc.Buffers[0].Data != nil {
+		t.Fatal("import modified the source document")
+	}
+}
+
+func TestGltfAccessorLayouts(t *testing.T) {
+	le := func(vs ...interface{}) []byte {
+		buf := &bytes.Buffer{}
+		for _, v := range vs {
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+		return buf.Bytes()
+	}
+	interleaved := le(
+		[3]float32{0, 0, 0}, [3]float32{0, 0, 1},
+		[3]float32{1, 0, 0}, [3]float32{0, 0, 1},
+		[3]float32{0, 1, 0}, [3]float32{0, 0, 1},
+		[3]float32{1, 1, 0}, [3]float32{0, 0, 1},
+	)
+	uvs := le([2]uint8{0, 0}, [2]uint8{255, 0}, [2]uint8{0, 255}, [2]uint8{255, 255})
+	indices := le([]uint16{0, 1, 2, 2, 1, 3})
+	sparse := le(uint8(3), [3]float32{2, 2, 0})
+	var data []byte
+	offset := func(b []byte) uint32 {
+		off := uint32(len(data))
+		data = append(data, b...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+		return off
+	}
+	iOff, uOff, xOff, sOff := offset(interleaved), offset(uvs), offset(indices), offset(sparse)
+	u32 := func(v uint32) *uint32 { return &v }
+	doc := &gltf.Document{
+		Buffers: []*gltf.Buffer{{ByteLength: uint32(len(data)), Data: data}},
+		BufferViews: []*gltf.BufferView{
+			{ByteOffset: iOff, ByteLength: uint32(len(interleaved)), ByteStride: 24},
+			{ByteOffset: uOff, ByteLength: uint32(len(uvs))},
+			{ByteOffset: xOff, ByteLength: uint32(len(indices))},
+			{ByteOffset: sOff, ByteLength: uint32(len(sparse))},
+		},
+		Accessors: []*gltf.Accessor{
+			{BufferView: u32(0), Count: 4, Type: gltf.AccessorVec3, ComponentType: gltf.ComponentFloat, Sparse: &gltf.Sparse{
+				Count:   1,
+				Indices: gltf.SparseIndices{BufferView: 3, ComponentType: gltf.ComponentUbyte},
+				Values:  gltf.SparseValues{BufferView: 3, ByteOffset: 1},
+			}},
+			{BufferView: u32(0), ByteOffset: 12, Count: 4, Type: gltf.AccessorVec3, ComponentType: gltf.ComponentFloat},
+			{BufferView: u32(1), Count: 4, Type: gltf.AccessorVec2, ComponentType: gltf.ComponentUbyte, Normalized: true},
+			{BufferView: u32(2), Count: 6, Type: gltf.AccessorScalar, ComponentType: gltf.ComponentUshort},
+		},
+		Meshes: []*gltf.Mesh{{Primitives: []*gltf.Primitive{{
+			Attributes: gltf.Attribute{"POSITION": 0, "NORMAL": 1, "TEXCOORD_0": 2},
+			Indices:    u32(3),
+			Mode:       gltf.PrimitiveTriangles,
+		}}}},
+		Nodes:  []*gltf.Node{{Mesh: u32(0)}},
+		Scenes: []*gltf.Scene{{Nodes: []uint32{0}}},
+	}
+	ms, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := ms.Nodes[0]
+	if len(nd.Vertices) != 4 || nd.Vertices[1] != (vec3.T{1, 0, 0}) || nd.Vertices[3] != (vec3.T{2, 2, 0}) {
+		t.Fatalf("unexpected positions %v", nd.Vertices)
+	}
+	if nd.Normals[2] != (vec3.T{0, 0, 1}) || nd.TexCoords[3] != (vec2.T{1, 1}) {
+		t.Fatalf("unexpected attributes %v %v", nd.Normals, nd.TexCoords)
+	}
+	if f := nd.FaceGroup[0].Faces[1].Vertex; f != [3]uint32{2, 1, 3} {
+		t.Fatalf("unexpected ushort indices %v", f)
+	}
+
+	doc.BufferViews[0].ByteStride = 8
+	if _, err := GltfToMst(doc); err == nil {
+		t.Fatal("expected stride smaller than the element to fail")
+	}
+	doc.BufferViews[0].ByteStride = 24
+	doc.Accessors[3].Count = 7
+	if _, err := GltfToMst(doc); err == nil {
+		t.Fatal("expected accessor overrunning its buffer view to fail")
+	}
+	doc.Accessors[3].Count = 0
+	doc.Accessors[3].ByteOffset = 1000
+	if _, err := GltfToMst(doc); err == nil {
+		t.Fatal("expected empty accessor offset past its buffer view to fail")
+	}
+	doc.Accessors[3].ByteOffset = 0
+	doc.Accessors[3].Count = 1 << 31
+	if _, err := GltfToMst(doc); err == nil {
+		t.Fatal("expected oversized accessor count to fail")
+	}
+}
+
+func TestGltfSceneTransforms(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = append(ms.Materials, &BaseMaterial{Color: [3]byte{255, 0, 0}})
+	ms.Nodes = append(ms.Nodes, newTestCubeNode())
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Nodes[0].Rotation = [4]float32{0, 0, 0.70710677, 0.70710677}
+	doc.Nodes = append(doc.Nodes, &gltf.Node{Name: "root", Translation: [3]float32{10, 0, 0}, Scale: [3]float32{2, 2, 2}, Children: []uint32{0}})
+	doc.Scenes[0].Nodes = []uint32{1}
+
+	want := func(v vec3.T) vec3.T { return vec3.T{10 - 2*v[1], 2 * v[0], 2 * v[2]} }
+	near := func(a, b vec3.T) bool {
+		d := vec3.Sub(&a, &b)
+		return d.Length() < 1e-4
+	}
+	baked, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := ms.Nodes[0].Vertices
+	for i, v := range baked.Nodes[0].Vertices {
+		if !near(v, want(src[i])) {
+			t.Fatalf("vertex %d: got %v, want %v", i, v, want(src[i]))
+		}
+	}
+
+	kept, err := GltfToMstWithOptions(doc, &GltfImportOptions{KeepTransforms: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := kept.Nodes[0]
+	if nd.Mat == nil || !near(nd.Vertices[1], src[1]) {
+		t.Fatal("expected transform to be kept in MeshNode.Mat")
+	}
+	p := dvec3.T{float64(src[1][0]), float64(src[1][1]), float64(src[1][2])}
+	nd.Mat.TransformVec3(&p)
+	if !near(vec3.T{float32(p[0]), float32(p[1]), float32(p[2])}, want(src[1])) {
+		t.Fatalf("kept matrix maps %v to %v", src[1], p)
+	}
+	if root := kept.Nodes[1]; root.Name != "root" || root.Mat == nil || len(root.Children) != 1 {
+		t.Fatal("expected group node to keep its transform")
+	}
+
+	out, err := MstToGltf([]*Mesh{kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := GltfToMst(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range again.Nodes[0].Vertices {
+		if !near(v, want(src[i])) {
+			t.Fatalf("round trip vertex %d: got %v, want %v", i, v, want(src[i]))
+		}
+	}
+}
+
+func TestTexCoords2(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode = nil
+	ms.Materials[1] = &TextureMaterial{Texture: &Texture{Id: 1, Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: []byte{1, 2, 3, 4}}, TexCoord: TEXCOORD_SET_1}
+	nd := ms.Nodes[1]
+	for i := range nd.Vertices {
+		nd.TexCoords2 = append(nd.TexCoords2, vec2.T{float32(i) / 10, 0.5})
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V17 || len(out.Nodes[1].TexCoords2) != len(nd.TexCoords2) || out.Nodes[1].TexCoords2[3] != nd.TexCoords2[3] || len(out.Nodes[0].TexCoords2) != 0 {
+		t.Fatal("second texture coordinates not preserved")
+	}
+	if out.Materials[1].(*TextureMaterial).TexCoord != TEXCOORD_SET_1 {
+		t.Fatal("material texture coordinate set not preserved")
+	}
+	conv, warns := ConvertVersion(ms, V16)
+	if conv.Nodes[1].TexCoords2 != nil || conv.Materials[1].(*TextureMaterial).TexCoord != TEXCOORD_SET_0 || len(warns) != 2 || nd.TexCoords2 == nil {
+		t.Fatalf("expected second texture coordinates to be dropped, got %v", warns)
+	}
+
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc.Meshes[1].Primitives[0].Attributes["TEXCOORD_1"]; !ok {
+		t.Fatal("TEXCOORD_1 not exported")
+	}
+	if tex := doc.Materials[1].PBRMetallicRoughness.BaseColorTexture; tex == nil || tex.TexCoord != 1 {
+		t.Fatal("base color texture coordinate set not exported")
+	}
+	imp, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Nodes[0].TexCoords2 != nil || len(imp.Nodes[1].TexCoords2) != len(imp.Nodes[1].Vertices) {
+		t.Fatal("TEXCOORD_1 not imported")
+	}
+	if imp.Materials[1].(*TextureMaterial).TexCoord != TEXCOORD_SET_1 {
+		t.Fatal("texture coordinate set not imported")
+	}
+}
+
+func TestFaceIndices(t *testing.T) {
+	nd := &MeshNode{
+		Vertices:  []vec3.T{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+		Normals:   []vec3.T{{0, 0, 1}},
+		TexCoords: []vec2.T{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0.5, 0.5}},
+		FaceGroup: []*MeshTriangle{{Faces: []*Face{
+			{Vertex: [3]uint32{0, 1, 2}, Normal: &[3]uint32{0, 0, 0}, Uv: &[3]uint32{0, 1, 2}},
+			{Vertex: [3]uint32{0, 2, 3}, Normal: &[3]uint32{0, 0, 0}, Uv: &[3]uint32{4, 2, 3}},
+		}}},
+	}
+	ms := NewMesh()
+	ms.Nodes = append(ms.Nodes, nd)
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faces := out.Nodes[0].FaceGroup[0].Faces
+	if out.Version != V19 || faces[0].Uv != nil || faces[1].Uv == nil || *faces[1].Uv != [3]uint32{4, 2, 3} || faces[0].Normal == nil || *faces[0].Normal != [3]uint32{0, 0, 0} {
+		t.Fatal("face indices not preserved")
+	}
+	if got, err := ExtractNodeFrom(bytes.NewReader(buf.Bytes()), "0"); err != nil || len(got.Nodes[0].FaceGroup[0].Faces) != 2 {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	conv, warns := ConvertVersion(ms, V18)
+	if len(warns) != 1 || len(conv.Nodes[0].Vertices) != 6 || conv.Nodes[0].TexCoords[3] != (vec2.T{0.5, 0.5}) || nd.FaceGroup[0].Faces[1].Uv == nil {
+		t.Fatalf("expected separate indices to be flattened, got %v", warns)
+	}
+
+	buf.Reset()
+	nd.FaceGroup[0].Faces[1].Uv = &[3]uint32{9, 2, 3}
+	MeshMarshal(buf, ms)
+	if _, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions); err == nil {
+		t.Fatal("expected out of range texcoord index to fail")
+	}
+}
+
+func TestExternalTextures(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode = nil
+	data := []byte{1, 2, 3, 4}
+	ms.Materials[1] = &TextureMaterial{Texture: &Texture{Id: 1, Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: data, URI: "textures/facade.raw"}}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms, WithExternalTextures())
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tex := out.Materials[1].(*TextureMaterial).Texture
+	if out.Version != V20 || tex.URI != "textures/facade.raw" || len(tex.Data) != 0 || !tex.IsExternal() {
+		t.Fatal("external texture not written by reference")
+	}
+	if len(ms.Materials[1].(*TextureMaterial).Texture.Data) != 4 {
+		t.Fatal("external texture mode must not modify the source mesh")
+	}
+	if _, err := LoadTexture(tex, false); err != ErrUnresolvedTexture {
+		t.Fatalf("expected ErrUnresolvedTexture, got %v", err)
+	}
+
+	var uris []string
+	opts := DefaultDecodeOptions
+	opts.Textures = TextureResolverFunc(func(tex *Texture) ([]byte, error) {
+		uris = append(uris, tex.URI)
+		return data, nil
+	})
+	out, err = MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tex := out.Materials[1].(*TextureMaterial).Texture; !bytes.Equal(tex.Data, data) || len(uris) != 1 {
+		t.Fatal("external texture not resolved on decode")
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{ExternalTextures: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Images) != 1 || doc.Images[0].URI != "textures/facade.raw" || doc.Images[0].BufferView != nil {
+		t.Fatal("glTF image not exported by uri")
+	}
+
+	conv, warns := ConvertVersion(ms, V19)
+	if conv.Materials[1].(*TextureMaterial).Texture.URI != "" || len(warns) != 1 || ms.Materials[1].(*TextureMaterial).Texture.URI == "" {
+		t.Fatalf("expected texture uris to be dropped, got %v", warns)
+	}
+}
+
+func TestMaterialPalette(t *testing.T) {
+	dir := t.TempDir()
+	pal := NewMaterialPalette()
+	var tiles []*Mesh
+	for i := 0; i < 2; i++ {
+		ms := newTestMesh()
+		ms.InstanceNode = nil
+		ms.UseMaterialPalette(pal, "facades"+MESH_PALETTE_EXT)
+		tiles = append(tiles, ms)
+	}
+	if len(pal.Materials) != len(tiles[0].Materials) {
+		t.Fatalf("palette not deduplicated: %d entries", len(pal.Materials))
+	}
+	if err := SaveMaterialPalette(filepath.Join(dir, "facades"+MESH_PALETTE_EXT), pal); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tile.mst")
+	if err := MeshWriteTo(path, tiles[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, tiles[0])
+	raw, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, ok := raw.Materials[1].(*MaterialRef)
+	if raw.Version != V21 || !ok || ref.Material != nil || ref.Hash != MaterialHash(tiles[0].Materials[1]) {
+		t.Fatal("materials not written as palette references")
+	}
+
+	out, err := MeshReadFrom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Materials[1].GetColor() != tiles[1].Materials[1].GetColor() || out.Materials[1].(*MaterialRef).Material == nil {
+		t.Fatal("palette references not resolved by MeshReadFrom")
+	}
+
+	conv, warns := ConvertVersion(tiles[0], V20)
+	if _, ok := conv.Materials[0].(*MaterialRef); ok || len(warns) != len(tiles[0].Materials) {
+		t.Fatalf("expected references to be embedded, got %v", warns)
+	}
+	var he *MaterialPaletteHashError
+	if _, err := pal.ResolveMaterialRef(&MaterialRef{URI: "facades.mstmat", Hash: 1}); !errors.As(err, &he) {
+		t.Fatalf("expected MaterialPaletteHashError, got %v", err)
+	}
+}
+
+func TestProgressAndCancellation(t *testing.T) {
+	ms := newTestMesh()
+	var stages []string
+	record := func(stage string, done, total int) {
+		stages = append(stages, fmt.Sprintf("%s %d/%d", stage, done, total))
+	}
+	buf := &bytes.Buffer{}
+	if err := MeshMarshalContext(context.Background(), buf, ms, WithProgress(record)); err != nil {
+		t.Fatal(err)
+	}
+	want := "[materials 2/2 nodes 1/2 nodes 2/2 instances 1/1]"
+	if fmt.Sprint(stages) != want {
+		t.Fatalf("unexpected encode progress %v", stages)
+	}
+	data := buf.Bytes()
+
+	stages = nil
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(data), &DecodeOptions{Limits: &DefaultDecodeLimits, Progress: record})
+	if err != nil || !out.Equal(ms) {
+		t.Fatalf("decode with progress: %v", err)
+	}
+	if fmt.Sprint(stages) != want {
+		t.Fatalf("unexpected decode progress %v", stages)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := MeshMarshalContext(ctx, &bytes.Buffer{}, ms); err != context.Canceled {
+		t.Fatalf("expected canceled encode, got %v", err)
+	}
+	if _, err := MeshUnMarshalContext(ctx, bytes.NewReader(data), nil); err != context.Canceled {
+		t.Fatalf("expected canceled decode, got %v", err)
+	}
+	if err := NewEncoder(&bytes.Buffer{}, WithContext(ctx)).Encode(ms); err != context.Canceled {
+		t.Fatalf("expected canceled encoder, got %v", err)
+	}
+
+	stop, cancelAfter := context.WithCancel(context.Background())
+	defer cancelAfter()
+	_, err = MeshUnMarshalWithOptions(bytes.NewReader(data), &DecodeOptions{Context: stop, Progress: func(stage string, done, total int) {
+		if stage == PROGRESS_STAGE_NODES {
+			cancelAfter()
+		}
+	}})
+	if err != context.Canceled {
+		t.Fatalf("expected decode to stop after cancellation, got %v", err)
+	}
+
+	if _, err := MstToGltfContext(ctx, []*Mesh{ms}, &GltfExportOptions{ContinueOnError: true}); err != context.Canceled {
+		t.Fatalf("expected canceled glTF export, got %v", err)
+	}
+	stages = nil
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{GpuInstance: true, Progress: record})
+	if err != nil || fmt.Sprint(stages) != "[gltf 1/2 gltf 2/2]" {
+		t.Fatalf("unexpected glTF export progress %v: %v", stages, err)
+	}
+	if _, err := GltfToMstContext(ctx, doc, nil); err != context.Canceled {
+		t.Fatalf("expected canceled glTF import, got %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var logs []string
+	logger := LoggerFunc(func(level uint8, msg string, keyvals ...interface{}) {
+		logs = append(logs, fmt.Sprintf("%d %s %v", level, msg, keyvals))
+	})
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, newTestMesh(), WithChecksum())
+	bad := append([]byte{}, buf.Bytes()...)
+	bad[len(bad)-len(MESH_FOOTER_SIGNATURE)-4] ^= 0xff
+	if _, err := MeshUnMarshalWithOptions(bytes.NewReader(bad), &DecodeOptions{Logger: logger}); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || !strings.HasPrefix(logs[0], "2 ignored checksum mismatch") {
+		t.Fatalf("unexpected decode logs %v", logs)
+	}
+
+	doc, err := MstToGltf([]*Mesh{newTestMesh()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.ExtensionsUsed = append(doc.ExtensionsUsed, "KHR_draco_mesh_compression")
+	doc.Meshes[0].Primitives[0].Mode = gltf.PrimitivePoints
+	logs = nil
+	if _, err := GltfToMstWithOptions(doc, &GltfImportOptions{ContinueOnError: true, Logger: logger}); err == nil {
+		t.Fatal("expected collected primitive error")
+	}
+	if len(logs) != 2 || !strings.Contains(logs[0], "unsupported glTF extension [extension KHR_draco_mesh_compression required false]") ||
+		!strings.Contains(logs[1], "recovered error [field primitives[0]") {
+		t.Fatalf("unexpected import logs %v", logs)
+	}
+	logs = nil
+	if _, err := GltfToMstWithOptions(doc, &GltfImportOptions{Logger: logger}); err == nil || len(logs) != 1 {
+		t.Fatalf("expected a fatal error without recovered logs: %v %v", err, logs)
+	}
+
+	out := &bytes.Buffer{}
+	NewStdLogger(log.New(out, "", 0)).Log(LOG_WARN, "skipped", "field", "nodes[1]", "dangling")
+	if out.String() != "warn skipped field=nodes[1] dangling=?\n" {
+		t.Fatalf("unexpected std logger output %q", out.String())
+	}
+}
+
+func TestTextureColorSpace(t *testing.T) {
+	wide := image.NewNRGBA64(image.Rect(0, 0, 2, 2))
+	wide.SetNRGBA64(0, 0, color.NRGBA64{R: 0x1234, G: 0x8000, B: 0xffff, A: 0xffff})
+	dir, err := ioutil.TempDir("", "mst-color")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "wide.png")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, wide)
+	f.Close()
+
+	raw, err := CreateTextureWithCompression(p, false, TEXTURE_COMPRESSED_NONE)
+	if err != nil || raw.Type != TEXTURE_PIXEL_TYPE_USHORT || len(raw.Data) != 2*2*4*2 || raw.Validate() != nil {
+		t.Fatalf("16-bit png not preserved: %v", err)
+	}
+	src, err := CreateTextureWithCompression(p, false, TEXTURE_COMPRESSED_SOURCE)
+	if err != nil || src.Type != TEXTURE_PIXEL_TYPE_USHORT {
+		t.Fatalf("16-bit source texture not flagged: %v", err)
+	}
+	for _, tex := range []*Texture{raw, src} {
+		img, err := LoadTexture(tex, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := color.NRGBA64Model.Convert(img.At(0, 0)).(color.NRGBA64); c.R != 0x1234 || c.G != 0x8000 {
+			t.Fatalf("16-bit pixel lost: %v", c)
+		}
+	}
+
+	gray := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	gray.SetNRGBA(0, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	lin, err := imageTexture(gray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lin.ColorSpace = TEXTURE_COLOR_SPACE_LINEAR
+	if !lin.IsLinear(true) || (&Texture{}).IsLinear(true) || !(&Texture{}).IsLinear(false) {
+		t.Fatal("unexpected effective color space")
+	}
+	conv, err := lin.ConvertColorSpace(TEXTURE_COLOR_SPACE_SRGB, true)
+	if err != nil || conv.ColorSpace != TEXTURE_COLOR_SPACE_SRGB {
+		t.Fatalf("conversion failed: %v", err)
+	}
+	if px, _ := conv.Pixels(); px[0] != 188 {
+		t.Fatalf("expected linear 0.5 to become sRGB 188, got %d", px[0])
+	}
+
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{
+		&PbrMaterial{TextureMaterial: TextureMaterial{Texture: lin}},
+		&PhongMaterial{LambertMaterial: LambertMaterial{TextureMaterial: TextureMaterial{Texture: &Texture{Id: 1, Format: TEXTURE_FORMAT_RGBA, Size: [2]uint64{1, 1}, Data: []byte{128, 128, 128, 255}}}}},
+	}
+	ms.Nodes = []*MeshNode{newTestCubeNode()}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions)
+	if err != nil || dec.Version != V24 || dec.Materials[0].GetTexture().ColorSpace != TEXTURE_COLOR_SPACE_LINEAR {
+		t.Fatalf("color space not round tripped: %v", err)
+	}
+	old, warns := ConvertVersion(ms, V23)
+	if old.Materials[0].GetTexture().ColorSpace != TEXTURE_COLOR_SPACE_DEFAULT || len(warns) == 0 || lin.ColorSpace != TEXTURE_COLOR_SPACE_LINEAR {
+		t.Fatalf("expected color spaces to be dropped for V23: %v", warns)
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bv := doc.BufferViews[*doc.Images[0].BufferView]
+	img, err := png.Decode(bytes.NewReader(doc.Buffers[0].Data[bv.ByteOffset : bv.ByteOffset+bv.ByteLength]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA); c.R != 188 {
+		t.Fatalf("linear base color texture not converted to sRGB on export: %v", c)
+	}
+	sp, ok := doc.Materials[1].Extensions[specular.ExtensionName].(*specular.PBRSpecularGlossiness)
+	if !ok || sp.DiffuseTexture == nil || sp.DiffuseTexture.Index != doc.Materials[1].PBRMetallicRoughness.BaseColorTexture.Index {
+		t.Fatal("diffuse texture missing from specular-glossiness extension")
+	}
+	imp, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tex := imp.Materials[0].GetTexture(); tex == nil || tex.ColorSpace != TEXTURE_COLOR_SPACE_SRGB {
+		t.Fatal("imported base color texture not flagged sRGB")
+	}
+}
+
+func TestRawTextures(t *testing.T) {
+	depth := &Texture{Id: 1, Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_DEPTH_COMPONENT, Type: TEXTURE_PIXEL_TYPE_FLOAT}
+	if err := depth.SetValues([]float64{0, 0.25, 0.5, 1}); err != nil {
+		t.Fatal(err)
+	}
+	ids := &Texture{Id: 2, Size: [2]uint64{1, 2}, Format: TEXTURE_FORMAT_RG_INTEGER, Type: TEXTURE_PIXEL_TYPE_INT}
+	if err := ids.SetValues([]float64{-7, 70000, 3, -1}); err != nil {
+		t.Fatal(err)
+	}
+	half := &Texture{Size: [2]uint64{3, 1}, Format: TEXTURE_FORMAT_R, Type: TEXTURE_PIXEL_TYPE_HALF}
+	if err := half.SetValues([]float64{1.5, -2, 65504}); err != nil {
+		t.Fatal(err)
+	}
+	if vals, err := half.Values(); err != nil || vals[0] != 1.5 || vals[1] != -2 || vals[2] != 65504 {
+		t.Fatalf("half values not round tripped: %v %v", vals, err)
+	}
+	if err := ids.SetValues([]float64{1}); err == nil {
+		t.Fatal("expected value count error")
+	}
+	for _, tex := range []*Texture{depth, ids, half} {
+		if tex.IsImage() || tex.Validate() != nil {
+			t.Fatalf("unexpected classification for format %d", tex.Format)
+		}
+		var fe *TextureFormatError
+		if _, err := LoadTexture(tex, false); !errors.As(err, &fe) || fe.Format != tex.Format {
+			t.Fatalf("expected TextureFormatError, got %v", err)
+		}
+	}
+	if err := (&Texture{Format: TEXTURE_FORMAT_DEPTH_COMPONENT, Compressed: TEXTURE_COMPRESSED_SOURCE, Data: pngSignature}).Validate(); err == nil {
+		t.Fatal("expected source-encoded depth texture to be rejected")
+	}
+
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&TextureMaterial{Texture: depth, Normal: ids}}
+	ms.Nodes = []*MeshNode{newTestCubeNode()}
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Images) != 0 || len(doc.Textures) != 2 || doc.Textures[0].Source != nil {
+		t.Fatalf("raw textures exported as images: %d images", len(doc.Images))
+	}
+	bin, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := &gltf.Document{}
+	if err := gltf.NewDecoder(bytes.NewReader(bin)).Decode(dec); err != nil {
+		t.Fatal(err)
+	}
+	out, err := GltfToMst(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := materialTextures(out.Materials[0])
+	if tm == nil || tm.Texture == nil || tm.Normal == nil {
+		t.Fatal("raw textures not imported")
+	}
+	for _, pair := range [][2]*Texture{{depth, tm.Texture}, {ids, tm.Normal}} {
+		want, _ := pair[0].Values()
+		got, err := pair[1].Values()
+		if err != nil || pair[1].Format != pair[0].Format || pair[1].Type != pair[0].Type || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("raw texture not round tripped: %v %v", got, err)
+		}
+	}
+}
+
+func TestLazyTexture(t *testing.T) {
+	raw := make([]byte, 4*3*3)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	tex := &Texture{Size: [2]uint64{4, 3}, Format: TEXTURE_FORMAT_RGB, Compressed: TEXTURE_COMPRESSED_ZLIB, Data: CompressImage(raw)}
+	cache := NewTextureCache(int64(len(raw)))
+	lt, err := NewLazyTexture(tex, cache)
+	if err != nil || lt.Stride() != 12 {
+		t.Fatalf("lazy texture: %v", err)
+	}
+	rows, err := lt.Rows(1, 3)
+	if err != nil || !bytes.Equal(rows, raw[12:]) {
+		t.Fatalf("rows mismatch: %v", err)
+	}
+	if st := cache.Stats(); st.Entries != 0 {
+		t.Fatal("row decode populated the cache")
+	}
+	tile, err := lt.Tile(1, 1, 2, 2)
+	if err != nil || !bytes.Equal(tile, append(append([]byte(nil), raw[15:21]...), raw[27:33]...)) {
+		t.Fatalf("tile mismatch: %v", err)
+	}
+	if _, err := lt.Rows(2, 4); err == nil {
+		t.Fatal("expected row range error")
+	}
+	for y := 0; y < 3; y++ {
+		if row, err := lt.Rows(y, y+1); err != nil || !bytes.Equal(row, raw[y*12:(y+1)*12]) || lt.zoff != (y+1)*12 {
+			t.Fatalf("sequential row %d mismatch at offset %d: %v", y, lt.zoff, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if px, err := lt.Pixels(); err != nil || !bytes.Equal(px, raw) {
+			t.Fatalf("pixels mismatch: %v", err)
+		}
+	}
+	if st := cache.Stats(); st.Entries != 1 || st.Hits != 1 || st.Bytes != int64(len(raw)) {
+		t.Fatalf("unexpected cache stats %+v", st)
+	}
+	other := &Texture{Size: [2]uint64{4, 3}, Format: TEXTURE_FORMAT_RGB, Compressed: TEXTURE_COMPRESSED_ZLIB, Data: CompressImage(append(append([]byte(nil), raw[:len(raw)-1]...), 0xff))}
+	if _, err := cache.Pixels(other); err != nil {
+		t.Fatal(err)
+	}
+	if st := cache.Stats(); st.Entries != 1 || st.Bytes != int64(len(raw)) {
+		t.Fatalf("budget not enforced %+v", st)
+	}
+	cache.SetBudget(0)
+	if st := cache.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Fatalf("budget change did not evict %+v", st)
+	}
+}
+
+func TestOptimizeForGPU(t *testing.T) {
+	const size = 24
+	nd := &MeshNode{}
+	for y := 0; y <= size; y++ {
+		for x := 0; x <= size; x++ {
+			nd.Vertices = append(nd.Vertices, vec3.T{float32(x), float32(y), 0})
+			nd.Colors = append(nd.Colors, [3]byte{byte(x), byte(y), 0})
+		}
+	}
+	var faces []*Face
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			a := uint32(y*(size+1) + x)
+			b, c, d := a+1, a+size+1, a+size+2
+			faces = append(faces, &Face{Vertex: [3]uint32{a, b, d}}, &Face{Vertex: [3]uint32{a, d, c}})
+		}
+	}
+	shuffled := make([]*Face, len(faces))
+	for i := range faces {
+		shuffled[(i*7919)%len(faces)] = faces[i]
+	}
+	g := &MeshTriangle{Faces: shuffled}
+	for i := range shuffled {
+		g.SetFaceFeature(i, uint64(shuffled[i].Vertex[0]))
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.EdgeGroup = []*MeshOutline{{Edges: [][2]uint32{{0, 1}}}}
+	corners := func(n *MeshNode) map[[3]vec3.T]uint64 {
+		out := make(map[[3]vec3.T]uint64)
+		for i, f := range n.FaceGroup[0].Faces {
+			id, _ := n.FaceGroup[0].FaceFeature(i)
+			out[[3]vec3.T{n.Vertices[f.Vertex[0]], n.Vertices[f.Vertex[1]], n.Vertices[f.Vertex[2]]}] = id
+		}
+		return out
+	}
+	want := corners(nd)
+	before := nd.ACMR(16)
+	if err := nd.OptimizeForGPU(); err != nil {
+		t.Fatal(err)
+	}
+	if after := nd.ACMR(16); after >= before || after > 0.8 {
+		t.Fatalf("acmr not improved: %v -> %v", before, after)
+	}
+	if !reflect.DeepEqual(corners(nd), want) {
+		t.Fatal("optimization changed triangles or their features")
+	}
+	next := uint32(0)
+	for _, f := range nd.FaceGroup[0].Faces {
+		for _, v := range f.Vertex {
+			if v > next {
+				t.Fatalf("vertex %d fetched before %d", v, next)
+			}
+			if v == next {
+				next++
+			}
+		}
+	}
+	for i, v := range nd.Vertices {
+		if nd.Colors[i] != [3]byte{byte(v[0]), byte(v[1]), 0} {
+			t.Fatal("colors not reordered with vertices")
+		}
+	}
+	e := nd.EdgeGroup[0].Edges[0]
+	if nd.Vertices[e[0]] != (vec3.T{0, 0, 0}) || nd.Vertices[e[1]] != (vec3.T{1, 0, 0}) {
+		t.Fatal("edges not remapped")
+	}
+	if err := nd.OptimizeForGPUWithOptions(&GPUOptimizeOptions{CacheSize: 2, OverdrawThreshold: 1}); err == nil {
+		t.Fatal("expected options error")
+	}
+}
+
+func BenchmarkOptimizeForGPU(b *testing.B) {
+	src := GenerateMesh(&DefaultGenerateOptions)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		ms := src.Clone()
+		b.StartTimer()
+		for _, nd := range ms.Nodes {
+			if err := nd.OptimizeForGPU(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestPrimitiveModes(t *testing.T) {
+	nd := &MeshNode{Vertices: []vec3.T{{0, 0, 0}, {0, 1, 0}, {1, 0, 0}, {1, 1, 0}, {2, 0, 0}, {2, 1, 0}}}
+	if err := nd.AddPrimitive(0, MESH_TRIANGLE_MODE_STRIP, []uint32{0, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddPrimitive(1, MESH_TRIANGLE_MODE_FAN, []uint32{0, 2, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddPrimitive(2, 7, []uint32{0, 1, 2}); err == nil {
+		t.Fatal("expected unknown mode error")
+	}
+	strip, fan := nd.FaceGroup[0], nd.FaceGroup[1]
+	if len(strip.Faces) != 4 || strip.Faces[1].Vertex != [3]uint32{2, 1, 3} || len(fan.Faces) != 2 || fan.Faces[1].Vertex != [3]uint32{0, 4, 5} {
+		t.Fatal("primitive not expanded to faces")
+	}
+	if mode, idx := fan.PrimitiveIndices(); mode != MESH_TRIANGLE_MODE_FAN || !reflect.DeepEqual(idx, []uint32{0, 2, 4, 5}) {
+		t.Fatalf("unexpected fan indices %d %v", mode, idx)
+	}
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}, &BaseMaterial{}}
+	ms.Nodes = []*MeshNode{nd}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out := MeshUnMarshal(bytes.NewReader(buf.Bytes()))
+	if out == nil || out.Nodes[0].FaceGroup[0].Mode != MESH_TRIANGLE_MODE_STRIP || out.Nodes[0].FaceGroup[1].Mode != MESH_TRIANGLE_MODE_FAN {
+		t.Fatal("primitive modes not round tripped")
+	}
+	if d, _ := Diff(ms, out, DiffOptions{}); !d.Empty() {
+		t.Fatalf("round trip changed the mesh: %+v", d)
+	}
+
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prims := doc.Meshes[0].Primitives
+	if prims[0].Mode != gltf.PrimitiveTriangleStrip || prims[1].Mode != gltf.PrimitiveTriangleFan ||
+		doc.Accessors[*prims[0].Indices].Count != 6 || doc.Accessors[*prims[1].Indices].Count != 4 || doc.Accessors[*prims[1].Indices].ByteOffset != 24 {
+		t.Fatalf("strip and fan not exported %+v %+v", prims[0], prims[1])
+	}
+	back, err := GltfToMst(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := back.Nodes[0].FaceGroup[0]; g.PrimitiveMode() != MESH_TRIANGLE_MODE_STRIP || len(g.Faces) != 4 {
+		t.Fatal("strip not imported")
+	}
+
+	old, warns := ConvertVersion(ms, V26)
+	if len(warns) != 1 || old.Nodes[0].FaceGroup[0].Mode != MESH_TRIANGLE_MODE_TRIANGLES || nd.FaceGroup[0].Mode != MESH_TRIANGLE_MODE_STRIP {
+		t.Fatalf("unexpected conversion %v", warns)
+	}
+
+	strip.Faces[0], strip.Faces[1] = strip.Faces[1], strip.Faces[0]
+	if strip.PrimitiveMode() != MESH_TRIANGLE_MODE_TRIANGLES {
+		t.Fatal("reordered strip still reported as strip")
+	}
+	ms.ConvertToTriangles()
+	if fan.Mode != MESH_TRIANGLE_MODE_TRIANGLES {
+		t.Fatal("fan not converted to triangles")
+	}
+}
+
+func TestPolygons(t *testing.T) {
+	nd := &MeshNode{Vertices: []vec3.T{
+		{0, 0, 0}, {4, 0, 0}, {4, 4, 0}, {0, 4, 0},
+		{1, 1, 0}, {1, 3, 0}, {3, 3, 0}, {3, 1, 0},
+		{0, 0, 1}, {2, 0, 1}, {2, 1, 1}, {1, 1, 1}, {1, 2, 1}, {0, 2, 1},
+	}}
+	square := &Polygon{Outer: []uint32{0, 1, 2, 3}, Holes: [][]uint32{{4, 5, 6, 7}}}
+	ell := &Polygon{Outer: []uint32{8, 9, 10, 11, 12, 13}}
+	if err := nd.AddPolygons(0, []*Polygon{square, ell}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddPolygons(1, []*Polygon{{Outer: []uint32{0, 1, 99}}}); err == nil {
+		t.Fatal("expected out of range polygon vertex")
+	}
+	area := func(tris [][3]uint32) float32 {
+		var sum float32
+		for _, tr := range tris {
+			a, b, c := nd.Vertices[tr[0]], nd.Vertices[tr[1]], nd.Vertices[tr[2]]
+			e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+			cr := vec3.Cross(&e1, &e2)
+			if cr[2] <= 0 {
+				t.Fatalf("triangle %v not counter-clockwise", tr)
+			}
+			sum += cr.Length() / 2
+		}
+		return sum
+	}
+	tris, err := square.Triangulate(nd.Vertices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tris) != 8 || math.Abs(float64(area(tris))-12) > 1e-4 {
+		t.Fatalf("unexpected square with hole triangulation %v", tris)
+	}
+	tris, err = ell.Triangulate(nd.Vertices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tris) != 4 || math.Abs(float64(area(tris))-3) > 1e-4 {
+		t.Fatalf("unexpected L triangulation %v", tris)
+	}
+
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	ms.Nodes = []*MeshNode{nd}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out := MeshUnMarshal(bytes.NewReader(buf.Bytes()))
+	if out == nil || len(out.Nodes[0].PolygonGroup) != 1 {
+		t.Fatal("polygons not round tripped")
+	}
+	if d, _ := Diff(ms, out, DiffOptions{}); !d.Empty() {
+		t.Fatalf("round trip changed the mesh: %+v", d)
+	}
+	if _, warns := ConvertVersion(ms, V27); len(warns) != 1 {
+		t.Fatalf("unexpected conversion warnings %v", warns)
+	}
+
+	if st := ms.Stats(); st.Triangles != 12 || st.MaterialFaces[0] != 12 {
+		t.Fatalf("polygons not counted as triangles %+v", st)
+	}
+	if a := nd.SurfaceArea(); math.Abs(a-15) > 1e-4 {
+		t.Fatalf("unexpected polygon surface area %v", a)
+	}
+	doc, err := MstToGltf([]*Mesh{ms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Meshes) != 1 || doc.Accessors[*doc.Meshes[0].Primitives[0].Indices].Count != 36 {
+		t.Fatal("polygons not exported to gltf")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := nd.Triangulate(); err != nil {
+			t.Fatal(err)
+		}
+		if len(nd.FaceGroup) != 1 || len(nd.FaceGroup[0].Faces) != 12 {
+			t.Fatal("node triangulation not added to face groups")
+		}
+	}
+	if st := ms.Stats(); st.Triangles != 12 {
+		t.Fatalf("triangulated polygons counted twice %+v", st)
+	}
+}
+
+func TestSolidExport(t *testing.T) {
+	ms := newTestMesh()
+	if !ms.Nodes[0].IsWatertight() {
+		t.Fatal("cube not watertight")
+	}
+	off := &bytes.Buffer{}
+	if err := MeshOffMarshal(off, ms, &SolidExportOptions{RequireWatertight: true}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(off.String()), "\n")
+	if lines[0] != "OFF" || lines[1] != "16 24 0" || len(lines) != 42 || !strings.HasPrefix(lines[41], "3 ") {
+		t.Fatalf("unexpected off output %q", lines[:2])
+	}
+	msh := &bytes.Buffer{}
+	if err := MeshMshMarshal(msh, ms, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := msh.String()
+	if !strings.Contains(out, "$PhysicalNames\n2\n2 1 \"batch_0\"\n2 2 \"batch_1\"\n$EndPhysicalNames\n") ||
+		!strings.Contains(out, "$Nodes\n16\n") || !strings.Contains(out, "\n24 2 2 2 2 ") || !strings.HasSuffix(out, "$EndElements\n") {
+		t.Fatalf("unexpected msh output\n%s", out)
+	}
+
+	nd := ms.Nodes[1]
+	nd.FaceGroup[0].Faces = nd.FaceGroup[0].Faces[1:]
+	if nd.IsWatertight() {
+		t.Fatal("open cube reported watertight")
+	}
+	if err := MeshMshMarshal(msh, ms, &SolidExportOptions{RequireWatertight: true}); err != ErrNotWatertight {
+		t.Fatalf("expected ErrNotWatertight, got %v", err)
+	}
+}
+
+func TestGenerateCollisionMesh(t *testing.T) {
+	ms := newTestMesh()
+	col, err := GenerateCollisionMesh(ms, &CollisionOptions{Method: COLLISION_METHOD_VOXEL, Resolution: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := col.Nodes[0]
+	if col.Props[COLLISION_PROPS_KEY] != true || nd.Props[COLLISION_PROPS_KEY] != true || !nd.IsWatertight() {
+		t.Fatal("voxel collision mesh not a tagged solid")
+	}
+	if bx := col.ComputeBBox(); bx.Min[0] > 0 || bx.Max[0] < 11 || bx.Max[0] > 12+1e-4 || bx.Max[2] < 1 || bx.Max[2] > 2+1e-4 {
+		t.Fatalf("unexpected collision bounds %v", bx)
+	}
+
+	grid := &MeshNode{}
+	const n = 40
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			grid.Vertices = append(grid.Vertices, vec3.T{float32(x), float32(y), float32(x*y%3) / 10})
+		}
+	}
+	var faces []*Face
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			v := uint32(y*(n+1) + x)
+			faces = append(faces, &Face{Vertex: [3]uint32{v, v + 1, v + n + 2}}, &Face{Vertex: [3]uint32{v, v + n + 2, v + n + 1}})
+		}
+	}
+	if err := grid.AddFaces(0, faces); err != nil {
+		t.Fatal(err)
+	}
+	ms = NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	ms.Nodes = []*MeshNode{grid}
+	col, err = GenerateCollisionMesh(ms, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(col.Nodes[0].FaceGroup[0].Faces); got > DefaultCollisionOptions.TargetFaces || got < 50 {
+		t.Fatalf("decimated to %d faces", got)
+	}
+	if _, err := GenerateCollisionMesh(NewMesh(), nil); err != ErrEmptyMesh {
+		t.Fatalf("expected ErrEmptyMesh, got %v", err)
+	}
+
+	dir, _ := ioutil.TempDir("", "mst_collision")
+	defer os.RemoveAll(dir)
+	opts := DefaultEngineExportOptions(ENGINE_UNITY)
+	opts.CollisionMesh = &DefaultCollisionOptions
+	pkg, err := ExportEnginePackage(dir, "grid", ms, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Assets[0].Collision == nil || pkg.Assets[0].Collision.Type != "mesh" {
+		t.Fatal("engine export did not use the collision mesh")
+	}
+	if lods := pkg.Assets[0].Lods; len(lods) != 3 || lods[2].Name != "grid_node_0_LOD2" {
+		t.Fatalf("unexpected lod chain %+v", lods)
+	}
+	doc, err := gltf.Open(filepath.Join(dir, pkg.Assets[0].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lodFaces := make(map[string]uint32)
+	for _, nd := range doc.Nodes {
+		if nd.Mesh != nil {
+			lodFaces[nd.Name] += doc.Accessors[*doc.Meshes[*nd.Mesh].Primitives[0].Indices].Count / 3
+		}
+	}
+	if lodFaces["grid_node_0_LOD0"] != 2*n*n || lodFaces["grid_node_0_LOD1"] > n*n || lodFaces["grid_node_0_LOD2"] > n*n/2 || lodFaces["grid_node_0_LOD2"] == 0 {
+		t.Fatalf("unexpected lod face counts %v", lodFaces)
+	}
+
+	opts = DefaultEngineExportOptions(ENGINE_UNREAL)
+	opts.UnitScale, opts.SourceAxis, opts.UpAxis = 100, AXIS_CONVENTION_Z_UP, "Y"
+	pkg, err = ExportEnginePackage(dir, "cube", newTestMesh(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := baseMeshBBox(&BaseMesh{Nodes: newTestMesh().Nodes[:1]})
+	want := [6]float64{100 * src[0], 100 * src[2], -100 * src[4], 100 * src[3], 100 * src[5], -100 * src[1]}
+	for i, v := range pkg.Assets[0].BBox {
+		if math.Abs(v-want[i]) > 1e-3 {
+			t.Fatalf("geometry not scaled and rotated: %v, want %v", pkg.Assets[0].BBox, want)
+		}
+	}
+	opts.UpAxis = "X"
+	if _, err := ExportEnginePackage(dir, "cube", newTestMesh(), opts); err == nil {
+		t.Fatal("expected unknown up axis error")
+	}
+}
+
+func TestConvexHull(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	nd := &MeshNode{}
+	for i := 0; i < 500; i++ {
+		nd.Vertices = append(nd.Vertices, vec3.T{r.Float32(), r.Float32() * 2, r.Float32() * 3})
+	}
+	hull, err := ComputeConvexHull(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hull.IsWatertight() {
+		t.Fatal("hull not closed")
+	}
+	for _, f := range hull.FaceGroup[0].Faces {
+		a, b, c := hull.Vertices[f.Vertex[0]], hull.Vertices[f.Vertex[1]], hull.Vertices[f.Vertex[2]]
+		e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+		n := vec3.Cross(&e1, &e2)
+		n.Normalize()
+		for _, v := range nd.Vertices {
+			d := vec3.Sub(&v, &a)
+			if vec3.Dot(&n, &d) > 1e-4 {
+				t.Fatalf("point %v outside hull face %v", v, f.Vertex)
+			}
+		}
+	}
+	if _, err := ComputeConvexHull(&MeshNode{Vertices: []vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0}}}); err != ErrDegenerateHull {
+		t.Fatalf("expected ErrDegenerateHull, got %v", err)
+	}
+
+	ell := boxProxyNode(&[6]float64{0, 0, 0, 2, 1, 1})
+	arm := boxProxyNode(&[6]float64{0, 1, 0, 1, 2, 1})
+	for _, f := range arm.FaceGroup[0].Faces {
+		f.Vertex = [3]uint32{f.Vertex[0] + 8, f.Vertex[1] + 8, f.Vertex[2] + 8}
+	}
+	ell.Vertices = append(ell.Vertices, arm.Vertices...)
+	ell.FaceGroup[0].Faces = append(ell.FaceGroup[0].Faces, arm.FaceGroup[0].Faces...)
+	hulls, err := ConvexDecomposition(ell, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hulls) < 2 {
+		t.Fatalf("L shape decomposed into %d hulls", len(hulls))
+	}
+	var volume float64
+	for _, h := range hulls {
+		if !h.IsWatertight() {
+			t.Fatal("decomposition hull not closed")
+		}
+		hb, _ := newHull(h.boundingPoints())
+		volume += hb.volume()
+	}
+	if volume < 3 || volume > 3.6 {
+		t.Fatalf("decomposition volume %v", volume)
+	}
+
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	ms.Nodes = []*MeshNode{ell}
+	col, err := GenerateCollisionMesh(ms, &CollisionOptions{Method: COLLISION_METHOD_CONVEX, Resolution: 16, MaxHulls: 4, Concavity: 0.02})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(col.Nodes) < 2 || len(col.Nodes) > 4 || col.Nodes[1].Props[COLLISION_PROPS_KEY] != true {
+		t.Fatalf("unexpected convex collision mesh with %d nodes", len(col.Nodes))
+	}
+	per, err := newTestMesh().InstanceNode[0].ConvexDecomposition(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(per) != 1 || len(per[0]) != 1 {
+		t.Fatal("cube prototype should decompose into one hull")
+	}
+}
+
+func TestVoxelize(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{}}
+	ms.Nodes = []*MeshNode{boxProxyNode(&[6]float64{0, 0, 0, 2, 1, 1})}
+	solid, err := Voxelize(ms, 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	surface, err := VoxelizeWithOptions(ms, &VoxelizeOptions{CellSize: 0.25, Mode: VOXEL_MODE_SURFACE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if solid.Count() <= surface.Count() || surface.Count() == 0 {
+		t.Fatalf("solid %d cells, surface %d cells", solid.Count(), surface.Count())
+	}
+	x, y, z, ok := solid.CellOf(dvec3.T{1, 0.5, 0.5})
+	if !ok || !solid.Get(x, y, z) || surface.Get(x, y, z) {
+		t.Fatal("interior cell not classified")
+	}
+	if c := solid.Center(x, y, z); math.Abs(c[0]-1) > 0.25 {
+		t.Fatalf("unexpected cell center %v", c)
+	}
+	if _, _, _, ok := solid.CellOf(dvec3.T{-5, 0, 0}); ok {
+		t.Fatal("point outside grid reported inside")
+	}
+
+	for _, g := range []*VoxelGrid{solid, surface} {
+		out, err := g.ToMesh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !out.Nodes[0].IsWatertight() {
+			t.Fatal("marching cubes surface not closed")
+		}
+	}
+	out, _ := solid.ToMesh()
+	hull, err := ComputeConvexHull(out.Nodes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bx := (&Mesh{BaseMesh: BaseMesh{Nodes: []*MeshNode{hull}}}).ComputeBBox()
+	if bx.Min[0] > 0.25 || bx.Max[0] < 1.75 || bx.Max[0] > 2.5 {
+		t.Fatalf("unexpected marching cubes bounds %v", bx)
+	}
+
+	single := &VoxelGrid{CellSize: 1, Dims: [3]int{1, 1, 1}, Cells: []bool{true}}
+	one, err := single.ToMesh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd := one.Nodes[0]; len(nd.Vertices) != 6 || len(nd.FaceGroup[0].Faces) != 8 || !nd.IsWatertight() {
+		t.Fatal("single voxel should become an octahedron")
+	}
+	var volume float32
+	for _, f := range one.Nodes[0].FaceGroup[0].Faces {
+		a, b, c := one.Nodes[0].Vertices[f.Vertex[0]], one.Nodes[0].Vertices[f.Vertex[1]], one.Nodes[0].Vertices[f.Vertex[2]]
+		bc := vec3.Cross(&b, &c)
+		volume += vec3.Dot(&a, &bc) / 6
+	}
+	if math.Abs(float64(volume)-1.0/6) > 1e-5 {
+		t.Fatalf("octahedron volume %v, faces not outward", volume)
+	}
+	if _, err := Voxelize(ms, 1e-6); err != ErrVoxelGridTooLarge {
+		t.Fatalf("expected ErrVoxelGridTooLarge, got %v", err)
+	}
+}
+
+func TestSliceMesh(t *testing.T) {
+	ms := newTestMesh()
+	plane := [4]float64{0, 0, 2, -1}
+	lines, err := SliceMesh(ms, plane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 section curves, got %d", len(lines))
+	}
+	for _, l := range lines {
+		if !l.Closed || len(l.Points) < 4 {
+			t.Fatalf("section not closed %+v", l)
+		}
+		for _, p := range l.Points {
+			if math.Abs(p[2]-0.5) > 1e-9 {
+				t.Fatalf("section point %v off the plane", p)
+			}
+		}
+	}
+	if lines[0].FeatureID != nil || lines[2].FeatureID == nil || *lines[2].FeatureID != 1 || *lines[3].FeatureID != 2 || lines[3].Points[0][0] < 10 {
+		t.Fatal("sections not grouped per feature")
+	}
+	area := func(nd *MeshNode) float64 {
+		var a float64
+		for _, f := range nd.FaceGroup[0].Faces {
+			p, q, r := nd.Vertices[f.Vertex[0]], nd.Vertices[f.Vertex[1]], nd.Vertices[f.Vertex[2]]
+			e1, e2 := vec3.Sub(&q, &p), vec3.Sub(&r, &p)
+			c := vec3.Cross(&e1, &e2)
+			if c[2] <= 0 {
+				t.Fatal("cap not facing the plane normal")
+			}
+			a += float64(c.Length()) / 2
+		}
+		return a
+	}
+	nd, err := CapSection(lines[3:], plane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := nd.FeatureForFace(0, 0); !ok || id != 2 || math.Abs(area(nd)-1) > 1e-5 {
+		t.Fatal("unexpected cap for instance section")
+	}
+
+	ring := func(x0, y0, x1, y1 float64, ccw bool) Polyline3D {
+		pts := []dvec3.T{{x0, y0, 0}, {x1, y0, 0}, {x1, y1, 0}, {x0, y1, 0}}
+		if !ccw {
+			pts[1], pts[3] = pts[3], pts[1]
+		}
+		return Polyline3D{Points: pts, Closed: true}
+	}
+	nd, err = CapSection([]Polyline3D{ring(1, 1, 3, 3, true), ring(0, 0, 4, 4, false), ring(1.5, 1.5, 2.5, 2.5, false)}, [4]float64{0, 0, 1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(area(nd)-13) > 1e-5 {
+		t.Fatalf("cap with hole and island has area %v", area(nd))
+	}
+	if _, err := SliceMesh(ms, [4]float64{}); err != ErrInvalidPlane {
+		t.Fatalf("expected ErrInvalidPlane, got %v", err)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	nd := boxProxyNode(&[6]float64{1, 2, 3, 3, 3, 6})
+	if a, v, c := nd.SurfaceArea(), nd.Volume(), nd.Centroid(); math.Abs(a-22) > 1e-9 || math.Abs(v-6) > 1e-9 || math.Abs(c[0]-2)+math.Abs(c[1]-2.5)+math.Abs(c[2]-4.5) > 1e-9 {
+		t.Fatalf("unexpected box measurements %v %v %v", a, v, c)
+	}
+	open := &MeshNode{Vertices: nd.Vertices, FaceGroup: []*MeshTriangle{{Faces: nd.FaceGroup[0].Faces[:2]}}}
+	if c := open.Centroid(); math.Abs(c[2]-3) > 1e-9 || open.measure().Closed {
+		t.Fatalf("open surface centroid %v", c)
+	}
+
+	ms := newTestMesh()
+	mirror := dmat.Ident
+	mirror.ScaleVec3(&dvec3.T{-1, 1, 1})
+	ms.InstanceNode[0].Transfors = append(ms.InstanceNode[0].Transfors, &mirror)
+	m, err := ms.Measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Closed || math.Abs(m.Volume-5) > 1e-9 || math.Abs(m.SurfaceArea-30) > 1e-9 {
+		t.Fatalf("unexpected mesh measurements %+v", m)
+	}
+	if want := (0.5*3 + 10.5 - 0.5) / 5; math.Abs(m.Centroid[0]-want) > 1e-9 {
+		t.Fatalf("centroid %v, want x %v", m.Centroid, want)
+	}
+}
+
+func TestClosestPoint(t *testing.T) {
+	ms := NewMesh()
+	ms.Nodes = []*MeshNode{boxProxyNode(&[6]float64{0, 0, 0, 2, 2, 2})}
+	id := uint64(9)
+	ms.Nodes[0].FaceGroup[0].FeatureID = &id
+	hit, d := ms.ClosestPoint(dvec3.T{1, 1, 5})
+	if math.Abs(d-3) > 1e-9 || hit.Point != (dvec3.T{1, 1, 2}) || hit.Normal[2] < 0.99 || hit.FeatureID == nil || *hit.FeatureID != 9 {
+		t.Fatalf("unexpected hit %+v at %v", hit, d)
+	}
+	if _, d := ms.ClosestPoint(dvec3.T{3, 3, 3}); math.Abs(d-math.Sqrt(3)) > 1e-9 {
+		t.Fatalf("corner distance %v", d)
+	}
+	if sd, err := ms.SignedDistance(dvec3.T{1, 1, 0.25}); err != nil || math.Abs(sd+0.25) > 1e-9 {
+		t.Fatalf("inside signed distance %v %v", sd, err)
+	}
+	if sd, err := ms.SignedDistance(dvec3.T{-1, 1, 1}); err != nil || math.Abs(sd-1) > 1e-9 {
+		t.Fatalf("outside signed distance %v %v", sd, err)
+	}
+
+	q, err := NewMeshQuery(newTestMesh())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		p := dvec3.T{rand.Float64()*30 - 10, rand.Float64()*30 - 10, rand.Float64()*30 - 10}
+		hit, d := q.ClosestPoint(p)
+		best := math.Inf(1)
+		for k := range q.bvh.tris {
+			tri := &q.bvh.tris[k]
+			c := barycentricPoint(tri, closestOnTriangle(tri, &p))
+			best = math.Min(best, dvec3.Distance(&c, &p))
+		}
+		if math.Abs(d-best) > 1e-9 || math.Abs(dvec3.Distance(&hit.Point, &p)-d) > 1e-9 {
+			t.Fatalf("bvh distance %v, brute force %v", d, best)
+		}
+	}
+
+	open := NewMesh()
+	open.Nodes = []*MeshNode{{Vertices: ms.Nodes[0].Vertices, FaceGroup: []*MeshTriangle{{Faces: ms.Nodes[0].FaceGroup[0].Faces[:2]}}}}
+	if _, err := open.SignedDistance(dvec3.T{}); err != ErrNotWatertight {
+		t.Fatalf("expected ErrNotWatertight, got %v", err)
+	}
+}
+
+func TestIntersects(t *testing.T) {
+	box := func(b [6]float64) *Mesh {
+		ms := NewMesh()
+		ms.Nodes = []*MeshNode{boxProxyNode(&b)}
+		return ms
+	}
+	a := box([6]float64{0, 0, 0, 1, 1, 1})
+	if hit, pairs := Intersects(a, box([6]float64{0.5, 0.5, 0.5, 2, 2, 2}), nil); !hit || len(pairs) == 0 {
+		t.Fatal("expected overlapping boxes to intersect")
+	}
+	far := box([6]float64{1.2, 0, 0, 2, 1, 1})
+	if hit, pairs := Intersects(a, far, nil); hit || len(pairs) != 0 {
+		t.Fatalf("unexpected contacts %v", pairs)
+	}
+	if hit, _ := Intersects(a, far, &IntersectOptions{Tolerance: 0.25}); !hit {
+		t.Fatal("expected contact within tolerance")
+	}
+	if _, pairs := Intersects(a, box([6]float64{0.5, 0.5, 0.5, 2, 2, 2}), &IntersectOptions{MaxContacts: 1}); len(pairs) != 1 {
+		t.Fatalf("expected a single contact, got %d", len(pairs))
+	}
+	if hit, _ := Intersects(a, box([6]float64{0.25, 0.25, 0.25, 0.75, 0.75, 0.75}), nil); hit {
+		t.Fatal("surfaces of nested boxes do not touch")
+	}
+
+	inst := NewMesh()
+	moved := dmat.Ident
+	moved.SetTranslation(&dvec3.T{5, 0, 0})
+	inst.InstanceNode = []*InstanceMesh{{Transfors: []*dmat.T{&moved}, Features: []uint64{42}, Mesh: &BaseMesh{Materials: a.Materials, Nodes: a.Nodes}}}
+	if hit, _ := Intersects(a, inst, nil); hit {
+		t.Fatal("translated instance should not intersect")
+	}
+	if hit, pairs := Intersects(box([6]float64{5.5, 0.5, 0.5, 6.5, 1.5, 1.5}), inst, nil); !hit || pairs[0].B.Instance != 0 || pairs[0].B.FeatureID == nil || *pairs[0].B.FeatureID != 42 {
+		t.Fatalf("expected contact with instance feature, got %+v", pairs)
+	}
+}
+
+func TestOrientNormalsConsistently(t *testing.T) {
+	ref := boxProxyNode(&[6]float64{0, 0, 0, 1, 2, 3})
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 2, 3})
+	faces := nd.FaceGroup[0].Faces
+	for i := 0; i < len(faces); i += 3 {
+		faces[i].Vertex[1], faces[i].Vertex[2] = faces[i].Vertex[2], faces[i].Vertex[1]
+	}
+	if n, err := nd.OrientNormalsConsistently(); err != nil || n != 4 {
+		t.Fatalf("flipped %d faces, err %v", n, err)
+	}
+	for i, f := range faces {
+		if f.Vertex != ref.FaceGroup[0].Faces[i].Vertex {
+			t.Fatalf("face %d winding %v, want %v", i, f.Vertex, ref.FaceGroup[0].Faces[i].Vertex)
+		}
+	}
+
+	inward := boxProxyNode(&[6]float64{0, 0, 0, 1, 2, 3})
+	for _, f := range inward.FaceGroup[0].Faces {
+		f.Vertex[1], f.Vertex[2] = f.Vertex[2], f.Vertex[1]
+	}
+	inward.ReComputeNormal()
+	if n, err := inward.OrientNormalsConsistently(); err != nil || n != 12 || inward.Volume() <= 0 {
+		t.Fatalf("flipped %d faces, volume %v, err %v", n, inward.Volume(), err)
+	}
+	if n := inward.Normals[0]; n[0] > 0 || n[1] > 0 || n[2] > 0 {
+		t.Fatalf("normal %v does not point away from the box", n)
+	}
+
+	open := &MeshNode{Vertices: ref.Vertices, FaceGroup: []*MeshTriangle{{Faces: []*Face{{Vertex: [3]uint32{0, 1, 2}}, {Vertex: [3]uint32{0, 2, 3}}, {Vertex: [3]uint32{7, 6, 5}}}}}}
+	if n, err := open.OrientNormalsConsistently(); err != nil || n != 0 {
+		t.Fatalf("flipped %d faces, err %v", n, err)
+	}
+	if _, err := (&MeshNode{FaceGroup: []*MeshTriangle{{Faces: []*Face{{Vertex: [3]uint32{0, 1, 2}}}}}}).OrientNormalsConsistently(); err == nil {
+		t.Fatal("expected an index error")
+	}
+}
+
+func TestGenerateUVAtlas(t *testing.T) {
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 2, 3})
+	if err := nd.GenerateUVAtlas(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.TexCoords) != len(nd.Vertices) || len(nd.Normals) != len(nd.Vertices) || len(nd.Vertices) != 24 {
+		t.Fatalf("unexpected vertex layout %d/%d/%d", len(nd.Vertices), len(nd.TexCoords), len(nd.Normals))
+	}
+	var tris []bvhTriangle
+	area := 0.0
+	for _, f := range nd.FaceGroup[0].Faces {
+		var tri bvhTriangle
+		for k, v := range f.Vertex {
+			uv := nd.TexCoords[v]
+			if uv[0] < 0 || uv[0] > 1 || uv[1] < 0 || uv[1] > 1 {
+				t.Fatalf("uv %v outside the atlas", uv)
+			}
+			tri.v[k] = dvec3.T{float64(uv[0]), float64(uv[1]), 0}
+		}
+		e1, e2 := dvec3.Sub(&tri.v[1], &tri.v[0]), dvec3.Sub(&tri.v[2], &tri.v[0])
+		if c := dvec3.Cross(&e1, &e2); c[2] <= 0 {
+			t.Fatalf("uv triangle %v is flipped or degenerate", tri.v)
+		} else {
+			area += c[2] / 2
+		}
+		tris = append(tris, tri)
+	}
+	for i := range tris {
+		for j := i + 1; j < len(tris); j++ {
+			if trianglesOverlap(&tris[i], &tris[j], -1e-6) {
+				t.Fatalf("uv triangles %d and %d overlap", i, j)
+			}
+		}
+	}
+	if area < 0.3 {
+		t.Fatalf("atlas only covers %v of the unit square", area)
+	}
+
+	ms := NewMesh()
+	textured := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	textured.TexCoords = make([]vec2.T, len(textured.Vertices))
+	ms.Nodes = []*MeshNode{boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1}), textured}
+	if err := ms.GenerateUVAtlas(&UVAtlasOptions{MaxChartAngle: 30, Resolution: 256, Padding: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Nodes[0].TexCoords) != 24 || len(textured.Vertices) != 8 {
+		t.Fatal("expected only the untextured node to be unwrapped")
+	}
+	if err := nd.GenerateUVAtlas(&UVAtlasOptions{MaxChartAngle: 90}); err == nil {
+		t.Fatal("expected an invalid angle error")
+	}
+}
+
+func TestBakeFaceColorsToTexture(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{255, 0, 0}}, &BaseMaterial{Color: [3]byte{0, 255, 0}}}
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	green := int32(1)
+	faces := nd.FaceGroup[0].Faces
+	faces[2].Material, faces[3].Material = &green, &green
+	nd.FaceGroup[0].SetFaceFeature(0, 7)
+	ms.Nodes = []*MeshNode{nd}
+	want := make([][3]byte, len(faces))
+	for i := range faces {
+		want[i] = [3]byte{255, 0, 0}
+		if faces[i].Material != nil {
+			want[i] = [3]byte{0, 255, 0}
+		}
+	}
+	mtl, err := ms.BakeFaceColorsToTexture(nd, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Materials) != 3 || ms.Materials[2] != mtl || len(nd.FaceGroup) != 1 || nd.FaceGroup[0].Batchid != 2 {
+		t.Fatalf("expected the node on a single baked material, got %d groups", len(nd.FaceGroup))
+	}
+	if id, ok := nd.FaceGroup[0].FaceFeature(0); !ok || id != 7 {
+		t.Fatal("face features were lost")
+	}
+	img, err := LoadTexture(mtl.Texture, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range nd.FaceGroup[0].Faces {
+		if f.Material != nil {
+			t.Fatal("face material overrides should be cleared")
+		}
+		var u, v float32
+		for _, vi := range f.Vertex {
+			u += nd.TexCoords[vi][0] / 3
+			v += nd.TexCoords[vi][1] / 3
+		}
+		r, g, b, _ := img.At(int(u*64), int(v*64)).RGBA()
+		if got := [3]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)}; got != want[i] {
+			t.Fatalf("face %d baked %v, want %v", i, got, want[i])
+		}
+	}
+
+	vc := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	vc.Colors = make([][3]byte, len(vc.Vertices))
+	for i := range vc.Colors {
+		vc.Colors[i] = [3]byte{0, 0, 128}
+	}
+	mesh := NewMesh()
+	mesh.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{255, 255, 255}}}
+	mesh.Nodes = []*MeshNode{vc}
+	mtl, err = mesh.BakeFaceColorsToTexture(vc, 16)
+	if err != nil || vc.Colors != nil {
+		t.Fatalf("unexpected bake result %v", err)
+	}
+	if img, err = LoadTexture(mtl.Texture, false); err != nil {
+		t.Fatal(err)
+	}
+	f := vc.FaceGroup[0].Faces[0]
+	c := vec2.T{}
+	for _, vi := range f.Vertex {
+		c.Add(&vc.TexCoords[vi])
+	}
+	if _, _, b, _ := img.At(int(c[0]*16/3), int(c[1]*16/3)).RGBA(); b>>8 != 128 {
+		t.Fatalf("vertex color not baked, blue %d", b>>8)
+	}
+	if _, err := mesh.BakeFaceColorsToTexture(vc, 0); err == nil {
+		t.Fatal("expected a resolution error")
+	}
+}
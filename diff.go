@@ -0,0 +1,469 @@
+package mst
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_CHANGED = "changed"
+)
+
+var ErrNilMesh = errors.New("mst: nil mesh")
+
+type DiffOptions struct {
+	PositionEpsilon float64
+	FloatEpsilon    float64
+}
+
+var DefaultDiffOptions = DiffOptions{
+	PositionEpsilon: 1e-6,
+	FloatEpsilon:    1e-9,
+}
+
+type DiffEntry struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type MeshDiff struct {
+	Nodes      []DiffEntry `json:"nodes,omitempty"`
+	Materials  []DiffEntry `json:"materials,omitempty"`
+	Instances  []DiffEntry `json:"instances,omitempty"`
+	Props      []DiffEntry `json:"props,omitempty"`
+	Animations []DiffEntry `json:"animations,omitempty"`
+}
+
+func (d *MeshDiff) Empty() bool {
+	return len(d.Nodes) == 0 && len(d.Materials) == 0 && len(d.Instances) == 0 && len(d.Props) == 0 && len(d.Animations) == 0
+}
+
+func Diff(a, b *Mesh, opts DiffOptions) (*MeshDiff, error) {
+	if a == nil || b == nil {
+		return nil, ErrNilMesh
+	}
+	if opts.PositionEpsilon < 0 || opts.FloatEpsilon < 0 {
+		return nil, errors.New("mst: diff tolerances must not be negative")
+	}
+	d := &MeshDiff{}
+	d.baseMesh("", &a.BaseMesh, &b.BaseMesh, &opts)
+	d.Props = diffProps(d.Props, "props", a.Props, b.Props, &opts)
+	if a.Units != b.Units {
+		d.Props = append(d.Props, DiffEntry{Kind: DIFF_CHANGED, Path: "units", Detail: fmt.Sprintf("%d != %d", a.Units, b.Units)})
+	}
+	n := len(a.InstanceNode)
+	if len(b.InstanceNode) > n {
+		n = len(b.InstanceNode)
+	}
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("instances[%d]", i)
+		switch {
+		case i >= len(a.InstanceNode):
+			d.Instances = append(d.Instances, DiffEntry{Kind: DIFF_ADDED, Path: path})
+		case i >= len(b.InstanceNode):
+			d.Instances = append(d.Instances, DiffEntry{Kind: DIFF_REMOVED, Path: path})
+		default:
+			d.instance(path, a.InstanceNode[i], b.InstanceNode[i], &opts)
+		}
+	}
+	d.animations(a.Animations, b.Animations, &opts)
+	return d, nil
+}
+
+func (d *MeshDiff) baseMesh(prefix string, a, b *BaseMesh, opts *DiffOptions) {
+	n := len(a.Materials)
+	if len(b.Materials) > n {
+		n = len(b.Materials)
+	}
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("%smaterials[%d]", prefix, i)
+		switch {
+		case i >= len(a.Materials):
+			d.Materials = append(d.Materials, DiffEntry{Kind: DIFF_ADDED, Path: path})
+		case i >= len(b.Materials):
+			d.Materials = append(d.Materials, DiffEntry{Kind: DIFF_REMOVED, Path: path})
+		default:
+			if detail := diffMaterial(a.Materials[i], b.Materials[i]); detail != "" {
+				d.Materials = append(d.Materials, DiffEntry{Kind: DIFF_CHANGED, Path: path, Detail: detail})
+			}
+		}
+	}
+	n = len(a.Nodes)
+	if len(b.Nodes) > n {
+		n = len(b.Nodes)
+	}
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("%snodes[%d]", prefix, i)
+		switch {
+		case i >= len(a.Nodes):
+			d.Nodes = append(d.Nodes, DiffEntry{Kind: DIFF_ADDED, Path: path})
+		case i >= len(b.Nodes):
+			d.Nodes = append(d.Nodes, DiffEntry{Kind: DIFF_REMOVED, Path: path})
+		default:
+			if detail := diffNode(a.Nodes[i], b.Nodes[i], opts); detail != "" {
+				d.Nodes = append(d.Nodes, DiffEntry{Kind: DIFF_CHANGED, Path: path, Detail: detail})
+			}
+			d.Props = diffProps(d.Props, path+".props", a.Nodes[i].Props, b.Nodes[i].Props, opts)
+		}
+	}
+	if a.Code != b.Code {
+		d.Nodes = append(d.Nodes, DiffEntry{Kind: DIFF_CHANGED, Path: prefix + "code", Detail: fmt.Sprintf("%d != %d", a.Code, b.Code)})
+	}
+}
+
+func (d *MeshDiff) instance(path string, a, b *InstanceMesh, opts *DiffOptions) {
+	change := func(detail string) {
+		d.Instances = append(d.Instances, DiffEntry{Kind: DIFF_CHANGED, Path: path, Detail: detail})
+	}
+	if len(a.Transfors) != len(b.Transfors) {
+		change(fmt.Sprintf("transform count %d != %d", len(a.Transfors), len(b.Transfors)))
+	} else {
+		for i := range a.Transfors {
+			if !sameMat(a.Transfors[i], b.Transfors[i], opts.PositionEpsilon) {
+				change(fmt.Sprintf("transform %d differs", i))
+				break
+			}
+		}
+	}
+	if !reflect.DeepEqual(canonicalUint64s(a.Features), canonicalUint64s(b.Features)) {
+		change("features differ")
+	}
+	if !reflect.DeepEqual(a.Ref, b.Ref) {
+		change("reference differs")
+	}
+	switch {
+	case a.Mesh == nil && b.Mesh != nil:
+		change("mesh added")
+	case a.Mesh != nil && b.Mesh == nil:
+		change("mesh removed")
+	case a.Mesh != nil:
+		d.baseMesh(path+".mesh.", a.Mesh, b.Mesh, opts)
+	}
+	d.Props = diffProps(d.Props, path+".props", a.Props, b.Props, opts)
+}
+
+func canonicalUint64s(vs []uint64) []uint64 {
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs
+}
+
+func diffMaterial(a, b MeshMaterial) string {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return fmt.Sprintf("type %T != %T", a, b)
+	}
+	if MaterialName(a) != MaterialName(b) {
+		return fmt.Sprintf("name %q != %q", MaterialName(a), MaterialName(b))
+	}
+	ba, bb := &bytes.Buffer{}, &bytes.Buffer{}
+	MaterialMarshal(ba, a, MESH_LATEST_VERSION)
+	MaterialMarshal(bb, b, MESH_LATEST_VERSION)
+	if !bytes.Equal(ba.Bytes(), bb.Bytes()) {
+		return "properties differ"
+	}
+	ba.Reset()
+	bb.Reset()
+	textureURIsMarshal(ba, a)
+	textureURIsMarshal(bb, b)
+	if !bytes.Equal(ba.Bytes(), bb.Bytes()) {
+		return "texture uris differ"
+	}
+	ba.Reset()
+	bb.Reset()
+	textureColorSpacesMarshal(ba, a)
+	textureColorSpacesMarshal(bb, b)
+	if !bytes.Equal(ba.Bytes(), bb.Bytes()) {
+		return "texture color spaces differ"
+	}
+	return ""
+}
+
+func (d *MeshDiff) animations(a, b []*Animation, opts *DiffOptions) {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("animations[%d]", i)
+		switch {
+		case i >= len(a):
+			d.Animations = append(d.Animations, DiffEntry{Kind: DIFF_ADDED, Path: path})
+		case i >= len(b):
+			d.Animations = append(d.Animations, DiffEntry{Kind: DIFF_REMOVED, Path: path})
+		default:
+			if detail := diffAnimation(a[i], b[i], opts); detail != "" {
+				d.Animations = append(d.Animations, DiffEntry{Kind: DIFF_CHANGED, Path: path, Detail: detail})
+			}
+		}
+	}
+}
+
+func sameFloat32s(a, b []float32, eps float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameFloat(float64(a[i]), float64(b[i]), eps) {
+			return false
+		}
+	}
+	return true
+}
+
+func diffAnimation(a, b *Animation, opts *DiffOptions) string {
+	if a.Name != b.Name {
+		return fmt.Sprintf("name %q != %q", a.Name, b.Name)
+	}
+	if len(a.Tracks) != len(b.Tracks) {
+		return fmt.Sprintf("track count %d != %d", len(a.Tracks), len(b.Tracks))
+	}
+	for i := range a.Tracks {
+		ta, tb := a.Tracks[i], b.Tracks[i]
+		if ta.TargetType != tb.TargetType || ta.Target != tb.Target || ta.Transform != tb.Transform || ta.Path != tb.Path || ta.Interpolation != tb.Interpolation ||
+			!sameFloat32s(ta.Times, tb.Times, opts.FloatEpsilon) || !sameFloat32s(ta.Values, tb.Values, opts.FloatEpsilon) {
+			return fmt.Sprintf("track %d differs", i)
+		}
+	}
+	return ""
+}
+
+func sameFloat(a, b, eps float64) bool {
+	return a == b || math.Abs(a-b) <= eps
+}
+
+func sameMat(a, b *dmat.T, eps float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	for c := range a {
+		for r := range a[c] {
+			if !sameFloat(a[c][r], b[c][r], eps) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func faceIndices(idx *[3]uint32, f *Face) [3]uint32 {
+	if idx == nil {
+		return f.Vertex
+	}
+	return *idx
+}
+
+func sameFace(a, b *Face) bool {
+	return a.Vertex == b.Vertex && faceIndices(a.Normal, a) == faceIndices(b.Normal, b) && faceIndices(a.Uv, a) == faceIndices(b.Uv, b)
+}
+
+func diffVec3s(name string, a, b []vec3.T, eps float64) string {
+	if len(a) != len(b) {
+		return fmt.Sprintf("%s count %d != %d", name, len(a), len(b))
+	}
+	for i := range a {
+		for c := 0; c < 3; c++ {
+			if !sameFloat(float64(a[i][c]), float64(b[i][c]), eps) {
+				return fmt.Sprintf("%s %d moved from %v to %v", name, i, a[i], b[i])
+			}
+		}
+	}
+	return ""
+}
+
+func diffVec2s(name string, a, b []vec2.T, eps float64) string {
+	if len(a) != len(b) {
+		return fmt.Sprintf("%s count %d != %d", name, len(a), len(b))
+	}
+	for i := range a {
+		if !sameFloat(float64(a[i][0]), float64(b[i][0]), eps) || !sameFloat(float64(a[i][1]), float64(b[i][1]), eps) {
+			return fmt.Sprintf("%s %d differs", name, i)
+		}
+	}
+	return ""
+}
+
+func diffNode(a, b *MeshNode, opts *DiffOptions) string {
+	if s := diffVec3s("vertex", a.Vertices, b.Vertices, opts.PositionEpsilon); s != "" {
+		return s
+	}
+	if s := diffVec3s("normal", a.Normals, b.Normals, opts.FloatEpsilon); s != "" {
+		return s
+	}
+	if s := diffVec2s("texcoord", a.TexCoords, b.TexCoords, opts.FloatEpsilon); s != "" {
+		return s
+	}
+	if s := diffVec2s("texcoord2", a.TexCoords2, b.TexCoords2, opts.FloatEpsilon); s != "" {
+		return s
+	}
+	if !sameLightmap(a.Lightmap, b.Lightmap) {
+		return "lightmap differs"
+	}
+	if len(a.Colors) != len(b.Colors) || (len(a.Colors) > 0 && !reflect.DeepEqual(a.Colors, b.Colors)) {
+		return "colors differ"
+	}
+	if !sameMat(a.Mat, b.Mat, opts.FloatEpsilon) {
+		return "transform differs"
+	}
+	if len(a.FaceGroup) != len(b.FaceGroup) {
+		return fmt.Sprintf("face group count %d != %d", len(a.FaceGroup), len(b.FaceGroup))
+	}
+	for i := range a.FaceGroup {
+		ga, gb := a.FaceGroup[i], b.FaceGroup[i]
+		if ga.Batchid != gb.Batchid || len(ga.Faces) != len(gb.Faces) {
+			return fmt.Sprintf("face group %d differs", i)
+		}
+		if ma, mb := ga.PrimitiveMode(), gb.PrimitiveMode(); ma != mb {
+			return fmt.Sprintf("face group %d mode %d != %d", i, ma, mb)
+		}
+		for j := range ga.Faces {
+			if !sameFace(ga.Faces[j], gb.Faces[j]) || ga.Faces[j].materialIndex(ga.Batchid) != gb.Faces[j].materialIndex(gb.Batchid) {
+				return fmt.Sprintf("face group %d face %d differs", i, j)
+			}
+			fa, oka := ga.FaceFeature(j)
+			fb, okb := gb.FaceFeature(j)
+			if fa != fb || oka != okb {
+				return fmt.Sprintf("face group %d face %d feature differs", i, j)
+			}
+		}
+	}
+	if len(a.PolygonGroup) != len(b.PolygonGroup) {
+		return fmt.Sprintf("polygon group count %d != %d", len(a.PolygonGroup), len(b.PolygonGroup))
+	}
+	for i := range a.PolygonGroup {
+		if !reflect.DeepEqual(canonicalPolygons(a.PolygonGroup[i]), canonicalPolygons(b.PolygonGroup[i])) {
+			return fmt.Sprintf("polygon group %d differs", i)
+		}
+	}
+	if len(a.EdgeGroup) != len(b.EdgeGroup) {
+		return fmt.Sprintf("edge group count %d != %d", len(a.EdgeGroup), len(b.EdgeGroup))
+	}
+	for i := range a.EdgeGroup {
+		ga, gb := a.EdgeGroup[i], b.EdgeGroup[i]
+		if ga.Batchid != gb.Batchid || ga.Closed != gb.Closed || !sameFloat(float64(ga.Width), float64(gb.Width), opts.FloatEpsilon) ||
+			!reflect.DeepEqual(ga.Color, gb.Color) || len(ga.Edges) != len(gb.Edges) || len(ga.Dash) != len(gb.Dash) {
+			return fmt.Sprintf("edge group %d differs", i)
+		}
+		for j := range ga.Edges {
+			if ga.Edges[j] != gb.Edges[j] {
+				return fmt.Sprintf("edge group %d edge %d differs", i, j)
+			}
+		}
+		for j := range ga.Dash {
+			if !sameFloat(float64(ga.Dash[j]), float64(gb.Dash[j]), opts.FloatEpsilon) {
+				return fmt.Sprintf("edge group %d dash differs", i)
+			}
+		}
+	}
+	if len(a.MorphTargets) != len(b.MorphTargets) {
+		return fmt.Sprintf("morph target count %d != %d", len(a.MorphTargets), len(b.MorphTargets))
+	}
+	for i := range a.MorphTargets {
+		ta, tb := a.MorphTargets[i], b.MorphTargets[i]
+		if ta.Name != tb.Name || !sameFloat(float64(ta.Weight), float64(tb.Weight), opts.FloatEpsilon) ||
+			diffVec3s("", ta.Positions, tb.Positions, opts.PositionEpsilon) != "" || diffVec3s("", ta.Normals, tb.Normals, opts.FloatEpsilon) != "" {
+			return fmt.Sprintf("morph target %d differs", i)
+		}
+	}
+	if a.Name != b.Name {
+		return fmt.Sprintf("name %q != %q", a.Name, b.Name)
+	}
+	if len(a.Children) != len(b.Children) || (len(a.Children) > 0 && !reflect.DeepEqual(a.Children, b.Children)) {
+		return "children differ"
+	}
+	return ""
+}
+
+func propNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func propMap(v interface{}) (Properties, bool) {
+	switch m := v.(type) {
+	case Properties:
+		return m, true
+	case map[string]interface{}:
+		return Properties(m), true
+	}
+	return nil, false
+}
+
+func samePropValue(a, b interface{}, opts *DiffOptions) bool {
+	if na, ok := propNumber(a); ok {
+		nb, ok := propNumber(b)
+		return ok && sameFloat(na, nb, opts.FloatEpsilon)
+	}
+	if ma, ok := propMap(a); ok {
+		mb, ok := propMap(b)
+		return ok && len(diffProps(nil, "", ma, mb, opts)) == 0
+	}
+	if la, ok := a.([]interface{}); ok {
+		lb, ok := b.([]interface{})
+		if !ok || len(la) != len(lb) {
+			return false
+		}
+		for i := range la {
+			if !samePropValue(la[i], lb[i], opts) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func diffProps(out []DiffEntry, path string, a, b Properties, opts *DiffOptions) []DiffEntry {
+	for _, k := range sortedPropKeys(a) {
+		bv, ok := b[k]
+		switch {
+		case !ok:
+			out = append(out, DiffEntry{Kind: DIFF_REMOVED, Path: path + "." + k})
+		case !samePropValue(a[k], bv, opts):
+			out = append(out, DiffEntry{Kind: DIFF_CHANGED, Path: path + "." + k, Detail: fmt.Sprintf("%v != %v", a[k], bv)})
+		}
+	}
+	for _, k := range sortedPropKeys(b) {
+		if _, ok := a[k]; !ok {
+			out = append(out, DiffEntry{Kind: DIFF_ADDED, Path: path + "." + k})
+		}
+	}
+	return out
+}
+
+func (m *Mesh) Equal(other *Mesh) bool {
+	return m.AlmostEqual(other, 0)
+}
+
+func (m *Mesh) AlmostEqual(other *Mesh, epsilon float64) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	d, err := Diff(m, other, DiffOptions{PositionEpsilon: epsilon, FloatEpsilon: epsilon})
+	return err == nil && d.Empty()
+}
@@ -0,0 +1,95 @@
+package mst
+
+import (
+	"errors"
+	"math"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrInvalidCreaseAngle = errors.New("mst: crease angle must be between 0 and 180 degrees")
+
+type featureEdge struct {
+	verts   [2]uint32
+	batchid int32
+	normals []vec3.T
+}
+
+func (n *MeshNode) weldedVertices() []uint32 {
+	weld := make([]uint32, len(n.Vertices))
+	seen := make(map[vec3.T]uint32, len(n.Vertices))
+	for i, v := range n.Vertices {
+		if j, ok := seen[v]; ok {
+			weld[i] = j
+			continue
+		}
+		seen[v] = uint32(i)
+		weld[i] = uint32(i)
+	}
+	return weld
+}
+
+func (n *MeshNode) ExtractOutlines(creaseAngleDeg float64, includeBoundary bool) error {
+	if creaseAngleDeg < 0 || creaseAngleDeg > 180 || math.IsNaN(creaseAngleDeg) {
+		return ErrInvalidCreaseAngle
+	}
+	if err := n.validateVertexIndices(); err != nil {
+		return err
+	}
+	weld := n.weldedVertices()
+	index := make(map[[2]uint32]int)
+	var edges []*featureEdge
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			a, b, c := n.Vertices[f.Vertex[0]], n.Vertices[f.Vertex[1]], n.Vertices[f.Vertex[2]]
+			e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+			nl := vec3.Cross(&e1, &e2)
+			if nl.Length() == 0 {
+				continue
+			}
+			nl.Normalize()
+			for k := 0; k < 3; k++ {
+				v0, v1 := f.Vertex[k], f.Vertex[(k+1)%3]
+				key := [2]uint32{weld[v0], weld[v1]}
+				if key[0] == key[1] {
+					continue
+				}
+				if key[0] > key[1] {
+					key[0], key[1] = key[1], key[0]
+				}
+				i, ok := index[key]
+				if !ok {
+					i = len(edges)
+					index[key] = i
+					edges = append(edges, &featureEdge{verts: [2]uint32{v0, v1}, batchid: g.Batchid})
+				}
+				edges[i].normals = append(edges[i].normals, nl)
+			}
+		}
+	}
+	limit := math.Cos(creaseAngleDeg*math.Pi/180) - 1e-6
+	var batches []int32
+	byBatch := make(map[int32][][2]uint32)
+	for _, e := range edges {
+		switch len(e.normals) {
+		case 1:
+			if !includeBoundary {
+				continue
+			}
+		case 2:
+			if float64(vec3.Dot(&e.normals[0], &e.normals[1])) >= limit {
+				continue
+			}
+		}
+		if _, ok := byBatch[e.batchid]; !ok {
+			batches = append(batches, e.batchid)
+		}
+		byBatch[e.batchid] = append(byBatch[e.batchid], e.verts)
+	}
+	for _, b := range batches {
+		if err := n.AddEdges(b, byBatch[b]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package mst
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	ENGINE_UNITY  = 0
+	ENGINE_UNREAL = 1
+)
+
+const ENGINE_METADATA_EXT = ".json"
+
+// UnitScale multiplies every position. When SourceAxis is set the geometry is
+// rotated from that AXIS_CONVENTION so UpAxis ("Y" or "Z") points up. Each
+// LodRatios entry adds a LOD keeping that fraction of the LOD0 triangles.
+type EngineExportOptions struct {
+	Engine     int
+	Units      string
+	UnitScale  float64
+	UpAxis     string
+	SourceAxis uint8
+	LodRatios  []float64
+	Collision  bool
+
+	CollisionMesh *CollisionOptions
+
+	ContinueOnError bool
+	Logger          Logger
+}
+
+func DefaultEngineExportOptions(engine int) *EngineExportOptions {
+	return &EngineExportOptions{Engine: engine, Units: "meters", UnitScale: 1, UpAxis: "Z", LodRatios: []float64{0.5, 0.25}, Collision: true}
+}
+
+type EngineCollision struct {
+	Name   string     `json:"name"`
+	Type   string     `json:"type"`
+	Center [3]float64 `json:"center"`
+	Size   [3]float64 `json:"size"`
+}
+
+type EngineLod struct {
+	Level int    `json:"level"`
+	Name  string `json:"name"`
+}
+
+type EngineAsset struct {
+	Name      string           `json:"name"`
+	File      string           `json:"file"`
+	BBox      [6]float64       `json:"bbox"`
+	Lods      []EngineLod      `json:"lods"`
+	Collision *EngineCollision `json:"collision,omitempty"`
+	Instances [][16]float64    `json:"instances,omitempty"`
+}
+
+type EnginePackage struct {
+	Name      string        `json:"name"`
+	Engine    string        `json:"engine"`
+	Units     string        `json:"units"`
+	UnitScale float64       `json:"unitScale"`
+	UpAxis    string        `json:"upAxis"`
+	Assets    []EngineAsset `json:"assets"`
+}
+
+func engineName(engine int) string {
+	if engine == ENGINE_UNREAL {
+		return "unreal"
+	}
+	return "unity"
+}
+
+func engineMeshName(engine int, name string) string {
+	if engine == ENGINE_UNREAL {
+		return "SM_" + name
+	}
+	return name
+}
+
+func engineLodName(engine int, name string, level int) string {
+	return fmt.Sprintf("%s_LOD%d", engineMeshName(engine, name), level)
+}
+
+func engineCollisionName(engine int, name string) string {
+	if engine == ENGINE_UNREAL {
+		return fmt.Sprintf("UCX_%s_00", engineMeshName(engine, name))
+	}
+	return name + "_collider"
+}
+
+func subBaseMesh(ms *BaseMesh, nds []*MeshNode) *BaseMesh {
+	remap := make(map[int32]int32)
+	out := &BaseMesh{Code: ms.Code}
+	for _, nd := range nds {
+		cp := *nd
+		cp.Children = nil
+		groups := nd.materialGroups()
+		cp.FaceGroup = make([]*MeshTriangle, len(groups))
+		for i, g := range groups {
+			cp.FaceGroup[i] = &MeshTriangle{Batchid: remapBatchid(ms, out, remap, g.Batchid), Faces: g.Faces, FeatureID: g.FeatureID, Features: g.Features, Mode: g.Mode}
+		}
+		cp.PolygonGroup = clonePolygonGroups(nd.PolygonGroup, func(b int32) int32 { return remapBatchid(ms, out, remap, b) })
+		cp.EdgeGroup = make([]*MeshOutline, len(nd.EdgeGroup))
+		for i, g := range nd.EdgeGroup {
+			cp.EdgeGroup[i] = g.withEdges(remapBatchid(ms, out, remap, g.Batchid), g.Edges)
+		}
+		out.Nodes = append(out.Nodes, &cp)
+	}
+	return out
+}
+
+func remapBatchid(src, dst *BaseMesh, remap map[int32]int32, id int32) int32 {
+	if id < 0 || int(id) >= len(src.Materials) {
+		return id
+	}
+	if nid, ok := remap[id]; ok {
+		return nid
+	}
+	nid := int32(len(dst.Materials))
+	dst.Materials = append(dst.Materials, src.Materials[id])
+	remap[id] = nid
+	return nid
+}
+
+func engineFrame(opts *EngineExportOptions) (*frameChange, error) {
+	if opts.UnitScale < 0 || math.IsNaN(opts.UnitScale) || math.IsInf(opts.UnitScale, 0) {
+		return nil, fmt.Errorf("mst: invalid engine unit scale %v", opts.UnitScale)
+	}
+	to := uint8(AXIS_CONVENTION_NONE)
+	switch strings.ToUpper(opts.UpAxis) {
+	case "":
+	case "Y":
+		to = AXIS_CONVENTION_Y_UP
+	case "Z":
+		to = AXIS_CONVENTION_Z_UP
+	default:
+		return nil, fmt.Errorf("mst: unknown up axis %q", opts.UpAxis)
+	}
+	fc, err := axisChange(opts.SourceAxis, to)
+	if err != nil {
+		return nil, err
+	}
+	if opts.UnitScale == 0 || opts.UnitScale == 1 {
+		return fc, nil
+	}
+	if fc == nil {
+		f := identityFrame
+		fc = &f
+	}
+	fc.scale = opts.UnitScale
+	return fc, nil
+}
+
+func faceCount(nds []*MeshNode) int {
+	n := 0
+	for _, nd := range nds {
+		for _, g := range nd.withPolygonFaces().FaceGroup {
+			n += len(g.Faces)
+		}
+	}
+	return n
+}
+
+func clusterNode(nd *MeshNode, min *[3]float64, cell float64) *MeshNode {
+	out := nd.Clone()
+	out.EdgeGroup = nil
+	out.PolygonGroup = nil
+	index := make(map[[3]int64]uint32)
+	rep := make([]uint32, len(nd.Vertices))
+	sums := make([][3]float64, len(nd.Vertices))
+	counts := make([]float64, len(nd.Vertices))
+	for i, v := range nd.Vertices {
+		var k [3]int64
+		for c := 0; c < 3; c++ {
+			k[c] = int64((float64(v[c]) - min[c]) / cell)
+		}
+		r, ok := index[k]
+		if !ok {
+			r = uint32(i)
+			index[k] = r
+		}
+		rep[i] = r
+		for c := 0; c < 3; c++ {
+			sums[r][c] += float64(v[c])
+		}
+		counts[r]++
+	}
+	for i, c := range counts {
+		if c > 0 {
+			out.Vertices[i] = vec3.T{float32(sums[i][0] / c), float32(sums[i][1] / c), float32(sums[i][2] / c)}
+		}
+	}
+	out.FaceGroup = nil
+	for _, g := range nd.FaceGroup {
+		tg := &MeshTriangle{Batchid: g.Batchid}
+		seen := make(map[[3]uint32]bool)
+		for i, f := range g.Faces {
+			v := [3]uint32{rep[f.Vertex[0]], rep[f.Vertex[1]], rep[f.Vertex[2]]}
+			if v[0] == v[1] || v[1] == v[2] || v[2] == v[0] {
+				continue
+			}
+			k := v
+			sort.Slice(k[:], func(i, j int) bool { return k[i] < k[j] })
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			cp := *f
+			cp.Vertex = v
+			tg.addFace(&cp, g, i)
+		}
+		if len(tg.Faces) > 0 {
+			out.FaceGroup = append(out.FaceGroup, tg)
+		}
+	}
+	out.removeUnusedVertices()
+	return out
+}
+
+// simplifyNode clusters the vertices on a grid, coarsening it until at most
+// target triangles are left. Face groups and materials are preserved.
+func simplifyNode(nd *MeshNode, target int) *MeshNode {
+	if len(nd.Vertices) == 0 {
+		return nd
+	}
+	min, max := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, v := range nd.Vertices {
+		for c := 0; c < 3; c++ {
+			min[c], max[c] = math.Min(min[c], float64(v[c])), math.Max(max[c], float64(v[c]))
+		}
+	}
+	size := math.Max(max[0]-min[0], math.Max(max[1]-min[1], max[2]-min[2]))
+	if size == 0 {
+		return nd
+	}
+	out := nd
+	for res := math.Ceil(2 * math.Sqrt(float64(target))); faceCount([]*MeshNode{out}) > target && res >= 1; res = math.Floor(res * 0.8) {
+		out = clusterNode(nd, &min, size/res)
+	}
+	return out
+}
+
+func simplifyBaseMesh(ms *BaseMesh, ratio float64) *BaseMesh {
+	out := &BaseMesh{Materials: ms.Materials, Code: ms.Code}
+	for _, nd := range ms.Nodes {
+		nd = nd.withPolygonFaces()
+		if sn := simplifyNode(nd, int(ratio*float64(faceCount([]*MeshNode{nd})))); faceCount([]*MeshNode{sn}) > 0 {
+			out.Nodes = append(out.Nodes, sn)
+		}
+	}
+	return out
+}
+
+func boxProxyNode(bx *[6]float64) *MeshNode {
+	nd := &MeshNode{}
+	for i := 0; i < 8; i++ {
+		x, y, z := bx[0], bx[1], bx[2]
+		if i&1 != 0 {
+			x = bx[3]
+		}
+		if i&2 != 0 {
+			y = bx[4]
+		}
+		if i&4 != 0 {
+			z = bx[5]
+		}
+		nd.Vertices = append(nd.Vertices, vec3.T{float32(x), float32(y), float32(z)})
+	}
+	quads := [6][4]uint32{{0, 2, 3, 1}, {4, 5, 7, 6}, {0, 1, 5, 4}, {2, 6, 7, 3}, {0, 4, 6, 2}, {1, 3, 7, 5}}
+	g := &MeshTriangle{Batchid: 0}
+	for _, q := range quads {
+		g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{q[0], q[1], q[2]}}, &Face{Vertex: [3]uint32{q[0], q[2], q[3]}})
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.ReComputeNormal()
+	return nd
+}
+
+func baseMeshBBox(ms *BaseMesh) [6]float64 {
+	m := &Mesh{BaseMesh: *ms}
+	bx := m.ComputeBBox()
+	return [6]float64{bx.Min[0], bx.Min[1], bx.Min[2], bx.Max[0], bx.Max[1], bx.Max[2]}
+}
+
+func exportEngineAsset(dir, name string, ms *BaseMesh, opts *EngineExportOptions) (*EngineAsset, error) {
+	asset := &EngineAsset{Name: engineMeshName(opts.Engine, name), File: name + ".glb"}
+	asset.BBox = baseMeshBBox(ms)
+	asset.Lods = []EngineLod{{Level: 0, Name: engineLodName(opts.Engine, name, 0)}}
+
+	doc := CreateDoc()
+	gerr := BuildGltfWithOptions(doc, &Mesh{BaseMesh: *ms}, &GltfExportOptions{ContinueOnError: opts.ContinueOnError})
+	if gerr != nil && !opts.ContinueOnError {
+		return nil, gerr
+	}
+	for _, nd := range doc.Nodes {
+		nd.Name = asset.Lods[0].Name
+	}
+	prev := faceCount(ms.Nodes)
+	for i, r := range opts.LodRatios {
+		lod := simplifyBaseMesh(ms, r)
+		n := faceCount(lod.Nodes)
+		if n == 0 || n >= prev {
+			break
+		}
+		prev = n
+		level := EngineLod{Level: i + 1, Name: engineLodName(opts.Engine, name, i+1)}
+		first := len(doc.Nodes)
+		if err := BuildGltfWithOptions(doc, &Mesh{BaseMesh: *lod}, &GltfExportOptions{ContinueOnError: opts.ContinueOnError}); err != nil && !opts.ContinueOnError {
+			return nil, err
+		}
+		for _, nd := range doc.Nodes[first:] {
+			nd.Name = level.Name
+		}
+		asset.Lods = append(asset.Lods, level)
+	}
+	if opts.Collision && len(ms.Nodes) > 0 {
+		bx := asset.BBox
+		asset.Collision = &EngineCollision{
+			Name:   engineCollisionName(opts.Engine, name),
+			Type:   "box",
+			Center: [3]float64{(bx[0] + bx[3]) / 2, (bx[1] + bx[4]) / 2, (bx[2] + bx[5]) / 2},
+			Size:   [3]float64{bx[3] - bx[0], bx[4] - bx[1], bx[5] - bx[2]},
+		}
+		first := len(doc.Nodes)
+		proxy := &BaseMesh{Materials: []MeshMaterial{&BaseMaterial{Color: [3]byte{128, 128, 128}}}, Nodes: []*MeshNode{boxProxyNode(&bx)}}
+		if opts.CollisionMesh != nil {
+			col, err := GenerateCollisionMesh(&Mesh{BaseMesh: *ms}, opts.CollisionMesh)
+			if err != nil {
+				return nil, err
+			}
+			asset.Collision.Type = "mesh"
+			proxy = &col.BaseMesh
+		}
+		if err := buildGltf(doc, proxy, nil, nil, false, nil, &GltfExportOptions{}); err != nil {
+			return nil, err
+		}
+		for _, nd := range doc.Nodes[first:] {
+			nd.Name = asset.Collision.Name
+		}
+	}
+	bt, err := GetGltfBinary(doc, 8)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, asset.File), bt, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return asset, gerr
+}
+
+func matToArray(mt *dmat.T) [16]float64 {
+	var a [16]float64
+	for i := 0; i < 4; i++ {
+		copy(a[i*4:], mt[i][:])
+	}
+	return a
+}
+
+func ExportEnginePackage(dir, name string, mh *Mesh, opts *EngineExportOptions) (*EnginePackage, error) {
+	if opts == nil {
+		opts = DefaultEngineExportOptions(ENGINE_UNITY)
+	}
+	for _, r := range opts.LodRatios {
+		if !(r > 0 && r < 1) {
+			return nil, fmt.Errorf("mst: invalid lod ratio %v", r)
+		}
+	}
+	fc, err := engineFrame(opts)
+	if err != nil {
+		return nil, err
+	}
+	if fc != nil {
+		mh = mh.Clone(WithSharedTextures())
+		fc.mesh(mh)
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	pkg := &EnginePackage{Name: name, Engine: engineName(opts.Engine), Units: opts.Units, UnitScale: opts.UnitScale, UpAxis: opts.UpAxis}
+	ec := newErrorCollector(opts.ContinueOnError, opts.Logger)
+	for i, nd := range mh.Nodes {
+		nm := fmt.Sprintf("%s_node_%d", name, i)
+		asset, err := exportEngineAsset(dir, nm, subBaseMesh(&mh.BaseMesh, []*MeshNode{nd}), opts)
+		if err := ec.report(nm, err); err != nil {
+			return nil, err
+		}
+		if asset == nil {
+			continue
+		}
+		pkg.Assets = append(pkg.Assets, *asset)
+	}
+	for i, inst := range mh.InstanceNode {
+		nm := fmt.Sprintf("%s_instance_%d", name, i)
+		if inst.Mesh == nil {
+			if err := ec.report(nm, ErrUnresolvedInstanceRef); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		asset, err := exportEngineAsset(dir, nm, inst.Mesh, opts)
+		if err := ec.report(nm, err); err != nil {
+			return nil, err
+		}
+		if asset == nil {
+			continue
+		}
+		for _, mt := range inst.Transfors {
+			asset.Instances = append(asset.Instances, matToArray(mt))
+		}
+		pkg.Assets = append(pkg.Assets, *asset)
+	}
+	bt, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+ENGINE_METADATA_EXT), bt, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return pkg, ec.err()
+}
@@ -0,0 +1,89 @@
+package mst
+
+import (
+	"fmt"
+	"strings"
+)
+
+type AssetError struct {
+	Field string
+	Err   error
+}
+
+func (e *AssetError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *AssetError) Unwrap() error {
+	return e.Err
+}
+
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("mst: %d errors occurred: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *MultiError) Append(field string, err error) {
+	if err == nil {
+		return
+	}
+	if me, ok := err.(*MultiError); ok {
+		for _, sub := range me.Errors {
+			e.Append(field, sub)
+		}
+		return
+	}
+	if ae, ok := err.(*AssetError); ok && field != "" {
+		err = &AssetError{Field: field + "." + ae.Field, Err: ae.Err}
+	} else if field != "" {
+		err = &AssetError{Field: field, Err: err}
+	}
+	e.Errors = append(e.Errors, err)
+}
+
+func (e *MultiError) ErrorOrNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+type errorCollector struct {
+	errs *MultiError
+	log  Logger
+}
+
+func newErrorCollector(continueOnError bool, log Logger) *errorCollector {
+	if !continueOnError {
+		return &errorCollector{}
+	}
+	return &errorCollector{errs: &MultiError{}, log: log}
+}
+
+func (c *errorCollector) report(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.errs == nil {
+		return err
+	}
+	if _, nested := err.(*MultiError); !nested {
+		logWarn(c.log, "recovered error", "field", field, "error", err)
+	}
+	c.errs.Append(field, err)
+	return nil
+}
+
+func (c *errorCollector) err() error {
+	return c.errs.ErrorOrNil()
+}
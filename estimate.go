@@ -0,0 +1,235 @@
+package mst
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+)
+
+type SizeEstimate struct {
+	Vertices int64
+	Indices  int64
+	Textures int64
+	Props    int64
+	Other    int64
+}
+
+func (e SizeEstimate) Total() int64 {
+	return e.Vertices + e.Indices + e.Textures + e.Props + e.Other
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func propsSize(props Properties) int64 {
+	if len(props) == 0 {
+		return 0
+	}
+	cw := &countingWriter{}
+	PropertiesMarshal(cw, props)
+	return cw.n
+}
+
+func textureSize(tex *Texture) int64 {
+	if tex == nil {
+		return 0
+	}
+	return int64(len(tex.Data))
+}
+
+func materialTextureSize(mtls []MeshMaterial, refs bool) int64 {
+	var n int64
+	for _, mtl := range mtls {
+		if _, ok := mtl.(*MaterialRef); ok && refs {
+			continue
+		}
+		if tm := materialTextures(mtl); tm != nil {
+			n += textureSize(tm.Texture) + textureSize(tm.Normal)
+		}
+	}
+	return n
+}
+
+const (
+	faceMemSize  = int64(unsafe.Sizeof(Face{})) + int64(unsafe.Sizeof(&Face{}))
+	nodeMemSize  = int64(unsafe.Sizeof(MeshNode{}))
+	indexMemSize = int64(unsafe.Sizeof([3]uint32{}))
+)
+
+func (n *MeshNode) estimateMemory() SizeEstimate {
+	e := SizeEstimate{Other: nodeMemSize}
+	e.Vertices = int64(len(n.Vertices)+len(n.Normals))*12 + int64(len(n.TexCoords)+len(n.TexCoords2))*8 + int64(len(n.Colors))*3
+	for _, t := range n.MorphTargets {
+		e.Vertices += int64(len(t.Positions)+len(t.Normals)) * 12
+	}
+	for _, g := range n.FaceGroup {
+		e.Indices += int64(len(g.Faces)) * faceMemSize
+		for _, f := range g.Faces {
+			if f.Normal != nil {
+				e.Indices += indexMemSize
+			}
+			if f.Uv != nil {
+				e.Indices += indexMemSize
+			}
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		e.Indices += int64(len(g.Edges)) * 8
+	}
+	e.Indices += polygonsSize(n, 4)
+	if n.Mat != nil {
+		e.Other += int64(unsafe.Sizeof(*n.Mat))
+	}
+	e.Textures = textureSize(n.Lightmap)
+	e.Props = propsSize(n.Props)
+	return e
+}
+
+func (e *SizeEstimate) add(o SizeEstimate) {
+	e.Vertices += o.Vertices
+	e.Indices += o.Indices
+	e.Textures += o.Textures
+	e.Props += o.Props
+	e.Other += o.Other
+}
+
+func (m *Mesh) EstimateMemory() SizeEstimate {
+	var e SizeEstimate
+	for _, nd := range m.Nodes {
+		e.add(nd.estimateMemory())
+	}
+	e.Textures += materialTextureSize(m.Materials, false)
+	e.Props += propsSize(m.Props)
+	for _, inst := range m.InstanceNode {
+		e.Other += int64(len(inst.Transfors))*int64(unsafe.Sizeof(dmat.T{})) + int64(len(inst.Features))*8
+		e.Props += propsSize(inst.Props)
+		if inst.Mesh == nil {
+			continue
+		}
+		for _, nd := range inst.Mesh.Nodes {
+			e.add(nd.estimateMemory())
+		}
+		e.Textures += materialTextureSize(inst.Mesh.Materials, false)
+	}
+	return e
+}
+
+func (n *MeshNode) estimateSerialized(caps Capabilities) SizeEstimate {
+	var e SizeEstimate
+	width := int64(INDEX_WIDTH_32)
+	if caps.IndexWidth {
+		width = int64(n.GetIndexWidth())
+	}
+	quant := n.storedQuantization(caps)
+	nv, nn, nt := int64(len(n.Vertices)), int64(len(n.Normals)), int64(len(n.TexCoords))
+	if quant.flags&QUANTIZE_POSITION != 0 {
+		e.Vertices += nv*6 + int64(binary.Size(&quant.pos))
+	} else {
+		e.Vertices += nv * 12
+	}
+	if quant.flags&QUANTIZE_NORMAL_OCT16 != 0 {
+		e.Vertices += nn * 2
+	} else if quant.flags&QUANTIZE_NORMAL != 0 {
+		e.Vertices += nn * 4
+	} else {
+		e.Vertices += nn * 12
+	}
+	if quant.flags&QUANTIZE_TEXCOORD_HALF != 0 {
+		e.Vertices += nt * 4
+	} else if quant.flags&QUANTIZE_TEXCOORD != 0 {
+		e.Vertices += nt*4 + int64(binary.Size(&quant.uv))
+	} else {
+		e.Vertices += nt * 8
+	}
+	e.Vertices += int64(len(n.Colors)) * 3
+	if caps.TexCoords2 {
+		e.Vertices += int64(len(n.TexCoords2)) * 8
+	}
+	for _, g := range n.FaceGroup {
+		faces := int64(len(g.Faces)) * 3 * width
+		if caps.PrimitiveModes {
+			e.Indices += primitiveSize(g, width)
+		} else {
+			e.Indices += faces
+		}
+		if caps.FaceIndices {
+			mask := g.faceIndexMask()
+			if mask&faceIndicesNormal != 0 {
+				e.Indices += faces
+			}
+			if mask&faceIndicesUv != 0 {
+				e.Indices += faces
+			}
+		}
+		if caps.FaceFeatures {
+			e.Indices += faceFeaturesSize(g)
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		e.Indices += int64(len(g.Edges)) * 2 * width
+	}
+	if caps.Polygons {
+		e.Indices += polygonsSize(n, width)
+	}
+	if caps.NodeProps {
+		e.Props = propsSize(n.Props)
+	}
+	if caps.Lightmaps {
+		e.Textures = textureSize(n.Lightmap)
+	}
+	return e
+}
+
+func (m *Mesh) EstimateSerializedSize(version uint32, opts ...WriteOption) (SizeEstimate, error) {
+	if !IsSupportedVersion(version) {
+		return SizeEstimate{}, ErrUnsupportedVersion
+	}
+	o := newWriteOptions(opts)
+	if err := o.validate(); err != nil {
+		return SizeEstimate{}, err
+	}
+	cp := *m
+	cp.Version = version
+	ms := &cp
+	if o.externalTextures {
+		ms = externalTextures(ms)
+	}
+	v, flags := o.header(requiredVersion(ms, version))
+	caps := FormatCapabilities(v)
+	var e SizeEstimate
+	for _, nd := range ms.Nodes {
+		e.add(nd.estimateSerialized(caps))
+	}
+	e.Textures += materialTextureSize(ms.Materials, caps.MaterialRefs)
+	if caps.Props {
+		e.Props += propsSize(ms.Props)
+	}
+	for _, inst := range ms.InstanceNode {
+		if caps.Props {
+			e.Props += propsSize(inst.Props)
+		}
+		if inst.Mesh == nil || inst.Ref != nil && caps.InstanceRefs {
+			continue
+		}
+		for _, nd := range inst.Mesh.Nodes {
+			e.add(nd.estimateSerialized(caps))
+		}
+		e.Textures += materialTextureSize(inst.Mesh.Materials, caps.MaterialRefs)
+	}
+	cw := &countingWriter{}
+	meshSectionsMarshal(cw, ms, v, func() {}, nil)
+	sections := meshSectionCount(v)
+	total := cw.n + int64(headerSize(v, flags, sections, len(o.manifestBytes(ms, v))))
+	if flags&MESH_FLAG_CHECKSUM != 0 {
+		total += int64(4+4*sections+4) + int64(len(MESH_FOOTER_SIGNATURE))
+	}
+	e.Other = total - e.Vertices - e.Indices - e.Textures - e.Props
+	return e, nil
+}
@@ -0,0 +1,185 @@
+package mst
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+)
+
+var ErrNodeNotFound = errors.New("mst: node not found")
+
+func (d *decoder) skip(n int64) {
+	if d.err != nil || n <= 0 {
+		return
+	}
+	if _, err := io.CopyN(ioutil.Discard, d.rd, n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.fail(err)
+	}
+}
+
+func (d *decoder) skipArray(field string, elemSize int64) {
+	n := d.count(field, func(l *DecodeLimits) uint32 { return l.MaxVertices })
+	d.skip(int64(n) * elemSize)
+}
+
+func (d *decoder) skipQuantizedArray(field string, elemSize, quantSize, header int64, quantized bool) {
+	if !quantized {
+		d.skipArray(field, elemSize)
+		return
+	}
+	n := d.count(field, func(l *DecodeLimits) uint32 { return l.MaxVertices })
+	if n > 0 {
+		d.skip(header + int64(n)*quantSize)
+	}
+}
+
+func (d *decoder) skipMeshNode() {
+	width := d.indexWidth()
+	quant := d.quantization()
+	d.skipQuantizedArray("vertex count", 12, 6, 24, quant&QUANTIZE_POSITION != 0)
+	if quant&QUANTIZE_NORMAL_OCT16 != 0 {
+		d.skipQuantizedArray("normal count", 12, 2, 0, true)
+	} else {
+		d.skipQuantizedArray("normal count", 12, 4, 0, quant&QUANTIZE_NORMAL != 0)
+	}
+	d.skipArray("color count", 3)
+	if quant&QUANTIZE_TEXCOORD_HALF != 0 {
+		d.skipQuantizedArray("texcoord count", 8, 4, 0, true)
+	} else {
+		d.skipQuantizedArray("texcoord count", 8, 4, 24, quant&QUANTIZE_TEXCOORD != 0)
+	}
+	var isMat uint8
+	d.read(&isMat)
+	if isMat == 1 {
+		d.skip(128)
+	}
+	groups := d.count("face group count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	for i := 0; i < groups && d.err == nil; i++ {
+		d.skip(4)
+		var n int
+		if d.caps().PrimitiveModes {
+			n = d.skipPrimitive(width)
+		} else {
+			n = d.count("face count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+			d.skip(int64(n) * 3 * int64(width))
+		}
+		if d.caps().FaceIndices {
+			d.skipFaceIndices(n, width)
+		}
+		if d.caps().FaceFeatures {
+			d.skipFaceFeatures(n)
+		}
+	}
+	groups = d.count("edge group count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	for i := 0; i < groups && d.err == nil; i++ {
+		d.skip(4)
+		d.skipArray("edge count", 2*int64(width))
+		if d.caps().OutlineStyles {
+			d.skipOutlineStyle()
+		}
+	}
+	if d.caps().NodeProps {
+		d.props(0)
+	}
+	if d.caps().MorphTargets {
+		d.skipMorphTargets()
+	}
+	if d.caps().Hierarchy {
+		d.skipHierarchy()
+	}
+	if d.caps().TexCoords2 {
+		d.skipArray("texcoord2 count", 8)
+	}
+	if d.caps().Lightmaps {
+		d.lightmap(&MeshNode{})
+	}
+	if d.caps().Polygons {
+		d.skipPolygons(width)
+	}
+}
+
+// ExtractNodeFrom decodes a single node, selected by index or, when selector is
+// not a number, by name.
+func ExtractNodeFrom(rd io.Reader, selector string) (*Mesh, error) {
+	d := newDecoder(rd, 0, &DefaultDecodeLimits)
+	if !d.header() {
+		return nil, d.err
+	}
+	var mtls []MeshMaterial
+	d.section(func() { mtls = d.materials() })
+	d.beginSection()
+	return extractNode(d, mtls, selector)
+}
+
+// ExtractNodeAt is ExtractNodeFrom for random access input. When the file has
+// a section table it seeks to the node section instead of reading the file in
+// order.
+func ExtractNodeAt(ra io.ReaderAt, selector string) (*Mesh, error) {
+	whole := func() io.Reader { return bufio.NewReader(io.NewSectionReader(ra, 0, math.MaxInt64)) }
+	hdr, err := ReadMeshHeader(whole())
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Flags&MESH_FLAG_SECTION_TABLE == 0 || len(hdr.Sections) <= MESH_SECTION_NODES {
+		return ExtractNodeFrom(whole(), selector)
+	}
+	mtls, err := ReadMeshSection(ra, hdr, MESH_SECTION_MATERIALS)
+	if err != nil {
+		return nil, err
+	}
+	s := hdr.Sections[MESH_SECTION_NODES]
+	d := newSectionDecoder(bufio.NewReader(io.NewSectionReader(ra, int64(s.Offset), int64(s.Length))), hdr)
+	d.beginSection()
+	return extractNode(d, mtls.Materials, selector)
+}
+
+func extractNode(d *decoder, mtls []MeshMaterial, selector string) (*Mesh, error) {
+	base := &BaseMesh{Materials: mtls}
+	index, err := strconv.Atoi(selector)
+	byName := err != nil
+	n := d.count("node count", func(l *DecodeLimits) uint32 { return l.MaxNodes })
+	if d.err != nil {
+		return nil, d.err
+	}
+	if !byName && (index < 0 || index >= n) {
+		return nil, ErrNodeNotFound
+	}
+	var nd *MeshNode
+	for i := 0; i < n && nd == nil && d.err == nil; i++ {
+		switch {
+		case byName:
+			if c := d.meshNode(); c.Name == selector {
+				nd = c
+			}
+		case i == index:
+			nd = d.meshNode()
+		default:
+			d.skipMeshNode()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if nd == nil {
+		return nil, ErrNodeNotFound
+	}
+	out := &Mesh{Version: d.v}
+	out.BaseMesh = *subBaseMesh(base, []*MeshNode{nd})
+	return out, nil
+}
+
+func ExtractNode(path string, selector string) (*Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ExtractNodeAt(f, selector)
+}
@@ -0,0 +1,205 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	faceFeatureGroup = 1 << 0
+	faceFeaturePer   = 1 << 1
+)
+
+func (g *MeshTriangle) FaceFeature(i int) (uint64, bool) {
+	if i < len(g.Features) {
+		return g.Features[i], true
+	}
+	if g.FeatureID != nil {
+		return *g.FeatureID, true
+	}
+	return 0, len(g.Features) > 0
+}
+
+func (g *MeshTriangle) HasFeatures() bool {
+	return g.FeatureID != nil || len(g.Features) > 0
+}
+
+func (g *MeshTriangle) SetFaceFeature(i int, id uint64) {
+	if len(g.Features) == 0 && g.FeatureID != nil && *g.FeatureID == id {
+		return
+	}
+	g.expandFeatures()
+	g.Features[i] = id
+}
+
+func (g *MeshTriangle) expandFeatures() {
+	if len(g.Features) >= len(g.Faces) {
+		return
+	}
+	var fill uint64
+	if g.FeatureID != nil {
+		fill = *g.FeatureID
+	}
+	for len(g.Features) < len(g.Faces) {
+		g.Features = append(g.Features, fill)
+	}
+}
+
+func (g *MeshTriangle) addFace(f *Face, src *MeshTriangle, i int) {
+	n := len(g.Faces)
+	g.Faces = append(g.Faces, f)
+	id, ok := uint64(0), false
+	if src != nil {
+		id, ok = src.FaceFeature(i)
+	}
+	switch {
+	case len(g.Features) > 0:
+		g.Features = append(g.Features, id)
+	case n == 0:
+		if ok {
+			g.FeatureID = &id
+		}
+	case g.FeatureID == nil && !ok, g.FeatureID != nil && ok && *g.FeatureID == id:
+	default:
+		g.Faces = g.Faces[:n]
+		g.expandFeatures()
+		g.Faces = append(g.Faces, f)
+		g.Features = append(g.Features, id)
+	}
+}
+
+func (g *MeshTriangle) cloneFeatures(dst *MeshTriangle) {
+	if g.FeatureID != nil {
+		id := *g.FeatureID
+		dst.FeatureID = &id
+	}
+	if len(g.Features) > 0 {
+		dst.Features = append([]uint64(nil), g.Features...)
+	}
+}
+
+func (n *MeshNode) FeatureForFace(group, face int) (uint64, bool) {
+	if group < 0 || group >= len(n.FaceGroup) || face < 0 || face >= len(n.FaceGroup[group].Faces) {
+		return 0, false
+	}
+	return n.FaceGroup[group].FaceFeature(face)
+}
+
+func (n *MeshNode) FaceFeatureIDs() []uint64 {
+	seen := make(map[uint64]bool)
+	var out []uint64
+	for _, g := range n.FaceGroup {
+		for i := range g.Faces {
+			if id, ok := g.FaceFeature(i); ok && !seen[id] {
+				seen[id] = true
+				out = append(out, id)
+			}
+		}
+	}
+	return out
+}
+
+func (g *MeshTriangle) faceFeatureMask() uint8 {
+	var mask uint8
+	if g.FeatureID != nil {
+		mask |= faceFeatureGroup
+	}
+	if len(g.Features) > 0 {
+		mask |= faceFeaturePer
+	}
+	return mask
+}
+
+func hasFaceFeatures(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool {
+		for _, g := range nd.FaceGroup {
+			if g.HasFeatures() {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func faceFeaturesMarshal(wt io.Writer, g *MeshTriangle) {
+	mask := g.faceFeatureMask()
+	writeLittleByte(wt, mask)
+	if mask&faceFeatureGroup != 0 {
+		writeLittleByte(wt, *g.FeatureID)
+	}
+	if mask&faceFeaturePer != 0 {
+		ids := make([]uint64, len(g.Faces))
+		for i := range ids {
+			ids[i], _ = g.FaceFeature(i)
+		}
+		writeLittleByte(wt, ids)
+	}
+}
+
+func (d *decoder) faceFeatures(g *MeshTriangle) {
+	var mask uint8
+	d.read(&mask)
+	if mask&^(faceFeatureGroup|faceFeaturePer) != 0 {
+		d.fail(fmt.Errorf("mst: unknown face feature flags %#x", mask))
+		return
+	}
+	if mask&faceFeatureGroup != 0 {
+		var id uint64
+		if d.read(&id) {
+			g.FeatureID = &id
+		}
+	}
+	if mask&faceFeaturePer != 0 {
+		g.Features = d.uint64s(len(g.Faces))
+	}
+}
+
+func (d *decoder) skipFaceFeatures(faces int) {
+	var mask uint8
+	d.read(&mask)
+	if mask&faceFeatureGroup != 0 {
+		d.skip(8)
+	}
+	if mask&faceFeaturePer != 0 {
+		d.skip(int64(faces) * 8)
+	}
+}
+
+func faceFeaturesSize(g *MeshTriangle) int64 {
+	size := int64(1)
+	if g.FeatureID != nil {
+		size += 8
+	}
+	if len(g.Features) > 0 {
+		size += int64(len(g.Faces)) * 8
+	}
+	return size
+}
+
+func dropFaceFeatures(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		dropped := false
+		for _, g := range nd.FaceGroup {
+			dropped = dropped || g.HasFeatures()
+		}
+		if !dropped {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.FaceGroup = make([]*MeshTriangle, len(nd.FaceGroup))
+		for j, g := range nd.FaceGroup {
+			cg := *g
+			cg.FeatureID, cg.Features = nil, nil
+			cp.FaceGroup[j] = &cg
+		}
+		out[i] = &cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "dropped face feature ids"})
+	}
+	return out, warns
+}
@@ -0,0 +1,145 @@
+package mst
+
+import "io"
+
+const (
+	faceIndicesNormal = 1 << 0
+	faceIndicesUv     = 1 << 1
+)
+
+func (f *Face) normalIndices() [3]uint32 {
+	if f.Normal != nil {
+		return *f.Normal
+	}
+	return f.Vertex
+}
+
+func (f *Face) uvIndices() [3]uint32 {
+	if f.Uv != nil {
+		return *f.Uv
+	}
+	return f.Vertex
+}
+
+func (g *MeshTriangle) faceIndexMask() uint8 {
+	var mask uint8
+	for _, f := range g.Faces {
+		if f.normalIndices() != f.Vertex {
+			mask |= faceIndicesNormal
+		}
+		if f.uvIndices() != f.Vertex {
+			mask |= faceIndicesUv
+		}
+	}
+	return mask
+}
+
+func hasFaceIndices(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool {
+		for _, g := range nd.FaceGroup {
+			if g.faceIndexMask() != 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func faceIndicesMarshal(wt io.Writer, g *MeshTriangle, width uint8) {
+	mask := g.faceIndexMask()
+	writeLittleByte(wt, mask)
+	if mask&faceIndicesNormal != 0 {
+		for _, f := range g.Faces {
+			idx := f.normalIndices()
+			writeIndices(wt, idx[:], width)
+		}
+	}
+	if mask&faceIndicesUv != 0 {
+		for _, f := range g.Faces {
+			idx := f.uvIndices()
+			writeIndices(wt, idx[:], width)
+		}
+	}
+}
+
+func (d *decoder) faceIndices(g *MeshTriangle, width uint8) {
+	var mask uint8
+	d.read(&mask)
+	read := func() []*[3]uint32 {
+		out := make([]*[3]uint32, len(g.Faces))
+		idx := make([][3]uint32, len(g.Faces))
+		for i, f := range g.Faces {
+			if d.err != nil {
+				break
+			}
+			if d.indices(idx[i][:], width); idx[i] != f.Vertex {
+				out[i] = &idx[i]
+			}
+		}
+		return out
+	}
+	if mask&faceIndicesNormal != 0 {
+		for i, idx := range read() {
+			g.Faces[i].Normal = idx
+		}
+	}
+	if mask&faceIndicesUv != 0 {
+		for i, idx := range read() {
+			g.Faces[i].Uv = idx
+		}
+	}
+}
+
+func (d *decoder) skipFaceIndices(faces int, width uint8) {
+	var mask uint8
+	d.read(&mask)
+	for _, bit := range []uint8{faceIndicesNormal, faceIndicesUv} {
+		if mask&bit != 0 {
+			d.skip(int64(faces) * 3 * int64(width))
+		}
+	}
+}
+
+func (n *MeshNode) validateFaceIndices() error {
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if f.Normal == nil && f.Uv == nil {
+				continue
+			}
+			if err := n.validateFace(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dropFaceIndices(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if nd.DetectIndexingMode() != INDEXING_MODE_SEPARATE {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.FaceGroup = make([]*MeshTriangle, len(nd.FaceGroup))
+		for j, g := range nd.FaceGroup {
+			cg := *g
+			cg.Faces = make([]*Face, len(g.Faces))
+			for k, f := range g.Faces {
+				cf := *f
+				cg.Faces[k] = &cf
+			}
+			cp.FaceGroup[j] = &cg
+		}
+		cp.IndexingMode = INDEXING_MODE_SEPARATE
+		cp.ResortVtVn(nil)
+		out[i] = &cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "flattened nodes with separate normal or texture coordinate indices"})
+	}
+	return out, warns
+}
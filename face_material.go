@@ -0,0 +1,70 @@
+package mst
+
+import "sort"
+
+func (f *Face) materialIndex(batchid int32) int32 {
+	if f.Material != nil {
+		return *f.Material
+	}
+	return batchid
+}
+
+func (n *MeshNode) hasFaceMaterials() bool {
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if f.Material != nil && *f.Material != g.Batchid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (n *MeshNode) materialGroups() []*MeshTriangle {
+	if !n.hasFaceMaterials() {
+		return n.FaceGroup
+	}
+	index := make(map[int32]*MeshTriangle)
+	var out []*MeshTriangle
+	for _, g := range n.FaceGroup {
+		for i, f := range g.Faces {
+			id := f.materialIndex(g.Batchid)
+			tg, ok := index[id]
+			if !ok {
+				tg = &MeshTriangle{Batchid: id}
+				index[id] = tg
+				out = append(out, tg)
+			}
+			cp := *f
+			cp.Material = nil
+			tg.addFace(&cp, g, i)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Batchid < out[j].Batchid })
+	return out
+}
+
+func (n *MeshNode) withMaterialGroups() *MeshNode {
+	if !n.hasFaceMaterials() {
+		return n
+	}
+	cp := *n
+	cp.FaceGroup = n.materialGroups()
+	return &cp
+}
+
+func (n *MeshNode) RegroupByMaterial() {
+	n.FaceGroup = n.materialGroups()
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			f.Material = nil
+		}
+	}
+}
+
+func (m *Mesh) RegroupByMaterial() {
+	anyNode(m, func(nd *MeshNode) bool {
+		nd.RegroupByMaterial()
+		return false
+	})
+}
@@ -0,0 +1,104 @@
+package mst
+
+import (
+	"fmt"
+	"sort"
+)
+
+type IndexError struct {
+	Kind  string
+	Index uint32
+	Count int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("mst: %s index %d out of range (%d available)", e.Kind, e.Index, e.Count)
+}
+
+func checkIndices(kind string, idx []uint32, count int) error {
+	for _, i := range idx {
+		if int(i) >= count {
+			return &IndexError{Kind: kind, Index: i, Count: count}
+		}
+	}
+	return nil
+}
+
+func (n *MeshNode) validateFace(f *Face) error {
+	if err := checkIndices("vertex", f.Vertex[:], len(n.Vertices)); err != nil {
+		return err
+	}
+	if f.Normal != nil {
+		if err := checkIndices("normal", f.Normal[:], len(n.Normals)); err != nil {
+			return err
+		}
+	}
+	if f.Uv != nil {
+		if err := checkIndices("texcoord", f.Uv[:], len(n.TexCoords)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *MeshNode) AddFaces(batchid int32, faces []*Face) error {
+	return n.addFaces(&MeshTriangle{Batchid: batchid, Faces: faces})
+}
+
+func (n *MeshNode) addFaces(src *MeshTriangle) error {
+	for _, f := range src.Faces {
+		if f == nil {
+			return fmt.Errorf("mst: nil face")
+		}
+		if err := n.validateFace(f); err != nil {
+			return err
+		}
+	}
+	for _, g := range n.FaceGroup {
+		if g.Batchid == src.Batchid {
+			g.Mode = MESH_TRIANGLE_MODE_TRIANGLES
+			for i, f := range src.Faces {
+				g.addFace(f, src, i)
+			}
+			return nil
+		}
+	}
+	n.FaceGroup = append(n.FaceGroup, src)
+	sort.SliceStable(n.FaceGroup, func(i, j int) bool { return n.FaceGroup[i].Batchid < n.FaceGroup[j].Batchid })
+	return nil
+}
+
+func (n *MeshNode) AddEdges(batchid int32, edges [][2]uint32) error {
+	for _, e := range edges {
+		if err := checkIndices("vertex", e[:], len(n.Vertices)); err != nil {
+			return err
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		if g.Batchid == batchid {
+			g.Edges = append(g.Edges, edges...)
+			return nil
+		}
+	}
+	n.EdgeGroup = append(n.EdgeGroup, &MeshOutline{Batchid: batchid, Edges: edges})
+	sort.SliceStable(n.EdgeGroup, func(i, j int) bool { return n.EdgeGroup[i].Batchid < n.EdgeGroup[j].Batchid })
+	return nil
+}
+
+func (n *MeshNode) validateVertexIndices() error {
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if err := checkIndices("vertex", f.Vertex[:], len(n.Vertices)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		for _, e := range g.Edges {
+			if err := checkIndices("vertex", e[:], len(n.Vertices)); err != nil {
+				return err
+			}
+		}
+	}
+	return n.validatePolygons()
+}
@@ -0,0 +1,75 @@
+package mst
+
+import (
+	"sort"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+)
+
+type FeatureRef struct {
+	Instance  int
+	Transform int
+	Props     Properties
+}
+
+type FeatureTable struct {
+	mesh  *Mesh
+	ids   []uint64
+	index map[uint64][]FeatureRef
+}
+
+func (m *Mesh) BuildFeatureIndex() *FeatureTable {
+	ft := &FeatureTable{mesh: m, index: make(map[uint64][]FeatureRef)}
+	for i, inst := range m.InstanceNode {
+		for j, id := range inst.Features {
+			if j >= len(inst.Transfors) {
+				break
+			}
+			if _, ok := ft.index[id]; !ok {
+				ft.ids = append(ft.ids, id)
+			}
+			ft.index[id] = append(ft.index[id], FeatureRef{Instance: i, Transform: j, Props: inst.Props})
+		}
+	}
+	sort.Slice(ft.ids, func(i, j int) bool { return ft.ids[i] < ft.ids[j] })
+	return ft
+}
+
+func (ft *FeatureTable) Len() int {
+	return len(ft.ids)
+}
+
+func (ft *FeatureTable) IDs() []uint64 {
+	return append([]uint64(nil), ft.ids...)
+}
+
+func (ft *FeatureTable) Has(id uint64) bool {
+	_, ok := ft.index[id]
+	return ok
+}
+
+func (ft *FeatureTable) Lookup(id uint64) []FeatureRef {
+	return ft.index[id]
+}
+
+func (ft *FeatureTable) TransformsForFeature(id uint64) []*dmat.T {
+	refs := ft.index[id]
+	if len(refs) == 0 {
+		return nil
+	}
+	out := make([]*dmat.T, len(refs))
+	for i, ref := range refs {
+		out[i] = ft.mesh.InstanceNode[ref.Instance].Transfors[ref.Transform]
+	}
+	return out
+}
+
+func (ft *FeatureTable) PropsForFeature(id uint64) Properties {
+	var out Properties
+	for _, ref := range ft.index[id] {
+		if len(ref.Props) > 0 {
+			out = out.Merge(ref.Props.Clone())
+		}
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package mst
+
+import (
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	FLATTEN_PROPS_INSTANCE  = "instance"
+	FLATTEN_PROPS_TRANSFORM = "transform"
+	FLATTEN_PROPS_FEATURE   = "feature"
+)
+
+func cloneFaceGroups(groups []*MeshTriangle, remap func(int32) int32) []*MeshTriangle {
+	out := make([]*MeshTriangle, len(groups))
+	for i, g := range groups {
+		faces := make([]*Face, len(g.Faces))
+		for j, f := range g.Faces {
+			cp := *f
+			if f.Normal != nil {
+				n := *f.Normal
+				cp.Normal = &n
+			}
+			if f.Uv != nil {
+				uv := *f.Uv
+				cp.Uv = &uv
+			}
+			if f.Material != nil {
+				id := remap(*f.Material)
+				cp.Material = &id
+			}
+			faces[j] = &cp
+		}
+		out[i] = &MeshTriangle{Batchid: remap(g.Batchid), Faces: faces, Mode: g.Mode}
+		g.cloneFeatures(out[i])
+	}
+	return out
+}
+
+func cloneEdgeGroups(groups []*MeshOutline, remap func(int32) int32) []*MeshOutline {
+	out := make([]*MeshOutline, len(groups))
+	for i, g := range groups {
+		out[i] = g.withEdges(remap(g.Batchid), append([][2]uint32(nil), g.Edges...))
+	}
+	return out
+}
+
+func TransformNode(nd *MeshNode, mt *dmat.T, remap func(int32) int32) *MeshNode {
+	if remap == nil {
+		remap = func(b int32) int32 { return b }
+	}
+	out := &MeshNode{
+		Vertices:     make([]vec3.T, len(nd.Vertices)),
+		Normals:      make([]vec3.T, len(nd.Normals)),
+		Colors:       append([][3]byte(nil), nd.Colors...),
+		TexCoords:    append(nd.TexCoords[:0:0], nd.TexCoords...),
+		TexCoords2:   append(nd.TexCoords2[:0:0], nd.TexCoords2...),
+		Lightmap:     nd.Lightmap,
+		Mat:          nd.Mat,
+		FaceGroup:    cloneFaceGroups(nd.FaceGroup, remap),
+		EdgeGroup:    cloneEdgeGroups(nd.EdgeGroup, remap),
+		PolygonGroup: clonePolygonGroups(nd.PolygonGroup, remap),
+	}
+	for i, v := range nd.Vertices {
+		p := dvec3.T{float64(v[0]), float64(v[1]), float64(v[2])}
+		mt.TransformVec3(&p)
+		out.Vertices[i] = vec3.T{float32(p[0]), float32(p[1]), float32(p[2])}
+	}
+	nm := *mt
+	nm.Invert()
+	nm.Transpose()
+	for i, n := range nd.Normals {
+		p := dvec3.T{float64(n[0]), float64(n[1]), float64(n[2])}
+		nm.TransformVec3W(&p, 0)
+		p.Normalize()
+		out.Normals[i] = vec3.T{float32(p[0]), float32(p[1]), float32(p[2])}
+	}
+	if nd.Props != nil {
+		out.Props = make(Properties, len(nd.Props))
+		for k, v := range nd.Props {
+			out.Props[k] = v
+		}
+	}
+	out.MorphTargets = transformMorphTargets(nd.MorphTargets, mt)
+	out.Name = nd.Name
+	out.Children = append([]uint32(nil), nd.Children...)
+	return out
+}
+
+func (m *Mesh) mergeMaterials(mtls []MeshMaterial) func(int32) int32 {
+	remap := make([]int32, len(mtls))
+	for i, mtl := range mtls {
+		remap[i] = -1
+		for j, existing := range m.Materials {
+			if existing == mtl {
+				remap[i] = int32(j)
+				break
+			}
+		}
+		if remap[i] < 0 {
+			remap[i] = int32(len(m.Materials))
+			m.Materials = append(m.Materials, mtl)
+		}
+	}
+	return func(b int32) int32 {
+		if b < 0 || int(b) >= len(remap) {
+			return b
+		}
+		return remap[b]
+	}
+}
+
+func (m *Mesh) FlattenInstances() {
+	for i, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		remap := m.mergeMaterials(inst.Mesh.Materials)
+		for j, mt := range inst.Transfors {
+			base := len(m.Nodes)
+			for _, nd := range inst.Mesh.Nodes {
+				out := TransformNode(nd, mt, remap)
+				out.Children = shiftChildren(nd.Children, base)
+				if out.Props == nil {
+					out.Props = make(Properties, len(inst.Props)+3)
+				}
+				for k, v := range inst.Props {
+					out.Props[k] = v
+				}
+				out.Props[FLATTEN_PROPS_INSTANCE] = int64(i)
+				out.Props[FLATTEN_PROPS_TRANSFORM] = int64(j)
+				if j < len(inst.Features) {
+					out.Props[FLATTEN_PROPS_FEATURE] = int64(inst.Features[j])
+				}
+				m.Nodes = append(m.Nodes, out)
+			}
+		}
+	}
+	m.InstanceNode = nil
+}
@@ -0,0 +1,152 @@
+package mst
+
+import (
+	"fmt"
+	"math/rand"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+type GenerateOptions struct {
+	Seed         int64
+	Nodes        int
+	Subdivisions int
+	Materials    int
+	Textures     int
+	TextureSize  int
+	Instances    int
+	Transforms   int
+	Props        int
+}
+
+var DefaultGenerateOptions = GenerateOptions{
+	Seed:         1,
+	Nodes:        4,
+	Subdivisions: 8,
+	Materials:    2,
+	Textures:     1,
+	TextureSize:  64,
+	Instances:    1,
+	Transforms:   4,
+	Props:        4,
+}
+
+func generateTexture(r *rand.Rand, id, size int) *Texture {
+	raw := make([]byte, size*size*3)
+	r.Read(raw)
+	return &Texture{
+		Id:         int32(id),
+		Name:       fmt.Sprintf("texture_%d.png", id),
+		Size:       [2]uint64{uint64(size), uint64(size)},
+		Format:     TEXTURE_FORMAT_RGB,
+		Type:       TEXTURE_PIXEL_TYPE_UBYTE,
+		Compressed: TEXTURE_COMPRESSED_ZLIB,
+		Data:       CompressImage(raw),
+		Repeated:   true,
+	}
+}
+
+func generateMaterials(r *rand.Rand, opts *GenerateOptions) []MeshMaterial {
+	n := opts.Materials
+	if n < 1 {
+		n = 1
+	}
+	mtls := make([]MeshMaterial, n)
+	for i := range mtls {
+		base := BaseMaterial{Color: [3]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))}}
+		if i < opts.Textures {
+			mtls[i] = &PbrMaterial{
+				TextureMaterial: TextureMaterial{BaseMaterial: base, Texture: generateTexture(r, i, opts.TextureSize)},
+				Metallic:        r.Float32(),
+				Roughness:       r.Float32(),
+			}
+		} else {
+			mtls[i] = &base
+		}
+	}
+	return mtls
+}
+
+func generateNode(r *rand.Rand, sub int, batchid int32, origin vec3.T) *MeshNode {
+	if sub < 1 {
+		sub = 1
+	}
+	nd := &MeshNode{}
+	step := 1 / float32(sub)
+	for y := 0; y <= sub; y++ {
+		for x := 0; x <= sub; x++ {
+			u, v := float32(x)*step, float32(y)*step
+			nd.Vertices = append(nd.Vertices, vec3.T{origin[0] + u, origin[1] + v, origin[2] + r.Float32()*0.1})
+			nd.TexCoords = append(nd.TexCoords, vec2.T{u, v})
+		}
+	}
+	g := &MeshTriangle{Batchid: batchid}
+	row := uint32(sub + 1)
+	for y := uint32(0); y < uint32(sub); y++ {
+		for x := uint32(0); x < uint32(sub); x++ {
+			a := y*row + x
+			b, c, d := a+1, a+row, a+row+1
+			g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{a, b, d}}, &Face{Vertex: [3]uint32{a, d, c}})
+		}
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.ReComputeNormal()
+	return nd
+}
+
+func generateProps(r *rand.Rand, n int) Properties {
+	if n <= 0 {
+		return nil
+	}
+	props := make(Properties, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("prop_%d", i)
+		switch i % 4 {
+		case 0:
+			props[key] = fmt.Sprintf("value_%d", r.Intn(1000))
+		case 1:
+			props[key] = int64(r.Intn(1 << 20))
+		case 2:
+			props[key] = r.Float64()
+		case 3:
+			props[key] = r.Intn(2) == 1
+		}
+	}
+	return props
+}
+
+func GenerateMesh(opts *GenerateOptions) *Mesh {
+	if opts == nil {
+		opts = &DefaultGenerateOptions
+	}
+	r := rand.New(rand.NewSource(opts.Seed))
+	ms := NewMesh()
+	ms.Materials = generateMaterials(r, opts)
+	for i := 0; i < opts.Nodes; i++ {
+		batchid := int32(i % len(ms.Materials))
+		ms.Nodes = append(ms.Nodes, generateNode(r, opts.Subdivisions, batchid, vec3.T{float32(i), 0, 0}))
+	}
+	for i := 0; i < opts.Instances; i++ {
+		inst := &InstanceMesh{
+			Mesh: &BaseMesh{
+				Materials: []MeshMaterial{&BaseMaterial{Color: [3]byte{200, 200, 200}}},
+				Nodes:     []*MeshNode{generateNode(r, opts.Subdivisions, 0, vec3.T{})},
+			},
+			Hash:  r.Uint64(),
+			Props: generateProps(r, opts.Props),
+		}
+		for j := 0; j < opts.Transforms; j++ {
+			mt := dmat.Ident
+			mt.SetTranslation(&dvec3.T{r.Float64() * 100, r.Float64() * 100, 0})
+			inst.Transfors = append(inst.Transfors, &mt)
+			inst.Features = append(inst.Features, uint64(j))
+		}
+		inst.BBox = inst.Mesh.Nodes[0].GetBoundbox()
+		ms.InstanceNode = append(ms.InstanceNode, inst)
+	}
+	ms.Props = generateProps(r, opts.Props)
+	return ms
+}
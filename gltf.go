@@ -2,7 +2,10 @@ package mst
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"fmt"
+	"image"
 	"image/png"
 	"io"
 
@@ -97,27 +100,152 @@ func GetGltfBinary(doc *gltf.Document, paddingUnit int) ([]byte, error) {
 	return w.Bytes(), nil
 }
 
+const (
+	GLTF_PROPS_NODE = 0
+	GLTF_PROPS_MESH = 1
+	GLTF_PROPS_NONE = 2
+)
+
+type GltfExportOptions struct {
+	ExportOutline    bool
+	GpuInstance      bool
+	TextureLevels    []uint32
+	ContinueOnError  bool
+	PropsExtras      int
+	Quantization     uint8
+	ExternalTextures bool
+
+	MergeByMaterial   bool
+	MaxMergedVertices int
+
+	OutlineWithTriangles bool
+
+	Classification *Classification
+
+	AxisConvention uint8
+
+	Context  context.Context
+	Progress ProgressFunc
+	Logger   Logger
+}
+
+func (o *GltfExportOptions) progress() *progress {
+	return newProgress(o.Context, o.Progress)
+}
+
+func MstToGltfWithOptions(msts []*Mesh, opts *GltfExportOptions) (*gltf.Document, error) {
+	if opts == nil {
+		opts = &GltfExportOptions{GpuInstance: true}
+	}
+	doc := CreateDoc()
+	ec := newErrorCollector(opts.ContinueOnError, opts.Logger)
+	for i, mst := range msts {
+		err := BuildGltfWithOptions(doc, mst, opts)
+		if cerr := opts.progress().err(); cerr != nil {
+			return nil, cerr
+		}
+		if err := ec.report(fmt.Sprintf("meshes[%d]", i), err); err != nil {
+			return nil, err
+		}
+	}
+	return doc, ec.err()
+}
+
+func MstToGltfContext(ctx context.Context, msts []*Mesh, opts *GltfExportOptions) (*gltf.Document, error) {
+	o := GltfExportOptions{GpuInstance: true}
+	if opts != nil {
+		o = *opts
+	}
+	o.Context = ctx
+	return MstToGltfWithOptions(msts, &o)
+}
+
 func BuildGltf(doc *gltf.Document, mh *Mesh, exportOutline, gpu_instance bool) error {
-	err := buildGltf(doc, &mh.BaseMesh, nil, exportOutline, gpu_instance)
+	return BuildGltfWithOptions(doc, mh, &GltfExportOptions{ExportOutline: exportOutline, GpuInstance: gpu_instance})
+}
+
+func BuildGltfWithOptions(doc *gltf.Document, mh *Mesh, opts *GltfExportOptions) error {
+	if opts == nil {
+		opts = &GltfExportOptions{GpuInstance: true}
+	}
+	ec := newErrorCollector(opts.ContinueOnError, opts.Logger)
+	p := opts.progress()
+	if err := p.err(); err != nil {
+		return err
+	}
+	mh, err := mh.ConvertAxis(opts.AxisConvention, AXIS_CONVENTION_Y_UP)
 	if err != nil {
 		return err
 	}
-	for _, inst := range mh.InstanceNode {
-		buildGltf(doc, inst.Mesh, inst.Transfors, false, gpu_instance)
+	if opts.Classification != nil {
+		mh = mh.Clone(WithSharedTextures())
+		if err := mh.ApplyClassification(opts.Classification); err != nil {
+			return err
+		}
+	}
+	if opts.PropsExtras != GLTF_PROPS_NONE && len(mh.Props) > 0 {
+		sceneExtras(doc.Scenes[0], mh.Props)
+	}
+	animated := len(mh.Animations) > 0
+	nodeTargets := &gltfTargets{animated: animated}
+	base := &mh.BaseMesh
+	if opts.MergeByMaterial && !animated {
+		base = mergeByMaterial(base, true, opts.ExportOutline, opts)
+	}
+	if err := ec.report("", buildGltf(doc, base, nil, nil, opts.ExportOutline, nodeTargets, opts)); err != nil {
+		return err
+	}
+	total := 1 + len(mh.InstanceNode)
+	instTargets := make([]*gltfTargets, len(mh.InstanceNode))
+	for i, inst := range mh.InstanceNode {
+		if err := p.report(PROGRESS_STAGE_GLTF, 1+i, total); err != nil {
+			return err
+		}
+		field := fmt.Sprintf("instances[%d]", i)
+		if inst.Mesh == nil {
+			if err := ec.report(field, ErrUnresolvedInstanceRef); err != nil {
+				return err
+			}
+			continue
+		}
+		instTargets[i] = &gltfTargets{animated: animated}
+		proto := inst.Mesh
+		if opts.MergeByMaterial && !animated {
+			proto = mergeByMaterial(proto, false, false, opts)
+		}
+		if err := ec.report(field, buildGltf(doc, proto, inst.Transfors, inst.Props, false, instTargets[i], opts)); err != nil {
+			return err
+		}
+	}
+	if err := p.report(PROGRESS_STAGE_GLTF, total, total); err != nil {
+		return err
+	}
+	for i, anim := range mh.Animations {
+		if err := ec.report(fmt.Sprintf("animations[%d]", i), buildAnimation(doc, mh, anim, nodeTargets, instTargets, opts)); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return ec.err()
 }
 
 type buildContext struct {
 	mtlSize uint32
+	mtlEnd  uint32
+	lineMtl []*gltf.Material
+	lineDef *uint32
 	bvIndex uint32
 	bvPos   uint32
 	bvTex   uint32
 	bvNorm  uint32
+	bvTex2  uint32
+	bvFeat  uint32
+
+	features    []float32
+	nullFeature uint32
 }
 
-func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf.BufferView, nd *MeshNode) []*gltf.BufferView {
+func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf.BufferView, nd *MeshNode, quant *nodeQuantization) []*gltf.BufferView {
 	var bt []byte
 	buf := bytes.NewBuffer(bt)
 	ctx.bvIndex = uint32(len(bufferViews))
@@ -125,9 +253,8 @@ func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf
 	startLen := buffer.ByteLength
 	indecs.ByteOffset = startLen
 	for _, g := range nd.FaceGroup {
-		for _, f := range g.Faces {
-			binary.Write(buf, binary.LittleEndian, f.Vertex)
-		}
+		_, idx := g.PrimitiveIndices()
+		binary.Write(buf, binary.LittleEndian, idx)
 	}
 	indecs.ByteLength = uint32(buf.Len())
 	indecs.Buffer = 0
@@ -135,7 +262,12 @@ func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf
 
 	postions := &gltf.BufferView{}
 	postions.ByteOffset = uint32(buf.Len()) + startLen
-	binary.Write(buf, binary.LittleEndian, nd.Vertices)
+	if quant.flags&QUANTIZE_POSITION != 0 {
+		writeGltfPositions(buf, nd.Vertices, &quant.pos)
+		postions.ByteStride = 8
+	} else {
+		binary.Write(buf, binary.LittleEndian, nd.Vertices)
+	}
 	postions.ByteLength = uint32(buf.Len()) - postions.ByteOffset + startLen
 	postions.Buffer = 0
 	ctx.bvPos = uint32(len(bufferViews))
@@ -145,7 +277,11 @@ func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf
 	ctx.bvTex = uint32(len(bufferViews))
 	if len(nd.TexCoords) > 0 {
 		texcood.ByteOffset = uint32(buf.Len()) + startLen
-		binary.Write(buf, binary.LittleEndian, nd.TexCoords)
+		if quant.flags&QUANTIZE_TEXCOORD != 0 {
+			writeGltfTexCoords(buf, nd.TexCoords)
+		} else {
+			binary.Write(buf, binary.LittleEndian, nd.TexCoords)
+		}
 		texcood.ByteLength = uint32(buf.Len()) - texcood.ByteOffset + startLen
 		texcood.Buffer = 0
 		bufferViews = append(bufferViews, texcood)
@@ -155,11 +291,36 @@ func buildMeshBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*gltf
 	ctx.bvNorm = uint32(len(bufferViews))
 	if len(nd.Normals) > 0 {
 		normalView.ByteOffset = uint32(buf.Len()) + startLen
-		binary.Write(buf, binary.LittleEndian, nd.Normals)
+		if quant.flags&QUANTIZE_NORMAL != 0 {
+			writeGltfNormals(buf, nd.Normals)
+			normalView.ByteStride = 4
+		} else {
+			binary.Write(buf, binary.LittleEndian, nd.Normals)
+		}
 		normalView.ByteLength = uint32(buf.Len()) - normalView.ByteOffset + startLen
 		normalView.Buffer = 0
 		bufferViews = append(bufferViews, normalView)
 	}
+
+	ctx.bvTex2 = uint32(len(bufferViews))
+	if len(nd.TexCoords2) > 0 {
+		texcood2 := &gltf.BufferView{}
+		texcood2.ByteOffset = uint32(buf.Len()) + startLen
+		binary.Write(buf, binary.LittleEndian, nd.TexCoords2)
+		texcood2.ByteLength = uint32(buf.Len()) - texcood2.ByteOffset + startLen
+		texcood2.Buffer = 0
+		bufferViews = append(bufferViews, texcood2)
+	}
+
+	ctx.bvFeat = uint32(len(bufferViews))
+	if ctx.features != nil {
+		featView := &gltf.BufferView{}
+		featView.ByteOffset = uint32(buf.Len()) + startLen
+		binary.Write(buf, binary.LittleEndian, ctx.features)
+		featView.ByteLength = uint32(buf.Len()) - featView.ByteOffset + startLen
+		featView.Buffer = 0
+		bufferViews = append(bufferViews, featView)
+	}
 	buffer.ByteLength += uint32(buf.Len())
 	buffer.Data = append(buffer.Data, buf.Bytes()...)
 
@@ -174,7 +335,7 @@ func buildOutlineBuffer(ctx *buildContext, buffer *gltf.Buffer, bufferViews []*g
 	startLen := buffer.ByteLength
 	indecs.ByteOffset = startLen
 	for _, g := range nd.EdgeGroup {
-		for _, f := range g.Edges {
+		for _, f := range g.closedEdges() {
 			binary.Write(buf, binary.LittleEndian, f)
 		}
 	}
@@ -209,6 +370,10 @@ func buildOutline(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (
 			batchId = 0
 		}
 		mtl_id := uint32(batchId) + ctx.mtlSize
+		if patch.Color != nil {
+			mtl_id = ctx.mtlEnd + uint32(len(ctx.lineMtl))
+			ctx.lineMtl = append(ctx.lineMtl, outlineMaterial(patch.Color))
+		}
 
 		ps := &gltf.Primitive{}
 		ps.Material = &mtl_id
@@ -220,16 +385,18 @@ func buildOutline(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (
 
 		ps.Attributes["POSITION"] = indexPos
 
-		ps.Mode = gltf.PrimitiveLineStrip
+		ps.Mode = gltf.PrimitiveLines
+		ps.Extras = outlineExtras(patch)
 		mesh.Primitives = append(mesh.Primitives, ps)
 
+		edges := uint32(len(patch.closedEdges()))
 		indexacc := &gltf.Accessor{}
 		indexacc.ComponentType = gltf.ComponentUint
 
 		indexacc.ByteOffset = start * 8
-		indexacc.Count = uint32(len(patch.Edges)) * 2
+		indexacc.Count = edges * 2
 
-		start += uint32(len(patch.Edges))
+		start += edges
 		bfindex := ctx.bvIndex
 		indexacc.BufferView = &bfindex
 		accessors = append(accessors, indexacc)
@@ -249,7 +416,7 @@ func buildOutline(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (
 	return mesh, accessors
 }
 
-func buildMesh(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (*gltf.Mesh, []*gltf.Accessor) {
+func buildMesh(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode, quant *nodeQuantization) (*gltf.Mesh, []*gltf.Accessor) {
 	mesh := &gltf.Mesh{}
 	aftIndices := uint32(len(nd.FaceGroup))
 	idx := uint32(len(accessors))
@@ -282,14 +449,26 @@ func buildMesh(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (*gl
 			tmp++
 			ps.Attributes["NORMAL"] = tmp
 		}
-		ps.Mode = gltf.PrimitiveTriangles
+		if len(nd.TexCoords2) > 0 {
+			tmp++
+			ps.Attributes["TEXCOORD_1"] = tmp
+		}
+		if ctx.features != nil {
+			tmp++
+			ps.Attributes["_FEATURE_ID_0"] = tmp
+			if mf := patch.gltfFeatures(ctx.nullFeature); mf != nil {
+				ps.Extensions = gltf.Extensions{EXT_MESH_FEATURES: mf}
+			}
+		}
+		mode, indices := patch.PrimitiveIndices()
+		ps.Mode = gltfPrimitiveMode(mode)
 		mesh.Primitives = append(mesh.Primitives, ps)
 
 		indexacc := &gltf.Accessor{}
 		indexacc.ComponentType = gltf.ComponentUint
-		indexacc.ByteOffset = start * 12
-		indexacc.Count = uint32(len(patch.Faces)) * 3
-		start += uint32(len(patch.Faces))
+		indexacc.ByteOffset = start * 4
+		indexacc.Count = uint32(len(indices))
+		start += uint32(len(indices))
 		bfindex := ctx.bvIndex
 		indexacc.BufferView = &bfindex
 		accessors = append(accessors, indexacc)
@@ -302,14 +481,23 @@ func buildMesh(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (*gl
 
 	bvPos := ctx.bvPos
 	posacc.BufferView = &bvPos
-	box := nd.GetBoundbox()
-	posacc.Min = []float32{float32(box[0]), float32(box[1]), float32(box[2])}
-	posacc.Max = []float32{float32(box[3]), float32(box[4]), float32(box[5])}
+	if quant.flags&QUANTIZE_POSITION != 0 {
+		posacc.ComponentType = gltf.ComponentUshort
+		posacc.Min, posacc.Max = quantizedBounds(nd.Vertices, &quant.pos)
+	} else {
+		box := nd.GetBoundbox()
+		posacc.Min = []float32{float32(box[0]), float32(box[1]), float32(box[2])}
+		posacc.Max = []float32{float32(box[3]), float32(box[4]), float32(box[5])}
+	}
 	accessors = append(accessors, posacc)
 
 	if len(nd.TexCoords) > 0 {
 		texacc := &gltf.Accessor{}
 		texacc.ComponentType = gltf.ComponentFloat
+		if quant.flags&QUANTIZE_TEXCOORD != 0 {
+			texacc.ComponentType = gltf.ComponentUshort
+			texacc.Normalized = true
+		}
 		texacc.Type = gltf.AccessorVec2
 		texacc.Count = uint32(len(nd.TexCoords))
 		bvTex := ctx.bvTex
@@ -320,51 +508,175 @@ func buildMesh(ctx *buildContext, accessors []*gltf.Accessor, nd *MeshNode) (*gl
 	if len(nd.Normals) > 0 {
 		nlacc := &gltf.Accessor{}
 		nlacc.ComponentType = gltf.ComponentFloat
+		if quant.flags&QUANTIZE_NORMAL != 0 {
+			nlacc.ComponentType = gltf.ComponentByte
+			nlacc.Normalized = true
+		}
 		nlacc.Type = gltf.AccessorVec3
 		nlacc.Count = uint32(len(nd.Normals))
 		bvNorm := ctx.bvNorm
 		nlacc.BufferView = &bvNorm
 		accessors = append(accessors, nlacc)
 	}
+
+	if len(nd.TexCoords2) > 0 {
+		bvTex2 := ctx.bvTex2
+		accessors = append(accessors, &gltf.Accessor{ComponentType: gltf.ComponentFloat, Type: gltf.AccessorVec2, Count: uint32(len(nd.TexCoords2)), BufferView: &bvTex2})
+	}
+
+	if ctx.features != nil {
+		bvFeat := ctx.bvFeat
+		accessors = append(accessors, &gltf.Accessor{ComponentType: gltf.ComponentFloat, Type: gltf.AccessorScalar, Count: uint32(len(ctx.features)), BufferView: &bvFeat})
+	}
 	return mesh, accessors
 }
 
-func buildGltf(doc *gltf.Document, mh *BaseMesh, trans []*mat4d.T, exportOutline bool, gpu_instance bool) error {
+func sceneExtras(sc *gltf.Scene, props Properties) {
+	if extras, ok := sc.Extras.(map[string]interface{}); ok {
+		Properties(extras).Merge(props.Clone())
+		return
+	}
+	sc.Extras = map[string]interface{}(props.Clone())
+}
+
+func propsExtras(props Properties) interface{} {
+	if len(props) == 0 {
+		return nil
+	}
+	return map[string]interface{}(props)
+}
+
+func buildGltf(doc *gltf.Document, mh *BaseMesh, trans []*mat4d.T, instProps Properties, exportOutline bool, targets *gltfTargets, opts *GltfExportOptions) error {
+	ec := newErrorCollector(opts.ContinueOnError, opts.Logger)
+	if targets == nil {
+		targets = &gltfTargets{}
+	}
+	if trans == nil {
+		targets.slots = make([][]uint32, len(mh.Nodes))
+	} else {
+		targets.slots = make([][]uint32, len(trans))
+	}
 	ctx := &buildContext{}
 	ctx.mtlSize = uint32(len(doc.Materials))
+	ctx.mtlEnd = ctx.mtlSize + uint32(len(mh.Materials))
+	var parents []int
+	if trans == nil && hasChildren(mh.Nodes) {
+		if err := mh.ValidateHierarchy(); err != nil {
+			if err := ec.report("nodes", err); err != nil {
+				return err
+			}
+		} else {
+			parents = mh.Parents()
+		}
+	}
 
-	for _, mstNd := range mh.Nodes {
+	p := opts.progress()
+	for i, mstNd := range mh.Nodes {
+		if err := p.err(); err != nil {
+			return err
+		}
+		if err := mstNd.validateVertexIndices(); err != nil {
+			if err := ec.report(fmt.Sprintf("nodes[%d]", i), err); err != nil {
+				return err
+			}
+			continue
+		}
+		mstNd = mstNd.withPolygonFaces().withMaterialGroups()
+		ctx.features = nil
+		if fnd, values, null, err := mstNd.gltfFeatures(); err != nil {
+			if err := ec.report(fmt.Sprintf("nodes[%d]", i), err); err != nil {
+				return err
+			}
+		} else {
+			mstNd, ctx.features, ctx.nullFeature = fnd, values, null
+		}
+		var meshExtras, nodeExtras interface{}
+		switch {
+		case opts.PropsExtras == GLTF_PROPS_NONE:
+		case trans != nil:
+			meshExtras, nodeExtras = propsExtras(mstNd.Props), propsExtras(instProps)
+		case opts.PropsExtras == GLTF_PROPS_MESH:
+			meshExtras = propsExtras(mstNd.Props)
+		default:
+			nodeExtras = propsExtras(mstNd.Props)
+		}
+		if trans == nil && len(mstNd.FaceGroup) == 0 && len(mstNd.EdgeGroup) == 0 {
+			node := &gltf.Node{Name: mstNd.Name, Extras: nodeExtras}
+			if mt := localNodeMatrix(mh.Nodes, parents, i); mt != nil {
+				node.Matrix = gltfMatrix(mt)
+			}
+			targets.slots[i] = append(targets.slots[i], uint32(len(doc.Nodes)))
+			doc.Nodes = append(doc.Nodes, node)
+			continue
+		}
 		l := (uint32)(len(doc.Meshes))
-		if exportOutline && len(mstNd.EdgeGroup) > 0 {
+		outlineOnly := exportOutline && len(mstNd.EdgeGroup) > 0 && (!opts.OutlineWithTriangles || len(mstNd.FaceGroup) == 0)
+		quant := &nodeQuantization{}
+		if !outlineOnly {
+			quant = gltfQuantization(mstNd, opts, trans != nil && opts.GpuInstance || targets.animated || len(mstNd.Children) > 0)
+			if quant.flags != 0 {
+				addExtension(doc, KHR_MESH_QUANTIZATION, true)
+			}
+		}
+		if outlineOnly {
 			doc.BufferViews = buildOutlineBuffer(ctx, doc.Buffers[0], doc.BufferViews, mstNd)
 
 			var mesh *gltf.Mesh
 			mesh, doc.Accessors = buildOutline(ctx, doc.Accessors, mstNd)
+			mesh.Name = mstNd.Name
+			mesh.Extras = meshExtras
 			doc.Meshes = append(doc.Meshes, mesh)
 		} else {
-			doc.BufferViews = buildMeshBuffer(ctx, doc.Buffers[0], doc.BufferViews, mstNd)
+			if ctx.features != nil {
+				addExtension(doc, EXT_MESH_FEATURES, false)
+			}
+			doc.BufferViews = buildMeshBuffer(ctx, doc.Buffers[0], doc.BufferViews, mstNd, quant)
 
 			var mesh *gltf.Mesh
-			mesh, doc.Accessors = buildMesh(ctx, doc.Accessors, mstNd)
+			mesh, doc.Accessors = buildMesh(ctx, doc.Accessors, mstNd, quant)
+			mesh.Name = mstNd.Name
+			mesh.Extras = meshExtras
+			if exportOutline && len(mstNd.EdgeGroup) > 0 {
+				buildOutlinePrimitives(ctx, doc, mesh, mstNd)
+			}
+			buildMorphTargets(doc, mesh, mstNd)
 			doc.Meshes = append(doc.Meshes, mesh)
 		}
 
 		if trans == nil {
-			doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, uint32(len(doc.Nodes)))
-			node := &gltf.Node{}
+			node := &gltf.Node{Name: mstNd.Name, Extras: nodeExtras}
+			mt := localNodeMatrix(mh.Nodes, parents, i)
+			if quant.flags&QUANTIZE_POSITION != 0 {
+				if mt == nil {
+					mt = quant.pos.matrix()
+				} else {
+					mt = (&mat4d.T{}).AssignMul(mt, quant.pos.matrix())
+				}
+			}
+			if mt != nil {
+				node.Matrix = gltfMatrix(mt)
+			}
 			node.Mesh = &l
+			targets.slots[i] = append(targets.slots[i], uint32(len(doc.Nodes)))
 			doc.Nodes = append(doc.Nodes, node)
 		} else {
-			if gpu_instance {
-				buildInstance(doc, l, trans)
+			if opts.GpuInstance {
+				buildInstance(doc, l, trans, nodeExtras)
 			} else {
-				for _, mt := range trans {
+				for j, mt := range trans {
+					targets.slots[j] = append(targets.slots[j], uint32(len(doc.Nodes)))
+					if quant.flags&QUANTIZE_POSITION != 0 {
+						m := &mat4d.T{}
+						m.AssignMul(mt, quant.pos.matrix())
+						mt = m
+					}
 					position, quat, scale := mat4d.Decompose(mt)
 					nd := gltf.Node{
 						Mesh:        &l,
 						Translation: [3]float32{float32(position[0]), float32(position[1]), float32(position[2])},
 						Rotation:    [4]float32{float32(quat[0]), float32(quat[1]), float32(quat[2]), float32(quat[3])},
 						Scale:       [3]float32{float32(scale[0]), float32(scale[1]), float32(scale[2])},
+						Extras:      nodeExtras,
 					}
 					doc.Nodes = append(doc.Nodes, &nd)
 					doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, uint32(len(doc.Nodes)-1))
@@ -374,15 +686,53 @@ func buildGltf(doc *gltf.Document, mh *BaseMesh, trans []*mat4d.T, exportOutline
 
 	}
 
-	err := fillMaterials(doc, mh.Materials)
+	if trans == nil {
+		linkGltfNodes(doc, targets.slots, parents)
+	}
+
+	err := fillMaterials(doc, mh.Materials, opts, ec)
 	if err != nil {
 		return err
 	}
+	doc.Materials = append(doc.Materials, ctx.lineMtl...)
 
-	return nil
+	return ec.err()
+}
+
+func localNodeMatrix(nds []*MeshNode, parents []int, i int) *mat4d.T {
+	var parent *mat4d.T
+	if parents != nil && parents[i] >= 0 {
+		parent = nds[parents[i]].Mat
+	}
+	if nds[i].Mat == nil && parent == nil {
+		return nil
+	}
+	mt := mat4d.Ident
+	if nds[i].Mat != nil {
+		mt = *nds[i].Mat
+	}
+	if parent != nil {
+		inv := parent.Inverted()
+		return (&mat4d.T{}).AssignMul(&inv, &mt)
+	}
+	return &mt
+}
+
+func linkGltfNodes(doc *gltf.Document, slots [][]uint32, parents []int) {
+	for i, slot := range slots {
+		if len(slot) == 0 {
+			continue
+		}
+		if i < len(parents) && parents[i] >= 0 && len(slots[parents[i]]) > 0 {
+			parent := doc.Nodes[slots[parents[i]][0]]
+			parent.Children = append(parent.Children, slot[0])
+			continue
+		}
+		doc.Scenes[0].Nodes = append(doc.Scenes[0].Nodes, slot[0])
+	}
 }
 
-func buildInstance(doc *gltf.Document, l uint32, trans []*mat4d.T) {
+func buildInstance(doc *gltf.Document, l uint32, trans []*mat4d.T, extras interface{}) {
 	bvIdx := uint32(len(doc.BufferViews))
 	accInx := len(doc.Accessors)
 	buf := bytes.NewBuffer([]byte{})
@@ -422,7 +772,8 @@ func buildInstance(doc *gltf.Document, l uint32, trans []*mat4d.T) {
 		doc.Accessors = append(doc.Accessors, rotAcc)
 
 		nd := gltf.Node{
-			Mesh: &l,
+			Mesh:   &l,
+			Extras: extras,
 			Extensions: map[string]interface{}{"EXT_mesh_gpu_instancing": map[string]interface{}{
 				"attributes": map[string]interface{}{
 					"TRANSLATION": accInx,
@@ -445,21 +796,13 @@ func buildInstance(doc *gltf.Document, l uint32, trans []*mat4d.T) {
 	doc.Buffers[0].ByteLength += bv.ByteLength
 }
 
-func buildTextureBuffer(doc *gltf.Document, buffer *gltf.Buffer, texture *Texture) (*gltf.Texture, error) {
-	spCount := uint32(len(doc.Samplers))
+func appendImage(doc *gltf.Document, buffer *gltf.Buffer, img image.Image) uint32 {
 	imCount := uint32(len(doc.Images))
-
-	tx := &gltf.Texture{Sampler: &spCount, Source: &imCount}
-
 	gimg := &gltf.Image{}
 	gimg.MimeType = "image/png"
 	imgIndex := uint32(len(doc.BufferViews))
 	gimg.BufferView = &imgIndex
 
-	img, e := LoadTexture(texture, true)
-	if e != nil {
-		return nil, e
-	}
 	var bt []byte
 	buf := bytes.NewBuffer(bt)
 	png.Encode(buf, img)
@@ -473,6 +816,41 @@ func buildTextureBuffer(doc *gltf.Document, buffer *gltf.Buffer, texture *Textur
 
 	doc.BufferViews = append(doc.BufferViews, imgBuffView)
 	doc.Images = append(doc.Images, gimg)
+	return imCount
+}
+
+func buildTextureBuffer(doc *gltf.Document, buffer *gltf.Buffer, texture *Texture, colorData bool, opts *GltfExportOptions) (*gltf.Texture, error) {
+	spCount := uint32(len(doc.Samplers))
+
+	mismatch := texture.IsLinear(colorData) == colorData
+	var tx *gltf.Texture
+	if texture.URI != "" && (opts.ExternalTextures || len(texture.Data) == 0) {
+		if mismatch {
+			logWarn(opts.Logger, "external texture color space does not match glTF", "texture", texture.URI)
+		}
+		imCount := uint32(len(doc.Images))
+		doc.Images = append(doc.Images, &gltf.Image{Name: texture.Name, URI: texture.URI})
+		tx = &gltf.Texture{Sampler: &spCount, Source: &imCount}
+	} else if !texture.IsImage() {
+		extras, e := appendRawTexture(doc, buffer, texture)
+		if e != nil {
+			return nil, e
+		}
+		tx = &gltf.Texture{Sampler: &spCount, Extras: extras}
+	} else {
+		img, e := LoadTexture(texture, true)
+		if e != nil {
+			return nil, e
+		}
+		if mismatch {
+			img = convertImageColorSpace(img, !colorData)
+		}
+		imCount := appendImage(doc, buffer, img)
+		tx = &gltf.Texture{Sampler: &spCount, Source: &imCount}
+		if len(opts.TextureLevels) > 0 {
+			tx.Extras = buildTextureLevels(doc, buffer, img, imCount, opts.TextureLevels)
+		}
+	}
 
 	var sp *gltf.Sampler
 	if texture.Repeated {
@@ -480,21 +858,25 @@ func buildTextureBuffer(doc *gltf.Document, buffer *gltf.Buffer, texture *Textur
 	} else {
 		sp = &gltf.Sampler{WrapS: gltf.WrapClampToEdge, WrapT: gltf.WrapClampToEdge}
 	}
+	if isLookupTexture(texture) || !texture.IsImage() {
+		sp.MagFilter, sp.MinFilter = gltf.MagNearest, gltf.MinNearest
+	}
 	doc.Samplers = append(doc.Samplers, sp)
 
 	return tx, nil
 }
 
-func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
+func fillMaterials(doc *gltf.Document, mts []MeshMaterial, opts *GltfExportOptions, ec *errorCollector) error {
 	texMap := make(map[int32]uint32)
 	useExtension := false
 	for i := range mts {
-		mtl := mts[i]
+		mtl := resolveMaterial(mts[i])
 
-		gm := &gltf.Material{DoubleSided: true, AlphaMode: gltf.AlphaMask}
+		gm := &gltf.Material{Name: MaterialName(mtl), DoubleSided: true, AlphaMode: gltf.AlphaMask}
 		gm.PBRMetallicRoughness = &gltf.PBRMetallicRoughness{BaseColorFactor: &[4]float32{1, 1, 1, 1}}
 		gm.Extensions = make(map[string]interface{})
 		var texMtl *TextureMaterial
+		var spmtl *specular.PBRSpecularGlossiness
 		var cl *[4]float32
 		switch ml := mtl.(type) {
 		case *BaseMaterial:
@@ -513,7 +895,7 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 			cl = &[4]float32{float32(ml.Color[0]) / 255, float32(ml.Color[1]) / 255, float32(ml.Color[2]) / 255, 1 - float32(ml.Transparency)}
 			texMtl = &ml.TextureMaterial
 
-			spmtl := &specular.PBRSpecularGlossiness{
+			spmtl = &specular.PBRSpecularGlossiness{
 				DiffuseFactor: &[4]float32{float32(ml.Diffuse[0]) / 255, float32(ml.Diffuse[1]) / 255, float32(ml.Diffuse[2]) / 255, 1},
 			}
 
@@ -527,7 +909,7 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 			cl = &[4]float32{float32(ml.Color[0]) / 255, float32(ml.Color[1]) / 255, float32(ml.Color[2]) / 255, 1 - float32(ml.Transparency)}
 			texMtl = &ml.TextureMaterial
 
-			spmtl := &specular.PBRSpecularGlossiness{
+			spmtl = &specular.PBRSpecularGlossiness{
 				DiffuseFactor:    &[4]float32{float32(ml.Diffuse[0]) / 255, float32(ml.Diffuse[1]) / 255, float32(ml.Diffuse[2]) / 255, 1},
 				SpecularFactor:   &[3]float32{float32(ml.Specular[0]) / 255, float32(ml.Specular[1]) / 255, float32(ml.Specular[2]) / 255},
 				GlossinessFactor: &ml.Shininess,
@@ -542,6 +924,17 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 		case *TextureMaterial:
 			texMtl = ml
 			cl = &[4]float32{float32(ml.Color[0]) / 255, float32(ml.Color[1]) / 255, float32(ml.Color[2]) / 255, 1 - float32(ml.Transparency)}
+		default:
+			if mtl != nil {
+				c := mtl.GetColor()
+				gm.PBRMetallicRoughness.BaseColorFactor = &[4]float32{float32(c[0]) / 255, float32(c[1]) / 255, float32(c[2]) / 255, 1}
+			}
+			if err := fillCustomMaterial(doc, mtl, gm); err != nil {
+				if err := ec.report(fmt.Sprintf("materials[%d]", i), err); err != nil {
+					return err
+				}
+			}
+			cl = gm.PBRMetallicRoughness.BaseColorFactor
 		}
 
 		if texMtl != nil && texMtl.HasTexture() {
@@ -549,15 +942,17 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 				gm.PBRMetallicRoughness.BaseColorTexture = &gltf.TextureInfo{Index: idx}
 			} else {
 				texIndex := uint32(len(doc.Textures))
-				texMap[texMtl.Texture.Id] = texIndex
-				tex, err := buildTextureBuffer(doc, doc.Buffers[0], texMtl.Texture)
+				tex, err := buildTextureBuffer(doc, doc.Buffers[0], texMtl.Texture, true, opts)
 
 				if err != nil {
-					return err
+					if err := ec.report(fmt.Sprintf("materials[%d].texture", i), err); err != nil {
+						return err
+					}
+				} else {
+					texMap[texMtl.Texture.Id] = texIndex
+					gm.PBRMetallicRoughness.BaseColorTexture = &gltf.TextureInfo{Index: texIndex}
+					doc.Textures = append(doc.Textures, tex)
 				}
-
-				gm.PBRMetallicRoughness.BaseColorTexture = &gltf.TextureInfo{Index: texIndex}
-				doc.Textures = append(doc.Textures, tex)
 			}
 		}
 
@@ -566,17 +961,31 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 				gm.NormalTexture = &gltf.NormalTexture{Index: &idx}
 			} else {
 				normalTexIndex := uint32(len(doc.Textures))
-				texMap[texMtl.Normal.Id] = normalTexIndex
-				tex, err := buildTextureBuffer(doc, doc.Buffers[0], texMtl.Normal)
+				tex, err := buildTextureBuffer(doc, doc.Buffers[0], texMtl.Normal, false, opts)
 
 				if err != nil {
-					return err
+					if err := ec.report(fmt.Sprintf("materials[%d].normal", i), err); err != nil {
+						return err
+					}
+				} else {
+					texMap[texMtl.Normal.Id] = normalTexIndex
+					gm.NormalTexture = &gltf.NormalTexture{Index: &normalTexIndex}
+					doc.Textures = append(doc.Textures, tex)
 				}
-				gm.NormalTexture = &gltf.NormalTexture{Index: &normalTexIndex}
-				doc.Textures = append(doc.Textures, tex)
 			}
 		}
 
+		if texMtl != nil && gm.PBRMetallicRoughness.BaseColorTexture != nil {
+			gm.PBRMetallicRoughness.BaseColorTexture.TexCoord = uint32(texMtl.TexCoord)
+			if spmtl != nil {
+				info := *gm.PBRMetallicRoughness.BaseColorTexture
+				spmtl.DiffuseTexture = &info
+			}
+		}
+		if texMtl != nil && gm.NormalTexture != nil {
+			gm.NormalTexture.TexCoord = uint32(texMtl.NormalTexCoord)
+		}
+
 		gm.PBRMetallicRoughness.BaseColorFactor = cl
 
 		if gm.PBRMetallicRoughness.MetallicFactor == nil {
@@ -592,16 +1001,25 @@ func fillMaterials(doc *gltf.Document, mts []MeshMaterial) error {
 		doc.Materials = append(doc.Materials, gm)
 	}
 	if useExtension {
-		has := false
-		for _, nm := range doc.ExtensionsUsed {
-			if nm == specular.ExtensionName {
-				has = true
-				break
-			}
-		}
-		if !has {
-			doc.ExtensionsUsed = append(doc.ExtensionsUsed, specular.ExtensionName)
-		}
+		addExtension(doc, specular.ExtensionName, false)
 	}
 	return nil
 }
+
+func hasString(names []string, name string) bool {
+	for _, nm := range names {
+		if nm == name {
+			return true
+		}
+	}
+	return false
+}
+
+func addExtension(doc *gltf.Document, name string, required bool) {
+	if !hasString(doc.ExtensionsUsed, name) {
+		doc.ExtensionsUsed = append(doc.ExtensionsUsed, name)
+	}
+	if required && !hasString(doc.ExtensionsRequired, name) {
+		doc.ExtensionsRequired = append(doc.ExtensionsRequired, name)
+	}
+}
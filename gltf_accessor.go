@@ -0,0 +1,203 @@
+package mst
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/qmuntal/gltf"
+)
+
+const maxGltfViewlessElements = 1 << 24
+
+func gltfViewData(doc *gltf.Document, view, offset uint32, count, elemSize int) ([]byte, int, error) {
+	if int(view) >= len(doc.BufferViews) {
+		return nil, 0, &IndexError{Kind: "glTF buffer view", Index: view, Count: len(doc.BufferViews)}
+	}
+	bv := doc.BufferViews[view]
+	if int(bv.Buffer) >= len(doc.Buffers) {
+		return nil, 0, &IndexError{Kind: "glTF buffer", Index: bv.Buffer, Count: len(doc.Buffers)}
+	}
+	buf := doc.Buffers[bv.Buffer].Data
+	if uint64(bv.ByteOffset)+uint64(bv.ByteLength) > uint64(len(buf)) {
+		return nil, 0, fmt.Errorf("mst: glTF buffer view %d exceeds buffer %d", view, bv.Buffer)
+	}
+	data := buf[bv.ByteOffset : bv.ByteOffset+bv.ByteLength]
+	stride := int(bv.ByteStride)
+	if stride == 0 {
+		stride = elemSize
+	}
+	if stride < elemSize {
+		return nil, 0, fmt.Errorf("mst: glTF buffer view %d stride %d is smaller than element size %d", view, stride, elemSize)
+	}
+	if uint64(offset) > uint64(len(data)) {
+		return nil, 0, fmt.Errorf("mst: glTF accessor offset %d exceeds buffer view %d", offset, view)
+	}
+	if count > 0 && uint64(offset)+uint64(count-1)*uint64(stride)+uint64(elemSize) > uint64(len(data)) {
+		return nil, 0, fmt.Errorf("mst: glTF accessor of %d elements exceeds buffer view %d", count, view)
+	}
+	return data[offset:], stride, nil
+}
+
+func gltfComponent(b []byte, ct gltf.ComponentType, normalized bool) float64 {
+	switch ct {
+	case gltf.ComponentByte:
+		v := float64(int8(b[0]))
+		if normalized {
+			return math.Max(v/127, -1)
+		}
+		return v
+	case gltf.ComponentUbyte:
+		if normalized {
+			return float64(b[0]) / 255
+		}
+		return float64(b[0])
+	case gltf.ComponentShort:
+		v := float64(int16(binary.LittleEndian.Uint16(b)))
+		if normalized {
+			return math.Max(v/32767, -1)
+		}
+		return v
+	case gltf.ComponentUshort:
+		v := float64(binary.LittleEndian.Uint16(b))
+		if normalized {
+			return v / 65535
+		}
+		return v
+	case gltf.ComponentUint:
+		return float64(binary.LittleEndian.Uint32(b))
+	default:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+}
+
+func readGltfElements(doc *gltf.Document, view, offset uint32, ct gltf.ComponentType, comps, count int, normalized bool) ([]float64, error) {
+	size := int(ct.ByteSize())
+	data, stride, err := gltfViewData(doc, view, offset, count, size*comps)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]float64, count*comps)
+	for i := 0; i < count; i++ {
+		elem := data[i*stride:]
+		for c := 0; c < comps; c++ {
+			out[i*comps+c] = gltfComponent(elem[c*size:], ct, normalized)
+		}
+	}
+	return out, nil
+}
+
+func readGltfAccessor(doc *gltf.Document, acc *gltf.Accessor) ([]float64, int, error) {
+	comps := int(acc.Type.Components())
+	if comps == 0 || acc.ComponentType.ByteSize() == 0 {
+		return nil, 0, fmt.Errorf("mst: unsupported glTF accessor type %v/%v", acc.Type, acc.ComponentType)
+	}
+	if acc.BufferView == nil && acc.Count > maxGltfViewlessElements {
+		return nil, 0, fmt.Errorf("mst: glTF accessor without buffer view has %d elements", acc.Count)
+	}
+	var out []float64
+	if acc.BufferView != nil {
+		var err error
+		if out, err = readGltfElements(doc, *acc.BufferView, acc.ByteOffset, acc.ComponentType, comps, int(acc.Count), acc.Normalized); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		out = make([]float64, int(acc.Count)*comps)
+	}
+	if sp := acc.Sparse; sp != nil {
+		if sp.Count > acc.Count {
+			return nil, 0, fmt.Errorf("mst: glTF sparse accessor has %d elements for %d", sp.Count, acc.Count)
+		}
+		switch sp.Indices.ComponentType {
+		case gltf.ComponentUbyte, gltf.ComponentUshort, gltf.ComponentUint:
+		default:
+			return nil, 0, fmt.Errorf("mst: invalid glTF sparse index type %v", sp.Indices.ComponentType)
+		}
+		indices, err := readGltfElements(doc, sp.Indices.BufferView, sp.Indices.ByteOffset, sp.Indices.ComponentType, 1, int(sp.Count), false)
+		if err != nil {
+			return nil, 0, err
+		}
+		values, err := readGltfElements(doc, sp.Values.BufferView, sp.Values.ByteOffset, acc.ComponentType, comps, int(sp.Count), acc.Normalized)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i, idx := range indices {
+			if idx >= float64(acc.Count) {
+				return nil, 0, &IndexError{Kind: "glTF sparse element", Index: uint32(idx), Count: int(acc.Count)}
+			}
+			copy(out[int(idx)*comps:int(idx+1)*comps], values[i*comps:(i+1)*comps])
+		}
+	}
+	return out, comps, nil
+}
+
+func readGltfFloats(doc *gltf.Document, acc *gltf.Accessor, comps int) ([]float32, error) {
+	data, n, err := readGltfAccessor(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	if n != comps {
+		return nil, fmt.Errorf("mst: glTF accessor has %d components, expected %d", n, comps)
+	}
+	out := make([]float32, len(data))
+	for i, v := range data {
+		out[i] = float32(v)
+	}
+	return out, nil
+}
+
+func readGltfVec2(doc *gltf.Document, acc *gltf.Accessor) ([][2]float32, error) {
+	fs, err := readGltfFloats(doc, acc, 2)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][2]float32, len(fs)/2)
+	for i := range out {
+		copy(out[i][:], fs[i*2:])
+	}
+	return out, nil
+}
+
+func readGltfVec3(doc *gltf.Document, acc *gltf.Accessor) ([][3]float32, error) {
+	fs, err := readGltfFloats(doc, acc, 3)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][3]float32, len(fs)/3)
+	for i := range out {
+		copy(out[i][:], fs[i*3:])
+	}
+	return out, nil
+}
+
+func readGltfVec4(doc *gltf.Document, acc *gltf.Accessor) ([][4]float32, error) {
+	fs, err := readGltfFloats(doc, acc, 4)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][4]float32, len(fs)/4)
+	for i := range out {
+		copy(out[i][:], fs[i*4:])
+	}
+	return out, nil
+}
+
+func readGltfIndices(doc *gltf.Document, acc *gltf.Accessor) ([]uint32, error) {
+	switch acc.ComponentType {
+	case gltf.ComponentUbyte, gltf.ComponentUshort, gltf.ComponentUint:
+	default:
+		return nil, fmt.Errorf("mst: invalid glTF index component type %v", acc.ComponentType)
+	}
+	if acc.Type != gltf.AccessorScalar || acc.Normalized {
+		return nil, fmt.Errorf("mst: glTF indices must be unnormalized scalars")
+	}
+	data, _, err := readGltfAccessor(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint32, len(data))
+	for i, v := range data {
+		out[i] = uint32(v)
+	}
+	return out, nil
+}
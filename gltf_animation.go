@@ -0,0 +1,192 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+var errGpuInstanceAnimation = errors.New("mst: instance transforms cannot be animated with GPU instancing")
+
+type gltfTargets struct {
+	animated bool
+	slots    [][]uint32
+}
+
+type gltfAnimTarget struct {
+	targetType uint8
+	target     uint32
+	transform  uint32
+}
+
+var gltfPaths = map[uint8]gltf.TRSProperty{
+	ANIMATION_PATH_TRANSLATION: gltf.TRSTranslation,
+	ANIMATION_PATH_ROTATION:    gltf.TRSRotation,
+	ANIMATION_PATH_SCALE:       gltf.TRSScale,
+}
+
+func buildAnimation(doc *gltf.Document, mh *Mesh, anim *Animation, nodes *gltfTargets, insts []*gltfTargets, opts *GltfExportOptions) error {
+	ga := &gltf.Animation{Name: anim.Name}
+	for i, t := range anim.Tracks {
+		field := fmt.Sprintf("tracks[%d]", i)
+		if err := t.validate(mh); err != nil {
+			return &AssetError{Field: field, Err: err}
+		}
+		var targets []uint32
+		if t.TargetType == ANIMATION_TARGET_NODE {
+			targets = nodes.slots[t.Target]
+		} else {
+			if opts.GpuInstance {
+				return &AssetError{Field: field, Err: errGpuInstanceAnimation}
+			}
+			if insts[t.Target] == nil {
+				return &AssetError{Field: field, Err: ErrUnresolvedInstanceRef}
+			}
+			targets = insts[t.Target].slots[t.Transform]
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		input := modeler.WriteAccessor(doc, gltf.TargetNone, t.Times)
+		doc.Accessors[input].Min = []float32{t.Times[0]}
+		doc.Accessors[input].Max = []float32{t.Times[len(t.Times)-1]}
+		var output uint32
+		if t.Components() == 4 {
+			vs := make([][4]float32, len(t.Times))
+			for k := range vs {
+				copy(vs[k][:], t.Values[4*k:])
+			}
+			output = modeler.WriteAccessor(doc, gltf.TargetNone, vs)
+		} else {
+			vs := make([][3]float32, len(t.Times))
+			for k := range vs {
+				copy(vs[k][:], t.Values[3*k:])
+			}
+			output = modeler.WriteAccessor(doc, gltf.TargetNone, vs)
+		}
+		sampler := &gltf.AnimationSampler{Input: gltf.Index(input), Output: gltf.Index(output), Interpolation: gltf.InterpolationLinear}
+		if t.Interpolation == ANIMATION_INTERPOLATION_STEP {
+			sampler.Interpolation = gltf.InterpolationStep
+		}
+		si := uint32(len(ga.Samplers))
+		ga.Samplers = append(ga.Samplers, sampler)
+		for _, nd := range targets {
+			ga.Channels = append(ga.Channels, &gltf.Channel{Sampler: gltf.Index(si), Target: gltf.ChannelTarget{Node: gltf.Index(nd), Path: gltfPaths[t.Path]}})
+		}
+	}
+	if len(ga.Channels) > 0 {
+		doc.Animations = append(doc.Animations, ga)
+	}
+	return nil
+}
+
+func (imp *gltfImporter) animationTrack(s *gltf.AnimationSampler, path gltf.TRSProperty) (*AnimationTrack, error) {
+	if s.Input == nil || s.Output == nil {
+		return nil, fmt.Errorf("mst: glTF animation sampler without input or output")
+	}
+	in, err := imp.accessor(*s.Input)
+	if err != nil {
+		return nil, err
+	}
+	out, err := imp.accessor(*s.Output)
+	if err != nil {
+		return nil, err
+	}
+	if in.ComponentType != gltf.ComponentFloat || in.Type != gltf.AccessorScalar {
+		return nil, fmt.Errorf("mst: glTF animation input must be float scalars")
+	}
+	times, err := readGltfFloats(imp.doc, in, 1)
+	if err != nil {
+		return nil, err
+	}
+	t := &AnimationTrack{Times: times}
+	for p, gp := range gltfPaths {
+		if gp == path {
+			t.Path = p
+		}
+	}
+	if s.Interpolation == gltf.InterpolationStep {
+		t.Interpolation = ANIMATION_INTERPOLATION_STEP
+	}
+	var values [][]float32
+	if path == gltf.TRSRotation {
+		vs, err := readGltfVec4(imp.doc, out)
+		if err != nil {
+			return nil, err
+		}
+		for i := range vs {
+			values = append(values, vs[i][:])
+		}
+	} else {
+		vs, err := readGltfVec3(imp.doc, out)
+		if err != nil {
+			return nil, err
+		}
+		for i := range vs {
+			values = append(values, vs[i][:])
+		}
+	}
+	stride := 1
+	if s.Interpolation == gltf.InterpolationCubicSpline {
+		stride = 3
+	}
+	if len(values) != len(times)*stride {
+		return nil, fmt.Errorf("mst: glTF animation has %d outputs for %d keyframes", len(values), len(times))
+	}
+	for i := range times {
+		t.Values = append(t.Values, values[i*stride+stride/2]...)
+	}
+	return t, nil
+}
+
+func (imp *gltfImporter) animations() error {
+	for i, ga := range imp.doc.Animations {
+		anim := &Animation{Name: ga.Name}
+		seen := make(map[gltfAnimTarget]map[gltf.TRSProperty]bool)
+		for j, ch := range ga.Channels {
+			field := fmt.Sprintf("animations[%d].channels[%d]", i, j)
+			if ch.Target.Node == nil || ch.Sampler == nil {
+				logWarn(imp.opts.Logger, "skipped glTF animation channel without target", "field", field)
+				continue
+			}
+			if ch.Target.Path == gltf.TRSWeights {
+				logWarn(imp.opts.Logger, "skipped glTF morph weight animation", "field", field)
+				continue
+			}
+			tgt, ok := imp.animTargets[*ch.Target.Node]
+			if !ok {
+				logWarn(imp.opts.Logger, "skipped glTF animation channel for unimported node", "field", field, "node", *ch.Target.Node)
+				continue
+			}
+			if seen[tgt][ch.Target.Path] {
+				logWarn(imp.opts.Logger, "skipped duplicate glTF animation channel", "field", field)
+				continue
+			}
+			if int(*ch.Sampler) >= len(ga.Samplers) {
+				if err := imp.ec.report(field, fmt.Errorf("mst: glTF animation sampler %d out of range", *ch.Sampler)); err != nil {
+					return err
+				}
+				continue
+			}
+			t, err := imp.animationTrack(ga.Samplers[*ch.Sampler], ch.Target.Path)
+			if err := imp.ec.report(field, err); err != nil {
+				return err
+			}
+			if t == nil {
+				continue
+			}
+			if seen[tgt] == nil {
+				seen[tgt] = make(map[gltf.TRSProperty]bool)
+			}
+			seen[tgt][ch.Target.Path] = true
+			t.TargetType, t.Target, t.Transform = tgt.targetType, tgt.target, tgt.transform
+			anim.Tracks = append(anim.Tracks, t)
+		}
+		if len(anim.Tracks) > 0 {
+			imp.ms.Animations = append(imp.ms.Animations, anim)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package mst
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/qmuntal/gltf"
+)
+
+const EXT_MESH_FEATURES = "EXT_mesh_features"
+
+const GLTF_MAX_FEATURE_ID = 1 << 24
+
+type MeshFeatureID struct {
+	FeatureCount  uint32  `json:"featureCount"`
+	NullFeatureID *uint32 `json:"nullFeatureId,omitempty"`
+	Attribute     *uint32 `json:"attribute,omitempty"`
+}
+
+type MeshFeatures struct {
+	FeatureIDs []MeshFeatureID `json:"featureIds"`
+}
+
+func (n *MeshNode) vertexFeatureValues(null uint32) ([]float32, bool) {
+	values := make([]float32, len(n.Vertices))
+	set := make([]bool, len(n.Vertices))
+	for _, g := range n.FaceGroup {
+		for i, f := range g.Faces {
+			v := null
+			if id, ok := g.FaceFeature(i); ok {
+				v = uint32(id)
+			}
+			for _, idx := range f.Vertex {
+				if set[idx] && values[idx] != float32(v) {
+					return nil, false
+				}
+				values[idx], set[idx] = float32(v), true
+			}
+		}
+	}
+	return values, true
+}
+
+func (n *MeshNode) gltfFeatures() (*MeshNode, []float32, uint32, error) {
+	var null uint32
+	has := false
+	for _, g := range n.FaceGroup {
+		for i := range g.Faces {
+			id, ok := g.FaceFeature(i)
+			if !ok {
+				continue
+			}
+			if id >= GLTF_MAX_FEATURE_ID-1 {
+				return nil, nil, 0, fmt.Errorf("mst: feature id %d exceeds glTF feature id range", id)
+			}
+			if uint32(id) >= null {
+				null = uint32(id) + 1
+			}
+			has = true
+		}
+	}
+	if !has {
+		return n, nil, 0, nil
+	}
+	if values, ok := n.vertexFeatureValues(null); ok {
+		return n, values, null, nil
+	}
+	if len(n.MorphTargets) > 0 {
+		return nil, nil, 0, fmt.Errorf("mst: face features split shared vertices of a morphed node")
+	}
+	cp := *n
+	cp.FaceGroup = make([]*MeshTriangle, len(n.FaceGroup))
+	for j, g := range n.FaceGroup {
+		cg := *g
+		cg.Faces = make([]*Face, len(g.Faces))
+		for k, f := range g.Faces {
+			cf := *f
+			cg.Faces[k] = &cf
+		}
+		cp.FaceGroup[j] = &cg
+	}
+	cp.IndexingMode = INDEXING_MODE_SEPARATE
+	cp.ResortVtVn(nil)
+	values, _ := cp.vertexFeatureValues(null)
+	return &cp, values, null, nil
+}
+
+func (g *MeshTriangle) gltfFeatures(null uint32) *MeshFeatures {
+	seen := make(map[uint64]bool)
+	hasNull := false
+	for i := range g.Faces {
+		if id, ok := g.FaceFeature(i); ok {
+			seen[id] = true
+		} else {
+			hasNull = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+	attr := uint32(0)
+	fid := MeshFeatureID{FeatureCount: uint32(len(seen)), Attribute: &attr}
+	if hasNull {
+		fid.NullFeatureID = &null
+	}
+	return &MeshFeatures{FeatureIDs: []MeshFeatureID{fid}}
+}
+
+func meshFeaturesExtension(ext interface{}) (*MeshFeatures, error) {
+	if mf, ok := ext.(*MeshFeatures); ok {
+		return mf, nil
+	}
+	raw, ok := ext.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(ext); err != nil {
+			return nil, err
+		}
+	}
+	mf := &MeshFeatures{}
+	if err := json.Unmarshal(raw, mf); err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+func (imp *gltfImporter) faceFeatures(p *gltf.Primitive, g *MeshTriangle, base uint32) error {
+	ext, ok := p.Extensions[EXT_MESH_FEATURES]
+	if !ok {
+		return nil
+	}
+	mf, err := meshFeaturesExtension(ext)
+	if err != nil {
+		return err
+	}
+	for _, fid := range mf.FeatureIDs {
+		if fid.Attribute == nil {
+			continue
+		}
+		idx, ok := p.Attributes[fmt.Sprintf("_FEATURE_ID_%d", *fid.Attribute)]
+		if !ok {
+			return fmt.Errorf("mst: glTF primitive without feature id attribute %d", *fid.Attribute)
+		}
+		acc, err := imp.accessor(idx)
+		if err != nil {
+			return err
+		}
+		values, err := readGltfFloats(imp.doc, acc, 1)
+		if err != nil {
+			return err
+		}
+		out := &MeshTriangle{Batchid: g.Batchid}
+		for _, f := range g.Faces {
+			v := f.Vertex[0] - base
+			if int(v) >= len(values) {
+				return &IndexError{Kind: "feature id", Index: v, Count: len(values)}
+			}
+			src := &MeshTriangle{}
+			if id := uint64(values[v]); fid.NullFeatureID == nil || id != uint64(*fid.NullFeatureID) {
+				src.FeatureID = &id
+			}
+			out.addFace(f, src, 0)
+		}
+		g.FeatureID, g.Features = out.FeatureID, out.Features
+		return nil
+	}
+	return nil
+}
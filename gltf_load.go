@@ -0,0 +1,113 @@
+package mst
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qmuntal/gltf"
+)
+
+func decodeDataURI(uri string) ([]byte, error) {
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 {
+		return nil, fmt.Errorf("mst: malformed data URI")
+	}
+	header, payload := uri[len("data:"):comma], uri[comma+1:]
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	s, err := url.PathUnescape(payload)
+	return []byte(s), err
+}
+
+func readGltfResource(dir, uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		return decodeDataURI(uri)
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("mst: glTF resource %q is external and no base directory is set", uri)
+	}
+	p, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+	full, err := localResourcePath(dir, p)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(full)
+}
+
+type gltfResourceHandler struct {
+	dir string
+}
+
+func (h *gltfResourceHandler) ReadFullResource(uri string, data []byte) error {
+	bt, err := readGltfResource(h.dir, uri)
+	if err != nil {
+		return err
+	}
+	if len(bt) < len(data) {
+		return io.ErrUnexpectedEOF
+	}
+	copy(data, bt)
+	return nil
+}
+
+func loadGltfBuffers(doc *gltf.Document, dir string) (*gltf.Document, error) {
+	out := doc
+	for i, b := range doc.Buffers {
+		if len(b.Data) > 0 || b.ByteLength == 0 || b.URI == "" {
+			continue
+		}
+		data, err := readGltfResource(dir, b.URI)
+		if err != nil {
+			return nil, &AssetError{Field: fmt.Sprintf("buffers[%d]", i), Err: err}
+		}
+		if len(data) < int(b.ByteLength) {
+			return nil, &AssetError{Field: fmt.Sprintf("buffers[%d]", i), Err: io.ErrUnexpectedEOF}
+		}
+		if out == doc {
+			cp := *doc
+			cp.Buffers = append([]*gltf.Buffer(nil), doc.Buffers...)
+			out = &cp
+		}
+		nb := *b
+		nb.Data = data[:b.ByteLength]
+		out.Buffers[i] = &nb
+	}
+	return out, nil
+}
+
+func GltfToMstFromReader(rd io.Reader, opts *GltfImportOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &GltfImportOptions{}
+	}
+	doc := new(gltf.Document)
+	if err := gltf.NewDecoder(rd).WithReadHandler(&gltfResourceHandler{dir: opts.BaseDir}).Decode(doc); err != nil {
+		return nil, err
+	}
+	return GltfToMstWithOptions(doc, opts)
+}
+
+func GltfToMstFromFile(path string, opts *GltfImportOptions) (*Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	o := GltfImportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BaseDir == "" {
+		o.BaseDir = filepath.Dir(path)
+	}
+	return GltfToMstFromReader(f, &o)
+}
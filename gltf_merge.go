@@ -0,0 +1,121 @@
+package mst
+
+const GLTF_MERGE_MAX_VERTICES = 65535
+
+type mergeLayout struct {
+	batchid    int32
+	normals    bool
+	texCoords  bool
+	texCoords2 bool
+	colors     bool
+}
+
+type mergeTarget struct {
+	layout mergeLayout
+	node   *MeshNode
+	out    []*MeshNode
+}
+
+func (t *mergeTarget) flush() {
+	if t.node != nil && len(t.node.FaceGroup[0].Faces) > 0 {
+		t.out = append(t.out, t.node)
+	}
+	t.node = &MeshNode{FaceGroup: []*MeshTriangle{{Batchid: t.layout.batchid}}}
+}
+
+func mergeableNode(nd *MeshNode, exportOutline bool, opts *GltfExportOptions) bool {
+	parallel := func(l int) bool { return l == 0 || l == len(nd.Vertices) }
+	return len(nd.FaceGroup) > 0 && len(nd.MorphTargets) == 0 && len(nd.Children) == 0 &&
+		!(exportOutline && len(nd.EdgeGroup) > 0) &&
+		(len(nd.Props) == 0 || opts.PropsExtras == GLTF_PROPS_NONE) &&
+		parallel(len(nd.Normals)) && parallel(len(nd.TexCoords)) && parallel(len(nd.TexCoords2)) && parallel(len(nd.Colors)) &&
+		nd.validateVertexIndices() == nil
+}
+
+func mergeByMaterial(mh *BaseMesh, bake, exportOutline bool, opts *GltfExportOptions) *BaseMesh {
+	if hasChildren(mh.Nodes) {
+		return mh
+	}
+	maxVertices := opts.MaxMergedVertices
+	if maxVertices <= 0 {
+		maxVertices = GLTF_MERGE_MAX_VERTICES
+	}
+	out := &BaseMesh{Materials: mh.Materials, Code: mh.Code}
+	targets := make(map[mergeLayout]*mergeTarget)
+	var order []*mergeTarget
+	for _, nd := range mh.Nodes {
+		nd = nd.withPolygonFaces()
+		if !mergeableNode(nd, exportOutline, opts) {
+			out.Nodes = append(out.Nodes, nd)
+			continue
+		}
+		if bake && nd.Mat != nil {
+			nd = TransformNode(nd, nd.Mat, nil)
+		}
+		remap := make(map[mergeLayout][]uint32)
+		for _, g := range nd.materialGroups() {
+			layout := mergeLayout{batchid: g.Batchid, normals: len(nd.Normals) > 0, texCoords: len(nd.TexCoords) > 0, texCoords2: len(nd.TexCoords2) > 0, colors: len(nd.Colors) > 0}
+			t, ok := targets[layout]
+			if !ok {
+				t = &mergeTarget{layout: layout}
+				t.flush()
+				targets[layout] = t
+				order = append(order, t)
+			}
+			index, ok := remap[layout]
+			if !ok {
+				index = make([]uint32, len(nd.Vertices))
+				for i := range index {
+					index[i] = splitUnmapped
+				}
+				remap[layout] = index
+			}
+			for fi, f := range g.Faces {
+				need := 0
+				for i, v := range f.Vertex {
+					if index[v] == splitUnmapped && (i == 0 || v != f.Vertex[0]) && (i < 2 || v != f.Vertex[1]) {
+						need++
+					}
+				}
+				if len(t.node.Vertices)+need > maxVertices && len(t.node.FaceGroup[0].Faces) > 0 {
+					t.flush()
+					for i := range index {
+						index[i] = splitUnmapped
+					}
+				}
+				cur := t.node
+				var face Face
+				for i, v := range f.Vertex {
+					if index[v] == splitUnmapped {
+						index[v] = uint32(len(cur.Vertices))
+						cur.Vertices = append(cur.Vertices, nd.Vertices[v])
+						if layout.normals {
+							cur.Normals = append(cur.Normals, nd.Normals[v])
+						}
+						if layout.texCoords {
+							cur.TexCoords = append(cur.TexCoords, nd.TexCoords[v])
+						}
+						if layout.texCoords2 {
+							cur.TexCoords2 = append(cur.TexCoords2, nd.TexCoords2[v])
+						}
+						if layout.colors {
+							cur.Colors = append(cur.Colors, nd.Colors[v])
+						}
+					}
+					face.Vertex[i] = index[v]
+				}
+				cur.FaceGroup[0].addFace(&face, g, fi)
+			}
+		}
+	}
+	for _, t := range order {
+		t.flush()
+		for _, nd := range t.out {
+			if b := nd.FaceGroup[0].Batchid; b >= 0 && int(b) < len(mh.Materials) {
+				nd.Name = MaterialName(mh.Materials[b])
+			}
+			out.Nodes = append(out.Nodes, nd)
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package mst
+
+import (
+	"fmt"
+
+	"github.com/flywave/go3d/vec3"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+const gltfTargetNames = "targetNames"
+
+func gltfVec3s(vs []vec3.T) [][3]float32 {
+	out := make([][3]float32, len(vs))
+	for i, v := range vs {
+		out[i] = [3]float32(v)
+	}
+	return out
+}
+
+func buildMorphTargets(doc *gltf.Document, mesh *gltf.Mesh, nd *MeshNode) {
+	if len(nd.MorphTargets) == 0 {
+		return
+	}
+	targets := make([]gltf.Attribute, len(nd.MorphTargets))
+	names := make([]string, len(nd.MorphTargets))
+	named := false
+	for i, t := range nd.MorphTargets {
+		targets[i] = make(gltf.Attribute)
+		if len(t.Positions) > 0 && len(t.Positions) == len(nd.Vertices) {
+			acc := modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, gltfVec3s(t.Positions))
+			min, max, _ := componentBounds(len(t.Positions), 3, func(i, c int) float32 { return t.Positions[i][c] })
+			doc.Accessors[acc].Min = []float32{float32(min[0]), float32(min[1]), float32(min[2])}
+			doc.Accessors[acc].Max = []float32{float32(max[0]), float32(max[1]), float32(max[2])}
+			targets[i]["POSITION"] = acc
+		}
+		if len(t.Normals) > 0 && len(t.Normals) == len(nd.Normals) && len(nd.Normals) == len(nd.Vertices) {
+			targets[i]["NORMAL"] = modeler.WriteAccessor(doc, gltf.TargetArrayBuffer, gltfVec3s(t.Normals))
+		}
+		names[i] = t.Name
+		named = named || t.Name != ""
+	}
+	for _, p := range mesh.Primitives {
+		p.Targets = targets
+	}
+	mesh.Weights = nd.MorphWeights()
+	if !named {
+		return
+	}
+	extras := map[string]interface{}{}
+	if m, ok := mesh.Extras.(map[string]interface{}); ok {
+		for k, v := range m {
+			extras[k] = v
+		}
+	}
+	extras[gltfTargetNames] = names
+	mesh.Extras = extras
+}
+
+func padVec3s(vs []vec3.T, n int) []vec3.T {
+	for len(vs) < n {
+		vs = append(vs, vec3.T{})
+	}
+	return vs
+}
+
+func (b *gltfNodeBuilder) morphAttribute(imp *gltfImporter, tgt gltf.Attribute, name string, n int) ([][3]float32, error) {
+	idx, ok := tgt[name]
+	if !ok {
+		return nil, nil
+	}
+	acc, err := imp.accessor(idx)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := readGltfVec3(imp.doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) != n {
+		return nil, fmt.Errorf("mst: glTF morph target %s has %d elements for %d vertices", name, len(vs), n)
+	}
+	return vs, nil
+}
+
+func (b *gltfNodeBuilder) morphTargets(imp *gltfImporter, p *gltf.Primitive, base uint32, n int) error {
+	for k, tgt := range p.Targets {
+		if k >= maxMorphTargets {
+			return fmt.Errorf("mst: glTF primitive has more than %d morph targets", maxMorphTargets)
+		}
+		for len(b.nd.MorphTargets) <= k {
+			b.nd.MorphTargets = append(b.nd.MorphTargets, &MorphTarget{})
+			b.morphPos = append(b.morphPos, false)
+			b.morphNormal = append(b.morphNormal, false)
+		}
+		t := b.nd.MorphTargets[k]
+		pos, err := b.morphAttribute(imp, tgt, "POSITION", n)
+		if err != nil {
+			return err
+		}
+		if pos != nil {
+			t.Positions = padVec3s(t.Positions, int(base))
+			for _, v := range pos {
+				t.Positions = append(t.Positions, vec3.T(v))
+			}
+			b.morphPos[k] = true
+		}
+		normals, err := b.morphAttribute(imp, tgt, "NORMAL", n)
+		if err != nil {
+			return err
+		}
+		if normals != nil {
+			t.Normals = padVec3s(t.Normals, int(base))
+			for _, v := range normals {
+				t.Normals = append(t.Normals, vec3.T(v))
+			}
+			b.morphNormal[k] = true
+		}
+	}
+	return nil
+}
+
+func (b *gltfNodeBuilder) finishMorphTargets(gm *gltf.Mesh) {
+	var names []interface{}
+	if b.nd.Props != nil {
+		switch v := b.nd.Props[gltfTargetNames].(type) {
+		case []interface{}:
+			names = v
+		case []string:
+			for _, s := range v {
+				names = append(names, s)
+			}
+		}
+		delete(b.nd.Props, gltfTargetNames)
+		if len(b.nd.Props) == 0 {
+			b.nd.Props = nil
+		}
+	}
+	for i, t := range b.nd.MorphTargets {
+		if b.morphPos[i] {
+			t.Positions = padVec3s(t.Positions, len(b.nd.Vertices))
+		}
+		if b.morphNormal[i] && b.hasNormals {
+			t.Normals = padVec3s(t.Normals, len(b.nd.Vertices))
+		} else {
+			t.Normals = nil
+		}
+		if i < len(names) {
+			t.Name, _ = names[i].(string)
+		}
+		if i < len(gm.Weights) {
+			t.Weight = gm.Weights[i]
+		}
+	}
+}
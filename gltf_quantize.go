@@ -0,0 +1,101 @@
+package mst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+	"github.com/qmuntal/gltf"
+)
+
+const KHR_MESH_QUANTIZATION = "KHR_mesh_quantization"
+
+func gltfQuantization(nd *MeshNode, opts *GltfExportOptions, dropPositions bool) *nodeQuantization {
+	flags := nd.Quantization | opts.Quantization
+	if dropPositions {
+		flags &^= QUANTIZE_POSITION
+	}
+	if len(nd.MorphTargets) > 0 {
+		flags &^= QUANTIZE_POSITION | QUANTIZE_NORMAL | QUANTIZE_NORMAL_OCT16
+	}
+	quant := nd.planQuantization(flags)
+	if quant.flags&QUANTIZE_NORMAL_OCT16 != 0 {
+		quant.flags = quant.flags&^QUANTIZE_NORMAL_OCT16 | QUANTIZE_NORMAL
+	}
+	if quant.flags&QUANTIZE_TEXCOORD_HALF != 0 {
+		quant.flags = quant.flags&^QUANTIZE_TEXCOORD_HALF | QUANTIZE_TEXCOORD
+	}
+	if quant.flags&QUANTIZE_TEXCOORD != 0 {
+		min, max, _ := componentBounds(len(nd.TexCoords), 2, func(i, c int) float32 { return nd.TexCoords[i][c] })
+		if min[0] < 0 || min[1] < 0 || max[0] > 1 || max[1] > 1 {
+			quant.flags &^= QUANTIZE_TEXCOORD
+		}
+	}
+	return quant
+}
+
+func (g *QuantizationGrid) matrix() *mat4d.T {
+	mt := mat4d.Ident
+	for c := 0; c < 3; c++ {
+		mt[c][c] = float64(g.Scale[c])
+		if g.Scale[c] == 0 {
+			mt[c][c] = 1
+		}
+		mt[3][c] = float64(g.Offset[c])
+	}
+	return &mt
+}
+
+func gltfMatrix(mt *mat4d.T) [16]float32 {
+	var out [16]float32
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			out[i*4+j] = float32(mt[i][j])
+		}
+	}
+	return out
+}
+
+func quantizedBounds(vs []vec3.T, g *QuantizationGrid) ([]float32, []float32) {
+	min := []float32{math.MaxUint16, math.MaxUint16, math.MaxUint16}
+	max := []float32{0, 0, 0}
+	for _, v := range vs {
+		for c := 0; c < 3; c++ {
+			q := float32(g.Quantize(v[c], c))
+			min[c] = float32(math.Min(float64(min[c]), float64(q)))
+			max[c] = float32(math.Max(float64(max[c]), float64(q)))
+		}
+	}
+	return min, max
+}
+
+func writeGltfPositions(buf *bytes.Buffer, vs []vec3.T, g *QuantizationGrid) {
+	out := make([][4]uint16, len(vs))
+	for i, v := range vs {
+		out[i] = [4]uint16{g.Quantize(v[0], 0), g.Quantize(v[1], 1), g.Quantize(v[2], 2), 0}
+	}
+	binary.Write(buf, binary.LittleEndian, out)
+}
+
+func writeGltfNormals(buf *bytes.Buffer, ns []vec3.T) {
+	out := make([][4]int8, len(ns))
+	for i, n := range ns {
+		l := float32(math.Sqrt(float64(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])))
+		if l == 0 {
+			continue
+		}
+		out[i] = [4]int8{gltf.NormalizeByte(n[0] / l), gltf.NormalizeByte(n[1] / l), gltf.NormalizeByte(n[2] / l), 0}
+	}
+	binary.Write(buf, binary.LittleEndian, out)
+}
+
+func writeGltfTexCoords(buf *bytes.Buffer, uvs []vec2.T) {
+	out := make([][2]uint16, len(uvs))
+	for i, uv := range uvs {
+		out[i] = [2]uint16{gltf.NormalizeUshort(uv[0]), gltf.NormalizeUshort(uv[1])}
+	}
+	binary.Write(buf, binary.LittleEndian, out)
+}
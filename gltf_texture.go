@@ -0,0 +1,108 @@
+package mst
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+
+	"github.com/qmuntal/gltf"
+	"golang.org/x/image/draw"
+)
+
+type TextureLevel struct {
+	Image  uint32 `json:"image"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type TextureLevelsExtras struct {
+	Levels []TextureLevel `json:"levels"`
+}
+
+type TextureRaw struct {
+	BufferView uint32 `json:"bufferView"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Format     uint16 `json:"format"`
+	Type       uint16 `json:"type"`
+}
+
+type TextureRawExtras struct {
+	RawTexture *TextureRaw `json:"rawTexture"`
+}
+
+func appendRawTexture(doc *gltf.Document, buffer *gltf.Buffer, tex *Texture) (*TextureRawExtras, error) {
+	data, err := tex.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	if sz := tex.rawSize(); sz > 0 && len(data) != sz {
+		return nil, fmt.Errorf("mst: raw texture has %d bytes, expected %d", len(data), sz)
+	}
+	if pad := (4 - buffer.ByteLength%4) % 4; pad > 0 {
+		buffer.Data = append(buffer.Data, make([]byte, pad)...)
+		buffer.ByteLength += pad
+	}
+	idx := uint32(len(doc.BufferViews))
+	doc.BufferViews = append(doc.BufferViews, &gltf.BufferView{Buffer: 0, ByteOffset: buffer.ByteLength, ByteLength: uint32(len(data))})
+	buffer.Data = append(buffer.Data, data...)
+	buffer.ByteLength += uint32(len(data))
+	return &TextureRawExtras{RawTexture: &TextureRaw{BufferView: idx, Width: int(tex.Size[0]), Height: int(tex.Size[1]), Format: tex.Format, Type: tex.Type}}, nil
+}
+
+func rawTextureExtras(extras interface{}) *TextureRaw {
+	switch ex := extras.(type) {
+	case nil:
+		return nil
+	case *TextureRawExtras:
+		return ex.RawTexture
+	}
+	data, err := json.Marshal(extras)
+	if err != nil {
+		return nil
+	}
+	var ex TextureRawExtras
+	if json.Unmarshal(data, &ex) != nil {
+		return nil
+	}
+	return ex.RawTexture
+}
+
+func scaleImage(src image.Image, maxSize uint32) image.Image {
+	bd := src.Bounds()
+	w, h := bd.Dx(), bd.Dy()
+	if w <= int(maxSize) && h <= int(maxSize) {
+		return nil
+	}
+	if w >= h {
+		h = h * int(maxSize) / w
+		w = int(maxSize)
+	} else {
+		w = w * int(maxSize) / h
+		h = int(maxSize)
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bd, draw.Src, nil)
+	return dst
+}
+
+func buildTextureLevels(doc *gltf.Document, buffer *gltf.Buffer, img image.Image, full uint32, sizes []uint32) *TextureLevelsExtras {
+	bd := img.Bounds()
+	extras := &TextureLevelsExtras{Levels: []TextureLevel{{Image: full, Width: bd.Dx(), Height: bd.Dy()}}}
+	for _, sz := range sizes {
+		level := scaleImage(img, sz)
+		if level == nil {
+			continue
+		}
+		idx := appendImage(doc, buffer, level)
+		lb := level.Bounds()
+		extras.Levels = append(extras.Levels, TextureLevel{Image: idx, Width: lb.Dx(), Height: lb.Dy()})
+	}
+	return extras
+}
@@ -0,0 +1,868 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/float64/quaternion"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+	"github.com/qmuntal/gltf"
+	"github.com/qmuntal/gltf/ext/specular"
+	"github.com/qmuntal/gltf/modeler"
+)
+
+const GLTF_GPU_INSTANCING = "EXT_mesh_gpu_instancing"
+
+var gltfImportExtensions = map[string]bool{
+	GLTF_GPU_INSTANCING:    true,
+	KHR_MESH_QUANTIZATION:  true,
+	specular.ExtensionName: true,
+}
+
+type GltfImportOptions struct {
+	Scene           *uint32
+	BaseDir         string
+	ContinueOnError bool
+	KeepTransforms  bool
+	AxisConvention  uint8
+	Context         context.Context
+	Progress        ProgressFunc
+	Logger          Logger
+}
+
+type gltfOccurrence struct {
+	node   uint32
+	world  *dmat.T
+	extras Properties
+	gpu    []*dmat.T
+}
+
+type gltfInstance struct {
+	trans []*dmat.T
+	props Properties
+	nodes []*MeshNode
+}
+
+type gltfImporter struct {
+	doc         *gltf.Document
+	opts        *GltfImportOptions
+	ec          *errorCollector
+	ms          *Mesh
+	textures    map[uint32]*Texture
+	defaultMtl  int32
+	instances   []*gltfInstance
+	animTargets map[uint32]gltfAnimTarget
+	parents     map[uint32]uint32
+	nodes       map[uint32]uint32
+	worlds      map[uint32]*dmat.T
+}
+
+func GltfToMst(doc *gltf.Document) (*Mesh, error) {
+	return GltfToMstWithOptions(doc, nil)
+}
+
+func GltfToMstWithOptions(doc *gltf.Document, opts *GltfImportOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &GltfImportOptions{}
+	}
+	axis, err := axisChange(AXIS_CONVENTION_Y_UP, opts.AxisConvention)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = loadGltfBuffers(doc, opts.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range doc.ExtensionsUsed {
+		if !gltfImportExtensions[ext] {
+			logWarn(opts.Logger, "unsupported glTF extension", "extension", ext, "required", hasString(doc.ExtensionsRequired, ext))
+		}
+	}
+	p := newProgress(opts.Context, opts.Progress)
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	imp := &gltfImporter{doc: doc, opts: opts, ec: newErrorCollector(opts.ContinueOnError, opts.Logger), ms: NewMesh(), textures: make(map[uint32]*Texture), defaultMtl: -1, animTargets: make(map[uint32]gltfAnimTarget), parents: make(map[uint32]uint32), nodes: make(map[uint32]uint32), worlds: make(map[uint32]*dmat.T)}
+	if err := imp.materials(); err != nil {
+		return nil, err
+	}
+
+	scene := doc.Scene
+	if opts.Scene != nil {
+		scene = opts.Scene
+	}
+	var roots []uint32
+	if scene != nil && int(*scene) < len(doc.Scenes) {
+		sc := doc.Scenes[*scene]
+		roots = sc.Nodes
+		imp.ms.Props = extrasToProps(sc.Extras)
+	} else {
+		for i := range doc.Nodes {
+			roots = append(roots, uint32(i))
+		}
+	}
+
+	uses := make(map[uint32][]*gltfOccurrence)
+	var order []uint32
+	for _, r := range roots {
+		if err := imp.walk(r, &dmat.Ident, uses, &order, 0); err != nil {
+			return nil, err
+		}
+	}
+	for i, mi := range order {
+		if err := imp.ec.report(fmt.Sprintf("meshes[%d]", mi), imp.mesh(mi, uses[mi])); err != nil {
+			return nil, err
+		}
+		if err := p.report(PROGRESS_STAGE_GLTF, i+1, len(order)); err != nil {
+			return nil, err
+		}
+	}
+	imp.finishInstances()
+	imp.hierarchy()
+	if err := imp.animations(); err != nil {
+		return nil, err
+	}
+	for _, nd := range imp.ms.Nodes {
+		if len(nd.Props) > 0 {
+			imp.ms.Version = V8
+		}
+	}
+	for _, p := range imp.instances {
+		for _, nd := range p.nodes {
+			if len(nd.Props) > 0 {
+				imp.ms.Version = V8
+			}
+		}
+	}
+	if len(imp.ms.Animations) > 0 {
+		imp.ms.Version = V12
+	}
+	if hasMorphTargets(imp.ms) {
+		imp.ms.Version = V13
+	}
+	if anyNode(imp.ms, inHierarchy) {
+		imp.ms.Version = V14
+	}
+	if axis != nil {
+		axis.mesh(imp.ms)
+	}
+	return imp.ms, imp.ec.err()
+}
+
+func GltfToMstContext(ctx context.Context, doc *gltf.Document, opts *GltfImportOptions) (*Mesh, error) {
+	o := GltfImportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Context = ctx
+	return GltfToMstWithOptions(doc, &o)
+}
+
+func extrasValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val)
+		}
+		return val
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, e := range val {
+			out[i] = extrasValue(e)
+		}
+		return out
+	}
+	if m, ok := asProperties(v); ok {
+		return map[string]interface{}(extrasToProps(m))
+	}
+	return v
+}
+
+func extrasToProps(extras interface{}) Properties {
+	m, ok := asProperties(extras)
+	if !ok {
+		if raw, isRaw := extras.(json.RawMessage); isRaw {
+			var v map[string]interface{}
+			if json.Unmarshal(raw, &v) != nil {
+				return nil
+			}
+			m = Properties(v)
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(Properties, len(m))
+	for k, v := range m {
+		out[k] = extrasValue(v)
+	}
+	return out
+}
+
+func nodeMatrix(nd *gltf.Node) dmat.T {
+	if nd.Matrix != [16]float32{} && nd.Matrix != gltf.DefaultMatrix {
+		var mt dmat.T
+		for i := 0; i < 16; i++ {
+			mt[i/4][i%4] = float64(nd.Matrix[i])
+		}
+		return mt
+	}
+	t := nd.TranslationOrDefault()
+	r := nd.RotationOrDefault()
+	s := nd.ScaleOrDefault()
+	return trsMatrix(t, r, s)
+}
+
+func trsMatrix(t [3]float32, r [4]float32, s [3]float32) dmat.T {
+	pos := dvec3.T{float64(t[0]), float64(t[1]), float64(t[2])}
+	quat := quaternion.T{float64(r[0]), float64(r[1]), float64(r[2]), float64(r[3])}
+	scl := dvec3.T{float64(s[0]), float64(s[1]), float64(s[2])}
+	return *dmat.Compose(&pos, &quat, &scl)
+}
+
+func (imp *gltfImporter) walk(idx uint32, parent *dmat.T, uses map[uint32][]*gltfOccurrence, order *[]uint32, depth int) error {
+	if int(idx) >= len(imp.doc.Nodes) {
+		return fmt.Errorf("mst: glTF node %d out of range", idx)
+	}
+	if depth > len(imp.doc.Nodes) {
+		return fmt.Errorf("mst: glTF node hierarchy has a cycle at node %d", idx)
+	}
+	nd := imp.doc.Nodes[idx]
+	local := nodeMatrix(nd)
+	world := &dmat.T{}
+	world.AssignMul(parent, &local)
+	if _, ok := imp.worlds[idx]; !ok {
+		imp.worlds[idx] = world
+	}
+	if nd.Mesh != nil {
+		occ := &gltfOccurrence{node: idx, world: world, extras: extrasToProps(nd.Extras)}
+		if ext, ok := nd.Extensions[GLTF_GPU_INSTANCING]; ok {
+			gpu, err := imp.gpuTransforms(ext)
+			if err := imp.ec.report(fmt.Sprintf("nodes[%d]", idx), err); err != nil {
+				return err
+			}
+			occ.gpu = gpu
+		}
+		if _, seen := uses[*nd.Mesh]; !seen {
+			*order = append(*order, *nd.Mesh)
+		}
+		uses[*nd.Mesh] = append(uses[*nd.Mesh], occ)
+	}
+	for _, c := range nd.Children {
+		if _, ok := imp.parents[c]; !ok {
+			imp.parents[c] = idx
+		}
+		if err := imp.walk(c, world, uses, order, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *gltfImporter) accessor(idx uint32) (*gltf.Accessor, error) {
+	if int(idx) >= len(imp.doc.Accessors) {
+		return nil, fmt.Errorf("mst: glTF accessor %d out of range", idx)
+	}
+	return imp.doc.Accessors[idx], nil
+}
+
+func (imp *gltfImporter) gpuTransforms(ext interface{}) ([]*dmat.T, error) {
+	raw, ok := ext.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(ext); err != nil {
+			return nil, err
+		}
+	}
+	var inst struct {
+		Attributes map[string]uint32 `json:"attributes"`
+	}
+	if err := json.Unmarshal(raw, &inst); err != nil {
+		return nil, err
+	}
+	var ts, ss [][3]float32
+	var rs [][4]float32
+	count := -1
+	read := func(name string, fn func(acc *gltf.Accessor) (int, error)) error {
+		idx, ok := inst.Attributes[name]
+		if !ok {
+			return nil
+		}
+		acc, err := imp.accessor(idx)
+		if err != nil {
+			return err
+		}
+		n, err := fn(acc)
+		if err != nil {
+			return err
+		}
+		if count >= 0 && n != count {
+			return fmt.Errorf("mst: %s has %d instances, expected %d", GLTF_GPU_INSTANCING, n, count)
+		}
+		count = n
+		return nil
+	}
+	err := read("TRANSLATION", func(acc *gltf.Accessor) (int, error) {
+		var err error
+		ts, err = readGltfVec3(imp.doc, acc)
+		return len(ts), err
+	})
+	if err == nil {
+		err = read("ROTATION", func(acc *gltf.Accessor) (int, error) {
+			var err error
+			rs, err = readGltfVec4(imp.doc, acc)
+			return len(rs), err
+		})
+	}
+	if err == nil {
+		err = read("SCALE", func(acc *gltf.Accessor) (int, error) {
+			var err error
+			ss, err = readGltfVec3(imp.doc, acc)
+			return len(ss), err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*dmat.T, 0, count)
+	for i := 0; i < count; i++ {
+		t, r, s := [3]float32{}, gltf.DefaultRotation, gltf.DefaultScale
+		if ts != nil {
+			t = ts[i]
+		}
+		if rs != nil {
+			r = rs[i]
+		}
+		if ss != nil {
+			s = ss[i]
+		}
+		mt := trsMatrix(t, r, s)
+		out = append(out, &mt)
+	}
+	return out, nil
+}
+
+func colorByte(f float32) byte {
+	if f <= 0 {
+		return 0
+	}
+	if f >= 1 {
+		return 255
+	}
+	return byte(math.Round(float64(f) * 255))
+}
+
+func colorBytes(c []float32) [3]byte {
+	return [3]byte{colorByte(c[0]), colorByte(c[1]), colorByte(c[2])}
+}
+
+func (imp *gltfImporter) imageData(img *gltf.Image) ([]byte, error) {
+	if img.BufferView != nil {
+		if int(*img.BufferView) >= len(imp.doc.BufferViews) {
+			return nil, fmt.Errorf("mst: glTF buffer view %d out of range", *img.BufferView)
+		}
+		return modeler.ReadBufferView(imp.doc, imp.doc.BufferViews[*img.BufferView])
+	}
+	if img.IsEmbeddedResource() {
+		return img.MarshalData()
+	}
+	if img.URI != "" && imp.opts.BaseDir != "" {
+		return readGltfResource(imp.opts.BaseDir, img.URI)
+	}
+	return nil, fmt.Errorf("mst: glTF image %q is not embedded", img.URI)
+}
+
+func (imp *gltfImporter) texture(idx uint32) (*Texture, error) {
+	if tex, ok := imp.textures[idx]; ok {
+		return tex, nil
+	}
+	if int(idx) >= len(imp.doc.Textures) {
+		return nil, fmt.Errorf("mst: glTF texture %d out of range", idx)
+	}
+	gt := imp.doc.Textures[idx]
+	if raw := rawTextureExtras(gt.Extras); gt.Source == nil && raw != nil {
+		tex, err := imp.rawTexture(raw)
+		if err != nil {
+			return nil, err
+		}
+		tex.Id, tex.Name = int32(idx), gt.Name
+		if gt.Sampler != nil && int(*gt.Sampler) < len(imp.doc.Samplers) {
+			tex.Repeated = imp.doc.Samplers[*gt.Sampler].WrapS == gltf.WrapRepeat
+		}
+		imp.textures[idx] = tex
+		return tex, nil
+	}
+	if gt.Source == nil || int(*gt.Source) >= len(imp.doc.Images) {
+		return nil, fmt.Errorf("mst: glTF texture %d has no image", idx)
+	}
+	data, err := imp.imageData(imp.doc.Images[*gt.Source])
+	if err != nil {
+		return nil, err
+	}
+	tex, err := sourceTexture(data, true)
+	if err != nil {
+		return nil, err
+	}
+	tex.Id, tex.Name = int32(idx), gt.Name
+	if gt.Sampler != nil && int(*gt.Sampler) < len(imp.doc.Samplers) {
+		tex.Repeated = imp.doc.Samplers[*gt.Sampler].WrapS == gltf.WrapRepeat
+	}
+	imp.textures[idx] = tex
+	return tex, nil
+}
+
+func (imp *gltfImporter) rawTexture(raw *TextureRaw) (*Texture, error) {
+	if int(raw.BufferView) >= len(imp.doc.BufferViews) {
+		return nil, fmt.Errorf("mst: glTF buffer view %d out of range", raw.BufferView)
+	}
+	data, err := modeler.ReadBufferView(imp.doc, imp.doc.BufferViews[raw.BufferView])
+	if err != nil {
+		return nil, err
+	}
+	tex := &Texture{Size: [2]uint64{uint64(raw.Width), uint64(raw.Height)}, Format: raw.Format, Type: raw.Type, Data: append([]byte(nil), data...)}
+	if err := tex.Validate(); err != nil {
+		return nil, err
+	}
+	return tex, nil
+}
+
+func sourceTexture(data []byte, flipY bool) (*Texture, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bd := src.Bounds()
+	var img draw.Image = image.NewNRGBA(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+	if isWideImage(src) {
+		img = image.NewNRGBA64(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+	}
+	for y := 0; y < bd.Dy(); y++ {
+		dy := y
+		if flipY {
+			dy = bd.Dy() - y - 1
+		}
+		draw.Draw(img, image.Rect(0, dy, bd.Dx(), dy+1), src, image.Pt(bd.Min.X, bd.Min.Y+y), draw.Src)
+	}
+	return imageTexture(img)
+}
+
+func (imp *gltfImporter) material(i int, gm *gltf.Material) (MeshMaterial, error) {
+	pbr := gm.PBRMetallicRoughness
+	if pbr == nil {
+		pbr = &gltf.PBRMetallicRoughness{}
+	}
+	cl := pbr.BaseColorFactorOrDefault()
+	tm := TextureMaterial{BaseMaterial: BaseMaterial{Name: gm.Name, Color: colorBytes(cl[:3]), Transparency: 1 - cl[3]}}
+	field := fmt.Sprintf("materials[%d]", i)
+	baseColor := pbr.BaseColorTexture
+	sp, _ := gm.Extensions[specular.ExtensionName].(*specular.PBRSpecularGlossiness)
+	if baseColor == nil && sp != nil {
+		baseColor = sp.DiffuseTexture
+	}
+	if baseColor != nil {
+		tex, err := imp.texture(baseColor.Index)
+		if err := imp.ec.report(field+".texture", err); err != nil {
+			return nil, err
+		}
+		if tex != nil {
+			tex.ColorSpace = TEXTURE_COLOR_SPACE_SRGB
+		}
+		tm.Texture = tex
+		set, err := gltfTexCoordSet(baseColor.TexCoord)
+		if err := imp.ec.report(field+".texCoord", err); err != nil {
+			return nil, err
+		}
+		tm.TexCoord = set
+	}
+	if gm.NormalTexture != nil && gm.NormalTexture.Index != nil {
+		tex, err := imp.texture(*gm.NormalTexture.Index)
+		if err := imp.ec.report(field+".normal", err); err != nil {
+			return nil, err
+		}
+		if tex != nil {
+			tex.ColorSpace = TEXTURE_COLOR_SPACE_LINEAR
+		}
+		tm.Normal = tex
+		set, err := gltfTexCoordSet(gm.NormalTexture.TexCoord)
+		if err := imp.ec.report(field+".normalTexCoord", err); err != nil {
+			return nil, err
+		}
+		tm.NormalTexCoord = set
+	}
+	emissive := colorBytes(gm.EmissiveFactor[:])
+	if sp != nil {
+		lm := LambertMaterial{TextureMaterial: tm, Emissive: emissive}
+		if sp.DiffuseFactor != nil {
+			lm.Diffuse = colorBytes(sp.DiffuseFactor[:3])
+		}
+		if sp.SpecularFactor == nil && sp.GlossinessFactor == nil {
+			return &lm, nil
+		}
+		ph := &PhongMaterial{LambertMaterial: lm}
+		if sp.SpecularFactor != nil {
+			ph.Specular = colorBytes(sp.SpecularFactor[:])
+		}
+		if sp.GlossinessFactor != nil {
+			ph.Shininess = *sp.GlossinessFactor
+		}
+		return ph, nil
+	}
+	metallic := pbr.MetallicFactorOrDefault()
+	roughness := pbr.RoughnessFactorOrDefault()
+	if metallic == 0 && roughness == 1 && emissive == [3]byte{} {
+		if tm.Texture == nil && tm.Normal == nil {
+			return &tm.BaseMaterial, nil
+		}
+		return &tm, nil
+	}
+	return &PbrMaterial{TextureMaterial: tm, Emissive: emissive, Metallic: metallic, Roughness: roughness}, nil
+}
+
+func (imp *gltfImporter) materials() error {
+	for i, gm := range imp.doc.Materials {
+		mtl, err := imp.material(i, gm)
+		if err != nil {
+			return err
+		}
+		imp.ms.Materials = append(imp.ms.Materials, mtl)
+	}
+	return nil
+}
+
+func (imp *gltfImporter) batchid(p *gltf.Primitive) int32 {
+	if p.Material != nil && int(*p.Material) < len(imp.ms.Materials) {
+		return int32(*p.Material)
+	}
+	if imp.defaultMtl < 0 {
+		imp.defaultMtl = int32(len(imp.ms.Materials))
+		imp.ms.Materials = append(imp.ms.Materials, &BaseMaterial{Color: [3]byte{255, 255, 255}})
+	}
+	return imp.defaultMtl
+}
+
+func primitiveModeOf(mode gltf.PrimitiveMode) (uint8, error) {
+	switch mode {
+	case gltf.PrimitiveTriangles:
+		return MESH_TRIANGLE_MODE_TRIANGLES, nil
+	case gltf.PrimitiveTriangleStrip:
+		return MESH_TRIANGLE_MODE_STRIP, nil
+	case gltf.PrimitiveTriangleFan:
+		return MESH_TRIANGLE_MODE_FAN, nil
+	}
+	return 0, fmt.Errorf("mst: unsupported glTF primitive mode %d", mode)
+}
+
+func gltfPrimitiveMode(mode uint8) gltf.PrimitiveMode {
+	switch mode {
+	case MESH_TRIANGLE_MODE_STRIP:
+		return gltf.PrimitiveTriangleStrip
+	case MESH_TRIANGLE_MODE_FAN:
+		return gltf.PrimitiveTriangleFan
+	}
+	return gltf.PrimitiveTriangles
+}
+
+func lineEdges(mode gltf.PrimitiveMode, idx []uint32) [][2]uint32 {
+	var edges [][2]uint32
+	switch mode {
+	case gltf.PrimitiveLines:
+		for i := 0; i+1 < len(idx); i += 2 {
+			edges = append(edges, [2]uint32{idx[i], idx[i+1]})
+		}
+	case gltf.PrimitiveLineStrip, gltf.PrimitiveLineLoop:
+		for i := 0; i+1 < len(idx); i++ {
+			edges = append(edges, [2]uint32{idx[i], idx[i+1]})
+		}
+		if mode == gltf.PrimitiveLineLoop && len(idx) > 2 {
+			edges = append(edges, [2]uint32{idx[len(idx)-1], idx[0]})
+		}
+	}
+	return edges
+}
+
+type gltfNodeBuilder struct {
+	nd                      *MeshNode
+	bases                   map[uint32]uint32
+	hasNormals, hasTexCoord bool
+	hasTexCoord2            bool
+	morphPos, morphNormal   []bool
+}
+
+func (b *gltfNodeBuilder) vertices(imp *gltfImporter, p *gltf.Primitive) (uint32, int, error) {
+	posIdx, ok := p.Attributes["POSITION"]
+	if !ok {
+		return 0, 0, fmt.Errorf("mst: glTF primitive without POSITION")
+	}
+	acc, err := imp.accessor(posIdx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if base, ok := b.bases[posIdx]; ok {
+		return base, int(acc.Count), nil
+	}
+	pos, err := readGltfVec3(imp.doc, acc)
+	if err != nil {
+		return 0, 0, err
+	}
+	n := len(pos)
+	var normals [][3]float32
+	if idx, ok := p.Attributes["NORMAL"]; ok {
+		if acc, err := imp.accessor(idx); err == nil {
+			normals, err = readGltfVec3(imp.doc, acc)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	texCoords := func(attr string) ([][2]float32, error) {
+		if idx, ok := p.Attributes[attr]; ok {
+			if acc, err := imp.accessor(idx); err == nil {
+				return readGltfVec2(imp.doc, acc)
+			}
+		}
+		return nil, nil
+	}
+	uvs, err := texCoords("TEXCOORD_0")
+	if err != nil {
+		return 0, 0, err
+	}
+	uvs2, err := texCoords("TEXCOORD_1")
+	if err != nil {
+		return 0, 0, err
+	}
+	base := uint32(len(b.nd.Vertices))
+	b.bases[posIdx] = base
+	for i := 0; i < n; i++ {
+		b.nd.Vertices = append(b.nd.Vertices, vec3.T(pos[i]))
+		var nl vec3.T
+		if i < len(normals) {
+			nl = vec3.T(normals[i])
+		}
+		b.nd.Normals = append(b.nd.Normals, nl)
+		var uv vec2.T
+		if i < len(uvs) {
+			uv = vec2.T(uvs[i])
+		}
+		b.nd.TexCoords = append(b.nd.TexCoords, uv)
+		var uv2 vec2.T
+		if i < len(uvs2) {
+			uv2 = vec2.T(uvs2[i])
+		}
+		b.nd.TexCoords2 = append(b.nd.TexCoords2, uv2)
+	}
+	b.hasNormals = b.hasNormals || len(normals) > 0
+	b.hasTexCoord = b.hasTexCoord || len(uvs) > 0
+	b.hasTexCoord2 = b.hasTexCoord2 || len(uvs2) > 0
+	if err := b.morphTargets(imp, p, base, n); err != nil {
+		return 0, 0, err
+	}
+	return base, n, nil
+}
+
+func (imp *gltfImporter) primitive(b *gltfNodeBuilder, p *gltf.Primitive) error {
+	base, n, err := b.vertices(imp, p)
+	if err != nil {
+		return err
+	}
+	var idx []uint32
+	if p.Indices != nil {
+		acc, err := imp.accessor(*p.Indices)
+		if err != nil {
+			return err
+		}
+		if idx, err = readGltfIndices(imp.doc, acc); err != nil {
+			return err
+		}
+	} else {
+		idx = make([]uint32, n)
+		for i := range idx {
+			idx[i] = uint32(i)
+		}
+	}
+	for i, v := range idx {
+		if int(v) >= n {
+			return &IndexError{Kind: "vertex", Index: v, Count: n}
+		}
+		idx[i] = v + base
+	}
+	batchid := imp.batchid(p)
+	switch p.Mode {
+	case gltf.PrimitivePoints:
+		return fmt.Errorf("mst: glTF point primitives are not supported")
+	case gltf.PrimitiveLines, gltf.PrimitiveLineStrip, gltf.PrimitiveLineLoop:
+		return b.nd.AddEdges(batchid, lineEdges(p.Mode, idx))
+	}
+	mode, err := primitiveModeOf(p.Mode)
+	if err != nil {
+		return err
+	}
+	tris, err := primitiveFaces(mode, idx)
+	if err != nil {
+		return err
+	}
+	g := &MeshTriangle{Batchid: batchid, Mode: mode, Faces: make([]*Face, len(tris))}
+	for i := range tris {
+		g.Faces[i] = &Face{Vertex: tris[i]}
+	}
+	if err := imp.faceFeatures(p, g, base); err != nil {
+		return err
+	}
+	return b.nd.addFaces(g)
+}
+
+func (imp *gltfImporter) node(mi uint32) (*MeshNode, error) {
+	if int(mi) >= len(imp.doc.Meshes) {
+		return nil, fmt.Errorf("mst: glTF mesh %d out of range", mi)
+	}
+	gm := imp.doc.Meshes[mi]
+	b := &gltfNodeBuilder{nd: &MeshNode{Props: extrasToProps(gm.Extras)}, bases: make(map[uint32]uint32)}
+	for i, p := range gm.Primitives {
+		if err := imp.ec.report(fmt.Sprintf("primitives[%d]", i), imp.primitive(b, p)); err != nil {
+			return nil, err
+		}
+	}
+	b.finishMorphTargets(gm)
+	if !b.hasNormals {
+		b.nd.Normals = nil
+	}
+	if !b.hasTexCoord {
+		b.nd.TexCoords = nil
+	}
+	if !b.hasTexCoord2 {
+		b.nd.TexCoords2 = nil
+	}
+	return b.nd, nil
+}
+
+func isIdentity(mt *dmat.T) bool {
+	return *mt == dmat.Ident
+}
+
+func sameTransforms(a, b []*dmat.T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (imp *gltfImporter) mesh(mi uint32, occs []*gltfOccurrence) error {
+	nd, err := imp.node(mi)
+	if err != nil {
+		return err
+	}
+	if len(occs) == 1 && occs[0].gpu == nil {
+		occ := occs[0]
+		if imp.opts.KeepTransforms {
+			if !isIdentity(occ.world) {
+				mt := *occ.world
+				nd.Mat = &mt
+			}
+		} else if !isIdentity(occ.world) {
+			props := nd.Props
+			nd = TransformNode(nd, occ.world, nil)
+			nd.Props = props
+		}
+		if len(occ.extras) > 0 {
+			nd.Props = nd.Props.Merge(occ.extras)
+		}
+		nd.Name = imp.doc.Nodes[occ.node].Name
+		if nd.Name == "" {
+			nd.Name = imp.doc.Meshes[mi].Name
+		}
+		imp.nodes[occ.node] = uint32(len(imp.ms.Nodes))
+		imp.animTargets[occ.node] = gltfAnimTarget{targetType: ANIMATION_TARGET_NODE, target: uint32(len(imp.ms.Nodes))}
+		imp.ms.Nodes = append(imp.ms.Nodes, nd)
+		return nil
+	}
+	var trans []*dmat.T
+	targets := make(map[uint32]uint32)
+	for _, occ := range occs {
+		if occ.gpu == nil {
+			targets[occ.node] = uint32(len(trans))
+			trans = append(trans, occ.world)
+			continue
+		}
+		for _, g := range occ.gpu {
+			mt := &dmat.T{}
+			mt.AssignMul(occ.world, g)
+			trans = append(trans, mt)
+		}
+	}
+	props := occs[0].extras
+	if n := len(imp.instances); n > 0 {
+		last := imp.instances[n-1]
+		if sameTransforms(last.trans, trans) && last.props.Equal(props) {
+			last.nodes = append(last.nodes, nd)
+			imp.instanceTargets(n-1, targets)
+			return nil
+		}
+	}
+	imp.instanceTargets(len(imp.instances), targets)
+	imp.instances = append(imp.instances, &gltfInstance{trans: trans, props: props, nodes: []*MeshNode{nd}})
+	return nil
+}
+
+func (imp *gltfImporter) instanceTargets(inst int, targets map[uint32]uint32) {
+	for node, j := range targets {
+		imp.animTargets[node] = gltfAnimTarget{targetType: ANIMATION_TARGET_INSTANCE, target: uint32(inst), transform: j}
+	}
+}
+
+func (imp *gltfImporter) finishInstances() {
+	for _, p := range imp.instances {
+		bm := subBaseMesh(&BaseMesh{Materials: imp.ms.Materials}, p.nodes)
+		bx := baseMeshBBox(bm)
+		imp.ms.InstanceNode = append(imp.ms.InstanceNode, &InstanceMesh{Transfors: p.trans, BBox: &bx, Mesh: bm, Props: p.props})
+	}
+}
+
+func (imp *gltfImporter) hierarchy() {
+	mapped := make([]uint32, 0, len(imp.nodes))
+	for g := range imp.nodes {
+		mapped = append(mapped, g)
+	}
+	sort.Slice(mapped, func(i, j int) bool { return imp.nodes[mapped[i]] < imp.nodes[mapped[j]] })
+	linked := make(map[uint32]bool)
+	var link func(g uint32)
+	link = func(g uint32) {
+		if linked[g] {
+			return
+		}
+		linked[g] = true
+		p, ok := imp.parents[g]
+		if !ok {
+			return
+		}
+		pi, ok := imp.nodes[p]
+		if !ok {
+			pi = uint32(len(imp.ms.Nodes))
+			imp.nodes[p] = pi
+			imp.animTargets[p] = gltfAnimTarget{targetType: ANIMATION_TARGET_NODE, target: pi}
+			group := &MeshNode{Name: imp.doc.Nodes[p].Name}
+			if w := imp.worlds[p]; imp.opts.KeepTransforms && w != nil && !isIdentity(w) {
+				mt := *w
+				group.Mat = &mt
+			}
+			imp.ms.Nodes = append(imp.ms.Nodes, group)
+		}
+		imp.ms.Nodes[pi].Children = append(imp.ms.Nodes[pi].Children, imp.nodes[g])
+		link(p)
+	}
+	for _, g := range mapped {
+		link(g)
+	}
+}
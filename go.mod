@@ -1,11 +1,12 @@
 module github.com/flywave/go-mst
 
-go 1.13
+go 1.18
 
 require (
 	github.com/flywave/go-3jsbin v0.0.0-20211111233441-249df4b81129
 	github.com/flywave/go-proj v0.0.0-20211220121303-46dc797a5cd0
 	github.com/flywave/go3d v0.0.0-20220209071216-2c50e8b3e7ff
+	github.com/klauspost/compress v1.11.13
 	github.com/qmuntal/gltf v0.20.3
 	github.com/xtgo/uuid v0.0.0-20140804021211-a0b114877d4c
 	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
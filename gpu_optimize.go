@@ -0,0 +1,433 @@
+package mst
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+type GPUOptimizeOptions struct {
+	CacheSize         int
+	OverdrawThreshold float64
+}
+
+var DefaultGPUOptimizeOptions = GPUOptimizeOptions{CacheSize: 16, OverdrawThreshold: 1.05}
+
+const (
+	forsythCacheDecayPower   = 1.5
+	forsythLastTriScore      = 0.75
+	forsythValenceBoostScale = 2.0
+	forsythValenceBoostPower = 0.5
+)
+
+func (n *MeshNode) OptimizeForGPU() error {
+	return n.OptimizeForGPUWithOptions(&DefaultGPUOptimizeOptions)
+}
+
+func (n *MeshNode) OptimizeForGPUWithOptions(opts *GPUOptimizeOptions) error {
+	if opts == nil {
+		opts = &DefaultGPUOptimizeOptions
+	}
+	if opts.CacheSize < 4 || opts.OverdrawThreshold < 1 {
+		return errors.New("mst: gpu optimize needs a cache size of at least 4 and an overdraw threshold of at least 1")
+	}
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if err := n.validateFace(f); err != nil {
+				return err
+			}
+		}
+	}
+	if err := n.validateVertexIndices(); err != nil {
+		return err
+	}
+	for _, g := range n.FaceGroup {
+		order := forsythOrder(g.Faces, opts.CacheSize)
+		order = n.overdrawOrder(g.Faces, order, opts)
+		g.permuteFaces(order)
+	}
+	n.reorderVertexFetch()
+	return nil
+}
+
+func (g *MeshTriangle) permuteFaces(order []int) {
+	faces := make([]*Face, len(order))
+	for i, j := range order {
+		faces[i] = g.Faces[j]
+	}
+	if len(g.Features) > 0 {
+		g.expandFeatures()
+		features := make([]uint64, len(order))
+		for i, j := range order {
+			features[i] = g.Features[j]
+		}
+		g.Features = features
+	}
+	g.Faces = faces
+}
+
+func forsythScore(pos, live, cacheSize int) float64 {
+	if live == 0 {
+		return -1
+	}
+	score := 0.0
+	switch {
+	case pos < 0:
+	case pos < 3:
+		score = forsythLastTriScore
+	default:
+		score = math.Pow(1-float64(pos-3)/float64(cacheSize-3), forsythCacheDecayPower)
+	}
+	return score + forsythValenceBoostScale*math.Pow(float64(live), -forsythValenceBoostPower)
+}
+
+func forsythOrder(faces []*Face, cacheSize int) []int {
+	local := make(map[uint32]int)
+	tris := make([][3]int, len(faces))
+	for i, f := range faces {
+		for k, v := range f.Vertex {
+			id, ok := local[v]
+			if !ok {
+				id = len(local)
+				local[v] = id
+			}
+			tris[i][k] = id
+		}
+	}
+	nv := len(local)
+	live := make([]int, nv)
+	for _, t := range tris {
+		for _, v := range t {
+			live[v]++
+		}
+	}
+	offset := make([]int, nv+1)
+	for v := 0; v < nv; v++ {
+		offset[v+1] = offset[v] + live[v]
+	}
+	adj := make([]int, offset[nv])
+	fill := append([]int(nil), offset[:nv]...)
+	for i, t := range tris {
+		for _, v := range t {
+			adj[fill[v]] = i
+			fill[v]++
+		}
+	}
+	pos := make([]int, nv)
+	score := make([]float64, nv)
+	for v := range pos {
+		pos[v] = -1
+		score[v] = forsythScore(-1, live[v], cacheSize)
+	}
+	emitted := make([]bool, len(tris))
+	triScore := make([]float64, len(tris))
+	for i, t := range tris {
+		triScore[i] = score[t[0]] + score[t[1]] + score[t[2]]
+	}
+	order := make([]int, 0, len(tris))
+	cache := make([]int, 0, cacheSize+3)
+	next := make([]int, 0, cacheSize+3)
+	cursor := 0
+	best := -1
+	for len(order) < len(tris) {
+		if best < 0 {
+			for emitted[cursor] {
+				cursor++
+			}
+			best = cursor
+		}
+		emitted[best] = true
+		order = append(order, best)
+		t := tris[best]
+		next = append(next[:0], t[0], t[1], t[2])
+		for _, v := range t {
+			last := offset[v] + live[v] - 1
+			for j := offset[v]; j <= last; j++ {
+				if adj[j] == best {
+					adj[j], adj[last] = adj[last], adj[j]
+					break
+				}
+			}
+			live[v]--
+		}
+		for _, v := range cache {
+			if v != t[0] && v != t[1] && v != t[2] {
+				next = append(next, v)
+			}
+		}
+		for _, v := range cache {
+			pos[v] = -1
+		}
+		cache, next = next, cache
+		for i, v := range cache {
+			if i < cacheSize {
+				pos[v] = i
+			}
+		}
+		for _, v := range cache {
+			score[v] = forsythScore(pos[v], live[v], cacheSize)
+		}
+		best = -1
+		bestScore := -1.0
+		for _, v := range cache {
+			for j := offset[v]; j < offset[v]+live[v]; j++ {
+				tri := adj[j]
+				tt := tris[tri]
+				triScore[tri] = score[tt[0]] + score[tt[1]] + score[tt[2]]
+				if triScore[tri] > bestScore {
+					best, bestScore = tri, triScore[tri]
+				}
+			}
+		}
+		if len(cache) > cacheSize {
+			cache = cache[:cacheSize]
+		}
+	}
+	return order
+}
+
+type vertexCache struct {
+	size  int
+	time  int
+	stamp map[uint32]int
+}
+
+func newVertexCache(size int) *vertexCache {
+	return &vertexCache{size: size, time: size + 1, stamp: make(map[uint32]int)}
+}
+
+func (c *vertexCache) misses(f *Face) int {
+	m := 0
+	for _, v := range f.Vertex {
+		if t, ok := c.stamp[v]; !ok || c.time-t > c.size {
+			c.stamp[v] = c.time
+			c.time++
+			m++
+		}
+	}
+	return m
+}
+
+func (c *vertexCache) reset() {
+	c.time += c.size + 1
+}
+
+func (n *MeshNode) ACMR(cacheSize int) float64 {
+	c := newVertexCache(cacheSize)
+	misses, faces := 0, 0
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			misses += c.misses(f)
+			faces++
+		}
+	}
+	if faces == 0 {
+		return 0
+	}
+	return float64(misses) / float64(faces)
+}
+
+func (n *MeshNode) overdrawOrder(faces []*Face, order []int, opts *GPUOptimizeOptions) []int {
+	if len(order) < 2 {
+		return order
+	}
+	c := newVertexCache(opts.CacheSize)
+	var hard []int
+	for i, j := range order {
+		if c.misses(faces[j]) == 3 {
+			hard = append(hard, i)
+		}
+	}
+	hard = append(hard, len(order))
+	var clusters []int
+	for h := 0; h+1 < len(hard); h++ {
+		start, end := hard[h], hard[h+1]
+		c.reset()
+		total := 0
+		for i := start; i < end; i++ {
+			total += c.misses(faces[order[i]])
+		}
+		limit := opts.OverdrawThreshold * float64(total) / float64(end-start)
+		c.reset()
+		clusters = append(clusters, start)
+		soft, misses := start, 0
+		for i := start; i < end; i++ {
+			misses += c.misses(faces[order[i]])
+			if i+1 < end && float64(misses)/float64(i+1-soft) <= limit {
+				clusters = append(clusters, i+1)
+				soft, misses = i+1, 0
+				c.reset()
+			}
+		}
+	}
+	clusters = append(clusters, len(order))
+
+	var center vec3.T
+	var area float64
+	type cluster struct {
+		start, end int
+		center     vec3.T
+		normal     vec3.T
+		area       float64
+	}
+	cs := make([]cluster, len(clusters)-1)
+	for k := range cs {
+		cl := &cs[k]
+		cl.start, cl.end = clusters[k], clusters[k+1]
+		for i := cl.start; i < cl.end; i++ {
+			v := faces[order[i]].Vertex
+			a, b, cc := n.Vertices[v[0]], n.Vertices[v[1]], n.Vertices[v[2]]
+			e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&cc, &a)
+			nm := vec3.Cross(&e1, &e2)
+			w := float64(nm.Length()) / 2
+			mid := vec3.Add(&a, &b)
+			mid = vec3.Add(&mid, &cc)
+			mid.Scale(float32(w / 3))
+			cl.center.Add(&mid)
+			cl.normal.Add(&nm)
+			cl.area += w
+		}
+		center.Add(&cl.center)
+		area += cl.area
+	}
+	if area == 0 {
+		return order
+	}
+	center.Scale(float32(1 / area))
+	key := make([]float64, len(cs))
+	for k := range cs {
+		cl := &cs[k]
+		if cl.area == 0 {
+			continue
+		}
+		cl.center.Scale(float32(1 / cl.area))
+		d := vec3.Sub(&cl.center, &center)
+		if l := cl.normal.Length(); l > 0 {
+			cl.normal.Scale(1 / l)
+		}
+		key[k] = float64(vec3.Dot(&d, &cl.normal))
+	}
+	idx := make([]int, len(cs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return key[idx[i]] > key[idx[j]] })
+	out := make([]int, 0, len(order))
+	for _, k := range idx {
+		out = append(out, order[cs[k].start:cs[k].end]...)
+	}
+	return out
+}
+
+func (n *MeshNode) reorderVertexFetch() {
+	nv := len(n.Vertices)
+	remap := make([]uint32, nv)
+	for i := range remap {
+		remap[i] = math.MaxUint32
+	}
+	next := uint32(0)
+	use := func(v uint32) {
+		if remap[v] == math.MaxUint32 {
+			remap[v] = next
+			next++
+		}
+	}
+	sharedNormals, sharedUvs := true, true
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for _, v := range f.Vertex {
+				use(v)
+			}
+			sharedNormals = sharedNormals && (f.Normal == nil || *f.Normal == f.Vertex)
+			sharedUvs = sharedUvs && (f.Uv == nil || *f.Uv == f.Vertex)
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		for _, e := range g.Edges {
+			use(e[0])
+			use(e[1])
+		}
+	}
+	for _, g := range n.PolygonGroup {
+		for _, p := range g.Polygons {
+			for _, ring := range p.rings() {
+				for _, v := range ring {
+					use(v)
+				}
+			}
+		}
+	}
+	for v := range remap {
+		use(uint32(v))
+	}
+	identity := true
+	for v, r := range remap {
+		identity = identity && uint32(v) == r
+	}
+	if identity {
+		return
+	}
+	permute3 := func(src []vec3.T) []vec3.T {
+		if len(src) != nv {
+			return src
+		}
+		out := make([]vec3.T, nv)
+		for i, r := range remap {
+			out[r] = src[i]
+		}
+		return out
+	}
+	n.Vertices = permute3(n.Vertices)
+	if sharedNormals {
+		n.Normals = permute3(n.Normals)
+	}
+	if sharedUvs && len(n.TexCoords) == nv {
+		uvs := make([]vec2.T, nv)
+		for i, r := range remap {
+			uvs[r] = n.TexCoords[i]
+		}
+		n.TexCoords = uvs
+	}
+	if len(n.TexCoords2) == nv {
+		uvs := make([]vec2.T, nv)
+		for i, r := range remap {
+			uvs[r] = n.TexCoords2[i]
+		}
+		n.TexCoords2 = uvs
+	}
+	if len(n.Colors) == nv {
+		cls := make([][3]byte, nv)
+		for i, r := range remap {
+			cls[r] = n.Colors[i]
+		}
+		n.Colors = cls
+	}
+	for _, t := range n.MorphTargets {
+		t.Positions = permute3(t.Positions)
+		t.Normals = permute3(t.Normals)
+	}
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for k, v := range f.Vertex {
+				f.Vertex[k] = remap[v]
+			}
+			if sharedNormals && f.Normal != nil {
+				nm := f.Vertex
+				f.Normal = &nm
+			}
+			if sharedUvs && f.Uv != nil {
+				uv := f.Vertex
+				f.Uv = &uv
+			}
+		}
+	}
+	for _, g := range n.EdgeGroup {
+		for i, e := range g.Edges {
+			g.Edges[i] = [2]uint32{remap[e[0]], remap[e[1]]}
+		}
+	}
+	n.remapPolygonVertices(func(v uint32) uint32 { return remap[v] })
+}
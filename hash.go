@@ -0,0 +1,139 @@
+package mst
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"math"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+const GEOMETRY_HASH_PRECISION = 1e-6
+
+type geometryHasher struct {
+	hash.Hash64
+	precision float64
+	buf       [8]byte
+}
+
+func newGeometryHasher(precision float64) *geometryHasher {
+	return &geometryHasher{Hash64: fnv.New64a(), precision: precision}
+}
+
+func (h *geometryHasher) put(v uint64) {
+	binary.LittleEndian.PutUint64(h.buf[:], v)
+	h.Write(h.buf[:])
+}
+
+func (h *geometryHasher) quantize(f float32) {
+	h.put(uint64(int64(math.Round(float64(f) / h.precision))))
+}
+
+func (h *geometryHasher) triple(v [3]uint32) {
+	h.put(uint64(v[0])<<32 | uint64(v[1]))
+	h.put(uint64(v[2]))
+}
+
+func (h *geometryHasher) node(nd *MeshNode, origin vec3.T) {
+	h.put(uint64(len(nd.Vertices)))
+	for _, v := range nd.Vertices {
+		h.quantize(v[0] - origin[0])
+		h.quantize(v[1] - origin[1])
+		h.quantize(v[2] - origin[2])
+	}
+	h.put(uint64(len(nd.Normals)))
+	for _, n := range nd.Normals {
+		h.quantize(n[0])
+		h.quantize(n[1])
+		h.quantize(n[2])
+	}
+	h.put(uint64(len(nd.TexCoords)))
+	for _, uv := range nd.TexCoords {
+		h.quantize(uv[0])
+		h.quantize(uv[1])
+	}
+	if len(nd.TexCoords2) > 0 {
+		h.put(uint64(len(nd.TexCoords2)))
+		for _, uv := range nd.TexCoords2 {
+			h.quantize(uv[0])
+			h.quantize(uv[1])
+		}
+	}
+	if nd.Lightmap != nil {
+		h.put(nd.Lightmap.Size[0]<<32 | nd.Lightmap.Size[1])
+		h.Write(nd.Lightmap.Data)
+	}
+	h.put(uint64(len(nd.Colors)))
+	for _, cl := range nd.Colors {
+		h.Write(cl[:])
+	}
+	if nd.Mat != nil {
+		for _, v := range matToArray(nd.Mat) {
+			h.put(math.Float64bits(v))
+		}
+	}
+	for _, g := range nd.FaceGroup {
+		h.put(uint64(g.Batchid))
+		h.put(uint64(len(g.Faces)))
+		for _, f := range g.Faces {
+			h.triple(f.Vertex)
+			if f.Material != nil {
+				h.put(uint64(*f.Material))
+			}
+		}
+		if g.HasFeatures() {
+			faceFeaturesMarshal(h, g)
+		}
+		if mode := g.PrimitiveMode(); mode != MESH_TRIANGLE_MODE_TRIANGLES {
+			h.put(uint64(mode))
+		}
+	}
+	for _, g := range nd.PolygonGroup {
+		h.put(uint64(g.Batchid))
+		for _, p := range g.Polygons {
+			h.put(uint64(len(p.Holes)))
+			for _, ring := range p.rings() {
+				h.put(uint64(len(ring)))
+				for _, v := range ring {
+					h.put(uint64(v))
+				}
+			}
+		}
+	}
+	for _, g := range nd.EdgeGroup {
+		h.put(uint64(g.Batchid))
+		for _, e := range g.Edges {
+			h.put(uint64(e[0])<<32 | uint64(e[1]))
+		}
+		if g.styled() {
+			outlineStyleMarshal(h, g)
+		}
+	}
+	if len(nd.MorphTargets) > 0 {
+		morphTargetsMarshal(h, nd.MorphTargets)
+	}
+}
+
+func ComputeMeshHash(node *MeshNode) uint64 {
+	h := newGeometryHasher(GEOMETRY_HASH_PRECISION)
+	h.node(node, vec3.T{})
+	return h.Sum64()
+}
+
+func ComputeBaseMeshHash(ms *BaseMesh) uint64 {
+	h := newGeometryHasher(GEOMETRY_HASH_PRECISION)
+	h.put(uint64(len(ms.Materials)))
+	for _, mtl := range ms.Materials {
+		MaterialMarshal(h, mtl, V14)
+		if name := MaterialName(mtl); name != "" {
+			h.Write([]byte(name))
+		}
+	}
+	h.put(uint64(len(ms.Nodes)))
+	for _, nd := range ms.Nodes {
+		h.node(nd, vec3.T{})
+	}
+	h.put(uint64(ms.Code))
+	return h.Sum64()
+}
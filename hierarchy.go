@@ -0,0 +1,169 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalidHierarchy = errors.New("mst: invalid node hierarchy")
+
+func inHierarchy(nd *MeshNode) bool {
+	return nd.Name != "" || len(nd.Children) > 0
+}
+
+func hasChildren(nds []*MeshNode) bool {
+	for _, nd := range nds {
+		if len(nd.Children) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeParents(nds []*MeshNode) []int {
+	parents := make([]int, len(nds))
+	for i := range parents {
+		parents[i] = -1
+	}
+	for i, nd := range nds {
+		for _, c := range nd.Children {
+			if int(c) < len(parents) && parents[c] < 0 {
+				parents[c] = i
+			}
+		}
+	}
+	return parents
+}
+
+func validateHierarchy(nds []*MeshNode) error {
+	parents := make([]int, len(nds))
+	for i := range parents {
+		parents[i] = -1
+	}
+	for i, nd := range nds {
+		for _, c := range nd.Children {
+			if int(c) >= len(nds) {
+				return &IndexError{Kind: "child node", Index: c, Count: len(nds)}
+			}
+			if parents[c] >= 0 || int(c) == i {
+				return &AssetError{Field: fmt.Sprintf("nodes[%d]", c), Err: ErrInvalidHierarchy}
+			}
+			parents[c] = i
+		}
+	}
+	seen := 0
+	var visit func(i int)
+	visit = func(i int) {
+		seen++
+		for _, c := range nds[i].Children {
+			visit(int(c))
+		}
+	}
+	for i, p := range parents {
+		if p < 0 {
+			visit(i)
+		}
+	}
+	if seen != len(nds) {
+		return ErrInvalidHierarchy
+	}
+	return nil
+}
+
+func (m *BaseMesh) ValidateHierarchy() error {
+	return validateHierarchy(m.Nodes)
+}
+
+func (m *BaseMesh) Parents() []int {
+	return nodeParents(m.Nodes)
+}
+
+func (m *BaseMesh) Roots() []int {
+	var roots []int
+	for i, p := range nodeParents(m.Nodes) {
+		if p < 0 {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+func (m *BaseMesh) Walk(fn func(index, parent int, nd *MeshNode) error) error {
+	if err := m.ValidateHierarchy(); err != nil {
+		return err
+	}
+	var visit func(i, parent int) error
+	visit = func(i, parent int) error {
+		if err := fn(i, parent, m.Nodes[i]); err != nil {
+			return err
+		}
+		for _, c := range m.Nodes[i].Children {
+			if err := visit(int(c), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range m.Roots() {
+		if err := visit(r, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *BaseMesh) FindByName(name string) (int, *MeshNode) {
+	for i, nd := range m.Nodes {
+		if nd.Name == name {
+			return i, nd
+		}
+	}
+	return -1, nil
+}
+
+func remapHierarchy(nds []*MeshNode, index []int) {
+	for i, nd := range nds {
+		if len(nd.Children) == 0 {
+			continue
+		}
+		cp := *nd
+		cp.Children = make([]uint32, 0, len(nd.Children))
+		for _, c := range nd.Children {
+			if int(c) < len(index) && index[c] >= 0 {
+				cp.Children = append(cp.Children, uint32(index[c]))
+			}
+		}
+		nds[i] = &cp
+	}
+}
+
+func shiftChildren(children []uint32, base int) []uint32 {
+	if children == nil {
+		return nil
+	}
+	out := make([]uint32, len(children))
+	for i, c := range children {
+		out[i] = c + uint32(base)
+	}
+	return out
+}
+
+func hierarchyMarshal(wt io.Writer, nd *MeshNode) {
+	writeLittleByte(wt, uint32(len(nd.Name)))
+	wt.Write([]byte(nd.Name))
+	writeLittleByte(wt, uint32(len(nd.Children)))
+	writeLittleByte(wt, nd.Children)
+}
+
+func (d *decoder) hierarchy(nd *MeshNode) {
+	nd.Name = d.string("node name length")
+	if n := d.count("node child count", func(l *DecodeLimits) uint32 { return l.MaxNodes }); n > 0 {
+		nd.Children = d.uint32s(n)
+	}
+}
+
+func (d *decoder) skipHierarchy() {
+	d.skip(int64(d.count("node name length", func(l *DecodeLimits) uint32 { return l.MaxNameLength })))
+	d.skip(4 * int64(d.count("node child count", func(l *DecodeLimits) uint32 { return l.MaxNodes })))
+}
@@ -0,0 +1,98 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	INDEX_WIDTH_AUTO = 0
+	INDEX_WIDTH_16   = 2
+	INDEX_WIDTH_32   = 4
+)
+
+// RequiredIndexWidth is the narrowest width able to address vertices. Face
+// indices are uint32, so 32 bits is the widest width.
+func RequiredIndexWidth(vertices int) uint8 {
+	if vertices <= math.MaxUint16+1 {
+		return INDEX_WIDTH_16
+	}
+	return INDEX_WIDTH_32
+}
+
+func (n *MeshNode) GetIndexWidth() uint8 {
+	need := RequiredIndexWidth(n.attributeCount())
+	if n.IndexWidth > need {
+		return n.IndexWidth
+	}
+	return need
+}
+
+func (n *MeshNode) attributeCount() int {
+	c := len(n.Vertices)
+	for _, l := range []int{len(n.Normals), len(n.Colors), len(n.TexCoords)} {
+		if l > c {
+			c = l
+		}
+	}
+	return c
+}
+
+func validIndexWidth(w uint8) bool {
+	return w == INDEX_WIDTH_16 || w == INDEX_WIDTH_32
+}
+
+func writeIndices(wt io.Writer, idx []uint32, width uint8) {
+	if width != INDEX_WIDTH_16 {
+		writeLittleByte(wt, idx)
+		return
+	}
+	var small [3]uint16
+	buf := small[:0]
+	if len(idx) > len(small) {
+		buf = make([]uint16, 0, len(idx))
+	}
+	for _, v := range idx {
+		buf = append(buf, uint16(v))
+	}
+	writeLittleByte(wt, buf)
+}
+
+func (d *decoder) indexWidth() uint8 {
+	if !d.caps().IndexWidth {
+		return INDEX_WIDTH_32
+	}
+	var w uint8
+	if d.read(&w) && !validIndexWidth(w) {
+		d.fail(fmt.Errorf("mst: invalid index width %d", w))
+	}
+	return w
+}
+
+func (d *decoder) indices(dst []uint32, width uint8) {
+	if width != INDEX_WIDTH_16 {
+		d.read(dst)
+		return
+	}
+	var buf [3]uint16
+	if d.read(buf[:len(dst)]) {
+		for i := range dst {
+			dst[i] = uint32(buf[i])
+		}
+	}
+}
+
+func (d *decoder) indexList(n int, width uint8) []uint32 {
+	idx := make([]uint32, 0, capHint(n))
+	var chunk [3]uint32
+	for i := 0; i < n && d.err == nil; i += len(chunk) {
+		k := len(chunk)
+		if n-i < k {
+			k = n - i
+		}
+		d.indices(chunk[:k], width)
+		idx = append(idx, chunk[:k]...)
+	}
+	return idx
+}
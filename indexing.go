@@ -0,0 +1,37 @@
+package mst
+
+const (
+	INDEXING_MODE_AUTO      = 0
+	INDEXING_MODE_SHARED    = 1
+	INDEXING_MODE_SEPARATE  = 2
+	INDEXING_MODE_FLATTENED = 3
+)
+
+func (n *MeshNode) DetectIndexingMode() uint8 {
+	var faces int
+	sequential := true
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if (f.Normal != nil && *f.Normal != f.Vertex) || (f.Uv != nil && *f.Uv != f.Vertex) {
+				return INDEXING_MODE_SEPARATE
+			}
+			base := uint32(faces * 3)
+			if f.Vertex != [3]uint32{base, base + 1, base + 2} {
+				sequential = false
+			}
+			faces++
+		}
+	}
+	parallel := func(l int) bool { return l == 0 || l == len(n.Vertices) }
+	if faces > 0 && sequential && len(n.Vertices) == faces*3 && parallel(len(n.Normals)) && parallel(len(n.TexCoords)) && parallel(len(n.TexCoords2)) {
+		return INDEXING_MODE_FLATTENED
+	}
+	return INDEXING_MODE_SHARED
+}
+
+func (n *MeshNode) GetIndexingMode() uint8 {
+	if n.IndexingMode != INDEXING_MODE_AUTO {
+		return n.IndexingMode
+	}
+	return n.DetectIndexingMode()
+}
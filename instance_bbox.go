@@ -0,0 +1,86 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+func transformBBox(bx *[6]float64, mt *dmat.T) [6]float64 {
+	box := dvec3.MinBox
+	for _, p := range boxCorners(bx) {
+		mt.TransformVec3(&p)
+		box.Extend(&p)
+	}
+	return [6]float64{box.Min[0], box.Min[1], box.Min[2], box.Max[0], box.Max[1], box.Max[2]}
+}
+
+func (inst *InstanceMesh) localBBox(opts *BBoxOptions) *[6]float64 {
+	if inst.Mesh != nil && len(inst.Mesh.Nodes) > 0 {
+		bx := (&Mesh{BaseMesh: *inst.Mesh}).ComputeBBoxWithOptions(opts)
+		return &[6]float64{bx.Min[0], bx.Min[1], bx.Min[2], bx.Max[0], bx.Max[1], bx.Max[2]}
+	}
+	return inst.BBox
+}
+
+func (inst *InstanceMesh) ComputePerInstanceBBoxes() [][6]float64 {
+	return inst.perInstanceBBoxes(nil)
+}
+
+func (inst *InstanceMesh) perInstanceBBoxes(opts *BBoxOptions) [][6]float64 {
+	local := inst.localBBox(opts)
+	if local == nil || local[0] > local[3] {
+		return nil
+	}
+	out := make([][6]float64, len(inst.Transfors))
+	for i, mt := range inst.Transfors {
+		out[i] = transformBBox(local, mt)
+	}
+	return out
+}
+
+func (m *Mesh) ComputePerInstanceBBoxes() {
+	for _, inst := range m.InstanceNode {
+		inst.Bounds = inst.ComputePerInstanceBBoxes()
+	}
+}
+
+func (inst *InstanceMesh) instanceBounds(opts *BBoxOptions) [][6]float64 {
+	if len(inst.Bounds) == len(inst.Transfors) {
+		return inst.Bounds
+	}
+	return inst.perInstanceBBoxes(opts)
+}
+
+func instanceBoundsMarshal(wt io.Writer, inst *InstanceMesh) {
+	if len(inst.Bounds) != len(inst.Transfors) {
+		writeLittleByte(wt, uint32(0))
+		return
+	}
+	writeLittleByte(wt, uint32(len(inst.Bounds)))
+	writeLittleByte(wt, inst.Bounds)
+}
+
+func (d *decoder) instanceBounds(inst *InstanceMesh) {
+	n := d.count("instance bounds count", func(l *DecodeLimits) uint32 { return l.MaxTransforms })
+	if n == 0 || d.err != nil {
+		return
+	}
+	if n != len(inst.Transfors) {
+		d.fail(fmt.Errorf("mst: %d instance bounds for %d transforms", n, len(inst.Transfors)))
+		return
+	}
+	inst.Bounds = make([][6]float64, n)
+	d.read(inst.Bounds)
+}
+
+func hasInstanceBounds(ms *Mesh) bool {
+	for _, inst := range ms.InstanceNode {
+		if len(inst.Bounds) > 0 && len(inst.Bounds) == len(inst.Transfors) {
+			return true
+		}
+	}
+	return false
+}
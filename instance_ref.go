@@ -0,0 +1,127 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+var ErrUnresolvedInstanceRef = errors.New("mst: instance references an unresolved prototype")
+
+type InstanceRef struct {
+	URI  string `json:"uri"`
+	Hash uint64 `json:"hash,omitempty"`
+}
+
+type PrototypeResolver interface {
+	ResolvePrototype(ref *InstanceRef) (*BaseMesh, error)
+}
+
+type PrototypeHashError struct {
+	URI      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *PrototypeHashError) Error() string {
+	return fmt.Sprintf("mst: prototype %s hash mismatch (expected %016x, got %016x)", e.URI, e.Expected, e.Actual)
+}
+
+func instanceRefMarshal(wt io.Writer, ref *InstanceRef) {
+	if ref == nil {
+		writeLittleByte(wt, uint8(0))
+		return
+	}
+	writeLittleByte(wt, uint8(1))
+	writeLittleByte(wt, uint32(len(ref.URI)))
+	wt.Write([]byte(ref.URI))
+	writeLittleByte(wt, ref.Hash)
+}
+
+func (d *decoder) instanceRef() *InstanceRef {
+	var has uint8
+	if !d.read(&has) || has == 0 {
+		return nil
+	}
+	ref := &InstanceRef{URI: d.string("instance reference uri length")}
+	d.read(&ref.Hash)
+	return ref
+}
+
+func hasUnresolvedInstanceRefs(ms *Mesh) bool {
+	for _, inst := range ms.InstanceNode {
+		if inst.Ref != nil && inst.Mesh == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func anyNode(ms *Mesh, fn func(nd *MeshNode) bool) bool {
+	for _, nd := range ms.Nodes {
+		if fn(nd) {
+			return true
+		}
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		for _, nd := range inst.Mesh.Nodes {
+			if fn(nd) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type FilePrototypeResolver struct {
+	Dir   string
+	mu    sync.Mutex
+	cache map[string]*BaseMesh
+}
+
+func NewFilePrototypeResolver(dir string) *FilePrototypeResolver {
+	return &FilePrototypeResolver{Dir: dir, cache: make(map[string]*BaseMesh)}
+}
+
+func (r *FilePrototypeResolver) ResolvePrototype(ref *InstanceRef) (*BaseMesh, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bm, ok := r.cache[ref.URI]; ok {
+		return bm, nil
+	}
+	path := ref.URI
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.Dir, filepath.FromSlash(path))
+	}
+	ms, err := MeshReadFrom(path)
+	if err != nil {
+		return nil, err
+	}
+	bm := &ms.BaseMesh
+	r.cache[ref.URI] = bm
+	return bm, nil
+}
+
+func (m *Mesh) ResolveInstanceRefs(r PrototypeResolver) error {
+	for _, inst := range m.InstanceNode {
+		if inst.Ref == nil || inst.Mesh != nil {
+			continue
+		}
+		bm, err := r.ResolvePrototype(inst.Ref)
+		if err != nil {
+			return err
+		}
+		if inst.Ref.Hash != 0 {
+			if h := ComputeBaseMeshHash(bm); h != inst.Ref.Hash {
+				return &PrototypeHashError{URI: inst.Ref.URI, Expected: inst.Ref.Hash, Actual: h}
+			}
+		}
+		inst.Mesh = bm
+	}
+	return nil
+}
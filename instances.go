@@ -0,0 +1,148 @@
+package mst
+
+import (
+	"errors"
+	"math"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrInvalidTolerance = errors.New("mst: tolerance must be positive")
+
+func nodeCentroid(nd *MeshNode) vec3.T {
+	var c [3]float64
+	for _, v := range nd.Vertices {
+		c[0] += float64(v[0])
+		c[1] += float64(v[1])
+		c[2] += float64(v[2])
+	}
+	if n := float64(len(nd.Vertices)); n > 0 {
+		c[0], c[1], c[2] = c[0]/n, c[1]/n, c[2]/n
+	}
+	return vec3.T{float32(c[0]), float32(c[1]), float32(c[2])}
+}
+
+func NodeHash(nd *MeshNode, tolerance float64) uint64 {
+	h := newGeometryHasher(tolerance)
+	h.node(nd, nodeCentroid(nd))
+	return h.Sum64()
+}
+
+func sameNodeGeometry(a, b *MeshNode, tolerance float64) bool {
+	if len(a.Vertices) != len(b.Vertices) || len(a.Normals) != len(b.Normals) || len(a.TexCoords) != len(b.TexCoords) ||
+		len(a.TexCoords2) != len(b.TexCoords2) || !sameLightmap(a.Lightmap, b.Lightmap) ||
+		len(a.Colors) != len(b.Colors) || len(a.FaceGroup) != len(b.FaceGroup) || len(a.EdgeGroup) != len(b.EdgeGroup) {
+		return false
+	}
+	near := func(x, y float32) bool { return math.Abs(float64(x-y)) <= tolerance }
+	ca, cb := nodeCentroid(a), nodeCentroid(b)
+	for i := range a.Vertices {
+		for k := 0; k < 3; k++ {
+			if !near(a.Vertices[i][k]-ca[k], b.Vertices[i][k]-cb[k]) {
+				return false
+			}
+		}
+	}
+	for i := range a.Normals {
+		for k := 0; k < 3; k++ {
+			if !near(a.Normals[i][k], b.Normals[i][k]) {
+				return false
+			}
+		}
+	}
+	for i := range a.TexCoords {
+		if !near(a.TexCoords[i][0], b.TexCoords[i][0]) || !near(a.TexCoords[i][1], b.TexCoords[i][1]) {
+			return false
+		}
+	}
+	for i := range a.TexCoords2 {
+		if !near(a.TexCoords2[i][0], b.TexCoords2[i][0]) || !near(a.TexCoords2[i][1], b.TexCoords2[i][1]) {
+			return false
+		}
+	}
+	for i := range a.Colors {
+		if a.Colors[i] != b.Colors[i] {
+			return false
+		}
+	}
+	for i, g := range a.FaceGroup {
+		o := b.FaceGroup[i]
+		if g.Batchid != o.Batchid || len(g.Faces) != len(o.Faces) {
+			return false
+		}
+		for j, f := range g.Faces {
+			if f.Vertex != o.Faces[j].Vertex || f.normalIndices() != o.Faces[j].normalIndices() || f.uvIndices() != o.Faces[j].uvIndices() ||
+				f.materialIndex(g.Batchid) != o.Faces[j].materialIndex(o.Batchid) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func DetectInstances(mesh *Mesh, tolerance float64) (*Mesh, error) {
+	if tolerance <= 0 {
+		return nil, ErrInvalidTolerance
+	}
+	type group struct {
+		hash  uint64
+		nodes []int
+	}
+	var groups []*group
+	byHash := make(map[uint64][]*group)
+	parents := nodeParents(mesh.Nodes)
+	for i, nd := range mesh.Nodes {
+		if len(nd.Props) > 0 || len(nd.MorphTargets) > 0 || len(nd.Vertices) == 0 || inHierarchy(nd) || parents[i] >= 0 {
+			groups = append(groups, &group{nodes: []int{i}})
+			continue
+		}
+		h := NodeHash(nd, tolerance)
+		var found *group
+		for _, g := range byHash[h] {
+			if sameNodeGeometry(mesh.Nodes[g.nodes[0]], nd, tolerance) {
+				found = g
+				break
+			}
+		}
+		if found == nil {
+			found = &group{hash: h}
+			byHash[h] = append(byHash[h], found)
+			groups = append(groups, found)
+		}
+		found.nodes = append(found.nodes, i)
+	}
+
+	out := *mesh
+	out.Nodes = nil
+	out.InstanceNode = append([]*InstanceMesh(nil), mesh.InstanceNode...)
+	index := make([]int, len(mesh.Nodes))
+	for _, g := range groups {
+		if len(g.nodes) < 2 {
+			index[g.nodes[0]] = len(out.Nodes)
+			out.Nodes = append(out.Nodes, mesh.Nodes[g.nodes[0]])
+			continue
+		}
+		proto := mesh.Nodes[g.nodes[0]]
+		c := nodeCentroid(proto)
+		origin := dmat.Ident
+		origin.SetTranslation(&dvec3.T{-float64(c[0]), -float64(c[1]), -float64(c[2])})
+		local := TransformNode(proto, &origin, nil)
+		inst := &InstanceMesh{
+			Mesh: subBaseMesh(&mesh.BaseMesh, []*MeshNode{local}),
+			Hash: g.hash,
+			BBox: local.GetBoundbox(),
+		}
+		for _, idx := range g.nodes {
+			nc := nodeCentroid(mesh.Nodes[idx])
+			mt := dmat.Ident
+			mt.SetTranslation(&dvec3.T{float64(nc[0]), float64(nc[1]), float64(nc[2])})
+			inst.Transfors = append(inst.Transfors, &mt)
+			inst.Features = append(inst.Features, uint64(idx))
+		}
+		out.InstanceNode = append(out.InstanceNode, inst)
+	}
+	remapHierarchy(out.Nodes, index)
+	return &out, nil
+}
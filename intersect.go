@@ -0,0 +1,123 @@
+package mst
+
+import (
+	"math"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+type IntersectOptions struct {
+	Tolerance   float64
+	MaxContacts int
+}
+
+var DefaultIntersectOptions = IntersectOptions{MaxContacts: 1024}
+
+type ContactPair struct {
+	A TriangleRef
+	B TriangleRef
+}
+
+// Intersects reports whether any triangle of a touches a triangle of b. Pairs
+// closer than Tolerance along every separating axis count as contacts, which
+// makes a positive tolerance a conservative clearance check. A MaxContacts of
+// zero collects every contact.
+func Intersects(a, b *Mesh, opts *IntersectOptions) (bool, []ContactPair) {
+	if opts == nil {
+		opts = &DefaultIntersectOptions
+	}
+	ta, err := meshTriangles(a)
+	if err != nil || len(ta) == 0 {
+		return false, nil
+	}
+	tb, err := meshTriangles(b)
+	if err != nil || len(tb) == 0 {
+		return false, nil
+	}
+	ba, bb := newBVH(ta), newBVH(tb)
+	tol := math.Max(opts.Tolerance, 0)
+	var out []ContactPair
+	stack := [][2]int{{0, 0}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		na, nb := &ba.nodes[p[0]], &bb.nodes[p[1]]
+		if !boxesOverlap(&na.box, &nb.box, tol) {
+			continue
+		}
+		switch {
+		case na.count == 0 && (nb.count != 0 || boxVolume(&na.box) >= boxVolume(&nb.box)):
+			stack = append(stack, [2]int{na.left, p[1]}, [2]int{na.right, p[1]})
+		case nb.count == 0:
+			stack = append(stack, [2]int{p[0], nb.left}, [2]int{p[0], nb.right})
+		default:
+			for i := na.start; i < na.start+na.count; i++ {
+				for j := nb.start; j < nb.start+nb.count; j++ {
+					if !trianglesOverlap(&ba.tris[i], &bb.tris[j], tol) {
+						continue
+					}
+					out = append(out, ContactPair{A: a.triangleRef(&ba.tris[i]), B: b.triangleRef(&bb.tris[j])})
+					if opts.MaxContacts > 0 && len(out) >= opts.MaxContacts {
+						return true, out
+					}
+				}
+			}
+		}
+	}
+	return len(out) > 0, out
+}
+
+func boxesOverlap(a, b *dvec3.Box, tol float64) bool {
+	for k := 0; k < 3; k++ {
+		if a.Min[k] > b.Max[k]+tol || b.Min[k] > a.Max[k]+tol {
+			return false
+		}
+	}
+	return true
+}
+
+func boxVolume(bx *dvec3.Box) float64 {
+	d := bx.Diagonal()
+	return d[0] * d[1] * d[2]
+}
+
+func projectTriangle(t *bvhTriangle, axis *dvec3.T) (float64, float64) {
+	lo := dvec3.Dot(&t.v[0], axis)
+	hi := lo
+	for k := 1; k < 3; k++ {
+		d := dvec3.Dot(&t.v[k], axis)
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+	}
+	return lo, hi
+}
+
+func trianglesOverlap(a, b *bvhTriangle, tol float64) bool {
+	var ea, eb [3]dvec3.T
+	for k := 0; k < 3; k++ {
+		ea[k] = dvec3.Sub(&a.v[(k+1)%3], &a.v[k])
+		eb[k] = dvec3.Sub(&b.v[(k+1)%3], &b.v[k])
+	}
+	na, nb := dvec3.Cross(&ea[0], &ea[1]), dvec3.Cross(&eb[0], &eb[1])
+	axes := []dvec3.T{na, nb}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			axes = append(axes, dvec3.Cross(&ea[i], &eb[j]))
+		}
+		// In-plane axes separate coplanar triangles, where every edge cross
+		// product collapses onto the shared normal.
+		axes = append(axes, dvec3.Cross(&na, &ea[i]), dvec3.Cross(&nb, &eb[i]))
+	}
+	for _, ax := range axes {
+		l := ax.Length()
+		if l < 1e-12 {
+			continue
+		}
+		ax.Scale(1 / l)
+		alo, ahi := projectTriangle(a, &ax)
+		blo, bhi := projectTriangle(b, &ax)
+		if alo > bhi+tol || blo > ahi+tol {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,394 @@
+package mst
+
+import (
+	"encoding/json"
+	"fmt"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+)
+
+// JSON schema of a mesh:
+//
+//	{
+//	  "version": 5,
+//	  "code": 0,
+//	  "materials": [{"type": "color"|"texture"|"pbr"|"lambert"|"phong"|"ref"|"unknown", ...material fields}],
+//	  "nodes": [{"vertices": [[x,y,z]], "faceGroup": [{"batchid": 0, "faces": [{"v": [a,b,c]}]}], "props": {}, ...}],
+//	  "instances": [{"transforms": [[16 floats, row major]], "features": [], "bbox": [6 floats], "mesh": {"materials", "nodes", "code"}, "hash": 0, "props": {}, "ref": {"uri", "hash"}}],
+//	  "props": {"key": {"type": "null"|"string"|"int"|"float"|"bool"|"array"|"map", "value": ...}}
+//	}
+//
+// Texture data is stored base64 encoded in the "data" field of each texture.
+
+var materialTypeNames = map[int]string{
+	MESH_TRIANGLE_MATERIAL_TYPE_COLOR:   "color",
+	MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE: "texture",
+	MESH_TRIANGLE_MATERIAL_TYPE_PBR:     "pbr",
+	MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT: "lambert",
+	MESH_TRIANGLE_MATERIAL_TYPE_PHONG:   "phong",
+
+	MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE: "ref",
+}
+
+const materialTypeUnknown = "unknown"
+
+var propTypeNames = map[int]string{
+	PROP_TYPE_NULL:   "null",
+	PROP_TYPE_STRING: "string",
+	PROP_TYPE_INT:    "int",
+	PROP_TYPE_FLOAT:  "float",
+	PROP_TYPE_BOOL:   "bool",
+	PROP_TYPE_ARRAY:  "array",
+	PROP_TYPE_MAP:    "map",
+}
+
+type jsonBaseMesh struct {
+	Materials []json.RawMessage `json:"materials,omitempty"`
+	Nodes     []*jsonNode       `json:"nodes,omitempty"`
+	Code      uint32            `json:"code,omitempty"`
+}
+
+type jsonNode struct {
+	*MeshNode
+	Props map[string]*jsonPropValue `json:"props,omitempty"`
+}
+
+type jsonInstance struct {
+	Transforms [][16]float64             `json:"transforms"`
+	Features   []uint64                  `json:"features,omitempty"`
+	BBox       *[6]float64               `json:"bbox,omitempty"`
+	Mesh       *jsonBaseMesh             `json:"mesh"`
+	Hash       uint64                    `json:"hash,omitempty"`
+	Props      map[string]*jsonPropValue `json:"props,omitempty"`
+	Ref        *InstanceRef              `json:"ref,omitempty"`
+	Bounds     [][6]float64              `json:"bounds,omitempty"`
+}
+
+type jsonMesh struct {
+	Version uint32 `json:"version"`
+	jsonBaseMesh
+	Instances  []*jsonInstance           `json:"instances,omitempty"`
+	Props      map[string]*jsonPropValue `json:"props,omitempty"`
+	Units      uint8                     `json:"units,omitempty"`
+	Animations []*Animation              `json:"animations,omitempty"`
+}
+
+type jsonPropValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func materialTypeOf(mtl MeshMaterial) int {
+	switch mtl.(type) {
+	case *TextureMaterial:
+		return MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE
+	case *PbrMaterial:
+		return MESH_TRIANGLE_MATERIAL_TYPE_PBR
+	case *LambertMaterial:
+		return MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT
+	case *PhongMaterial:
+		return MESH_TRIANGLE_MATERIAL_TYPE_PHONG
+	}
+	return MESH_TRIANGLE_MATERIAL_TYPE_COLOR
+}
+
+func MaterialMarshalJSON(mtl MeshMaterial) (json.RawMessage, error) {
+	if ref, ok := mtl.(*MaterialRef); ok {
+		return json.Marshal(map[string]interface{}{"type": materialTypeNames[MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE], "uri": ref.URI, "hash": ref.Hash})
+	}
+	if um, ok := OpaqueMaterial(mtl); ok {
+		return json.Marshal(map[string]interface{}{"type": materialTypeUnknown, "materialType": um.Type, "data": um.Data})
+	}
+	mtl = resolveMaterial(mtl)
+	bt, err := json.Marshal(mtl)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(bt, &fields); err != nil {
+		return nil, err
+	}
+	fields["type"], _ = json.Marshal(materialTypeNames[materialTypeOf(mtl)])
+	return json.Marshal(fields)
+}
+
+func MaterialUnmarshalJSON(data []byte) (MeshMaterial, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	var mtl MeshMaterial
+	switch head.Type {
+	case "color":
+		mtl = &BaseMaterial{}
+	case "texture":
+		mtl = &TextureMaterial{}
+	case "pbr":
+		mtl = &PbrMaterial{}
+	case "lambert":
+		mtl = &LambertMaterial{}
+	case "phong":
+		mtl = &PhongMaterial{}
+	case "ref":
+		mtl = &MaterialRef{}
+	case materialTypeUnknown:
+		mtl = &UnknownMaterial{}
+	default:
+		return nil, fmt.Errorf("mst: unknown material type %q", head.Type)
+	}
+	if err := json.Unmarshal(data, mtl); err != nil {
+		return nil, err
+	}
+	if um, ok := mtl.(*UnknownMaterial); ok {
+		return um.Decode()
+	}
+	return mtl, nil
+}
+
+func propValueToJSON(v interface{}) (*jsonPropValue, error) {
+	var ty int
+	var val interface{} = v
+	switch vv := v.(type) {
+	case nil:
+		return &jsonPropValue{Type: propTypeNames[PROP_TYPE_NULL]}, nil
+	case string:
+		ty = PROP_TYPE_STRING
+	case int, int32, int64, uint32, uint64:
+		ty = PROP_TYPE_INT
+	case float32, float64:
+		ty = PROP_TYPE_FLOAT
+	case bool:
+		ty = PROP_TYPE_BOOL
+	case []interface{}:
+		ty = PROP_TYPE_ARRAY
+		arr := make([]*jsonPropValue, len(vv))
+		for i, e := range vv {
+			jv, err := propValueToJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = jv
+		}
+		val = arr
+	case map[string]interface{}:
+		ty = PROP_TYPE_MAP
+		m, err := propsToJSON(Properties(vv))
+		if err != nil {
+			return nil, err
+		}
+		val = m
+	case Properties:
+		ty = PROP_TYPE_MAP
+		m, err := propsToJSON(vv)
+		if err != nil {
+			return nil, err
+		}
+		val = m
+	default:
+		ty = PROP_TYPE_STRING
+		val = fmt.Sprint(v)
+	}
+	bt, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonPropValue{Type: propTypeNames[ty], Value: bt}, nil
+}
+
+func propsToJSON(props Properties) (map[string]*jsonPropValue, error) {
+	if props == nil {
+		return nil, nil
+	}
+	out := make(map[string]*jsonPropValue, len(props))
+	for k, v := range props {
+		jv, err := propValueToJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = jv
+	}
+	return out, nil
+}
+
+func propValueFromJSON(jv *jsonPropValue) (interface{}, error) {
+	if jv == nil {
+		return nil, nil
+	}
+	switch jv.Type {
+	case "null":
+		return nil, nil
+	case "string":
+		var s string
+		err := json.Unmarshal(jv.Value, &s)
+		return s, err
+	case "int":
+		var i int64
+		err := json.Unmarshal(jv.Value, &i)
+		return i, err
+	case "float":
+		var f float64
+		err := json.Unmarshal(jv.Value, &f)
+		return f, err
+	case "bool":
+		var b bool
+		err := json.Unmarshal(jv.Value, &b)
+		return b, err
+	case "array":
+		var arr []*jsonPropValue
+		if err := json.Unmarshal(jv.Value, &arr); err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			v, err := propValueFromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case "map":
+		var m map[string]*jsonPropValue
+		if err := json.Unmarshal(jv.Value, &m); err != nil {
+			return nil, err
+		}
+		props, err := propsFromJSON(m)
+		return map[string]interface{}(props), err
+	}
+	return nil, fmt.Errorf("mst: unknown property type %q", jv.Type)
+}
+
+func propsFromJSON(m map[string]*jsonPropValue) (Properties, error) {
+	if m == nil {
+		return nil, nil
+	}
+	props := make(Properties, len(m))
+	for k, jv := range m {
+		v, err := propValueFromJSON(jv)
+		if err != nil {
+			return nil, err
+		}
+		props[k] = v
+	}
+	return props, nil
+}
+
+func baseMeshToJSON(ms *BaseMesh) (*jsonBaseMesh, error) {
+	out := &jsonBaseMesh{Code: ms.Code}
+	for _, nd := range ms.Nodes {
+		if nd == nil {
+			out.Nodes = append(out.Nodes, nil)
+			continue
+		}
+		props, err := propsToJSON(nd.Props)
+		if err != nil {
+			return nil, err
+		}
+		out.Nodes = append(out.Nodes, &jsonNode{MeshNode: nd, Props: props})
+	}
+	for _, mtl := range ms.Materials {
+		bt, err := MaterialMarshalJSON(mtl)
+		if err != nil {
+			return nil, err
+		}
+		out.Materials = append(out.Materials, bt)
+	}
+	return out, nil
+}
+
+func baseMeshFromJSON(jm *jsonBaseMesh) (*BaseMesh, error) {
+	ms := &BaseMesh{Code: jm.Code}
+	for _, jn := range jm.Nodes {
+		if jn == nil {
+			ms.Nodes = append(ms.Nodes, nil)
+			continue
+		}
+		nd := jn.MeshNode
+		if nd == nil {
+			nd = &MeshNode{}
+		}
+		props, err := propsFromJSON(jn.Props)
+		if err != nil {
+			return nil, err
+		}
+		nd.Props = props
+		ms.Nodes = append(ms.Nodes, nd)
+	}
+	for _, raw := range jm.Materials {
+		mtl, err := MaterialUnmarshalJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		ms.Materials = append(ms.Materials, mtl)
+	}
+	return ms, nil
+}
+
+func (m Mesh) MarshalJSON() ([]byte, error) {
+	bm, err := baseMeshToJSON(&m.BaseMesh)
+	if err != nil {
+		return nil, err
+	}
+	out := &jsonMesh{Version: m.Version, jsonBaseMesh: *bm, Units: m.Units, Animations: m.Animations}
+	if out.Props, err = propsToJSON(m.Props); err != nil {
+		return nil, err
+	}
+	for _, inst := range m.InstanceNode {
+		ji := &jsonInstance{Features: inst.Features, BBox: inst.BBox, Hash: inst.Hash, Ref: inst.Ref, Bounds: inst.Bounds}
+		for _, mt := range inst.Transfors {
+			ji.Transforms = append(ji.Transforms, matToArray(mt))
+		}
+		if inst.Mesh != nil {
+			if ji.Mesh, err = baseMeshToJSON(inst.Mesh); err != nil {
+				return nil, err
+			}
+		}
+		if ji.Props, err = propsToJSON(inst.Props); err != nil {
+			return nil, err
+		}
+		out.Instances = append(out.Instances, ji)
+	}
+	return json.Marshal(out)
+}
+
+func (m *Mesh) UnmarshalJSON(data []byte) error {
+	var jm jsonMesh
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+	bm, err := baseMeshFromJSON(&jm.jsonBaseMesh)
+	if err != nil {
+		return err
+	}
+	out := Mesh{BaseMesh: *bm, Version: jm.Version, Units: jm.Units, Animations: jm.Animations}
+	if out.Props, err = propsFromJSON(jm.Props); err != nil {
+		return err
+	}
+	for _, ji := range jm.Instances {
+		inst := &InstanceMesh{Features: ji.Features, BBox: ji.BBox, Hash: ji.Hash, Ref: ji.Ref, Bounds: ji.Bounds}
+		for i := range ji.Transforms {
+			inst.Transfors = append(inst.Transfors, arrayToMat(&ji.Transforms[i]))
+		}
+		if ji.Mesh != nil {
+			if inst.Mesh, err = baseMeshFromJSON(ji.Mesh); err != nil {
+				return err
+			}
+		}
+		if inst.Props, err = propsFromJSON(ji.Props); err != nil {
+			return err
+		}
+		out.InstanceNode = append(out.InstanceNode, inst)
+	}
+	*m = out
+	return nil
+}
+
+func arrayToMat(a *[16]float64) *dmat.T {
+	mt := &dmat.T{}
+	for i := 0; i < 4; i++ {
+		copy(mt[i][:], a[i*4:i*4+4])
+	}
+	return mt
+}
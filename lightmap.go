@@ -0,0 +1,206 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+var (
+	ErrNoLightmapUVs      = errors.New("mst: lightmaps need a second texture coordinate per vertex")
+	ErrInvalidSampleCount = errors.New("mst: sample count must be positive")
+)
+
+func (n *MeshNode) SetLightmap(tex *Texture) error {
+	if tex != nil && (len(n.TexCoords2) == 0 || len(n.TexCoords2) != len(n.Vertices)) {
+		return ErrNoLightmapUVs
+	}
+	n.Lightmap = tex
+	return nil
+}
+
+func (n *MeshNode) GetLightmap() *Texture {
+	return n.Lightmap
+}
+
+func sameLightmap(a, b *Texture) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	return a.Size == b.Size && a.Format == b.Format && a.Compressed == b.Compressed && string(a.Data) == string(b.Data)
+}
+
+func hasLightmaps(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool { return nd.Lightmap != nil })
+}
+
+func lightmapMarshal(wt io.Writer, nd *MeshNode, v uint32) {
+	if nd.Lightmap == nil {
+		writeLittleByte(wt, uint8(0))
+		return
+	}
+	writeLittleByte(wt, uint8(1))
+	TextureMarshal(wt, nd.Lightmap)
+	if FormatCapabilities(v).TextureURIs {
+		textureURIMarshal(wt, nd.Lightmap)
+	}
+	if FormatCapabilities(v).ColorSpaces {
+		textureColorSpaceMarshal(wt, nd.Lightmap)
+	}
+}
+
+func (d *decoder) lightmap(nd *MeshNode) {
+	var has uint8
+	if d.read(&has) && has == 1 {
+		nd.Lightmap = d.texture()
+		if d.caps().TextureURIs {
+			d.textureURI(nd.Lightmap)
+		}
+		if d.caps().ColorSpaces {
+			d.textureColorSpace(nd.Lightmap)
+		}
+	}
+}
+
+func dropLightmaps(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if nd.Lightmap == nil {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].lightmap", field, i), Message: "dropped lightmap texture"})
+		cp := *nd
+		cp.Lightmap = nil
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func (nd *MeshNode) worldPoints() []dvec3.T {
+	pts := nd.boundingPoints()
+	if nd.Mat != nil {
+		for i := range pts {
+			nd.Mat.TransformVec3(&pts[i])
+		}
+	}
+	return pts
+}
+
+func (nd *MeshNode) vertexNormals(pts []dvec3.T) []dvec3.T {
+	nls := make([]dvec3.T, len(pts))
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			e1 := dvec3.Sub(&pts[f.Vertex[1]], &pts[f.Vertex[0]])
+			e2 := dvec3.Sub(&pts[f.Vertex[2]], &pts[f.Vertex[0]])
+			fn := dvec3.Cross(&e1, &e2)
+			for _, v := range f.Vertex {
+				nls[v].Add(&fn)
+			}
+		}
+	}
+	for i := range nls {
+		if l := nls[i].Length(); l > 0 {
+			nls[i].Scale(1 / l)
+		}
+	}
+	return nls
+}
+
+func radicalInverse(i uint32) float64 {
+	i = (i << 16) | (i >> 16)
+	i = ((i & 0x55555555) << 1) | ((i & 0xAAAAAAAA) >> 1)
+	i = ((i & 0x33333333) << 2) | ((i & 0xCCCCCCCC) >> 2)
+	i = ((i & 0x0F0F0F0F) << 4) | ((i & 0xF0F0F0F0) >> 4)
+	i = ((i & 0x00FF00FF) << 8) | ((i & 0xFF00FF00) >> 8)
+	return float64(i) / (1 << 32)
+}
+
+func hemisphereSamples(samples int) []dvec3.T {
+	out := make([]dvec3.T, samples)
+	for k := range out {
+		u1, u2 := (float64(k)+0.5)/float64(samples), radicalInverse(uint32(k))
+		r, phi := math.Sqrt(u1), 2*math.Pi*u2
+		out[k] = dvec3.T{r * math.Cos(phi), r * math.Sin(phi), math.Sqrt(1 - u1)}
+	}
+	return out
+}
+
+func tangentFrame(n *dvec3.T) (dvec3.T, dvec3.T) {
+	up := dvec3.T{0, 0, 1}
+	if math.Abs(n[2]) > 0.9 {
+		up = dvec3.T{1, 0, 0}
+	}
+	t := dvec3.Cross(&up, n)
+	t.Normalize()
+	return t, dvec3.Cross(n, &t)
+}
+
+func (nd *MeshNode) bakeAO(b *bvh, samples []dvec3.T) {
+	bx := b.bounds()
+	diag := bx.Diagonal()
+	maxDist := diag.Length()
+	bias := math.Max(maxDist*1e-5, 1e-9)
+	pts := nd.worldPoints()
+	nls := nd.vertexNormals(pts)
+	colors := make([][3]byte, len(pts))
+	for i, p := range pts {
+		n := nls[i]
+		open := len(samples)
+		if !n.IsZero() {
+			t, s := tangentFrame(&n)
+			org := dvec3.T{p[0] + n[0]*bias, p[1] + n[1]*bias, p[2] + n[2]*bias}
+			for _, smp := range samples {
+				dir := dvec3.T{
+					t[0]*smp[0] + s[0]*smp[1] + n[0]*smp[2],
+					t[1]*smp[0] + s[1]*smp[1] + n[1]*smp[2],
+					t[2]*smp[0] + s[2]*smp[1] + n[2]*smp[2],
+				}
+				if _, hit := b.raycast(org, dir, maxDist, true); hit >= 0 {
+					open--
+				}
+			}
+		}
+		c := uint8(math.Round(255 * float64(open) / float64(len(samples))))
+		colors[i] = [3]byte{c, c, c}
+	}
+	nd.Colors = colors
+}
+
+func (n *MeshNode) BakeAOVertexColors(samples int) error {
+	if samples <= 0 {
+		return ErrInvalidSampleCount
+	}
+	tris, err := nodeTriangles(n, 0, nil)
+	if err != nil {
+		return err
+	}
+	n.bakeAO(newBVH(tris), hemisphereSamples(samples))
+	return nil
+}
+
+func (m *Mesh) BakeAOVertexColors(samples int) error {
+	if samples <= 0 {
+		return ErrInvalidSampleCount
+	}
+	var tris []bvhTriangle
+	for i, nd := range m.Nodes {
+		var err error
+		if tris, err = nodeTriangles(nd, i, tris); err != nil {
+			return &AssetError{Field: fmt.Sprintf("nodes[%d]", i), Err: err}
+		}
+	}
+	b, dirs := newBVH(tris), hemisphereSamples(samples)
+	for _, nd := range m.Nodes {
+		nd.bakeAO(b, dirs)
+	}
+	return nil
+}
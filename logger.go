@@ -0,0 +1,63 @@
+package mst
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	LOG_DEBUG = 0
+	LOG_INFO  = 1
+	LOG_WARN  = 2
+	LOG_ERROR = 3
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+type Logger interface {
+	Log(level uint8, msg string, keyvals ...interface{})
+}
+
+type LoggerFunc func(level uint8, msg string, keyvals ...interface{})
+
+func (f LoggerFunc) Log(level uint8, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func NewStdLogger(l *log.Logger) Logger {
+	return &stdLogger{l: l}
+}
+
+func (s *stdLogger) Log(level uint8, msg string, keyvals ...interface{}) {
+	var sb strings.Builder
+	if int(level) < len(logLevelNames) {
+		sb.WriteString(logLevelNames[level])
+	} else {
+		fmt.Fprintf(&sb, "level(%d)", level)
+	}
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&sb, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&sb, " %v=?", keyvals[i])
+		}
+	}
+	if s.l == nil {
+		log.Print(sb.String())
+		return
+	}
+	s.l.Print(sb.String())
+}
+
+func logWarn(l Logger, msg string, keyvals ...interface{}) {
+	if l != nil {
+		l.Log(LOG_WARN, msg, keyvals...)
+	}
+}
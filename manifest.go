@@ -0,0 +1,106 @@
+package mst
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+const MANIFEST_VERSION = 1
+
+const maxManifestSize = 64 * 1024
+
+var ErrNoManifest = errors.New("mst: file has no manifest")
+
+type Manifest struct {
+	ManifestVersion int          `json:"manifestVersion"`
+	Version         uint32       `json:"version"`
+	Materials       int          `json:"materials"`
+	Textures        int          `json:"textures"`
+	Nodes           int          `json:"nodes"`
+	Vertices        int          `json:"vertices"`
+	Faces           int          `json:"faces"`
+	Instances       int          `json:"instances"`
+	Transforms      int          `json:"transforms"`
+	BBox            *[6]float64  `json:"bbox,omitempty"`
+	Box             *[12]float64 `json:"box,omitempty"`
+	Sphere          *[4]float64  `json:"sphere,omitempty"`
+	CRS             string       `json:"crs,omitempty"`
+	ThumbnailHash   string       `json:"thumbnailHash,omitempty"`
+}
+
+func countBaseMesh(m *Manifest, ms *BaseMesh, copies int) {
+	for _, nd := range ms.Nodes {
+		m.Vertices += len(nd.Vertices) * copies
+		for _, g := range nd.FaceGroup {
+			m.Faces += len(g.Faces) * copies
+		}
+	}
+	for _, mtl := range ms.Materials {
+		if mtl.HasTexture() {
+			m.Textures++
+		}
+	}
+}
+
+func BuildManifest(ms *Mesh) *Manifest {
+	m := &Manifest{
+		ManifestVersion: MANIFEST_VERSION,
+		Version:         ms.Version,
+		Materials:       len(ms.Materials),
+		Nodes:           len(ms.Nodes),
+		Instances:       len(ms.InstanceNode),
+	}
+	countBaseMesh(m, &ms.BaseMesh, 1)
+	for _, inst := range ms.InstanceNode {
+		m.Transforms += len(inst.Transfors)
+		if inst.Mesh != nil {
+			countBaseMesh(m, inst.Mesh, len(inst.Transfors))
+		}
+	}
+	if len(ms.Nodes) > 0 {
+		bx := ms.ComputeBBox()
+		m.BBox = &[6]float64{bx.Min[0], bx.Min[1], bx.Min[2], bx.Max[0], bx.Max[1], bx.Max[2]}
+		box, sph := ms.ComputeOBB().TilesBox(), ms.ComputeBoundingSphere().TilesSphere()
+		m.Box, m.Sphere = &box, &sph
+	}
+	return m
+}
+
+func WithManifest(m *Manifest) WriteOption {
+	return func(o *writeOptions) {
+		if m == nil {
+			m = &Manifest{}
+		}
+		o.manifest = m
+	}
+}
+
+func (o *writeOptions) manifestBytes(ms *Mesh, v uint32) []byte {
+	if o.manifest == nil {
+		return nil
+	}
+	m := BuildManifest(ms)
+	m.Version = v
+	m.CRS = o.manifest.CRS
+	m.ThumbnailHash = o.manifest.ThumbnailHash
+	bt, _ := json.Marshal(m)
+	return bt
+}
+
+func MeshManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hdr, err := ReadMeshHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Manifest == nil {
+		return nil, ErrNoManifest
+	}
+	return hdr.Manifest, nil
+}
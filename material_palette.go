@@ -0,0 +1,335 @@
+package mst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	MESH_PALETTE_SIGNATURE = "fwmp"
+	MESH_PALETTE_EXT       = ".mstmat"
+)
+
+var (
+	ErrUnresolvedMaterialRef = errors.New("mst: material references an unresolved palette entry")
+	ErrInvalidPalette        = errors.New("mst: invalid material palette")
+)
+
+type MaterialRef struct {
+	URI      string       `json:"uri"`
+	Hash     uint64       `json:"hash"`
+	Material MeshMaterial `json:"-"`
+}
+
+func (m *MaterialRef) Resolve() (MeshMaterial, error) {
+	if m.Material == nil {
+		return nil, ErrUnresolvedMaterialRef
+	}
+	return resolveMaterial(m.Material), nil
+}
+
+func (m *MaterialRef) resolved() MeshMaterial {
+	mtl, err := m.Resolve()
+	if err != nil {
+		return &BaseMaterial{}
+	}
+	return mtl
+}
+
+func (m *MaterialRef) HasTexture() bool {
+	return m.resolved().HasTexture()
+}
+
+func (m *MaterialRef) GetTexture() *Texture {
+	return m.resolved().GetTexture()
+}
+
+func (m *MaterialRef) GetColor() [3]byte {
+	return m.resolved().GetColor()
+}
+
+func (m *MaterialRef) GetEmissive() [3]byte {
+	return m.resolved().GetEmissive()
+}
+
+type MaterialPaletteHashError struct {
+	URI  string
+	Hash uint64
+}
+
+func (e *MaterialPaletteHashError) Error() string {
+	return fmt.Sprintf("mst: material palette %s has no entry %016x", e.URI, e.Hash)
+}
+
+func MaterialHash(mtl MeshMaterial) uint64 {
+	h := fnv.New64a()
+	MaterialMarshal(h, resolveMaterial(mtl), MESH_LATEST_VERSION)
+	return h.Sum64()
+}
+
+type MaterialPalette struct {
+	Materials []MeshMaterial
+	Hashes    []uint64
+	index     map[uint64]int
+}
+
+func NewMaterialPalette() *MaterialPalette {
+	return &MaterialPalette{index: make(map[uint64]int)}
+}
+
+func (p *MaterialPalette) entries() map[uint64]int {
+	if p.index == nil {
+		p.index = make(map[uint64]int)
+		for i, h := range p.Hashes {
+			p.index[h] = i
+		}
+	}
+	return p.index
+}
+
+func (p *MaterialPalette) Add(mtl MeshMaterial) uint64 {
+	mtl = resolveMaterial(mtl)
+	h := MaterialHash(mtl)
+	if _, ok := p.entries()[h]; !ok {
+		p.index[h] = len(p.Materials)
+		p.Materials = append(p.Materials, mtl)
+		p.Hashes = append(p.Hashes, h)
+	}
+	return h
+}
+
+func (p *MaterialPalette) Lookup(hash uint64) (MeshMaterial, bool) {
+	i, ok := p.entries()[hash]
+	if !ok {
+		return nil, false
+	}
+	return p.Materials[i], true
+}
+
+func (p *MaterialPalette) ResolveMaterialRef(ref *MaterialRef) (MeshMaterial, error) {
+	mtl, ok := p.Lookup(ref.Hash)
+	if !ok {
+		return nil, &MaterialPaletteHashError{URI: ref.URI, Hash: ref.Hash}
+	}
+	return mtl, nil
+}
+
+type MaterialResolver interface {
+	ResolveMaterialRef(ref *MaterialRef) (MeshMaterial, error)
+}
+
+func MaterialPaletteMarshal(wt io.Writer, p *MaterialPalette) {
+	wt.Write([]byte(MESH_PALETTE_SIGNATURE))
+	writeLittleByte(wt, MESH_LATEST_VERSION)
+	writeLittleByte(wt, uint32(len(p.Materials)))
+	for i, mtl := range p.Materials {
+		writeLittleByte(wt, p.Hashes[i])
+		MaterialMarshal(wt, mtl, MESH_LATEST_VERSION)
+	}
+}
+
+func MaterialPaletteUnMarshal(rd io.Reader) (*MaterialPalette, error) {
+	sig := make([]byte, len(MESH_PALETTE_SIGNATURE))
+	if _, err := io.ReadFull(rd, sig); err != nil || string(sig) != MESH_PALETTE_SIGNATURE {
+		return nil, ErrInvalidPalette
+	}
+	d := newDecoder(rd, 0, &DefaultDecodeLimits)
+	if !d.read(&d.v) {
+		return nil, d.err
+	}
+	if !IsSupportedVersion(d.v) {
+		return nil, ErrUnsupportedVersion
+	}
+	p := NewMaterialPalette()
+	n := d.count("material count", func(l *DecodeLimits) uint32 { return l.MaxMaterials })
+	for i := 0; i < n && d.err == nil; i++ {
+		var h uint64
+		d.read(&h)
+		if mtl := d.material(); d.err == nil {
+			if _, ok := mtl.(*MaterialRef); ok {
+				return nil, ErrInvalidPalette
+			}
+			p.index[h] = len(p.Materials)
+			p.Materials = append(p.Materials, mtl)
+			p.Hashes = append(p.Hashes, h)
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return p, nil
+}
+
+func SaveMaterialPalette(path string, p *MaterialPalette) error {
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	MaterialPaletteMarshal(bw, p)
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func LoadMaterialPalette(path string) (*MaterialPalette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return MaterialPaletteUnMarshal(bufio.NewReader(f))
+}
+
+type FileMaterialResolver struct {
+	Dir   string
+	mu    sync.Mutex
+	cache map[string]*MaterialPalette
+}
+
+func NewFileMaterialResolver(dir string) *FileMaterialResolver {
+	return &FileMaterialResolver{Dir: dir, cache: make(map[string]*MaterialPalette)}
+}
+
+func (r *FileMaterialResolver) ResolveMaterialRef(ref *MaterialRef) (MeshMaterial, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.cache[ref.URI]
+	if !ok {
+		path := ref.URI
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.Dir, filepath.FromSlash(path))
+		}
+		var err error
+		if p, err = LoadMaterialPalette(path); err != nil {
+			return nil, err
+		}
+		r.cache[ref.URI] = p
+	}
+	return p.ResolveMaterialRef(ref)
+}
+
+func materialRefMarshal(wt io.Writer, ref *MaterialRef) {
+	writeLittleByte(wt, uint32(len(ref.URI)))
+	wt.Write([]byte(ref.URI))
+	writeLittleByte(wt, ref.Hash)
+}
+
+func (d *decoder) materialRef() *MaterialRef {
+	ref := &MaterialRef{URI: d.string("material reference uri length")}
+	d.read(&ref.Hash)
+	return ref
+}
+
+func hasMaterialRefs(ms *Mesh, unresolvedOnly bool) bool {
+	refs := func(mtls []MeshMaterial) bool {
+		for _, mtl := range mtls {
+			if ref, ok := mtl.(*MaterialRef); ok && (ref.Material == nil || !unresolvedOnly) {
+				return true
+			}
+		}
+		return false
+	}
+	if refs(ms.Materials) {
+		return true
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh != nil && refs(inst.Mesh.Materials) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mesh) UseMaterialPalette(p *MaterialPalette, uri string) {
+	share := func(mtls []MeshMaterial) {
+		for i, mtl := range mtls {
+			if _, ok := mtl.(*MaterialRef); ok {
+				continue
+			}
+			h := p.Add(mtl)
+			shared, _ := p.Lookup(h)
+			mtls[i] = &MaterialRef{URI: uri, Hash: h, Material: shared}
+		}
+	}
+	share(m.Materials)
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh != nil {
+			share(inst.Mesh.Materials)
+		}
+	}
+}
+
+func (m *Mesh) ResolveMaterialRefs(r MaterialResolver) error {
+	resolve := func(mtls []MeshMaterial) error {
+		for _, mtl := range mtls {
+			ref, ok := mtl.(*MaterialRef)
+			if !ok || ref.Material != nil {
+				continue
+			}
+			res, err := r.ResolveMaterialRef(ref)
+			if err != nil {
+				return err
+			}
+			ref.Material = res
+		}
+		return nil
+	}
+	if err := resolve(m.Materials); err != nil {
+		return err
+	}
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		if err := resolve(inst.Mesh.Materials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func embedMaterialRefs(field string, mtls []MeshMaterial, warns []Warning) ([]MeshMaterial, []Warning) {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		ref, ok := mtl.(*MaterialRef)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = append([]MeshMaterial(nil), mtls...)
+		}
+		if ref.Material == nil {
+			warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d]", field, i), Message: fmt.Sprintf("unresolved reference to %s replaced by a default material", ref.URI)})
+		} else {
+			warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d]", field, i), Message: fmt.Sprintf("reference to %s embedded", ref.URI)})
+		}
+		out[i] = ref.resolved()
+	}
+	return out, warns
+}
+
+func embedResolvedMaterialRefs(mtls []MeshMaterial) []MeshMaterial {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		if ref, ok := mtl.(*MaterialRef); ok && ref.Material != nil {
+			if out == nil {
+				out = append([]MeshMaterial(nil), mtls...)
+			}
+			out[i] = ref.resolved()
+		}
+	}
+	if out == nil {
+		return mtls
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package mst
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/qmuntal/gltf"
+)
+
+var ErrMaterialTypeRegistered = errors.New("mst: material type already registered")
+
+type MaterialCodec interface {
+	Match(mtl MeshMaterial) bool
+	Marshal(wt io.Writer, mtl MeshMaterial)
+	Unmarshal(rd io.Reader) (MeshMaterial, error)
+}
+
+type GltfMaterialCodec interface {
+	MaterialCodec
+	FillGltf(doc *gltf.Document, mtl MeshMaterial, gm *gltf.Material) error
+}
+
+var materialRegistry = struct {
+	sync.RWMutex
+	codecs map[uint32]MaterialCodec
+	tags   []uint32
+}{codecs: make(map[uint32]MaterialCodec)}
+
+func RegisterMaterialType(tag uint32, codec MaterialCodec) error {
+	if codec == nil {
+		return fmt.Errorf("mst: nil codec for material type %d", tag)
+	}
+	materialRegistry.Lock()
+	defer materialRegistry.Unlock()
+	if _, ok := materialRegistry.codecs[tag]; ok || knownMaterialType(tag) {
+		return ErrMaterialTypeRegistered
+	}
+	materialRegistry.codecs[tag] = codec
+	materialRegistry.tags = append(materialRegistry.tags, tag)
+	sort.Slice(materialRegistry.tags, func(i, j int) bool { return materialRegistry.tags[i] < materialRegistry.tags[j] })
+	return nil
+}
+
+func UnregisterMaterialType(tag uint32) {
+	materialRegistry.Lock()
+	defer materialRegistry.Unlock()
+	if _, ok := materialRegistry.codecs[tag]; !ok {
+		return
+	}
+	delete(materialRegistry.codecs, tag)
+	for i, t := range materialRegistry.tags {
+		if t == tag {
+			materialRegistry.tags = append(materialRegistry.tags[:i], materialRegistry.tags[i+1:]...)
+			break
+		}
+	}
+}
+
+func materialCodec(tag uint32) (MaterialCodec, bool) {
+	materialRegistry.RLock()
+	defer materialRegistry.RUnlock()
+	codec, ok := materialRegistry.codecs[tag]
+	return codec, ok
+}
+
+func customMaterialCodec(mtl MeshMaterial) (uint32, MaterialCodec, bool) {
+	if mtl == nil || materialBase(mtl) != nil {
+		return 0, nil, false
+	}
+	switch mtl.(type) {
+	case *TemplateMaterial, *MaterialRef, *UnknownMaterial:
+		return 0, nil, false
+	}
+	materialRegistry.RLock()
+	defer materialRegistry.RUnlock()
+	for _, tag := range materialRegistry.tags {
+		if codec := materialRegistry.codecs[tag]; codec.Match(mtl) {
+			return tag, codec, true
+		}
+	}
+	return 0, nil, false
+}
+
+func customMaterialMarshal(wt io.Writer, tag uint32, codec MaterialCodec, mtl MeshMaterial) {
+	body := &bytes.Buffer{}
+	codec.Marshal(body, mtl)
+	writeLittleByte(wt, tag)
+	writeLittleByte(wt, uint32(body.Len()))
+	wt.Write(body.Bytes())
+}
+
+func decodeCustomMaterial(tag uint32, data []byte) (MeshMaterial, bool, error) {
+	codec, ok := materialCodec(tag)
+	if !ok {
+		return nil, false, nil
+	}
+	mtl, err := codec.Unmarshal(bytes.NewReader(data))
+	if err == nil && mtl == nil {
+		err = fmt.Errorf("mst: codec for material type %d returned no material", tag)
+	}
+	return mtl, true, err
+}
+
+func fillCustomMaterial(doc *gltf.Document, mtl MeshMaterial, gm *gltf.Material) error {
+	_, codec, ok := customMaterialCodec(mtl)
+	if !ok {
+		return nil
+	}
+	gc, ok := codec.(GltfMaterialCodec)
+	if !ok {
+		return nil
+	}
+	if err := gc.FillGltf(doc, mtl, gm); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(gm.Extensions))
+	for name := range gm.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addExtension(doc, name, false)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package mst
+
+import "fmt"
+
+type MaterialTemplate struct {
+	Name     string
+	Material MeshMaterial
+}
+
+func NewMaterialTemplate(name string, mtl MeshMaterial) *MaterialTemplate {
+	return &MaterialTemplate{Name: name, Material: mtl}
+}
+
+type TemplateMaterial struct {
+	Template     *MaterialTemplate
+	Color        *[3]byte
+	Transparency *float32
+	Emissive     *[3]byte
+	Texture      *Texture
+}
+
+func (t *MaterialTemplate) Derive(color [3]byte) *TemplateMaterial {
+	return &TemplateMaterial{Template: t, Color: &color}
+}
+
+func (m *TemplateMaterial) Resolve() (MeshMaterial, error) {
+	if m.Template == nil || m.Template.Material == nil {
+		return nil, fmt.Errorf("mst: template material without base material")
+	}
+	base := m.Template.Material
+	if tm, ok := base.(*TemplateMaterial); ok {
+		var err error
+		if base, err = tm.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	mtl := cloneMaterial(base)
+	var bm *BaseMaterial
+	var tm *TextureMaterial
+	switch ml := mtl.(type) {
+	case *BaseMaterial:
+		bm = ml
+	case *TextureMaterial:
+		bm, tm = &ml.BaseMaterial, ml
+	case *PbrMaterial:
+		bm, tm = &ml.BaseMaterial, &ml.TextureMaterial
+		if m.Emissive != nil {
+			ml.Emissive = *m.Emissive
+		}
+	case *LambertMaterial:
+		bm, tm = &ml.BaseMaterial, &ml.TextureMaterial
+		if m.Emissive != nil {
+			ml.Emissive = *m.Emissive
+		}
+	case *PhongMaterial:
+		bm, tm = &ml.BaseMaterial, &ml.TextureMaterial
+		if m.Emissive != nil {
+			ml.Emissive = *m.Emissive
+		}
+	default:
+		return nil, fmt.Errorf("mst: template %q has unsupported material type %T", m.Template.Name, base)
+	}
+	if m.Color != nil {
+		bm.Color = *m.Color
+	}
+	if m.Transparency != nil {
+		bm.Transparency = *m.Transparency
+	}
+	if m.Texture != nil {
+		if tm == nil {
+			return nil, fmt.Errorf("mst: template %q cannot carry a texture", m.Template.Name)
+		}
+		tm.Texture = m.Texture
+	}
+	return mtl, nil
+}
+
+func (m *TemplateMaterial) resolved() MeshMaterial {
+	mtl, err := m.Resolve()
+	if err != nil {
+		return &BaseMaterial{}
+	}
+	return mtl
+}
+
+func (m *TemplateMaterial) HasTexture() bool {
+	return m.resolved().HasTexture()
+}
+
+func (m *TemplateMaterial) GetTexture() *Texture {
+	return m.resolved().GetTexture()
+}
+
+func (m *TemplateMaterial) GetColor() [3]byte {
+	return m.resolved().GetColor()
+}
+
+func (m *TemplateMaterial) GetEmissive() [3]byte {
+	return m.resolved().GetEmissive()
+}
+
+func cloneMaterial(mtl MeshMaterial) MeshMaterial {
+	switch ml := mtl.(type) {
+	case *BaseMaterial:
+		cp := *ml
+		return &cp
+	case *TextureMaterial:
+		cp := *ml
+		return &cp
+	case *PbrMaterial:
+		cp := *ml
+		return &cp
+	case *LambertMaterial:
+		cp := *ml
+		return &cp
+	case *PhongMaterial:
+		cp := *ml
+		return &cp
+	case *TemplateMaterial:
+		cp := *ml
+		return &cp
+	case *UnknownMaterial:
+		cp := *ml
+		return &cp
+	}
+	return mtl
+}
+
+func resolveMaterial(mtl MeshMaterial) MeshMaterial {
+	switch ml := mtl.(type) {
+	case *TemplateMaterial:
+		return ml.resolved()
+	case *MaterialRef:
+		return ml.resolved()
+	}
+	return mtl
+}
+
+func resolveMaterials(mtls []MeshMaterial) error {
+	for i, mtl := range mtls {
+		if tm, ok := mtl.(*TemplateMaterial); ok {
+			r, err := tm.Resolve()
+			if err != nil {
+				return err
+			}
+			mtls[i] = r
+		}
+	}
+	return nil
+}
+
+func (m *Mesh) ResolveMaterials() error {
+	if err := resolveMaterials(m.Materials); err != nil {
+		return err
+	}
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		if err := resolveMaterials(inst.Mesh.Materials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package mst
+
+import (
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+type Measurements struct {
+	SurfaceArea float64 `json:"surfaceArea"`
+	Volume      float64 `json:"volume"`
+	Centroid    dvec3.T `json:"centroid"`
+	Closed      bool    `json:"closed"`
+}
+
+type measurer struct {
+	area, volume  float64
+	surface, mass dvec3.T
+}
+
+func (m *measurer) add(a, b, c *dvec3.T) {
+	e1, e2 := dvec3.Sub(b, a), dvec3.Sub(c, a)
+	cr := dvec3.Cross(&e1, &e2)
+	area := cr.Length() / 2
+	sum := dvec3.Add(a, b)
+	sum.Add(c)
+	bc := dvec3.Cross(b, c)
+	vol := dvec3.Dot(a, &bc) / 6
+	m.area += area
+	m.volume += vol
+	s, v := sum.Scaled(area/3), sum.Scaled(vol/4)
+	m.surface.Add(&s)
+	m.mass.Add(&v)
+}
+
+func (m *measurer) result(closed bool) Measurements {
+	out := Measurements{SurfaceArea: m.area, Volume: m.volume, Closed: closed}
+	switch {
+	case closed && m.volume != 0:
+		out.Centroid = m.mass.Scaled(1 / m.volume)
+	case m.area != 0:
+		out.Centroid = m.surface.Scaled(1 / m.area)
+	}
+	return out
+}
+
+func (n *MeshNode) measure() Measurements {
+	var m measurer
+	n = n.withPolygonFaces()
+	pts := n.boundingPoints()
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			if checkIndices("vertex", f.Vertex[:], len(pts)) != nil {
+				continue
+			}
+			m.add(&pts[f.Vertex[0]], &pts[f.Vertex[1]], &pts[f.Vertex[2]])
+		}
+	}
+	return m.result(n.IsWatertight())
+}
+
+func (n *MeshNode) SurfaceArea() float64 {
+	return n.measure().SurfaceArea
+}
+
+// Volume is the signed volume enclosed by the faces in node space. It is only
+// meaningful for closed meshes and is negative when the faces point inwards.
+func (n *MeshNode) Volume() float64 {
+	return n.measure().Volume
+}
+
+// Centroid is the centre of the enclosed volume, or the area-weighted centre of
+// the surface when the node is not closed.
+func (n *MeshNode) Centroid() dvec3.T {
+	return n.measure().Centroid
+}
+
+func (m *Mesh) Measure() (Measurements, error) {
+	tris, err := meshTriangles(m)
+	if err != nil {
+		return Measurements{}, err
+	}
+	var ms measurer
+	for i := range tris {
+		t := &tris[i]
+		ms.add(&t.v[0], &t.v[1], &t.v[2])
+	}
+	closed := !anyNode(m, func(nd *MeshNode) bool {
+		return (len(nd.FaceGroup) > 0 || len(nd.PolygonGroup) > 0) && !nd.IsWatertight()
+	})
+	return ms.result(closed && len(tris) > 0), nil
+}
@@ -1,12 +1,15 @@
 package mst
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/binary"
 	"errors"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -31,6 +34,30 @@ const V1 uint32 = 1
 const V2 uint32 = 2
 const V3 uint32 = 3
 const V4 uint32 = 4
+const V5 uint32 = 5
+const V6 uint32 = 6
+const V7 uint32 = 7
+const V8 uint32 = 8
+const V9 uint32 = 9
+const V10 uint32 = 10
+const V11 uint32 = 11
+const V12 uint32 = 12
+const V13 uint32 = 13
+const V14 uint32 = 14
+const V15 uint32 = 15
+const V16 uint32 = 16
+const V17 uint32 = 17
+const V18 uint32 = 18
+const V19 uint32 = 19
+const V20 uint32 = 20
+const V21 uint32 = 21
+const V22 uint32 = 22
+const V23 uint32 = 23
+const V24 uint32 = 24
+const V25 uint32 = 25
+const V26 uint32 = 26
+const V27 uint32 = 27
+const V28 uint32 = 28
 
 const (
 	MESH_TRIANGLE_MATERIAL_TYPE_COLOR   = 0
@@ -38,6 +65,8 @@ const (
 	MESH_TRIANGLE_MATERIAL_TYPE_PBR     = 2
 	MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT = 3
 	MESH_TRIANGLE_MATERIAL_TYPE_PHONG   = 4
+
+	MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE = 5
 )
 
 const (
@@ -73,7 +102,15 @@ const (
 )
 
 const (
-	TEXTURE_COMPRESSED_ZLIB = 1
+	TEXTURE_COMPRESSED_NONE   = 0
+	TEXTURE_COMPRESSED_ZLIB   = 1
+	TEXTURE_COMPRESSED_SOURCE = 2
+)
+
+const (
+	TEXTURE_COLOR_SPACE_DEFAULT = 0
+	TEXTURE_COLOR_SPACE_SRGB    = 1
+	TEXTURE_COLOR_SPACE_LINEAR  = 2
 )
 
 type MeshMaterial interface {
@@ -90,11 +127,14 @@ type Texture struct {
 	Format     uint16    `json:"format"`
 	Type       uint16    `json:"type"`
 	Compressed uint16    `json:"compressed"`
-	Data       []byte    `json:"-"`
+	Data       []byte    `json:"data,omitempty"`
 	Repeated   bool      `json:"repeated"`
+	URI        string    `json:"uri,omitempty"`
+	ColorSpace uint8     `json:"colorSpace,omitempty"`
 }
 
 type BaseMaterial struct {
+	Name         string  `json:"name,omitempty"`
 	Color        [3]byte `json:"color"`
 	Transparency float32 `json:"transparency"`
 }
@@ -115,10 +155,60 @@ func (m *BaseMaterial) GetColor() [3]byte {
 	return m.Color
 }
 
+func (m *BaseMaterial) GetName() string {
+	return m.Name
+}
+
+func materialBase(mtl MeshMaterial) *BaseMaterial {
+	switch ml := mtl.(type) {
+	case *BaseMaterial:
+		return ml
+	case *TextureMaterial:
+		return &ml.BaseMaterial
+	case *PbrMaterial:
+		return &ml.BaseMaterial
+	case *LambertMaterial:
+		return &ml.BaseMaterial
+	case *PhongMaterial:
+		return &ml.BaseMaterial
+	}
+	return nil
+}
+
+func MaterialName(mtl MeshMaterial) string {
+	if bm := materialBase(resolveMaterial(mtl)); bm != nil {
+		return bm.Name
+	}
+	return ""
+}
+
+func hasMaterialNames(ms *Mesh) bool {
+	named := func(mtls []MeshMaterial) bool {
+		for _, mtl := range mtls {
+			if MaterialName(mtl) != "" {
+				return true
+			}
+		}
+		return false
+	}
+	if named(ms.Materials) {
+		return true
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh != nil && named(inst.Mesh.Materials) {
+			return true
+		}
+	}
+	return false
+}
+
 type TextureMaterial struct {
 	BaseMaterial
 	Texture *Texture `json:"texture,omitempty"`
 	Normal  *Texture `json:"normal,omitempty"`
+
+	TexCoord       uint8 `json:"texCoord,omitempty"`
+	NormalTexCoord uint8 `json:"normalTexCoord,omitempty"`
 }
 
 func (m *TextureMaterial) HasTexture() bool {
@@ -178,40 +268,76 @@ func (m *LambertMaterial) GetEmissive() [3]byte {
 }
 
 type Face struct {
-	Vertex [3]uint32
-	Normal *[3]uint32
-	Uv     *[3]uint32
+	Vertex   [3]uint32  `json:"v"`
+	Normal   *[3]uint32 `json:"n,omitempty"`
+	Uv       *[3]uint32 `json:"uv,omitempty"`
+	Material *int32     `json:"mtl,omitempty"`
 }
 type MeshTriangle struct {
-	Batchid int32   `json:"batchid"`
-	Faces   []*Face `json:"faces"`
+	Batchid   int32    `json:"batchid"`
+	Faces     []*Face  `json:"faces"`
+	FeatureID *uint64  `json:"featureId,omitempty"`
+	Features  []uint64 `json:"features,omitempty"`
+	Mode      uint8    `json:"mode,omitempty"`
 }
 
 type MeshOutline struct {
 	Batchid int32       `json:"batchid"`
 	Edges   [][2]uint32 `json:"edges"`
+	Width   float32     `json:"width,omitempty"`
+	Color   *[3]byte    `json:"color,omitempty"`
+	Dash    []float32   `json:"dash,omitempty"`
+	Closed  bool        `json:"closed,omitempty"`
 }
 
 type MeshNode struct {
-	Vertices  []vec3.T        `json:"vertices"`
-	Normals   []vec3.T        `json:"normals,omitempty"`
-	Colors    [][3]byte       `json:"colors,omitempty"`
-	TexCoords []vec2.T        `json:"texCoords,omitempty"`
-	Mat       *dmat.T         `json:"mat,omitempty"`
-	FaceGroup []*MeshTriangle `json:"faceGroup,omitempty"`
-	EdgeGroup []*MeshOutline  `json:"edgeGroup,omitempty"`
+	Vertices     []vec3.T        `json:"vertices"`
+	Normals      []vec3.T        `json:"normals,omitempty"`
+	Colors       [][3]byte       `json:"colors,omitempty"`
+	TexCoords    []vec2.T        `json:"texCoords,omitempty"`
+	TexCoords2   []vec2.T        `json:"texCoords2,omitempty"`
+	Lightmap     *Texture        `json:"lightmap,omitempty"`
+	Mat          *dmat.T         `json:"mat,omitempty"`
+	FaceGroup    []*MeshTriangle `json:"faceGroup,omitempty"`
+	EdgeGroup    []*MeshOutline  `json:"edgeGroup,omitempty"`
+	PolygonGroup []*MeshPolygon  `json:"polygonGroup,omitempty"`
+	Props        Properties      `json:"props,omitempty"`
+
+	MorphTargets []*MorphTarget `json:"morphTargets,omitempty"`
+	Name         string         `json:"name,omitempty"`
+	Children     []uint32       `json:"children,omitempty"`
+
+	IndexingMode uint8 `json:"indexingMode,omitempty"`
+	IndexWidth   uint8 `json:"indexWidth,omitempty"`
+	Quantization uint8 `json:"quantization,omitempty"`
 }
 
 func (n *MeshNode) ResortVtVn(m *Mesh) {
+	if n.GetIndexingMode() == INDEXING_MODE_FLATTENED {
+		return
+	}
+	sharedNormals := len(n.Normals) == len(n.Vertices)
+	sharedUvs := len(n.TexCoords) == len(n.Vertices)
+	sharedColors := len(n.Colors) == len(n.Vertices)
+	sharedUvs2 := len(n.TexCoords2) == len(n.Vertices)
 	var vs, vns []vec3.T
-	var vts []vec2.T
+	var vts, vts2 []vec2.T
+	var cls [][3]byte
 	var idx uint32
+	first := make(map[uint32]uint32)
 	for _, g := range n.FaceGroup {
 		for _, f := range g.Faces {
+			for k, v := range f.Vertex {
+				if _, ok := first[v]; !ok {
+					first[v] = idx + uint32(k)
+				}
+			}
 			if f.Normal != nil {
 				vns = append(vns, n.Normals[int((*f.Normal)[0])])
 				vns = append(vns, n.Normals[int((*f.Normal)[1])])
 				vns = append(vns, n.Normals[int((*f.Normal)[2])])
+			} else if sharedNormals {
+				vns = append(vns, n.Normals[int(f.Vertex[0])], n.Normals[int(f.Vertex[1])], n.Normals[int(f.Vertex[2])])
 			} else {
 				vns = append(vns, vec3.T{0, 0, 1})
 				vns = append(vns, vec3.T{0, 0, 1})
@@ -221,21 +347,63 @@ func (n *MeshNode) ResortVtVn(m *Mesh) {
 				vts = append(vts, n.TexCoords[int((*f.Uv)[0])])
 				vts = append(vts, n.TexCoords[int((*f.Uv)[1])])
 				vts = append(vts, n.TexCoords[int((*f.Uv)[2])])
+			} else if sharedUvs {
+				vts = append(vts, n.TexCoords[int(f.Vertex[0])], n.TexCoords[int(f.Vertex[1])], n.TexCoords[int(f.Vertex[2])])
 			} else {
 				vts = append(vts, vec2.T{0, 0})
 				vts = append(vts, vec2.T{0, 0})
 				vts = append(vts, vec2.T{0, 0})
 			}
+			if sharedColors {
+				cls = append(cls, n.Colors[int(f.Vertex[0])], n.Colors[int(f.Vertex[1])], n.Colors[int(f.Vertex[2])])
+			}
+			if sharedUvs2 {
+				vts2 = append(vts2, n.TexCoords2[int(f.Vertex[0])], n.TexCoords2[int(f.Vertex[1])], n.TexCoords2[int(f.Vertex[2])])
+			}
 			vs = append(vs, n.Vertices[int(f.Vertex[0])])
 			vs = append(vs, n.Vertices[int(f.Vertex[1])])
 			vs = append(vs, n.Vertices[int(f.Vertex[2])])
 			f.Vertex = [3]uint32{idx, uint32(idx + 1), uint32(idx + 2)}
+			f.Normal = nil
+			f.Uv = nil
 			idx += 3
 		}
 	}
+	n.remapPolygonVertices(func(v uint32) uint32 {
+		if r, ok := first[v]; ok {
+			return r
+		}
+		vs = append(vs, n.Vertices[v])
+		if sharedNormals {
+			vns = append(vns, n.Normals[v])
+		} else {
+			vns = append(vns, vec3.T{0, 0, 1})
+		}
+		if sharedUvs {
+			vts = append(vts, n.TexCoords[v])
+		} else {
+			vts = append(vts, vec2.T{0, 0})
+		}
+		if sharedColors {
+			cls = append(cls, n.Colors[v])
+		}
+		if sharedUvs2 {
+			vts2 = append(vts2, n.TexCoords2[v])
+		}
+		first[v] = idx
+		idx++
+		return first[v]
+	})
 	n.Vertices = vs
 	n.Normals = vns
 	n.TexCoords = vts
+	if sharedColors {
+		n.Colors = cls
+	}
+	if sharedUvs2 {
+		n.TexCoords2 = vts2
+	}
+	n.IndexingMode = INDEXING_MODE_FLATTENED
 }
 
 func (n *MeshNode) ReComputeNormal() {
@@ -279,6 +447,9 @@ type InstanceMesh struct {
 	BBox      *[6]float64
 	Mesh      *BaseMesh
 	Hash      uint64
+	Props     Properties
+	Ref       *InstanceRef
+	Bounds    [][6]float64
 }
 
 func (nd *MeshNode) GetBoundbox() *[6]float64 {
@@ -310,10 +481,13 @@ type Mesh struct {
 	BaseMesh
 	Version      uint32 `json:"version"`
 	InstanceNode []*InstanceMesh
+	Props        Properties   `json:"props,omitempty"`
+	Animations   []*Animation `json:"animations,omitempty"`
+	Units        uint8        `json:"units,omitempty"`
 }
 
 func NewMesh() *Mesh {
-	return &Mesh{Version: V4}
+	return &Mesh{Version: V5}
 }
 
 func (m *Mesh) NodeCount() int {
@@ -325,19 +499,7 @@ func (m *Mesh) MaterialCount() int {
 }
 
 func (m *Mesh) ComputeBBox() dvec3.Box {
-	if len(m.Nodes) == 0 {
-		return dvec3.Box{}
-	}
-
-	bbox := dvec3.MinBox
-	for _, nd := range m.Nodes {
-		bx := nd.GetBoundbox()
-		min := dvec3.T{bx[0], bx[1], bx[2]}
-		max := dvec3.T{bx[3], bx[4], bx[5]}
-		bbx := dvec3.Box{Min: min, Max: max}
-		bbox.Join(&bbx)
-	}
-	return bbox
+	return m.ComputeBBoxWithOptions(nil)
 }
 
 func toLittleByteOrder(v interface{}) []byte {
@@ -357,20 +519,13 @@ func writeLittleByte(wt io.Writer, v interface{}) {
 	}
 }
 
-func readLittleByte(rd io.Reader, v interface{}) {
-	binary.Read(rd, binary.LittleEndian, v)
-}
-
 func BaseMaterialMarshal(wt io.Writer, mtl *BaseMaterial) {
 	writeLittleByte(wt, &mtl.Color)
 	writeLittleByte(wt, &mtl.Transparency)
 }
 
 func BaseMaterialUnMarshal(rd io.Reader) *BaseMaterial {
-	mtl := BaseMaterial{}
-	readLittleByte(rd, mtl.Color[:])
-	readLittleByte(rd, &mtl.Transparency)
-	return &mtl
+	return newDecoder(rd, 0, nil).baseMaterial()
 }
 
 func TextureMarshal(wt io.Writer, tex *Texture) {
@@ -387,23 +542,7 @@ func TextureMarshal(wt io.Writer, tex *Texture) {
 }
 
 func TextureUnMarshal(rd io.Reader) *Texture {
-	tex := &Texture{}
-	readLittleByte(rd, &tex.Id)
-	var name_size uint32
-	readLittleByte(rd, &name_size)
-	nm := make([]byte, name_size)
-	rd.Read(nm)
-	tex.Name = string(nm)
-	readLittleByte(rd, &tex.Size)
-	readLittleByte(rd, &tex.Format)
-	readLittleByte(rd, &tex.Type)
-	readLittleByte(rd, &tex.Compressed)
-	var tex_size uint32
-	readLittleByte(rd, &tex_size)
-	tex.Data = make([]byte, tex_size)
-	readLittleByte(rd, tex.Data)
-	readLittleByte(rd, &tex.Repeated)
-	return tex
+	return newDecoder(rd, 0, nil).texture()
 }
 
 func TextureMaterialMarshal(wt io.Writer, mtl *TextureMaterial) {
@@ -423,25 +562,13 @@ func TextureMaterialMarshal(wt io.Writer, mtl *TextureMaterial) {
 }
 
 func TextureMaterialUnMarshal(rd io.Reader) *TextureMaterial {
-	tmtl := TextureMaterial{}
-	bmt := BaseMaterialUnMarshal(rd)
-	tmtl.BaseMaterial = *bmt
-	var hasTex uint16
-	readLittleByte(rd, &hasTex)
-	if hasTex == 1 {
-		tmtl.Texture = TextureUnMarshal(rd)
-	}
-	readLittleByte(rd, &hasTex)
-	if hasTex == 1 {
-		tmtl.Normal = TextureUnMarshal(rd)
-	}
-	return &tmtl
+	return newDecoder(rd, 0, nil).textureMaterial()
 }
 
 func PbrMaterialMarshal(wt io.Writer, mtl *PbrMaterial, v uint32) {
 	TextureMaterialMarshal(wt, &mtl.TextureMaterial)
 	writeLittleByte(wt, mtl.Emissive[:])
-	if v < 2 {
+	if FormatCapabilities(v).PbrPadding {
 		writeLittleByte(wt, byte(255))
 	}
 	writeLittleByte(wt, &mtl.Metallic)
@@ -460,28 +587,7 @@ func PbrMaterialMarshal(wt io.Writer, mtl *PbrMaterial, v uint32) {
 }
 
 func PbrMaterialUnMarshal(rd io.Reader, v uint32) *PbrMaterial {
-	mtl := PbrMaterial{}
-	tmtl := TextureMaterialUnMarshal(rd)
-	mtl.TextureMaterial = *tmtl
-	readLittleByte(rd, mtl.Emissive[:])
-	if v < 2 {
-		var b byte
-		readLittleByte(rd, &b)
-	}
-	readLittleByte(rd, &mtl.Metallic)
-	readLittleByte(rd, &mtl.Roughness)
-	readLittleByte(rd, &mtl.Reflectance)
-	readLittleByte(rd, &mtl.AmbientOcclusion)
-	readLittleByte(rd, &mtl.ClearCoat)
-	readLittleByte(rd, &mtl.ClearCoatRoughness)
-	readLittleByte(rd, &mtl.ClearCoatNormal)
-	readLittleByte(rd, &mtl.Anisotropy)
-	readLittleByte(rd, mtl.AnisotropyDirection[:])
-	readLittleByte(rd, &mtl.Thickness)
-	readLittleByte(rd, &mtl.SubSurfacePower)
-	readLittleByte(rd, &mtl.SheenColor)
-	readLittleByte(rd, mtl.SubSurfaceColor[:])
-	return &mtl
+	return newDecoder(rd, v, nil).pbrMaterial()
 }
 
 func LambertMaterialMarshal(wt io.Writer, mtl *LambertMaterial) {
@@ -492,13 +598,7 @@ func LambertMaterialMarshal(wt io.Writer, mtl *LambertMaterial) {
 }
 
 func LambertMaterialUnMarshal(rd io.Reader) *LambertMaterial {
-	mtl := LambertMaterial{}
-	tmt := TextureMaterialUnMarshal(rd)
-	mtl.TextureMaterial = *tmt
-	readLittleByte(rd, mtl.Ambient[:])
-	readLittleByte(rd, mtl.Diffuse[:])
-	readLittleByte(rd, mtl.Emissive[:])
-	return &mtl
+	return newDecoder(rd, 0, nil).lambertMaterial()
 }
 
 func PhongMaterialMarshal(wt io.Writer, mtl *PhongMaterial) {
@@ -509,121 +609,187 @@ func PhongMaterialMarshal(wt io.Writer, mtl *PhongMaterial) {
 }
 
 func PhongMaterialUnMarshal(rd io.Reader) *PhongMaterial {
-	mtl := PhongMaterial{}
-	mt := LambertMaterialUnMarshal(rd)
-	mtl.LambertMaterial = *mt
-	readLittleByte(rd, mtl.Specular[:])
-	readLittleByte(rd, &mtl.Shininess)
-	readLittleByte(rd, &mtl.Specularity)
-	return &mtl
+	return newDecoder(rd, 0, nil).phongMaterial()
 }
 
 func MaterialMarshal(wt io.Writer, mt MeshMaterial, v uint32) {
+	caps := FormatCapabilities(v)
+	ty := uint32(materialTypeOf(mt))
+	switch mtl := mt.(type) {
+	case *TemplateMaterial:
+		MaterialMarshal(wt, mtl.resolved(), v)
+		return
+	case *MaterialRef:
+		if !caps.MaterialRefs {
+			MaterialMarshal(wt, mtl.resolved(), v)
+			return
+		}
+		ty = MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE
+	case *UnknownMaterial:
+		if !caps.MaterialLengths {
+			MaterialMarshal(wt, mtl.placeholder(), v)
+			return
+		}
+		writeLittleByte(wt, mtl.Type)
+		writeLittleByte(wt, uint32(len(mtl.Data)))
+		wt.Write(mtl.Data)
+		return
+	}
+	if tag, codec, ok := customMaterialCodec(mt); ok {
+		if !caps.MaterialLengths {
+			MaterialMarshal(wt, &BaseMaterial{Color: mt.GetColor()}, v)
+			return
+		}
+		customMaterialMarshal(wt, tag, codec, mt)
+		return
+	}
+	if materialBase(mt) == nil && ty != MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE {
+		placeholder := &BaseMaterial{Color: [3]byte{255, 255, 255}}
+		if mt != nil {
+			placeholder.Color = mt.GetColor()
+		}
+		MaterialMarshal(wt, placeholder, v)
+		return
+	}
+	writeLittleByte(wt, ty)
+	if !caps.MaterialLengths {
+		materialBodyMarshal(wt, mt, v)
+		return
+	}
+	body := &bytes.Buffer{}
+	materialBodyMarshal(body, mt, v)
+	writeLittleByte(wt, uint32(body.Len()))
+	wt.Write(body.Bytes())
+}
+
+func materialBodyMarshal(wt io.Writer, mt MeshMaterial, v uint32) {
 	switch mtl := mt.(type) {
 	case *BaseMaterial:
-		writeLittleByte(wt, uint32(MESH_TRIANGLE_MATERIAL_TYPE_COLOR))
 		BaseMaterialMarshal(wt, mtl)
 	case *TextureMaterial:
-		writeLittleByte(wt, uint32(MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE))
 		TextureMaterialMarshal(wt, mtl)
 	case *PbrMaterial:
-		writeLittleByte(wt, uint32(MESH_TRIANGLE_MATERIAL_TYPE_PBR))
 		PbrMaterialMarshal(wt, mtl, v)
 	case *LambertMaterial:
-		writeLittleByte(wt, uint32(MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT))
 		LambertMaterialMarshal(wt, mtl)
 	case *PhongMaterial:
-		writeLittleByte(wt, uint32(MESH_TRIANGLE_MATERIAL_TYPE_PHONG))
 		PhongMaterialMarshal(wt, mtl)
+	case *MaterialRef:
+		materialRefMarshal(wt, mtl)
+		return
+	}
+	if FormatCapabilities(v).MaterialNames {
+		name := MaterialName(mt)
+		writeLittleByte(wt, uint32(len(name)))
+		wt.Write([]byte(name))
+	}
+	if FormatCapabilities(v).TexCoords2 {
+		texCoordSetsMarshal(wt, mt)
+	}
+	if FormatCapabilities(v).TextureURIs {
+		textureURIsMarshal(wt, mt)
+	}
+	if FormatCapabilities(v).ColorSpaces {
+		textureColorSpacesMarshal(wt, mt)
 	}
 }
 
 func MaterialUnMarshal(rd io.Reader, v uint32) MeshMaterial {
-	var ty uint32
-	readLittleByte(rd, &ty)
-	switch int(ty) {
-	case MESH_TRIANGLE_MATERIAL_TYPE_COLOR:
-		return BaseMaterialUnMarshal(rd)
-	case MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE:
-		return TextureMaterialUnMarshal(rd)
-	case MESH_TRIANGLE_MATERIAL_TYPE_PBR:
-		return PbrMaterialUnMarshal(rd, v)
-	case MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT:
-		return LambertMaterialUnMarshal(rd)
-	case MESH_TRIANGLE_MATERIAL_TYPE_PHONG:
-		return PhongMaterialUnMarshal(rd)
-	default:
-		return nil
-	}
+	return newDecoder(rd, v, nil).material()
 }
 
+// Deprecated: Use Encoder.EncodeMaterials.
 func MtlsMarshal(wt io.Writer, mtls []MeshMaterial, v uint32) {
+	mtlsMarshal(wt, mtls, v)
+}
+
+func mtlsMarshal(wt io.Writer, mtls []MeshMaterial, v uint32) {
 	writeLittleByte(wt, uint32(len(mtls)))
 	for _, mtl := range mtls {
 		MaterialMarshal(wt, mtl, v)
 	}
 }
 
+// Deprecated: Use Decoder.DecodeMaterials.
 func MtlsUnMarshal(rd io.Reader, v uint32) []MeshMaterial {
-	var size uint32
-	readLittleByte(rd, &size)
-	mtls := make([]MeshMaterial, size)
-	for i := 0; i < int(size); i++ {
-		mtls[i] = MaterialUnMarshal(rd, v)
-	}
-	return mtls
+	return newDecoder(rd, v, nil).materials()
 }
 
 func MeshTriangleMarshal(wt io.Writer, nd *MeshTriangle) {
+	meshTriangleMarshal(wt, nd, INDEX_WIDTH_32, Capabilities{})
+}
+
+func meshTriangleMarshal(wt io.Writer, nd *MeshTriangle, width uint8, caps Capabilities) {
 	writeLittleByte(wt, nd.Batchid)
+	if caps.PrimitiveModes {
+		primitiveMarshal(wt, nd, width)
+		return
+	}
 	writeLittleByte(wt, uint32(len(nd.Faces)))
 	for _, f := range nd.Faces {
-		writeLittleByte(wt, &f.Vertex)
+		writeIndices(wt, f.Vertex[:], width)
 	}
 }
 
 func MeshTriangleUnMarshal(rd io.Reader) *MeshTriangle {
-	nd := MeshTriangle{}
-	readLittleByte(rd, &nd.Batchid)
-	var size uint32
-	readLittleByte(rd, &size)
-	nd.Faces = make([]*Face, size)
-	for i := 0; i < int(size); i++ {
-		f := &Face{}
-		nd.Faces[i] = f
-		readLittleByte(rd, &f.Vertex)
-	}
-	return &nd
+	return newDecoder(rd, 0, nil).meshTriangle(INDEX_WIDTH_32)
 }
 
 func MeshOutlineMarshal(wt io.Writer, nd *MeshOutline) {
+	meshOutlineMarshal(wt, nd, INDEX_WIDTH_32)
+}
+
+func meshOutlineMarshal(wt io.Writer, nd *MeshOutline, width uint8) {
 	writeLittleByte(wt, nd.Batchid)
 	writeLittleByte(wt, uint32(len(nd.Edges)))
-	for _, e := range nd.Edges {
-		writeLittleByte(wt, &e)
+	for i := range nd.Edges {
+		writeIndices(wt, nd.Edges[i][:], width)
 	}
 }
 
 func MeshOutlineUnMarshal(rd io.Reader) *MeshOutline {
-	nd := MeshOutline{}
-	readLittleByte(rd, &nd.Batchid)
-	var size uint32
-	readLittleByte(rd, &size)
-	nd.Edges = make([][2]uint32, size)
-	for i := 0; i < int(size); i++ {
-		readLittleByte(rd, &nd.Edges[i])
-	}
-	return &nd
+	return newDecoder(rd, 0, nil).meshOutline(INDEX_WIDTH_32)
 }
 
+// Deprecated: Use Encoder.EncodeNode.
 func MeshNodeMarshal(wt io.Writer, nd *MeshNode) {
+	meshNodeMarshal(wt, nd, V5)
+}
+
+// Deprecated: Use Encoder.EncodeNode with Encoder.SetVersion.
+func MeshNodeMarshalWithVersion(wt io.Writer, nd *MeshNode, v uint32) {
+	meshNodeMarshal(wt, nd, v)
+}
+
+func meshNodeMarshal(wt io.Writer, nd *MeshNode, v uint32) {
+	nd = nd.withMaterialGroups()
+	caps := FormatCapabilities(v)
+	width := uint8(INDEX_WIDTH_32)
+	if caps.IndexWidth {
+		width = nd.GetIndexWidth()
+		writeLittleByte(wt, width)
+	}
+	quant := nd.storedQuantization(caps)
+	if caps.Quantization {
+		writeLittleByte(wt, quant.flags)
+	}
 	writeLittleByte(wt, uint32(len(nd.Vertices)))
-	for i := range nd.Vertices {
-		writeLittleByte(wt, nd.Vertices[i][:])
+	if quant.flags&QUANTIZE_POSITION != 0 {
+		writeQuantizedPositions(wt, nd.Vertices, &quant.pos)
+	} else {
+		for i := range nd.Vertices {
+			writeLittleByte(wt, nd.Vertices[i][:])
+		}
 	}
 	writeLittleByte(wt, uint32(len(nd.Normals)))
-	for i := range nd.Normals {
-		writeLittleByte(wt, nd.Normals[i][:])
+	if quant.flags&QUANTIZE_NORMAL_OCT16 != 0 {
+		writeOctNormals16(wt, nd.Normals)
+	} else if quant.flags&QUANTIZE_NORMAL != 0 {
+		writeOctNormals(wt, nd.Normals)
+	} else {
+		for i := range nd.Normals {
+			writeLittleByte(wt, nd.Normals[i][:])
+		}
 	}
 	writeLittleByte(wt, uint32(len(nd.Colors)))
 	for i := range nd.Colors {
@@ -631,8 +797,14 @@ func MeshNodeMarshal(wt io.Writer, nd *MeshNode) {
 
 	}
 	writeLittleByte(wt, uint32(len(nd.TexCoords)))
-	for i := range nd.TexCoords {
-		writeLittleByte(wt, nd.TexCoords[i][:])
+	if quant.flags&QUANTIZE_TEXCOORD_HALF != 0 {
+		writeHalfTexCoords(wt, nd.TexCoords)
+	} else if quant.flags&QUANTIZE_TEXCOORD != 0 {
+		writeQuantizedTexCoords(wt, nd.TexCoords, &quant.uv)
+	} else {
+		for i := range nd.TexCoords {
+			writeLittleByte(wt, nd.TexCoords[i][:])
+		}
 	}
 	if nd.Mat != nil {
 		writeLittleByte(wt, uint8(1))
@@ -646,129 +818,218 @@ func MeshNodeMarshal(wt io.Writer, nd *MeshNode) {
 
 	writeLittleByte(wt, uint32(len(nd.FaceGroup)))
 	for _, fg := range nd.FaceGroup {
-		MeshTriangleMarshal(wt, fg)
+		meshTriangleMarshal(wt, fg, width, caps)
+		if caps.FaceIndices {
+			faceIndicesMarshal(wt, fg, width)
+		}
+		if caps.FaceFeatures {
+			faceFeaturesMarshal(wt, fg)
+		}
 	}
 
 	writeLittleByte(wt, uint32(len(nd.EdgeGroup)))
 	for _, eg := range nd.EdgeGroup {
-		MeshOutlineMarshal(wt, eg)
+		meshOutlineMarshal(wt, eg, width)
+		if caps.OutlineStyles {
+			outlineStyleMarshal(wt, eg)
+		}
 	}
-}
-
-func MeshNodeUnMarshal(rd io.Reader) *MeshNode {
-	nd := MeshNode{}
-	var size uint32
-	readLittleByte(rd, &size)
-	nd.Vertices = make([]vec3.T, size)
-	for i := range nd.Vertices {
-		readLittleByte(rd, nd.Vertices[i][:])
+	if FormatCapabilities(v).NodeProps {
+		PropertiesMarshal(wt, nd.Props)
 	}
-	readLittleByte(rd, &size)
-	nd.Normals = make([]vec3.T, size)
-	for i := range nd.Normals {
-		readLittleByte(rd, nd.Normals[i][:])
+	if caps.MorphTargets {
+		morphTargetsMarshal(wt, nd.MorphTargets)
 	}
-	readLittleByte(rd, &size)
-	nd.Colors = make([][3]byte, size)
-	for i := range nd.Colors {
-		readLittleByte(rd, nd.Colors[i][:])
+	if caps.Hierarchy {
+		hierarchyMarshal(wt, nd)
 	}
-
-	readLittleByte(rd, &size)
-	nd.TexCoords = make([]vec2.T, size)
-	for i := range nd.TexCoords {
-		readLittleByte(rd, &nd.TexCoords[i])
+	if caps.TexCoords2 {
+		texCoords2Marshal(wt, nd, width)
 	}
-	var isMat uint8
-	readLittleByte(rd, &isMat)
-	if isMat == 1 {
-		nd.Mat = &dmat.T{}
-		readLittleByte(rd, nd.Mat[0][:])
-		readLittleByte(rd, nd.Mat[1][:])
-		readLittleByte(rd, nd.Mat[2][:])
-		readLittleByte(rd, nd.Mat[3][:])
+	if caps.Lightmaps {
+		lightmapMarshal(wt, nd, v)
 	}
-
-	readLittleByte(rd, &size)
-	nd.FaceGroup = make([]*MeshTriangle, size)
-	for i := 0; i < int(size); i++ {
-		nd.FaceGroup[i] = MeshTriangleUnMarshal(rd)
+	if caps.Polygons {
+		polygonsMarshal(wt, nd, width)
 	}
+}
 
-	readLittleByte(rd, &size)
-	nd.EdgeGroup = make([]*MeshOutline, size)
-	for i := 0; i < int(size); i++ {
-		nd.EdgeGroup[i] = MeshOutlineUnMarshal(rd)
-	}
-	return &nd
+// Deprecated: Use Decoder.DecodeNode.
+func MeshNodeUnMarshal(rd io.Reader) *MeshNode {
+	return newDecoder(rd, 0, nil).meshNode()
+}
+
+// Deprecated: Use Decoder.DecodeNode with Decoder.SetVersion.
+func MeshNodeUnMarshalWithVersion(rd io.Reader, v uint32) *MeshNode {
+	return newDecoder(rd, v, nil).meshNode()
 }
 
+// Deprecated: Use Encoder.EncodeNodes.
 func MeshNodesMarshal(wt io.Writer, nds []*MeshNode) {
+	meshNodesMarshal(wt, nds, V5)
+}
+
+// Deprecated: Use Encoder.EncodeNodes with Encoder.SetVersion.
+func MeshNodesMarshalWithVersion(wt io.Writer, nds []*MeshNode, v uint32) {
+	meshNodesMarshal(wt, nds, v)
+}
+
+func meshNodesMarshal(wt io.Writer, nds []*MeshNode, v uint32) {
 	writeLittleByte(wt, uint32(len(nds)))
 	for _, nd := range nds {
-		MeshNodeMarshal(wt, nd)
+		meshNodeMarshal(wt, nd, v)
 	}
 }
 
+// Deprecated: Use Decoder.DecodeNodes.
 func MeshNodesUnMarshal(rd io.Reader) []*MeshNode {
-	var size uint32
-	readLittleByte(rd, &size)
-	nds := make([]*MeshNode, size)
-	for i := range nds {
-		nds[i] = MeshNodeUnMarshal(rd)
+	return newDecoder(rd, 0, nil).meshNodes()
+}
+
+// Deprecated: Use Decoder.DecodeNodes with Decoder.SetVersion.
+func MeshNodesUnMarshalWithVersion(rd io.Reader, v uint32) []*MeshNode {
+	return newDecoder(rd, v, nil).meshNodes()
+}
+
+func MeshMarshal(wt io.Writer, ms *Mesh, opts ...WriteOption) error {
+	return meshMarshal(wt, ms, newWriteOptions(opts))
+}
+
+func MeshMarshalContext(ctx context.Context, wt io.Writer, ms *Mesh, opts ...WriteOption) error {
+	o := newWriteOptions(opts)
+	o.ctx = ctx
+	return meshMarshal(wt, ms, o)
+}
+
+func meshMarshal(wt io.Writer, ms *Mesh, o *writeOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	p := newProgress(o.ctx, o.progress)
+	if err := p.err(); err != nil {
+		return err
+	}
+	if o.canonical {
+		ms = CanonicalMesh(ms)
 	}
-	return nds
+	if o.externalTextures {
+		ms = externalTextures(ms)
+	}
+	v, flags := o.header(requiredVersion(ms, ms.Version))
+	manifest := o.manifestBytes(ms, v)
+	cw := newChecksumWriter(wt, flags&MESH_FLAG_CHECKSUM != 0)
+	if flags&(MESH_FLAG_SECTION_TABLE|MESH_FLAG_COMPRESSED) == 0 {
+		cw.Write(headerBytes(v, flags, o.compression, nil, manifest))
+		cw.begin()
+		if err := meshSectionsMarshal(cw, ms, v, cw.next, p); err != nil {
+			return err
+		}
+		cw.footer()
+		return nil
+	}
+	sb := &sectionBuffer{}
+	if err := meshSectionsMarshal(sb, ms, v, sb.next, p); err != nil {
+		return err
+	}
+	sections := sb.sections
+	if flags&MESH_FLAG_COMPRESSED != 0 {
+		sections = compressSections(sections, o.compression, o.level)
+	}
+	var table []MeshSection
+	if flags&MESH_FLAG_SECTION_TABLE != 0 {
+		table = sectionTable(v, flags, sections, len(manifest))
+	}
+	cw.Write(headerBytes(v, flags, o.compression, table, manifest))
+	cw.begin()
+	for _, sec := range sections {
+		cw.Write(sec)
+		cw.next()
+	}
+	cw.footer()
+	return nil
 }
 
-func MeshMarshal(wt io.Writer, ms *Mesh) {
-	wt.Write([]byte(MESH_SIGNATURE))
-	writeLittleByte(wt, ms.Version)
-	baseMeshMarshal(wt, &ms.BaseMesh, ms.Version)
-	MeshInstanceNodesMarshal(wt, ms.InstanceNode, ms.Version)
-	if ms.Version == V4 {
+func meshSectionsMarshal(wt io.Writer, ms *Mesh, v uint32, next func(), p *progress) error {
+	caps := FormatCapabilities(v)
+	mtlsMarshal(wt, ms.Materials, v)
+	if err := p.report(PROGRESS_STAGE_MATERIALS, len(ms.Materials), len(ms.Materials)); err != nil {
+		return err
+	}
+	next()
+	writeLittleByte(wt, uint32(len(ms.Nodes)))
+	for i, nd := range ms.Nodes {
+		meshNodeMarshal(wt, nd, v)
+		if err := p.report(PROGRESS_STAGE_NODES, i+1, len(ms.Nodes)); err != nil {
+			return err
+		}
+	}
+	if caps.Code {
+		writeLittleByte(wt, ms.Code)
+	}
+	next()
+	writeLittleByte(wt, uint32(len(ms.InstanceNode)))
+	for i, inst := range ms.InstanceNode {
+		instanceNodeMarshal(wt, inst, v)
+		if err := p.report(PROGRESS_STAGE_INSTANCES, i+1, len(ms.InstanceNode)); err != nil {
+			return err
+		}
+	}
+	if caps.Code {
 		writeLittleByte(wt, ms.Code)
 	}
+	next()
+	if caps.Props {
+		PropertiesMarshal(wt, ms.Props)
+	}
+	if caps.Units {
+		writeLittleByte(wt, ms.Units)
+	}
+	next()
+	if caps.Animations {
+		animationsMarshal(wt, ms.Animations)
+		if err := p.report(PROGRESS_STAGE_ANIMATIONS, len(ms.Animations), len(ms.Animations)); err != nil {
+			return err
+		}
+		next()
+	}
+	return nil
 }
 
 func baseMeshMarshal(wt io.Writer, ms *BaseMesh, v uint32) {
-	MtlsMarshal(wt, ms.Materials, v)
-	MeshNodesMarshal(wt, ms.Nodes)
-	if v == V4 {
+	mtlsMarshal(wt, ms.Materials, v)
+	meshNodesMarshal(wt, ms.Nodes, v)
+	if FormatCapabilities(v).Code {
 		writeLittleByte(wt, ms.Code)
 	}
 }
 
+// Deprecated: Use Decoder.Decode, which reports errors.
 func MeshUnMarshal(rd io.Reader) *Mesh {
-	ms := Mesh{}
-	sig := make([]byte, 4)
-	rd.Read(sig)
-	readLittleByte(rd, &ms.Version)
-	ms.BaseMesh = *baseMeshUnMarshal(rd, ms.Version)
-	ms.InstanceNode = MeshInstanceNodesUnMarshal(rd, ms.Version)
-	if ms.Version == V4 {
-		readLittleByte(rd, &ms.Code)
-	}
-	return &ms
+	return newDecoder(rd, 0, nil).mesh()
 }
 
 func baseMeshUnMarshal(rd io.Reader, v uint32) *BaseMesh {
-	ms := &BaseMesh{}
-	ms.Materials = MtlsUnMarshal(rd, v)
-	ms.Nodes = MeshNodesUnMarshal(rd)
-	if v == V4 {
-		readLittleByte(rd, &ms.Code)
-	}
-	return ms
+	return newDecoder(rd, v, nil).baseMesh()
 }
 
+// Deprecated: Use Encoder.EncodeInstances.
 func MeshInstanceNodesMarshal(wt io.Writer, instNd []*InstanceMesh, v uint32) {
+	instanceNodesMarshal(wt, instNd, v)
+}
+
+func instanceNodesMarshal(wt io.Writer, instNd []*InstanceMesh, v uint32) {
 	writeLittleByte(wt, uint32(len(instNd)))
 	for _, nd := range instNd {
-		MeshInstanceNodeMarshal(wt, nd, v)
+		instanceNodeMarshal(wt, nd, v)
 	}
 }
 
+// Deprecated: Use Encoder.EncodeInstance.
 func MeshInstanceNodeMarshal(wt io.Writer, instNd *InstanceMesh, v uint32) {
+	instanceNodeMarshal(wt, instNd, v)
+}
+
+func instanceNodeMarshal(wt io.Writer, instNd *InstanceMesh, v uint32) {
 	writeLittleByte(wt, uint32(len(instNd.Transfors)))
 	for _, mt := range instNd.Transfors {
 		writeLittleByte(wt, mt[0][:])
@@ -776,76 +1037,85 @@ func MeshInstanceNodeMarshal(wt io.Writer, instNd *InstanceMesh, v uint32) {
 		writeLittleByte(wt, mt[2][:])
 		writeLittleByte(wt, mt[3][:])
 	}
+	caps := FormatCapabilities(v)
 	writeLittleByte(wt, uint32(len(instNd.Features)))
 	for _, f := range instNd.Features {
-		writeLittleByte(wt, f)
+		if caps.Features64 {
+			writeLittleByte(wt, f)
+		} else {
+			writeLittleByte(wt, uint32(f))
+		}
 	}
 	writeLittleByte(wt, instNd.BBox)
-	baseMeshMarshal(wt, instNd.Mesh, v)
-	writeLittleByte(wt, instNd.Hash)
+	hash := instNd.Hash
+	if caps.InstanceRefs && instNd.Ref != nil {
+		baseMeshMarshal(wt, &BaseMesh{}, v)
+		hash = instNd.Ref.Hash
+	} else {
+		baseMeshMarshal(wt, instNd.Mesh, v)
+		if hash == 0 && instNd.Mesh != nil {
+			hash = ComputeBaseMeshHash(instNd.Mesh)
+		}
+	}
+	writeLittleByte(wt, hash)
+	if caps.Props {
+		PropertiesMarshal(wt, instNd.Props)
+	}
+	if caps.InstanceRefs {
+		instanceRefMarshal(wt, instNd.Ref)
+	}
+	if caps.InstanceBounds {
+		instanceBoundsMarshal(wt, instNd)
+	}
 }
 
+// Deprecated: Use Decoder.DecodeInstances.
 func MeshInstanceNodesUnMarshal(rd io.Reader, v uint32) []*InstanceMesh {
-	var size uint32
-	readLittleByte(rd, &size)
-	nds := make([]*InstanceMesh, size)
-	for i := range nds {
-		nds[i] = MeshInstanceNodeUnMarshal(rd, v)
-	}
-	return nds
+	return newDecoder(rd, v, nil).instanceNodes()
 }
 
+// Deprecated: Use Decoder.DecodeInstance.
 func MeshInstanceNodeUnMarshal(rd io.Reader, v uint32) *InstanceMesh {
-	inst := &InstanceMesh{}
-	var size uint32
-	readLittleByte(rd, &size)
-	inst.Transfors = make([]*dmat.T, size)
-	for i := range inst.Transfors {
-		mt := &dmat.T{}
-		readLittleByte(rd, &mt[0])
-		readLittleByte(rd, &mt[1])
-		readLittleByte(rd, &mt[2])
-		readLittleByte(rd, &mt[3])
-		inst.Transfors[i] = mt
-	}
-	var fsize uint32
-	readLittleByte(rd, &fsize)
-	inst.Features = make([]uint64, fsize)
-	if v < V3 {
-		fs := make([]uint32, fsize)
-		readLittleByte(rd, &fs)
-		for i, f := range fs {
-			inst.Features[i] = uint64(f)
-		}
-	} else {
-		readLittleByte(rd, &inst.Features)
-	}
-
-	inst.BBox = &[6]float64{}
-	readLittleByte(rd, inst.BBox)
-	inst.Mesh = baseMeshUnMarshal(rd, v)
-	readLittleByte(rd, &inst.Hash)
-	return inst
+	return newDecoder(rd, v, nil).instanceNode()
 }
 
 func MeshReadFrom(path string) (*Mesh, error) {
+	return MeshReadFromWithOptions(path, &DefaultDecodeOptions)
+}
+
+func MeshReadFromWithLimits(path string, limits *DecodeLimits) (*Mesh, error) {
+	return MeshReadFromWithOptions(path, &DecodeOptions{Limits: limits, VerifyIntegrity: true})
+}
+
+func MeshReadFromWithOptions(path string, opts *DecodeOptions) (*Mesh, error) {
 	f, e := os.Open(path)
 	if e != nil {
 		return nil, e
 	}
 	defer f.Close()
-	return MeshUnMarshal(f), nil
+	if opts != nil && opts.Materials == nil {
+		cp := *opts
+		cp.Materials = NewFileMaterialResolver(filepath.Dir(path))
+		opts = &cp
+	}
+	return MeshUnMarshalWithOptions(bufio.NewReader(f), opts)
 }
 
-func MeshWriteTo(path string, ms *Mesh) error {
+func MeshWriteTo(path string, ms *Mesh, opts ...WriteOption) error {
+	if err := newWriteOptions(opts).validate(); err != nil {
+		return err
+	}
 	os.MkdirAll(filepath.Dir(path), os.ModePerm)
 	f, e := os.Create(path)
 	if e != nil {
 		return e
 	}
 	defer f.Close()
-	MeshMarshal(f, ms)
-	return nil
+	bw := bufio.NewWriter(f)
+	if err := meshMarshal(bw, ms, newWriteOptions(opts)); err != nil {
+		return err
+	}
+	return bw.Flush()
 }
 
 func CompressImage(buf []byte) []byte {
@@ -867,24 +1137,48 @@ func DecompressImage(src []byte) ([]byte, error) {
 }
 
 func LoadTexture(tex *Texture, flipY bool) (image.Image, error) {
+	if tex.IsExternal() {
+		return nil, ErrUnresolvedTexture
+	}
+	if err := tex.Validate(); err != nil {
+		return nil, err
+	}
+	if !tex.IsImage() {
+		return nil, &TextureFormatError{Format: tex.Format, Type: tex.Type}
+	}
+	if tex.Compressed == TEXTURE_COMPRESSED_SOURCE {
+		src, _, err := image.Decode(bytes.NewReader(tex.Data))
+		if err != nil {
+			return nil, err
+		}
+		if !flipY {
+			return src, nil
+		}
+		bd := src.Bounds()
+		var img draw.Image = image.NewNRGBA(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+		if isWideImage(src) {
+			img = image.NewNRGBA64(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+		}
+		for y := 0; y < bd.Dy(); y++ {
+			for x := 0; x < bd.Dx(); x++ {
+				img.Set(x, bd.Dy()-y-1, src.At(bd.Min.X+x, bd.Min.Y+y))
+			}
+		}
+		return img, nil
+	}
 	w := int(tex.Size[0])
 	h := int(tex.Size[1])
-	img := image.NewNRGBA(image.Rect(0, 0, w, h))
-	data := tex.Data
-	var sz int
-	if tex.Format == TEXTURE_FORMAT_RGB {
-		sz = 3
-	} else if tex.Format == TEXTURE_FORMAT_RGBA {
-		sz = 4
-	} else if tex.Format == TEXTURE_FORMAT_R {
-		sz = 1
+	data, e := textureCache.Pixels(tex)
+	if e != nil {
+		return nil, e
 	}
-	var e error
-	if tex.Compressed == TEXTURE_COMPRESSED_ZLIB {
-		data, e = DecompressImage(data)
-		if e != nil && e.Error() != "EOF" {
-			return nil, e
-		}
+	sz := textureChannels(tex.Format)
+	if tex.Type == TEXTURE_PIXEL_TYPE_USHORT {
+		return loadWideTexture(data, w, h, sz, flipY)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if len(data) < w*h*sz {
+		return nil, io.ErrUnexpectedEOF
 	}
 
 	for i := 0; i < h; i++ {
@@ -895,6 +1189,8 @@ func LoadTexture(tex *Texture, flipY bool) (image.Image, error) {
 				c = color.NRGBA{R: data[p], G: data[p+1], B: data[p+2], A: data[p+3]}
 			} else if sz == 3 {
 				c = color.NRGBA{R: data[p], G: data[p+1], B: data[p+2], A: 255}
+			} else if sz == 2 {
+				c = color.NRGBA{R: data[p], G: data[p+1], A: 255}
 			} else if sz == 1 {
 				c = color.NRGBA{R: data[p], G: data[p], B: data[p], A: 255}
 			}
@@ -909,17 +1205,66 @@ func LoadTexture(tex *Texture, flipY bool) (image.Image, error) {
 	return img, nil
 }
 
+func loadWideTexture(data []byte, w, h, sz int, flipY bool) (image.Image, error) {
+	if len(data) < w*h*sz*2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	at := func(p int) uint16 { return binary.LittleEndian.Uint16(data[p*2:]) }
+	for i := 0; i < h; i++ {
+		for j := 0; j < w; j++ {
+			p := i*w*sz + j*sz
+			var c color.NRGBA64
+			if sz == 4 {
+				c = color.NRGBA64{R: at(p), G: at(p + 1), B: at(p + 2), A: at(p + 3)}
+			} else if sz == 3 {
+				c = color.NRGBA64{R: at(p), G: at(p + 1), B: at(p + 2), A: 0xffff}
+			} else if sz == 2 {
+				c = color.NRGBA64{R: at(p), G: at(p + 1), A: 0xffff}
+			} else if sz == 1 {
+				c = color.NRGBA64{R: at(p), G: at(p), B: at(p), A: 0xffff}
+			}
+			y := i
+			if flipY {
+				y = h - i - 1
+			}
+			img.SetNRGBA64(j, y, c)
+		}
+	}
+	return img, nil
+}
+
 func CreateTexture(name string, repet bool) (*Texture, error) {
+	return CreateTextureWithCompression(name, repet, TEXTURE_COMPRESSED_ZLIB)
+}
+
+func CreateTextureWithCompression(name string, repet bool, compressed uint16) (*Texture, error) {
 	reader, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
-	_, format, err := image.DecodeConfig(reader)
+	cfg, format, err := image.DecodeConfig(reader)
 	if err != nil {
 		return nil, err
 	}
 	reader.Seek(0, io.SeekStart)
+	if compressed == TEXTURE_COMPRESSED_SOURCE {
+		if format != "png" && format != "jpeg" {
+			return nil, errors.New("source-encoded textures must be png or jpeg")
+		}
+		src, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		_, fn := filepath.Split(name)
+		tex := &Texture{Name: fn, Format: TEXTURE_FORMAT_RGBA, Size: [2]uint64{uint64(cfg.Width), uint64(cfg.Height)}, Compressed: compressed, Data: src, Repeated: repet}
+		switch cfg.ColorModel {
+		case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+			tex.Type = TEXTURE_PIXEL_TYPE_USHORT
+		}
+		return tex, nil
+	}
 	var img image.Image
 	switch format {
 	case "jpeg", "jpg":
@@ -936,23 +1281,40 @@ func CreateTexture(name string, repet bool) (*Texture, error) {
 		return nil, errors.New("unknow format")
 	}
 
+	if err != nil {
+		return nil, err
+	}
 	bd := img.Bounds()
+	wide := isWideImage(img)
 	buf1 := []byte{}
 
-	for y := 0; y < bd.Dy(); y++ {
-		for x := 0; x < bd.Dx(); x++ {
-			cl := img.At(x, y)
-			r, g, b, a := color.RGBAModel.Convert(cl).RGBA()
-			buf1 = append(buf1, byte(r&0xff), byte(g&0xff), byte(b&0xff), byte(a&0xff))
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			if wide {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				for _, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+					buf1 = append(buf1, byte(v), byte(v>>8))
+				}
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			buf1 = append(buf1, c.R, c.G, c.B, c.A)
 		}
 	}
 	t := &Texture{}
+	if wide {
+		t.Type = TEXTURE_PIXEL_TYPE_USHORT
+	}
 	_, fn := filepath.Split(name)
 	t.Name = fn
 	t.Format = TEXTURE_FORMAT_RGBA
 	t.Size = [2]uint64{uint64(bd.Dx()), uint64(bd.Dy())}
-	t.Compressed = TEXTURE_COMPRESSED_ZLIB
-	t.Data = CompressImage(buf1)
+	t.Compressed = compressed
+	if compressed == TEXTURE_COMPRESSED_ZLIB {
+		t.Data = CompressImage(buf1)
+	} else {
+		t.Data = buf1
+	}
 	t.Repeated = repet
 	return t, err
 }
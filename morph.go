@@ -0,0 +1,147 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+const maxMorphTargets = 1 << 10
+
+type MorphTarget struct {
+	Name      string   `json:"name,omitempty"`
+	Weight    float32  `json:"weight,omitempty"`
+	Positions []vec3.T `json:"positions,omitempty"`
+	Normals   []vec3.T `json:"normals,omitempty"`
+}
+
+func (n *MeshNode) AddMorphTarget(name string, weight float32, positions, normals []vec3.T) error {
+	if len(positions) != 0 && len(positions) != len(n.Vertices) {
+		return fmt.Errorf("mst: morph target %q has %d positions for %d vertices", name, len(positions), len(n.Vertices))
+	}
+	if len(normals) != 0 && len(normals) != len(n.Normals) {
+		return fmt.Errorf("mst: morph target %q has %d normals for %d normals", name, len(normals), len(n.Normals))
+	}
+	n.MorphTargets = append(n.MorphTargets, &MorphTarget{Name: name, Weight: weight, Positions: positions, Normals: normals})
+	return nil
+}
+
+func hasMorphTargets(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool { return len(nd.MorphTargets) > 0 })
+}
+
+func (n *MeshNode) MorphWeights() []float32 {
+	if len(n.MorphTargets) == 0 {
+		return nil
+	}
+	ws := make([]float32, len(n.MorphTargets))
+	for i, t := range n.MorphTargets {
+		ws[i] = t.Weight
+	}
+	return ws
+}
+
+func (n *MeshNode) ApplyMorph(weights []float32) *MeshNode {
+	out := *n
+	out.Vertices = append([]vec3.T(nil), n.Vertices...)
+	out.Normals = append([]vec3.T(nil), n.Normals...)
+	out.MorphTargets = nil
+	for i, t := range n.MorphTargets {
+		w := t.Weight
+		if i < len(weights) {
+			w = weights[i]
+		}
+		if w == 0 {
+			continue
+		}
+		for j := 0; j < len(t.Positions) && j < len(out.Vertices); j++ {
+			for c := 0; c < 3; c++ {
+				out.Vertices[j][c] += t.Positions[j][c] * w
+			}
+		}
+		for j := 0; j < len(t.Normals) && j < len(out.Normals); j++ {
+			for c := 0; c < 3; c++ {
+				out.Normals[j][c] += t.Normals[j][c] * w
+			}
+		}
+	}
+	for i := range out.Normals {
+		if len(n.MorphTargets) > 0 && out.Normals[i].Length() > 0 {
+			out.Normals[i].Normalize()
+		}
+	}
+	return &out
+}
+
+func transformMorphTargets(targets []*MorphTarget, mt *dmat.T) []*MorphTarget {
+	if targets == nil {
+		return nil
+	}
+	nm := *mt
+	nm.Invert()
+	nm.Transpose()
+	apply := func(vs []vec3.T, m *dmat.T) []vec3.T {
+		if vs == nil {
+			return nil
+		}
+		out := make([]vec3.T, len(vs))
+		for i, v := range vs {
+			p := dvec3.T{float64(v[0]), float64(v[1]), float64(v[2])}
+			m.TransformVec3W(&p, 0)
+			out[i] = vec3.T{float32(p[0]), float32(p[1]), float32(p[2])}
+		}
+		return out
+	}
+	out := make([]*MorphTarget, len(targets))
+	for i, t := range targets {
+		out[i] = &MorphTarget{Name: t.Name, Weight: t.Weight, Positions: apply(t.Positions, mt), Normals: apply(t.Normals, &nm)}
+	}
+	return out
+}
+
+func morphTargetsMarshal(wt io.Writer, targets []*MorphTarget) {
+	writeLittleByte(wt, uint32(len(targets)))
+	for _, t := range targets {
+		writeLittleByte(wt, uint32(len(t.Name)))
+		wt.Write([]byte(t.Name))
+		writeLittleByte(wt, t.Weight)
+		writeLittleByte(wt, uint32(len(t.Positions)))
+		writeLittleByte(wt, t.Positions)
+		writeLittleByte(wt, uint32(len(t.Normals)))
+		writeLittleByte(wt, t.Normals)
+	}
+}
+
+func (d *decoder) morphTargets() []*MorphTarget {
+	n := d.count("morph target count", func(l *DecodeLimits) uint32 { return maxMorphTargets })
+	if n == 0 {
+		return nil
+	}
+	maxVertices := func(l *DecodeLimits) uint32 { return l.MaxVertices }
+	targets := make([]*MorphTarget, 0, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		t := &MorphTarget{Name: d.string("morph target name length")}
+		d.read(&t.Weight)
+		if m := d.count("morph position count", maxVertices); m > 0 {
+			t.Positions = d.vec3s(m)
+		}
+		if m := d.count("morph normal count", maxVertices); m > 0 {
+			t.Normals = d.vec3s(m)
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (d *decoder) skipMorphTargets() {
+	n := d.count("morph target count", func(l *DecodeLimits) uint32 { return maxMorphTargets })
+	for i := 0; i < n && d.err == nil; i++ {
+		d.skip(int64(d.count("morph target name length", func(l *DecodeLimits) uint32 { return l.MaxNameLength })))
+		d.skip(4)
+		d.skipArray("morph position count", 12)
+		d.skipArray("morph normal count", 12)
+	}
+}
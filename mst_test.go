@@ -1,18 +1,24 @@
 package mst
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
-	"image/jpeg"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
 	proj "github.com/flywave/go-proj"
+	dmat "github.com/flywave/go3d/float64/mat4"
 	"github.com/flywave/go3d/float64/vec3"
+	fvec2 "github.com/flywave/go3d/vec2"
 	fvec3 "github.com/flywave/go3d/vec3"
 	"github.com/qmuntal/gltf"
 	"github.com/xtgo/uuid"
@@ -40,132 +46,6 @@ func TestDe(t *testing.T) {
 	gltf.Open("/home/hj/workspace/GISCore/build/public/Resources/anchormodel/public/psqitong/qitong.glb")
 }
 
-func MstToObj(path, destName string) {
-	dir, _ := filepath.Split(path)
-	faceTemp1 := "f %d %d %d \n"
-	faceTemp12 := "f %d//%d %d//%d %d//%d \n"
-	faceTemp21 := "f %d/%d %d/%d %d/%d \n"
-
-	faceTemp3 := "f %d/%d/%d %d/%d/%d %d/%d/%d \n"
-
-	vertTmp := "v %f %f %f \n"
-	nvTmp := "vn %f %f %f \n"
-
-	uvTmp := "vt %f %f \n"
-
-	ms, _ := MeshReadFrom(path)
-	fl, _ := os.Create(fmt.Sprintf("%s/%s_convert.obj", dir, destName))
-	mtlTex, _ := os.Create(fmt.Sprintf("%s/%s_convert.mtl", dir, destName))
-	fl.Write([]byte(fmt.Sprintf("mtllib %s_convert.mtl \n", destName)))
-	var vertCount uint32 = 1
-	for _, nd := range ms.Nodes {
-		for _, v := range nd.Vertices {
-			fl.Write([]byte(fmt.Sprintf(vertTmp, v[0], v[1], v[2])))
-		}
-		for _, v := range nd.Normals {
-			fl.Write([]byte(fmt.Sprintf(nvTmp, v[0], v[1], v[2])))
-		}
-		for _, v := range nd.TexCoords {
-			fl.Write([]byte(fmt.Sprintf(uvTmp, v[0], v[1])))
-		}
-	}
-
-	for _, nd := range ms.Nodes {
-		hasvn := false
-		hasvt := false
-		if len(nd.Normals) > 0 {
-			hasvn = true
-		}
-		if len(nd.TexCoords) > 0 {
-			hasvt = true
-		}
-		for _, g := range nd.FaceGroup {
-			fl.Write([]byte(fmt.Sprintf("usemtl material_%d \n", g.Batchid)))
-			if hasvn && hasvt {
-				for _, face := range g.Faces {
-					fl.Write([]byte(fmt.Sprintf(faceTemp3, face.Vertex[0]+vertCount, face.Vertex[0]+vertCount, face.Vertex[0]+vertCount, face.Vertex[1]+vertCount, face.Vertex[1]+vertCount, face.Vertex[1]+vertCount, face.Vertex[2]+vertCount, face.Vertex[2]+vertCount, face.Vertex[2]+vertCount)))
-				}
-			} else if hasvn {
-				for _, face := range g.Faces {
-					fl.Write([]byte(fmt.Sprintf(faceTemp12, face.Vertex[0]+vertCount, face.Vertex[0]+vertCount, face.Vertex[1]+vertCount, face.Vertex[1]+vertCount, face.Vertex[2]+vertCount, face.Vertex[2]+vertCount)))
-				}
-			} else if hasvt {
-				for _, face := range g.Faces {
-					fl.Write([]byte(fmt.Sprintf(faceTemp21, face.Vertex[0]+vertCount, face.Vertex[0]+vertCount, face.Vertex[1]+vertCount, face.Vertex[1]+vertCount, face.Vertex[2]+vertCount, face.Vertex[2]+vertCount)))
-				}
-			} else {
-				for _, face := range g.Faces {
-					fl.Write([]byte(fmt.Sprintf(faceTemp1, face.Vertex[0]+vertCount, face.Vertex[1]+vertCount, face.Vertex[2]+vertCount)))
-				}
-			}
-		}
-		vertCount += uint32(len(nd.Vertices))
-	}
-	fl.Close()
-
-	for idx, m := range ms.Materials {
-		mtlTex.Write([]byte(fmt.Sprintf("newmtl material_%d \n", idx)))
-		mtlTex.Write([]byte("Ka 0.200000 0.200000 0.200000\n"))
-		mtlTex.Write([]byte("Tr 1.000000\n"))
-		mtlTex.Write([]byte("Ks 1.000000 1.000000 1.000000\n"))
-		mtlTex.Write([]byte("Ns 000 \n"))
-		mtlTex.Write([]byte("illum 2\n"))
-
-		var tex *Texture
-		cl := [3]byte{255, 255, 255}
-		switch mtl := m.(type) {
-		case *TextureMaterial:
-			tex = mtl.Texture
-			cl = mtl.Color
-		case *PhongMaterial:
-			tex = mtl.Texture
-			cl = mtl.Color
-		case *LambertMaterial:
-			tex = mtl.Texture
-			cl = mtl.Color
-		case *BaseMaterial:
-			cl = mtl.Color
-		}
-
-		if tex != nil {
-			bt, _ := DecompressImage(tex.Data)
-			width := int(tex.Size[0])
-			height := int(tex.Size[1])
-			img := image.NewNRGBA(image.Rect(0, 0, width, height))
-			for y := height - 1; y > -1; y-- {
-				for x := 0; x < width; x++ {
-					index := y*width*4 + x*4
-					if tex.Format == TEXTURE_FORMAT_RGBA {
-						img.Set(x, y, color.NRGBA{
-							R: bt[index],
-							G: bt[index+1],
-							B: bt[index+2],
-							A: bt[index+3],
-						})
-					} else if tex.Format == TEXTURE_FORMAT_RGB {
-						img.Set(x, y, color.NRGBA{
-							R: bt[index],
-							G: bt[index+1],
-							B: bt[index+2],
-							A: 255,
-						})
-					}
-				}
-			}
-			imgNmae := fmt.Sprintf("node_tex_0_%d.jpg", tex.Id)
-			im, _ := os.Create(filepath.Join(dir, imgNmae))
-			jpeg.Encode(im, img, &jpeg.Options{Quality: 95})
-			im.Close()
-			mtlTex.Write([]byte(fmt.Sprintf("map_Kd %s \n", imgNmae)))
-		} else {
-			// bm, ok := m.(*PhongMaterial)
-			// if !ok {
-			// }
-			mtlTex.Write([]byte(fmt.Sprintf("Kd %f %f %f  \n", float32(cl[0])/255, float32(cl[1])/255, float32(cl[2])/255)))
-		}
-	}
-}
-
 func TestVec(t *testing.T) {
 	world := &vec3.T{-2389250.4338499242, 4518270.200871248, 3802675.424745363}
 	head := &vec3.T{4.771371435839683, -0.753607839345932, 3.867249683942646}
@@ -204,3 +84,1170 @@ func TestMst2Gltf(t *testing.T) {
 	bt, _ := GetGltfBinary(doc, 8)
 	ioutil.WriteFile("tests/test1.glb", bt, os.ModePerm)
 }
+
+func newTestCubeNode() *MeshNode {
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 1, 1, 1})
+	nd.TexCoords = make([]fvec2.T, len(nd.Vertices))
+	for i, v := range nd.Vertices {
+		nd.TexCoords[i] = fvec2.T{v[0], v[1]}
+	}
+	return nd
+}
+
+func newTestMesh() *Mesh {
+	ms := NewMesh()
+	ms.Materials = append(ms.Materials, &BaseMaterial{Color: [3]byte{255, 0, 0}}, &PbrMaterial{TextureMaterial: TextureMaterial{BaseMaterial: BaseMaterial{Color: [3]byte{0, 255, 0}}}, Metallic: 0.5, Roughness: 0.5})
+	ms.Nodes = append(ms.Nodes, newTestCubeNode())
+	nd := newTestCubeNode()
+	nd.FaceGroup[0].Batchid = 1
+	ms.Nodes = append(ms.Nodes, nd)
+	mt := dmat.Ident
+	mt.SetTranslation(&vec3.T{10, 0, 0})
+	ms.InstanceNode = append(ms.InstanceNode, &InstanceMesh{
+		Transfors: []*dmat.T{&dmat.Ident, &mt},
+		Features:  []uint64{1, 2},
+		BBox:      &[6]float64{0, 0, 0, 1, 1, 1},
+		Mesh:      &BaseMesh{Materials: []MeshMaterial{&BaseMaterial{Color: [3]byte{0, 0, 255}}}, Nodes: []*MeshNode{newTestCubeNode()}},
+	})
+	return ms
+}
+
+func TestExportEnginePackage(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "mst_engine")
+	defer os.RemoveAll(dir)
+	pkg, err := ExportEnginePackage(dir, "cube", newTestMesh(), DefaultEngineExportOptions(ENGINE_UNREAL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Assets) != 3 {
+		t.Fatalf("expected 3 assets, got %d", len(pkg.Assets))
+	}
+	if pkg.Assets[0].Collision == nil || pkg.Assets[0].Collision.Name != "UCX_SM_cube_node_0_00" {
+		t.Fatal("missing collision proxy")
+	}
+	if len(pkg.Assets[2].Instances) != 2 {
+		t.Fatal("missing instance transforms")
+	}
+	doc, err := gltf.Open(filepath.Join(dir, pkg.Assets[1].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Materials) != 2 || doc.Nodes[0].Name != "SM_cube_node_1_LOD0" {
+		t.Fatal("unexpected glb content")
+	}
+}
+
+func TestMaterialTemplates(t *testing.T) {
+	tpl := NewMaterialTemplate("facade", &PbrMaterial{TextureMaterial: TextureMaterial{BaseMaterial: BaseMaterial{Color: [3]byte{1, 2, 3}}}, Roughness: 0.8})
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{tpl.Derive([3]byte{255, 0, 0}), tpl.Derive([3]byte{0, 255, 0})}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec := MeshUnMarshal(buf)
+	pm, ok := dec.Materials[1].(*PbrMaterial)
+	if !ok || pm.Color != [3]byte{0, 255, 0} || pm.Roughness != 0.8 {
+		t.Fatal("template not resolved on marshal")
+	}
+	if err := ms.ResolveMaterials(); err != nil {
+		t.Fatal(err)
+	}
+	if ms.Materials[0].GetColor() != [3]byte{255, 0, 0} {
+		t.Fatal("unexpected resolved color")
+	}
+	if tpl.Material.GetColor() != [3]byte{1, 2, 3} {
+		t.Fatal("template base modified")
+	}
+}
+
+func TestMeshJSONRoundTrip(t *testing.T) {
+	ms := newTestMesh()
+	ms.Materials = append(ms.Materials, &TextureMaterial{Texture: &Texture{Id: 1, Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: []byte{1, 2, 3, 4}}})
+	ms.Props = Properties{"count": int64(2), "ratio": 0.5, "tags": []interface{}{"a", true}, "sub": map[string]interface{}{"id": int64(7)}}
+	ms.InstanceNode[0].Props = Properties{"kind": "tree"}
+	ms.Animations = []*Animation{{Name: "grow", Tracks: []*AnimationTrack{{TargetType: ANIMATION_TARGET_INSTANCE, Path: ANIMATION_PATH_SCALE, Interpolation: ANIMATION_INTERPOLATION_STEP, Times: []float32{0, 2}, Values: []float32{1, 1, 1, 2, 2, 2}}}}}
+	bt, err := json.Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec Mesh
+	if err := json.Unmarshal(bt, &dec); err != nil {
+		t.Fatal(err)
+	}
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	MeshMarshal(a, ms)
+	MeshMarshal(b, &dec)
+	if len(dec.Materials) != 3 || dec.Props["count"] != int64(2) || dec.InstanceNode[0].Props["kind"] != "tree" {
+		t.Fatal("json round trip lost data")
+	}
+	if tm := dec.Materials[2].(*TextureMaterial); !bytes.Equal(tm.Texture.Data, []byte{1, 2, 3, 4}) {
+		t.Fatal("texture data lost")
+	}
+	if a.Len() != b.Len() {
+		t.Fatal("binary size differs after json round trip")
+	}
+	if !dec.Equal(ms) {
+		t.Fatal("json round trip changed the mesh")
+	}
+	if !reflect.DeepEqual(dec.Animations, ms.Animations) {
+		t.Fatal("json round trip lost animations")
+	}
+}
+
+func TestMeshPipe(t *testing.T) {
+	rd := MeshPipe(newTestMesh(), 16)
+	ms, err := MeshUnMarshalWithOptions(rd, &DefaultDecodeOptions)
+	rd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(ms.Nodes))
+	}
+
+	rd, err = MeshGlbPipe([]*Mesh{newTestMesh()}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(rd, head); err != nil || string(head) != "glTF" {
+		t.Fatalf("unexpected glb header %q: %v", head, err)
+	}
+	rd.Close()
+}
+
+func TestFlattenInstances(t *testing.T) {
+	ms := newTestMesh()
+	ms.InstanceNode[0].Props = Properties{"kind": "tree"}
+	ms.FlattenInstances()
+	if len(ms.InstanceNode) != 0 || len(ms.Nodes) != 4 || len(ms.Materials) != 3 {
+		t.Fatalf("unexpected flatten result: %d nodes, %d materials", len(ms.Nodes), len(ms.Materials))
+	}
+	nd := ms.Nodes[3]
+	if nd.FaceGroup[0].Batchid != 2 {
+		t.Fatalf("expected batchid 2, got %d", nd.FaceGroup[0].Batchid)
+	}
+	if bx := nd.GetBoundbox(); bx[0] != 10 || bx[3] != 11 {
+		t.Fatalf("transform not applied: %v", bx)
+	}
+	if nd.Props["feature"] != int64(2) || nd.Props["kind"] != "tree" {
+		t.Fatalf("unexpected props %v", nd.Props)
+	}
+}
+
+func TestGltfTextureLevels(t *testing.T) {
+	ms := GenerateMesh(&GenerateOptions{Seed: 1, Nodes: 1, Subdivisions: 1, Materials: 1, Textures: 1, TextureSize: 64})
+	doc := CreateDoc()
+	if err := BuildGltfWithOptions(doc, ms, &GltfExportOptions{TextureLevels: []uint32{16, 128}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Images) != 2 || len(doc.Textures) != 1 {
+		t.Fatalf("expected 2 images and 1 texture, got %d and %d", len(doc.Images), len(doc.Textures))
+	}
+	extras, ok := doc.Textures[0].Extras.(*TextureLevelsExtras)
+	if !ok || len(extras.Levels) != 2 || extras.Levels[1].Width != 16 {
+		t.Fatalf("unexpected texture levels %+v", doc.Textures[0].Extras)
+	}
+}
+
+func TestDetectInstances(t *testing.T) {
+	ms := newTestMesh()
+	ms.FlattenInstances()
+	for _, nd := range ms.Nodes {
+		nd.Props = nil
+	}
+	out, err := DetectInstances(ms, 1e-4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Nodes) != 2 || len(out.InstanceNode) != 1 {
+		t.Fatalf("expected 2 nodes and 1 instance, got %d and %d", len(out.Nodes), len(out.InstanceNode))
+	}
+	inst := out.InstanceNode[0]
+	if len(inst.Transfors) != 2 || inst.Hash == 0 || len(inst.Mesh.Materials) != 1 {
+		t.Fatal("unexpected instance content")
+	}
+	if dx := inst.Transfors[1][3][0] - inst.Transfors[0][3][0]; dx != 10 {
+		t.Fatalf("expected translation offset 10, got %v", dx)
+	}
+	if _, err := DetectInstances(ms, 0); err != ErrInvalidTolerance {
+		t.Fatal("expected ErrInvalidTolerance")
+	}
+}
+
+func TestResortVtVnIdempotent(t *testing.T) {
+	nd := newTestCubeNode()
+	if mode := nd.DetectIndexingMode(); mode != INDEXING_MODE_SHARED {
+		t.Fatalf("expected shared indexing, got %d", mode)
+	}
+	nd.ResortVtVn(nil)
+	if len(nd.Vertices) != 36 || len(nd.Normals) != 36 || nd.DetectIndexingMode() != INDEXING_MODE_FLATTENED {
+		t.Fatalf("unexpected flattened node: %d vertices", len(nd.Vertices))
+	}
+	nd.IndexingMode = INDEXING_MODE_AUTO
+	nd.ResortVtVn(nil)
+	if len(nd.Vertices) != 36 {
+		t.Fatalf("resort not idempotent: %d vertices", len(nd.Vertices))
+	}
+}
+
+func TestAddFaces(t *testing.T) {
+	nd := &MeshNode{Vertices: []fvec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}}
+	if err := nd.AddFaces(2, []*Face{{Vertex: [3]uint32{0, 1, 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddFaces(0, []*Face{{Vertex: [3]uint32{0, 2, 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.AddFaces(2, []*Face{{Vertex: [3]uint32{1, 2, 0}}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.FaceGroup) != 2 || nd.FaceGroup[0].Batchid != 0 || len(nd.FaceGroup[1].Faces) != 2 {
+		t.Fatal("faces not merged into sorted groups")
+	}
+	var ierr *IndexError
+	if err := nd.AddFaces(0, []*Face{{Vertex: [3]uint32{0, 1, 3}}}); !errors.As(err, &ierr) {
+		t.Fatalf("expected index error, got %v", err)
+	}
+	if err := nd.AddEdges(0, [][2]uint32{{0, 5}}); err == nil {
+		t.Fatal("expected edge index error")
+	}
+}
+
+func TestComputeBaseMeshHash(t *testing.T) {
+	ms := newTestMesh()
+	want := ComputeBaseMeshHash(ms.InstanceNode[0].Mesh)
+	if want != ComputeBaseMeshHash(newTestMesh().InstanceNode[0].Mesh) {
+		t.Fatal("hash not deterministic")
+	}
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(buf, &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.InstanceNode[0].Hash != want {
+		t.Fatal("writer did not populate instance hash")
+	}
+	nd := newTestCubeNode()
+	h := ComputeMeshHash(nd)
+	nd.Vertices[0][0] += 0.5
+	if ComputeMeshHash(nd) == h {
+		t.Fatal("hash ignores vertex positions")
+	}
+}
+
+func TestGltfContinueOnError(t *testing.T) {
+	ms := newTestMesh()
+	tm := ms.Materials[1].(*PbrMaterial)
+	tm.Texture = &Texture{Id: 1, Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_RGBA, Compressed: TEXTURE_COMPRESSED_SOURCE, Data: []byte("broken")}
+	bad := newTestCubeNode()
+	bad.FaceGroup[0].Faces[0].Vertex[0] = 100
+	ms.Nodes = append(ms.Nodes, bad)
+	ms.InstanceNode = append(ms.InstanceNode, &InstanceMesh{Transfors: []*dmat.T{&dmat.Ident}, Ref: &InstanceRef{URI: "missing.mst"}})
+
+	if _, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{}); err == nil {
+		t.Fatal("expected strict export to fail")
+	}
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{ContinueOnError: true})
+	var merr *MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != 3 {
+		t.Fatalf("expected 3 aggregated errors, got %v", err)
+	}
+	if doc == nil || len(doc.Meshes) != 3 || len(doc.Textures) != 0 {
+		t.Fatal("partial document not produced")
+	}
+	var ierr *IndexError
+	if !errors.As(merr.Errors[0], &ierr) || merr.Errors[0].(*AssetError).Field != "meshes[0].nodes[2]" {
+		t.Fatalf("unexpected first error %v", merr.Errors[0])
+	}
+}
+
+func TestBoundingVolumes(t *testing.T) {
+	nd := boxProxyNode(&[6]float64{0, 0, 0, 10, 1, 1})
+	for i, v := range nd.Vertices {
+		nd.Vertices[i] = fvec3.T{(v[0] - v[1]) * 0.70710677, (v[0] + v[1]) * 0.70710677, v[2]}
+	}
+	obb := nd.ComputeOBB()
+	if v := obb.Volume(); v < 9.9 || v > 10.1 {
+		t.Fatalf("expected a tight oriented box, got volume %v", v)
+	}
+	sphere := nd.ComputeBoundingSphere()
+	for _, v := range nd.Vertices {
+		p := vec3.T{float64(v[0]), float64(v[1]), float64(v[2])}
+		c := vec3.T(sphere.Center)
+		if vec3.Distance(&p, &c) > sphere.Radius+1e-6 {
+			t.Fatalf("vertex %v outside sphere %v", v, sphere)
+		}
+	}
+	if sphere.Radius > 5.2 {
+		t.Fatalf("bounding sphere too large: %v", sphere.Radius)
+	}
+
+	ms := newTestMesh()
+	box := ms.ComputeOBB().TilesBox()
+	if box[0] < 5.4 || box[0] > 5.6 {
+		t.Fatalf("mesh box does not cover instances: %v", box)
+	}
+	if m := BuildManifest(ms); m.Box == nil || m.Sphere == nil || m.Sphere[3] < 5.5 {
+		t.Fatal("manifest missing bounding volumes")
+	}
+}
+
+func TestMeshObjMarshal(t *testing.T) {
+	ms := newTestMesh()
+	buf := &bytes.Buffer{}
+	if err := MeshObjMarshal(buf, ms, "cube.mtl"); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	var lastFace string
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		fs := strings.Fields(l)
+		counts[fs[0]]++
+		if fs[0] == "f" {
+			lastFace = l
+		}
+	}
+	if counts["v"] != 16 || counts["vn"] != 16 || counts["vt"] != 16 || counts["f"] != 24 || counts["mtllib"] != 1 {
+		t.Fatalf("unexpected obj element counts %v", counts)
+	}
+	if lastFace != "f 10/10/10 16/16/16 14/14/14" {
+		t.Fatalf("unexpected face offsets %q", lastFace)
+	}
+	for v, want := range map[float32]string{-0.25: "-0.25", 1234.5: "1234.5", -0.0000001: "0", 3: "3", 1e13: "10000000000000"} {
+		if got := string(appendObjFloat(nil, v)); got != want {
+			t.Fatalf("appendObjFloat(%v) = %q, want %q", v, got, want)
+		}
+	}
+	mtl := &bytes.Buffer{}
+	if err := MeshMtlMarshal(mtl, ms); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mtl.String(), "newmtl material_1\n") || !strings.Contains(mtl.String(), "Kd 1 0 0\n") {
+		t.Fatalf("unexpected mtl output %q", mtl.String())
+	}
+}
+
+func BenchmarkMeshObjMarshal(b *testing.B) {
+	opts := DefaultGenerateOptions
+	opts.Subdivisions = 64
+	opts.Textures = 0
+	ms := GenerateMesh(&opts)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := MeshObjMarshal(ioutil.Discard, ms, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBakeAOVertexColors(t *testing.T) {
+	floor := &MeshNode{
+		Vertices:  []fvec3.T{{-5, -5, 0}, {5, -5, 0}, {5, 5, 0}, {-5, 5, 0}, {1.1, 0.5, 0}},
+		FaceGroup: []*MeshTriangle{{Faces: []*Face{{Vertex: [3]uint32{0, 1, 2}}, {Vertex: [3]uint32{0, 2, 3}}, {Vertex: [3]uint32{0, 1, 4}}}}},
+	}
+	ms := NewMesh()
+	ms.Nodes = append(ms.Nodes, floor, newTestCubeNode())
+	if err := ms.BakeAOVertexColors(0); err != ErrInvalidSampleCount {
+		t.Fatalf("expected ErrInvalidSampleCount, got %v", err)
+	}
+	if err := ms.BakeAOVertexColors(64); err != nil {
+		t.Fatal(err)
+	}
+	if len(floor.Colors) != len(floor.Vertices) || len(ms.Nodes[1].Colors) != len(ms.Nodes[1].Vertices) {
+		t.Fatal("ao not stored per vertex")
+	}
+	if floor.Colors[0][0] < 240 || floor.Colors[4][0] > 200 || floor.Colors[4][0] == 0 {
+		t.Fatalf("unexpected ambient occlusion %v", floor.Colors)
+	}
+
+	nd := newTestCubeNode()
+	if err := nd.SetLightmap(&Texture{Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: []byte{1, 2, 3, 4}}); err != ErrNoLightmapUVs {
+		t.Fatalf("expected ErrNoLightmapUVs, got %v", err)
+	}
+	nd.TexCoords2 = append([]fvec2.T(nil), nd.TexCoords...)
+	if err := nd.SetLightmap(&Texture{Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: []byte{1, 2, 3, 4}}); err != nil {
+		t.Fatal(err)
+	}
+	ms = NewMesh()
+	ms.Nodes = append(ms.Nodes, nd)
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	out, err := MeshUnMarshalWithOptions(bytes.NewReader(buf.Bytes()), &DefaultDecodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Version != V18 || out.Nodes[0].GetLightmap() == nil || !bytes.Equal(out.Nodes[0].Lightmap.Data, []byte{1, 2, 3, 4}) {
+		t.Fatal("lightmap not preserved")
+	}
+}
+
+func TestExtractOutlines(t *testing.T) {
+	nd := newTestCubeNode()
+	if err := nd.ExtractOutlines(-1, false); err != ErrInvalidCreaseAngle {
+		t.Fatalf("expected ErrInvalidCreaseAngle, got %v", err)
+	}
+	if err := nd.ExtractOutlines(30, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.EdgeGroup) != 1 || len(nd.EdgeGroup[0].Edges) != 12 {
+		t.Fatalf("expected 12 cube edges, got %v", nd.EdgeGroup)
+	}
+
+	flat := newTestCubeNode()
+	flat.ResortVtVn(nil)
+	if err := flat.ExtractOutlines(30, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(flat.EdgeGroup) != 1 || len(flat.EdgeGroup[0].Edges) != 12 {
+		t.Fatal("welded edges not detected on flattened cube")
+	}
+
+	quad := &MeshNode{
+		Vertices:  []fvec3.T{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+		FaceGroup: []*MeshTriangle{{Batchid: 2, Faces: []*Face{{Vertex: [3]uint32{0, 1, 2}}, {Vertex: [3]uint32{0, 2, 3}}}}},
+	}
+	if err := quad.ExtractOutlines(0, false); err != nil || len(quad.EdgeGroup) != 0 {
+		t.Fatal("coplanar diagonal reported as crease")
+	}
+	if err := quad.ExtractOutlines(0, true); err != nil || len(quad.EdgeGroup) != 1 || len(quad.EdgeGroup[0].Edges) != 4 || quad.EdgeGroup[0].Batchid != 2 {
+		t.Fatalf("expected 4 boundary edges, got %v", quad.EdgeGroup)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	nd := newTestCubeNode()
+	nd.Colors = make([][3]byte, len(nd.Vertices))
+	nd.Colors[7] = [3]byte{7, 7, 7}
+	g := nd.FaceGroup[0]
+	g.Faces = append(g.Faces[:len(g.Faces)-2:len(g.Faces)-2], &Face{Vertex: [3]uint32{0, 0, 1}}, &Face{Vertex: [3]uint32{g.Faces[0].Vertex[2], g.Faces[0].Vertex[0], g.Faces[0].Vertex[1]}})
+	nd.Vertices = append(nd.Vertices, fvec3.T{9, 9, 9})
+	nd.Colors = append(nd.Colors, [3]byte{})
+	nd.TexCoords = append(nd.TexCoords, fvec2.T{})
+
+	if _, err := nd.Cleanup(CleanupOptions{AreaEpsilon: -1}); err == nil {
+		t.Fatal("expected negative options to be rejected")
+	}
+	rep, err := nd.Cleanup(CleanupOptions{AreaEpsilon: 1e-12, MaxHoleEdges: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.DegenerateFaces != 1 || rep.DuplicateFaces != 1 || rep.UnusedVertices != 1 || rep.FilledHoles != 1 {
+		t.Fatalf("unexpected cleanup report %+v", rep)
+	}
+	if len(nd.Vertices) != 8 || len(nd.Colors) != 8 || len(nd.TexCoords) != 8 || nd.Colors[7] != [3]byte{7, 7, 7} || len(nd.FaceGroup[0].Faces) != 12 {
+		t.Fatal("node not compacted")
+	}
+	edges := map[[2]uint32]bool{}
+	for _, f := range nd.FaceGroup[0].Faces {
+		for k := 0; k < 3; k++ {
+			edges[[2]uint32{f.Vertex[k], f.Vertex[(k+1)%3]}] = true
+		}
+	}
+	for e := range edges {
+		if !edges[[2]uint32{e[1], e[0]}] {
+			t.Fatalf("hole not closed consistently at edge %v", e)
+		}
+	}
+}
+
+func TestRegroupByMaterial(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{255, 0, 0}}, &BaseMaterial{Color: [3]byte{0, 255, 0}}}
+	nd := newTestCubeNode()
+	red := int32(1)
+	nd.FaceGroup[0].Faces[2].Material = &red
+	nd.FaceGroup[0].Faces[3].Material = &red
+	ms.Nodes = append(ms.Nodes, nd)
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec := MeshUnMarshal(buf)
+	if g := dec.Nodes[0].FaceGroup; len(g) != 2 || g[0].Batchid != 0 || len(g[0].Faces) != 10 || g[1].Batchid != 1 || len(g[1].Faces) != 2 {
+		t.Fatal("encoded face groups not regrouped by material")
+	}
+	if len(nd.FaceGroup) != 1 {
+		t.Fatal("encoding must not modify the source node")
+	}
+
+	ms.RegroupByMaterial()
+	if len(nd.FaceGroup) != 2 || nd.FaceGroup[1].Faces[0].Material != nil || nd.FaceGroup[1].Faces[0].Vertex != dec.Nodes[0].FaceGroup[1].Faces[0].Vertex {
+		t.Fatal("node not regrouped by material")
+	}
+}
+
+func TestReorderMaterials(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{1}}, &BaseMaterial{Color: [3]byte{2}}, &BaseMaterial{Color: [3]byte{3}}}
+	nd := newTestCubeNode()
+	nd.FaceGroup = []*MeshTriangle{{Batchid: 0, Faces: nd.FaceGroup[0].Faces[:6]}, {Batchid: 2, Faces: nd.FaceGroup[0].Faces[6:]}}
+	face := int32(1)
+	nd.FaceGroup[0].Faces[0].Material = &face
+	nd.EdgeGroup = []*MeshOutline{{Batchid: 2, Edges: [][2]uint32{{0, 1}}}}
+	ms.Nodes = append(ms.Nodes, nd)
+
+	if err := ms.ReorderMaterials([]int{0, 0, 1}); err != ErrInvalidMaterialOrder {
+		t.Fatalf("expected ErrInvalidMaterialOrder, got %v", err)
+	}
+	if err := ms.ReorderMaterials([]int{2, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ms.Materials[0].GetColor() != [3]byte{3} || nd.FaceGroup[0].Batchid != 0 || len(nd.FaceGroup[0].Faces) != 6 || nd.FaceGroup[1].Batchid != 1 ||
+		*nd.FaceGroup[1].Faces[0].Material != 2 || nd.EdgeGroup[0].Batchid != 0 {
+		t.Fatal("batchids not updated with material order")
+	}
+
+	var ie *IndexError
+	if err := ms.RemapBatchids(map[int32]int32{0: 3}); !errors.As(err, &ie) {
+		t.Fatalf("expected IndexError, got %v", err)
+	}
+	if err := ms.RemapBatchids(map[int32]int32{0: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.FaceGroup) != 1 || nd.FaceGroup[0].Batchid != 1 || len(nd.FaceGroup[0].Faces) != 12 {
+		t.Fatal("face groups sharing a batchid not merged")
+	}
+}
+
+func TestMeshBuilder(t *testing.T) {
+	b := NewMeshBuilder()
+	var wg sync.WaitGroup
+	nodes := make([]int, 8)
+	errs := make([]error, 8)
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mtl, err := b.AddMaterial(&BaseMaterial{Color: [3]byte{byte(i)}})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			nd := newTestCubeNode()
+			nd.FaceGroup[0].Batchid = mtl
+			nodes[i], errs[i] = b.AddNode(nd)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ms, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Nodes) != 8 || len(ms.Materials) != 8 {
+		t.Fatal("builder lost concurrent additions")
+	}
+	for i, n := range nodes {
+		if ms.Materials[ms.Nodes[n].FaceGroup[0].Batchid].GetColor()[0] != byte(i) {
+			t.Fatal("builder indices not stable")
+		}
+	}
+	if _, err := b.AddNode(newTestCubeNode()); err != ErrBuilderFinished {
+		t.Fatalf("expected ErrBuilderFinished, got %v", err)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&TextureMaterial{Texture: &Texture{Size: [2]uint64{2, 2}, Format: TEXTURE_FORMAT_RGBA, Data: make([]byte, 16)}}}
+	nd := newTestCubeNode()
+	nd.Props = Properties{"name": "cube"}
+	ms.Nodes = append(ms.Nodes, nd)
+	ms.Props = Properties{"tile": int64(3)}
+
+	for _, v := range []uint32{V5, MESH_LATEST_VERSION} {
+		for _, opts := range [][]WriteOption{nil, {WithChecksum(), WithSectionTable()}} {
+			cp := *ms
+			cp.Version = v
+			buf := &bytes.Buffer{}
+			MeshMarshal(buf, &cp, opts...)
+			est, err := ms.EstimateSerializedSize(v, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if est.Total() != int64(buf.Len()) {
+				t.Fatalf("version %d: estimated %d bytes, wrote %d", v, est.Total(), buf.Len())
+			}
+			if est.Vertices < int64(len(nd.Vertices))*12 || est.Indices == 0 || est.Textures != 16 || est.Props == 0 || est.Other <= 0 {
+				t.Fatalf("unexpected breakdown %+v", est)
+			}
+		}
+	}
+	if _, err := ms.EstimateSerializedSize(0); err != ErrUnsupportedVersion {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	mem := ms.EstimateMemory()
+	if mem.Vertices < int64(len(nd.Vertices))*12 || mem.Indices == 0 || mem.Textures != 16 || mem.Total() <= mem.Vertices+mem.Indices {
+		t.Fatalf("unexpected memory estimate %+v", mem)
+	}
+}
+
+func TestClone(t *testing.T) {
+	tex := &Texture{Size: [2]uint64{1, 1}, Format: TEXTURE_FORMAT_RGBA, Data: []byte{1, 2, 3, 4}}
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&PbrMaterial{TextureMaterial: TextureMaterial{Texture: tex}}, &TextureMaterial{Texture: tex}}
+	nd := newTestCubeNode()
+	nd.Props = Properties{"tags": []interface{}{"a"}}
+	ms.Nodes = append(ms.Nodes, nd)
+	mt := dmat.Ident
+	ms.InstanceNode = []*InstanceMesh{{Transfors: []*dmat.T{&mt}, Features: []uint64{7}, Mesh: &BaseMesh{Nodes: []*MeshNode{newTestCubeNode()}}}}
+
+	want := &bytes.Buffer{}
+	MeshMarshal(want, ms)
+
+	cp := ms.Clone()
+	got := &bytes.Buffer{}
+	MeshMarshal(got, cp)
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Fatal("clone does not serialize identically")
+	}
+
+	cp.Nodes[0].Vertices[0][0] += 1
+	cp.Nodes[0].FaceGroup[0].Faces[0].Vertex[0] = 1
+	cp.Nodes[0].Props["tags"].([]interface{})[0] = "b"
+	cp.Materials[0].GetTexture().Data[0] = 9
+	cp.InstanceNode[0].Transfors[0][0][0] = 2
+	cp.InstanceNode[0].Mesh.Nodes[0].Vertices[0][0] += 1
+	after := &bytes.Buffer{}
+	MeshMarshal(after, ms)
+	if !bytes.Equal(want.Bytes(), after.Bytes()) || nd.Props["tags"].([]interface{})[0] != "a" {
+		t.Fatal("mutating the clone changed the original")
+	}
+	if cp.Materials[0].GetTexture() != cp.Materials[1].GetTexture() {
+		t.Fatal("clone does not preserve texture sharing between materials")
+	}
+
+	shared := ms.Clone(WithSharedTextures())
+	if shared.Materials[0].GetTexture() != tex || shared.Materials[0] == ms.Materials[0] {
+		t.Fatal("expected shared textures on cloned materials")
+	}
+	if mtl := ms.Materials[1].(*TextureMaterial).Clone(); mtl.GetTexture() == tex || !bytes.Equal(mtl.GetTexture().Data, tex.Data) {
+		t.Fatal("material clone did not copy its texture")
+	}
+}
+
+func TestGltfMergeByMaterial(t *testing.T) {
+	ms := NewMesh()
+	ms.Materials = append(ms.Materials, &BaseMaterial{Name: "red", Color: [3]byte{255, 0, 0}}, &BaseMaterial{Name: "green", Color: [3]byte{0, 255, 0}})
+	faces, verts := 0, 0
+	for i := 0; i < 10; i++ {
+		nd := newTestCubeNode()
+		nd.FaceGroup[0].Batchid = int32(i % 2)
+		mt := dmat.Ident
+		mt.SetTranslation(&vec3.T{float64(i) * 2, 0, 0})
+		nd.Mat = &mt
+		faces += len(nd.FaceGroup[0].Faces)
+		verts += len(nd.Vertices)
+		ms.Nodes = append(ms.Nodes, nd)
+	}
+	count := func(doc *gltf.Document) (int, int) {
+		n := 0
+		for _, m := range doc.Meshes {
+			for _, p := range m.Primitives {
+				n += int(doc.Accessors[*p.Indices].Count) / 3
+			}
+		}
+		return len(doc.Meshes), n
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{MergeByMaterial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meshes, n := count(doc); meshes != 2 || n != faces {
+		t.Fatalf("expected 2 merged meshes with %d faces, got %d with %d", faces, meshes, n)
+	}
+	if doc.Meshes[0].Name != "red" || doc.Nodes[0].Matrix != [16]float32{} && doc.Nodes[0].Matrix != gltf.DefaultMatrix {
+		t.Fatalf("unexpected merged node %+v", doc.Nodes[0])
+	}
+	if max := doc.Accessors[doc.Meshes[0].Primitives[0].Attributes["POSITION"]].Max; max[0] != 17 {
+		t.Fatalf("node transforms not baked, max %v", max)
+	}
+
+	doc, err = MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{MergeByMaterial: true, MaxMergedVertices: verts / 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meshes, n := count(doc); meshes <= 2 || meshes >= len(ms.Nodes) || n != faces {
+		t.Fatalf("vertex limit not respected: %d meshes, %d faces", meshes, n)
+	}
+	for _, m := range doc.Meshes {
+		if c := doc.Accessors[m.Primitives[0].Attributes["POSITION"]].Count; int(c) > verts/4 {
+			t.Fatalf("merged mesh has %d vertices", c)
+		}
+	}
+}
+
+func TestProjectUV(t *testing.T) {
+	nd := newTestCubeNode()
+	nd.TexCoords = nil
+	if err := nd.ProjectUVPlanar(AXIS_Y, 2); err != nil || nd.TexCoords[7] != (fvec2.T{-2, 2}) {
+		t.Fatalf("unexpected planar projection %v: %v", nd.TexCoords, err)
+	}
+	if err := nd.DrapeUV([4]float64{0, 0, 2, 4}); err != nil || nd.TexCoords[7] != (fvec2.T{0.5, 0.75}) {
+		t.Fatalf("unexpected drape %v: %v", nd.TexCoords, err)
+	}
+
+	if err := nd.ProjectUVBox(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(nd.Vertices) <= 8 || len(nd.Normals) != len(nd.Vertices) || len(nd.TexCoords) != len(nd.Vertices) {
+		t.Fatalf("box projection did not split seams: %d vertices", len(nd.Vertices))
+	}
+	for _, f := range nd.FaceGroup[0].Faces {
+		fn := faceNormal(nd.Vertices, f)
+		for _, v := range f.Vertex {
+			p, uv := nd.Vertices[v], nd.TexCoords[v]
+			switch {
+			case fn[0] != 0 && uv[1] != p[2], fn[1] != 0 && uv[1] != p[2], fn[2] != 0 && uv[0] != p[0]:
+				t.Fatalf("face %v with normal %v has uv %v at %v", f.Vertex, fn, uv, p)
+			}
+		}
+	}
+
+	ring := &MeshNode{}
+	const segs = 8
+	for i := 0; i < segs; i++ {
+		a := 2 * math.Pi * float64(i) / segs
+		ring.Vertices = append(ring.Vertices, fvec3.T{float32(math.Cos(a)), float32(math.Sin(a)), 0}, fvec3.T{float32(math.Cos(a)), float32(math.Sin(a)), 2})
+	}
+	g := &MeshTriangle{}
+	for i := uint32(0); i < segs; i++ {
+		j := (i + 1) % segs
+		g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{2 * i, 2 * j, 2*j + 1}}, &Face{Vertex: [3]uint32{2 * i, 2*j + 1, 2*i + 1}})
+	}
+	ring.FaceGroup = []*MeshTriangle{g}
+	if err := ring.ProjectUVCylindrical(AXIS_Z, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if len(ring.Vertices) != 2*segs+2 {
+		t.Fatalf("expected one seam column, got %d vertices", len(ring.Vertices))
+	}
+	for _, f := range g.Faces {
+		for _, v := range f.Vertex {
+			uv := ring.TexCoords[v]
+			if u := ring.TexCoords[f.Vertex[0]][0]; math.Abs(float64(uv[0]-u)) > 0.2 || uv[1] != ring.Vertices[v][2]*0.5 {
+				t.Fatalf("face %v wraps across the seam: %v", f.Vertex, uv)
+			}
+		}
+	}
+
+	if err := nd.ProjectUVPlanar(3, 1); err == nil {
+		t.Fatal("expected error for an invalid axis")
+	}
+	if err := nd.ProjectUVBox(0); err == nil {
+		t.Fatal("expected error for a zero scale")
+	}
+}
+
+func TestClassificationPalette(t *testing.T) {
+	ramp := ColorRamp{{Value: 0, Color: [4]byte{0, 0, 0, 255}}, {Value: 10, Color: [4]byte{200, 100, 0, 255}}}
+	if c := ramp.At(5); c != [4]byte{100, 50, 0, 255} || ramp.At(-1) != ramp[0].Color || ramp.At(20) != ramp[1].Color {
+		t.Fatalf("unexpected ramp color %v", c)
+	}
+	tex, err := ramp.Texture(11)
+	if err != nil || tex.Size != [2]uint64{11, 1} || !bytes.Equal(tex.Data[4*5:4*6], []byte{100, 50, 0, 255}) {
+		t.Fatalf("unexpected ramp texture: %v", err)
+	}
+	if _, err := PaletteTexture(nil); err != ErrEmptyPalette {
+		t.Fatalf("expected ErrEmptyPalette, got %v", err)
+	}
+
+	ms := newTestMesh()
+	colors := [][4]byte{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	classify := &Classification{Colors: colors, ClassOf: func(nd *MeshNode, batchid int32, f *Face) int {
+		if f.Vertex[0] == 0 {
+			return 2
+		}
+		return int(batchid)
+	}}
+	cp := ms.Clone()
+	if err := cp.ApplyClassification(classify); err != nil {
+		t.Fatal(err)
+	}
+	nd := cp.Nodes[1]
+	if len(cp.Materials) != 3 || nd.FaceGroup[0].Batchid != 2 || len(nd.TexCoords) != len(nd.Vertices) || len(nd.Normals) != len(nd.Vertices) {
+		t.Fatalf("classification not applied: %d materials", len(cp.Materials))
+	}
+	for _, f := range nd.FaceGroup[0].Faces {
+		want := PaletteUV(1, 3)
+		if nd.Vertices[f.Vertex[0]] == (fvec3.T{}) {
+			want = PaletteUV(2, 3)
+		}
+		for _, v := range f.Vertex {
+			if nd.TexCoords[v] != want {
+				t.Fatalf("face %v has uv %v, want %v", f.Vertex, nd.TexCoords[v], want)
+			}
+		}
+	}
+	if len(cp.InstanceNode[0].Mesh.Materials) != 2 {
+		t.Fatal("instance prototype not classified")
+	}
+
+	doc, err := MstToGltfWithOptions([]*Mesh{ms}, &GltfExportOptions{Classification: classify})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Materials) != 2 || ms.Nodes[0].TexCoords[1] == PaletteUV(0, 3) {
+		t.Fatal("export modified the source mesh")
+	}
+	nearest := 0
+	for _, sp := range doc.Samplers {
+		if sp.MagFilter == gltf.MagNearest && sp.WrapS == gltf.WrapClampToEdge {
+			nearest++
+		}
+	}
+	if nearest == 0 {
+		t.Fatal("palette texture not exported with nearest filtering")
+	}
+
+	bad := &Classification{Colors: colors, ClassOf: func(*MeshNode, int32, *Face) int { return 3 }}
+	if err := ms.Clone().ApplyClassification(bad); err == nil {
+		t.Fatal("expected error for an out of range class")
+	}
+}
+
+func TestAxisConvention(t *testing.T) {
+	ms := newTestMesh()
+	mt := dmat.Ident
+	mt.SetTranslation(&vec3.T{0, 5, 2})
+	ms.Nodes[1].Mat = &mt
+	ms.InstanceNode[0].Transfors[1].SetTranslation(&vec3.T{0, 10, 3})
+	ms.Animations = []*Animation{{Name: "spin", Tracks: []*AnimationTrack{
+		{Target: 0, Path: ANIMATION_PATH_TRANSLATION, Times: []float32{0}, Values: []float32{1, 2, 3}},
+		{Target: 0, Path: ANIMATION_PATH_ROTATION, Times: []float32{0}, Values: []float32{0, 0, 0.6, 0.8}},
+		{Target: 0, Path: ANIMATION_PATH_SCALE, Times: []float32{0}, Values: []float32{1, 2, 3}},
+	}}}
+
+	translation := func(m *dmat.T) vec3.T { return vec3.T{m[3][0], m[3][1], m[3][2]} }
+	yup, err := ms.ConvertAxis(AXIS_CONVENTION_Z_UP, AXIS_CONVENTION_Y_UP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := yup.Nodes[0].Vertices[7]; v != (fvec3.T{1, 1, -1}) {
+		t.Fatalf("vertex not rotated: %v", v)
+	}
+	if tr := translation(yup.Nodes[1].Mat); tr != (vec3.T{0, 2, -5}) || yup.Nodes[1].Mat[1][1] != 1 {
+		t.Fatalf("node transform not rotated: %v", yup.Nodes[1].Mat)
+	}
+	if tr := translation(yup.InstanceNode[0].Transfors[1]); tr != (vec3.T{0, 3, -10}) {
+		t.Fatalf("instance transform not rotated: %v", tr)
+	}
+	if bx := yup.InstanceNode[0].BBox; *bx != [6]float64{0, 0, -1, 1, 1, 0} {
+		t.Fatalf("instance bbox not rotated: %v", *bx)
+	}
+	tracks := yup.Animations[0].Tracks
+	if fmt.Sprint(tracks[0].Values, tracks[1].Values, tracks[2].Values) != "[1 3 -2] [0 0.6 -0 0.8] [1 3 2]" {
+		t.Fatalf("animation not rotated: %v %v %v", tracks[0].Values, tracks[1].Values, tracks[2].Values)
+	}
+	if ms.Nodes[0].Vertices[7] != (fvec3.T{1, 1, 1}) || ms.Animations[0].Tracks[0].Values[1] != 2 {
+		t.Fatal("conversion modified the source mesh")
+	}
+	back, err := yup.ConvertAxis(AXIS_CONVENTION_Y_UP, AXIS_CONVENTION_Z_UP)
+	if err != nil || !back.AlmostEqual(ms, 1e-6) {
+		t.Fatalf("axis round trip changed the mesh: %v", err)
+	}
+	if same, _ := ms.ConvertAxis(AXIS_CONVENTION_NONE, AXIS_CONVENTION_Y_UP); same != ms {
+		t.Fatal("expected no conversion without a source convention")
+	}
+	if _, err := ms.ConvertAxis(AXIS_CONVENTION_Z_UP, 7); err == nil {
+		t.Fatal("expected error for an unknown axis convention")
+	}
+
+	cube := NewMesh()
+	cube.Materials = []MeshMaterial{&BaseMaterial{}}
+	cube.Nodes = []*MeshNode{newTestCubeNode()}
+	doc, err := MstToGltfWithOptions([]*Mesh{cube}, &GltfExportOptions{AxisConvention: AXIS_CONVENTION_Z_UP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := doc.Accessors[doc.Meshes[0].Primitives[0].Attributes["POSITION"]]
+	if pos.Min[2] != -1 || pos.Max[1] != 1 {
+		t.Fatalf("glTF positions not Y-up: %v %v", pos.Min, pos.Max)
+	}
+	imp, err := GltfToMstWithOptions(doc, &GltfImportOptions{AxisConvention: AXIS_CONVENTION_Z_UP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range imp.Nodes[0].Vertices {
+		if v != cube.Nodes[0].Vertices[i] {
+			t.Fatalf("imported vertex %d is %v, want %v", i, v, cube.Nodes[0].Vertices[i])
+		}
+	}
+
+	obj := &bytes.Buffer{}
+	if err := MeshObjMarshalWithOptions(obj, cube, "", &ObjExportOptions{AxisConvention: AXIS_CONVENTION_Z_UP}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(obj.String(), "v 1 1 -1\n") {
+		t.Fatal("OBJ vertices not Y-up")
+	}
+}
+
+func TestConvertUnits(t *testing.T) {
+	ms := newTestMesh()
+	ms.Units = UNITS_MILLIMETERS
+	mt := dmat.Ident
+	mt.SetTranslation(&vec3.T{0, 5000, 0})
+	ms.Nodes[1].Mat = &mt
+
+	translation := func(m *dmat.T) vec3.T { return vec3.T{m[3][0], m[3][1], m[3][2]} }
+	out, err := ms.ConvertUnits(UNITS_METERS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Units != UNITS_METERS || out.Nodes[0].Vertices[7] != (fvec3.T{0.001, 0.001, 0.001}) {
+		t.Fatalf("vertices not scaled: %v", out.Nodes[0].Vertices[7])
+	}
+	if out.Nodes[0].Normals[0] != ms.Nodes[0].Normals[0] {
+		t.Fatal("normals should not be scaled")
+	}
+	if tr := translation(out.Nodes[1].Mat); tr != (vec3.T{0, 5, 0}) || out.Nodes[1].Mat[1][1] != 1 {
+		t.Fatalf("node transform not scaled: %v", out.Nodes[1].Mat)
+	}
+	if tr := translation(out.InstanceNode[0].Transfors[1]); math.Abs(tr[0]-0.01) > 1e-12 {
+		t.Fatalf("instance transform not scaled: %v", tr)
+	}
+	if bx := out.InstanceNode[0].BBox; bx[3] != 0.001 {
+		t.Fatalf("instance bbox not scaled: %v", *bx)
+	}
+	if ms.Units != UNITS_MILLIMETERS || ms.Nodes[0].Vertices[7] != (fvec3.T{1, 1, 1}) {
+		t.Fatal("conversion modified the source mesh")
+	}
+	if same, err := out.ConvertUnits(UNITS_METERS); err != nil || !same.Equal(out) {
+		t.Fatalf("converting to the same units changed the mesh: %v", err)
+	}
+	if _, err := NewMesh().ConvertUnits(UNITS_METERS); err != ErrUnknownUnits {
+		t.Fatalf("expected ErrUnknownUnits, got %v", err)
+	}
+	if _, err := ms.ConvertUnits(42); err == nil {
+		t.Fatal("expected error for unknown target units")
+	}
+
+	buf := &bytes.Buffer{}
+	MeshMarshal(buf, ms)
+	dec := MeshUnMarshal(buf)
+	if dec.Version != V23 || dec.Units != UNITS_MILLIMETERS {
+		t.Fatalf("units not round tripped: version %d units %d", dec.Version, dec.Units)
+	}
+	old, warns := ConvertVersion(ms, V22)
+	if old.Units != UNITS_UNKNOWN || len(warns) == 0 || warns[len(warns)-1].Field != "units" {
+		t.Fatalf("expected units to be dropped for V22: %v", warns)
+	}
+}
+
+func TestThreejsImport(t *testing.T) {
+	scene := `{
+	"metadata": {"version": 4.5, "type": "Object"},
+	"geometries": [{"uuid": "g1", "type": "BufferGeometry", "data": {
+		"attributes": {
+			"position": {"itemSize": 3, "type": "Float32Array", "array": [0,0,0, 1,0,0, 1,1,0, 0,1,0]},
+			"normal": {"itemSize": 3, "type": "Float32Array", "array": [0,0,1, 0,0,1, 0,0,1, 0,0,1]},
+			"uv": {"itemSize": 2, "type": "Float32Array", "array": [0,0, 1,0, 1,1, 0,1]}
+		},
+		"index": {"type": "Uint16Array", "array": [0,1,2, 0,2,3]},
+		"groups": [{"start": 0, "count": 3, "materialIndex": 0}, {"start": 3, "count": null, "materialIndex": 1}]
+	}}],
+	"materials": [
+		{"uuid": "m1", "type": "MeshStandardMaterial", "name": "std", "color": 16711680, "roughness": 0.5, "metalness": 0.25, "map": "t1"},
+		{"uuid": "m2", "type": "MeshPhongMaterial", "color": 255, "specular": 1118481, "shininess": 30, "opacity": 0.5, "transparent": true}
+	],
+	"textures": [{"uuid": "t1", "image": "i1", "wrap": [1000, 1000]}],
+	"images": [{"uuid": "i1", "url": "JingGai_RL.jpg"}],
+	"object": {"uuid": "root", "type": "Scene", "userData": {"site": "a"}, "children": [
+		{"uuid": "o1", "type": "Mesh", "name": "quad", "geometry": "g1", "material": ["m1", "m2"], "matrix": [1,0,0,0, 0,1,0,0, 0,0,1,0, 10,0,0,1], "userData": {"id": 7}},
+		{"uuid": "o2", "type": "Group", "matrix": [2,0,0,0, 0,2,0,0, 0,0,2,0, 0,0,0,1], "children": [
+			{"uuid": "o3", "type": "Mesh", "geometry": "g1", "material": "m2"}
+		]},
+		{"uuid": "o4", "type": "Points", "geometry": "g1"}
+	]}
+}`
+	var logs []string
+	logger := LoggerFunc(func(level uint8, msg string, keyvals ...interface{}) { logs = append(logs, msg) })
+	ms, err := ThreejsToMst([]byte(scene), &ThreejsImportOptions{BaseDir: "tests", Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms.Materials) != 2 || len(ms.Nodes) != 2 || ms.Version != V8 || ms.Props["site"] != "a" {
+		t.Fatalf("unexpected scene: %d materials, %d nodes, version %d", len(ms.Materials), len(ms.Nodes), ms.Version)
+	}
+	std, ok := ms.Materials[0].(*PbrMaterial)
+	if !ok || std.Color != [3]byte{255, 0, 0} || std.Metallic != 0.25 || std.Roughness != 0.5 || std.Texture == nil || !std.Texture.Repeated || std.Texture.ColorSpace != TEXTURE_COLOR_SPACE_SRGB {
+		t.Fatalf("unexpected standard material %#v", ms.Materials[0])
+	}
+	phong, ok := ms.Materials[1].(*PhongMaterial)
+	if !ok || phong.Color != [3]byte{0, 0, 255} || phong.Specular != [3]byte{17, 17, 17} || phong.Shininess != 30 || phong.Transparency != 0.5 {
+		t.Fatalf("unexpected phong material %#v", ms.Materials[1])
+	}
+	quad := ms.Nodes[0]
+	if quad.Name != "quad" || quad.Vertices[1] != (fvec3.T{11, 0, 0}) || quad.Props["id"] != int64(7) || len(quad.TexCoords) != 4 {
+		t.Fatalf("unexpected quad node %v %v", quad.Vertices, quad.Props)
+	}
+	if len(quad.FaceGroup) != 2 || quad.FaceGroup[0].Batchid != 0 || quad.FaceGroup[1].Batchid != 1 || len(quad.FaceGroup[1].Faces) != 1 {
+		t.Fatal("groups not mapped to materials")
+	}
+	scaled := ms.Nodes[1]
+	if scaled.Vertices[2] != (fvec3.T{2, 2, 0}) || len(scaled.FaceGroup) != 1 || scaled.FaceGroup[0].Batchid != 1 || len(scaled.FaceGroup[0].Faces) != 2 {
+		t.Fatalf("unexpected nested node %v", scaled.Vertices)
+	}
+	if len(logs) != 1 || logs[0] != "skipped unsupported three.js object" {
+		t.Fatalf("unexpected logs %v", logs)
+	}
+
+	legacy := `{
+	"metadata": {"formatVersion": 3.1},
+	"scale": 2,
+	"materials": [{"DbgName": "tile", "colorDiffuse": [1, 0, 0], "shading": "lambert", "mapDiffuse": "JingGai_RL.jpg"}],
+	"vertices": [0,0,0, 2,0,0, 2,2,0, 0,2,0],
+	"normals": [0,0,1],
+	"uvs": [[0,0, 1,0, 1,1, 0,1]],
+	"faces": [43, 0,1,2,3, 0, 0,1,2,3, 0,0,0,0]
+}`
+	ms, err = ThreejsToMst([]byte(legacy), &ThreejsImportOptions{BaseDir: "tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, ok := ms.Materials[0].(*LambertMaterial)
+	if !ok || lm.Name != "tile" || lm.Diffuse != [3]byte{255, 0, 0} || lm.Texture == nil {
+		t.Fatalf("unexpected legacy material %#v", ms.Materials[0])
+	}
+	nd := ms.Nodes[0]
+	if nd.Vertices[2] != (fvec3.T{1, 1, 0}) || len(nd.FaceGroup[0].Faces) != 2 || nd.GetIndexingMode() != INDEXING_MODE_SEPARATE {
+		t.Fatalf("unexpected legacy node %v", nd.Vertices)
+	}
+	if f := nd.FaceGroup[0].Faces[1]; f.Vertex != [3]uint32{1, 2, 3} || *f.Uv != [3]uint32{1, 2, 3} || *f.Normal != [3]uint32{0, 0, 0} {
+		t.Fatalf("unexpected quad split %v", f.Vertex)
+	}
+
+	geom := `{"metadata": {"type": "BufferGeometry"}, "type": "BufferGeometry", "data": {"attributes": {
+		"position": {"itemSize": 3, "type": "Float32Array", "array": [0,0,0, 1,0,0, 0,1,0]},
+		"color": {"itemSize": 3, "type": "Uint8Array", "normalized": true, "array": [255,0,0, 0,255,0, 0,0,255]}
+	}}}`
+	ms, err = ThreejsToMst([]byte(geom), nil)
+	if err != nil || len(ms.Materials) != 1 || len(ms.Nodes[0].FaceGroup[0].Faces) != 1 || ms.Nodes[0].Colors[1] != [3]byte{0, 255, 0} {
+		t.Fatalf("unexpected buffer geometry import: %v", err)
+	}
+
+	if _, err := ThreejsToMstFromFile("tests/JingGai_RL.json", nil); err != ErrThreejsBinary {
+		t.Fatalf("expected ErrThreejsBinary, got %v", err)
+	}
+	if _, err := ThreejsToMst([]byte(`{"metadata": {}}`), nil); err != ErrThreejsUnknown {
+		t.Fatalf("expected ErrThreejsUnknown, got %v", err)
+	}
+}
+
+func TestThreejsBinToMesh(t *testing.T) {
+	open := func(name string) *os.File {
+		f, err := os.Open(filepath.Join("tests", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	js, bin := open("BYjishuiqi.json"), open("BYjishuiqi.bin")
+	ms, err := ThreejsBinToMesh(js, bin, nil)
+	js.Close()
+	bin.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := MeshReadFrom("tests/BYjishuiqi.mst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, rnd := ms.Nodes[0], ref.Nodes[0]
+	if len(ms.Materials) != len(ref.Materials) || len(nd.FaceGroup) != len(rnd.FaceGroup) {
+		t.Fatalf("got %d materials, %d groups", len(ms.Materials), len(nd.FaceGroup))
+	}
+	if ph, ok := ms.Materials[0].(*PhongMaterial); !ok || ph.Name != "phong3SG" || ph.Color[0] != ref.Materials[0].(*PbrMaterial).Color[0] {
+		t.Fatalf("unexpected material %#v", ms.Materials[0])
+	}
+	near := func(a, b []float32) bool {
+		for i := range a {
+			if math.Abs(float64(a[i]-b[i])) > 1e-4 {
+				return false
+			}
+		}
+		return true
+	}
+	for g := range nd.FaceGroup {
+		faces, rfaces := nd.FaceGroup[g].Faces, rnd.FaceGroup[g].Faces
+		if len(faces) != len(rfaces) {
+			t.Fatalf("group %d: %d faces, expected %d", g, len(faces), len(rfaces))
+		}
+		for i, f := range faces {
+			for c := 0; c < 3; c++ {
+				rv := rfaces[i].Vertex[c]
+				v := nd.Vertices[f.Vertex[c]]
+				uv := nd.TexCoords[f.Uv[c]]
+				if !near(v[:], rnd.Vertices[rv][:]) || !near(uv[:], rnd.TexCoords[rv][:]) {
+					t.Fatalf("group %d face %d corner %d differs: %v %v", g, i, c, v, rnd.Vertices[rv])
+				}
+			}
+		}
+	}
+
+	if n := nd.Normals[nd.FaceGroup[0].Faces[0].Normal[0]]; n != (fvec3.T{-1, 0, 0}) {
+		t.Fatalf("unexpected normal %v", n)
+	}
+
+	js, bin = open("JingGai_RL.json"), open("JingGai_RL.bin")
+	ms, err = ThreejsBinToMesh(js, bin, NewFileTextureResolver("tests"))
+	js.Close()
+	bin.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm, ok := ms.Materials[0].(*PhongMaterial); !ok || tm.Texture == nil || len(tm.Texture.Data) == 0 {
+		t.Fatalf("texture not resolved %#v", ms.Materials[0])
+	}
+	js, bin = open("JingGai_RL.json"), open("JingGai_RL.bin")
+	ms, err = ThreejsBinToMesh(js, bin, nil)
+	js.Close()
+	bin.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm := ms.Materials[0].(*PhongMaterial); tm.Texture.URI != "JingGai_RL.jpg" || len(tm.Texture.Data) != 0 {
+		t.Fatalf("expected external texture %#v", tm.Texture)
+	}
+
+	data, err := ioutil.ReadFile("tests/JingGai_RL.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js = open("JingGai_RL.json")
+	_, err = ThreejsBinToMesh(js, bytes.NewReader(data[:len(data)/2]), nil)
+	js.Close()
+	var ae *AssetError
+	if !errors.As(err, &ae) || ae.Err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected truncated buffer error, got %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "threejs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"JingGai_RL.json", "JingGai_RL.bin", "JingGai_RL.jpg"} {
+		b, err := ioutil.ReadFile(filepath.Join("tests", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ThreejsBin2Mst(filepath.Join(dir, "JingGai_RL.json"), "out"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := MeshReadFrom(filepath.Join(dir, "out.mst"))
+	if err != nil || len(out.Nodes) != 1 || len(out.Nodes[0].FaceGroup[0].Faces) != 360 {
+		t.Fatalf("unexpected converter output: %v", err)
+	}
+}
@@ -0,0 +1,1092 @@
+package mstpb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	mst "github.com/flywave/go-mst"
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const SIGNATURE string = "fwpb"
+
+var ErrUnknownEncoding = errors.New("mstpb: unknown mesh encoding")
+
+func packColor(c [3]byte) uint64 {
+	return uint64(c[0])<<16 | uint64(c[1])<<8 | uint64(c[2])
+}
+
+func unpackColor(u uint64) [3]byte {
+	return [3]byte{byte(u >> 16), byte(u >> 8), byte(u)}
+}
+
+func matToDoubles(mt *dmat.T) []float64 {
+	out := make([]float64, 0, 16)
+	for i := 0; i < 4; i++ {
+		out = append(out, mt[i][:]...)
+	}
+	return out
+}
+
+func doublesToMat(a []float64) *dmat.T {
+	mt := &dmat.T{}
+	for i := 0; i < 4; i++ {
+		copy(mt[i][:], a[i*4:i*4+4])
+	}
+	return mt
+}
+
+func encodeTexture(tex *mst.Texture) *encoder {
+	e := &encoder{}
+	e.sint(1, int64(tex.Id))
+	e.string(2, tex.Name)
+	e.uint(3, tex.Size[0])
+	e.uint(4, tex.Size[1])
+	e.uint(5, uint64(tex.Format))
+	e.uint(6, uint64(tex.Type))
+	e.uint(7, uint64(tex.Compressed))
+	e.bytes(8, tex.Data)
+	e.bool(9, tex.Repeated)
+	e.string(10, tex.URI)
+	e.uint(11, uint64(tex.ColorSpace))
+	return e
+}
+
+func encodeMaterial(mtl mst.MeshMaterial) (*encoder, error) {
+	if tm, ok := mtl.(interface {
+		Resolve() (mst.MeshMaterial, error)
+	}); ok {
+		var err error
+		if mtl, err = tm.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	e := &encoder{}
+	if um, ok := mst.OpaqueMaterial(mtl); ok {
+		e.uint(1, uint64(um.Type))
+		e.bytes(28, um.Data)
+		return e, nil
+	}
+	var base *mst.BaseMaterial
+	var tex *mst.TextureMaterial
+	switch ml := mtl.(type) {
+	case *mst.BaseMaterial:
+		base = ml
+	case *mst.TextureMaterial:
+		e.uint(1, mst.MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE)
+		base, tex = &ml.BaseMaterial, ml
+	case *mst.PbrMaterial:
+		e.uint(1, mst.MESH_TRIANGLE_MATERIAL_TYPE_PBR)
+		base, tex = &ml.BaseMaterial, &ml.TextureMaterial
+		e.uint(6, packColor(ml.Emissive))
+		e.float(7, ml.Metallic)
+		e.float(8, ml.Roughness)
+		e.float(9, ml.Reflectance)
+		e.float(10, ml.AmbientOcclusion)
+		e.float(11, ml.ClearCoat)
+		e.float(12, ml.ClearCoatRoughness)
+		e.uint(13, packColor(ml.ClearCoatNormal))
+		e.float(14, ml.Anisotropy)
+		e.floats(15, ml.AnisotropyDirection[:])
+		e.float(16, ml.Thickness)
+		e.float(17, ml.SubSurfacePower)
+		e.uint(18, packColor(ml.SheenColor))
+		e.uint(19, packColor(ml.SubSurfaceColor))
+	case *mst.LambertMaterial:
+		e.uint(1, mst.MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT)
+		base, tex = &ml.BaseMaterial, &ml.TextureMaterial
+		e.uint(6, packColor(ml.Emissive))
+		e.uint(20, packColor(ml.Ambient))
+		e.uint(21, packColor(ml.Diffuse))
+	case *mst.PhongMaterial:
+		e.uint(1, mst.MESH_TRIANGLE_MATERIAL_TYPE_PHONG)
+		base, tex = &ml.BaseMaterial, &ml.TextureMaterial
+		e.uint(6, packColor(ml.Emissive))
+		e.uint(20, packColor(ml.Ambient))
+		e.uint(21, packColor(ml.Diffuse))
+		e.uint(22, packColor(ml.Specular))
+		e.float(23, ml.Shininess)
+		e.float(24, ml.Specularity)
+	default:
+		return nil, fmt.Errorf("mstpb: unsupported material type %T", mtl)
+	}
+	e.uint(2, packColor(base.Color))
+	e.float(3, base.Transparency)
+	e.string(25, base.Name)
+	if tex != nil && tex.Texture != nil {
+		e.message(4, encodeTexture(tex.Texture))
+	}
+	if tex != nil && tex.Normal != nil {
+		e.message(5, encodeTexture(tex.Normal))
+	}
+	if tex != nil {
+		e.uint(26, uint64(tex.TexCoord))
+		e.uint(27, uint64(tex.NormalTexCoord))
+	}
+	return e, nil
+}
+
+func encodeNode(nd *mst.MeshNode) *encoder {
+	e := &encoder{}
+	flat := func(vs []vec3.T) []float32 {
+		out := make([]float32, 0, len(vs)*3)
+		for _, v := range vs {
+			out = append(out, v[:]...)
+		}
+		return out
+	}
+	e.floats(1, flat(nd.Vertices))
+	e.floats(2, flat(nd.Normals))
+	colors := make([]byte, 0, len(nd.Colors)*3)
+	for _, c := range nd.Colors {
+		colors = append(colors, c[:]...)
+	}
+	e.bytes(3, colors)
+	uvs := make([]float32, 0, len(nd.TexCoords)*2)
+	for _, uv := range nd.TexCoords {
+		uvs = append(uvs, uv[:]...)
+	}
+	e.floats(4, uvs)
+	uvs2 := make([]float32, 0, len(nd.TexCoords2)*2)
+	for _, uv := range nd.TexCoords2 {
+		uvs2 = append(uvs2, uv[:]...)
+	}
+	e.floats(12, uvs2)
+	if nd.Lightmap != nil {
+		e.message(13, encodeTexture(nd.Lightmap))
+	}
+	if nd.Mat != nil {
+		e.doubles(5, matToDoubles(nd.Mat))
+	}
+	idx := func(v [3]uint32) []uint64 {
+		return []uint64{uint64(v[0]), uint64(v[1]), uint64(v[2])}
+	}
+	for _, g := range nd.FaceGroup {
+		ge := &encoder{}
+		ge.sint(1, int64(g.Batchid))
+		for _, f := range g.Faces {
+			fe := &encoder{}
+			fe.uints(1, idx(f.Vertex))
+			if f.Normal != nil {
+				fe.uints(2, idx(*f.Normal))
+			}
+			if f.Uv != nil {
+				fe.uints(3, idx(*f.Uv))
+			}
+			if f.Material != nil {
+				fe.sint(4, int64(*f.Material))
+			}
+			ge.message(2, fe)
+		}
+		if g.FeatureID != nil {
+			ge.tag(3, wireVarint)
+			ge.varint(*g.FeatureID)
+		}
+		ge.uints(4, g.Features)
+		ge.uint(5, uint64(g.Mode))
+		e.message(6, ge)
+	}
+	for _, g := range nd.EdgeGroup {
+		ge := &encoder{}
+		ge.sint(1, int64(g.Batchid))
+		edges := make([]uint64, 0, len(g.Edges)*2)
+		for _, ed := range g.Edges {
+			edges = append(edges, uint64(ed[0]), uint64(ed[1]))
+		}
+		ge.uints(2, edges)
+		ge.float(3, g.Width)
+		if g.Color != nil {
+			ge.tag(4, wireVarint)
+			ge.varint(packColor(*g.Color))
+		}
+		ge.floats(5, g.Dash)
+		ge.bool(6, g.Closed)
+		e.message(7, ge)
+	}
+	encodeProps(e, 8, nd.Props)
+	for _, t := range nd.MorphTargets {
+		te := &encoder{}
+		te.string(1, t.Name)
+		te.float(2, t.Weight)
+		te.floats(3, flat(t.Positions))
+		te.floats(4, flat(t.Normals))
+		e.message(9, te)
+	}
+	e.string(10, nd.Name)
+	children := make([]uint64, len(nd.Children))
+	for i, c := range nd.Children {
+		children[i] = uint64(c)
+	}
+	e.uints(11, children)
+	for _, g := range nd.PolygonGroup {
+		e.message(14, encodePolygonGroup(g))
+	}
+	return e
+}
+
+func ringUints(ring []uint32) []uint64 {
+	out := make([]uint64, len(ring))
+	for i, v := range ring {
+		out[i] = uint64(v)
+	}
+	return out
+}
+
+func encodePolygonGroup(g *mst.MeshPolygon) *encoder {
+	ge := &encoder{}
+	ge.sint(1, int64(g.Batchid))
+	for _, p := range g.Polygons {
+		pe := &encoder{}
+		pe.uints(1, ringUints(p.Outer))
+		for _, h := range p.Holes {
+			he := &encoder{}
+			he.uints(1, ringUints(h))
+			pe.message(2, he)
+		}
+		ge.message(2, pe)
+	}
+	return ge
+}
+
+func encodeBaseMesh(ms *mst.BaseMesh) (*encoder, error) {
+	e := &encoder{}
+	for _, mtl := range ms.Materials {
+		me, err := encodeMaterial(mtl)
+		if err != nil {
+			return nil, err
+		}
+		e.message(1, me)
+	}
+	for _, nd := range ms.Nodes {
+		e.message(2, encodeNode(nd))
+	}
+	e.uint(3, uint64(ms.Code))
+	return e, nil
+}
+
+func encodeValue(v interface{}) *encoder {
+	e := &encoder{}
+	switch val := v.(type) {
+	case nil:
+		e.tag(1, wireVarint)
+		e.varint(1)
+	case string:
+		e.tag(2, wireBytes)
+		e.varint(uint64(len(val)))
+		e.buf = append(e.buf, val...)
+	case int:
+		e.sint(3, int64(val))
+	case int32:
+		e.sint(3, int64(val))
+	case int64:
+		e.sint(3, val)
+	case uint32:
+		e.sint(3, int64(val))
+	case uint64:
+		e.sint(3, int64(val))
+	case float32:
+		e.double(4, float64(val))
+	case float64:
+		e.double(4, val)
+	case bool:
+		e.tag(5, wireVarint)
+		if val {
+			e.varint(1)
+		} else {
+			e.varint(0)
+		}
+	case []interface{}:
+		le := &encoder{}
+		for _, item := range val {
+			le.message(1, encodeValue(item))
+		}
+		e.message(6, le)
+	case map[string]interface{}:
+		me := &encoder{}
+		encodeProps(me, 1, mst.Properties(val))
+		e.message(7, me)
+	case mst.Properties:
+		me := &encoder{}
+		encodeProps(me, 1, val)
+		e.message(7, me)
+	default:
+		s := fmt.Sprint(val)
+		e.tag(2, wireBytes)
+		e.varint(uint64(len(s)))
+		e.buf = append(e.buf, s...)
+	}
+	return e
+}
+
+func encodeProps(e *encoder, field int, props mst.Properties) {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := props[k]
+		entry := &encoder{}
+		entry.tag(1, wireBytes)
+		entry.varint(uint64(len(k)))
+		entry.buf = append(entry.buf, k...)
+		entry.message(2, encodeValue(v))
+		e.message(field, entry)
+	}
+}
+
+func encodeInstance(inst *mst.InstanceMesh) (*encoder, error) {
+	e := &encoder{}
+	trans := make([]float64, 0, len(inst.Transfors)*16)
+	for _, mt := range inst.Transfors {
+		trans = append(trans, matToDoubles(mt)...)
+	}
+	e.doubles(1, trans)
+	e.uints(2, inst.Features)
+	if inst.BBox != nil {
+		e.doubles(3, inst.BBox[:])
+	}
+	if inst.Mesh != nil {
+		me, err := encodeBaseMesh(inst.Mesh)
+		if err != nil {
+			return nil, err
+		}
+		e.message(4, me)
+	}
+	e.uint(5, inst.Hash)
+	encodeProps(e, 6, inst.Props)
+	if inst.Ref != nil {
+		e.string(7, inst.Ref.URI)
+		e.uint(8, inst.Ref.Hash)
+	}
+	if len(inst.Bounds) > 0 {
+		bounds := make([]float64, 0, len(inst.Bounds)*6)
+		for _, bx := range inst.Bounds {
+			bounds = append(bounds, bx[:]...)
+		}
+		e.doubles(9, bounds)
+	}
+	return e, nil
+}
+
+func encodeAnimation(a *mst.Animation) *encoder {
+	e := &encoder{}
+	e.string(1, a.Name)
+	for _, t := range a.Tracks {
+		te := &encoder{}
+		te.uint(1, uint64(t.TargetType))
+		te.uint(2, uint64(t.Target))
+		te.uint(3, uint64(t.Transform))
+		te.uint(4, uint64(t.Path))
+		te.uint(5, uint64(t.Interpolation))
+		te.floats(6, t.Times)
+		te.floats(7, t.Values)
+		e.message(2, te)
+	}
+	return e
+}
+
+func Marshal(ms *mst.Mesh) ([]byte, error) {
+	e := &encoder{buf: []byte(SIGNATURE)}
+	e.uint(1, uint64(ms.Version))
+	base, err := encodeBaseMesh(&ms.BaseMesh)
+	if err != nil {
+		return nil, err
+	}
+	e.message(2, base)
+	for _, inst := range ms.InstanceNode {
+		ie, err := encodeInstance(inst)
+		if err != nil {
+			return nil, err
+		}
+		e.message(3, ie)
+	}
+	encodeProps(e, 4, ms.Props)
+	if ms.Units != mst.UNITS_UNKNOWN {
+		e.uint(5, uint64(ms.Units))
+	}
+	for _, a := range ms.Animations {
+		e.message(6, encodeAnimation(a))
+	}
+	return e.buf, nil
+}
+
+func MeshMarshal(wt io.Writer, ms *mst.Mesh) error {
+	buf, err := Marshal(ms)
+	if err != nil {
+		return err
+	}
+	_, err = wt.Write(buf)
+	return err
+}
+
+func decodeTexture(data []byte) (*mst.Texture, error) {
+	tex := &mst.Texture{}
+	err := parse(data, func(f *field) error {
+		switch f.num {
+		case 1:
+			tex.Id = int32(f.sint())
+		case 2:
+			tex.Name = string(f.data)
+		case 3:
+			tex.Size[0] = f.u
+		case 4:
+			tex.Size[1] = f.u
+		case 5:
+			tex.Format = uint16(f.u)
+		case 6:
+			tex.Type = uint16(f.u)
+		case 7:
+			tex.Compressed = uint16(f.u)
+		case 8:
+			tex.Data = append([]byte(nil), f.data...)
+		case 9:
+			tex.Repeated = f.u != 0
+		case 10:
+			tex.URI = string(f.data)
+		case 11:
+			tex.ColorSpace = uint8(f.u)
+		}
+		return nil
+	})
+	return tex, err
+}
+
+type materialFields struct {
+	ty                  uint64
+	colors              [32]uint64
+	scalars             [32]float32
+	texture, normal     *mst.Texture
+	anisotropyDirection []float32
+	name                string
+	texCoords           [2]uint64
+	data                []byte
+}
+
+func decodeMaterial(data []byte) (mst.MeshMaterial, error) {
+	m := &materialFields{}
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			m.ty = f.u
+		case 4:
+			m.texture, err = decodeTexture(f.data)
+		case 5:
+			m.normal, err = decodeTexture(f.data)
+		case 15:
+			m.anisotropyDirection, err = f.floats(m.anisotropyDirection)
+		case 25:
+			m.name = string(f.data)
+		case 28:
+			m.data = append([]byte(nil), f.data...)
+		case 26, 27:
+			if f.u >= mst.MAX_TEXCOORD_SETS {
+				return fmt.Errorf("mstpb: texture coordinate set %d out of range", f.u)
+			}
+			m.texCoords[f.num-26] = f.u
+		case 2, 6, 13, 18, 19, 20, 21, 22:
+			m.colors[f.num] = f.u
+		default:
+			if f.num < len(m.scalars) && f.wire == wireFixed32 {
+				m.scalars[f.num] = f.float()
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	base := mst.BaseMaterial{Name: m.name, Color: unpackColor(m.colors[2]), Transparency: m.scalars[3]}
+	tex := mst.TextureMaterial{BaseMaterial: base, Texture: m.texture, Normal: m.normal, TexCoord: uint8(m.texCoords[0]), NormalTexCoord: uint8(m.texCoords[1])}
+	lambert := mst.LambertMaterial{
+		TextureMaterial: tex,
+		Emissive:        unpackColor(m.colors[6]),
+		Ambient:         unpackColor(m.colors[20]),
+		Diffuse:         unpackColor(m.colors[21]),
+	}
+	switch m.ty {
+	case mst.MESH_TRIANGLE_MATERIAL_TYPE_COLOR:
+		return &base, nil
+	case mst.MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE:
+		return &tex, nil
+	case mst.MESH_TRIANGLE_MATERIAL_TYPE_PBR:
+		pbr := &mst.PbrMaterial{
+			TextureMaterial:    tex,
+			Emissive:           unpackColor(m.colors[6]),
+			Metallic:           m.scalars[7],
+			Roughness:          m.scalars[8],
+			Reflectance:        m.scalars[9],
+			AmbientOcclusion:   m.scalars[10],
+			ClearCoat:          m.scalars[11],
+			ClearCoatRoughness: m.scalars[12],
+			ClearCoatNormal:    unpackColor(m.colors[13]),
+			Anisotropy:         m.scalars[14],
+			Thickness:          m.scalars[16],
+			SubSurfacePower:    m.scalars[17],
+			SheenColor:         unpackColor(m.colors[18]),
+			SubSurfaceColor:    unpackColor(m.colors[19]),
+		}
+		copy(pbr.AnisotropyDirection[:], m.anisotropyDirection)
+		return pbr, nil
+	case mst.MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT:
+		return &lambert, nil
+	case mst.MESH_TRIANGLE_MATERIAL_TYPE_PHONG:
+		return &mst.PhongMaterial{
+			LambertMaterial: lambert,
+			Specular:        unpackColor(m.colors[22]),
+			Shininess:       m.scalars[23],
+			Specularity:     m.scalars[24],
+		}, nil
+	}
+	if m.ty > math.MaxUint32 || m.ty == mst.MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE {
+		return nil, &mst.UnknownMaterialError{Type: uint32(m.ty)}
+	}
+	return (&mst.UnknownMaterial{Type: uint32(m.ty), Data: m.data}).Decode()
+}
+
+func toTriple(v []uint64) (*[3]uint32, error) {
+	if len(v) != 3 {
+		return nil, fmt.Errorf("mstpb: face index has %d components", len(v))
+	}
+	return &[3]uint32{uint32(v[0]), uint32(v[1]), uint32(v[2])}, nil
+}
+
+func decodeFace(data []byte) (*mst.Face, error) {
+	var v, n, uv []uint64
+	var mtl *int32
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			v, err = f.uints(v)
+		case 2:
+			n, err = f.uints(n)
+		case 3:
+			uv, err = f.uints(uv)
+		case 4:
+			id := int32(f.sint())
+			mtl = &id
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	face := &mst.Face{Material: mtl}
+	vt, err := toTriple(v)
+	if err != nil {
+		return nil, err
+	}
+	face.Vertex = *vt
+	if n != nil {
+		if face.Normal, err = toTriple(n); err != nil {
+			return nil, err
+		}
+	}
+	if uv != nil {
+		if face.Uv, err = toTriple(uv); err != nil {
+			return nil, err
+		}
+	}
+	return face, nil
+}
+
+func decodeNode(data []byte) (*mst.MeshNode, error) {
+	nd := &mst.MeshNode{}
+	var vs, ns, uvs, uvs2 []float32
+	var children []uint64
+	var mat []float64
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			vs, err = f.floats(vs)
+		case 2:
+			ns, err = f.floats(ns)
+		case 3:
+			if len(f.data)%3 != 0 {
+				return fmt.Errorf("mstpb: color data length %d", len(f.data))
+			}
+			for i := 0; i < len(f.data); i += 3 {
+				nd.Colors = append(nd.Colors, [3]byte{f.data[i], f.data[i+1], f.data[i+2]})
+			}
+		case 4:
+			uvs, err = f.floats(uvs)
+		case 5:
+			mat, err = f.doubles(mat)
+		case 6:
+			g := &mst.MeshTriangle{}
+			err = parse(f.data, func(gf *field) error {
+				switch gf.num {
+				case 1:
+					g.Batchid = int32(gf.sint())
+				case 2:
+					face, err := decodeFace(gf.data)
+					if err != nil {
+						return err
+					}
+					g.Faces = append(g.Faces, face)
+				case 3:
+					id := gf.u
+					g.FeatureID = &id
+				case 4:
+					var err error
+					g.Features, err = gf.uints(g.Features)
+					return err
+				case 5:
+					g.Mode = uint8(gf.u)
+				}
+				return nil
+			})
+			nd.FaceGroup = append(nd.FaceGroup, g)
+		case 7:
+			g := &mst.MeshOutline{}
+			var edges []uint64
+			err = parse(f.data, func(gf *field) error {
+				var err error
+				switch gf.num {
+				case 1:
+					g.Batchid = int32(gf.sint())
+				case 2:
+					edges, err = gf.uints(edges)
+				case 3:
+					g.Width = gf.float()
+				case 4:
+					cl := unpackColor(gf.u)
+					g.Color = &cl
+				case 5:
+					g.Dash, err = gf.floats(g.Dash)
+				case 6:
+					g.Closed = gf.u != 0
+				}
+				return err
+			})
+			if len(edges)%2 != 0 {
+				return fmt.Errorf("mstpb: odd edge index count %d", len(edges))
+			}
+			for i := 0; i < len(edges); i += 2 {
+				g.Edges = append(g.Edges, [2]uint32{uint32(edges[i]), uint32(edges[i+1])})
+			}
+			nd.EdgeGroup = append(nd.EdgeGroup, g)
+		case 8:
+			if nd.Props == nil {
+				nd.Props = mst.Properties{}
+			}
+			err = decodeEntry(nd.Props, f.data, 0)
+		case 9:
+			var t *mst.MorphTarget
+			if t, err = decodeMorphTarget(f.data); err == nil {
+				nd.MorphTargets = append(nd.MorphTargets, t)
+			}
+		case 10:
+			nd.Name = string(f.data)
+		case 11:
+			children, err = f.uints(children)
+		case 12:
+			uvs2, err = f.floats(uvs2)
+		case 13:
+			nd.Lightmap, err = decodeTexture(f.data)
+		case 14:
+			var g *mst.MeshPolygon
+			if g, err = decodePolygonGroup(f.data); err == nil {
+				nd.PolygonGroup = append(nd.PolygonGroup, g)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(vs)%3 != 0 || len(ns)%3 != 0 || len(uvs)%2 != 0 || len(uvs2)%2 != 0 {
+		return nil, errors.New("mstpb: vector data not a multiple of its dimension")
+	}
+	for i := 0; i < len(vs); i += 3 {
+		nd.Vertices = append(nd.Vertices, vec3.T{vs[i], vs[i+1], vs[i+2]})
+	}
+	for i := 0; i < len(ns); i += 3 {
+		nd.Normals = append(nd.Normals, vec3.T{ns[i], ns[i+1], ns[i+2]})
+	}
+	for i := 0; i < len(uvs); i += 2 {
+		nd.TexCoords = append(nd.TexCoords, vec2.T{uvs[i], uvs[i+1]})
+	}
+	for i := 0; i < len(uvs2); i += 2 {
+		nd.TexCoords2 = append(nd.TexCoords2, vec2.T{uvs2[i], uvs2[i+1]})
+	}
+	for _, c := range children {
+		nd.Children = append(nd.Children, uint32(c))
+	}
+	if mat != nil {
+		if len(mat) != 16 {
+			return nil, fmt.Errorf("mstpb: node matrix has %d components", len(mat))
+		}
+		nd.Mat = doublesToMat(mat)
+	}
+	return nd, nil
+}
+
+func toVec3s(vs []float32) ([]vec3.T, error) {
+	if len(vs)%3 != 0 {
+		return nil, errors.New("mstpb: vector data not a multiple of its dimension")
+	}
+	var out []vec3.T
+	for i := 0; i < len(vs); i += 3 {
+		out = append(out, vec3.T{vs[i], vs[i+1], vs[i+2]})
+	}
+	return out, nil
+}
+
+func decodeMorphTarget(data []byte) (*mst.MorphTarget, error) {
+	t := &mst.MorphTarget{}
+	var ps, ns []float32
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			t.Name = string(f.data)
+		case 2:
+			t.Weight = f.float()
+		case 3:
+			ps, err = f.floats(ps)
+		case 4:
+			ns, err = f.floats(ns)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.Positions, err = toVec3s(ps); err != nil {
+		return nil, err
+	}
+	if t.Normals, err = toVec3s(ns); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func toRing(vs []uint64) []uint32 {
+	ring := make([]uint32, len(vs))
+	for i, v := range vs {
+		ring[i] = uint32(v)
+	}
+	return ring
+}
+
+func decodeRing(data []byte) ([]uint32, error) {
+	var vs []uint64
+	err := parse(data, func(f *field) error {
+		var err error
+		if f.num == 1 {
+			vs, err = f.uints(vs)
+		}
+		return err
+	})
+	return toRing(vs), err
+}
+
+func decodePolygonGroup(data []byte) (*mst.MeshPolygon, error) {
+	g := &mst.MeshPolygon{}
+	err := parse(data, func(f *field) error {
+		switch f.num {
+		case 1:
+			g.Batchid = int32(f.sint())
+		case 2:
+			p := &mst.Polygon{}
+			var outer []uint64
+			err := parse(f.data, func(pf *field) error {
+				var err error
+				switch pf.num {
+				case 1:
+					outer, err = pf.uints(outer)
+				case 2:
+					var hole []uint32
+					if hole, err = decodeRing(pf.data); err == nil {
+						p.Holes = append(p.Holes, hole)
+					}
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			p.Outer = toRing(outer)
+			g.Polygons = append(g.Polygons, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func decodeBaseMesh(data []byte) (*mst.BaseMesh, error) {
+	ms := &mst.BaseMesh{}
+	err := parse(data, func(f *field) error {
+		switch f.num {
+		case 1:
+			mtl, err := decodeMaterial(f.data)
+			if err != nil {
+				return err
+			}
+			ms.Materials = append(ms.Materials, mtl)
+		case 2:
+			nd, err := decodeNode(f.data)
+			if err != nil {
+				return err
+			}
+			ms.Nodes = append(ms.Nodes, nd)
+		case 3:
+			ms.Code = uint32(f.u)
+		}
+		return nil
+	})
+	return ms, err
+}
+
+func decodeValue(data []byte, depth int) (interface{}, error) {
+	if depth > 64 {
+		return nil, mst.ErrPropsTooDeep
+	}
+	var v interface{}
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			v = nil
+		case 2:
+			v = string(f.data)
+		case 3:
+			v = f.sint()
+		case 4:
+			v = f.double()
+		case 5:
+			v = f.u != 0
+		case 6:
+			arr := []interface{}{}
+			err = parse(f.data, func(lf *field) error {
+				item, err := decodeValue(lf.data, depth+1)
+				arr = append(arr, item)
+				return err
+			})
+			v = arr
+		case 7:
+			props := mst.Properties{}
+			err = parse(f.data, func(mf *field) error {
+				return decodeEntry(props, mf.data, depth+1)
+			})
+			v = map[string]interface{}(props)
+		}
+		return err
+	})
+	return v, err
+}
+
+func decodeEntry(props mst.Properties, data []byte, depth int) error {
+	var key string
+	var val interface{}
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			key = string(f.data)
+		case 2:
+			val, err = decodeValue(f.data, depth)
+		}
+		return err
+	})
+	props[key] = val
+	return err
+}
+
+func decodeInstance(data []byte) (*mst.InstanceMesh, error) {
+	inst := &mst.InstanceMesh{}
+	var trans, bbox, bounds []float64
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			trans, err = f.doubles(trans)
+		case 2:
+			inst.Features, err = f.uints(inst.Features)
+		case 3:
+			bbox, err = f.doubles(bbox)
+		case 4:
+			inst.Mesh, err = decodeBaseMesh(f.data)
+		case 5:
+			inst.Hash = f.u
+		case 6:
+			if inst.Props == nil {
+				inst.Props = mst.Properties{}
+			}
+			err = decodeEntry(inst.Props, f.data, 0)
+		case 7:
+			if inst.Ref == nil {
+				inst.Ref = &mst.InstanceRef{}
+			}
+			inst.Ref.URI = string(f.data)
+		case 8:
+			if inst.Ref == nil {
+				inst.Ref = &mst.InstanceRef{}
+			}
+			inst.Ref.Hash = f.u
+		case 9:
+			bounds, err = f.doubles(bounds)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(trans)%16 != 0 {
+		return nil, fmt.Errorf("mstpb: transform data length %d", len(trans))
+	}
+	for i := 0; i < len(trans); i += 16 {
+		inst.Transfors = append(inst.Transfors, doublesToMat(trans[i:i+16]))
+	}
+	if bbox != nil {
+		if len(bbox) != 6 {
+			return nil, fmt.Errorf("mstpb: bbox has %d components", len(bbox))
+		}
+		inst.BBox = &[6]float64{}
+		copy(inst.BBox[:], bbox)
+	}
+	if len(bounds)%6 != 0 {
+		return nil, fmt.Errorf("mstpb: bounds data length %d", len(bounds))
+	}
+	for i := 0; i < len(bounds); i += 6 {
+		var bx [6]float64
+		copy(bx[:], bounds[i:i+6])
+		inst.Bounds = append(inst.Bounds, bx)
+	}
+	return inst, nil
+}
+
+func decodeAnimationTrack(data []byte) (*mst.AnimationTrack, error) {
+	t := &mst.AnimationTrack{}
+	err := parse(data, func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			t.TargetType = uint8(f.u)
+		case 2:
+			t.Target = uint32(f.u)
+		case 3:
+			t.Transform = uint32(f.u)
+		case 4:
+			if f.u > mst.ANIMATION_PATH_SCALE {
+				return fmt.Errorf("mstpb: invalid animation path %d", f.u)
+			}
+			t.Path = uint8(f.u)
+		case 5:
+			t.Interpolation = uint8(f.u)
+		case 6:
+			t.Times, err = f.floats(t.Times)
+		case 7:
+			t.Values, err = f.floats(t.Values)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func decodeAnimation(data []byte) (*mst.Animation, error) {
+	a := &mst.Animation{}
+	err := parse(data, func(f *field) error {
+		switch f.num {
+		case 1:
+			a.Name = string(f.data)
+		case 2:
+			t, err := decodeAnimationTrack(f.data)
+			if err != nil {
+				return err
+			}
+			a.Tracks = append(a.Tracks, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func Unmarshal(data []byte) (*mst.Mesh, error) {
+	if len(data) < len(SIGNATURE) || string(data[:len(SIGNATURE)]) != SIGNATURE {
+		return nil, mst.ErrInvalidSignature
+	}
+	ms := &mst.Mesh{}
+	err := parse(data[len(SIGNATURE):], func(f *field) error {
+		var err error
+		switch f.num {
+		case 1:
+			if f.u > math.MaxUint32 {
+				return mst.ErrUnsupportedVersion
+			}
+			ms.Version = uint32(f.u)
+		case 2:
+			var base *mst.BaseMesh
+			if base, err = decodeBaseMesh(f.data); err == nil {
+				ms.BaseMesh = *base
+			}
+		case 3:
+			var inst *mst.InstanceMesh
+			if inst, err = decodeInstance(f.data); err == nil {
+				ms.InstanceNode = append(ms.InstanceNode, inst)
+			}
+		case 4:
+			if ms.Props == nil {
+				ms.Props = mst.Properties{}
+			}
+			err = decodeEntry(ms.Props, f.data, 0)
+		case 5:
+			ms.Units = uint8(f.u)
+		case 6:
+			var a *mst.Animation
+			if a, err = decodeAnimation(f.data); err == nil {
+				ms.Animations = append(ms.Animations, a)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func MeshUnMarshal(rd io.Reader) (*mst.Mesh, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
+
+func Decode(rd io.Reader) (*mst.Mesh, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrUnknownEncoding
+	}
+	switch string(data[:4]) {
+	case SIGNATURE:
+		return Unmarshal(data)
+	case mst.MESH_SIGNATURE:
+		return mst.MeshUnMarshalWithOptions(bytes.NewReader(data), &mst.DefaultDecodeOptions)
+	}
+	return nil, ErrUnknownEncoding
+}
@@ -0,0 +1,263 @@
+package mstpb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+func newMesh() *mst.Mesh {
+	ms := mst.NewMesh()
+	ms.Materials = append(ms.Materials,
+		&mst.BaseMaterial{Color: [3]byte{255, 0, 0}, Transparency: 0.5},
+		&mst.PhongMaterial{
+			LambertMaterial: mst.LambertMaterial{
+				TextureMaterial: mst.TextureMaterial{
+					BaseMaterial: mst.BaseMaterial{Name: "brick", Color: [3]byte{1, 2, 3}},
+					Texture:      &mst.Texture{Id: -1, Name: "t", Size: [2]uint64{1, 1}, Format: mst.TEXTURE_FORMAT_RGB, Data: []byte{1, 2, 3}},
+				},
+				Ambient: [3]byte{4, 5, 6},
+			},
+			Shininess: 8,
+		},
+	)
+	n := [3]uint32{0, 1, 2}
+	mtl := int32(0)
+	fid := uint64(0)
+	ms.Nodes = append(ms.Nodes, &mst.MeshNode{
+		Vertices:     []vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}},
+		Normals:      []vec3.T{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+		TexCoords:    []vec2.T{{0, 0}, {1, 0}, {0, 1}},
+		Colors:       [][3]byte{{1, 1, 1}, {2, 2, 2}, {3, 3, 3}},
+		Mat:          &dmat.Ident,
+		FaceGroup:    []*mst.MeshTriangle{{Batchid: 1, Faces: []*mst.Face{{Vertex: [3]uint32{0, 1, 2}, Normal: &n, Material: &mtl}}, FeatureID: &fid, Mode: mst.MESH_TRIANGLE_MODE_STRIP}},
+		EdgeGroup:    []*mst.MeshOutline{{Batchid: -1, Edges: [][2]uint32{{0, 1}, {1, 2}}, Width: 2, Color: &[3]byte{}, Dash: []float32{4, 2}, Closed: true}},
+		PolygonGroup: []*mst.MeshPolygon{{Batchid: 1, Polygons: []*mst.Polygon{{Outer: []uint32{0, 1, 2}, Holes: [][]uint32{{2, 1, 0}}}}}},
+		Props:        mst.Properties{"level": int64(2)},
+		Name:         "slab",
+		MorphTargets: []*mst.MorphTarget{
+			{Name: "lift", Weight: 0.25, Positions: []vec3.T{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}},
+		},
+	})
+	ms.InstanceNode = append(ms.InstanceNode, &mst.InstanceMesh{
+		Transfors: []*dmat.T{&dmat.Ident},
+		Features:  []uint64{7, 1 << 40},
+		BBox:      &[6]float64{0, 0, 0, 1, 1, 1},
+		Mesh:      &mst.BaseMesh{Materials: []mst.MeshMaterial{&mst.BaseMaterial{}}, Nodes: ms.Nodes},
+		Hash:      42,
+		Props:     mst.Properties{"id": int64(-3)},
+	})
+	ms.Props = mst.Properties{
+		"name":  "tower",
+		"ok":    true,
+		"list":  []interface{}{1.5, nil, "x"},
+		"attrs": map[string]interface{}{"floors": int64(12)},
+	}
+	ms.Animations = []*mst.Animation{{Name: "sway", Tracks: []*mst.AnimationTrack{
+		{Path: mst.ANIMATION_PATH_TRANSLATION, Times: []float32{0, 1}, Values: []float32{0, 0, 0, 0, 0, 1}},
+	}}}
+	return ms
+}
+
+func TestRoundTrip(t *testing.T) {
+	ms := newMesh()
+	buf := &bytes.Buffer{}
+	if err := MeshMarshal(buf, ms); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(ms)
+	got, _ := json.Marshal(out)
+	if !bytes.Equal(want, got) {
+		t.Fatalf("round trip mismatch\nwant %s\ngot  %s", want, got)
+	}
+}
+
+func TestDecodeSniffsNativeFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mst.MeshMarshal(buf, newMesh())
+	out, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Nodes) != 1 || len(out.InstanceNode) != 1 {
+		t.Fatal("unexpected mesh content")
+	}
+	if _, err := Decode(bytes.NewReader([]byte("nope"))); err != ErrUnknownEncoding {
+		t.Fatalf("expected ErrUnknownEncoding, got %v", err)
+	}
+}
+
+type levelMaterial struct {
+	Level byte
+}
+
+func (m *levelMaterial) HasTexture() bool         { return false }
+func (m *levelMaterial) GetTexture() *mst.Texture { return nil }
+func (m *levelMaterial) GetColor() [3]byte        { return [3]byte{m.Level, m.Level, m.Level} }
+func (m *levelMaterial) GetEmissive() [3]byte     { return [3]byte{} }
+
+type levelCodec struct{}
+
+func (levelCodec) Match(mtl mst.MeshMaterial) bool {
+	_, ok := mtl.(*levelMaterial)
+	return ok
+}
+
+func (levelCodec) Marshal(wt io.Writer, mtl mst.MeshMaterial) {
+	wt.Write([]byte{mtl.(*levelMaterial).Level})
+}
+
+func (levelCodec) Unmarshal(rd io.Reader) (mst.MeshMaterial, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(rd, b[:]); err != nil {
+		return nil, err
+	}
+	return &levelMaterial{Level: b[0]}, nil
+}
+
+func TestOpaqueMaterials(t *testing.T) {
+	if err := mst.RegisterMaterialType(120, levelCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	defer mst.UnregisterMaterialType(120)
+	ms := newMesh()
+	ms.Materials = append(ms.Materials, &levelMaterial{Level: 7}, &mst.UnknownMaterial{Type: 121, Data: []byte{1, 2}})
+	data, err := Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lm, ok := out.Materials[2].(*levelMaterial); !ok || lm.Level != 7 {
+		t.Fatalf("custom material not round tripped: %#v", out.Materials[2])
+	}
+	if um, ok := out.Materials[3].(*mst.UnknownMaterial); !ok || um.Type != 121 || !bytes.Equal(um.Data, []byte{1, 2}) {
+		t.Fatalf("unknown material not passed through: %#v", out.Materials[3])
+	}
+}
+
+type protoField struct {
+	name, typ string
+	repeated  bool
+}
+
+func loadSchema(t *testing.T) (map[string]map[int]protoField, map[string]bool) {
+	src, err := ioutil.ReadFile("mst.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := regexp.MustCompile(`(?s)(message|enum) (\w+) \{(.*?)\n\}`)
+	line := regexp.MustCompile(`(?m)^\s*(optional |repeated )?(map<string, \w+>|\w+) (\w+) = (\d+);`)
+	msgs, enums := make(map[string]map[int]protoField), make(map[string]bool)
+	for _, b := range block.FindAllStringSubmatch(string(src), -1) {
+		if b[1] == "enum" {
+			enums[b[2]] = true
+			continue
+		}
+		fields := make(map[int]protoField)
+		for _, l := range line.FindAllStringSubmatch(b[3], -1) {
+			num, _ := strconv.Atoi(l[4])
+			fields[num] = protoField{name: l[3], typ: l[2], repeated: l[1] == "repeated "}
+		}
+		msgs[b[2]] = fields
+	}
+	return msgs, enums
+}
+
+func checkSchema(t *testing.T, msgs map[string]map[int]protoField, enums map[string]bool, msg string, data []byte, seen map[string]bool) {
+	err := parse(data, func(f *field) error {
+		pf, ok := msgs[msg][f.num]
+		if !ok {
+			t.Errorf("%s: field %d is not declared in mst.proto", msg, f.num)
+			return nil
+		}
+		seen[msg+"."+pf.name] = true
+		wire := wireVarint
+		switch {
+		case pf.typ == "float":
+			wire = wireFixed32
+		case pf.typ == "double":
+			wire = wireFixed64
+		case pf.typ == "string" || pf.typ == "bytes" || msgs[pf.typ] != nil || strings.HasPrefix(pf.typ, "map<"):
+			wire = wireBytes
+		case enums[pf.typ] || strings.Contains(pf.typ, "int") || pf.typ == "bool":
+		default:
+			t.Errorf("%s.%s: unhandled type %s", msg, pf.name, pf.typ)
+		}
+		if f.wire != wire && !(pf.repeated && f.wire == wireBytes) {
+			t.Errorf("%s.%s: wire type %d, mst.proto declares %s", msg, pf.name, f.wire, pf.typ)
+		}
+		switch {
+		case strings.HasPrefix(pf.typ, "map<"):
+			value := strings.TrimSuffix(strings.TrimPrefix(pf.typ, "map<string, "), ">")
+			return parse(f.data, func(ef *field) error {
+				if ef.num == 2 {
+					checkSchema(t, msgs, enums, value, ef.data, seen)
+				}
+				return nil
+			})
+		case msgs[pf.typ] != nil:
+			checkSchema(t, msgs, enums, pf.typ, f.data, seen)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("%s: %v", msg, err)
+	}
+}
+
+func TestSchemaMatchesEncoder(t *testing.T) {
+	msgs, enums := loadSchema(t)
+	ms := newMesh()
+	tex := &mst.Texture{Id: 3, Name: "n", Size: [2]uint64{1, 1}, Format: mst.TEXTURE_FORMAT_RGB, Type: 1, Compressed: 1, Data: []byte{1}, Repeated: true, URI: "n.png", ColorSpace: 1}
+	ms.Materials = append(ms.Materials, &mst.PbrMaterial{
+		TextureMaterial: mst.TextureMaterial{BaseMaterial: mst.BaseMaterial{Color: [3]byte{1}, Transparency: 1}, Texture: tex, Normal: tex, TexCoord: 1, NormalTexCoord: 1},
+		Emissive:        [3]byte{1}, Metallic: 1, Roughness: 1, Reflectance: 1, AmbientOcclusion: 1, ClearCoat: 1, ClearCoatRoughness: 1,
+		ClearCoatNormal: [3]byte{1}, Anisotropy: 1, AnisotropyDirection: [3]float32{1}, Thickness: 1, SubSurfacePower: 1,
+		SheenColor: [3]byte{1}, SubSurfaceColor: [3]byte{1},
+	}, &mst.PhongMaterial{LambertMaterial: mst.LambertMaterial{Diffuse: [3]byte{1}}, Specular: [3]byte{1}, Specularity: 1},
+		&mst.UnknownMaterial{Type: 99, Data: []byte{1}})
+	nd := ms.Nodes[0]
+	uv := [3]uint32{0, 1, 2}
+	nd.FaceGroup[0].Faces[0].Uv = &uv
+	nd.FaceGroup = append(nd.FaceGroup, &mst.MeshTriangle{Batchid: 2, Faces: nd.FaceGroup[0].Faces, Features: []uint64{1}})
+	nd.TexCoords2 = nd.TexCoords
+	nd.Lightmap = tex
+	nd.MorphTargets[0].Normals = nd.Normals
+	nd.Children = []uint32{0}
+	ms.InstanceNode[0].Ref = &mst.InstanceRef{URI: "a.mst", Hash: 1}
+	ms.InstanceNode[0].Bounds = [][6]float64{{0, 0, 0, 1, 1, 1}}
+	ms.Props["n"] = int64(1)
+	ms.Units = mst.UNITS_METERS
+	ms.Code = 1
+	ms.Animations[0].Tracks = append(ms.Animations[0].Tracks, &mst.AnimationTrack{TargetType: mst.ANIMATION_TARGET_INSTANCE, Target: 1, Transform: 1, Path: mst.ANIMATION_PATH_SCALE, Interpolation: mst.ANIMATION_INTERPOLATION_STEP, Times: []float32{1}, Values: []float32{1, 1, 1}})
+	data, err := Marshal(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	checkSchema(t, msgs, enums, "Mesh", data[len(SIGNATURE):], seen)
+	for msg, fields := range msgs {
+		for _, pf := range fields {
+			if !seen[msg+"."+pf.name] {
+				t.Errorf("mst.proto field %s.%s is never written", msg, pf.name)
+			}
+		}
+	}
+}
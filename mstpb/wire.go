@@ -0,0 +1,235 @@
+package mstpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("mstpb: truncated message")
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *encoder) tag(field int, wt int) {
+	e.varint(uint64(field)<<3 | uint64(wt))
+}
+
+func (e *encoder) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+func (e *encoder) sint(field int, v int64) {
+	e.tag(field, wireVarint)
+	e.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.uint(field, 1)
+	}
+}
+
+func (e *encoder) float(field int, v float32) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed32)
+	e.buf = appendUint32(e.buf, math.Float32bits(v))
+}
+
+func (e *encoder) double(field int, v float64) {
+	e.tag(field, wireFixed64)
+	e.buf = appendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(field int, v string) {
+	e.bytes(field, []byte(v))
+}
+
+func (e *encoder) message(field int, m *encoder) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(m.buf)))
+	e.buf = append(e.buf, m.buf...)
+}
+
+func (e *encoder) floats(field int, v []float32) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v) * 4))
+	for _, f := range v {
+		e.buf = appendUint32(e.buf, math.Float32bits(f))
+	}
+}
+
+func (e *encoder) doubles(field int, v []float64) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v) * 8))
+	for _, f := range v {
+		e.buf = appendUint64(e.buf, math.Float64bits(f))
+	}
+}
+
+func (e *encoder) uints(field int, v []uint64) {
+	if len(v) == 0 {
+		return
+	}
+	p := &encoder{}
+	for _, u := range v {
+		p.varint(u)
+	}
+	e.bytes(field, p.buf)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+type field struct {
+	num  int
+	wire int
+	u    uint64
+	data []byte
+}
+
+func (f *field) sint() int64 {
+	return int64(f.u>>1) ^ -int64(f.u&1)
+}
+
+func (f *field) float() float32 {
+	return math.Float32frombits(uint32(f.u))
+}
+
+func (f *field) double() float64 {
+	return math.Float64frombits(f.u)
+}
+
+func (f *field) floats(out []float32) ([]float32, error) {
+	if f.wire == wireFixed32 {
+		return append(out, f.float()), nil
+	}
+	if f.wire != wireBytes || len(f.data)%4 != 0 {
+		return nil, f.mismatch()
+	}
+	for i := 0; i < len(f.data); i += 4 {
+		out = append(out, math.Float32frombits(binary.LittleEndian.Uint32(f.data[i:])))
+	}
+	return out, nil
+}
+
+func (f *field) doubles(out []float64) ([]float64, error) {
+	if f.wire == wireFixed64 {
+		return append(out, f.double()), nil
+	}
+	if f.wire != wireBytes || len(f.data)%8 != 0 {
+		return nil, f.mismatch()
+	}
+	for i := 0; i < len(f.data); i += 8 {
+		out = append(out, math.Float64frombits(binary.LittleEndian.Uint64(f.data[i:])))
+	}
+	return out, nil
+}
+
+func (f *field) uints(out []uint64) ([]uint64, error) {
+	if f.wire == wireVarint {
+		return append(out, f.u), nil
+	}
+	if f.wire != wireBytes {
+		return nil, f.mismatch()
+	}
+	data := f.data
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		out = append(out, v)
+		data = data[n:]
+	}
+	return out, nil
+}
+
+func (f *field) mismatch() error {
+	return fmt.Errorf("mstpb: field %d has unexpected wire type %d", f.num, f.wire)
+}
+
+func parse(data []byte, fn func(f *field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		f := &field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.u, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			f.u = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			f.u = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errTruncated
+			}
+			f.data = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return fmt.Errorf("mstpb: unsupported wire type %d", f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
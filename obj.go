@@ -0,0 +1,266 @@
+package mst
+
+import (
+	"bufio"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	OBJ_BUFFER_SIZE      = 1 << 16
+	OBJ_FLOAT_DIGITS     = 6
+	OBJ_FLOAT_SCALE      = 1000000
+	OBJ_FAST_FLOAT_LIMIT = 1 << 40
+)
+
+type objWriter struct {
+	wt  *bufio.Writer
+	buf []byte
+}
+
+func newObjWriter(wt io.Writer) *objWriter {
+	return &objWriter{wt: bufio.NewWriterSize(wt, OBJ_BUFFER_SIZE), buf: make([]byte, 0, 128)}
+}
+
+func (w *objWriter) floats(prefix string, vs ...float32) {
+	w.buf = append(w.buf[:0], prefix...)
+	for _, v := range vs {
+		w.buf = append(w.buf, ' ')
+		w.buf = appendObjFloat(w.buf, v)
+	}
+	w.buf = append(w.buf, '\n')
+	w.wt.Write(w.buf)
+}
+
+func appendObjFloat(buf []byte, v float32) []byte {
+	f := float64(v)
+	if f != f || f > OBJ_FAST_FLOAT_LIMIT || f < -OBJ_FAST_FLOAT_LIMIT {
+		return strconv.AppendFloat(buf, f, 'f', -1, 32)
+	}
+	neg := f < 0
+	if neg {
+		f = -f
+	}
+	n := uint64(f*OBJ_FLOAT_SCALE + 0.5)
+	if neg && n != 0 {
+		buf = append(buf, '-')
+	}
+	buf = strconv.AppendUint(buf, n/OBJ_FLOAT_SCALE, 10)
+	frac := n % OBJ_FLOAT_SCALE
+	if frac == 0 {
+		return buf
+	}
+	var digits [OBJ_FLOAT_DIGITS]byte
+	end := 0
+	for i := OBJ_FLOAT_DIGITS - 1; i >= 0; i-- {
+		digits[i] = byte('0' + frac%10)
+		if end == 0 && digits[i] != '0' {
+			end = i + 1
+		}
+		frac /= 10
+	}
+	buf = append(buf, '.')
+	return append(buf, digits[:end]...)
+}
+
+func (w *objWriter) face(v, vt, vn *[3]uint32, vOff, vtOff, vnOff uint64) {
+	w.buf = append(w.buf[:0], 'f')
+	for i := 0; i < 3; i++ {
+		w.buf = append(w.buf, ' ')
+		w.buf = strconv.AppendUint(w.buf, uint64(v[i])+vOff, 10)
+		if vt == nil && vn == nil {
+			continue
+		}
+		w.buf = append(w.buf, '/')
+		if vt != nil {
+			w.buf = strconv.AppendUint(w.buf, uint64(vt[i])+vtOff, 10)
+		}
+		if vn != nil {
+			w.buf = append(w.buf, '/')
+			w.buf = strconv.AppendUint(w.buf, uint64(vn[i])+vnOff, 10)
+		}
+	}
+	w.buf = append(w.buf, '\n')
+	w.wt.Write(w.buf)
+}
+
+func (w *objWriter) polyline(v []uint32, vOff uint64) {
+	w.buf = append(w.buf[:0], 'l')
+	for _, i := range v {
+		w.buf = append(w.buf, ' ')
+		w.buf = strconv.AppendUint(w.buf, uint64(i)+vOff, 10)
+	}
+	w.buf = append(w.buf, '\n')
+	w.wt.Write(w.buf)
+}
+
+func (w *objWriter) line(prefix string, n int) {
+	w.buf = append(w.buf[:0], prefix...)
+	w.buf = strconv.AppendInt(w.buf, int64(n), 10)
+	w.buf = append(w.buf, '\n')
+	w.wt.Write(w.buf)
+}
+
+type ObjExportOptions struct {
+	AxisConvention uint8
+}
+
+func MeshObjMarshal(wt io.Writer, ms *Mesh, mtlName string) error {
+	return MeshObjMarshalWithOptions(wt, ms, mtlName, nil)
+}
+
+func MeshObjMarshalWithOptions(wt io.Writer, ms *Mesh, mtlName string, opts *ObjExportOptions) error {
+	if opts != nil {
+		var err error
+		if ms, err = ms.ConvertAxis(opts.AxisConvention, AXIS_CONVENTION_Y_UP); err != nil {
+			return err
+		}
+	}
+	w := newObjWriter(wt)
+	if mtlName != "" {
+		w.wt.WriteString("mtllib " + mtlName + "\n")
+	}
+	for _, nd := range ms.Nodes {
+		for _, v := range nd.Vertices {
+			w.floats("v", v[0], v[1], v[2])
+		}
+		for _, v := range nd.Normals {
+			w.floats("vn", v[0], v[1], v[2])
+		}
+		for _, v := range nd.TexCoords {
+			w.floats("vt", v[0], v[1])
+		}
+	}
+
+	var vOff, vtOff, vnOff uint64 = 1, 1, 1
+	for i, nd := range ms.Nodes {
+		if name := strings.Join(strings.Fields(nd.Name), "_"); name != "" {
+			w.wt.WriteString("o " + name + "\n")
+		} else {
+			w.line("o node_", i)
+		}
+		nd = nd.withPolygonFaces()
+		hasvn := len(nd.Normals) > 0
+		hasvt := len(nd.TexCoords) > 0
+		for _, g := range nd.materialGroups() {
+			w.line("usemtl material_", int(g.Batchid))
+			for _, f := range g.Faces {
+				var vt, vn *[3]uint32
+				if hasvt {
+					vt = &f.Vertex
+					if f.Uv != nil {
+						vt = f.Uv
+					}
+				}
+				if hasvn {
+					vn = &f.Vertex
+					if f.Normal != nil {
+						vn = f.Normal
+					}
+				}
+				w.face(&f.Vertex, vt, vn, vOff, vtOff, vnOff)
+			}
+		}
+		for _, g := range nd.EdgeGroup {
+			w.line("usemtl material_", int(g.Batchid))
+			for _, l := range g.Polylines() {
+				w.polyline(l, vOff)
+			}
+		}
+		vOff += uint64(len(nd.Vertices))
+		vtOff += uint64(len(nd.TexCoords))
+		vnOff += uint64(len(nd.Normals))
+	}
+	return w.wt.Flush()
+}
+
+func objTextureName(tex *Texture) string {
+	return fmt.Sprintf("node_tex_0_%d.jpg", tex.Id)
+}
+
+func MeshMtlMarshal(wt io.Writer, ms *Mesh) error {
+	w := newObjWriter(wt)
+	for idx, m := range ms.Materials {
+		w.line("newmtl material_", idx)
+		w.wt.WriteString("Ka 0.2 0.2 0.2\nTr 1\nKs 1 1 1\nNs 0\nillum 2\n")
+
+		var tex *Texture
+		cl := [3]byte{255, 255, 255}
+		switch mtl := resolveMaterial(m).(type) {
+		case *TextureMaterial:
+			tex, cl = mtl.Texture, mtl.Color
+		case *PbrMaterial:
+			tex, cl = mtl.Texture, mtl.Color
+		case *PhongMaterial:
+			tex, cl = mtl.Texture, mtl.Color
+		case *LambertMaterial:
+			tex, cl = mtl.Texture, mtl.Color
+		case *BaseMaterial:
+			cl = mtl.Color
+		}
+		if tex != nil {
+			w.wt.WriteString("map_Kd " + objTextureName(tex) + "\n")
+		} else {
+			w.floats("Kd", float32(cl[0])/255, float32(cl[1])/255, float32(cl[2])/255)
+		}
+	}
+	return w.wt.Flush()
+}
+
+func writeObjTextures(dir string, ms *Mesh) error {
+	written := make(map[int32]bool)
+	for _, m := range ms.Materials {
+		tex := resolveMaterial(m).GetTexture()
+		if tex == nil || written[tex.Id] {
+			continue
+		}
+		written[tex.Id] = true
+		img, err := LoadTexture(tex, false)
+		if err != nil {
+			return err
+		}
+		im, err := os.Create(filepath.Join(dir, objTextureName(tex)))
+		if err != nil {
+			return err
+		}
+		err = jpeg.Encode(im, img, &jpeg.Options{Quality: 95})
+		im.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeObjFile(path string, fn func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func MstToObj(path, destName string) error {
+	dir, _ := filepath.Split(path)
+	ms, err := MeshReadFrom(path)
+	if err != nil {
+		return err
+	}
+	mtlName := destName + "_convert.mtl"
+	if err := writeObjFile(filepath.Join(dir, destName+"_convert.obj"), func(wt io.Writer) error { return MeshObjMarshal(wt, ms, mtlName) }); err != nil {
+		return err
+	}
+	if err := writeObjFile(filepath.Join(dir, mtlName), func(wt io.Writer) error { return MeshMtlMarshal(wt, ms) }); err != nil {
+		return err
+	}
+	return writeObjTextures(dir, ms)
+}
@@ -0,0 +1,428 @@
+package mst
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// ObjImportOptions controls ObjToMst. Material libraries and the textures they
+// reference are only read when BaseDir is set; otherwise usemtl names become
+// plain named materials.
+type ObjImportOptions struct {
+	BaseDir string
+}
+
+type objCorner struct {
+	v, vt, vn int
+}
+
+type objNode struct {
+	nd      *MeshNode
+	corners map[objCorner]uint32
+	groups  map[int32]*MeshTriangle
+	edges   map[int32]*MeshOutline
+	normals bool
+}
+
+type objImporter struct {
+	opts      *ObjImportOptions
+	ms        *Mesh
+	v         []vec3.T
+	colors    [][3]byte
+	vt        []vec2.T
+	vn        []vec3.T
+	materials map[string]int32
+	current   int32
+	node      *objNode
+	nodes     []*objNode
+	line      int
+}
+
+// localResourcePath resolves a file referenced by a model relative to dir and
+// refuses names that would leave it.
+func localResourcePath(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	base := filepath.Clean(dir)
+	full := filepath.Join(base, name)
+	rel, err := filepath.Rel(base, full)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) || err != nil ||
+		rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("mst: resource %q escapes the base directory", name)
+	}
+	return full, nil
+}
+
+func ObjToMst(rd io.Reader, opts *ObjImportOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &ObjImportOptions{}
+	}
+	imp := &objImporter{opts: opts, ms: NewMesh(), materials: make(map[string]int32), current: -1}
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, OBJ_BUFFER_SIZE), 1<<26)
+	for sc.Scan() {
+		imp.line++
+		if err := imp.statement(sc.Text()); err != nil {
+			return nil, fmt.Errorf("mst: obj line %d: %v", imp.line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for _, on := range imp.nodes {
+		imp.finish(on)
+	}
+	return imp.ms, nil
+}
+
+func ObjToMstFromFile(path string, opts *ObjImportOptions) (*Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	o := ObjImportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BaseDir == "" {
+		o.BaseDir = filepath.Dir(path)
+	}
+	return ObjToMst(f, &o)
+}
+
+func objFloats(fields []string, n int) ([]float32, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected %d values, got %d", n, len(fields))
+	}
+	out := make([]float32, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 32)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = float32(v)
+	}
+	return out, nil
+}
+
+func objIndex(s string, count int) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		i += count
+	} else {
+		i--
+	}
+	if i < 0 || i >= count {
+		return 0, fmt.Errorf("index %s out of range", s)
+	}
+	return i, nil
+}
+
+func (imp *objImporter) statement(text string) error {
+	if i := strings.IndexByte(text, '#'); i >= 0 {
+		text = text[:i]
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "v":
+		fs, err := objFloats(args, 3)
+		if err != nil {
+			return err
+		}
+		imp.v = append(imp.v, vec3.T{fs[0], fs[1], fs[2]})
+		if len(fs) >= 6 {
+			imp.colors = append(imp.colors, colorBytes(fs[3:6]))
+		} else {
+			imp.colors = append(imp.colors, [3]byte{255, 255, 255})
+		}
+	case "vt":
+		fs, err := objFloats(args, 1)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, 0)
+		imp.vt = append(imp.vt, vec2.T{fs[0], fs[1]})
+	case "vn":
+		fs, err := objFloats(args, 3)
+		if err != nil {
+			return err
+		}
+		imp.vn = append(imp.vn, vec3.T{fs[0], fs[1], fs[2]})
+	case "o", "g":
+		if imp.node != nil && (len(imp.node.groups) > 0 || len(imp.node.edges) > 0) {
+			imp.node = nil
+		}
+		if imp.node == nil {
+			imp.startNode()
+		}
+		imp.node.nd.Name = strings.Join(args, " ")
+	case "usemtl":
+		imp.current = imp.material(strings.Join(args, " "))
+	case "mtllib":
+		if imp.opts.BaseDir == "" {
+			return nil
+		}
+		for _, name := range args {
+			if err := imp.mtllib(name); err != nil {
+				return err
+			}
+		}
+	case "f":
+		return imp.face(args)
+	case "l":
+		return imp.polyline(args)
+	}
+	return nil
+}
+
+func (imp *objImporter) startNode() {
+	imp.node = &objNode{nd: &MeshNode{}, corners: make(map[objCorner]uint32), groups: make(map[int32]*MeshTriangle), edges: make(map[int32]*MeshOutline)}
+	imp.nodes = append(imp.nodes, imp.node)
+}
+
+func (imp *objImporter) material(name string) int32 {
+	if id, ok := imp.materials[name]; ok {
+		return id
+	}
+	id := int32(len(imp.ms.Materials))
+	imp.ms.Materials = append(imp.ms.Materials, &BaseMaterial{Name: name, Color: [3]byte{255, 255, 255}})
+	imp.materials[name] = id
+	return id
+}
+
+func (imp *objImporter) batch() int32 {
+	if imp.current < 0 {
+		imp.current = imp.material("default")
+	}
+	if imp.node == nil {
+		imp.startNode()
+	}
+	return imp.current
+}
+
+func (imp *objImporter) corner(s string) (uint32, error) {
+	parts := strings.Split(s, "/")
+	c := objCorner{vt: -1, vn: -1}
+	var err error
+	if c.v, err = objIndex(parts[0], len(imp.v)); err != nil {
+		return 0, err
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		if c.vt, err = objIndex(parts[1], len(imp.vt)); err != nil {
+			return 0, err
+		}
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if c.vn, err = objIndex(parts[2], len(imp.vn)); err != nil {
+			return 0, err
+		}
+	}
+	on := imp.node
+	if i, ok := on.corners[c]; ok {
+		return i, nil
+	}
+	nd := on.nd
+	i := uint32(len(nd.Vertices))
+	on.corners[c] = i
+	nd.Vertices = append(nd.Vertices, imp.v[c.v])
+	nd.Colors = append(nd.Colors, imp.colors[c.v])
+	var uv vec2.T
+	if c.vt >= 0 {
+		uv = imp.vt[c.vt]
+	}
+	nd.TexCoords = append(nd.TexCoords, uv)
+	var n vec3.T
+	if c.vn >= 0 {
+		n, on.normals = imp.vn[c.vn], true
+	}
+	nd.Normals = append(nd.Normals, n)
+	return i, nil
+}
+
+func (imp *objImporter) corners(args []string) ([]uint32, error) {
+	idx := make([]uint32, len(args))
+	for k, a := range args {
+		i, err := imp.corner(a)
+		if err != nil {
+			return nil, err
+		}
+		idx[k] = i
+	}
+	return idx, nil
+}
+
+func (imp *objImporter) face(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("face needs at least 3 vertices, got %d", len(args))
+	}
+	batch := imp.batch()
+	idx, err := imp.corners(args)
+	if err != nil {
+		return err
+	}
+	on := imp.node
+	g := on.groups[batch]
+	if g == nil {
+		g = &MeshTriangle{Batchid: batch}
+		on.groups[batch] = g
+		on.nd.FaceGroup = append(on.nd.FaceGroup, g)
+	}
+	if len(idx) == 3 {
+		g.Faces = append(g.Faces, &Face{Vertex: [3]uint32{idx[0], idx[1], idx[2]}})
+		return nil
+	}
+	tris, err := (&Polygon{Outer: idx}).Triangulate(on.nd.Vertices)
+	if err != nil {
+		tris = nil
+		for k := 1; k+1 < len(idx); k++ {
+			tris = append(tris, [3]uint32{idx[0], idx[k], idx[k+1]})
+		}
+	}
+	for _, t := range tris {
+		g.Faces = append(g.Faces, &Face{Vertex: t})
+	}
+	return nil
+}
+
+func (imp *objImporter) polyline(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("line needs at least 2 vertices, got %d", len(args))
+	}
+	batch := imp.batch()
+	idx, err := imp.corners(args)
+	if err != nil {
+		return err
+	}
+	on := imp.node
+	g := on.edges[batch]
+	if g == nil {
+		g = &MeshOutline{Batchid: batch}
+		on.edges[batch] = g
+		on.nd.EdgeGroup = append(on.nd.EdgeGroup, g)
+	}
+	for k := 0; k+1 < len(idx); k++ {
+		g.Edges = append(g.Edges, [2]uint32{idx[k], idx[k+1]})
+	}
+	return nil
+}
+
+func (imp *objImporter) finish(on *objNode) {
+	nd := on.nd
+	if len(nd.Vertices) == 0 {
+		return
+	}
+	uvs, colors := false, false
+	for c := range on.corners {
+		uvs = uvs || c.vt >= 0
+	}
+	for _, cl := range nd.Colors {
+		colors = colors || cl != [3]byte{255, 255, 255}
+	}
+	if !uvs {
+		nd.TexCoords = nil
+	}
+	if !colors {
+		nd.Colors = nil
+	}
+	if !on.normals {
+		nd.Normals = nil
+		if len(nd.FaceGroup) > 0 {
+			nd.ReComputeNormal()
+		}
+	}
+	imp.ms.Nodes = append(imp.ms.Nodes, nd)
+}
+
+func (imp *objImporter) mtllib(name string) error {
+	path, err := localResourcePath(imp.opts.BaseDir, name)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var mtl *TextureMaterial
+	flush := func() {
+		if mtl == nil {
+			return
+		}
+		var m MeshMaterial = &mtl.BaseMaterial
+		if mtl.Texture != nil {
+			m = mtl
+		}
+		if id, ok := imp.materials[mtl.Name]; ok {
+			imp.ms.Materials[id] = m
+		} else {
+			imp.materials[mtl.Name] = int32(len(imp.ms.Materials))
+			imp.ms.Materials = append(imp.ms.Materials, m)
+		}
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		args := fields[1:]
+		if fields[0] == "newmtl" {
+			flush()
+			mtl = &TextureMaterial{BaseMaterial: BaseMaterial{Name: strings.Join(args, " "), Color: [3]byte{255, 255, 255}}}
+			continue
+		}
+		if mtl == nil || len(args) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "Kd":
+			if fs, err := objFloats(args, 3); err == nil {
+				mtl.Color = colorBytes(fs[:3])
+			}
+		case "d":
+			if fs, err := objFloats(args[:1], 1); err == nil {
+				mtl.Transparency = 1 - fs[0]
+			}
+		case "map_Kd":
+			file := args[len(args)-1]
+			path, err := localResourcePath(imp.opts.BaseDir, file)
+			if err != nil {
+				return err
+			}
+			tex, err := CreateTexture(path, true)
+			if err != nil {
+				return fmt.Errorf("texture %s: %v", file, err)
+			}
+			tex.Id, tex.Name = int32(imp.textureCount()), file
+			mtl.Texture = tex
+		}
+	}
+	flush()
+	return sc.Err()
+}
+
+func (imp *objImporter) textureCount() int {
+	n := 0
+	for _, m := range imp.ms.Materials {
+		if m.GetTexture() != nil {
+			n++
+		}
+	}
+	return n
+}
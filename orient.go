@@ -0,0 +1,128 @@
+package mst
+
+import (
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+type orientEdge struct {
+	faces   []int
+	forward []bool
+}
+
+// OrientNormalsConsistently makes neighbouring faces agree on winding within
+// every edge-connected component and then turns closed components whose
+// signed volume is negative outside in. It returns the number of flipped faces
+// and rebuilds vertex normals when any face was flipped.
+func (n *MeshNode) OrientNormalsConsistently() (int, error) {
+	if err := n.validateVertexIndices(); err != nil {
+		return 0, err
+	}
+	weld := n.weldedVertices()
+	var faces []*Face
+	for _, g := range n.FaceGroup {
+		faces = append(faces, g.Faces...)
+	}
+	edges := make(map[[2]uint32]*orientEdge)
+	faceEdges := make([][][2]uint32, len(faces))
+	for i, f := range faces {
+		for k := 0; k < 3; k++ {
+			key := [2]uint32{weld[f.Vertex[k]], weld[f.Vertex[(k+1)%3]]}
+			if key[0] == key[1] {
+				continue
+			}
+			fwd := key[0] < key[1]
+			if !fwd {
+				key[0], key[1] = key[1], key[0]
+			}
+			e := edges[key]
+			if e == nil {
+				e = &orientEdge{}
+				edges[key] = e
+			}
+			e.faces = append(e.faces, i)
+			e.forward = append(e.forward, fwd)
+			faceEdges[i] = append(faceEdges[i], key)
+		}
+	}
+	flip := make([]bool, len(faces))
+	visited := make([]bool, len(faces))
+	flipped := 0
+	for seed := range faces {
+		if visited[seed] || len(faceEdges[seed]) == 0 {
+			continue
+		}
+		visited[seed] = true
+		component := []int{seed}
+		closed := true
+		for q := 0; q < len(component); q++ {
+			f := component[q]
+			for _, key := range faceEdges[f] {
+				e := edges[key]
+				if len(e.faces) != 2 {
+					closed = false
+					continue
+				}
+				a, b := 0, 1
+				if e.faces[a] != f {
+					a, b = b, a
+				}
+				g := e.faces[b]
+				if visited[g] {
+					continue
+				}
+				visited[g] = true
+				flip[g] = (e.forward[a] != flip[f]) == e.forward[b]
+				component = append(component, g)
+			}
+		}
+		if closed && n.orientedVolume(faces, component, flip) < 0 {
+			for _, f := range component {
+				flip[f] = !flip[f]
+			}
+		}
+		for _, f := range component {
+			if flip[f] {
+				flipFace(faces[f])
+				flipped++
+			}
+		}
+	}
+	if flipped > 0 && len(n.Normals) > 0 {
+		n.ReComputeNormal()
+	}
+	return flipped, nil
+}
+
+func (n *MeshNode) orientedVolume(faces []*Face, component []int, flip []bool) float64 {
+	var origin dvec3.T
+	for _, f := range component {
+		p := n.Vertices[faces[f].Vertex[0]]
+		origin.Add(&dvec3.T{float64(p[0]), float64(p[1]), float64(p[2])})
+	}
+	origin.Scale(1 / float64(len(component)))
+	vol := 0.0
+	for _, f := range component {
+		var v [3]dvec3.T
+		for k, i := range faces[f].Vertex {
+			p := n.Vertices[i]
+			v[k] = dvec3.T{float64(p[0]) - origin[0], float64(p[1]) - origin[1], float64(p[2]) - origin[2]}
+		}
+		bc := dvec3.Cross(&v[1], &v[2])
+		d := dvec3.Dot(&v[0], &bc)
+		if flip[f] {
+			d = -d
+		}
+		vol += d
+	}
+	return vol / 6
+}
+
+func flipFace(f *Face) {
+	if f.Normal != nil && f.Normal != &f.Vertex {
+		f.Normal[1], f.Normal[2] = f.Normal[2], f.Normal[1]
+	}
+	if f.Uv != nil && f.Uv != &f.Vertex && f.Uv != f.Normal {
+		f.Uv[1], f.Uv[2] = f.Uv[2], f.Uv[1]
+	}
+	f.Vertex[1], f.Vertex[2] = f.Vertex[2], f.Vertex[1]
+}
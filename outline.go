@@ -0,0 +1,226 @@
+package mst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/qmuntal/gltf"
+)
+
+const (
+	outlineStyleColor  = 1 << 0
+	outlineStyleClosed = 1 << 1
+)
+
+const maxOutlineDashes = 64
+
+func (g *MeshOutline) styled() bool {
+	return g.Width != 0 || g.Color != nil || len(g.Dash) > 0 || g.Closed
+}
+
+func hasOutlineStyles(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool {
+		for _, g := range nd.EdgeGroup {
+			if g.styled() {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func dropOutlineStyles(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		var groups []*MeshOutline
+		n := 0
+		for j, g := range nd.EdgeGroup {
+			if !g.styled() {
+				continue
+			}
+			if groups == nil {
+				groups = append([]*MeshOutline(nil), nd.EdgeGroup...)
+			}
+			groups[j] = &MeshOutline{Batchid: g.Batchid, Edges: g.Edges}
+			n++
+		}
+		if groups == nil {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].edgeGroup", field, i), Message: fmt.Sprintf("dropped styles of %d outlines", n)})
+		cp := *nd
+		cp.EdgeGroup = groups
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func (g *MeshOutline) withEdges(batchid int32, edges [][2]uint32) *MeshOutline {
+	out := &MeshOutline{Batchid: batchid, Edges: edges, Width: g.Width, Closed: g.Closed}
+	if g.Color != nil {
+		cl := *g.Color
+		out.Color = &cl
+	}
+	if g.Dash != nil {
+		out.Dash = append([]float32(nil), g.Dash...)
+	}
+	return out
+}
+
+func (g *MeshOutline) Polylines() [][]uint32 {
+	var lines [][]uint32
+	for i, e := range g.Edges {
+		if i > 0 && g.Edges[i-1][1] == e[0] {
+			l := len(lines) - 1
+			lines[l] = append(lines[l], e[1])
+			continue
+		}
+		lines = append(lines, []uint32{e[0], e[1]})
+	}
+	if g.Closed && len(lines) > 0 {
+		l := lines[len(lines)-1]
+		if first := lines[0][0]; len(l) > 2 && l[len(l)-1] != first {
+			lines[len(lines)-1] = append(l, first)
+		}
+	}
+	return lines
+}
+
+func (g *MeshOutline) closedEdges() [][2]uint32 {
+	if !g.Closed || len(g.Edges) < 2 {
+		return g.Edges
+	}
+	first, last := g.Edges[0][0], g.Edges[len(g.Edges)-1][1]
+	if first == last {
+		return g.Edges
+	}
+	return append(append([][2]uint32(nil), g.Edges...), [2]uint32{last, first})
+}
+
+func outlineStyleMarshal(wt io.Writer, g *MeshOutline) {
+	var flags uint8
+	if g.Color != nil {
+		flags |= outlineStyleColor
+	}
+	if g.Closed {
+		flags |= outlineStyleClosed
+	}
+	writeLittleByte(wt, flags)
+	writeLittleByte(wt, g.Width)
+	if g.Color != nil {
+		writeLittleByte(wt, g.Color[:])
+	}
+	writeLittleByte(wt, uint32(len(g.Dash)))
+	writeLittleByte(wt, g.Dash)
+}
+
+func (d *decoder) outlineStyle(g *MeshOutline) {
+	var flags uint8
+	if d.read(&flags) && flags&^(outlineStyleColor|outlineStyleClosed) != 0 {
+		d.fail(fmt.Errorf("mst: invalid outline style flags %#x", flags))
+		return
+	}
+	d.read(&g.Width)
+	if !finite(g.Width) || g.Width < 0 {
+		d.fail(fmt.Errorf("mst: invalid outline width %v", g.Width))
+		return
+	}
+	if flags&outlineStyleColor != 0 {
+		g.Color = &[3]byte{}
+		d.read(g.Color[:])
+	}
+	g.Closed = flags&outlineStyleClosed != 0
+	n := d.count("outline dash count", func(l *DecodeLimits) uint32 { return maxOutlineDashes })
+	if n > 0 {
+		g.Dash = make([]float32, n)
+		d.read(g.Dash)
+	}
+}
+
+func (d *decoder) skipOutlineStyle() {
+	var flags uint8
+	d.read(&flags)
+	d.skip(4)
+	if flags&outlineStyleColor != 0 {
+		d.skip(3)
+	}
+	d.skip(4 * int64(d.count("outline dash count", func(l *DecodeLimits) uint32 { return maxOutlineDashes })))
+}
+
+func outlineExtras(g *MeshOutline) interface{} {
+	if g.Width == 0 && len(g.Dash) == 0 && !g.Closed {
+		return nil
+	}
+	extras := map[string]interface{}{}
+	if g.Width != 0 {
+		extras["lineWidth"] = g.Width
+	}
+	if len(g.Dash) > 0 {
+		extras["dashPattern"] = g.Dash
+	}
+	if g.Closed {
+		extras["closed"] = true
+	}
+	return extras
+}
+
+func outlineMaterial(cl *[3]byte) *gltf.Material {
+	gm := &gltf.Material{DoubleSided: true, AlphaMode: gltf.AlphaOpaque}
+	mc, rg := float32(0), float32(1)
+	gm.PBRMetallicRoughness = &gltf.PBRMetallicRoughness{
+		BaseColorFactor: &[4]float32{float32(cl[0]) / 255, float32(cl[1]) / 255, float32(cl[2]) / 255, 1},
+		MetallicFactor:  &mc,
+		RoughnessFactor: &rg,
+	}
+	return gm
+}
+
+func (ctx *buildContext) outlineMaterialIndex(g *MeshOutline) uint32 {
+	if g.Color == nil && ctx.lineDef != nil {
+		return *ctx.lineDef
+	}
+	idx := ctx.mtlEnd + uint32(len(ctx.lineMtl))
+	if g.Color != nil {
+		ctx.lineMtl = append(ctx.lineMtl, outlineMaterial(g.Color))
+		return idx
+	}
+	ctx.lineMtl = append(ctx.lineMtl, outlineMaterial(&[3]byte{}))
+	ctx.lineDef = &idx
+	return idx
+}
+
+func buildOutlinePrimitives(ctx *buildContext, doc *gltf.Document, mesh *gltf.Mesh, nd *MeshNode) {
+	pos := mesh.Primitives[0].Attributes["POSITION"]
+	buffer := doc.Buffers[0]
+	buf := &bytes.Buffer{}
+	for _, g := range nd.EdgeGroup {
+		for _, e := range g.closedEdges() {
+			binary.Write(buf, binary.LittleEndian, e)
+		}
+	}
+	bv := uint32(len(doc.BufferViews))
+	doc.BufferViews = append(doc.BufferViews, &gltf.BufferView{Buffer: 0, ByteOffset: buffer.ByteLength, ByteLength: uint32(buf.Len())})
+	buffer.ByteLength += uint32(buf.Len())
+	buffer.Data = append(buffer.Data, buf.Bytes()...)
+
+	var start uint32
+	for _, g := range nd.EdgeGroup {
+		edges := uint32(len(g.closedEdges()))
+		index := uint32(len(doc.Accessors))
+		doc.Accessors = append(doc.Accessors, &gltf.Accessor{ComponentType: gltf.ComponentUint, ByteOffset: start * 8, Count: edges * 2, BufferView: &bv})
+		start += edges
+		mtl := ctx.outlineMaterialIndex(g)
+		mesh.Primitives = append(mesh.Primitives, &gltf.Primitive{
+			Attributes: gltf.Attribute{"POSITION": pos},
+			Indices:    &index,
+			Material:   &mtl,
+			Mode:       gltf.PrimitiveLines,
+			Extras:     outlineExtras(g),
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+const PALETTE_TEXTURE_NAME = "palette"
+
+var ErrEmptyPalette = errors.New("mst: palette has no colors")
+
+type ColorStop struct {
+	Value float64
+	Color [4]byte
+}
+
+type ColorRamp []ColorStop
+
+func (r ColorRamp) At(v float64) [4]byte {
+	if len(r) == 0 {
+		return [4]byte{}
+	}
+	i := sort.Search(len(r), func(i int) bool { return r[i].Value >= v })
+	if i == 0 {
+		return r[0].Color
+	}
+	if i == len(r) {
+		return r[len(r)-1].Color
+	}
+	a, b := r[i-1], r[i]
+	t := (v - a.Value) / (b.Value - a.Value)
+	var c [4]byte
+	for k := range c {
+		c[k] = byte(math.Round(float64(a.Color[k]) + t*(float64(b.Color[k])-float64(a.Color[k]))))
+	}
+	return c
+}
+
+func (r ColorRamp) Texture(width int) (*Texture, error) {
+	if len(r) == 0 {
+		return nil, ErrEmptyPalette
+	}
+	if width < 2 {
+		return nil, fmt.Errorf("mst: ramp texture width must be at least 2, got %d", width)
+	}
+	for i := 1; i < len(r); i++ {
+		if !(r[i].Value > r[i-1].Value) {
+			return nil, fmt.Errorf("mst: ramp stop %d is not in increasing order", i)
+		}
+	}
+	lo, hi := r[0].Value, r[len(r)-1].Value
+	colors := make([][4]byte, width)
+	for i := range colors {
+		colors[i] = r.At(lo + (hi-lo)*float64(i)/float64(width-1))
+	}
+	return PaletteTexture(colors)
+}
+
+func PaletteTexture(colors [][4]byte) (*Texture, error) {
+	if len(colors) == 0 {
+		return nil, ErrEmptyPalette
+	}
+	tex := &Texture{Name: PALETTE_TEXTURE_NAME, Size: [2]uint64{uint64(len(colors)), 1}, Format: TEXTURE_FORMAT_RGBA, Type: TEXTURE_PIXEL_TYPE_UBYTE, Compressed: TEXTURE_COMPRESSED_NONE}
+	tex.Data = make([]byte, 0, 4*len(colors))
+	for _, c := range colors {
+		tex.Data = append(tex.Data, c[:]...)
+	}
+	return tex, nil
+}
+
+func PaletteUV(class, count int) vec2.T {
+	return vec2.T{(float32(class) + 0.5) / float32(count), 0.5}
+}
+
+func (n *MeshNode) AssignFacePalette(count int, classOf func(batchid int32, f *Face) int) error {
+	if count <= 0 {
+		return ErrEmptyPalette
+	}
+	classes := make(map[*Face]int)
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			c := classOf(g.Batchid, f)
+			if c < 0 || c >= count {
+				return &IndexError{Kind: "palette class", Index: uint32(c), Count: count}
+			}
+			classes[f] = c
+		}
+	}
+	return n.setCornerUVs(func(f *Face, corner int) vec2.T {
+		return PaletteUV(classes[f], count)
+	})
+}
+
+func (n *MeshNode) AssignVertexPalette(classes []int, count int) error {
+	if count <= 0 {
+		return ErrEmptyPalette
+	}
+	if len(classes) != len(n.Vertices) {
+		return fmt.Errorf("mst: %d vertex classes for %d vertices", len(classes), len(n.Vertices))
+	}
+	n.TexCoords = make([]vec2.T, len(n.Vertices))
+	for i, c := range classes {
+		if c < 0 || c >= count {
+			return &IndexError{Kind: "palette class", Index: uint32(c), Count: count}
+		}
+		n.TexCoords[i] = PaletteUV(c, count)
+	}
+	n.clearFaceUvs()
+	return nil
+}
+
+func (n *MeshNode) AssignVertexRamp(values []float64, min, max float64) error {
+	if len(values) != len(n.Vertices) {
+		return fmt.Errorf("mst: %d vertex values for %d vertices", len(values), len(n.Vertices))
+	}
+	if !(max > min) {
+		return fmt.Errorf("mst: invalid ramp range [%v, %v]", min, max)
+	}
+	n.TexCoords = make([]vec2.T, len(n.Vertices))
+	for i, v := range values {
+		t := math.Max(0, math.Min(1, (v-min)/(max-min)))
+		if math.IsNaN(t) {
+			t = 0
+		}
+		n.TexCoords[i] = vec2.T{float32(t), 0.5}
+	}
+	n.clearFaceUvs()
+	return nil
+}
+
+type Classification struct {
+	Name    string
+	Colors  [][4]byte
+	ClassOf func(nd *MeshNode, batchid int32, f *Face) int
+}
+
+func (m *Mesh) ApplyClassification(c *Classification) error {
+	tex, err := PaletteTexture(c.Colors)
+	if err != nil {
+		return err
+	}
+	if c.Name != "" {
+		tex.Name = c.Name
+	}
+	bases := []*BaseMesh{&m.BaseMesh}
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh != nil {
+			bases = append(bases, inst.Mesh)
+		}
+	}
+	for _, mh := range bases {
+		for _, mtl := range mh.Materials {
+			if tm := materialTextures(mtl); tm != nil {
+				for _, t := range []*Texture{tm.Texture, tm.Normal} {
+					if t != nil && t.Id >= tex.Id {
+						tex.Id = t.Id + 1
+					}
+				}
+			}
+		}
+	}
+	seen := make(map[*BaseMesh]bool)
+	apply := func(mh *BaseMesh, field string) error {
+		if seen[mh] {
+			return nil
+		}
+		seen[mh] = true
+		mtl := uint32(len(mh.Materials))
+		mh.Materials = append(mh.Materials, &TextureMaterial{BaseMaterial: BaseMaterial{Name: tex.Name, Color: [3]byte{255, 255, 255}}, Texture: tex})
+		for i, nd := range mh.Nodes {
+			err := nd.AssignFacePalette(len(c.Colors), func(batchid int32, f *Face) int {
+				return c.ClassOf(nd, batchid, f)
+			})
+			if err != nil {
+				return &AssetError{Field: fmt.Sprintf("%snodes[%d]", field, i), Err: err}
+			}
+			for _, g := range nd.FaceGroup {
+				g.Batchid = int32(mtl)
+			}
+		}
+		return nil
+	}
+	if err := apply(&m.BaseMesh, ""); err != nil {
+		return err
+	}
+	for i, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		if err := apply(inst.Mesh, fmt.Sprintf("instances[%d].", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isLookupTexture(tex *Texture) bool {
+	return tex.Size[1] == 1 && tex.Size[0] > 1
+}
@@ -0,0 +1,48 @@
+package mst
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/qmuntal/gltf"
+)
+
+const DEFAULT_PIPE_BUFFER_SIZE = 64 * 1024
+
+func NewPipe(bufSize int, encode func(wt io.Writer) error) io.ReadCloser {
+	if bufSize <= 0 {
+		bufSize = DEFAULT_PIPE_BUFFER_SIZE
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriterSize(pw, bufSize)
+		err := encode(bw)
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func MeshPipe(ms *Mesh, bufSize int, opts ...WriteOption) io.ReadCloser {
+	return NewPipe(bufSize, func(wt io.Writer) error {
+		return meshMarshal(wt, ms, newWriteOptions(opts))
+	})
+}
+
+func GltfPipe(doc *gltf.Document, bufSize int) io.ReadCloser {
+	return NewPipe(bufSize, func(wt io.Writer) error {
+		enc := gltf.NewEncoder(wt)
+		enc.AsBinary = true
+		return enc.Encode(doc)
+	})
+}
+
+func MeshGlbPipe(msts []*Mesh, bufSize int) (io.ReadCloser, error) {
+	doc, err := MstToGltf(msts)
+	if err != nil {
+		return nil, err
+	}
+	return GltfPipe(doc, bufSize), nil
+}
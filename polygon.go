@@ -0,0 +1,487 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+type Polygon struct {
+	Outer []uint32   `json:"outer"`
+	Holes [][]uint32 `json:"holes,omitempty"`
+}
+
+type MeshPolygon struct {
+	Batchid  int32      `json:"batchid"`
+	Polygons []*Polygon `json:"polygons"`
+}
+
+func (p *Polygon) rings() [][]uint32 {
+	return append([][]uint32{p.Outer}, p.Holes...)
+}
+
+func (p *Polygon) validate(vertices int) error {
+	if p == nil {
+		return fmt.Errorf("mst: nil polygon")
+	}
+	for i, ring := range p.rings() {
+		if len(ring) < 3 {
+			return fmt.Errorf("mst: polygon ring %d has %d vertices", i, len(ring))
+		}
+		if err := checkIndices("polygon vertex", ring, vertices); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *MeshNode) AddPolygons(batchid int32, polys []*Polygon) error {
+	for _, p := range polys {
+		if err := p.validate(len(n.Vertices)); err != nil {
+			return err
+		}
+	}
+	for _, g := range n.PolygonGroup {
+		if g.Batchid == batchid {
+			g.Polygons = append(g.Polygons, polys...)
+			return nil
+		}
+	}
+	n.PolygonGroup = append(n.PolygonGroup, &MeshPolygon{Batchid: batchid, Polygons: polys})
+	sort.SliceStable(n.PolygonGroup, func(i, j int) bool { return n.PolygonGroup[i].Batchid < n.PolygonGroup[j].Batchid })
+	return nil
+}
+
+func (g *MeshPolygon) Triangulate(vertices []vec3.T) (*MeshTriangle, error) {
+	out := &MeshTriangle{Batchid: g.Batchid}
+	for _, p := range g.Polygons {
+		tris, err := p.Triangulate(vertices)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tris {
+			out.Faces = append(out.Faces, &Face{Vertex: t})
+		}
+	}
+	return out, nil
+}
+
+// Triangulate replaces the faces of every batchid that has polygons with the
+// triangulation of those polygons, so calling it again gives the same faces.
+// The polygons are kept for editing.
+func (n *MeshNode) Triangulate() error {
+	for _, g := range n.PolygonGroup {
+		tg, err := g.Triangulate(n.Vertices)
+		if err != nil {
+			return err
+		}
+		n.replaceFaces(tg)
+	}
+	return nil
+}
+
+func (n *MeshNode) replaceFaces(tg *MeshTriangle) {
+	for i, g := range n.FaceGroup {
+		if g.Batchid != tg.Batchid {
+			continue
+		}
+		if len(tg.Faces) == 0 {
+			n.FaceGroup = append(n.FaceGroup[:i:i], n.FaceGroup[i+1:]...)
+		} else {
+			n.FaceGroup[i] = tg
+		}
+		return
+	}
+	if len(tg.Faces) == 0 {
+		return
+	}
+	n.FaceGroup = append(n.FaceGroup, tg)
+	sort.SliceStable(n.FaceGroup, func(i, j int) bool { return n.FaceGroup[i].Batchid < n.FaceGroup[j].Batchid })
+}
+
+// withPolygonFaces returns a view of the node whose face groups hold the
+// triangulated polygons, for exporters and queries that only read triangles.
+func (n *MeshNode) withPolygonFaces() *MeshNode {
+	if len(n.PolygonGroup) == 0 {
+		return n
+	}
+	cp := *n
+	cp.FaceGroup = append([]*MeshTriangle(nil), n.FaceGroup...)
+	cp.PolygonGroup = nil
+	for _, g := range n.PolygonGroup {
+		tg, err := g.Triangulate(n.Vertices)
+		if err != nil {
+			return n
+		}
+		cp.replaceFaces(tg)
+	}
+	return &cp
+}
+
+type ringVertex struct {
+	index uint32
+	x, y  float64
+}
+
+func polygonBasis(vertices []vec3.T, ring []uint32) (u, v [3]float64, ok bool) {
+	var nm [3]float64
+	for i := range ring {
+		a, b := vertices[ring[i]], vertices[ring[(i+1)%len(ring)]]
+		nm[0] += float64(a[1]-b[1]) * float64(a[2]+b[2])
+		nm[1] += float64(a[2]-b[2]) * float64(a[0]+b[0])
+		nm[2] += float64(a[0]-b[0]) * float64(a[1]+b[1])
+	}
+	l := math.Sqrt(nm[0]*nm[0] + nm[1]*nm[1] + nm[2]*nm[2])
+	if l == 0 {
+		return u, v, false
+	}
+	for i := range nm {
+		nm[i] /= l
+	}
+	cross := func(a, b [3]float64) [3]float64 {
+		return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+	}
+	var axis [3]float64
+	k := 0
+	for i := 1; i < 3; i++ {
+		if math.Abs(nm[i]) < math.Abs(nm[k]) {
+			k = i
+		}
+	}
+	axis[k] = 1
+	u = cross(axis, nm)
+	ul := math.Sqrt(u[0]*u[0] + u[1]*u[1] + u[2]*u[2])
+	for i := range u {
+		u[i] /= ul
+	}
+	return u, cross(nm, u), true
+}
+
+func ringArea(ring []ringVertex) float64 {
+	a := 0.0
+	for i := range ring {
+		p, q := ring[i], ring[(i+1)%len(ring)]
+		a += p.x*q.y - q.x*p.y
+	}
+	return a / 2
+}
+
+func orient(a, b, c ringVertex) float64 {
+	return (b.x-a.x)*(c.y-a.y) - (b.y-a.y)*(c.x-a.x)
+}
+
+func samePoint(a, b ringVertex) bool {
+	return a.x == b.x && a.y == b.y
+}
+
+func inTriangle(p, a, b, c ringVertex) bool {
+	return orient(a, b, p) >= 0 && orient(b, c, p) >= 0 && orient(c, a, p) >= 0
+}
+
+func (p *Polygon) Triangulate(vertices []vec3.T) ([][3]uint32, error) {
+	if err := p.validate(len(vertices)); err != nil {
+		return nil, err
+	}
+	u, v, ok := polygonBasis(vertices, p.Outer)
+	if !ok {
+		return nil, nil
+	}
+	project := func(ring []uint32, ccw bool) []ringVertex {
+		out := make([]ringVertex, len(ring))
+		for i, idx := range ring {
+			pt := vertices[idx]
+			x := float64(pt[0])*u[0] + float64(pt[1])*u[1] + float64(pt[2])*u[2]
+			y := float64(pt[0])*v[0] + float64(pt[1])*v[1] + float64(pt[2])*v[2]
+			out[i] = ringVertex{index: idx, x: x, y: y}
+		}
+		if area := ringArea(out); (area > 0) != ccw && area != 0 {
+			for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+				out[i], out[j] = out[j], out[i]
+			}
+		}
+		return out
+	}
+	outer := project(p.Outer, true)
+	holes := make([][]ringVertex, len(p.Holes))
+	for i, h := range p.Holes {
+		holes[i] = project(h, false)
+	}
+	return earClip(bridgeHoles(outer, holes)), nil
+}
+
+func rightmost(ring []ringVertex) int {
+	m := 0
+	for i, p := range ring {
+		if p.x > ring[m].x || (p.x == ring[m].x && p.y < ring[m].y) {
+			m = i
+		}
+	}
+	return m
+}
+
+func bridgeHoles(outer []ringVertex, holes [][]ringVertex) []ringVertex {
+	sort.SliceStable(holes, func(i, j int) bool {
+		return holes[i][rightmost(holes[i])].x > holes[j][rightmost(holes[j])].x
+	})
+	for _, h := range holes {
+		mi := rightmost(h)
+		pi := bridgeVertex(outer, h[mi])
+		if pi < 0 {
+			continue
+		}
+		merged := make([]ringVertex, 0, len(outer)+len(h)+2)
+		merged = append(merged, outer[:pi+1]...)
+		merged = append(merged, h[mi:]...)
+		merged = append(merged, h[:mi+1]...)
+		merged = append(merged, outer[pi:]...)
+		outer = merged
+	}
+	return outer
+}
+
+func bridgeVertex(ring []ringVertex, m ringVertex) int {
+	best, bx := -1, math.Inf(1)
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		if (a.y < m.y && b.y < m.y) || (a.y > m.y && b.y > m.y) {
+			continue
+		}
+		if a.y == b.y {
+			if a.y != m.y {
+				continue
+			}
+			for _, k := range []int{i, (i + 1) % len(ring)} {
+				if x := ring[k].x; x >= m.x && x < bx {
+					best, bx = k, x
+				}
+			}
+			continue
+		}
+		x := a.x + (m.y-a.y)*(b.x-a.x)/(b.y-a.y)
+		if x < m.x || x >= bx {
+			continue
+		}
+		bx = x
+		best = i
+		if b.x > a.x {
+			best = (i + 1) % len(ring)
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	i := ringVertex{x: bx, y: m.y}
+	p := ring[best]
+	if samePoint(p, i) {
+		return best
+	}
+	tri := [3]ringVertex{m, i, p}
+	if orient(m, i, p) < 0 {
+		tri = [3]ringVertex{m, p, i}
+	}
+	bestCos := -2.0
+	bestDist := math.Inf(1)
+	found := best
+	for k, r := range ring {
+		if k == best || samePoint(r, p) || !inTriangle(r, tri[0], tri[1], tri[2]) {
+			continue
+		}
+		prev, next := ring[(k+len(ring)-1)%len(ring)], ring[(k+1)%len(ring)]
+		if orient(prev, r, next) > 0 {
+			continue
+		}
+		dx, dy := r.x-m.x, r.y-m.y
+		d := math.Hypot(dx, dy)
+		if d == 0 {
+			continue
+		}
+		if c := dx / d; c > bestCos || (c == bestCos && d < bestDist) {
+			found, bestCos, bestDist = k, c, d
+		}
+	}
+	return found
+}
+
+func earClip(ring []ringVertex) [][3]uint32 {
+	var tris [][3]uint32
+	idx := make([]int, len(ring))
+	for i := range idx {
+		idx[i] = i
+	}
+	isEar := func(k int) bool {
+		a, b, c := ring[idx[(k+len(idx)-1)%len(idx)]], ring[idx[k]], ring[idx[(k+1)%len(idx)]]
+		if orient(a, b, c) <= 0 {
+			return false
+		}
+		for _, j := range idx {
+			p := ring[j]
+			if samePoint(p, a) || samePoint(p, b) || samePoint(p, c) {
+				continue
+			}
+			if inTriangle(p, a, b, c) {
+				return false
+			}
+		}
+		return true
+	}
+	k := 0
+	for len(idx) > 3 {
+		ear := k
+		for tries := 0; tries < len(idx); tries++ {
+			if i := (k + tries) % len(idx); isEar(i) {
+				ear = i
+				break
+			}
+		}
+		tris = append(tris, earTriangle(ring, idx, ear))
+		idx = append(idx[:ear], idx[ear+1:]...)
+		k = (ear + len(idx) - 1) % len(idx)
+	}
+	if len(idx) == 3 && orient(ring[idx[0]], ring[idx[1]], ring[idx[2]]) != 0 {
+		tris = append(tris, earTriangle(ring, idx, 1))
+	}
+	return tris
+}
+
+func earTriangle(ring []ringVertex, idx []int, k int) [3]uint32 {
+	n := len(idx)
+	return [3]uint32{ring[idx[(k+n-1)%n]].index, ring[idx[k]].index, ring[idx[(k+1)%n]].index}
+}
+
+func (n *MeshNode) validatePolygons() error {
+	for _, g := range n.PolygonGroup {
+		for _, p := range g.Polygons {
+			if err := p.validate(len(n.Vertices)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *MeshNode) remapPolygonVertices(remap func(uint32) uint32) {
+	for _, g := range n.PolygonGroup {
+		for _, p := range g.Polygons {
+			for _, ring := range p.rings() {
+				for i, v := range ring {
+					ring[i] = remap(v)
+				}
+			}
+		}
+	}
+}
+
+func clonePolygonGroups(groups []*MeshPolygon, remap func(int32) int32) []*MeshPolygon {
+	if groups == nil {
+		return nil
+	}
+	out := make([]*MeshPolygon, len(groups))
+	for i, g := range groups {
+		cg := &MeshPolygon{Batchid: remap(g.Batchid), Polygons: make([]*Polygon, len(g.Polygons))}
+		for j, p := range g.Polygons {
+			cp := &Polygon{Outer: append([]uint32(nil), p.Outer...)}
+			for _, h := range p.Holes {
+				cp.Holes = append(cp.Holes, append([]uint32(nil), h...))
+			}
+			cg.Polygons[j] = cp
+		}
+		out[i] = cg
+	}
+	return out
+}
+
+func hasPolygons(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool { return len(nd.PolygonGroup) > 0 })
+}
+
+func polygonsMarshal(wt io.Writer, nd *MeshNode, width uint8) {
+	writeLittleByte(wt, uint32(len(nd.PolygonGroup)))
+	for _, g := range nd.PolygonGroup {
+		writeLittleByte(wt, g.Batchid)
+		writeLittleByte(wt, uint32(len(g.Polygons)))
+		for _, p := range g.Polygons {
+			writeLittleByte(wt, uint32(1+len(p.Holes)))
+			for _, ring := range p.rings() {
+				writeLittleByte(wt, uint32(len(ring)))
+				writeIndices(wt, ring, width)
+			}
+		}
+	}
+}
+
+func (d *decoder) polygons(nd *MeshNode, width uint8) {
+	maxFaces := func(l *DecodeLimits) uint32 { return l.MaxFaces }
+	groups := d.count("polygon group count", maxFaces)
+	for i := 0; i < groups && d.err == nil; i++ {
+		g := &MeshPolygon{}
+		d.read(&g.Batchid)
+		n := d.count("polygon count", maxFaces)
+		g.Polygons = make([]*Polygon, 0, capHint(n))
+		for j := 0; j < n && d.err == nil; j++ {
+			p := &Polygon{}
+			rings := d.count("polygon ring count", maxFaces)
+			for k := 0; k < rings && d.err == nil; k++ {
+				ring := d.indexList(d.count("polygon vertex count", func(l *DecodeLimits) uint32 { return l.MaxVertices }), width)
+				if k == 0 {
+					p.Outer = ring
+				} else {
+					p.Holes = append(p.Holes, ring)
+				}
+			}
+			g.Polygons = append(g.Polygons, p)
+		}
+		nd.PolygonGroup = append(nd.PolygonGroup, g)
+	}
+	if d.err == nil {
+		d.fail(nd.validatePolygons())
+	}
+}
+
+func (d *decoder) skipPolygons(width uint8) {
+	maxFaces := func(l *DecodeLimits) uint32 { return l.MaxFaces }
+	groups := d.count("polygon group count", maxFaces)
+	for i := 0; i < groups && d.err == nil; i++ {
+		d.skip(4)
+		n := d.count("polygon count", maxFaces)
+		for j := 0; j < n && d.err == nil; j++ {
+			rings := d.count("polygon ring count", maxFaces)
+			for k := 0; k < rings && d.err == nil; k++ {
+				d.skipArray("polygon vertex count", int64(width))
+			}
+		}
+	}
+}
+
+func polygonsSize(nd *MeshNode, width int64) int64 {
+	size := int64(4)
+	for _, g := range nd.PolygonGroup {
+		size += 8
+		for _, p := range g.Polygons {
+			size += 4
+			for _, ring := range p.rings() {
+				size += 4 + int64(len(ring))*width
+			}
+		}
+	}
+	return size
+}
+
+func dropPolygons(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if len(nd.PolygonGroup) == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.PolygonGroup = nil
+		out[i] = &cp
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].polygonGroup", field, i), Message: fmt.Sprintf("dropped %d polygon groups", len(nd.PolygonGroup))})
+	}
+	return out, warns
+}
@@ -0,0 +1,212 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	MESH_TRIANGLE_MODE_TRIANGLES = 0
+	MESH_TRIANGLE_MODE_STRIP     = 1
+	MESH_TRIANGLE_MODE_FAN       = 2
+)
+
+func primitiveFaces(mode uint8, idx []uint32) ([][3]uint32, error) {
+	var tris [][3]uint32
+	switch mode {
+	case MESH_TRIANGLE_MODE_TRIANGLES:
+		for i := 0; i+2 < len(idx); i += 3 {
+			tris = append(tris, [3]uint32{idx[i], idx[i+1], idx[i+2]})
+		}
+	case MESH_TRIANGLE_MODE_STRIP:
+		for i := 0; i+2 < len(idx); i++ {
+			if i%2 == 0 {
+				tris = append(tris, [3]uint32{idx[i], idx[i+1], idx[i+2]})
+			} else {
+				tris = append(tris, [3]uint32{idx[i+1], idx[i], idx[i+2]})
+			}
+		}
+	case MESH_TRIANGLE_MODE_FAN:
+		for i := 1; i+1 < len(idx); i++ {
+			tris = append(tris, [3]uint32{idx[0], idx[i], idx[i+1]})
+		}
+	default:
+		return nil, fmt.Errorf("mst: unknown primitive mode %d", mode)
+	}
+	return tris, nil
+}
+
+func (g *MeshTriangle) stripIndices() []uint32 {
+	if len(g.Faces) == 0 {
+		return nil
+	}
+	f0 := g.Faces[0].Vertex
+	idx := append(make([]uint32, 0, len(g.Faces)+2), f0[:]...)
+	for i := 1; i < len(g.Faces); i++ {
+		v := g.Faces[i].Vertex
+		switch g.Mode {
+		case MESH_TRIANGLE_MODE_STRIP:
+			a, b := idx[i], idx[i+1]
+			if i%2 == 1 {
+				a, b = b, a
+			}
+			if v[0] != a || v[1] != b {
+				return nil
+			}
+		case MESH_TRIANGLE_MODE_FAN:
+			if v[0] != idx[0] || v[1] != idx[i+1] {
+				return nil
+			}
+		}
+		idx = append(idx, v[2])
+	}
+	return idx
+}
+
+func (g *MeshTriangle) PrimitiveMode() uint8 {
+	switch g.Mode {
+	case MESH_TRIANGLE_MODE_STRIP, MESH_TRIANGLE_MODE_FAN:
+		if g.stripIndices() != nil {
+			return g.Mode
+		}
+	}
+	return MESH_TRIANGLE_MODE_TRIANGLES
+}
+
+func (g *MeshTriangle) PrimitiveIndices() (uint8, []uint32) {
+	if mode := g.PrimitiveMode(); mode != MESH_TRIANGLE_MODE_TRIANGLES {
+		return mode, g.stripIndices()
+	}
+	idx := make([]uint32, 0, len(g.Faces)*3)
+	for _, f := range g.Faces {
+		idx = append(idx, f.Vertex[:]...)
+	}
+	return MESH_TRIANGLE_MODE_TRIANGLES, idx
+}
+
+func (n *MeshNode) AddPrimitive(batchid int32, mode uint8, idx []uint32) error {
+	tris, err := primitiveFaces(mode, idx)
+	if err != nil {
+		return err
+	}
+	g := &MeshTriangle{Batchid: batchid, Mode: mode, Faces: make([]*Face, len(tris))}
+	for i := range tris {
+		g.Faces[i] = &Face{Vertex: tris[i]}
+	}
+	return n.addFaces(g)
+}
+
+func (g *MeshTriangle) ConvertToTriangles() {
+	g.Mode = MESH_TRIANGLE_MODE_TRIANGLES
+}
+
+func (n *MeshNode) ConvertToTriangles() {
+	for _, g := range n.FaceGroup {
+		g.ConvertToTriangles()
+	}
+}
+
+func (m *Mesh) ConvertToTriangles() {
+	anyNode(m, func(nd *MeshNode) bool {
+		nd.ConvertToTriangles()
+		return false
+	})
+}
+
+func hasPrimitiveModes(ms *Mesh) bool {
+	return anyNode(ms, func(nd *MeshNode) bool {
+		for _, g := range nd.FaceGroup {
+			if g.PrimitiveMode() != MESH_TRIANGLE_MODE_TRIANGLES {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func primitiveMarshal(wt io.Writer, g *MeshTriangle, width uint8) {
+	mode, idx := g.PrimitiveIndices()
+	writeLittleByte(wt, mode)
+	if mode == MESH_TRIANGLE_MODE_TRIANGLES {
+		writeLittleByte(wt, uint32(len(g.Faces)))
+	} else {
+		writeLittleByte(wt, uint32(len(idx)))
+	}
+	writeIndices(wt, idx, width)
+}
+
+func (d *decoder) primitive(g *MeshTriangle, width uint8) {
+	d.read(&g.Mode)
+	if g.Mode == MESH_TRIANGLE_MODE_TRIANGLES {
+		g.Faces = d.faces(width)
+		return
+	}
+	n := d.count("index count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	idx := d.indexList(n, width)
+	tris, err := primitiveFaces(g.Mode, idx)
+	if d.fail(err); d.err != nil {
+		return
+	}
+	faces := make([]Face, len(tris))
+	g.Faces = make([]*Face, len(tris))
+	for i := range tris {
+		faces[i].Vertex = tris[i]
+		g.Faces[i] = &faces[i]
+	}
+}
+
+func (d *decoder) skipPrimitive(width uint8) int {
+	var mode uint8
+	d.read(&mode)
+	switch mode {
+	case MESH_TRIANGLE_MODE_TRIANGLES:
+		n := d.count("face count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+		d.skip(int64(n) * 3 * int64(width))
+		return n
+	case MESH_TRIANGLE_MODE_STRIP, MESH_TRIANGLE_MODE_FAN:
+	default:
+		d.fail(fmt.Errorf("mst: unknown primitive mode %d", mode))
+		return 0
+	}
+	n := d.count("index count", func(l *DecodeLimits) uint32 { return l.MaxFaces })
+	d.skip(int64(n) * int64(width))
+	if n < 3 {
+		return 0
+	}
+	return n - 2
+}
+
+func primitiveSize(g *MeshTriangle, width int64) int64 {
+	if mode, idx := g.PrimitiveIndices(); mode != MESH_TRIANGLE_MODE_TRIANGLES {
+		return 1 + int64(len(idx))*width
+	}
+	return 1 + int64(len(g.Faces))*3*width
+}
+
+func dropPrimitiveModes(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		dropped := false
+		for _, g := range nd.FaceGroup {
+			dropped = dropped || g.Mode != MESH_TRIANGLE_MODE_TRIANGLES
+		}
+		if !dropped {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.FaceGroup = make([]*MeshTriangle, len(nd.FaceGroup))
+		for j, g := range nd.FaceGroup {
+			cg := *g
+			cg.Mode = MESH_TRIANGLE_MODE_TRIANGLES
+			cp.FaceGroup[j] = &cg
+		}
+		out[i] = &cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "stored triangle strips and fans as triangle lists"})
+	}
+	return out, warns
+}
@@ -0,0 +1,96 @@
+package primitives
+
+import (
+	"fmt"
+	"math"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+type FootprintOptions struct {
+	Base         float64
+	NoFloor      bool
+	FloorBatchid int32
+	WallBatchid  int32
+	RoofBatchid  int32
+}
+
+var DefaultFootprintOptions = FootprintOptions{
+	FloorBatchid: 0,
+	WallBatchid:  1,
+	RoofBatchid:  2,
+}
+
+func ExtrudeFootprint(outer [][2]float64, holes [][][2]float64, height float64, opts *FootprintOptions) (*mst.MeshNode, error) {
+	if opts == nil {
+		opts = &DefaultFootprintOptions
+	}
+	if !(height > 0) || math.IsInf(height, 0) {
+		return nil, fmt.Errorf("primitives: height must be positive, got %v", height)
+	}
+	shell, err := ring(outer, true)
+	if err != nil {
+		return nil, err
+	}
+	rings := [][][2]float64{shell}
+	for i, h := range holes {
+		hole, err := ring(h, false)
+		if err != nil {
+			return nil, fmt.Errorf("primitives: hole %d: %v", i, err)
+		}
+		rings = append(rings, hole)
+	}
+	poly, tris, err := triangulateFootprint(shell, append([][][2]float64(nil), rings[1:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &builder{nd: &mst.MeshNode{}}
+	addCap := func(batchid int32, z float64, up bool) {
+		b.group(batchid)
+		n := vec3.T{0, 0, -1}
+		if up {
+			n = vec3.T{0, 0, 1}
+		}
+		base := uint32(len(b.nd.Vertices))
+		for _, p := range poly {
+			b.vertex(vec(p[0], p[1], z), n, vec2.T{float32(p[0]), float32(p[1])})
+		}
+		for _, t := range tris {
+			if up {
+				b.tri(base+t[0], base+t[1], base+t[2])
+			} else {
+				b.tri(base+t[0], base+t[2], base+t[1])
+			}
+		}
+	}
+	if !opts.NoFloor {
+		addCap(opts.FloorBatchid, opts.Base, false)
+	}
+	addCap(opts.RoofBatchid, opts.Base+height, true)
+
+	b.group(opts.WallBatchid)
+	z0, z1 := opts.Base, opts.Base+height
+	for _, r := range rings {
+		var dist float64
+		for i, p := range r {
+			q := r[(i+1)%len(r)]
+			dx, dy := q[0]-p[0], q[1]-p[1]
+			l := math.Hypot(dx, dy)
+			if l == 0 {
+				continue
+			}
+			n := vec(dy/l, -dx/l, 0)
+			a := b.vertex(vec(p[0], p[1], z0), n, vec2.T{float32(dist), 0})
+			c := b.vertex(vec(q[0], q[1], z0), n, vec2.T{float32(dist + l), 0})
+			e := b.vertex(vec(q[0], q[1], z1), n, vec2.T{float32(dist + l), float32(height)})
+			f := b.vertex(vec(p[0], p[1], z1), n, vec2.T{float32(dist), float32(height)})
+			b.tri(a, c, e)
+			b.tri(a, e, f)
+			dist += l
+		}
+	}
+	return b.nd, nil
+}
@@ -0,0 +1,403 @@
+package primitives
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrDegeneratePolygon = errors.New("primitives: polygon is degenerate or self-intersecting")
+
+type builder struct {
+	nd *mst.MeshNode
+	g  *mst.MeshTriangle
+}
+
+func newBuilder() *builder {
+	g := &mst.MeshTriangle{}
+	return &builder{nd: &mst.MeshNode{FaceGroup: []*mst.MeshTriangle{g}}, g: g}
+}
+
+func (b *builder) group(batchid int32) {
+	for _, g := range b.nd.FaceGroup {
+		if g.Batchid == batchid {
+			b.g = g
+			return
+		}
+	}
+	b.g = &mst.MeshTriangle{Batchid: batchid}
+	b.nd.FaceGroup = append(b.nd.FaceGroup, b.g)
+}
+
+func (b *builder) vertex(p, n vec3.T, uv vec2.T) uint32 {
+	b.nd.Vertices = append(b.nd.Vertices, p)
+	b.nd.Normals = append(b.nd.Normals, n)
+	b.nd.TexCoords = append(b.nd.TexCoords, uv)
+	return uint32(len(b.nd.Vertices) - 1)
+}
+
+func (b *builder) tri(a, c, d uint32) {
+	vs := b.nd.Vertices
+	if vs[a] == vs[c] || vs[c] == vs[d] || vs[d] == vs[a] {
+		return
+	}
+	b.g.Faces = append(b.g.Faces, &mst.Face{Vertex: [3]uint32{a, c, d}})
+}
+
+func (b *builder) grid(cols, rows int, fn func(u, v float64) (p, n vec3.T)) {
+	base := uint32(len(b.nd.Vertices))
+	for r := 0; r <= rows; r++ {
+		for c := 0; c <= cols; c++ {
+			u, v := float64(c)/float64(cols), float64(r)/float64(rows)
+			p, n := fn(u, v)
+			b.vertex(p, n, vec2.T{float32(u), float32(v)})
+		}
+	}
+	stride := uint32(cols + 1)
+	for r := uint32(0); r < uint32(rows); r++ {
+		for c := uint32(0); c < uint32(cols); c++ {
+			i00 := base + r*stride + c
+			i10, i01 := i00+1, i00+stride
+			i11 := i01 + 1
+			b.tri(i00, i10, i11)
+			b.tri(i00, i11, i01)
+		}
+	}
+}
+
+func (b *builder) disk(radius, z float64, up bool, segments int) {
+	n := vec3.T{0, 0, -1}
+	if up {
+		n = vec3.T{0, 0, 1}
+	}
+	center := b.vertex(vec3.T{0, 0, float32(z)}, n, vec2.T{0.5, 0.5})
+	for i := 0; i < segments; i++ {
+		a0, a1 := 2*math.Pi*float64(i)/float64(segments), 2*math.Pi*float64(i+1)/float64(segments)
+		p0 := b.vertex(vec3.T{float32(radius * math.Cos(a0)), float32(radius * math.Sin(a0)), float32(z)}, n, vec2.T{float32(0.5 + 0.5*math.Cos(a0)), float32(0.5 + 0.5*math.Sin(a0))})
+		p1 := b.vertex(vec3.T{float32(radius * math.Cos(a1)), float32(radius * math.Sin(a1)), float32(z)}, n, vec2.T{float32(0.5 + 0.5*math.Cos(a1)), float32(0.5 + 0.5*math.Sin(a1))})
+		if up {
+			b.tri(center, p0, p1)
+		} else {
+			b.tri(center, p1, p0)
+		}
+	}
+}
+
+func vec(x, y, z float64) vec3.T {
+	return vec3.T{float32(x), float32(y), float32(z)}
+}
+
+func positive(name string, v float32) error {
+	if !(v > 0) || math.IsInf(float64(v), 0) {
+		return fmt.Errorf("primitives: %s must be positive, got %v", name, v)
+	}
+	return nil
+}
+
+func minSegments(name string, n, min int) error {
+	if n < min {
+		return fmt.Errorf("primitives: %s must be at least %d, got %d", name, min, n)
+	}
+	return nil
+}
+
+func Box(size vec3.T) (*mst.MeshNode, error) {
+	for i, name := range []string{"width", "depth", "height"} {
+		if err := positive(name, size[i]); err != nil {
+			return nil, err
+		}
+	}
+	faces := [6][3]vec3.T{
+		{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+		{{-1, 0, 0}, {0, -1, 0}, {0, 0, 1}},
+		{{0, 1, 0}, {-1, 0, 0}, {0, 0, 1}},
+		{{0, -1, 0}, {1, 0, 0}, {0, 0, 1}},
+		{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}},
+		{{0, 0, -1}, {1, 0, 0}, {0, -1, 0}},
+	}
+	b := newBuilder()
+	for _, f := range faces {
+		n, ua, va := f[0], f[1], f[2]
+		b.grid(1, 1, func(u, v float64) (vec3.T, vec3.T) {
+			var p vec3.T
+			for i := range p {
+				p[i] = size[i] / 2 * (n[i] + float32(2*u-1)*ua[i] + float32(2*v-1)*va[i])
+			}
+			return p, n
+		})
+	}
+	return b.nd, nil
+}
+
+func Plane(width, depth float32, segX, segY int) (*mst.MeshNode, error) {
+	if err := positive("width", width); err != nil {
+		return nil, err
+	}
+	if err := positive("depth", depth); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segX, 1); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segY, 1); err != nil {
+		return nil, err
+	}
+	b := newBuilder()
+	b.grid(segX, segY, func(u, v float64) (vec3.T, vec3.T) {
+		return vec(float64(width)*(u-0.5), float64(depth)*(v-0.5), 0), vec3.T{0, 0, 1}
+	})
+	return b.nd, nil
+}
+
+func Sphere(radius float32, segments, rings int) (*mst.MeshNode, error) {
+	if err := positive("radius", radius); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segments, 3); err != nil {
+		return nil, err
+	}
+	if err := minSegments("rings", rings, 2); err != nil {
+		return nil, err
+	}
+	r := float64(radius)
+	b := newBuilder()
+	b.grid(segments, rings, func(u, v float64) (vec3.T, vec3.T) {
+		phi, theta := 2*math.Pi*u, math.Pi*v
+		n := vec(math.Sin(theta)*math.Cos(phi), math.Sin(theta)*math.Sin(phi), -math.Cos(theta))
+		if v == 0 || v == 1 {
+			n = vec(0, 0, 2*v-1)
+		}
+		return vec(r*float64(n[0]), r*float64(n[1]), r*float64(n[2])), n
+	})
+	return b.nd, nil
+}
+
+func Cylinder(radius, height float32, segments int) (*mst.MeshNode, error) {
+	if err := positive("radius", radius); err != nil {
+		return nil, err
+	}
+	if err := positive("height", height); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segments, 3); err != nil {
+		return nil, err
+	}
+	r, h := float64(radius), float64(height)
+	b := newBuilder()
+	b.grid(segments, 1, func(u, v float64) (vec3.T, vec3.T) {
+		phi := 2 * math.Pi * u
+		return vec(r*math.Cos(phi), r*math.Sin(phi), h*v), vec(math.Cos(phi), math.Sin(phi), 0)
+	})
+	b.disk(r, 0, false, segments)
+	b.disk(r, h, true, segments)
+	return b.nd, nil
+}
+
+func Cone(radius, height float32, segments int) (*mst.MeshNode, error) {
+	if err := positive("radius", radius); err != nil {
+		return nil, err
+	}
+	if err := positive("height", height); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segments, 3); err != nil {
+		return nil, err
+	}
+	r, h := float64(radius), float64(height)
+	l := math.Hypot(r, h)
+	b := newBuilder()
+	b.grid(segments, 1, func(u, v float64) (vec3.T, vec3.T) {
+		phi := 2 * math.Pi * u
+		return vec((1-v)*r*math.Cos(phi), (1-v)*r*math.Sin(phi), h*v), vec(h/l*math.Cos(phi), h/l*math.Sin(phi), r/l)
+	})
+	b.disk(r, 0, false, segments)
+	return b.nd, nil
+}
+
+func Torus(radius, tube float32, segments, tubeSegments int) (*mst.MeshNode, error) {
+	if err := positive("radius", radius); err != nil {
+		return nil, err
+	}
+	if err := positive("tube radius", tube); err != nil {
+		return nil, err
+	}
+	if err := minSegments("segments", segments, 3); err != nil {
+		return nil, err
+	}
+	if err := minSegments("tube segments", tubeSegments, 3); err != nil {
+		return nil, err
+	}
+	R, r := float64(radius), float64(tube)
+	b := newBuilder()
+	b.grid(segments, tubeSegments, func(u, v float64) (vec3.T, vec3.T) {
+		phi, theta := 2*math.Pi*u, 2*math.Pi*v
+		n := vec(math.Cos(theta)*math.Cos(phi), math.Cos(theta)*math.Sin(phi), math.Sin(theta))
+		return vec((R+r*math.Cos(theta))*math.Cos(phi), (R+r*math.Cos(theta))*math.Sin(phi), r*math.Sin(theta)), n
+	})
+	return b.nd, nil
+}
+
+func polygon(path []vec2.T) ([]vec2.T, error) {
+	pts, err := ring(points(path), true)
+	if err != nil {
+		return nil, err
+	}
+	poly := make([]vec2.T, len(pts))
+	for i, p := range pts {
+		poly[i] = vec2.T{float32(p[0]), float32(p[1])}
+	}
+	return poly, nil
+}
+
+func ExtrudePolygon(path []vec2.T, height float32) (*mst.MeshNode, error) {
+	if err := positive("height", height); err != nil {
+		return nil, err
+	}
+	poly, err := polygon(path)
+	if err != nil {
+		return nil, err
+	}
+	tris, err := Triangulate(poly)
+	if err != nil {
+		return nil, err
+	}
+	b := newBuilder()
+	for _, top := range []bool{false, true} {
+		z, n := float32(0), vec3.T{0, 0, -1}
+		if top {
+			z, n = height, vec3.T{0, 0, 1}
+		}
+		base := uint32(len(b.nd.Vertices))
+		for _, p := range poly {
+			b.vertex(vec3.T{p[0], p[1], z}, n, p)
+		}
+		for _, t := range tris {
+			if top {
+				b.tri(base+t[0], base+t[1], base+t[2])
+			} else {
+				b.tri(base+t[0], base+t[2], base+t[1])
+			}
+		}
+	}
+	var dist float32
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		d := vec2.Sub(&q, &p)
+		l := d.Length()
+		if l == 0 {
+			continue
+		}
+		n := vec3.T{d[1] / l, -d[0] / l, 0}
+		a := b.vertex(vec3.T{p[0], p[1], 0}, n, vec2.T{dist, 0})
+		c := b.vertex(vec3.T{q[0], q[1], 0}, n, vec2.T{dist + l, 0})
+		e := b.vertex(vec3.T{q[0], q[1], height}, n, vec2.T{dist + l, height})
+		f := b.vertex(vec3.T{p[0], p[1], height}, n, vec2.T{dist, height})
+		b.tri(a, c, e)
+		b.tri(a, e, f)
+		dist += l
+	}
+	return b.nd, nil
+}
+
+func unit(v vec3.T) vec3.T {
+	l := v.Length()
+	if l == 0 {
+		return v
+	}
+	return v.Scaled(1 / l)
+}
+
+func SweepProfile(profile []vec2.T, path []vec3.T) (*mst.MeshNode, error) {
+	prof, err := polygon(profile)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) < 2 {
+		return nil, fmt.Errorf("primitives: sweep path needs at least 2 points, got %d", len(path))
+	}
+	dirs := make([]vec3.T, len(path)-1)
+	for i := range dirs {
+		d := vec3.Sub(&path[i+1], &path[i])
+		if d.Length() == 0 {
+			return nil, fmt.Errorf("primitives: sweep path segment %d has zero length", i)
+		}
+		dirs[i] = unit(d)
+	}
+	tangents := make([]vec3.T, len(path))
+	for i := range path {
+		switch {
+		case i == 0:
+			tangents[i] = dirs[0]
+		case i == len(dirs):
+			tangents[i] = dirs[i-1]
+		default:
+			t := vec3.Add(&dirs[i-1], &dirs[i])
+			if t.Length() == 0 {
+				return nil, fmt.Errorf("primitives: sweep path reverses at point %d", i)
+			}
+			tangents[i] = unit(t)
+		}
+	}
+
+	axis := vec3.T{1, 0, 0}
+	if t := tangents[0]; math.Abs(float64(t[1])) < math.Abs(float64(t[0])) && math.Abs(float64(t[1])) <= math.Abs(float64(t[2])) {
+		axis = vec3.T{0, 1, 0}
+	} else if math.Abs(float64(t[2])) < math.Abs(float64(t[0])) {
+		axis = vec3.T{0, 0, 1}
+	}
+	normal := vec3.Cross(&tangents[0], &axis)
+	normal = unit(normal)
+
+	outward := make([]vec2.T, len(prof))
+	perimeter := make([]float32, len(prof)+1)
+	for i, p := range prof {
+		q := prof[(i+1)%len(prof)]
+		o := prof[(i+len(prof)-1)%len(prof)]
+		e1, e0 := vec2.Sub(&q, &p), vec2.Sub(&p, &o)
+		n := vec2.T{e0[1] + e1[1], -e0[0] - e1[0]}
+		if l := n.Length(); l > 0 {
+			n = n.Scaled(1 / l)
+		}
+		outward[i] = n
+		perimeter[i+1] = perimeter[i] + e1.Length()
+	}
+
+	b := newBuilder()
+	cols := uint32(len(prof) + 1)
+	var along float32
+	for i, p := range path {
+		t := tangents[i]
+		proj := t.Scaled(vec3.Dot(&normal, &t))
+		normal = vec3.Sub(&normal, &proj)
+		normal = unit(normal)
+		binormal := vec3.Cross(&t, &normal)
+		if i > 0 {
+			d := vec3.Sub(&p, &path[i-1])
+			along += d.Length()
+		}
+		for j := 0; j <= len(prof); j++ {
+			k := j % len(prof)
+			x, y := normal.Scaled(prof[k][0]), binormal.Scaled(prof[k][1])
+			nx, ny := normal.Scaled(outward[k][0]), binormal.Scaled(outward[k][1])
+			pos := vec3.Add(&p, &x)
+			pos = vec3.Add(&pos, &y)
+			b.vertex(pos, unit(vec3.Add(&nx, &ny)), vec2.T{perimeter[j] / perimeter[len(prof)], along})
+		}
+		if i == 0 {
+			continue
+		}
+		base := uint32(i-1) * cols
+		for j := uint32(0); j < uint32(len(prof)); j++ {
+			i00 := base + j
+			i10, i01 := i00+1, i00+cols
+			i11 := i01 + 1
+			b.tri(i00, i10, i11)
+			b.tri(i00, i11, i01)
+		}
+	}
+	return b.nd, nil
+}
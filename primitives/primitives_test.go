@@ -0,0 +1,190 @@
+package primitives
+
+import (
+	"math"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+func volume(t *testing.T, nd *mst.MeshNode) float64 {
+	if len(nd.Normals) != len(nd.Vertices) || len(nd.TexCoords) != len(nd.Vertices) {
+		t.Fatalf("attributes not parallel to %d vertices", len(nd.Vertices))
+	}
+	var v float64
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			a, b, c := nd.Vertices[f.Vertex[0]], nd.Vertices[f.Vertex[1]], nd.Vertices[f.Vertex[2]]
+			e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+			fn := vec3.Cross(&e1, &e2)
+			for _, i := range f.Vertex {
+				if vec3.Dot(&fn, &nd.Normals[i]) <= 0 {
+					t.Fatalf("face %v winds against its vertex normals", f.Vertex)
+				}
+			}
+			bc := vec3.Cross(&b, &c)
+			v += float64(vec3.Dot(&a, &bc)) / 6
+		}
+	}
+	return v
+}
+
+func TestPrimitives(t *testing.T) {
+	lshape := []vec2.T{{0, 0}, {0, 2}, {1, 2}, {1, 1}, {2, 1}, {2, 0}}
+	cases := []struct {
+		name   string
+		build  func() (*mst.MeshNode, error)
+		volume float64
+		tol    float64
+	}{
+		{"box", func() (*mst.MeshNode, error) { return Box(vec3.T{1, 2, 3}) }, 6, 1e-5},
+		{"sphere", func() (*mst.MeshNode, error) { return Sphere(2, 64, 32) }, 4.0 / 3 * math.Pi * 8, 0.01},
+		{"cylinder", func() (*mst.MeshNode, error) { return Cylinder(1, 2, 64) }, 2 * math.Pi, 0.01},
+		{"cone", func() (*mst.MeshNode, error) { return Cone(1, 3, 64) }, math.Pi, 0.01},
+		{"torus", func() (*mst.MeshNode, error) { return Torus(2, 0.5, 64, 32) }, 2 * math.Pi * math.Pi * 2 * 0.25, 0.01},
+		{"extrude", func() (*mst.MeshNode, error) { return ExtrudePolygon(lshape, 2) }, 6, 1e-5},
+	}
+	for _, c := range cases {
+		nd, err := c.build()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if v := volume(t, nd); math.Abs(v-c.volume) > c.tol*c.volume {
+			t.Fatalf("%s: volume %v, want %v", c.name, v, c.volume)
+		}
+	}
+
+	nd, err := ExtrudePolygon(append([]vec2.T{lshape[5], lshape[4], lshape[3]}, lshape[2], lshape[1], lshape[0]), 1)
+	if err != nil || len(nd.FaceGroup[0].Faces) != 2*4+2*6 {
+		t.Fatalf("clockwise extrusion: %v", err)
+	}
+	plane, err := Plane(4, 2, 4, 2)
+	if err != nil || len(plane.Vertices) != 15 || len(plane.FaceGroup[0].Faces) != 16 || plane.Vertices[14] != (vec3.T{2, 1, 0}) {
+		t.Fatalf("unexpected plane: %v", err)
+	}
+	pipe, err := SweepProfile([]vec2.T{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}, []vec3.T{{0, 0, 0}, {5, 0, 0}, {5, 5, 0}})
+	if err != nil || len(pipe.Vertices) != 3*5 || len(pipe.FaceGroup[0].Faces) != 2*4*2 {
+		t.Fatalf("unexpected sweep: %v", err)
+	}
+	volume(t, pipe)
+	if bx := pipe.GetBoundbox(); bx[0] != 0 || bx[3] != 6 || bx[4] != 5 || bx[5] != 1 {
+		t.Fatalf("unexpected sweep bounds %v", bx)
+	}
+
+	if _, err := Box(vec3.T{1, 0, 1}); err == nil {
+		t.Fatal("expected error for a flat box")
+	}
+	if _, err := Sphere(1, 2, 2); err == nil {
+		t.Fatal("expected error for too few segments")
+	}
+	if _, err := ExtrudePolygon([]vec2.T{{0, 0}, {1, 1}, {2, 2}}, 1); err != ErrDegeneratePolygon {
+		t.Fatalf("expected ErrDegeneratePolygon, got %v", err)
+	}
+	if _, err := SweepProfile(lshape, []vec3.T{{0, 0, 0}, {0, 0, 0}}); err == nil {
+		t.Fatal("expected error for a zero length path")
+	}
+}
+
+func TestExtrudeFootprint(t *testing.T) {
+	outer := [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	holes := [][][2]float64{
+		{{2, 2}, {2, 4}, {4, 4}, {4, 2}},
+		{{6, 6}, {8, 6}, {8, 8}, {6, 8}},
+	}
+	nd, err := ExtrudeFootprint(outer, holes, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := volume(t, nd); math.Abs(v-(100-8)*3) > 1e-3 {
+		t.Fatalf("volume %v, want %v", v, (100-8)*3)
+	}
+	if len(nd.FaceGroup) != 3 || nd.FaceGroup[0].Batchid != 0 || nd.FaceGroup[1].Batchid != 2 || nd.FaceGroup[2].Batchid != 1 {
+		t.Fatalf("unexpected face groups %+v", nd.FaceGroup)
+	}
+	if walls := len(nd.FaceGroup[2].Faces); walls != 2*12 {
+		t.Fatalf("expected 24 wall faces, got %d", walls)
+	}
+	if bx := nd.GetBoundbox(); bx[2] != 0 || bx[5] != 3 {
+		t.Fatalf("unexpected bounds %v", bx)
+	}
+
+	nd, err = ExtrudeFootprint(outer, nil, 2, &FootprintOptions{Base: 5, NoFloor: true, WallBatchid: 3, RoofBatchid: 4})
+	if err != nil || len(nd.FaceGroup) != 2 || nd.FaceGroup[0].Batchid != 4 || nd.GetBoundbox()[2] != 5 {
+		t.Fatalf("unexpected footprint without floor: %v", err)
+	}
+	if _, err := ExtrudeFootprint(outer, [][][2]float64{{{20, 20}, {21, 20}, {21, 21}}}, 1, nil); err == nil {
+		t.Fatal("expected error for a hole outside the footprint")
+	}
+	if _, err := ExtrudeFootprint(outer, nil, 0, nil); err == nil {
+		t.Fatal("expected error for zero height")
+	}
+}
+
+func TestHeightGridToMesh(t *testing.T) {
+	grid := func(n int, fn func(x, y int) float32) [][]float32 {
+		hs := make([][]float32, n)
+		for y := range hs {
+			hs[y] = make([]float32, n)
+			for x := range hs[y] {
+				hs[y][x] = fn(x, y)
+			}
+		}
+		return hs
+	}
+	upward := func(nd *mst.MeshNode, faces int) {
+		for _, f := range nd.FaceGroup[0].Faces[:faces] {
+			a, b, c := nd.Vertices[f.Vertex[0]], nd.Vertices[f.Vertex[1]], nd.Vertices[f.Vertex[2]]
+			e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+			if n := vec3.Cross(&e1, &e2); n[2] <= 0 || nd.Normals[f.Vertex[0]][2] <= 0 {
+				t.Fatalf("terrain face %v does not face up", f.Vertex)
+			}
+		}
+	}
+
+	ramp := grid(17, func(x, y int) float32 { return float32(x) * 0.5 })
+	full, err := HeightGridToMesh(ramp, 2, nil)
+	if err != nil || len(full.Vertices) != 17*17 || len(full.FaceGroup[0].Faces) != 2*16*16 {
+		t.Fatalf("unexpected full resolution terrain: %v", err)
+	}
+	upward(full, len(full.FaceGroup[0].Faces))
+	if bx := full.GetBoundbox(); bx[3] != 32 || bx[4] != 32 || bx[5] != 8 {
+		t.Fatalf("unexpected terrain bounds %v", bx)
+	}
+	flat, err := HeightGridToMesh(ramp, 2, &TerrainOptions{MaxError: 0.01})
+	if err != nil || len(flat.FaceGroup[0].Faces) != 2 || len(flat.Vertices) != 4 {
+		t.Fatalf("planar terrain should collapse to two triangles: %v", err)
+	}
+	upward(flat, 2)
+
+	bumpy := grid(33, func(x, y int) float32 { return float32(math.Sin(float64(x)/3) * math.Cos(float64(y)/5) * 10) })
+	coarse, err := HeightGridToMesh(bumpy, 1, &TerrainOptions{MaxError: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fine, err := HeightGridToMesh(bumpy, 1, &TerrainOptions{MaxError: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, nf := len(coarse.FaceGroup[0].Faces), len(fine.FaceGroup[0].Faces)
+	if nc >= nf || nf >= 2*32*32 {
+		t.Fatalf("expected error-bounded decimation, got %d and %d faces", nc, nf)
+	}
+	upward(fine, nf)
+
+	skirted, err := HeightGridToMesh(bumpy, 1, &TerrainOptions{MaxError: 1, SkirtDepth: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skirted.FaceGroup[0].Faces) <= nc || skirted.GetBoundbox()[2] >= coarse.GetBoundbox()[2] {
+		t.Fatal("skirt not generated")
+	}
+
+	if _, err := HeightGridToMesh(grid(10, func(x, y int) float32 { return 0 }), 1, &TerrainOptions{MaxError: 1}); err != ErrTerrainGridSize {
+		t.Fatalf("expected ErrTerrainGridSize, got %v", err)
+	}
+	if _, err := HeightGridToMesh([][]float32{{0, 1}, {0}}, 1, nil); err == nil {
+		t.Fatal("expected error for a ragged grid")
+	}
+}
@@ -0,0 +1,206 @@
+package primitives
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrTerrainGridSize = errors.New("primitives: decimated terrain grids must be square with 2^n+1 samples per side")
+
+type TerrainOptions struct {
+	MaxError   float64
+	SkirtDepth float64
+}
+
+var DefaultTerrainOptions = TerrainOptions{}
+
+type heightGrid struct {
+	heights    [][]float32
+	rows, cols int
+	cellSize   float64
+}
+
+func (g *heightGrid) at(x, y int) float64 {
+	return float64(g.heights[y][x])
+}
+
+func (g *heightGrid) normal(x, y int) vec3.T {
+	x0, x1, y0, y1 := x-1, x+1, y-1, y+1
+	if x0 < 0 {
+		x0 = 0
+	}
+	if x1 >= g.cols {
+		x1 = g.cols - 1
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	if y1 >= g.rows {
+		y1 = g.rows - 1
+	}
+	dx := (g.at(x1, y) - g.at(x0, y)) / (float64(x1-x0) * g.cellSize)
+	dy := (g.at(x, y1) - g.at(x, y0)) / (float64(y1-y0) * g.cellSize)
+	l := math.Sqrt(dx*dx + dy*dy + 1)
+	return vec(-dx/l, -dy/l, 1/l)
+}
+
+type terrainBuilder struct {
+	*builder
+	grid  *heightGrid
+	index map[[2]int]uint32
+	edges map[[2]uint32]bool
+}
+
+func (t *terrainBuilder) point(x, y int) uint32 {
+	if i, ok := t.index[[2]int{x, y}]; ok {
+		return i
+	}
+	g := t.grid
+	i := t.vertex(vec(float64(x)*g.cellSize, float64(y)*g.cellSize, g.at(x, y)), g.normal(x, y), vec2.T{float32(x) / float32(g.cols-1), float32(y) / float32(g.rows-1)})
+	t.index[[2]int{x, y}] = i
+	return i
+}
+
+func (t *terrainBuilder) face(a, b, c [2]int) {
+	pts := [3][2]int{a, b, c}
+	idx := [3]uint32{t.point(a[0], a[1]), t.point(b[0], b[1]), t.point(c[0], c[1])}
+	t.tri(idx[0], idx[1], idx[2])
+	for k := 0; k < 3; k++ {
+		p, q := pts[k], pts[(k+1)%3]
+		if p[0] == q[0] && (p[0] == 0 || p[0] == t.grid.cols-1) || p[1] == q[1] && (p[1] == 0 || p[1] == t.grid.rows-1) {
+			t.edges[[2]uint32{idx[k], idx[(k+1)%3]}] = true
+		}
+	}
+}
+
+func (t *terrainBuilder) skirt(depth float64) {
+	bottom := make(map[uint32]uint32)
+	lower := func(i uint32) uint32 {
+		if j, ok := bottom[i]; ok {
+			return j
+		}
+		p := t.nd.Vertices[i]
+		p[2] -= float32(depth)
+		j := t.vertex(p, t.nd.Normals[i], t.nd.TexCoords[i])
+		bottom[i] = j
+		return j
+	}
+	for _, g := range append([]*mst.MeshTriangle(nil), t.nd.FaceGroup...) {
+		for _, f := range g.Faces {
+			for k := 0; k < 3; k++ {
+				a, b := f.Vertex[k], f.Vertex[(k+1)%3]
+				if !t.edges[[2]uint32{a, b}] {
+					continue
+				}
+				la, lb := lower(a), lower(b)
+				t.tri(la, lb, b)
+				t.tri(la, b, a)
+			}
+		}
+	}
+}
+
+func (t *terrainBuilder) rtin(maxError float64) {
+	g := t.grid
+	size := g.cols
+	tile := size - 1
+	errs := make([]float64, size*size)
+	numTriangles := tile*tile*2 - 2
+	numParents := numTriangles - tile*tile
+	for i := numTriangles - 1; i >= 0; i-- {
+		id := i + 2
+		var ax, ay, bx, by, cx, cy int
+		if id&1 != 0 {
+			bx, by, cx = tile, tile, tile
+		} else {
+			ax, ay, cy = tile, tile, tile
+		}
+		for id >>= 1; id > 1; id >>= 1 {
+			mx, my := (ax+bx)>>1, (ay+by)>>1
+			if id&1 != 0 {
+				bx, by, ax, ay = ax, ay, cx, cy
+			} else {
+				ax, ay, bx, by = bx, by, cx, cy
+			}
+			cx, cy = mx, my
+		}
+		mx, my := (ax+bx)>>1, (ay+by)>>1
+		cx, cy = mx+my-ay, my+ax-mx
+		mid := my*size + mx
+		errs[mid] = math.Max(errs[mid], math.Abs((g.at(ax, ay)+g.at(bx, by))/2-g.at(mx, my)))
+		if i < numParents {
+			left := ((ay+cy)>>1)*size + ((ax + cx) >> 1)
+			right := ((by+cy)>>1)*size + ((bx + cx) >> 1)
+			errs[mid] = math.Max(errs[mid], math.Max(errs[left], errs[right]))
+		}
+	}
+	var split func(a, b, c [2]int)
+	split = func(a, b, c [2]int) {
+		m := [2]int{(a[0] + b[0]) >> 1, (a[1] + b[1]) >> 1}
+		if abs(a[0]-c[0])+abs(a[1]-c[1]) > 1 && errs[m[1]*size+m[0]] > maxError {
+			split(c, a, m)
+			split(b, c, m)
+			return
+		}
+		t.face(a, c, b)
+	}
+	split([2]int{0, 0}, [2]int{tile, tile}, [2]int{tile, 0})
+	split([2]int{tile, tile}, [2]int{0, 0}, [2]int{0, tile})
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func HeightGridToMesh(heights [][]float32, cellSize float64, opts *TerrainOptions) (*mst.MeshNode, error) {
+	if opts == nil {
+		opts = &DefaultTerrainOptions
+	}
+	if !(cellSize > 0) || math.IsInf(cellSize, 0) {
+		return nil, fmt.Errorf("primitives: cell size must be positive, got %v", cellSize)
+	}
+	if opts.MaxError < 0 || opts.SkirtDepth < 0 {
+		return nil, errors.New("primitives: terrain error and skirt depth must not be negative")
+	}
+	g := &heightGrid{heights: heights, rows: len(heights), cellSize: cellSize}
+	if g.rows < 2 || len(heights[0]) < 2 {
+		return nil, errors.New("primitives: height grid needs at least 2x2 samples")
+	}
+	g.cols = len(heights[0])
+	for i, row := range heights {
+		if len(row) != g.cols {
+			return nil, fmt.Errorf("primitives: height grid row %d has %d samples, want %d", i, len(row), g.cols)
+		}
+		for _, h := range row {
+			if math.IsNaN(float64(h)) || math.IsInf(float64(h), 0) {
+				return nil, fmt.Errorf("primitives: height grid row %d has a non-finite sample", i)
+			}
+		}
+	}
+	t := &terrainBuilder{builder: newBuilder(), grid: g, index: make(map[[2]int]uint32), edges: make(map[[2]uint32]bool)}
+	if opts.MaxError > 0 {
+		if g.rows != g.cols || (g.cols-1)&(g.cols-2) != 0 {
+			return nil, ErrTerrainGridSize
+		}
+		t.rtin(opts.MaxError)
+	} else {
+		for y := 0; y+1 < g.rows; y++ {
+			for x := 0; x+1 < g.cols; x++ {
+				t.face([2]int{x, y}, [2]int{x + 1, y}, [2]int{x + 1, y + 1})
+				t.face([2]int{x, y}, [2]int{x + 1, y + 1}, [2]int{x, y + 1})
+			}
+		}
+	}
+	if opts.SkirtDepth > 0 {
+		t.skirt(opts.SkirtDepth)
+	}
+	return t.nd, nil
+}
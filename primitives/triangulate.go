@@ -0,0 +1,191 @@
+package primitives
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+func points(vs []vec2.T) [][2]float64 {
+	out := make([][2]float64, len(vs))
+	for i, v := range vs {
+		out[i] = [2]float64{float64(v[0]), float64(v[1])}
+	}
+	return out
+}
+
+func signedArea(poly [][2]float64) float64 {
+	var a float64
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+func cross2(o, a, b [2]float64) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+func inTriangle(p, a, b, c [2]float64) bool {
+	return cross2(a, b, p) >= 0 && cross2(b, c, p) >= 0 && cross2(c, a, p) >= 0
+}
+
+func ring(path [][2]float64, ccw bool) ([][2]float64, error) {
+	poly := append([][2]float64(nil), path...)
+	if len(poly) > 1 && poly[0] == poly[len(poly)-1] {
+		poly = poly[:len(poly)-1]
+	}
+	if len(poly) < 3 {
+		return nil, fmt.Errorf("primitives: polygon needs at least 3 points, got %d", len(poly))
+	}
+	area := signedArea(poly)
+	if area == 0 || math.IsNaN(area) || math.IsInf(area, 0) {
+		return nil, ErrDegeneratePolygon
+	}
+	if (area > 0) != ccw {
+		for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+			poly[i], poly[j] = poly[j], poly[i]
+		}
+	}
+	return poly, nil
+}
+
+func earcut(poly [][2]float64) ([][3]uint32, error) {
+	if len(poly) < 3 {
+		return nil, ErrDegeneratePolygon
+	}
+	ccw := signedArea(poly) > 0
+	idx := make([]uint32, len(poly))
+	for i := range idx {
+		idx[i] = uint32(i)
+		if !ccw {
+			idx[i] = uint32(len(poly) - 1 - i)
+		}
+	}
+	var out [][3]uint32
+	for len(idx) > 3 {
+		ear := -1
+		for i := range idx {
+			a, b, c := idx[(i+len(idx)-1)%len(idx)], idx[i], idx[(i+1)%len(idx)]
+			if cross2(poly[a], poly[b], poly[c]) <= 0 {
+				continue
+			}
+			inside := false
+			for _, o := range idx {
+				if o != a && o != b && o != c && poly[o] != poly[a] && poly[o] != poly[b] && poly[o] != poly[c] && inTriangle(poly[o], poly[a], poly[b], poly[c]) {
+					inside = true
+					break
+				}
+			}
+			if !inside {
+				ear = i
+				break
+			}
+		}
+		if ear < 0 {
+			for i := range idx {
+				a, b, c := idx[(i+len(idx)-1)%len(idx)], idx[i], idx[(i+1)%len(idx)]
+				if cross2(poly[a], poly[b], poly[c]) == 0 {
+					ear = i
+					break
+				}
+			}
+			if ear < 0 {
+				return nil, ErrDegeneratePolygon
+			}
+			idx = append(idx[:ear], idx[ear+1:]...)
+			continue
+		}
+		out = append(out, [3]uint32{idx[(ear+len(idx)-1)%len(idx)], idx[ear], idx[(ear+1)%len(idx)]})
+		idx = append(idx[:ear], idx[ear+1:]...)
+	}
+	if cross2(poly[idx[0]], poly[idx[1]], poly[idx[2]]) != 0 {
+		out = append(out, [3]uint32{idx[0], idx[1], idx[2]})
+	}
+	return out, nil
+}
+
+func Triangulate(poly []vec2.T) ([][3]uint32, error) {
+	return earcut(points(poly))
+}
+
+func bridgeHole(outer, hole [][2]float64) ([][2]float64, error) {
+	m := 0
+	for i, p := range hole {
+		if p[0] > hole[m][0] {
+			m = i
+		}
+	}
+	mp := hole[m]
+	best, bestX := -1, math.Inf(1)
+	for i, a := range outer {
+		b := outer[(i+1)%len(outer)]
+		if (a[1] > mp[1]) == (b[1] > mp[1]) {
+			continue
+		}
+		x := a[0] + (mp[1]-a[1])*(b[0]-a[0])/(b[1]-a[1])
+		if x < mp[0] || x >= bestX {
+			continue
+		}
+		bestX = x
+		best = i
+		if b[0] > a[0] {
+			best = (i + 1) % len(outer)
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("primitives: hole is not inside the outer ring")
+	}
+	hit := [2]float64{bestX, mp[1]}
+	cand := outer[best]
+	angle := math.Inf(1)
+	for i, p := range outer {
+		if p == cand || p == mp || !inTriangle(p, mp, hit, cand) && !inTriangle(p, mp, cand, hit) {
+			continue
+		}
+		prev, next := outer[(i+len(outer)-1)%len(outer)], outer[(i+1)%len(outer)]
+		if cross2(prev, p, next) > 0 {
+			continue
+		}
+		if a := math.Abs(math.Atan2(p[1]-mp[1], p[0]-mp[0])); a < angle {
+			angle = a
+			best = i
+		}
+	}
+	out := make([][2]float64, 0, len(outer)+len(hole)+2)
+	out = append(out, outer[:best+1]...)
+	for i := 0; i <= len(hole); i++ {
+		out = append(out, hole[(m+i)%len(hole)])
+	}
+	out = append(out, outer[best])
+	return append(out, outer[best+1:]...), nil
+}
+
+func triangulateFootprint(outer [][2]float64, holes [][][2]float64) ([][2]float64, [][3]uint32, error) {
+	poly := outer
+	sort.SliceStable(holes, func(i, j int) bool {
+		return maxX(holes[i]) > maxX(holes[j])
+	})
+	for _, h := range holes {
+		var err error
+		if poly, err = bridgeHole(poly, h); err != nil {
+			return nil, nil, err
+		}
+	}
+	tris, err := earcut(poly)
+	if err != nil {
+		return nil, nil, err
+	}
+	return poly, tris, nil
+}
+
+func maxX(poly [][2]float64) float64 {
+	x := math.Inf(-1)
+	for _, p := range poly {
+		x = math.Max(x, p[0])
+	}
+	return x
+}
@@ -0,0 +1,42 @@
+package mst
+
+import "context"
+
+const (
+	PROGRESS_STAGE_MATERIALS  = "materials"
+	PROGRESS_STAGE_NODES      = "nodes"
+	PROGRESS_STAGE_INSTANCES  = "instances"
+	PROGRESS_STAGE_ANIMATIONS = "animations"
+	PROGRESS_STAGE_GLTF       = "gltf"
+)
+
+type ProgressFunc func(stage string, done, total int)
+
+type progress struct {
+	ctx context.Context
+	fn  ProgressFunc
+}
+
+func newProgress(ctx context.Context, fn ProgressFunc) *progress {
+	if ctx == nil && fn == nil {
+		return nil
+	}
+	return &progress{ctx: ctx, fn: fn}
+}
+
+func (p *progress) err() error {
+	if p == nil || p.ctx == nil {
+		return nil
+	}
+	return p.ctx.Err()
+}
+
+func (p *progress) report(stage string, done, total int) error {
+	if err := p.err(); err != nil {
+		return err
+	}
+	if p != nil && p.fn != nil {
+		p.fn(stage, done, total)
+	}
+	return nil
+}
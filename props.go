@@ -0,0 +1,354 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	PROP_TYPE_NULL   = 0
+	PROP_TYPE_STRING = 1
+	PROP_TYPE_INT    = 2
+	PROP_TYPE_FLOAT  = 3
+	PROP_TYPE_BOOL   = 4
+	PROP_TYPE_ARRAY  = 5
+	PROP_TYPE_MAP    = 6
+)
+
+const maxPropsDepth = 64
+
+var ErrPropsTooDeep = errors.New("mst: properties nested too deep")
+
+type Properties map[string]interface{}
+
+func propValueMarshal(wt io.Writer, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		writeLittleByte(wt, uint8(PROP_TYPE_NULL))
+	case string:
+		writeLittleByte(wt, uint8(PROP_TYPE_STRING))
+		writeLittleByte(wt, uint32(len(val)))
+		wt.Write([]byte(val))
+	case int:
+		writeLittleByte(wt, uint8(PROP_TYPE_INT))
+		writeLittleByte(wt, int64(val))
+	case int32:
+		writeLittleByte(wt, uint8(PROP_TYPE_INT))
+		writeLittleByte(wt, int64(val))
+	case int64:
+		writeLittleByte(wt, uint8(PROP_TYPE_INT))
+		writeLittleByte(wt, val)
+	case uint32:
+		writeLittleByte(wt, uint8(PROP_TYPE_INT))
+		writeLittleByte(wt, int64(val))
+	case uint64:
+		writeLittleByte(wt, uint8(PROP_TYPE_INT))
+		writeLittleByte(wt, int64(val))
+	case float32:
+		writeLittleByte(wt, uint8(PROP_TYPE_FLOAT))
+		writeLittleByte(wt, float64(val))
+	case float64:
+		writeLittleByte(wt, uint8(PROP_TYPE_FLOAT))
+		writeLittleByte(wt, val)
+	case bool:
+		writeLittleByte(wt, uint8(PROP_TYPE_BOOL))
+		writeLittleByte(wt, val)
+	case []interface{}:
+		writeLittleByte(wt, uint8(PROP_TYPE_ARRAY))
+		writeLittleByte(wt, uint32(len(val)))
+		for _, e := range val {
+			propValueMarshal(wt, e)
+		}
+	case map[string]interface{}:
+		writeLittleByte(wt, uint8(PROP_TYPE_MAP))
+		PropertiesMarshal(wt, Properties(val))
+	case Properties:
+		writeLittleByte(wt, uint8(PROP_TYPE_MAP))
+		PropertiesMarshal(wt, val)
+	default:
+		writeLittleByte(wt, uint8(PROP_TYPE_STRING))
+		s := fmt.Sprint(val)
+		writeLittleByte(wt, uint32(len(s)))
+		wt.Write([]byte(s))
+	}
+}
+
+func PropertiesMarshal(wt io.Writer, props Properties) {
+	writeLittleByte(wt, uint32(len(props)))
+	for _, k := range sortedPropKeys(props) {
+		writeLittleByte(wt, uint32(len(k)))
+		wt.Write([]byte(k))
+		propValueMarshal(wt, props[k])
+	}
+}
+
+func PropertiesUnMarshal(rd io.Reader) (Properties, error) {
+	return PropertiesUnMarshalWithLimits(rd, nil)
+}
+
+func PropertiesUnMarshalWithLimits(rd io.Reader, limits *DecodeLimits) (Properties, error) {
+	d := newDecoder(rd, V5, limits)
+	props := d.props(0)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return props, nil
+}
+
+func (d *decoder) propValue(depth int) interface{} {
+	var ty uint8
+	if !d.read(&ty) {
+		return nil
+	}
+	switch ty {
+	case PROP_TYPE_NULL:
+		return nil
+	case PROP_TYPE_STRING:
+		return d.limitedString("property string length", func(l *DecodeLimits) uint32 { return l.MaxPropValueLength })
+	case PROP_TYPE_INT:
+		var v int64
+		d.read(&v)
+		return v
+	case PROP_TYPE_FLOAT:
+		var v float64
+		d.read(&v)
+		return v
+	case PROP_TYPE_BOOL:
+		var v bool
+		d.read(&v)
+		return v
+	case PROP_TYPE_ARRAY:
+		if depth >= maxPropsDepth {
+			d.fail(ErrPropsTooDeep)
+			return nil
+		}
+		n := d.count("property array length", func(l *DecodeLimits) uint32 { return l.MaxProps })
+		arr := make([]interface{}, 0, capHint(n))
+		for i := 0; i < n && d.err == nil; i++ {
+			arr = append(arr, d.propValue(depth+1))
+		}
+		return arr
+	case PROP_TYPE_MAP:
+		if depth >= maxPropsDepth {
+			d.fail(ErrPropsTooDeep)
+			return nil
+		}
+		return map[string]interface{}(d.props(depth + 1))
+	default:
+		d.fail(fmt.Errorf("mst: unknown property type %d", ty))
+		return nil
+	}
+}
+
+func (d *decoder) props(depth int) Properties {
+	n := d.count("property count", func(l *DecodeLimits) uint32 { return l.MaxProps })
+	if n == 0 {
+		return nil
+	}
+	props := make(Properties, capHint(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.limitedString("property key length", func(l *DecodeLimits) uint32 { return l.MaxPropKeyLength })
+		props[k] = d.propValue(depth)
+	}
+	return props
+}
+
+func (p Properties) Get(key string) (interface{}, bool) {
+	v, ok := p[key]
+	return v, ok
+}
+
+func (p Properties) GetString(key string) (string, bool) {
+	v, ok := p[key].(string)
+	return v, ok
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	case uint32:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func (p Properties) GetInt(key string) (int64, bool) {
+	return toInt64(p[key])
+}
+
+func (p Properties) GetFloat(key string) (float64, bool) {
+	return toFloat64(p[key])
+}
+
+func (p Properties) GetBool(key string) (bool, bool) {
+	v, ok := p[key].(bool)
+	return v, ok
+}
+
+func (p Properties) GetArray(key string) ([]interface{}, bool) {
+	v, ok := p[key].([]interface{})
+	return v, ok
+}
+
+func asProperties(v interface{}) (Properties, bool) {
+	switch val := v.(type) {
+	case Properties:
+		return val, true
+	case map[string]interface{}:
+		return Properties(val), true
+	}
+	return nil, false
+}
+
+func (p Properties) GetMap(key string) (Properties, bool) {
+	return asProperties(p[key])
+}
+
+func (p Properties) SetString(key string, v string) {
+	p[key] = v
+}
+
+func (p Properties) SetInt(key string, v int64) {
+	p[key] = v
+}
+
+func (p Properties) SetFloat(key string, v float64) {
+	p[key] = v
+}
+
+func (p Properties) SetBool(key string, v bool) {
+	p[key] = v
+}
+
+func (p Properties) SetArray(key string, v []interface{}) {
+	p[key] = v
+}
+
+func (p Properties) SetMap(key string, v Properties) {
+	p[key] = map[string]interface{}(v)
+}
+
+func (p Properties) Lookup(path string) (interface{}, bool) {
+	cur := p
+	for {
+		i := strings.IndexByte(path, '.')
+		if i < 0 {
+			v, ok := cur[path]
+			return v, ok
+		}
+		next, ok := asProperties(cur[path[:i]])
+		if !ok {
+			return nil, false
+		}
+		cur, path = next, path[i+1:]
+	}
+}
+
+func (p Properties) Merge(other Properties) Properties {
+	out := p
+	if out == nil {
+		out = make(Properties, len(other))
+	}
+	for k, v := range other {
+		src, srcOk := asProperties(v)
+		dst, dstOk := asProperties(out[k])
+		if srcOk && dstOk {
+			out[k] = map[string]interface{}(dst.Merge(src))
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func propValueEqual(a, b interface{}) bool {
+	if ia, ok := toInt64(a); ok {
+		ib, ok := toInt64(b)
+		return ok && ia == ib
+	}
+	if fa, ok := toFloat64(a); ok {
+		fb, ok := toFloat64(b)
+		return ok && fa == fb
+	}
+	if ma, ok := asProperties(a); ok {
+		mb, ok := asProperties(b)
+		return ok && ma.Equal(mb)
+	}
+	if aa, ok := a.([]interface{}); ok {
+		ab, ok := b.([]interface{})
+		if !ok || len(aa) != len(ab) {
+			return false
+		}
+		for i := range aa {
+			if !propValueEqual(aa[i], ab[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch a.(type) {
+	case nil, string, bool:
+		return a == b
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func (p Properties) Equal(other Properties) bool {
+	if len(p) != len(other) {
+		return false
+	}
+	for k, v := range p {
+		ov, ok := other[k]
+		if !ok || !propValueEqual(v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
+func clonePropValue(v interface{}) interface{} {
+	if m, ok := asProperties(v); ok {
+		return map[string]interface{}(m.Clone())
+	}
+	if arr, ok := v.([]interface{}); ok {
+		out := make([]interface{}, len(arr))
+		for i, e := range arr {
+			out[i] = clonePropValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+func (p Properties) Clone() Properties {
+	if p == nil {
+		return nil
+	}
+	out := make(Properties, len(p))
+	for k, v := range p {
+		out[k] = clonePropValue(v)
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+package mst
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const PROP_TYPE_ANY = 0xff
+
+type PropSchema struct {
+	Type     uint8
+	Required bool
+	Fields   *PropsSchema
+	Items    *PropSchema
+}
+
+type PropsSchema struct {
+	Fields map[string]*PropSchema
+}
+
+func NewPropsSchema() *PropsSchema {
+	return &PropsSchema{Fields: make(map[string]*PropSchema)}
+}
+
+func (s *PropsSchema) Require(key string, ty uint8) *PropsSchema {
+	s.Fields[key] = &PropSchema{Type: ty, Required: true}
+	return s
+}
+
+func (s *PropsSchema) Optional(key string, ty uint8) *PropsSchema {
+	s.Fields[key] = &PropSchema{Type: ty}
+	return s
+}
+
+func (s *PropsSchema) Field(key string, f *PropSchema) *PropsSchema {
+	s.Fields[key] = f
+	return s
+}
+
+type PropError struct {
+	Path     string
+	Expected uint8
+	Actual   uint8
+	Missing  bool
+}
+
+func (e *PropError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("mst: missing required property %q", e.Path)
+	}
+	return fmt.Sprintf("mst: property %q has type %s, expected %s", e.Path, propTypeName(e.Actual), propTypeName(e.Expected))
+}
+
+type PropsValidationError struct {
+	Errors []*PropError
+}
+
+func (e *PropsValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, pe := range e.Errors {
+		msgs[i] = pe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func propTypeName(ty uint8) string {
+	switch ty {
+	case PROP_TYPE_NULL:
+		return "null"
+	case PROP_TYPE_STRING:
+		return "string"
+	case PROP_TYPE_INT:
+		return "int"
+	case PROP_TYPE_FLOAT:
+		return "float"
+	case PROP_TYPE_BOOL:
+		return "bool"
+	case PROP_TYPE_ARRAY:
+		return "array"
+	case PROP_TYPE_MAP:
+		return "map"
+	case PROP_TYPE_ANY:
+		return "any"
+	}
+	return fmt.Sprintf("type(%d)", ty)
+}
+
+func propValueType(v interface{}) uint8 {
+	if v == nil {
+		return PROP_TYPE_NULL
+	}
+	if _, ok := toInt64(v); ok {
+		return PROP_TYPE_INT
+	}
+	if _, ok := toFloat64(v); ok {
+		return PROP_TYPE_FLOAT
+	}
+	if _, ok := asProperties(v); ok {
+		return PROP_TYPE_MAP
+	}
+	switch v.(type) {
+	case bool:
+		return PROP_TYPE_BOOL
+	case []interface{}:
+		return PROP_TYPE_ARRAY
+	}
+	return PROP_TYPE_STRING
+}
+
+func propTypeMatches(expected, actual uint8) bool {
+	return expected == PROP_TYPE_ANY || expected == actual || (expected == PROP_TYPE_FLOAT && actual == PROP_TYPE_INT)
+}
+
+func (f *PropSchema) validate(path string, v interface{}, errs []*PropError) []*PropError {
+	ty := propValueType(v)
+	if !propTypeMatches(f.Type, ty) {
+		return append(errs, &PropError{Path: path, Expected: f.Type, Actual: ty})
+	}
+	if f.Fields != nil {
+		if m, ok := asProperties(v); ok {
+			errs = f.Fields.validate(path+".", m, errs)
+		}
+	}
+	if f.Items != nil {
+		if arr, ok := v.([]interface{}); ok {
+			for i, e := range arr {
+				errs = f.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+	}
+	return errs
+}
+
+func (s *PropsSchema) validate(prefix string, props Properties, errs []*PropError) []*PropError {
+	keys := make([]string, 0, len(s.Fields))
+	for k := range s.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f := s.Fields[k]
+		v, ok := props[k]
+		if !ok {
+			if f.Required {
+				errs = append(errs, &PropError{Path: prefix + k, Expected: f.Type, Missing: true})
+			}
+			continue
+		}
+		errs = f.validate(prefix+k, v, errs)
+	}
+	return errs
+}
+
+func (s *PropsSchema) Validate(props Properties) error {
+	if errs := s.validate("", props, nil); len(errs) > 0 {
+		return &PropsValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func (m *Mesh) ValidateProps(schema *PropsSchema) error {
+	return schema.Validate(m.Props)
+}
@@ -0,0 +1,439 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	QUANTIZE_NONE     = 0
+	QUANTIZE_POSITION = 1 << 0
+	QUANTIZE_NORMAL   = 1 << 1
+	QUANTIZE_TEXCOORD = 1 << 2
+	QUANTIZE_ALL      = QUANTIZE_POSITION | QUANTIZE_NORMAL | QUANTIZE_TEXCOORD
+
+	QUANTIZE_NORMAL_OCT16  = 1 << 3
+	QUANTIZE_TEXCOORD_HALF = 1 << 4
+	QUANTIZE_COMPACT       = QUANTIZE_NORMAL_OCT16 | QUANTIZE_TEXCOORD_HALF
+)
+
+const FLOAT16_MAX = 65504
+
+const QUANTIZE_STEPS = math.MaxUint16
+
+type QuantizationGrid struct {
+	Offset [3]float32
+	Scale  [3]float32
+}
+
+func newQuantizationGrid(min, max [3]float64) QuantizationGrid {
+	var g QuantizationGrid
+	for c := 0; c < 3; c++ {
+		g.Offset[c] = float32(min[c])
+		g.Scale[c] = float32((max[c] - float64(g.Offset[c])) / QUANTIZE_STEPS)
+	}
+	return g
+}
+
+func (g *QuantizationGrid) Quantize(v float32, c int) uint16 {
+	if g.Scale[c] == 0 {
+		return 0
+	}
+	q := math.Round(float64(v-g.Offset[c]) / float64(g.Scale[c]))
+	if q < 0 {
+		return 0
+	}
+	if q > QUANTIZE_STEPS {
+		return QUANTIZE_STEPS
+	}
+	return uint16(q)
+}
+
+func (g *QuantizationGrid) Dequantize(q uint16, c int) float32 {
+	return g.Offset[c] + float32(q)*g.Scale[c]
+}
+
+func finite(v float32) bool {
+	f := float64(v)
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func componentBounds(n, dims int, get func(i, c int) float32) (min, max [3]float64, ok bool) {
+	for c := 0; c < dims; c++ {
+		min[c], max[c] = math.Inf(1), math.Inf(-1)
+	}
+	for i := 0; i < n; i++ {
+		for c := 0; c < dims; c++ {
+			v := get(i, c)
+			if !finite(v) {
+				return min, max, false
+			}
+			min[c] = math.Min(min[c], float64(v))
+			max[c] = math.Max(max[c], float64(v))
+		}
+	}
+	return min, max, n > 0
+}
+
+func PositionGrid(vs []vec3.T) (QuantizationGrid, bool) {
+	min, max, ok := componentBounds(len(vs), 3, func(i, c int) float32 { return vs[i][c] })
+	return newQuantizationGrid(min, max), ok
+}
+
+func TexCoordGrid(uvs []vec2.T) (QuantizationGrid, bool) {
+	min, max, ok := componentBounds(len(uvs), 2, func(i, c int) float32 { return uvs[i][c] })
+	return newQuantizationGrid(min, max), ok
+}
+
+func octWrap(x, y float64) (float64, float64) {
+	sx, sy := 1.0, 1.0
+	if x < 0 {
+		sx = -1
+	}
+	if y < 0 {
+		sy = -1
+	}
+	return (1 - math.Abs(y)) * sx, (1 - math.Abs(x)) * sy
+}
+
+func octProject(n vec3.T) (float64, float64) {
+	x, y, z := float64(n[0]), float64(n[1]), float64(n[2])
+	l1 := math.Abs(x) + math.Abs(y) + math.Abs(z)
+	if l1 == 0 || math.IsNaN(l1) || math.IsInf(l1, 0) {
+		return 0, 0
+	}
+	x, y = x/l1, y/l1
+	if z < 0 {
+		x, y = octWrap(x, y)
+	}
+	return x, y
+}
+
+func octUnproject(x, y float64) vec3.T {
+	z := 1 - math.Abs(x) - math.Abs(y)
+	if z < 0 {
+		x, y = octWrap(x, y)
+	}
+	l := math.Sqrt(x*x + y*y + z*z)
+	return vec3.T{float32(x / l), float32(y / l), float32(z / l)}
+}
+
+func OctEncode(n vec3.T) [2]int16 {
+	x, y := octProject(n)
+	return [2]int16{int16(math.Round(x * math.MaxInt16)), int16(math.Round(y * math.MaxInt16))}
+}
+
+func OctDecode(e [2]int16) vec3.T {
+	return octUnproject(math.Max(float64(e[0])/math.MaxInt16, -1), math.Max(float64(e[1])/math.MaxInt16, -1))
+}
+
+func OctEncode16(n vec3.T) [2]int8 {
+	x, y := octProject(n)
+	return [2]int8{int8(math.Round(x * math.MaxInt8)), int8(math.Round(y * math.MaxInt8))}
+}
+
+func OctDecode16(e [2]int8) vec3.T {
+	return octUnproject(math.Max(float64(e[0])/math.MaxInt8, -1), math.Max(float64(e[1])/math.MaxInt8, -1))
+}
+
+func Float16Bits(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+	switch {
+	case b&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h, rem, half := mant>>shift, mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > half || rem == half && h&1 != 0 {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	h, rem := uint32(exp)<<10|mant>>13, mant&0x1fff
+	if rem > 0x1000 || rem == 0x1000 && h&1 != 0 {
+		h++
+	}
+	return sign | uint16(h)
+}
+
+func Float16Value(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp, mant := uint32(h>>10)&0x1f, uint32(h&0x3ff)
+	switch exp {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		v := float32(math.Ldexp(float64(mant), -24))
+		if sign != 0 {
+			return -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
+
+type nodeQuantization struct {
+	flags uint8
+	pos   QuantizationGrid
+	uv    QuantizationGrid
+}
+
+func (n *MeshNode) planQuantization(flags uint8) *nodeQuantization {
+	q := &nodeQuantization{}
+	var ok bool
+	if flags&QUANTIZE_POSITION != 0 {
+		if q.pos, ok = PositionGrid(n.Vertices); ok {
+			q.flags |= QUANTIZE_POSITION
+		}
+	}
+	if flags&(QUANTIZE_NORMAL|QUANTIZE_NORMAL_OCT16) != 0 && len(n.Normals) > 0 {
+		if _, _, ok = componentBounds(len(n.Normals), 3, func(i, c int) float32 { return n.Normals[i][c] }); ok {
+			if flags&QUANTIZE_NORMAL_OCT16 != 0 {
+				q.flags |= QUANTIZE_NORMAL_OCT16
+			} else {
+				q.flags |= QUANTIZE_NORMAL
+			}
+		}
+	}
+	half := false
+	if flags&QUANTIZE_TEXCOORD_HALF != 0 {
+		min, max, ok := componentBounds(len(n.TexCoords), 2, func(i, c int) float32 { return n.TexCoords[i][c] })
+		half = ok && math.Max(-min[0], -min[1]) <= FLOAT16_MAX && math.Max(max[0], max[1]) <= FLOAT16_MAX
+	}
+	if half {
+		q.flags |= QUANTIZE_TEXCOORD_HALF
+	} else if flags&QUANTIZE_TEXCOORD != 0 {
+		if q.uv, ok = TexCoordGrid(n.TexCoords); ok {
+			q.flags |= QUANTIZE_TEXCOORD
+		}
+	}
+	return q
+}
+
+func (n *MeshNode) storedQuantization(caps Capabilities) *nodeQuantization {
+	if !caps.Quantization {
+		return &nodeQuantization{}
+	}
+	flags := n.Quantization
+	if !caps.CompactAttributes {
+		flags &^= QUANTIZE_COMPACT
+	}
+	return n.planQuantization(flags)
+}
+
+func compactAttributes(nd *MeshNode) bool {
+	return nd.Quantization&QUANTIZE_COMPACT != 0
+}
+
+func dropQuantization(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if nd.Quantization == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].quantization", field, i), Message: fmt.Sprintf("dropped quantization flags 0x%02x", nd.Quantization)})
+		cp := *nd
+		cp.Quantization = 0
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func dropCompactAttributes(nds []*MeshNode) []*MeshNode {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if !compactAttributes(nd) {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.Quantization &^= QUANTIZE_COMPACT
+		out[i] = &cp
+	}
+	return out
+}
+
+func (m *Mesh) SetQuantization(flags uint8) {
+	for _, nd := range m.Nodes {
+		nd.Quantization = flags
+	}
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		for _, nd := range inst.Mesh.Nodes {
+			nd.Quantization = flags
+		}
+	}
+}
+
+func writeQuantizedPositions(wt io.Writer, vs []vec3.T, g *QuantizationGrid) {
+	writeLittleByte(wt, g)
+	buf := make([]uint16, 0, 3*capHint(len(vs)))
+	for i, v := range vs {
+		buf = append(buf, g.Quantize(v[0], 0), g.Quantize(v[1], 1), g.Quantize(v[2], 2))
+		if len(buf) == cap(buf) || i == len(vs)-1 {
+			writeLittleByte(wt, buf)
+			buf = buf[:0]
+		}
+	}
+}
+
+func writeOctNormals(wt io.Writer, ns []vec3.T) {
+	buf := make([][2]int16, 0, capHint(len(ns)))
+	for i, n := range ns {
+		buf = append(buf, OctEncode(n))
+		if len(buf) == cap(buf) || i == len(ns)-1 {
+			writeLittleByte(wt, buf)
+			buf = buf[:0]
+		}
+	}
+}
+
+func writeOctNormals16(wt io.Writer, ns []vec3.T) {
+	buf := make([][2]int8, 0, capHint(len(ns)))
+	for i, n := range ns {
+		buf = append(buf, OctEncode16(n))
+		if len(buf) == cap(buf) || i == len(ns)-1 {
+			writeLittleByte(wt, buf)
+			buf = buf[:0]
+		}
+	}
+}
+
+func writeHalfTexCoords(wt io.Writer, uvs []vec2.T) {
+	buf := make([]uint16, 0, 2*capHint(len(uvs)))
+	for i, v := range uvs {
+		buf = append(buf, Float16Bits(v[0]), Float16Bits(v[1]))
+		if len(buf) == cap(buf) || i == len(uvs)-1 {
+			writeLittleByte(wt, buf)
+			buf = buf[:0]
+		}
+	}
+}
+
+func writeQuantizedTexCoords(wt io.Writer, uvs []vec2.T, g *QuantizationGrid) {
+	writeLittleByte(wt, g)
+	buf := make([]uint16, 0, 2*capHint(len(uvs)))
+	for i, v := range uvs {
+		buf = append(buf, g.Quantize(v[0], 0), g.Quantize(v[1], 1))
+		if len(buf) == cap(buf) || i == len(uvs)-1 {
+			writeLittleByte(wt, buf)
+			buf = buf[:0]
+		}
+	}
+}
+
+func (d *decoder) quantization() uint8 {
+	if !d.caps().Quantization {
+		return QUANTIZE_NONE
+	}
+	known := uint8(QUANTIZE_ALL)
+	if d.caps().CompactAttributes {
+		known |= QUANTIZE_COMPACT
+	}
+	var q uint8
+	if d.read(&q) && (q&^known != 0 || q&QUANTIZE_NORMAL != 0 && q&QUANTIZE_NORMAL_OCT16 != 0 || q&QUANTIZE_TEXCOORD != 0 && q&QUANTIZE_TEXCOORD_HALF != 0) {
+		d.fail(fmt.Errorf("mst: invalid quantization flags %#x", q))
+	}
+	return q
+}
+
+func (d *decoder) uint16s(n int) []uint16 {
+	out := make([]uint16, 0, capHint(n))
+	for len(out) < n && d.err == nil {
+		chunk := make([]uint16, capHint(n-len(out)))
+		if d.read(chunk) {
+			out = append(out, chunk...)
+		}
+	}
+	return out
+}
+
+func (d *decoder) quantizedVec3s(n int) []vec3.T {
+	if n == 0 {
+		return nil
+	}
+	var g QuantizationGrid
+	d.read(&g)
+	q := d.uint16s(3 * n)
+	if d.err != nil {
+		return nil
+	}
+	out := make([]vec3.T, n)
+	for i := range out {
+		out[i] = vec3.T{g.Dequantize(q[3*i], 0), g.Dequantize(q[3*i+1], 1), g.Dequantize(q[3*i+2], 2)}
+	}
+	return out
+}
+
+func (d *decoder) octNormals(n int) []vec3.T {
+	q := d.uint16s(2 * n)
+	if d.err != nil {
+		return nil
+	}
+	out := make([]vec3.T, n)
+	for i := range out {
+		out[i] = OctDecode([2]int16{int16(q[2*i]), int16(q[2*i+1])})
+	}
+	return out
+}
+
+func (d *decoder) octNormals16(n int) []vec3.T {
+	q := d.bytes(2 * n)
+	if d.err != nil {
+		return nil
+	}
+	out := make([]vec3.T, n)
+	for i := range out {
+		out[i] = OctDecode16([2]int8{int8(q[2*i]), int8(q[2*i+1])})
+	}
+	return out
+}
+
+func (d *decoder) halfVec2s(n int) []vec2.T {
+	q := d.uint16s(2 * n)
+	if d.err != nil {
+		return nil
+	}
+	out := make([]vec2.T, n)
+	for i := range out {
+		out[i] = vec2.T{Float16Value(q[2*i]), Float16Value(q[2*i+1])}
+	}
+	return out
+}
+
+func (d *decoder) quantizedVec2s(n int) []vec2.T {
+	if n == 0 {
+		return nil
+	}
+	var g QuantizationGrid
+	d.read(&g)
+	q := d.uint16s(2 * n)
+	if d.err != nil {
+		return nil
+	}
+	out := make([]vec2.T, n)
+	for i := range out {
+		out[i] = vec2.T{g.Dequantize(q[2*i], 0), g.Dequantize(q[2*i+1], 1)}
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+package mst
+
+import (
+	"math"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+)
+
+type TriangleRef struct {
+	Node      int
+	Group     int
+	Face      int
+	Instance  int
+	Transform int
+	FeatureID *uint64
+}
+
+type SurfacePoint struct {
+	TriangleRef
+	Point       dvec3.T
+	Normal      dvec3.T
+	Barycentric [3]float64
+}
+
+// MeshQuery answers repeated distance queries against a mesh whose geometry
+// does not change between calls.
+type MeshQuery struct {
+	ms     *Mesh
+	bvh    *bvh
+	closed bool
+}
+
+func NewMeshQuery(ms *Mesh) (*MeshQuery, error) {
+	tris, err := meshTriangles(ms)
+	if err != nil {
+		return nil, err
+	}
+	if len(tris) == 0 {
+		return nil, ErrEmptyMesh
+	}
+	closed := !anyNode(ms, func(nd *MeshNode) bool {
+		return (len(nd.FaceGroup) > 0 || len(nd.PolygonGroup) > 0) && !nd.IsWatertight()
+	})
+	return &MeshQuery{ms: ms, bvh: newBVH(tris), closed: closed}, nil
+}
+
+func (m *Mesh) ClosestPoint(p dvec3.T) (hit SurfacePoint, dist float64) {
+	q, err := NewMeshQuery(m)
+	if err != nil {
+		return SurfacePoint{TriangleRef: TriangleRef{Instance: -1}}, math.Inf(1)
+	}
+	return q.ClosestPoint(p)
+}
+
+func (m *Mesh) SignedDistance(p dvec3.T) (float64, error) {
+	q, err := NewMeshQuery(m)
+	if err != nil {
+		return 0, err
+	}
+	return q.SignedDistance(p)
+}
+
+func (m *Mesh) triangleRef(t *bvhTriangle) TriangleRef {
+	r := TriangleRef{Node: t.node, Group: t.group, Face: t.face, Instance: t.instance, Transform: t.transform}
+	if id, ok := m.triangleFeature(t); ok {
+		r.FeatureID = &id
+	}
+	return r
+}
+
+func boxDistanceSq(bx *dvec3.Box, p *dvec3.T) float64 {
+	d := 0.0
+	for a := 0; a < 3; a++ {
+		if p[a] < bx.Min[a] {
+			d += (bx.Min[a] - p[a]) * (bx.Min[a] - p[a])
+		} else if p[a] > bx.Max[a] {
+			d += (p[a] - bx.Max[a]) * (p[a] - bx.Max[a])
+		}
+	}
+	return d
+}
+
+func closestOnTriangle(t *bvhTriangle, p *dvec3.T) [3]float64 {
+	a, b, c := &t.v[0], &t.v[1], &t.v[2]
+	ab, ac, ap := dvec3.Sub(b, a), dvec3.Sub(c, a), dvec3.Sub(p, a)
+	d1, d2 := dvec3.Dot(&ab, &ap), dvec3.Dot(&ac, &ap)
+	if d1 <= 0 && d2 <= 0 {
+		return [3]float64{1, 0, 0}
+	}
+	bp := dvec3.Sub(p, b)
+	d3, d4 := dvec3.Dot(&ab, &bp), dvec3.Dot(&ac, &bp)
+	if d3 >= 0 && d4 <= d3 {
+		return [3]float64{0, 1, 0}
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		v := d1 / (d1 - d3)
+		return [3]float64{1 - v, v, 0}
+	}
+	cp := dvec3.Sub(p, c)
+	d5, d6 := dvec3.Dot(&ab, &cp), dvec3.Dot(&ac, &cp)
+	if d6 >= 0 && d5 <= d6 {
+		return [3]float64{0, 0, 1}
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		w := d2 / (d2 - d6)
+		return [3]float64{1 - w, 0, w}
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		w := (d4 - d3) / ((d4 - d3) + (d5 - d6))
+		return [3]float64{0, 1 - w, w}
+	}
+	den := va + vb + vc
+	if den == 0 {
+		return [3]float64{1, 0, 0}
+	}
+	v, w := vb/den, vc/den
+	return [3]float64{1 - v - w, v, w}
+}
+
+func barycentricPoint(t *bvhTriangle, bc [3]float64) dvec3.T {
+	var out dvec3.T
+	for k := range t.v {
+		s := t.v[k].Scaled(bc[k])
+		out.Add(&s)
+	}
+	return out
+}
+
+func (b *bvh) closest(p *dvec3.T) (int, [3]float64, float64) {
+	hit, best := -1, math.Inf(1)
+	var bc [3]float64
+	if len(b.nodes) == 0 {
+		return hit, bc, best
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		n := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if boxDistanceSq(&n.box, p) >= best {
+			continue
+		}
+		if n.count == 0 {
+			l, r := &b.nodes[n.left], &b.nodes[n.right]
+			if boxDistanceSq(&l.box, p) < boxDistanceSq(&r.box, p) {
+				stack = append(stack, n.right, n.left)
+			} else {
+				stack = append(stack, n.left, n.right)
+			}
+			continue
+		}
+		for i := n.start; i < n.start+n.count; i++ {
+			w := closestOnTriangle(&b.tris[i], p)
+			q := barycentricPoint(&b.tris[i], w)
+			if d := dvec3.SquareDistance(&q, p); d < best {
+				hit, bc, best = i, w, d
+			}
+		}
+	}
+	return hit, bc, best
+}
+
+func (q *MeshQuery) ClosestPoint(p dvec3.T) (hit SurfacePoint, dist float64) {
+	i, bc, d := q.bvh.closest(&p)
+	if i < 0 {
+		return SurfacePoint{TriangleRef: TriangleRef{Instance: -1}}, math.Inf(1)
+	}
+	t := &q.bvh.tris[i]
+	e1, e2 := dvec3.Sub(&t.v[1], &t.v[0]), dvec3.Sub(&t.v[2], &t.v[0])
+	hit = SurfacePoint{
+		TriangleRef: q.ms.triangleRef(t),
+		Point:       barycentricPoint(t, bc),
+		Normal:      dvec3.Cross(&e1, &e2),
+		Barycentric: bc,
+	}
+	if hit.Normal.Length() > 0 {
+		hit.Normal.Normalize()
+	}
+	return hit, math.Sqrt(d)
+}
+
+// SignedDistance is negative inside the solid. The sign comes from the
+// generalized winding number, so it stays stable on the medial axis and at
+// shared edges where the nearest face normal is ambiguous.
+func (q *MeshQuery) SignedDistance(p dvec3.T) (float64, error) {
+	if !q.closed {
+		return 0, ErrNotWatertight
+	}
+	_, d := q.ClosestPoint(p)
+	if q.windingNumber(&p) > 0.5 {
+		d = -d
+	}
+	return d, nil
+}
+
+func (q *MeshQuery) windingNumber(p *dvec3.T) float64 {
+	w := 0.0
+	for i := range q.bvh.tris {
+		t := &q.bvh.tris[i]
+		a, b, c := dvec3.Sub(&t.v[0], p), dvec3.Sub(&t.v[1], p), dvec3.Sub(&t.v[2], p)
+		la, lb, lc := a.Length(), b.Length(), c.Length()
+		bc := dvec3.Cross(&b, &c)
+		num := dvec3.Dot(&a, &bc)
+		den := la*lb*lc + dvec3.Dot(&a, &b)*lc + dvec3.Dot(&b, &c)*la + dvec3.Dot(&c, &a)*lb
+		w += 2 * math.Atan2(num, den)
+	}
+	return w / (4 * math.Pi)
+}
@@ -0,0 +1,112 @@
+package mst
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+type RemoteMesh struct {
+	Header *MeshHeader
+	ctx    context.Context
+	url    string
+	client *http.Client
+	mtls   []MeshMaterial
+}
+
+type RangeError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("mst: range request to %s failed with status %d", e.URL, e.StatusCode)
+}
+
+func (r *RemoteMesh) fetch(offset, length uint64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(r.ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &RangeError{URL: r.url, StatusCode: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+func MeshOpenURL(ctx context.Context, url string, client *http.Client) (*RemoteMesh, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &RemoteMesh{ctx: ctx, url: url, client: client}
+	size := headerSize(MESH_LATEST_VERSION, MESH_FLAG_SECTION_TABLE|MESH_FLAG_MANIFEST|MESH_FLAG_COMPRESSED, maxSectionTableEntries, maxManifestSize)
+	body, err := r.fetch(0, uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if r.Header, err = ReadMeshHeader(body); err != nil {
+		return nil, err
+	}
+	if r.Header.Flags&MESH_FLAG_SECTION_TABLE == 0 {
+		return nil, ErrNoSectionTable
+	}
+	return r, nil
+}
+
+func (r *RemoteMesh) section(section int) (io.ReadCloser, error) {
+	if section < 0 || section >= len(r.Header.Sections) || section >= meshSectionCount(r.Header.Version) {
+		return nil, ErrSectionNotFound
+	}
+	s := r.Header.Sections[section]
+	if s.Length == 0 {
+		return nil, ErrSectionNotFound
+	}
+	return r.fetch(s.Offset, s.Length)
+}
+
+func (r *RemoteMesh) Section(section int) (*Mesh, error) {
+	body, err := r.section(section)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return decodeSection(bufio.NewReader(body), r.Header, section)
+}
+
+func (r *RemoteMesh) Materials() ([]MeshMaterial, error) {
+	if r.mtls != nil {
+		return r.mtls, nil
+	}
+	ms, err := r.Section(MESH_SECTION_MATERIALS)
+	if err != nil {
+		return nil, err
+	}
+	r.mtls = ms.Materials
+	return r.mtls, nil
+}
+
+func (r *RemoteMesh) Node(index int) (*Mesh, error) {
+	mtls, err := r.Materials()
+	if err != nil {
+		return nil, err
+	}
+	body, err := r.section(MESH_SECTION_NODES)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	d := newSectionDecoder(bufio.NewReader(body), r.Header)
+	d.beginSection()
+	return extractNode(d, mtls, strconv.Itoa(index))
+}
@@ -0,0 +1,264 @@
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/qmuntal/gltf"
+)
+
+const (
+	CONTENT_TYPE_MST  = "application/vnd.flywave.mst"
+	CONTENT_TYPE_GLB  = "model/gltf-binary"
+	CONTENT_TYPE_GLTF = "model/gltf+json"
+	CONTENT_TYPE_OBJ  = "model/obj"
+
+	CONTENT_TYPE_THREEJS     = "application/vnd.threejs+json"
+	CONTENT_TYPE_THREEJS_BIN = "multipart/vnd.threejs-bin"
+)
+
+const DEFAULT_MAX_UPLOAD_SIZE = 512 << 20
+
+var ErrExternalResource = errors.New("serve: external glTF resources are not allowed")
+
+var ErrThreejsParts = errors.New("serve: three.js binary upload needs json and bin parts")
+
+type Decoder func(rd io.Reader, opts *Options) (*mst.Mesh, error)
+
+type Encoder func(wt io.Writer, ms *mst.Mesh) error
+
+type Options struct {
+	MaxUploadSize int64
+	DecodeOptions *mst.DecodeOptions
+	GltfExport    *mst.GltfExportOptions
+	GltfImport    *mst.GltfImportOptions
+}
+
+type Server struct {
+	opts     Options
+	decoders map[string]Decoder
+	encoders map[string]Encoder
+	mux      *http.ServeMux
+}
+
+type rejectExternal struct{}
+
+func (rejectExternal) ReadFullResource(uri string, data []byte) error {
+	return ErrExternalResource
+}
+
+func decodeGltf(rd io.Reader, opts *Options) (*mst.Mesh, error) {
+	doc := new(gltf.Document)
+	dec := gltf.NewDecoder(rd).WithReadHandler(rejectExternal{})
+	if opts.MaxUploadSize > 0 {
+		dec.MaxMemoryAllocation = uint64(opts.MaxUploadSize)
+	}
+	if err := dec.Decode(doc); err != nil {
+		return nil, err
+	}
+	return mst.GltfToMstWithOptions(doc, opts.GltfImport)
+}
+
+// decodeThreejsBin reads a multipart body holding the three.js document in a
+// part named json and its geometry in a part named bin. The boundary is taken
+// from the first delimiter line since decoders only see the body.
+func decodeThreejsBin(rd io.Reader, opts *Options) (*mst.Mesh, error) {
+	br := bufio.NewReader(rd)
+	line, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "--") {
+		return nil, ErrThreejsParts
+	}
+	mr := multipart.NewReader(io.MultiReader(strings.NewReader(line), br), strings.TrimSpace(line[2:]))
+	parts := map[string][]byte{}
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts[p.FormName()] = data
+	}
+	if parts["json"] == nil || parts["bin"] == nil {
+		return nil, ErrThreejsParts
+	}
+	return mst.ThreejsBinToMesh(bytes.NewReader(parts["json"]), bytes.NewReader(parts["bin"]), nil)
+}
+
+func decodeMst(rd io.Reader, opts *Options) (*mst.Mesh, error) {
+	dopts := opts.DecodeOptions
+	if dopts == nil {
+		dopts = &mst.DefaultDecodeOptions
+	}
+	return mst.MeshUnMarshalWithOptions(rd, dopts)
+}
+
+func NewServer(opts *Options) *Server {
+	s := &Server{decoders: map[string]Decoder{}, encoders: map[string]Encoder{}, mux: http.NewServeMux()}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.MaxUploadSize == 0 {
+		s.opts.MaxUploadSize = DEFAULT_MAX_UPLOAD_SIZE
+	}
+	s.RegisterDecoder(CONTENT_TYPE_MST, decodeMst)
+	s.RegisterDecoder(CONTENT_TYPE_GLB, decodeGltf)
+	s.RegisterDecoder(CONTENT_TYPE_GLTF, decodeGltf)
+	s.RegisterDecoder(CONTENT_TYPE_OBJ, func(rd io.Reader, opts *Options) (*mst.Mesh, error) {
+		return mst.ObjToMst(rd, nil)
+	})
+	s.RegisterDecoder(CONTENT_TYPE_THREEJS, func(rd io.Reader, opts *Options) (*mst.Mesh, error) {
+		return mst.ThreejsToMstFromReader(rd, nil)
+	})
+	s.RegisterDecoder(CONTENT_TYPE_THREEJS_BIN, decodeThreejsBin)
+	s.RegisterEncoder(CONTENT_TYPE_MST, func(wt io.Writer, ms *mst.Mesh) error {
+		mst.MeshMarshal(wt, ms)
+		return nil
+	})
+	s.RegisterEncoder(CONTENT_TYPE_GLB, s.gltfEncoder(true))
+	s.RegisterEncoder(CONTENT_TYPE_GLTF, s.gltfEncoder(false))
+	s.RegisterEncoder(CONTENT_TYPE_OBJ, func(wt io.Writer, ms *mst.Mesh) error {
+		return mst.MeshObjMarshal(wt, ms, "")
+	})
+	s.mux.HandleFunc("/convert", s.convert)
+	s.mux.HandleFunc("/formats", s.formats)
+	return s
+}
+
+func (s *Server) gltfEncoder(binary bool) Encoder {
+	return func(wt io.Writer, ms *mst.Mesh) error {
+		doc, err := mst.MstToGltfWithOptions([]*mst.Mesh{ms}, s.opts.GltfExport)
+		if err != nil {
+			return err
+		}
+		enc := gltf.NewEncoder(wt)
+		enc.AsBinary = binary
+		return enc.Encode(doc)
+	}
+}
+
+func (s *Server) RegisterDecoder(contentType string, dec Decoder) {
+	s.decoders[contentType] = dec
+}
+
+func (s *Server) RegisterEncoder(contentType string, enc Encoder) {
+	s.encoders[contentType] = enc
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) decoderTypes() []string {
+	out := make([]string, 0, len(s.decoders))
+	for k := range s.decoders {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *Server) encoderTypes() []string {
+	out := make([]string, 0, len(s.encoders))
+	for k := range s.encoders {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *Server) formats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"from": s.decoderTypes(), "to": s.encoderTypes()})
+}
+
+func (s *Server) convert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from, _, _ = mime.ParseMediaType(r.Header.Get("Content-Type"))
+	}
+	dec, ok := s.decoders[from]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported input type %q", from), http.StatusUnsupportedMediaType)
+		return
+	}
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = negotiate(r.Header.Get("Accept"), s.encoderTypes())
+	}
+	enc, ok := s.encoders[to]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported output type %q", to), http.StatusNotAcceptable)
+		return
+	}
+	ms, err := dec(http.MaxBytesReader(w, r.Body, s.opts.MaxUploadSize), &s.opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	out := &bytes.Buffer{}
+	if err := enc(out, ms); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", to)
+	w.Header().Set("Content-Length", strconv.Itoa(out.Len()))
+	out.WriteTo(w)
+}
+
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return CONTENT_TYPE_MST
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, o := range offers {
+			if mt == o || mt == "*/*" || (strings.HasSuffix(mt, "/*") && strings.HasPrefix(o, strings.TrimSuffix(mt, "*"))) {
+				if mt == "*/*" {
+					o = CONTENT_TYPE_MST
+				}
+				best, bestQ = o, q
+				break
+			}
+		}
+	}
+	return best
+}
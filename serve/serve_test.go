@@ -0,0 +1,173 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec3"
+	"github.com/qmuntal/gltf"
+)
+
+func newMesh() *mst.Mesh {
+	ms := mst.NewMesh()
+	ms.Materials = append(ms.Materials, &mst.BaseMaterial{Color: [3]byte{255, 0, 0}})
+	ms.Nodes = append(ms.Nodes, &mst.MeshNode{
+		Vertices:  []vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}},
+		Normals:   []vec3.T{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+		FaceGroup: []*mst.MeshTriangle{{Faces: []*mst.Face{{Vertex: [3]uint32{0, 1, 2}}}}},
+	})
+	return ms
+}
+
+func post(t *testing.T, srv http.Handler, url, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConvert(t *testing.T) {
+	srv := NewServer(nil)
+	buf := &bytes.Buffer{}
+	mst.MeshMarshal(buf, newMesh())
+
+	rec := post(t, srv, "/convert", CONTENT_TYPE_MST, "model/obj;q=0.5, model/gltf-binary", buf.Bytes())
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != CONTENT_TYPE_GLB {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	glb := rec.Body.Bytes()
+	doc := new(gltf.Document)
+	if err := gltf.NewDecoder(bytes.NewReader(glb)).Decode(doc); err != nil || len(doc.Meshes) != 1 {
+		t.Fatalf("invalid glb response: %v", err)
+	}
+
+	rec = post(t, srv, "/convert", CONTENT_TYPE_GLB+"; charset=binary", "", glb)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != CONTENT_TYPE_MST {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	ms, err := mst.MeshUnMarshalWithOptions(rec.Body, &mst.DefaultDecodeOptions)
+	if err != nil || len(ms.Nodes) != 1 || len(ms.Nodes[0].Vertices) != 3 {
+		t.Fatalf("invalid mst response: %v", err)
+	}
+
+	rec = post(t, srv, "/convert?to="+CONTENT_TYPE_OBJ, CONTENT_TYPE_MST, "", buf.Bytes())
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "f ") {
+		t.Fatalf("unexpected obj response %d %s", rec.Code, rec.Body.String())
+	}
+	rec = post(t, srv, "/convert", CONTENT_TYPE_OBJ, "", rec.Body.Bytes())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected obj upload response %d %s", rec.Code, rec.Body.String())
+	}
+	if ms, err := mst.MeshUnMarshalWithOptions(rec.Body, &mst.DefaultDecodeOptions); err != nil || len(ms.Nodes) != 1 || len(ms.Nodes[0].FaceGroup[0].Faces) != 1 {
+		t.Fatalf("invalid mst from obj upload: %v", err)
+	}
+	mtllib := "mtllib ../../etc/passwd\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"
+	if rec = post(t, srv, "/convert", CONTENT_TYPE_OBJ, "", []byte(mtllib)); rec.Code != http.StatusOK {
+		t.Fatalf("expected material libraries to be ignored, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec = post(t, srv, "/convert", "text/plain", "", buf.Bytes()); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rec.Code)
+	}
+	if rec = post(t, srv, "/convert", CONTENT_TYPE_MST, "image/png", buf.Bytes()); rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", rec.Code)
+	}
+	if rec = post(t, srv, "/convert", CONTENT_TYPE_MST, "", []byte("garbage")); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	external := `{"asset":{"version":"2.0"},"buffers":[{"byteLength":4,"uri":"/etc/passwd"}]}`
+	if rec = post(t, srv, "/convert", CONTENT_TYPE_GLTF, "", []byte(external)); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected external buffers to be rejected, got %d", rec.Code)
+	}
+	small := NewServer(&Options{MaxUploadSize: 16})
+	if rec = post(t, small, "/convert", CONTENT_TYPE_MST, "", buf.Bytes()); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected oversized upload to fail, got %d", rec.Code)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewServer(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/formats", nil))
+	var formats map[string][]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &formats); err != nil {
+		t.Fatal(err)
+	}
+	if len(formats["from"]) != 6 || len(formats["to"]) != 4 {
+		t.Fatalf("unexpected formats %v", formats)
+	}
+}
+
+func TestConvertEncoderFailure(t *testing.T) {
+	srv := NewServer(nil)
+	srv.RegisterEncoder("application/x-broken", func(wt io.Writer, ms *mst.Mesh) error {
+		wt.Write([]byte("partial"))
+		return errors.New("encoder failed")
+	})
+	buf := &bytes.Buffer{}
+	mst.MeshMarshal(buf, newMesh())
+	rec := post(t, srv, "/convert?to=application/x-broken", CONTENT_TYPE_MST, "", buf.Bytes())
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "partial") {
+		t.Fatalf("expected 500 without a partial body, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConvertThreejs(t *testing.T) {
+	srv := NewServer(nil)
+	geom := `{"metadata": {"type": "BufferGeometry"}, "type": "BufferGeometry", "data": {"attributes": {
+		"position": {"itemSize": 3, "type": "Float32Array", "array": [0,0,0, 1,0,0, 0,1,0]}
+	}}}`
+	rec := post(t, srv, "/convert", CONTENT_TYPE_THREEJS, "", []byte(geom))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if ms := mst.MeshUnMarshal(bytes.NewReader(rec.Body.Bytes())); len(ms.Nodes) != 1 || len(ms.Nodes[0].Vertices) != 3 {
+		t.Fatal("three.js json did not round trip")
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, part := range []struct{ name, file string }{{"json", "BYjishuiqi.json"}, {"bin", "BYjishuiqi.bin"}} {
+		data, err := ioutil.ReadFile(filepath.Join("..", "tests", part.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, _ := mw.CreateFormFile(part.name, part.file)
+		fw.Write(data)
+	}
+	mw.Close()
+	rec = post(t, srv, "/convert", mw.FormDataContentType(), "", body.Bytes())
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected form data to need the three.js type, got %d", rec.Code)
+	}
+	rec = post(t, srv, "/convert", CONTENT_TYPE_THREEJS_BIN+"; boundary="+mw.Boundary(), "", body.Bytes())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if ms := mst.MeshUnMarshal(bytes.NewReader(rec.Body.Bytes())); len(ms.Nodes) == 0 || len(ms.Materials) == 0 {
+		t.Fatal("three.js binary upload did not convert")
+	}
+
+	body.Reset()
+	mw = multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("json", "only.json")
+	fw.Write([]byte(geom))
+	mw.Close()
+	rec = post(t, srv, "/convert", CONTENT_TYPE_THREEJS_BIN, "", body.Bytes())
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), ErrThreejsParts.Error()) {
+		t.Fatalf("expected missing bin part to be rejected, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,257 @@
+package mst
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+var ErrInvalidPlane = errors.New("mst: plane normal must not be zero")
+
+type Polyline3D struct {
+	Points    []dvec3.T `json:"points"`
+	Closed    bool      `json:"closed,omitempty"`
+	FeatureID *uint64   `json:"featureId,omitempty"`
+}
+
+type sliceSegment struct {
+	a, b dvec3.T
+}
+
+type sliceKey struct {
+	feature uint64
+	ok      bool
+}
+
+func normalizePlane(plane [4]float64) (dvec3.T, float64, error) {
+	n := dvec3.T{plane[0], plane[1], plane[2]}
+	l := n.Length()
+	if l == 0 || math.IsNaN(l) || math.IsInf(l, 0) {
+		return n, 0, ErrInvalidPlane
+	}
+	n.Scale(1 / l)
+	return n, plane[3] / l, nil
+}
+
+func planeCrossing(p, q dvec3.T, dp, dq float64) dvec3.T {
+	if p[0] > q[0] || p[0] == q[0] && (p[1] > q[1] || p[1] == q[1] && p[2] > q[2]) {
+		p, q, dp, dq = q, p, dq, dp
+	}
+	t := dp / (dp - dq)
+	d := dvec3.Sub(&q, &p)
+	d.Scale(t)
+	return dvec3.Add(&p, &d)
+}
+
+func sliceTriangle(t *bvhTriangle, n *dvec3.T, d float64) (sliceSegment, bool) {
+	var dist [3]float64
+	above := 0
+	for k := range t.v {
+		dist[k] = dvec3.Dot(n, &t.v[k]) + d
+		if dist[k] >= 0 {
+			above++
+		}
+	}
+	if above == 0 || above == 3 {
+		return sliceSegment{}, false
+	}
+	var s sliceSegment
+	for k := 0; k < 3; k++ {
+		j := (k + 1) % 3
+		in, out := dist[k] < 0, dist[j] < 0
+		if in == out {
+			continue
+		}
+		p := planeCrossing(t.v[k], t.v[j], dist[k], dist[j])
+		if out {
+			s.a = p
+		} else {
+			s.b = p
+		}
+	}
+	return s, true
+}
+
+func chainSegments(segs []sliceSegment) [][]dvec3.T {
+	from := make(map[dvec3.T][]int)
+	incoming := make(map[dvec3.T]int)
+	for i, s := range segs {
+		from[s.a] = append(from[s.a], i)
+		incoming[s.b]++
+	}
+	used := make([]bool, len(segs))
+	var out [][]dvec3.T
+	walk := func(i int) {
+		line := []dvec3.T{segs[i].a}
+		for i >= 0 {
+			used[i] = true
+			p := segs[i].b
+			line = append(line, p)
+			i = -1
+			if p == line[0] {
+				break
+			}
+			for _, j := range from[p] {
+				if !used[j] {
+					i = j
+					break
+				}
+			}
+		}
+		out = append(out, line)
+	}
+	for i, s := range segs {
+		if !used[i] && incoming[s.a] == 0 {
+			walk(i)
+		}
+	}
+	for i := range segs {
+		if !used[i] {
+			walk(i)
+		}
+	}
+	return out
+}
+
+func SliceMesh(ms *Mesh, plane [4]float64) ([]Polyline3D, error) {
+	n, d, err := normalizePlane(plane)
+	if err != nil {
+		return nil, err
+	}
+	tris, err := meshTriangles(ms)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[sliceKey][]sliceSegment)
+	var keys []sliceKey
+	for i := range tris {
+		s, ok := sliceTriangle(&tris[i], &n, d)
+		if !ok || s.a == s.b {
+			continue
+		}
+		id, has := ms.triangleFeature(&tris[i])
+		k := sliceKey{feature: id, ok: has}
+		if _, seen := groups[k]; !seen {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], s)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return !keys[i].ok && keys[j].ok || keys[i].ok == keys[j].ok && keys[i].feature < keys[j].feature
+	})
+	var out []Polyline3D
+	for _, k := range keys {
+		for _, pts := range chainSegments(groups[k]) {
+			pl := Polyline3D{Points: pts}
+			if len(pts) > 3 && pts[0] == pts[len(pts)-1] {
+				pl.Points, pl.Closed = pts[:len(pts)-1], true
+			}
+			if k.ok {
+				id := k.feature
+				pl.FeatureID = &id
+			}
+			out = append(out, pl)
+		}
+	}
+	return out, nil
+}
+
+func pointInRing(p [2]float64, ring [][2]float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+func CapSection(lines []Polyline3D, plane [4]float64) (*MeshNode, error) {
+	n, _, err := normalizePlane(plane)
+	if err != nil {
+		return nil, err
+	}
+	axis := dvec3.T{1, 0, 0}
+	if math.Abs(n[0]) > 0.9 {
+		axis = dvec3.T{0, 1, 0}
+	}
+	u := dvec3.Cross(&axis, &n)
+	u.Normalize()
+	v := dvec3.Cross(&n, &u)
+	type ring struct {
+		line  *Polyline3D
+		pts   [][2]float64
+		area  float64
+		depth int
+	}
+	var rings []*ring
+	for i := range lines {
+		l := &lines[i]
+		if !l.Closed || len(l.Points) < 3 {
+			continue
+		}
+		r := &ring{line: l, pts: make([][2]float64, len(l.Points))}
+		for k, p := range l.Points {
+			r.pts[k] = [2]float64{dvec3.Dot(&p, &u), dvec3.Dot(&p, &v)}
+		}
+		for k := range r.pts {
+			p, q := r.pts[k], r.pts[(k+1)%len(r.pts)]
+			r.area += (p[0]*q[1] - q[0]*p[1]) / 2
+		}
+		if r.area != 0 {
+			rings = append(rings, r)
+		}
+	}
+	sort.SliceStable(rings, func(i, j int) bool { return math.Abs(rings[i].area) > math.Abs(rings[j].area) })
+	parent := make([]int, len(rings))
+	for i, r := range rings {
+		parent[i] = -1
+		for j := i - 1; j >= 0; j-- {
+			if pointInRing(r.pts[0], rings[j].pts) {
+				parent[i], r.depth = j, rings[j].depth+1
+				break
+			}
+		}
+	}
+	nd := &MeshNode{}
+	g := &MeshTriangle{}
+	add := func(r *ring, ccw bool) []uint32 {
+		idx := make([]uint32, len(r.line.Points))
+		for k, p := range r.line.Points {
+			idx[k] = uint32(len(nd.Vertices))
+			nd.Vertices = append(nd.Vertices, vec3.T{float32(p[0]), float32(p[1]), float32(p[2])})
+		}
+		if (r.area > 0) != ccw {
+			for i, j := 0, len(idx)-1; i < j; i, j = i+1, j-1 {
+				idx[i], idx[j] = idx[j], idx[i]
+			}
+		}
+		return idx
+	}
+	for i, r := range rings {
+		if r.depth%2 != 0 {
+			continue
+		}
+		poly := &Polygon{Outer: add(r, true)}
+		for j := i + 1; j < len(rings); j++ {
+			if parent[j] == i {
+				poly.Holes = append(poly.Holes, add(rings[j], false))
+			}
+		}
+		tris, err := poly.Triangulate(nd.Vertices)
+		if err != nil {
+			return nil, err
+		}
+		src := &MeshTriangle{FeatureID: r.line.FeatureID}
+		for _, t := range tris {
+			g.addFace(&Face{Vertex: t}, src, 0)
+		}
+	}
+	nd.FaceGroup = []*MeshTriangle{g}
+	nd.ReComputeNormal()
+	return nd, nil
+}
@@ -0,0 +1,185 @@
+package mst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	MSH_FORMAT_VERSION   = "2.2"
+	MSH_ELEMENT_TRIANGLE = 2
+)
+
+var ErrNotWatertight = errors.New("mst: mesh is not a watertight solid")
+
+type SolidExportOptions struct {
+	RequireWatertight bool
+}
+
+type solidFace struct {
+	v       [3]uint32
+	batchid int32
+	entity  int
+}
+
+type solid struct {
+	vertices []vec3.T
+	faces    []solidFace
+}
+
+func weldedFaces(n *MeshNode, weld []uint32, entity int, out []solidFace) []solidFace {
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			v := [3]uint32{weld[f.Vertex[0]], weld[f.Vertex[1]], weld[f.Vertex[2]]}
+			if v[0] == v[1] || v[1] == v[2] || v[2] == v[0] {
+				continue
+			}
+			out = append(out, solidFace{v: v, batchid: g.Batchid, entity: entity})
+		}
+	}
+	return out
+}
+
+func newSolid(ms *Mesh) (*solid, error) {
+	s := &solid{}
+	for i, nd := range ms.Nodes {
+		if err := nd.validateVertexIndices(); err != nil {
+			return nil, err
+		}
+		weld := nd.weldedVertices()
+		compact := make([]uint32, len(nd.Vertices))
+		for v, w := range weld {
+			if uint32(v) == w {
+				compact[v] = uint32(len(s.vertices))
+				s.vertices = append(s.vertices, nd.Vertices[v])
+			}
+			weld[v] = compact[w]
+		}
+		s.faces = weldedFaces(nd, weld, i+1, s.faces)
+	}
+	return s, nil
+}
+
+func (s *solid) watertight() bool {
+	edges := make(map[[2]uint32]int, len(s.faces)*3)
+	for _, f := range s.faces {
+		for k := 0; k < 3; k++ {
+			edges[[2]uint32{f.v[k], f.v[(k+1)%3]}]++
+		}
+	}
+	for e, c := range edges {
+		if c != 1 || edges[[2]uint32{e[1], e[0]}] != 1 {
+			return false
+		}
+	}
+	return len(s.faces) > 0
+}
+
+func (n *MeshNode) IsWatertight() bool {
+	if n.validateVertexIndices() != nil {
+		return false
+	}
+	n = n.withPolygonFaces()
+	s := &solid{faces: weldedFaces(n, n.weldedVertices(), 1, nil)}
+	return s.watertight()
+}
+
+func solidOf(ms *Mesh, opts *SolidExportOptions) (*solid, error) {
+	s, err := newSolid(ms)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.RequireWatertight && !s.watertight() {
+		return nil, ErrNotWatertight
+	}
+	return s, nil
+}
+
+type solidWriter struct {
+	wt  *bufio.Writer
+	buf []byte
+}
+
+func (w *solidWriter) line(fields ...interface{}) {
+	w.buf = w.buf[:0]
+	for i, f := range fields {
+		if i > 0 {
+			w.buf = append(w.buf, ' ')
+		}
+		switch v := f.(type) {
+		case int:
+			w.buf = strconv.AppendInt(w.buf, int64(v), 10)
+		case uint32:
+			w.buf = strconv.AppendUint(w.buf, uint64(v), 10)
+		case float32:
+			w.buf = appendObjFloat(w.buf, v)
+		case string:
+			w.buf = append(w.buf, v...)
+		}
+	}
+	w.buf = append(w.buf, '\n')
+	w.wt.Write(w.buf)
+}
+
+func MeshOffMarshal(wt io.Writer, ms *Mesh, opts *SolidExportOptions) error {
+	s, err := solidOf(ms, opts)
+	if err != nil {
+		return err
+	}
+	w := &solidWriter{wt: bufio.NewWriterSize(wt, OBJ_BUFFER_SIZE)}
+	w.line("OFF")
+	w.line(len(s.vertices), len(s.faces), 0)
+	for _, v := range s.vertices {
+		w.line(v[0], v[1], v[2])
+	}
+	for _, f := range s.faces {
+		w.line(3, f.v[0], f.v[1], f.v[2])
+	}
+	return w.wt.Flush()
+}
+
+func MeshMshMarshal(wt io.Writer, ms *Mesh, opts *SolidExportOptions) error {
+	s, err := solidOf(ms, opts)
+	if err != nil {
+		return err
+	}
+	physical := make(map[int32]int)
+	var batchids []int32
+	for _, f := range s.faces {
+		if _, ok := physical[f.batchid]; !ok {
+			physical[f.batchid] = 0
+			batchids = append(batchids, f.batchid)
+		}
+	}
+	sort.Slice(batchids, func(i, j int) bool { return batchids[i] < batchids[j] })
+	w := &solidWriter{wt: bufio.NewWriterSize(wt, OBJ_BUFFER_SIZE)}
+	w.line("$MeshFormat")
+	w.line(MSH_FORMAT_VERSION, 0, 8)
+	w.line("$EndMeshFormat")
+	w.line("$PhysicalNames")
+	w.line(len(batchids))
+	for i, b := range batchids {
+		physical[b] = i + 1
+		w.line(2, i+1, fmt.Sprintf("\"batch_%d\"", b))
+	}
+	w.line("$EndPhysicalNames")
+	w.line("$Nodes")
+	w.line(len(s.vertices))
+	for i, v := range s.vertices {
+		w.line(i+1, v[0], v[1], v[2])
+	}
+	w.line("$EndNodes")
+	w.line("$Elements")
+	w.line(len(s.faces))
+	for i, f := range s.faces {
+		w.line(i+1, MSH_ELEMENT_TRIANGLE, 2, physical[f.batchid], f.entity, f.v[0]+1, f.v[1]+1, f.v[2]+1)
+	}
+	w.line("$EndElements")
+	return w.wt.Flush()
+}
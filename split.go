@@ -0,0 +1,320 @@
+package mst
+
+import "fmt"
+
+const splitUnmapped = ^uint32(0)
+
+type attrRemap struct {
+	index []uint32
+	used  []uint32
+}
+
+func newAttrRemap(n int) *attrRemap {
+	r := &attrRemap{index: make([]uint32, n)}
+	for i := range r.index {
+		r.index[i] = splitUnmapped
+	}
+	return r
+}
+
+func (r *attrRemap) missing(idx []uint32) int {
+	n := 0
+	for i, v := range idx {
+		if r.index[v] != splitUnmapped {
+			continue
+		}
+		dup := false
+		for _, p := range idx[:i] {
+			dup = dup || p == v
+		}
+		if !dup {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *attrRemap) get(v uint32, add func(uint32)) uint32 {
+	if r.index[v] == splitUnmapped {
+		r.index[v] = uint32(len(r.used))
+		r.used = append(r.used, v)
+		add(v)
+	}
+	return r.index[v]
+}
+
+func (r *attrRemap) reset() {
+	for _, v := range r.used {
+		r.index[v] = splitUnmapped
+	}
+	r.used = r.used[:0]
+}
+
+type nodeSplitter struct {
+	src      *MeshNode
+	max      int
+	separate bool
+	vmap     *attrRemap
+	nmap     *attrRemap
+	tmap     *attrRemap
+	cur      *MeshNode
+	faces    map[int]*MeshTriangle
+	edges    map[int]*MeshOutline
+	out      []*MeshNode
+}
+
+func (s *nodeSplitter) parallel(l int) bool {
+	return !s.separate && l == len(s.src.Vertices)
+}
+
+func (s *nodeSplitter) flush() {
+	if s.cur != nil && (len(s.cur.FaceGroup) > 0 || len(s.cur.EdgeGroup) > 0) {
+		s.out = append(s.out, s.cur)
+	}
+	s.vmap.reset()
+	if s.separate {
+		s.nmap.reset()
+		s.tmap.reset()
+	}
+	src := s.src
+	s.cur = &MeshNode{Mat: src.Mat, Lightmap: src.Lightmap, Props: src.Props.Clone(), Name: src.Name, IndexingMode: src.IndexingMode, IndexWidth: src.IndexWidth}
+	for _, t := range src.MorphTargets {
+		s.cur.MorphTargets = append(s.cur.MorphTargets, &MorphTarget{Name: t.Name, Weight: t.Weight})
+	}
+	s.faces = make(map[int]*MeshTriangle)
+	s.edges = make(map[int]*MeshOutline)
+}
+
+func (s *nodeSplitter) addVertex(v uint32) {
+	src := s.src
+	s.cur.Vertices = append(s.cur.Vertices, src.Vertices[v])
+	if len(src.Colors) == len(src.Vertices) {
+		s.cur.Colors = append(s.cur.Colors, src.Colors[v])
+	}
+	if s.parallel(len(src.Normals)) {
+		s.cur.Normals = append(s.cur.Normals, src.Normals[v])
+		s.morphNormal(v)
+	}
+	for i, t := range src.MorphTargets {
+		if len(t.Positions) == len(src.Vertices) {
+			s.cur.MorphTargets[i].Positions = append(s.cur.MorphTargets[i].Positions, t.Positions[v])
+		}
+	}
+	if s.parallel(len(src.TexCoords)) {
+		s.cur.TexCoords = append(s.cur.TexCoords, src.TexCoords[v])
+	}
+	if len(src.TexCoords2) == len(src.Vertices) {
+		s.cur.TexCoords2 = append(s.cur.TexCoords2, src.TexCoords2[v])
+	}
+}
+
+func (s *nodeSplitter) morphNormal(n uint32) {
+	for i, t := range s.src.MorphTargets {
+		if len(t.Normals) == len(s.src.Normals) {
+			s.cur.MorphTargets[i].Normals = append(s.cur.MorphTargets[i].Normals, t.Normals[n])
+		}
+	}
+}
+
+func (s *nodeSplitter) reserve(v, n, t []uint32) {
+	need := len(s.vmap.used) + s.vmap.missing(v)
+	if n != nil {
+		if c := len(s.nmap.used) + s.nmap.missing(n); c > need {
+			need = c
+		}
+	}
+	if t != nil {
+		if c := len(s.tmap.used) + s.tmap.missing(t); c > need {
+			need = c
+		}
+	}
+	if need > s.max {
+		s.flush()
+	}
+}
+
+func (s *nodeSplitter) face(gi int, g *MeshTriangle, fi int, f *Face) {
+	var n, t []uint32
+	if s.separate && len(s.src.Normals) > 0 {
+		n = f.Vertex[:]
+		if f.Normal != nil {
+			n = f.Normal[:]
+		}
+	}
+	if s.separate && len(s.src.TexCoords) > 0 {
+		t = f.Vertex[:]
+		if f.Uv != nil {
+			t = f.Uv[:]
+		}
+	}
+	s.reserve(f.Vertex[:], n, t)
+	out := &Face{Material: f.Material}
+	for i, v := range f.Vertex {
+		out.Vertex[i] = s.vmap.get(v, s.addVertex)
+	}
+	if n != nil {
+		var idx [3]uint32
+		for i, v := range n {
+			idx[i] = s.nmap.get(v, func(v uint32) {
+				s.cur.Normals = append(s.cur.Normals, s.src.Normals[v])
+				s.morphNormal(v)
+			})
+		}
+		out.Normal = &idx
+	}
+	if t != nil {
+		var idx [3]uint32
+		for i, v := range t {
+			idx[i] = s.tmap.get(v, func(v uint32) { s.cur.TexCoords = append(s.cur.TexCoords, s.src.TexCoords[v]) })
+		}
+		out.Uv = &idx
+	}
+	tg, ok := s.faces[gi]
+	if !ok {
+		tg = &MeshTriangle{Batchid: g.Batchid}
+		s.faces[gi] = tg
+		s.cur.FaceGroup = append(s.cur.FaceGroup, tg)
+	}
+	tg.addFace(out, g, fi)
+}
+
+func (s *nodeSplitter) edge(gi int, g *MeshOutline, e [2]uint32) {
+	s.reserve(e[:], nil, nil)
+	out := [2]uint32{s.vmap.get(e[0], s.addVertex), s.vmap.get(e[1], s.addVertex)}
+	tg, ok := s.edges[gi]
+	if !ok {
+		tg = g.withEdges(g.Batchid, nil)
+		s.edges[gi] = tg
+		s.cur.EdgeGroup = append(s.cur.EdgeGroup, tg)
+	}
+	tg.Edges = append(tg.Edges, out)
+}
+
+func SplitNode(nd *MeshNode, maxVertices int) ([]*MeshNode, error) {
+	if maxVertices < 3 {
+		return nil, fmt.Errorf("mst: cannot split nodes into fewer than 3 vertices, got %d", maxVertices)
+	}
+	if nd.attributeCount() <= maxVertices || (len(nd.FaceGroup) == 0 && len(nd.EdgeGroup) == 0) {
+		return []*MeshNode{nd}, nil
+	}
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			if err := nd.validateFace(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := nd.validateVertexIndices(); err != nil {
+		return nil, err
+	}
+	s := &nodeSplitter{src: nd, max: maxVertices, separate: nd.DetectIndexingMode() == INDEXING_MODE_SEPARATE, vmap: newAttrRemap(len(nd.Vertices))}
+	if s.separate {
+		s.nmap = newAttrRemap(len(nd.Normals))
+		s.tmap = newAttrRemap(len(nd.TexCoords))
+	}
+	s.flush()
+	for gi, g := range nd.FaceGroup {
+		for fi, f := range g.Faces {
+			s.face(gi, g, fi, f)
+		}
+	}
+	for gi, g := range nd.EdgeGroup {
+		for _, e := range g.Edges {
+			s.edge(gi, g, e)
+		}
+	}
+	s.flush()
+	return s.out, nil
+}
+
+func SelectFaces(nd *MeshNode, keep func(group, face int) bool) (*MeshNode, error) {
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			if err := nd.validateFace(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := nd.validateVertexIndices(); err != nil {
+		return nil, err
+	}
+	s := &nodeSplitter{src: nd, max: nd.attributeCount(), separate: nd.DetectIndexingMode() == INDEXING_MODE_SEPARATE, vmap: newAttrRemap(len(nd.Vertices))}
+	if s.separate {
+		s.nmap = newAttrRemap(len(nd.Normals))
+		s.tmap = newAttrRemap(len(nd.TexCoords))
+	}
+	s.flush()
+	for gi, g := range nd.FaceGroup {
+		for fi, f := range g.Faces {
+			if keep(gi, fi) {
+				s.face(gi, g, fi, f)
+			}
+		}
+	}
+	for gi, g := range nd.EdgeGroup {
+		for _, e := range g.Edges {
+			if s.vmap.index[e[0]] != splitUnmapped && s.vmap.index[e[1]] != splitUnmapped {
+				s.edge(gi, g, e)
+			}
+		}
+	}
+	return s.cur, nil
+}
+
+func splitNodes(nds []*MeshNode, maxVertices int) ([]*MeshNode, error) {
+	out := make([]*MeshNode, 0, len(nds))
+	index := make([]int, len(nds)+1)
+	for i, nd := range nds {
+		parts, err := SplitNode(nd, maxVertices)
+		if err != nil {
+			return nil, err
+		}
+		index[i] = len(out)
+		out = append(out, parts...)
+	}
+	index[len(nds)] = len(out)
+	if len(out) == len(nds) || !hasChildren(nds) {
+		return out, nil
+	}
+	for i, nd := range nds {
+		first, parts := index[i], index[i+1]-index[i]
+		if len(nd.Children) == 0 && parts == 1 {
+			continue
+		}
+		cp := *out[first]
+		cp.Children = nil
+		for _, c := range nd.Children {
+			if int(c) < len(nds) {
+				cp.Children = append(cp.Children, uint32(index[c]))
+			}
+		}
+		for j := 1; j < parts; j++ {
+			cp.Children = append(cp.Children, uint32(first+j))
+		}
+		out[first] = &cp
+	}
+	return out, nil
+}
+
+func (m *Mesh) SplitLargeNodes(maxVertices int) error {
+	nodes, err := splitNodes(m.Nodes, maxVertices)
+	if err != nil {
+		return err
+	}
+	instNodes := make([][]*MeshNode, len(m.InstanceNode))
+	for i, inst := range m.InstanceNode {
+		if inst.Mesh == nil {
+			continue
+		}
+		if instNodes[i], err = splitNodes(inst.Mesh.Nodes, maxVertices); err != nil {
+			return &AssetError{Field: fmt.Sprintf("instances[%d]", i), Err: err}
+		}
+	}
+	m.Nodes = nodes
+	for i, inst := range m.InstanceNode {
+		if inst.Mesh != nil {
+			inst.Mesh.Nodes = instNodes[i]
+		}
+	}
+	return nil
+}
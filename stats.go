@@ -0,0 +1,89 @@
+package mst
+
+type MeshStats struct {
+	Triangles         int
+	Vertices          int
+	Edges             int
+	OutlineEdges      int
+	Nodes             int
+	Instances         int
+	Textures          int
+	Texels            int64
+	CompressedBytes   int64
+	UncompressedBytes int64
+	MaterialFaces     []int
+	DegenerateFaces   int
+}
+
+func (n *MeshNode) isDegenerateFace(f *Face, eps float64) bool {
+	v := f.Vertex
+	if v[0] == v[1] || v[1] == v[2] || v[0] == v[2] || checkIndices("vertex", v[:], len(n.Vertices)) != nil {
+		return true
+	}
+	return faceArea(&n.Vertices[v[0]], &n.Vertices[v[1]], &n.Vertices[v[2]]) <= eps
+}
+
+func (s *MeshStats) node(nd *MeshNode, mtls []int) {
+	nd = nd.withPolygonFaces()
+	s.Nodes++
+	s.Vertices += len(nd.Vertices)
+	edges := make(map[[2]uint32]bool)
+	for _, g := range nd.FaceGroup {
+		s.Triangles += len(g.Faces)
+		for _, f := range g.Faces {
+			if id := f.materialIndex(g.Batchid); mtls != nil && id >= 0 && int(id) < len(mtls) {
+				mtls[id]++
+			}
+			if nd.isDegenerateFace(f, DefaultCleanupOptions.AreaEpsilon) {
+				s.DegenerateFaces++
+			}
+			for i := 0; i < 3; i++ {
+				a, b := f.Vertex[i], f.Vertex[(i+1)%3]
+				if a > b {
+					a, b = b, a
+				}
+				if a != b {
+					edges[[2]uint32{a, b}] = true
+				}
+			}
+		}
+	}
+	s.Edges += len(edges)
+	for _, g := range nd.EdgeGroup {
+		s.OutlineEdges += len(g.Edges)
+	}
+}
+
+func (s *MeshStats) texture(tex *Texture) {
+	s.Textures++
+	s.Texels += int64(tex.Size[0]) * int64(tex.Size[1])
+	s.CompressedBytes += int64(len(tex.Data))
+	s.UncompressedBytes += int64(tex.rawSize())
+}
+
+func (m *Mesh) Stats() MeshStats {
+	s := MeshStats{MaterialFaces: make([]int, len(m.Materials))}
+	for _, nd := range m.Nodes {
+		s.node(nd, s.MaterialFaces)
+	}
+	protos := make(map[*BaseMesh]bool)
+	for _, inst := range m.InstanceNode {
+		s.Instances += len(inst.Transfors)
+		if inst.Mesh == nil || protos[inst.Mesh] {
+			continue
+		}
+		protos[inst.Mesh] = true
+		for _, nd := range inst.Mesh.Nodes {
+			s.node(nd, nil)
+		}
+	}
+	seen := make(map[*Texture]bool)
+	anyTexture(m, func(tex *Texture) bool {
+		if !seen[tex] {
+			seen[tex] = true
+			s.texture(tex)
+		}
+		return false
+	})
+	return s
+}
@@ -0,0 +1,144 @@
+package mst
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+const (
+	TEXCOORD_SET_0 = 0
+	TEXCOORD_SET_1 = 1
+)
+
+const MAX_TEXCOORD_SETS = 2
+
+func (n *MeshNode) TexCoordSet(set uint8) []vec2.T {
+	switch set {
+	case TEXCOORD_SET_0:
+		return n.TexCoords
+	case TEXCOORD_SET_1:
+		return n.TexCoords2
+	}
+	return nil
+}
+
+func gltfTexCoordSet(set uint32) (uint8, error) {
+	if set >= MAX_TEXCOORD_SETS {
+		return TEXCOORD_SET_0, fmt.Errorf("mst: unsupported texture coordinate set %d", set)
+	}
+	return uint8(set), nil
+}
+
+func materialTextures(mtl MeshMaterial) *TextureMaterial {
+	switch ml := resolveMaterial(mtl).(type) {
+	case *TextureMaterial:
+		return ml
+	case *PbrMaterial:
+		return &ml.TextureMaterial
+	case *LambertMaterial:
+		return &ml.TextureMaterial
+	case *PhongMaterial:
+		return &ml.TextureMaterial
+	}
+	return nil
+}
+
+func selectsTexCoords2(mtl MeshMaterial) bool {
+	tm := materialTextures(mtl)
+	return tm != nil && (tm.TexCoord != TEXCOORD_SET_0 || tm.NormalTexCoord != TEXCOORD_SET_0)
+}
+
+func hasTexCoords2(ms *Mesh) bool {
+	if anyNode(ms, func(nd *MeshNode) bool { return len(nd.TexCoords2) > 0 }) {
+		return true
+	}
+	selects := func(mtls []MeshMaterial) bool {
+		for _, mtl := range mtls {
+			if selectsTexCoords2(mtl) {
+				return true
+			}
+		}
+		return false
+	}
+	if selects(ms.Materials) {
+		return true
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh != nil && selects(inst.Mesh.Materials) {
+			return true
+		}
+	}
+	return false
+}
+
+func texCoordSetsMarshal(wt io.Writer, mtl MeshMaterial) {
+	if tm := materialTextures(mtl); tm != nil {
+		writeLittleByte(wt, tm.TexCoord)
+		writeLittleByte(wt, tm.NormalTexCoord)
+	}
+}
+
+func (d *decoder) texCoordSets(mtl MeshMaterial) {
+	tm := materialTextures(mtl)
+	if tm == nil {
+		return
+	}
+	d.read(&tm.TexCoord)
+	d.read(&tm.NormalTexCoord)
+	if d.err == nil && (tm.TexCoord >= MAX_TEXCOORD_SETS || tm.NormalTexCoord >= MAX_TEXCOORD_SETS) {
+		d.fail(fmt.Errorf("mst: texture coordinate set %d/%d out of range", tm.TexCoord, tm.NormalTexCoord))
+	}
+}
+
+func texCoords2Marshal(wt io.Writer, nd *MeshNode, width uint8) {
+	writeLittleByte(wt, uint32(len(nd.TexCoords2)))
+	for i := range nd.TexCoords2 {
+		writeLittleByte(wt, nd.TexCoords2[i][:])
+	}
+}
+
+func (d *decoder) texCoords2(nd *MeshNode, width uint8) {
+	nd.TexCoords2 = d.vec2s(d.count("texcoord2 count", func(l *DecodeLimits) uint32 { return l.MaxVertices }))
+	if d.err == nil && len(nd.TexCoords2) > 0 && len(nd.TexCoords2) != len(nd.Vertices) {
+		d.fail(fmt.Errorf("mst: %d second texture coordinates for %d vertices", len(nd.TexCoords2), len(nd.Vertices)))
+	}
+}
+
+func dropTexCoords2(field string, nds []*MeshNode, warns []Warning) ([]*MeshNode, []Warning) {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if len(nd.TexCoords2) == 0 {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d].texCoords2", field, i), Message: fmt.Sprintf("dropped %d second texture coordinates", len(nd.TexCoords2))})
+		cp := *nd
+		cp.TexCoords2 = nil
+		out[i] = &cp
+	}
+	return out, warns
+}
+
+func dropTexCoordSets(field string, mtls []MeshMaterial, warns []Warning) ([]MeshMaterial, []Warning) {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		if !selectsTexCoords2(mtl) {
+			continue
+		}
+		if out == nil {
+			out = append([]MeshMaterial(nil), mtls...)
+		}
+		cp := cloneMaterial(resolveMaterial(mtl))
+		tm := materialTextures(cp)
+		tm.TexCoord, tm.NormalTexCoord = TEXCOORD_SET_0, TEXCOORD_SET_0
+		out[i] = cp
+	}
+	if out != nil {
+		warns = append(warns, Warning{Field: field, Message: "reset texture coordinate sets to 0"})
+	}
+	return out, warns
+}
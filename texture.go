@@ -0,0 +1,275 @@
+package mst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+)
+
+var (
+	pngSignature  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+	jpegSignature = []byte{0xff, 0xd8, 0xff}
+)
+
+type TextureCompressionError struct {
+	Declared uint16
+	Detected uint16
+}
+
+func (e *TextureCompressionError) Error() string {
+	return fmt.Sprintf("mst: texture compression flag %d does not match payload (detected %d)", e.Declared, e.Detected)
+}
+
+type TextureFormatError struct {
+	Format uint16
+	Type   uint16
+}
+
+func (e *TextureFormatError) Error() string {
+	return fmt.Sprintf("mst: texture format %d with pixel type %d is not an image", e.Format, e.Type)
+}
+
+func isZlibHeader(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	return data[0]&0x0f == 8 && data[0]>>4 <= 7 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+func DetectTextureCompression(data []byte) uint16 {
+	if bytes.HasPrefix(data, pngSignature) || bytes.HasPrefix(data, jpegSignature) {
+		return TEXTURE_COMPRESSED_SOURCE
+	}
+	if isZlibHeader(data) {
+		return TEXTURE_COMPRESSED_ZLIB
+	}
+	return TEXTURE_COMPRESSED_NONE
+}
+
+func textureChannels(format uint16) int {
+	switch format {
+	case TEXTURE_FORMAT_R, TEXTURE_FORMAT_R_INTEGER, TEXTURE_FORMAT_ALPHA, TEXTURE_FORMAT_DEPTH_COMPONENT, TEXTURE_FORMAT_DEPTH_STENCIL:
+		return 1
+	case TEXTURE_FORMAT_RG, TEXTURE_FORMAT_RG_INTEGER:
+		return 2
+	case TEXTURE_FORMAT_RGB, TEXTURE_FORMAT_RGB_INTEGER:
+		return 3
+	case TEXTURE_FORMAT_RGBA, TEXTURE_FORMAT_RGBA_INTEGER, TEXTURE_FORMAT_RGBM:
+		return 4
+	}
+	return 0
+}
+
+func texturePixelBytes(typ uint16) int {
+	switch typ {
+	case TEXTURE_PIXEL_TYPE_USHORT, TEXTURE_PIXEL_TYPE_SHORT, TEXTURE_PIXEL_TYPE_HALF:
+		return 2
+	case TEXTURE_PIXEL_TYPE_UINT, TEXTURE_PIXEL_TYPE_INT, TEXTURE_PIXEL_TYPE_FLOAT:
+		return 4
+	}
+	return 1
+}
+
+func (t *Texture) rawSize() int {
+	return int(t.Size[0]) * int(t.Size[1]) * textureChannels(t.Format) * texturePixelBytes(t.Type)
+}
+
+func (t *Texture) IsImage() bool {
+	switch t.Format {
+	case TEXTURE_FORMAT_R, TEXTURE_FORMAT_RG, TEXTURE_FORMAT_RGB, TEXTURE_FORMAT_RGBA, TEXTURE_FORMAT_RGBM, TEXTURE_FORMAT_ALPHA:
+		return t.Type == TEXTURE_PIXEL_TYPE_UBYTE || t.Type == TEXTURE_PIXEL_TYPE_USHORT
+	}
+	return false
+}
+
+func (t *Texture) Validate() error {
+	if t.IsExternal() {
+		return nil
+	}
+	detected := DetectTextureCompression(t.Data)
+	if t.Compressed == TEXTURE_COMPRESSED_SOURCE && !t.IsImage() {
+		return &TextureFormatError{Format: t.Format, Type: t.Type}
+	}
+	switch t.Compressed {
+	case TEXTURE_COMPRESSED_NONE:
+		if sz := t.rawSize(); sz > 0 && len(t.Data) == sz {
+			return nil
+		}
+		if detected != TEXTURE_COMPRESSED_NONE {
+			return &TextureCompressionError{Declared: t.Compressed, Detected: detected}
+		}
+		if sz := t.rawSize(); sz > 0 && len(t.Data) != sz {
+			return fmt.Errorf("mst: uncompressed texture has %d bytes, expected %d", len(t.Data), sz)
+		}
+	case TEXTURE_COMPRESSED_ZLIB, TEXTURE_COMPRESSED_SOURCE:
+		if len(t.Data) > 0 && detected != t.Compressed {
+			return &TextureCompressionError{Declared: t.Compressed, Detected: detected}
+		}
+	default:
+		return fmt.Errorf("mst: unknown texture compression %d", t.Compressed)
+	}
+	return nil
+}
+
+func (t *Texture) Pixels() ([]byte, error) {
+	switch t.Compressed {
+	case TEXTURE_COMPRESSED_NONE:
+		return t.Data, nil
+	case TEXTURE_COMPRESSED_ZLIB:
+		data, e := DecompressImage(t.Data)
+		if e != nil && e.Error() != "EOF" {
+			return nil, e
+		}
+		return data, nil
+	case TEXTURE_COMPRESSED_SOURCE:
+		img, err := LoadTexture(t, false)
+		if err != nil {
+			return nil, err
+		}
+		bd := img.Bounds()
+		if t.Type == TEXTURE_PIXEL_TYPE_USHORT {
+			buf := make([]byte, 0, bd.Dx()*bd.Dy()*8)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+					for _, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+						buf = append(buf, byte(v), byte(v>>8))
+					}
+				}
+			}
+			return buf, nil
+		}
+		buf := make([]byte, 0, bd.Dx()*bd.Dy()*4)
+		for y := bd.Min.Y; y < bd.Max.Y; y++ {
+			for x := bd.Min.X; x < bd.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				buf = append(buf, c.R, c.G, c.B, c.A)
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("mst: unknown texture compression %d", t.Compressed)
+}
+
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(h>>10&0x1f), float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * frac * math.Pow(2, -24)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * (1 + frac/1024) * math.Pow(2, float64(exp-15))
+}
+
+func floatToHalf(v float64) uint16 {
+	bits := math.Float32bits(float32(v))
+	sign := uint16(bits >> 16 & 0x8000)
+	exp := int(bits>>23&0xff) - 127 + 15
+	frac := bits & 0x7fffff
+	switch {
+	case math.IsNaN(v):
+		return 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		frac |= 0x800000
+		return sign | uint16((frac+(1<<uint(13-exp)))>>uint(14-exp))
+	}
+	h := sign | uint16(exp)<<10 | uint16(frac>>13)
+	if frac&0x1000 != 0 {
+		h++
+	}
+	return h
+}
+
+func (t *Texture) Values() ([]float64, error) {
+	if t.Compressed == TEXTURE_COMPRESSED_SOURCE {
+		return nil, &TextureFormatError{Format: t.Format, Type: t.Type}
+	}
+	data, err := t.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	sz := texturePixelBytes(t.Type)
+	n := t.rawSize() / sz
+	if n == 0 {
+		n = len(data) / sz
+	}
+	if len(data) < n*sz {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := make([]float64, n)
+	le := binary.LittleEndian
+	for i := range out {
+		p := data[i*sz:]
+		switch t.Type {
+		case TEXTURE_PIXEL_TYPE_UBYTE:
+			out[i] = float64(p[0])
+		case TEXTURE_PIXEL_TYPE_BYTE:
+			out[i] = float64(int8(p[0]))
+		case TEXTURE_PIXEL_TYPE_USHORT:
+			out[i] = float64(le.Uint16(p))
+		case TEXTURE_PIXEL_TYPE_SHORT:
+			out[i] = float64(int16(le.Uint16(p)))
+		case TEXTURE_PIXEL_TYPE_UINT:
+			out[i] = float64(le.Uint32(p))
+		case TEXTURE_PIXEL_TYPE_INT:
+			out[i] = float64(int32(le.Uint32(p)))
+		case TEXTURE_PIXEL_TYPE_HALF:
+			out[i] = halfToFloat(le.Uint16(p))
+		case TEXTURE_PIXEL_TYPE_FLOAT:
+			out[i] = float64(math.Float32frombits(le.Uint32(p)))
+		default:
+			return nil, fmt.Errorf("mst: unknown texture pixel type %d", t.Type)
+		}
+	}
+	return out, nil
+}
+
+func (t *Texture) SetValues(values []float64) error {
+	if sz := int(t.Size[0]) * int(t.Size[1]) * textureChannels(t.Format); sz > 0 && len(values) != sz {
+		return fmt.Errorf("mst: texture needs %d values, got %d", sz, len(values))
+	}
+	sz := texturePixelBytes(t.Type)
+	data := make([]byte, len(values)*sz)
+	le := binary.LittleEndian
+	for i, v := range values {
+		p := data[i*sz:]
+		switch t.Type {
+		case TEXTURE_PIXEL_TYPE_UBYTE:
+			p[0] = uint8(v)
+		case TEXTURE_PIXEL_TYPE_BYTE:
+			p[0] = uint8(int8(v))
+		case TEXTURE_PIXEL_TYPE_USHORT:
+			le.PutUint16(p, uint16(v))
+		case TEXTURE_PIXEL_TYPE_SHORT:
+			le.PutUint16(p, uint16(int16(v)))
+		case TEXTURE_PIXEL_TYPE_UINT:
+			le.PutUint32(p, uint32(v))
+		case TEXTURE_PIXEL_TYPE_INT:
+			le.PutUint32(p, uint32(int32(v)))
+		case TEXTURE_PIXEL_TYPE_HALF:
+			le.PutUint16(p, floatToHalf(v))
+		case TEXTURE_PIXEL_TYPE_FLOAT:
+			le.PutUint32(p, math.Float32bits(float32(v)))
+		default:
+			return fmt.Errorf("mst: unknown texture pixel type %d", t.Type)
+		}
+	}
+	t.Data, t.Compressed = data, TEXTURE_COMPRESSED_NONE
+	return nil
+}
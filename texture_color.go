@@ -0,0 +1,180 @@
+package mst
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+func textureColorSpaceMarshal(wt io.Writer, tex *Texture) {
+	if tex == nil {
+		return
+	}
+	writeLittleByte(wt, tex.ColorSpace)
+}
+
+func (d *decoder) textureColorSpace(tex *Texture) {
+	if tex == nil {
+		return
+	}
+	d.read(&tex.ColorSpace)
+	if d.err == nil && tex.ColorSpace > TEXTURE_COLOR_SPACE_LINEAR {
+		d.fail(fmt.Errorf("mst: unknown texture color space %d", tex.ColorSpace))
+	}
+}
+
+func textureColorSpacesMarshal(wt io.Writer, mtl MeshMaterial) {
+	if tm := materialTextures(mtl); tm != nil {
+		textureColorSpaceMarshal(wt, tm.Texture)
+		textureColorSpaceMarshal(wt, tm.Normal)
+	}
+}
+
+func (d *decoder) textureColorSpaces(mtl MeshMaterial) {
+	if tm := materialTextures(mtl); tm != nil {
+		d.textureColorSpace(tm.Texture)
+		d.textureColorSpace(tm.Normal)
+	}
+}
+
+func anyTexture(ms *Mesh, fn func(tex *Texture) bool) bool {
+	uses := func(mtls []MeshMaterial) bool {
+		for _, mtl := range mtls {
+			if tm := materialTextures(mtl); tm != nil && (tm.Texture != nil && fn(tm.Texture) || tm.Normal != nil && fn(tm.Normal)) {
+				return true
+			}
+		}
+		return false
+	}
+	if uses(ms.Materials) {
+		return true
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh != nil && uses(inst.Mesh.Materials) {
+			return true
+		}
+	}
+	return anyNode(ms, func(nd *MeshNode) bool { return nd.Lightmap != nil && fn(nd.Lightmap) })
+}
+
+func hasTextureColorSpaces(ms *Mesh) bool {
+	return anyTexture(ms, func(tex *Texture) bool { return tex.ColorSpace != TEXTURE_COLOR_SPACE_DEFAULT })
+}
+
+func dropTextureColorSpaces(ms *Mesh, warns []Warning) (*Mesh, []Warning) {
+	out := mapMeshTextures(ms, func(tex *Texture) *Texture {
+		if tex.ColorSpace == TEXTURE_COLOR_SPACE_DEFAULT {
+			return tex
+		}
+		cp := *tex
+		cp.ColorSpace = TEXTURE_COLOR_SPACE_DEFAULT
+		return &cp
+	})
+	return out, append(warns, Warning{Field: "textures", Message: "dropped texture color spaces"})
+}
+
+func (t *Texture) IsLinear(colorData bool) bool {
+	switch t.ColorSpace {
+	case TEXTURE_COLOR_SPACE_SRGB:
+		return false
+	case TEXTURE_COLOR_SPACE_LINEAR:
+		return true
+	}
+	return !colorData
+}
+
+func SRGBToLinear(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func LinearToSRGB(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+func isWideImage(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+func convertImageColorSpace(src image.Image, toLinear bool) image.Image {
+	conv := LinearToSRGB
+	if toLinear {
+		conv = SRGBToLinear
+	}
+	var lut [65536]uint16
+	for i := range lut {
+		lut[i] = uint16(math.Round(conv(float64(i)/65535) * 65535))
+	}
+	bd := src.Bounds()
+	if isWideImage(src) {
+		dst := image.NewNRGBA64(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+		for y := 0; y < bd.Dy(); y++ {
+			for x := 0; x < bd.Dx(); x++ {
+				c := color.NRGBA64Model.Convert(src.At(bd.Min.X+x, bd.Min.Y+y)).(color.NRGBA64)
+				dst.SetNRGBA64(x, y, color.NRGBA64{R: lut[c.R], G: lut[c.G], B: lut[c.B], A: c.A})
+			}
+		}
+		return dst
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+	for y := 0; y < bd.Dy(); y++ {
+		for x := 0; x < bd.Dx(); x++ {
+			c := color.NRGBAModel.Convert(src.At(bd.Min.X+x, bd.Min.Y+y)).(color.NRGBA)
+			dst.SetNRGBA(x, y, color.NRGBA{R: byte(lut[uint16(c.R)*0x101] >> 8), G: byte(lut[uint16(c.G)*0x101] >> 8), B: byte(lut[uint16(c.B)*0x101] >> 8), A: c.A})
+		}
+	}
+	return dst
+}
+
+func imageTexture(img image.Image) (*Texture, error) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	bd := img.Bounds()
+	tex := &Texture{
+		Size:       [2]uint64{uint64(bd.Dx()), uint64(bd.Dy())},
+		Format:     TEXTURE_FORMAT_RGBA,
+		Compressed: TEXTURE_COMPRESSED_SOURCE,
+		Data:       buf.Bytes(),
+		Repeated:   true,
+	}
+	if isWideImage(img) {
+		tex.Type = TEXTURE_PIXEL_TYPE_USHORT
+	}
+	return tex, nil
+}
+
+func (t *Texture) ConvertColorSpace(target uint8, colorData bool) (*Texture, error) {
+	if target != TEXTURE_COLOR_SPACE_SRGB && target != TEXTURE_COLOR_SPACE_LINEAR {
+		return nil, fmt.Errorf("mst: unknown texture color space %d", target)
+	}
+	if t.IsLinear(colorData) == (target == TEXTURE_COLOR_SPACE_LINEAR) {
+		cp := *t
+		cp.ColorSpace = target
+		return &cp, nil
+	}
+	img, err := LoadTexture(t, false)
+	if err != nil {
+		return nil, err
+	}
+	out, err := imageTexture(convertImageColorSpace(img, target == TEXTURE_COLOR_SPACE_LINEAR))
+	if err != nil {
+		return nil, err
+	}
+	out.Id, out.Name, out.Repeated, out.URI, out.ColorSpace = t.Id, t.Name, t.Repeated, t.URI, target
+	return out, nil
+}
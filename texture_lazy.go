@@ -0,0 +1,293 @@
+package mst
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"fmt"
+	"hash/crc64"
+	"image"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const DEFAULT_TEXTURE_CACHE_BUDGET = 64 << 20
+
+var textureCacheTable = crc64.MakeTable(crc64.ECMA)
+
+type textureCacheKey struct {
+	sum        uint64
+	size       int
+	compressed uint16
+	format     uint16
+	typ        uint16
+}
+
+type textureCacheEntry struct {
+	key  textureCacheKey
+	data []byte
+}
+
+type TextureCacheStats struct {
+	Entries int
+	Bytes   int64
+	Budget  int64
+	Hits    int64
+	Misses  int64
+}
+
+type TextureCache struct {
+	mu     sync.Mutex
+	budget int64
+	used   int64
+	hits   int64
+	misses int64
+	lru    *list.List
+	items  map[textureCacheKey]*list.Element
+}
+
+func NewTextureCache(budget int64) *TextureCache {
+	return &TextureCache{budget: budget, lru: list.New(), items: make(map[textureCacheKey]*list.Element)}
+}
+
+var textureCache = NewTextureCache(DEFAULT_TEXTURE_CACHE_BUDGET)
+
+func SetTextureCacheBudget(budget int64) {
+	textureCache.SetBudget(budget)
+}
+
+func GetTextureCacheStats() TextureCacheStats {
+	return textureCache.Stats()
+}
+
+func PurgeTextureCache() {
+	textureCache.Purge()
+}
+
+func cacheKeyOf(t *Texture) textureCacheKey {
+	return textureCacheKey{
+		sum:        crc64.Checksum(t.Data, textureCacheTable),
+		size:       len(t.Data),
+		compressed: t.Compressed,
+		format:     t.Format,
+		typ:        t.Type,
+	}
+}
+
+// Pixels returns the decoded pixels of t, sharing the slice with the cache.
+// Callers must not modify it.
+func (c *TextureCache) Pixels(t *Texture) ([]byte, error) {
+	if t.Compressed == TEXTURE_COMPRESSED_NONE || len(t.Data) == 0 {
+		return t.Pixels()
+	}
+	key := cacheKeyOf(t)
+	if data, ok := c.get(key); ok {
+		return data, nil
+	}
+	data, err := t.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, data)
+	return data, nil
+}
+
+func (c *TextureCache) get(key textureCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*textureCacheEntry).data, true
+}
+
+func (c *TextureCache) put(key textureCacheKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(data)) > c.budget {
+		return
+	}
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.lru.PushFront(&textureCacheEntry{key: key, data: data})
+	c.used += int64(len(data))
+	c.evict()
+}
+
+func (c *TextureCache) evict() {
+	for c.used > c.budget {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		e := c.lru.Remove(el).(*textureCacheEntry)
+		delete(c.items, e.key)
+		c.used -= int64(len(e.data))
+	}
+}
+
+func (c *TextureCache) SetBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budget = budget
+	c.evict()
+}
+
+func (c *TextureCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.items = make(map[textureCacheKey]*list.Element)
+	c.used = 0
+}
+
+func (c *TextureCache) Stats() TextureCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return TextureCacheStats{Entries: c.lru.Len(), Bytes: c.used, Budget: c.budget, Hits: c.hits, Misses: c.misses}
+}
+
+type LazyTexture struct {
+	tex    *Texture
+	cache  *TextureCache
+	width  int
+	height int
+	pixel  int
+	stride int
+
+	mu   sync.Mutex
+	zr   io.ReadCloser
+	zoff int
+}
+
+func NewLazyTexture(tex *Texture, cache *TextureCache) (*LazyTexture, error) {
+	if tex.IsExternal() {
+		return nil, ErrUnresolvedTexture
+	}
+	if err := tex.Validate(); err != nil {
+		return nil, err
+	}
+	if cache == nil {
+		cache = textureCache
+	}
+	l := &LazyTexture{tex: tex, cache: cache, width: int(tex.Size[0]), height: int(tex.Size[1])}
+	px := textureChannels(tex.Format) * texturePixelBytes(tex.Type)
+	if tex.Compressed == TEXTURE_COMPRESSED_SOURCE {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(tex.Data))
+		if err != nil {
+			return nil, err
+		}
+		l.width, l.height = cfg.Width, cfg.Height
+		px = 4 * texturePixelBytes(tex.Type)
+	}
+	if px == 0 {
+		return nil, &TextureFormatError{Format: tex.Format, Type: tex.Type}
+	}
+	l.pixel, l.stride = px, l.width*px
+	return l, nil
+}
+
+func (t *Texture) Lazy() (*LazyTexture, error) {
+	return NewLazyTexture(t, nil)
+}
+
+func (l *LazyTexture) Texture() *Texture {
+	return l.tex
+}
+
+func (l *LazyTexture) Bounds() image.Rectangle {
+	return image.Rect(0, 0, l.width, l.height)
+}
+
+func (l *LazyTexture) Stride() int {
+	return l.stride
+}
+
+func (l *LazyTexture) Pixels() ([]byte, error) {
+	return l.cache.Pixels(l.tex)
+}
+
+func (l *LazyTexture) Rows(y0, y1 int) ([]byte, error) {
+	if y0 < 0 || y1 > l.height || y0 > y1 {
+		return nil, fmt.Errorf("mst: texture rows %d-%d outside height %d", y0, y1, l.height)
+	}
+	lo, hi := y0*l.stride, y1*l.stride
+	switch l.tex.Compressed {
+	case TEXTURE_COMPRESSED_NONE:
+		if len(l.tex.Data) < hi {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return append([]byte(nil), l.tex.Data[lo:hi]...), nil
+	case TEXTURE_COMPRESSED_ZLIB:
+		if data, ok := l.cache.get(cacheKeyOf(l.tex)); ok {
+			return rowsOf(data, lo, hi)
+		}
+		return l.inflateRows(lo, hi)
+	}
+	data, err := l.Pixels()
+	if err != nil {
+		return nil, err
+	}
+	return rowsOf(data, lo, hi)
+}
+
+func rowsOf(data []byte, lo, hi int) ([]byte, error) {
+	if len(data) < hi {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return append([]byte(nil), data[lo:hi]...), nil
+}
+
+func (l *LazyTexture) inflateRows(lo, hi int) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.zr == nil || lo < l.zoff {
+		l.closeInflater()
+		r, err := zlib.NewReader(bytes.NewReader(l.tex.Data))
+		if err != nil {
+			return nil, err
+		}
+		l.zr = r
+	}
+	if _, err := io.CopyN(ioutil.Discard, l.zr, int64(lo-l.zoff)); err != nil {
+		l.closeInflater()
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, hi-lo)
+	if _, err := io.ReadFull(l.zr, buf); err != nil {
+		l.closeInflater()
+		return nil, io.ErrUnexpectedEOF
+	}
+	l.zoff = hi
+	return buf, nil
+}
+
+func (l *LazyTexture) closeInflater() {
+	if l.zr != nil {
+		l.zr.Close()
+	}
+	l.zr, l.zoff = nil, 0
+}
+
+func (l *LazyTexture) Tile(x, y, w, h int) ([]byte, error) {
+	if x < 0 || w < 0 || x+w > l.width {
+		return nil, fmt.Errorf("mst: texture columns %d-%d outside width %d", x, x+w, l.width)
+	}
+	rows, err := l.Rows(y, y+h)
+	if err != nil {
+		return nil, err
+	}
+	px := l.pixel
+	out := make([]byte, 0, w*h*px)
+	for i := 0; i < h; i++ {
+		row := rows[i*l.stride:]
+		out = append(out, row[x*px:(x+w)*px]...)
+	}
+	return out, nil
+}
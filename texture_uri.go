@@ -0,0 +1,292 @@
+package mst
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrUnresolvedTexture = errors.New("mst: texture references external data that was not resolved")
+
+type TextureResolver interface {
+	ResolveTexture(tex *Texture) ([]byte, error)
+}
+
+type TextureResolverFunc func(tex *Texture) ([]byte, error)
+
+func (f TextureResolverFunc) ResolveTexture(tex *Texture) ([]byte, error) {
+	return f(tex)
+}
+
+func WithExternalTextures() WriteOption {
+	return func(o *writeOptions) {
+		o.externalTextures = true
+	}
+}
+
+func (t *Texture) IsExternal() bool {
+	return t.URI != "" && len(t.Data) == 0
+}
+
+func textureURIMarshal(wt io.Writer, tex *Texture) {
+	if tex == nil {
+		return
+	}
+	writeLittleByte(wt, uint32(len(tex.URI)))
+	wt.Write([]byte(tex.URI))
+}
+
+func (d *decoder) textureURI(tex *Texture) {
+	if tex == nil {
+		return
+	}
+	tex.URI = d.string("texture uri length")
+	if d.err == nil && d.limits != nil && len(tex.Data) == 0 {
+		if err := tex.Validate(); err != nil {
+			d.fail(err)
+		}
+	}
+}
+
+func textureURIsMarshal(wt io.Writer, mtl MeshMaterial) {
+	if tm := materialTextures(mtl); tm != nil {
+		textureURIMarshal(wt, tm.Texture)
+		textureURIMarshal(wt, tm.Normal)
+	}
+}
+
+func (d *decoder) textureURIs(mtl MeshMaterial) {
+	if tm := materialTextures(mtl); tm != nil {
+		d.textureURI(tm.Texture)
+		d.textureURI(tm.Normal)
+	}
+}
+
+func hasTextureURIs(ms *Mesh) bool {
+	return anyTexture(ms, func(tex *Texture) bool { return tex.URI != "" })
+}
+
+func mapMaterialTextures(mtls []MeshMaterial, fn func(tex *Texture) *Texture) []MeshMaterial {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		if _, ok := mtl.(*MaterialRef); ok {
+			continue
+		}
+		tm := materialTextures(mtl)
+		if tm == nil {
+			continue
+		}
+		tex, nrm := tm.Texture, tm.Normal
+		if tex != nil {
+			tex = fn(tex)
+		}
+		if nrm != nil {
+			nrm = fn(nrm)
+		}
+		if tex == tm.Texture && nrm == tm.Normal {
+			continue
+		}
+		if out == nil {
+			out = append([]MeshMaterial(nil), mtls...)
+		}
+		cp := cloneMaterial(resolveMaterial(mtl))
+		ct := materialTextures(cp)
+		ct.Texture, ct.Normal = tex, nrm
+		out[i] = cp
+	}
+	return out
+}
+
+func mapLightmaps(nds []*MeshNode, fn func(tex *Texture) *Texture) []*MeshNode {
+	var out []*MeshNode
+	for i, nd := range nds {
+		if nd.Lightmap == nil {
+			continue
+		}
+		tex := fn(nd.Lightmap)
+		if tex == nd.Lightmap {
+			continue
+		}
+		if out == nil {
+			out = append([]*MeshNode(nil), nds...)
+		}
+		cp := *nd
+		cp.Lightmap = tex
+		out[i] = &cp
+	}
+	return out
+}
+
+func mapMeshTextures(ms *Mesh, fn func(tex *Texture) *Texture) *Mesh {
+	out := *ms
+	if mtls := mapMaterialTextures(ms.Materials, fn); mtls != nil {
+		out.Materials = mtls
+	}
+	if nds := mapLightmaps(ms.Nodes, fn); nds != nil {
+		out.Nodes = nds
+	}
+	out.InstanceNode = make([]*InstanceMesh, len(ms.InstanceNode))
+	for i, inst := range ms.InstanceNode {
+		out.InstanceNode[i] = inst
+		if inst.Mesh == nil {
+			continue
+		}
+		mtls, nds := mapMaterialTextures(inst.Mesh.Materials, fn), mapLightmaps(inst.Mesh.Nodes, fn)
+		if mtls == nil && nds == nil {
+			continue
+		}
+		bm := *inst.Mesh
+		if mtls != nil {
+			bm.Materials = mtls
+		}
+		if nds != nil {
+			bm.Nodes = nds
+		}
+		cp := *inst
+		cp.Mesh = &bm
+		out.InstanceNode[i] = &cp
+	}
+	if ms.InstanceNode == nil {
+		out.InstanceNode = nil
+	}
+	return &out
+}
+
+func externalTextures(ms *Mesh) *Mesh {
+	return mapMeshTextures(ms, func(tex *Texture) *Texture {
+		if tex.URI == "" || len(tex.Data) == 0 {
+			return tex
+		}
+		cp := *tex
+		cp.Data = nil
+		return &cp
+	})
+}
+
+func dropTextureURIs(ms *Mesh, warns []Warning) (*Mesh, []Warning) {
+	external := 0
+	out := mapMeshTextures(ms, func(tex *Texture) *Texture {
+		if tex.URI == "" {
+			return tex
+		}
+		if len(tex.Data) == 0 {
+			external++
+		}
+		cp := *tex
+		cp.URI = ""
+		return &cp
+	})
+	msg := "dropped texture uris"
+	if external > 0 {
+		msg = fmt.Sprintf("dropped texture uris, %d external textures have no embedded data", external)
+	}
+	return out, append(warns, Warning{Field: "textures", Message: msg})
+}
+
+func (m *Mesh) ResolveTextures(r TextureResolver) error {
+	var err error
+	resolve := func(tex *Texture) {
+		if err != nil || tex == nil || !tex.IsExternal() {
+			return
+		}
+		var data []byte
+		if data, err = r.ResolveTexture(tex); err == nil {
+			tex.Data = data
+		}
+	}
+	resolveMtls := func(mtls []MeshMaterial) {
+		for _, mtl := range mtls {
+			if tm := materialTextures(mtl); tm != nil {
+				resolve(tm.Texture)
+				resolve(tm.Normal)
+			}
+		}
+	}
+	resolveMtls(m.Materials)
+	for _, inst := range m.InstanceNode {
+		if inst.Mesh != nil {
+			resolveMtls(inst.Mesh.Materials)
+		}
+	}
+	anyNode(m, func(nd *MeshNode) bool {
+		resolve(nd.Lightmap)
+		return err != nil
+	})
+	return err
+}
+
+type FileTextureResolver struct {
+	Dir   string
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+func NewFileTextureResolver(dir string) *FileTextureResolver {
+	return &FileTextureResolver{Dir: dir, cache: make(map[string][]byte)}
+}
+
+func (r *FileTextureResolver) ResolveTexture(tex *Texture) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.cache[tex.URI]; ok {
+		return data, nil
+	}
+	path := tex.URI
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.Dir, filepath.FromSlash(path))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[tex.URI] = data
+	return data, nil
+}
+
+type TextureFetchError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *TextureFetchError) Error() string {
+	return fmt.Sprintf("mst: texture request to %s failed with status %d", e.URL, e.StatusCode)
+}
+
+type HTTPTextureResolver struct {
+	BaseURL string
+	Client  *http.Client
+	Context context.Context
+}
+
+func (r *HTTPTextureResolver) ResolveTexture(tex *Texture) ([]byte, error) {
+	url := tex.URI
+	if !strings.Contains(url, "://") {
+		url = strings.TrimSuffix(r.BaseURL, "/") + "/" + strings.TrimPrefix(url, "/")
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.Context != nil {
+		req = req.WithContext(r.Context)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &TextureFetchError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,893 @@
+package mst
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	THREEJS_FACE_QUAD          = 1 << 0
+	THREEJS_FACE_MATERIAL      = 1 << 1
+	THREEJS_FACE_UV            = 1 << 2
+	THREEJS_FACE_VERTEX_UV     = 1 << 3
+	THREEJS_FACE_NORMAL        = 1 << 4
+	THREEJS_FACE_VERTEX_NORMAL = 1 << 5
+	THREEJS_FACE_COLOR         = 1 << 6
+	THREEJS_FACE_VERTEX_COLOR  = 1 << 7
+	THREEJS_DEFAULT_COLOR      = 0xffffff
+	THREEJS_WRAP_REPEAT        = 1000
+	THREEJS_ENCODING_LINEAR    = 3000
+	THREEJS_ENCODING_SRGB      = 3001
+)
+
+const (
+	threejsMaxHierarchyDepth        = 1024
+	threejsMaxDefaultMaterialGroups = 256
+)
+
+var (
+	ErrThreejsBinary  = errors.New("mst: three.js binary geometry is not supported")
+	ErrThreejsUnknown = errors.New("mst: unrecognized three.js JSON document")
+)
+
+type ThreejsImportOptions struct {
+	BaseDir         string
+	ContinueOnError bool
+	Logger          Logger
+}
+
+type threejsAttribute struct {
+	ItemSize   int       `json:"itemSize"`
+	Type       string    `json:"type"`
+	Array      []float64 `json:"array"`
+	Normalized bool      `json:"normalized"`
+}
+
+var threejsNormalizeScale = map[string]float64{
+	"Int8Array":         127,
+	"Uint8Array":        255,
+	"Uint8ClampedArray": 255,
+	"Int16Array":        32767,
+	"Uint16Array":       65535,
+	"Int32Array":        2147483647,
+	"Uint32Array":       4294967295,
+}
+
+func (a *threejsAttribute) count() int {
+	if a.ItemSize <= 0 {
+		return 0
+	}
+	return len(a.Array) / a.ItemSize
+}
+
+func (a *threejsAttribute) item(i, c int) float64 {
+	if c >= a.ItemSize {
+		return 0
+	}
+	v := a.Array[i*a.ItemSize+c]
+	if s, ok := threejsNormalizeScale[a.Type]; ok && a.Normalized {
+		v = math.Max(v/s, -1)
+	}
+	return v
+}
+
+type threejsGroup struct {
+	Start         int      `json:"start"`
+	Count         *float64 `json:"count"`
+	MaterialIndex int32    `json:"materialIndex"`
+}
+
+type threejsBufferGeometry struct {
+	Attributes map[string]*threejsAttribute `json:"attributes"`
+	Index      *threejsAttribute            `json:"index"`
+	Groups     []threejsGroup               `json:"groups"`
+}
+
+type threejsLegacyGeometry struct {
+	Scale    float64     `json:"scale"`
+	Vertices []float64   `json:"vertices"`
+	Normals  []float64   `json:"normals"`
+	Uvs      [][]float64 `json:"uvs"`
+	Colors   []uint32    `json:"colors"`
+	Faces    []uint32    `json:"faces"`
+}
+
+type threejsGeometry struct {
+	UUID string          `json:"uuid"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type threejsMaterial struct {
+	UUID        string   `json:"uuid"`
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Color       *uint32  `json:"color"`
+	Emissive    uint32   `json:"emissive"`
+	Specular    *uint32  `json:"specular"`
+	Shininess   *float32 `json:"shininess"`
+	Roughness   *float32 `json:"roughness"`
+	Metalness   *float32 `json:"metalness"`
+	Opacity     *float32 `json:"opacity"`
+	Transparent bool     `json:"transparent"`
+	Map         string   `json:"map"`
+	NormalMap   string   `json:"normalMap"`
+
+	DbgName       string    `json:"DbgName"`
+	Shading       string    `json:"shading"`
+	ColorDiffuse  []float32 `json:"colorDiffuse"`
+	ColorSpecular []float32 `json:"colorSpecular"`
+	ColorEmissive []float32 `json:"colorEmissive"`
+	SpecularCoef  *float32  `json:"specularCoef"`
+	Transparency  *float32  `json:"transparency"`
+	MapDiffuse    string    `json:"mapDiffuse"`
+	MapNormal     string    `json:"mapNormal"`
+}
+
+type threejsTexture struct {
+	UUID       string `json:"uuid"`
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	Wrap       []int  `json:"wrap"`
+	FlipY      *bool  `json:"flipY"`
+	Encoding   int    `json:"encoding"`
+	ColorSpace string `json:"colorSpace"`
+}
+
+func (tt *threejsTexture) colorSpace() uint8 {
+	switch {
+	case tt.ColorSpace == "srgb" || tt.Encoding == THREEJS_ENCODING_SRGB:
+		return TEXTURE_COLOR_SPACE_SRGB
+	case tt.ColorSpace == "srgb-linear" || tt.Encoding == THREEJS_ENCODING_LINEAR:
+		return TEXTURE_COLOR_SPACE_LINEAR
+	}
+	return TEXTURE_COLOR_SPACE_DEFAULT
+}
+
+type threejsImage struct {
+	UUID string          `json:"uuid"`
+	URL  json.RawMessage `json:"url"`
+}
+
+type threejsObject struct {
+	UUID           string                 `json:"uuid"`
+	Type           string                 `json:"type"`
+	Name           string                 `json:"name"`
+	Matrix         []float64              `json:"matrix"`
+	Geometry       string                 `json:"geometry"`
+	Material       json.RawMessage        `json:"material"`
+	Count          int                    `json:"count"`
+	InstanceMatrix *threejsAttribute      `json:"instanceMatrix"`
+	UserData       map[string]interface{} `json:"userData"`
+	Children       []*threejsObject       `json:"children"`
+}
+
+type threejsDocument struct {
+	Metadata struct {
+		Type          string  `json:"type"`
+		FormatVersion float64 `json:"formatVersion"`
+	} `json:"metadata"`
+	Type       string             `json:"type"`
+	Data       json.RawMessage    `json:"data"`
+	Buffers    string             `json:"buffers"`
+	Geometries []*threejsGeometry `json:"geometries"`
+	Materials  []*threejsMaterial `json:"materials"`
+	Textures   []*threejsTexture  `json:"textures"`
+	Images     []*threejsImage    `json:"images"`
+	Object     *threejsObject     `json:"object"`
+	threejsLegacyGeometry
+}
+
+type threejsImporter struct {
+	doc        *threejsDocument
+	opts       *ThreejsImportOptions
+	ec         *errorCollector
+	ms         *Mesh
+	geometries map[string]*threejsGeometry
+	nodes      map[string]*MeshNode
+	materials  map[string]int32
+	textures   map[string]*Texture
+	resolver   TextureResolver
+}
+
+func newThreejsImporter(doc *threejsDocument, opts *ThreejsImportOptions) *threejsImporter {
+	return &threejsImporter{
+		doc:        doc,
+		opts:       opts,
+		ec:         newErrorCollector(opts.ContinueOnError, opts.Logger),
+		ms:         NewMesh(),
+		geometries: make(map[string]*threejsGeometry),
+		nodes:      make(map[string]*MeshNode),
+		materials:  make(map[string]int32),
+		textures:   make(map[string]*Texture),
+	}
+}
+
+func ThreejsToMst(data []byte, opts *ThreejsImportOptions) (*Mesh, error) {
+	if opts == nil {
+		opts = &ThreejsImportOptions{}
+	}
+	doc := &threejsDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	imp := newThreejsImporter(doc, opts)
+	imp.resolver = TextureResolverFunc(func(tex *Texture) ([]byte, error) {
+		return readGltfResource(opts.BaseDir, tex.URI)
+	})
+	var err error
+	switch {
+	case doc.Buffers != "":
+		err = ErrThreejsBinary
+	case doc.Object != nil:
+		err = imp.scene()
+	case doc.Type == "BufferGeometry" || doc.Metadata.Type == "BufferGeometry":
+		err = imp.geometryOnly(&threejsGeometry{Type: "BufferGeometry", Data: doc.Data})
+	case doc.Type == "Geometry" || doc.Metadata.Type == "Geometry":
+		err = imp.geometryOnly(&threejsGeometry{Type: "Geometry", Data: doc.Data})
+	case len(doc.Faces) > 0:
+		err = imp.legacy()
+	default:
+		err = ErrThreejsUnknown
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(imp.ms.Props) > 0 || anyNode(imp.ms, func(nd *MeshNode) bool { return len(nd.Props) > 0 }) {
+		imp.ms.Version = V8
+	}
+	return imp.ms, imp.ec.err()
+}
+
+func ThreejsToMstFromReader(rd io.Reader, opts *ThreejsImportOptions) (*Mesh, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	return ThreejsToMst(data, opts)
+}
+
+func ThreejsToMstFromFile(path string, opts *ThreejsImportOptions) (*Mesh, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	o := ThreejsImportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BaseDir == "" {
+		o.BaseDir = filepath.Dir(path)
+	}
+	return ThreejsToMst(data, &o)
+}
+
+func threejsColor(c uint32) [3]byte {
+	return [3]byte{byte(c >> 16), byte(c >> 8), byte(c)}
+}
+
+func threejsMatrix(m []float64) (dmat.T, error) {
+	if len(m) == 0 {
+		return dmat.Ident, nil
+	}
+	if len(m) != 16 {
+		return dmat.T{}, fmt.Errorf("mst: three.js matrix has %d elements", len(m))
+	}
+	var mt dmat.T
+	for c := 0; c < 4; c++ {
+		for r := 0; r < 4; r++ {
+			mt[c][r] = m[c*4+r]
+		}
+	}
+	return mt, nil
+}
+
+func (imp *threejsImporter) image(uuid string) ([]byte, error) {
+	for _, img := range imp.doc.Images {
+		if img.UUID != uuid {
+			continue
+		}
+		var url string
+		if err := json.Unmarshal(img.URL, &url); err != nil {
+			return nil, fmt.Errorf("mst: three.js image %s is not a single URL", uuid)
+		}
+		return readGltfResource(imp.opts.BaseDir, url)
+	}
+	return nil, fmt.Errorf("mst: three.js image %s not found", uuid)
+}
+
+func (imp *threejsImporter) texture(uuid string) (*Texture, error) {
+	if tex, ok := imp.textures[uuid]; ok {
+		return tex, nil
+	}
+	for _, tt := range imp.doc.Textures {
+		if tt.UUID != uuid {
+			continue
+		}
+		data, err := imp.image(tt.Image)
+		if err != nil {
+			return nil, err
+		}
+		tex, err := sourceTexture(data, tt.FlipY != nil && !*tt.FlipY)
+		if err != nil {
+			return nil, err
+		}
+		tex.Id, tex.Name = int32(len(imp.textures)), tt.Name
+		tex.Repeated = len(tt.Wrap) > 0 && tt.Wrap[0] == THREEJS_WRAP_REPEAT
+		tex.ColorSpace = tt.colorSpace()
+		imp.textures[uuid] = tex
+		return tex, nil
+	}
+	return nil, fmt.Errorf("mst: three.js texture %s not found", uuid)
+}
+
+func (imp *threejsImporter) legacyTexture(name string) (*Texture, error) {
+	if tex, ok := imp.textures[name]; ok {
+		return tex, nil
+	}
+	id, base := int32(len(imp.textures)), strings.TrimSuffix(name, filepath.Ext(name))
+	if imp.resolver == nil {
+		tex := &Texture{Id: id, Name: base, URI: name, Repeated: true}
+		imp.textures[name] = tex
+		return tex, nil
+	}
+	data, err := imp.resolver.ResolveTexture(&Texture{Id: id, Name: base, URI: name})
+	if err != nil {
+		return nil, err
+	}
+	tex, err := sourceTexture(data, false)
+	if err != nil {
+		return nil, err
+	}
+	tex.Id, tex.Name = id, base
+	imp.textures[name] = tex
+	return tex, nil
+}
+
+func (imp *threejsImporter) material(i int, tm *threejsMaterial) (MeshMaterial, error) {
+	field := fmt.Sprintf("materials[%d]", i)
+	name := tm.Name
+	if name == "" {
+		name = tm.DbgName
+	}
+	color := threejsColor(THREEJS_DEFAULT_COLOR)
+	if tm.Color != nil {
+		color = threejsColor(*tm.Color)
+	} else if len(tm.ColorDiffuse) >= 3 {
+		color = colorBytes(tm.ColorDiffuse)
+	}
+	opacity := float32(1)
+	if tm.Opacity != nil && (tm.Transparent || tm.Type == "") {
+		opacity = *tm.Opacity
+	} else if tm.Transparency != nil {
+		opacity = *tm.Transparency
+	}
+	tx := TextureMaterial{BaseMaterial: BaseMaterial{Name: name, Color: color, Transparency: 1 - opacity}}
+	load := func(key, uuid, legacy string, space uint8) (*Texture, error) {
+		var tex *Texture
+		var err error
+		switch {
+		case uuid != "":
+			tex, err = imp.texture(uuid)
+		case legacy != "":
+			tex, err = imp.legacyTexture(legacy)
+		default:
+			return nil, nil
+		}
+		if err := imp.ec.report(field+"."+key, err); err != nil {
+			return nil, err
+		}
+		if tex != nil && tex.ColorSpace == TEXTURE_COLOR_SPACE_DEFAULT {
+			tex.ColorSpace = space
+		}
+		return tex, nil
+	}
+	var err error
+	if tx.Texture, err = load("texture", tm.Map, tm.MapDiffuse, TEXTURE_COLOR_SPACE_SRGB); err != nil {
+		return nil, err
+	}
+	if tx.Normal, err = load("normal", tm.NormalMap, tm.MapNormal, TEXTURE_COLOR_SPACE_LINEAR); err != nil {
+		return nil, err
+	}
+	emissive := threejsColor(tm.Emissive)
+	if len(tm.ColorEmissive) >= 3 {
+		emissive = colorBytes(tm.ColorEmissive)
+	}
+
+	switch {
+	case tm.Type == "MeshStandardMaterial" || tm.Type == "MeshPhysicalMaterial":
+		pbr := &PbrMaterial{TextureMaterial: tx, Emissive: emissive, Metallic: 0, Roughness: 1}
+		if tm.Metalness != nil {
+			pbr.Metallic = *tm.Metalness
+		}
+		if tm.Roughness != nil {
+			pbr.Roughness = *tm.Roughness
+		}
+		return pbr, nil
+	case tm.Type == "MeshPhongMaterial" || (tm.Type == "" && (strings.EqualFold(tm.Shading, "phong") || tm.SpecularCoef != nil)):
+		ph := &PhongMaterial{LambertMaterial: LambertMaterial{TextureMaterial: tx, Diffuse: color, Emissive: emissive}}
+		if tm.Specular != nil {
+			ph.Specular = threejsColor(*tm.Specular)
+		} else if len(tm.ColorSpecular) >= 3 {
+			ph.Specular = colorBytes(tm.ColorSpecular)
+		}
+		if tm.Shininess != nil {
+			ph.Shininess = *tm.Shininess
+		} else if tm.SpecularCoef != nil {
+			ph.Shininess = *tm.SpecularCoef
+		}
+		return ph, nil
+	case tm.Type == "MeshLambertMaterial" || (tm.Type == "" && strings.EqualFold(tm.Shading, "lambert")):
+		return &LambertMaterial{TextureMaterial: tx, Diffuse: color, Emissive: emissive}, nil
+	}
+	if tx.Texture == nil && tx.Normal == nil {
+		return &tx.BaseMaterial, nil
+	}
+	return &tx, nil
+}
+
+func (imp *threejsImporter) addMaterials() error {
+	for i, tm := range imp.doc.Materials {
+		mtl, err := imp.material(i, tm)
+		if err != nil {
+			return err
+		}
+		if tm.UUID != "" {
+			imp.materials[tm.UUID] = int32(len(imp.ms.Materials))
+		}
+		imp.ms.Materials = append(imp.ms.Materials, mtl)
+	}
+	return nil
+}
+
+func (imp *threejsImporter) defaultMaterials(nd *MeshNode) error {
+	n := 1
+	for _, g := range nd.FaceGroup {
+		if g.Batchid < 0 || g.Batchid >= threejsMaxDefaultMaterialGroups {
+			return fmt.Errorf("mst: three.js material index %d out of range", g.Batchid)
+		}
+		if int(g.Batchid)+1 > n {
+			n = int(g.Batchid) + 1
+		}
+	}
+	for i := 0; i < n; i++ {
+		imp.ms.Materials = append(imp.ms.Materials, &BaseMaterial{Color: threejsColor(THREEJS_DEFAULT_COLOR)})
+	}
+	return nil
+}
+
+func threejsVec3s(a *threejsAttribute, n int, name string) ([]vec3.T, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if a.count() != n {
+		return nil, fmt.Errorf("mst: three.js %s attribute has %d items, expected %d", name, a.count(), n)
+	}
+	out := make([]vec3.T, n)
+	for i := range out {
+		out[i] = vec3.T{float32(a.item(i, 0)), float32(a.item(i, 1)), float32(a.item(i, 2))}
+	}
+	return out, nil
+}
+
+func threejsVec2s(a *threejsAttribute, n int, name string) ([]vec2.T, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if a.count() != n {
+		return nil, fmt.Errorf("mst: three.js %s attribute has %d items, expected %d", name, a.count(), n)
+	}
+	out := make([]vec2.T, n)
+	for i := range out {
+		out[i] = vec2.T{float32(a.item(i, 0)), float32(a.item(i, 1))}
+	}
+	return out, nil
+}
+
+func (imp *threejsImporter) bufferGeometry(g *threejsBufferGeometry) (*MeshNode, error) {
+	pos := g.Attributes["position"]
+	if pos == nil || pos.ItemSize <= 0 {
+		return nil, fmt.Errorf("mst: three.js geometry has no position attribute")
+	}
+	n := pos.count()
+	nd := &MeshNode{}
+	var err error
+	if nd.Vertices, err = threejsVec3s(pos, n, "position"); err != nil {
+		return nil, err
+	}
+	if nd.Normals, err = threejsVec3s(g.Attributes["normal"], n, "normal"); err != nil {
+		return nil, err
+	}
+	if nd.TexCoords, err = threejsVec2s(g.Attributes["uv"], n, "uv"); err != nil {
+		return nil, err
+	}
+	uv2 := g.Attributes["uv1"]
+	if uv2 == nil {
+		uv2 = g.Attributes["uv2"]
+	}
+	if nd.TexCoords2, err = threejsVec2s(uv2, n, "uv1"); err != nil {
+		return nil, err
+	}
+	if cl := g.Attributes["color"]; cl != nil {
+		if cl.count() != n {
+			return nil, fmt.Errorf("mst: three.js color attribute has %d items, expected %d", cl.count(), n)
+		}
+		nd.Colors = make([][3]byte, n)
+		for i := range nd.Colors {
+			nd.Colors[i] = colorBytes([]float32{float32(cl.item(i, 0)), float32(cl.item(i, 1)), float32(cl.item(i, 2))})
+		}
+	}
+
+	var idx []uint32
+	if g.Index != nil {
+		idx = make([]uint32, len(g.Index.Array))
+		for i, v := range g.Index.Array {
+			if v < 0 || int(v) >= n {
+				return nil, &IndexError{Kind: "vertex", Index: uint32(v), Count: n}
+			}
+			idx[i] = uint32(v)
+		}
+	} else {
+		idx = make([]uint32, n)
+		for i := range idx {
+			idx[i] = uint32(i)
+		}
+	}
+	groups := g.Groups
+	if len(groups) == 0 {
+		groups = []threejsGroup{{}}
+	}
+	for _, gr := range groups {
+		end := len(idx)
+		if gr.Count != nil && gr.Start+int(*gr.Count) < end {
+			end = gr.Start + int(*gr.Count)
+		}
+		if gr.Start < 0 || gr.Start > end {
+			return nil, fmt.Errorf("mst: three.js group start %d out of range", gr.Start)
+		}
+		var faces []*Face
+		for i := gr.Start; i+2 < end; i += 3 {
+			faces = append(faces, &Face{Vertex: [3]uint32{idx[i], idx[i+1], idx[i+2]}})
+		}
+		if err := nd.AddFaces(gr.MaterialIndex, faces); err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
+func (imp *threejsImporter) legacyGeometry(g *threejsLegacyGeometry) (*MeshNode, error) {
+	scale := 1.0
+	if g.Scale != 0 {
+		scale = 1 / g.Scale
+	}
+	nd := &MeshNode{}
+	for i := 0; i+2 < len(g.Vertices); i += 3 {
+		nd.Vertices = append(nd.Vertices, vec3.T{float32(g.Vertices[i] * scale), float32(g.Vertices[i+1] * scale), float32(g.Vertices[i+2] * scale)})
+	}
+	for i := 0; i+2 < len(g.Normals); i += 3 {
+		nd.Normals = append(nd.Normals, vec3.T{float32(g.Normals[i]), float32(g.Normals[i+1]), float32(g.Normals[i+2])})
+	}
+	layers := 0
+	for _, layer := range g.Uvs {
+		if len(layer) > 0 {
+			layers++
+		}
+	}
+	if layers > 0 {
+		for i := 0; i+1 < len(g.Uvs[0]); i += 2 {
+			nd.TexCoords = append(nd.TexCoords, vec2.T{float32(g.Uvs[0][i]), float32(g.Uvs[0][i+1])})
+		}
+	}
+	if layers > 1 {
+		logWarn(imp.opts.Logger, "ignored extra three.js uv layers", "layers", layers)
+	}
+
+	faces := g.Faces
+	groups := make(map[int32][]*Face)
+	var order []int32
+	warnedColors := false
+	next := func(n int) ([]uint32, error) {
+		if len(faces) < n {
+			return nil, io.ErrUnexpectedEOF
+		}
+		out := faces[:n]
+		faces = faces[n:]
+		return out, nil
+	}
+	for len(faces) > 0 {
+		head, _ := next(1)
+		mask := head[0]
+		nv := 3
+		if mask&THREEJS_FACE_QUAD != 0 {
+			nv = 4
+		}
+		vs, err := next(nv)
+		if err != nil {
+			return nil, err
+		}
+		var batch int32
+		if mask&THREEJS_FACE_MATERIAL != 0 {
+			m, err := next(1)
+			if err != nil {
+				return nil, err
+			}
+			batch = int32(m[0])
+		}
+		var uvs []uint32
+		if mask&THREEJS_FACE_UV != 0 {
+			for l := 0; l < len(g.Uvs); l++ {
+				u, err := next(1)
+				if err != nil {
+					return nil, err
+				}
+				if l == 0 {
+					uvs = []uint32{u[0], u[0], u[0], u[0]}
+				}
+			}
+		}
+		if mask&THREEJS_FACE_VERTEX_UV != 0 {
+			for l := 0; l < len(g.Uvs); l++ {
+				u, err := next(nv)
+				if err != nil {
+					return nil, err
+				}
+				if l == 0 {
+					uvs = u
+				}
+			}
+		}
+		var ns []uint32
+		if mask&THREEJS_FACE_NORMAL != 0 {
+			n, err := next(1)
+			if err != nil {
+				return nil, err
+			}
+			ns = []uint32{n[0], n[0], n[0], n[0]}
+		}
+		if mask&THREEJS_FACE_VERTEX_NORMAL != 0 {
+			if ns, err = next(nv); err != nil {
+				return nil, err
+			}
+		}
+		if mask&THREEJS_FACE_COLOR != 0 {
+			if _, err := next(1); err != nil {
+				return nil, err
+			}
+		}
+		if mask&THREEJS_FACE_VERTEX_COLOR != 0 {
+			if _, err := next(nv); err != nil {
+				return nil, err
+			}
+		}
+		if mask&(THREEJS_FACE_COLOR|THREEJS_FACE_VERTEX_COLOR) != 0 && !warnedColors {
+			logWarn(imp.opts.Logger, "ignored three.js face colors")
+			warnedColors = true
+		}
+		corners := [][3]int{{0, 1, 2}}
+		if nv == 4 {
+			corners = [][3]int{{0, 1, 3}, {1, 2, 3}}
+		}
+		for _, c := range corners {
+			f := &Face{Vertex: [3]uint32{vs[c[0]], vs[c[1]], vs[c[2]]}}
+			if uvs != nil {
+				f.Uv = &[3]uint32{uvs[c[0]], uvs[c[1]], uvs[c[2]]}
+			}
+			if ns != nil {
+				f.Normal = &[3]uint32{ns[c[0]], ns[c[1]], ns[c[2]]}
+			}
+			if _, ok := groups[batch]; !ok {
+				order = append(order, batch)
+			}
+			groups[batch] = append(groups[batch], f)
+		}
+	}
+	for _, b := range order {
+		if err := nd.AddFaces(b, groups[b]); err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
+func (imp *threejsImporter) geometry(g *threejsGeometry) (*MeshNode, error) {
+	if nd, ok := imp.nodes[g.UUID]; ok && g.UUID != "" {
+		return nd, nil
+	}
+	var nd *MeshNode
+	var err error
+	switch g.Type {
+	case "BufferGeometry", "":
+		bg := &threejsBufferGeometry{}
+		if err := json.Unmarshal(g.Data, bg); err != nil {
+			return nil, err
+		}
+		nd, err = imp.bufferGeometry(bg)
+	case "Geometry":
+		lg := &threejsLegacyGeometry{}
+		if err := json.Unmarshal(g.Data, lg); err != nil {
+			return nil, err
+		}
+		nd, err = imp.legacyGeometry(lg)
+	default:
+		return nil, fmt.Errorf("mst: unsupported three.js geometry type %q", g.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g.UUID != "" {
+		imp.nodes[g.UUID] = nd
+	}
+	return nd, nil
+}
+
+func (imp *threejsImporter) geometryOnly(g *threejsGeometry) error {
+	if len(g.Data) == 0 {
+		return ErrThreejsUnknown
+	}
+	nd, err := imp.geometry(g)
+	if err != nil {
+		return err
+	}
+	if err := imp.addMaterials(); err != nil {
+		return err
+	}
+	if len(imp.ms.Materials) == 0 {
+		if err := imp.defaultMaterials(nd); err != nil {
+			return err
+		}
+	}
+	imp.ms.Nodes = append(imp.ms.Nodes, nd)
+	return nil
+}
+
+func (imp *threejsImporter) legacy() error {
+	if err := imp.addMaterials(); err != nil {
+		return err
+	}
+	nd, err := imp.legacyGeometry(&imp.doc.threejsLegacyGeometry)
+	if err != nil {
+		return err
+	}
+	if len(imp.ms.Materials) == 0 {
+		if err := imp.defaultMaterials(nd); err != nil {
+			return err
+		}
+	}
+	imp.ms.Nodes = append(imp.ms.Nodes, nd)
+	return nil
+}
+
+func (imp *threejsImporter) objectMaterials(obj *threejsObject) ([]int32, error) {
+	if len(obj.Material) == 0 {
+		return nil, nil
+	}
+	var uuids []string
+	if err := json.Unmarshal(obj.Material, &uuids); err != nil {
+		var one string
+		if err := json.Unmarshal(obj.Material, &one); err != nil {
+			return nil, fmt.Errorf("mst: three.js object %q has an invalid material reference", obj.Name)
+		}
+		uuids = []string{one}
+	}
+	out := make([]int32, len(uuids))
+	for i, id := range uuids {
+		m, ok := imp.materials[id]
+		if !ok {
+			return nil, fmt.Errorf("mst: three.js material %s not found", id)
+		}
+		out[i] = m
+	}
+	return out, nil
+}
+
+func (imp *threejsImporter) defaultMaterial() int32 {
+	for i, mtl := range imp.ms.Materials {
+		if bm, ok := mtl.(*BaseMaterial); ok && bm.Name == "" && bm.Color == threejsColor(THREEJS_DEFAULT_COLOR) && bm.Transparency == 0 {
+			return int32(i)
+		}
+	}
+	imp.ms.Materials = append(imp.ms.Materials, &BaseMaterial{Color: threejsColor(THREEJS_DEFAULT_COLOR)})
+	return int32(len(imp.ms.Materials) - 1)
+}
+
+func (imp *threejsImporter) mesh(obj *threejsObject, world *dmat.T) error {
+	g, ok := imp.geometries[obj.Geometry]
+	if !ok {
+		return fmt.Errorf("mst: three.js geometry %s not found", obj.Geometry)
+	}
+	tmpl, err := imp.geometry(g)
+	if err != nil {
+		return err
+	}
+	mtls, err := imp.objectMaterials(obj)
+	if err != nil {
+		return err
+	}
+	remap := func(b int32) int32 {
+		if len(mtls) == 0 {
+			return imp.defaultMaterial()
+		}
+		if int(b) >= len(mtls) || b < 0 {
+			return mtls[0]
+		}
+		return mtls[b]
+	}
+	transforms := []*dmat.T{world}
+	if im := obj.InstanceMatrix; im != nil && im.ItemSize == 16 {
+		transforms = transforms[:0]
+		for i := 0; i < im.count() && (obj.Count == 0 || i < obj.Count); i++ {
+			local, err := threejsMatrix(im.Array[i*16 : i*16+16])
+			if err != nil {
+				return err
+			}
+			mt := &dmat.T{}
+			mt.AssignMul(world, &local)
+			transforms = append(transforms, mt)
+		}
+	}
+	for _, mt := range transforms {
+		nd := TransformNode(tmpl, mt, remap)
+		groups := nd.FaceGroup
+		nd.FaceGroup = nil
+		for _, g := range groups {
+			if err := nd.AddFaces(g.Batchid, g.Faces); err != nil {
+				return err
+			}
+		}
+		nd.Name = obj.Name
+		nd.Props = extrasToProps(obj.UserData)
+		imp.ms.Nodes = append(imp.ms.Nodes, nd)
+	}
+	return nil
+}
+
+func (imp *threejsImporter) object(obj *threejsObject, parent *dmat.T, depth int) error {
+	if depth > threejsMaxHierarchyDepth {
+		return fmt.Errorf("mst: three.js object hierarchy deeper than %d", threejsMaxHierarchyDepth)
+	}
+	local, err := threejsMatrix(obj.Matrix)
+	if err != nil {
+		return err
+	}
+	world := &dmat.T{}
+	world.AssignMul(parent, &local)
+	switch obj.Type {
+	case "Mesh", "SkinnedMesh", "InstancedMesh":
+		if err := imp.ec.report(fmt.Sprintf("objects[%s]", obj.UUID), imp.mesh(obj, world)); err != nil {
+			return err
+		}
+	case "Points", "Line", "LineSegments", "LineLoop", "Sprite":
+		logWarn(imp.opts.Logger, "skipped unsupported three.js object", "type", obj.Type, "uuid", obj.UUID)
+	}
+	for _, c := range obj.Children {
+		if err := imp.object(c, world, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *threejsImporter) scene() error {
+	for _, g := range imp.doc.Geometries {
+		imp.geometries[g.UUID] = g
+	}
+	if err := imp.addMaterials(); err != nil {
+		return err
+	}
+	if err := imp.object(imp.doc.Object, &dmat.Ident, 0); err != nil {
+		return err
+	}
+	imp.ms.Props = extrasToProps(imp.doc.Object.UserData)
+	return nil
+}
@@ -0,0 +1,292 @@
+package mst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	dmat "github.com/flywave/go3d/float64/mat4"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const THREEJS_BIN_SIGNATURE = "Three.js 003"
+
+var ErrThreejsBinSignature = errors.New("mst: invalid three.js binary signature")
+
+type threejsBinHeader struct {
+	Signature          [12]byte
+	HeaderBytes        uint8
+	VertexCoordBytes   uint8
+	NormalCoordBytes   uint8
+	UvCoordBytes       uint8
+	VertexIndexBytes   uint8
+	NormalIndexBytes   uint8
+	UvIndexBytes       uint8
+	MaterialIndexBytes uint8
+	Vertices           uint32
+	Normals            uint32
+	Uvs                uint32
+	Faces              [8]uint32
+}
+
+type threejsTopology struct {
+	Scale    float32    `json:"scale"`
+	Rotation [4]float32 `json:"rotation"`
+	Offset   [3]float32 `json:"offset"`
+}
+
+type threejsBinReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *threejsBinReader) take(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *threejsBinReader) uints(n, width int) ([]uint32, error) {
+	b, err := r.take(n * width)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]uint32, n)
+	for i := range out {
+		switch width {
+		case 1:
+			out[i] = uint32(b[i])
+		case 2:
+			out[i] = uint32(binary.LittleEndian.Uint16(b[i*2:]))
+		default:
+			out[i] = binary.LittleEndian.Uint32(b[i*4:])
+		}
+	}
+	return out, nil
+}
+
+func (r *threejsBinReader) floats(n int) ([]float32, error) {
+	b, err := r.take(n * 4)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return out, nil
+}
+
+func (r *threejsBinReader) pad(length int) error {
+	_, err := r.take((4 - length%4) % 4)
+	return err
+}
+
+func (h *threejsBinHeader) validate() error {
+	if string(h.Signature[:]) != THREEJS_BIN_SIGNATURE {
+		return ErrThreejsBinSignature
+	}
+	if h.HeaderBytes < uint8(binary.Size(h)) || h.VertexCoordBytes != 4 || h.NormalCoordBytes != 1 || h.UvCoordBytes != 4 {
+		return fmt.Errorf("mst: unsupported three.js binary layout")
+	}
+	for _, w := range []uint8{h.VertexIndexBytes, h.NormalIndexBytes, h.UvIndexBytes, h.MaterialIndexBytes} {
+		if w != 1 && w != 2 && w != 4 {
+			return fmt.Errorf("mst: unsupported three.js binary index width %d", w)
+		}
+	}
+	return nil
+}
+
+func threejsBinNode(data []byte) (*MeshNode, error) {
+	h := &threejsBinHeader{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, h); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	r := &threejsBinReader{data: data, pos: int(h.HeaderBytes)}
+	if int(h.Vertices) > len(data) || int(h.Normals) > len(data) || int(h.Uvs) > len(data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	vs, err := r.floats(int(h.Vertices) * 3)
+	if err != nil {
+		return nil, err
+	}
+	nd := &MeshNode{Vertices: make([]vec3.T, h.Vertices)}
+	for i := range nd.Vertices {
+		nd.Vertices[i] = vec3.T{vs[i*3], vs[i*3+1], vs[i*3+2]}
+	}
+	ns, err := r.take(int(h.Normals) * 3)
+	if err != nil {
+		return nil, err
+	}
+	if h.Normals > 0 {
+		nd.Normals = make([]vec3.T, h.Normals)
+		for i := range nd.Normals {
+			for c := 0; c < 3; c++ {
+				nd.Normals[i][c] = float32(int8(ns[i*3+c])) / 127
+			}
+		}
+	}
+	if err := r.pad(len(ns)); err != nil {
+		return nil, err
+	}
+	uvs, err := r.floats(int(h.Uvs) * 2)
+	if err != nil {
+		return nil, err
+	}
+	if h.Uvs > 0 {
+		nd.TexCoords = make([]vec2.T, h.Uvs)
+		for i := range nd.TexCoords {
+			nd.TexCoords[i] = vec2.T{uvs[i*2], uvs[i*2+1]}
+		}
+	}
+
+	groups := make(map[int32][]*Face)
+	var order []int32
+	for s, count := range h.Faces {
+		n := int(count)
+		quad, smooth, textured := s >= 4, s%2 == 1, s%4 >= 2
+		nv := 3
+		if quad {
+			nv = 4
+		}
+		if n > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		start := r.pos
+		vi, err := r.uints(n*nv, int(h.VertexIndexBytes))
+		if err != nil {
+			return nil, err
+		}
+		var ni, ti []uint32
+		if smooth {
+			if ni, err = r.uints(n*nv, int(h.NormalIndexBytes)); err != nil {
+				return nil, err
+			}
+		}
+		if textured {
+			if ti, err = r.uints(n*nv, int(h.UvIndexBytes)); err != nil {
+				return nil, err
+			}
+		}
+		mi, err := r.uints(n, int(h.MaterialIndexBytes))
+		if err != nil {
+			return nil, err
+		}
+		if err := r.pad(r.pos - start); err != nil {
+			return nil, err
+		}
+		corners := [][3]int{{0, 1, 2}}
+		if quad {
+			corners = [][3]int{{0, 1, 2}, {2, 3, 0}}
+		}
+		for f := 0; f < n; f++ {
+			b := int32(mi[f])
+			for _, c := range corners {
+				face := &Face{Vertex: [3]uint32{vi[f*nv+c[0]], vi[f*nv+c[1]], vi[f*nv+c[2]]}}
+				if ni != nil {
+					face.Normal = &[3]uint32{ni[f*nv+c[0]], ni[f*nv+c[1]], ni[f*nv+c[2]]}
+				}
+				if ti != nil {
+					face.Uv = &[3]uint32{ti[f*nv+c[0]], ti[f*nv+c[1]], ti[f*nv+c[2]]}
+				}
+				if _, ok := groups[b]; !ok {
+					order = append(order, b)
+				}
+				groups[b] = append(groups[b], face)
+			}
+		}
+	}
+	for _, b := range order {
+		if err := nd.AddFaces(b, groups[b]); err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
+func ThreejsBinToMesh(jsonRd io.Reader, binRd io.Reader, texLoader TextureResolver) (*Mesh, error) {
+	js, err := ioutil.ReadAll(jsonRd)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		threejsDocument
+		Topology *threejsTopology `json:"topology"`
+	}
+	if err := json.Unmarshal(js, &doc); err != nil {
+		return nil, err
+	}
+	bin, err := ioutil.ReadAll(binRd)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := threejsBinNode(bin)
+	if err != nil {
+		return nil, &AssetError{Field: "buffers", Err: err}
+	}
+	if tp := doc.Topology; tp != nil && tp.Scale != 0 {
+		mt := trsMatrix(tp.Offset, tp.Rotation, [3]float32{tp.Scale, tp.Scale, tp.Scale})
+		if mt != dmat.Ident {
+			nd = TransformNode(nd, &mt, nil)
+		}
+	}
+	imp := newThreejsImporter(&doc.threejsDocument, &ThreejsImportOptions{})
+	imp.resolver = texLoader
+	if err := imp.addMaterials(); err != nil {
+		return nil, err
+	}
+	if len(imp.ms.Materials) == 0 {
+		if err := imp.defaultMaterials(nd); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range nd.FaceGroup {
+		if int(g.Batchid) >= len(imp.ms.Materials) {
+			return nil, &IndexError{Kind: "material", Index: uint32(g.Batchid), Count: len(imp.ms.Materials)}
+		}
+	}
+	imp.ms.Nodes = append(imp.ms.Nodes, nd)
+	return imp.ms, nil
+}
+
+func ThreejsBin2Mst(path, destName string) error {
+	dir := filepath.Dir(path)
+	js, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc threejsDocument
+	if err := json.Unmarshal(js, &doc); err != nil {
+		return err
+	}
+	if doc.Buffers == "" {
+		return fmt.Errorf("mst: %s has no three.js binary buffer", path)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(doc.Buffers)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ms, err := ThreejsBinToMesh(bytes.NewReader(js), f, NewFileTextureResolver(dir))
+	if err != nil {
+		return err
+	}
+	return MeshWriteTo(filepath.Join(dir, destName+".mst"), ms)
+}
@@ -0,0 +1,97 @@
+package mst
+
+import (
+	"math"
+
+	"github.com/flywave/go3d/vec3"
+)
+
+const PROVENANCE_PROPS_KEY = "provenance"
+
+type Tolerances struct {
+	WeldEpsilon   float64 `json:"weldEpsilon"`
+	AreaEpsilon   float64 `json:"areaEpsilon"`
+	CreaseAngle   float64 `json:"creaseAngle"`
+	PlanarAngle   float64 `json:"planarAngle"`
+	NormalEpsilon float64 `json:"normalEpsilon"`
+}
+
+var DefaultTolerances = Tolerances{
+	WeldEpsilon:   1e-6,
+	AreaEpsilon:   1e-12,
+	CreaseAngle:   30,
+	PlanarAngle:   1,
+	NormalEpsilon: 1e-6,
+}
+
+func tolerancesOrDefault(tol *Tolerances) *Tolerances {
+	if tol == nil {
+		return &DefaultTolerances
+	}
+	return tol
+}
+
+func (t *Tolerances) SamePosition(a, b vec3.T) bool {
+	d := vec3.Sub(&a, &b)
+	return float64(d.LengthSqr()) <= t.WeldEpsilon*t.WeldEpsilon
+}
+
+func (t *Tolerances) DegenerateArea(area float64) bool {
+	return math.Abs(area) <= t.AreaEpsilon
+}
+
+func (t *Tolerances) IsCrease(n1, n2 vec3.T) bool {
+	return float64(vec3.Dot(&n1, &n2)) < math.Cos(t.CreaseAngle*math.Pi/180)
+}
+
+func (t *Tolerances) IsCoplanar(n1, n2 vec3.T) bool {
+	return float64(vec3.Dot(&n1, &n2)) >= math.Cos(t.PlanarAngle*math.Pi/180)
+}
+
+func (t *Tolerances) ToProps() Properties {
+	return Properties{
+		"weldEpsilon":   t.WeldEpsilon,
+		"areaEpsilon":   t.AreaEpsilon,
+		"creaseAngle":   t.CreaseAngle,
+		"planarAngle":   t.PlanarAngle,
+		"normalEpsilon": t.NormalEpsilon,
+	}
+}
+
+func TolerancesFromProps(props Properties) *Tolerances {
+	tol := DefaultTolerances
+	get := func(k string, dst *float64) {
+		if v, ok := props[k].(float64); ok {
+			*dst = v
+		}
+	}
+	get("weldEpsilon", &tol.WeldEpsilon)
+	get("areaEpsilon", &tol.AreaEpsilon)
+	get("creaseAngle", &tol.CreaseAngle)
+	get("planarAngle", &tol.PlanarAngle)
+	get("normalEpsilon", &tol.NormalEpsilon)
+	return &tol
+}
+
+func (m *Mesh) RecordProvenance(op string, tol *Tolerances) {
+	if m.Props == nil {
+		m.Props = Properties{}
+	}
+	entry := map[string]interface{}{"op": op}
+	if tol != nil {
+		entry["tolerances"] = map[string]interface{}(tol.ToProps())
+	}
+	history, _ := m.Props[PROVENANCE_PROPS_KEY].([]interface{})
+	m.Props[PROVENANCE_PROPS_KEY] = append(history, entry)
+}
+
+func (m *Mesh) Provenance() []Properties {
+	history, _ := m.Props[PROVENANCE_PROPS_KEY].([]interface{})
+	out := make([]Properties, 0, len(history))
+	for _, h := range history {
+		if e, ok := h.(map[string]interface{}); ok {
+			out = append(out, Properties(e))
+		}
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+package topology
+
+import mst "github.com/flywave/go-mst"
+
+const NO_TWIN = -1
+
+type HalfEdge struct {
+	Origin uint32
+	Face   int
+	Next   int
+	Twin   int
+}
+
+type FaceRef struct {
+	Group int
+	Face  int
+}
+
+type Topology struct {
+	node      *mst.MeshNode
+	Faces     []FaceRef
+	HalfEdges []HalfEdge
+	outgoing  map[uint32][]int
+	edgeFaces map[[2]uint32][]int
+}
+
+func edgeKey(a, b uint32) [2]uint32 {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]uint32{a, b}
+}
+
+func Build(nd *mst.MeshNode) (*Topology, error) {
+	t := &Topology{node: nd, outgoing: make(map[uint32][]int), edgeFaces: make(map[[2]uint32][]int)}
+	directed := make(map[[2]uint32][]int)
+	for gi, g := range nd.FaceGroup {
+		for fi, f := range g.Faces {
+			for _, v := range f.Vertex {
+				if int(v) >= len(nd.Vertices) {
+					return nil, &mst.IndexError{Kind: "vertex", Index: v, Count: len(nd.Vertices)}
+				}
+			}
+			face := len(t.Faces)
+			t.Faces = append(t.Faces, FaceRef{Group: gi, Face: fi})
+			base := len(t.HalfEdges)
+			for k := 0; k < 3; k++ {
+				a, b := f.Vertex[k], f.Vertex[(k+1)%3]
+				h := base + k
+				t.HalfEdges = append(t.HalfEdges, HalfEdge{Origin: a, Face: face, Next: base + (k+1)%3, Twin: NO_TWIN})
+				t.outgoing[a] = append(t.outgoing[a], h)
+				directed[[2]uint32{a, b}] = append(directed[[2]uint32{a, b}], h)
+				key := edgeKey(a, b)
+				t.edgeFaces[key] = append(t.edgeFaces[key], face)
+			}
+		}
+	}
+	for h := range t.HalfEdges {
+		a, b := t.HalfEdges[h].Origin, t.dest(h)
+		if len(t.edgeFaces[edgeKey(a, b)]) != 2 || len(directed[[2]uint32{a, b}]) != 1 {
+			continue
+		}
+		if tw := directed[[2]uint32{b, a}]; len(tw) == 1 {
+			t.HalfEdges[h].Twin = tw[0]
+		}
+	}
+	return t, nil
+}
+
+func (t *Topology) Node() *mst.MeshNode {
+	return t.node
+}
+
+func (t *Topology) dest(h int) uint32 {
+	return t.HalfEdges[t.HalfEdges[h].Next].Origin
+}
+
+func (t *Topology) prev(h int) int {
+	return t.HalfEdges[t.HalfEdges[h].Next].Next
+}
+
+func (t *Topology) FaceHalfEdges(face int) [3]int {
+	return [3]int{face * 3, face*3 + 1, face*3 + 2}
+}
+
+func (t *Topology) NeighborFaces(face int) []int {
+	var out []int
+	for _, h := range t.FaceHalfEdges(face) {
+		for _, f := range t.edgeFaces[edgeKey(t.HalfEdges[h].Origin, t.dest(h))] {
+			if f != face {
+				out = append(out, f)
+			}
+		}
+	}
+	return out
+}
+
+func (t *Topology) isBoundary(h int) bool {
+	return len(t.edgeFaces[edgeKey(t.HalfEdges[h].Origin, t.dest(h))]) == 1
+}
+
+func (t *Topology) fanSize(h int) int {
+	seen := map[int]bool{h: true}
+	for cur := h; ; {
+		tw := t.HalfEdges[t.prev(cur)].Twin
+		if tw == NO_TWIN || seen[tw] {
+			break
+		}
+		seen[tw] = true
+		cur = tw
+	}
+	for cur := h; ; {
+		tw := t.HalfEdges[cur].Twin
+		if tw == NO_TWIN {
+			break
+		}
+		next := t.HalfEdges[tw].Next
+		if seen[next] {
+			break
+		}
+		seen[next] = true
+		cur = next
+	}
+	return len(seen)
+}
+
+func (t *Topology) IsManifold() bool {
+	for _, faces := range t.edgeFaces {
+		if len(faces) > 2 {
+			return false
+		}
+	}
+	for h := range t.HalfEdges {
+		if t.HalfEdges[h].Twin == NO_TWIN && !t.isBoundary(h) {
+			return false
+		}
+	}
+	for _, out := range t.outgoing {
+		if t.fanSize(out[0]) != len(out) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Topology) ConnectedComponents() [][]int {
+	parent := make([]int, len(t.Faces))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	for _, faces := range t.edgeFaces {
+		for _, f := range faces[1:] {
+			if a, b := find(faces[0]), find(f); a != b {
+				if a < b {
+					parent[b] = a
+				} else {
+					parent[a] = b
+				}
+			}
+		}
+	}
+	index := make(map[int]int)
+	var comps [][]int
+	for f := range t.Faces {
+		r := find(f)
+		c, ok := index[r]
+		if !ok {
+			c = len(comps)
+			index[r] = c
+			comps = append(comps, nil)
+		}
+		comps[c] = append(comps[c], f)
+	}
+	return comps
+}
+
+func (t *Topology) BoundaryLoops() [][]uint32 {
+	starts := make(map[uint32][]int)
+	var boundary []int
+	for h := range t.HalfEdges {
+		if t.isBoundary(h) {
+			starts[t.HalfEdges[h].Origin] = append(starts[t.HalfEdges[h].Origin], h)
+			boundary = append(boundary, h)
+		}
+	}
+	used := make(map[int]bool)
+	var loops [][]uint32
+	for _, h := range boundary {
+		if used[h] {
+			continue
+		}
+		var loop []uint32
+		for cur := h; cur >= 0 && !used[cur]; {
+			used[cur] = true
+			loop = append(loop, t.HalfEdges[cur].Origin)
+			next := -1
+			for _, c := range starts[t.dest(cur)] {
+				if !used[c] {
+					next = c
+					break
+				}
+			}
+			cur = next
+		}
+		loops = append(loops, loop)
+	}
+	return loops
+}
+
+func (t *Topology) SplitComponents() ([]*mst.MeshNode, error) {
+	comps := t.ConnectedComponents()
+	if len(comps) <= 1 {
+		return []*mst.MeshNode{t.node}, nil
+	}
+	owner := make(map[FaceRef]int, len(t.Faces))
+	for c, faces := range comps {
+		for _, f := range faces {
+			owner[t.Faces[f]] = c
+		}
+	}
+	out := make([]*mst.MeshNode, len(comps))
+	for c := range comps {
+		nd, err := mst.SelectFaces(t.node, func(g, f int) bool { return owner[FaceRef{Group: g, Face: f}] == c })
+		if err != nil {
+			return nil, err
+		}
+		out[c] = nd
+	}
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package topology
+
+import (
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec3"
+)
+
+func quad(offset float32) ([]vec3.T, [][3]uint32) {
+	return []vec3.T{{offset, 0, 0}, {offset + 1, 0, 0}, {offset + 1, 1, 0}, {offset, 1, 0}}, [][3]uint32{{0, 1, 2}, {0, 2, 3}}
+}
+
+func newNode(parts ...float32) *mst.MeshNode {
+	nd := &mst.MeshNode{}
+	g := &mst.MeshTriangle{}
+	for _, p := range parts {
+		vs, fs := quad(p)
+		base := uint32(len(nd.Vertices))
+		nd.Vertices = append(nd.Vertices, vs...)
+		for _, f := range fs {
+			g.Faces = append(g.Faces, &mst.Face{Vertex: [3]uint32{f[0] + base, f[1] + base, f[2] + base}})
+		}
+	}
+	nd.FaceGroup = []*mst.MeshTriangle{g}
+	return nd
+}
+
+func TestTopology(t *testing.T) {
+	nd := newNode(0, 5)
+	nd.Colors = make([][3]byte, len(nd.Vertices))
+	nd.Colors[5] = [3]byte{1, 2, 3}
+	nd.EdgeGroup = []*mst.MeshOutline{{Edges: [][2]uint32{{4, 5}}}}
+	top, err := Build(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !top.IsManifold() {
+		t.Fatal("two quads should be manifold")
+	}
+	if comps := top.ConnectedComponents(); len(comps) != 2 || len(comps[0]) != 2 {
+		t.Fatalf("unexpected components %v", comps)
+	}
+	loops := top.BoundaryLoops()
+	if len(loops) != 2 || len(loops[0]) != 4 || len(loops[1]) != 4 {
+		t.Fatalf("unexpected boundary loops %v", loops)
+	}
+	parts, err := top.SplitComponents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || len(parts[1].Vertices) != 4 || parts[1].Vertices[0][0] != 5 || parts[1].Colors[1] != [3]byte{1, 2, 3} {
+		t.Fatal("components not split")
+	}
+	if len(parts[0].EdgeGroup) != 0 || len(parts[1].EdgeGroup) != 1 || parts[1].EdgeGroup[0].Edges[0] != [2]uint32{0, 1} {
+		t.Fatal("edges not assigned to their component")
+	}
+
+	bowtie := &mst.MeshNode{
+		Vertices: []vec3.T{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {-1, 0, 0}, {-1, -1, 0}},
+		FaceGroup: []*mst.MeshTriangle{{Faces: []*mst.Face{
+			{Vertex: [3]uint32{0, 1, 2}}, {Vertex: [3]uint32{0, 3, 4}},
+		}}},
+	}
+	if top, err = Build(bowtie); err != nil || top.IsManifold() {
+		t.Fatal("bowtie vertex should not be manifold")
+	}
+	fin := newNode(0)
+	fin.Vertices = append(fin.Vertices, vec3.T{0, 0, 1})
+	fin.FaceGroup[0].Faces = append(fin.FaceGroup[0].Faces, &mst.Face{Vertex: [3]uint32{0, 2, 4}})
+	if top, err = Build(fin); err != nil || top.IsManifold() {
+		t.Fatal("edge with three faces should not be manifold")
+	}
+	if _, err := Build(&mst.MeshNode{FaceGroup: []*mst.MeshTriangle{{Faces: []*mst.Face{{Vertex: [3]uint32{0, 1, 2}}}}}}); err == nil {
+		t.Fatal("expected index error")
+	}
+}
@@ -0,0 +1,49 @@
+package mst
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	UNITS_UNKNOWN     = 0
+	UNITS_METERS      = 1
+	UNITS_CENTIMETERS = 2
+	UNITS_MILLIMETERS = 3
+	UNITS_FEET        = 4
+	UNITS_INCHES      = 5
+)
+
+var ErrUnknownUnits = errors.New("mst: mesh units are unknown")
+
+var unitMeters = map[uint8]float64{
+	UNITS_METERS:      1,
+	UNITS_CENTIMETERS: 0.01,
+	UNITS_MILLIMETERS: 0.001,
+	UNITS_FEET:        0.3048,
+	UNITS_INCHES:      0.0254,
+}
+
+func UnitScale(units uint8) (float64, bool) {
+	s, ok := unitMeters[units]
+	return s, ok
+}
+
+func (m *Mesh) ConvertUnits(target uint8) (*Mesh, error) {
+	to, ok := UnitScale(target)
+	if !ok {
+		return nil, fmt.Errorf("mst: unknown target units %d", target)
+	}
+	from, ok := UnitScale(m.Units)
+	if !ok {
+		return nil, ErrUnknownUnits
+	}
+	out := m.Clone(WithSharedTextures())
+	out.Units = target
+	if from != to {
+		fc := identityFrame
+		fc.scale = from / to
+		fc.mesh(out)
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package mst
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type UnknownMaterial struct {
+	Type uint32 `json:"materialType"`
+	Data []byte `json:"data"`
+}
+
+func (m *UnknownMaterial) HasTexture() bool {
+	return false
+}
+
+func (m *UnknownMaterial) GetTexture() *Texture {
+	return nil
+}
+
+func (m *UnknownMaterial) GetColor() [3]byte {
+	return [3]byte{255, 255, 255}
+}
+
+func (m *UnknownMaterial) GetEmissive() [3]byte {
+	return [3]byte{}
+}
+
+func (m *UnknownMaterial) placeholder() *BaseMaterial {
+	return &BaseMaterial{Color: m.GetColor()}
+}
+
+func knownMaterialType(ty uint32) bool {
+	switch ty {
+	case MESH_TRIANGLE_MATERIAL_TYPE_COLOR, MESH_TRIANGLE_MATERIAL_TYPE_TEXTURE, MESH_TRIANGLE_MATERIAL_TYPE_PBR,
+		MESH_TRIANGLE_MATERIAL_TYPE_LAMBERT, MESH_TRIANGLE_MATERIAL_TYPE_PHONG, MESH_TRIANGLE_MATERIAL_TYPE_REFERENCE:
+		return true
+	}
+	return false
+}
+
+func (d *decoder) framedMaterial(ty uint32) MeshMaterial {
+	var n uint32
+	if !d.read(&n) {
+		return nil
+	}
+	data := d.bytes(int(n))
+	if d.err != nil {
+		return nil
+	}
+	if mtl, ok, err := decodeCustomMaterial(ty, data); ok {
+		d.fail(err)
+		return mtl
+	}
+	if !knownMaterialType(ty) {
+		logWarn(d.log, "passed through unknown material type", "type", ty, "bytes", n)
+		return &UnknownMaterial{Type: ty, Data: data}
+	}
+	sub := *d
+	sub.rd = bytes.NewReader(data)
+	mtl := sub.materialBody(ty)
+	d.fail(sub.err)
+	return mtl
+}
+
+func opaqueMaterialType(mtl MeshMaterial) (uint32, bool) {
+	if um, ok := mtl.(*UnknownMaterial); ok {
+		return um.Type, true
+	}
+	tag, _, ok := customMaterialCodec(mtl)
+	return tag, ok
+}
+
+// OpaqueMaterial returns the type tag and framed payload of an unknown or
+// registered custom material, for encodings that carry them as raw bytes.
+func OpaqueMaterial(mtl MeshMaterial) (*UnknownMaterial, bool) {
+	if um, ok := mtl.(*UnknownMaterial); ok {
+		return um, true
+	}
+	tag, codec, ok := customMaterialCodec(mtl)
+	if !ok {
+		return nil, false
+	}
+	buf := &bytes.Buffer{}
+	codec.Marshal(buf, mtl)
+	return &UnknownMaterial{Type: tag, Data: buf.Bytes()}, true
+}
+
+// Decode returns the registered custom material for the payload, or m when no
+// codec is registered for its type.
+func (m *UnknownMaterial) Decode() (MeshMaterial, error) {
+	if mtl, ok, err := decodeCustomMaterial(m.Type, m.Data); ok {
+		return mtl, err
+	}
+	return m, nil
+}
+
+func hasUnknownMaterials(ms *Mesh) bool {
+	unknown := func(mtls []MeshMaterial) bool {
+		for _, mtl := range mtls {
+			if _, ok := opaqueMaterialType(mtl); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if unknown(ms.Materials) {
+		return true
+	}
+	for _, inst := range ms.InstanceNode {
+		if inst.Mesh != nil && unknown(inst.Mesh.Materials) {
+			return true
+		}
+	}
+	return false
+}
+
+func dropUnknownMaterials(field string, mtls []MeshMaterial, warns []Warning) ([]MeshMaterial, []Warning) {
+	var out []MeshMaterial
+	for i, mtl := range mtls {
+		ty, ok := opaqueMaterialType(mtl)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = append([]MeshMaterial(nil), mtls...)
+		}
+		out[i] = &BaseMaterial{Color: mtl.GetColor()}
+		warns = append(warns, Warning{Field: fmt.Sprintf("%s[%d]", field, i), Message: fmt.Sprintf("replaced material type %d with a color material", ty)})
+	}
+	return out, warns
+}
@@ -0,0 +1,218 @@
+package mst
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+)
+
+type UVAtlasOptions struct {
+	MaxChartAngle float64
+	Resolution    int
+	Padding       int
+}
+
+var DefaultUVAtlasOptions = UVAtlasOptions{MaxChartAngle: 60, Resolution: 1024, Padding: 2}
+
+type uvChart struct {
+	faces []int
+	uvs   [][3][2]float64
+	w, h  float64
+}
+
+// GenerateUVAtlas replaces TexCoords with a non-overlapping atlas in the unit
+// square. Faces are grown into charts whose normals stay within MaxChartAngle of
+// the seed face, each chart is projected onto its seed plane, and the charts
+// are shelf packed at a uniform texel density with Padding texels between them
+// at the given Resolution.
+func (n *MeshNode) GenerateUVAtlas(opts *UVAtlasOptions) error {
+	if opts == nil {
+		opts = &DefaultUVAtlasOptions
+	}
+	if !(opts.MaxChartAngle > 0 && opts.MaxChartAngle < 90) {
+		return errors.New("mst: uv atlas chart angle must be between 0 and 90 degrees")
+	}
+	if opts.Resolution < 1 || opts.Padding < 0 {
+		return errors.New("mst: invalid uv atlas resolution or padding")
+	}
+	if err := n.validateVertexIndices(); err != nil {
+		return err
+	}
+	var faces []*Face
+	for _, g := range n.FaceGroup {
+		faces = append(faces, g.Faces...)
+	}
+	charts := n.uvCharts(faces, math.Cos(opts.MaxChartAngle*math.Pi/180))
+	packUVCharts(charts, float64(opts.Padding)/float64(opts.Resolution))
+	corners := make(map[*Face][3][2]float64, len(faces))
+	for _, c := range charts {
+		for i, f := range c.faces {
+			corners[faces[f]] = c.uvs[i]
+		}
+	}
+	return n.setCornerUVs(func(f *Face, corner int) vec2.T {
+		uv := corners[f][corner]
+		return vec2.T{float32(uv[0]), float32(uv[1])}
+	})
+}
+
+// GenerateUVAtlas unwraps every node, including instanced ones, that has faces
+// but no TexCoords yet.
+func (m *Mesh) GenerateUVAtlas(opts *UVAtlasOptions) error {
+	var err error
+	anyNode(m, func(nd *MeshNode) bool {
+		if len(nd.TexCoords) == 0 && len(nd.FaceGroup) > 0 {
+			err = nd.GenerateUVAtlas(opts)
+		}
+		return err != nil
+	})
+	return err
+}
+
+func (n *MeshNode) uvCharts(faces []*Face, minCos float64) []*uvChart {
+	normals := make([]dvec3.T, len(faces))
+	for i, f := range faces {
+		fn := faceNormal(n.Vertices, f)
+		normals[i] = dvec3.T{float64(fn[0]), float64(fn[1]), float64(fn[2])}
+		if l := normals[i].Length(); l > 0 {
+			normals[i].Scale(1 / l)
+		}
+	}
+	weld := n.weldedVertices()
+	edges := make(map[[2]uint32][]int)
+	for i, f := range faces {
+		for k := 0; k < 3; k++ {
+			key := [2]uint32{weld[f.Vertex[k]], weld[f.Vertex[(k+1)%3]]}
+			if key[0] > key[1] {
+				key[0], key[1] = key[1], key[0]
+			}
+			if key[0] != key[1] {
+				edges[key] = append(edges[key], i)
+			}
+		}
+	}
+	chart := make([]int, len(faces))
+	for i := range chart {
+		chart[i] = -1
+	}
+	var charts []*uvChart
+	for seed := range faces {
+		if chart[seed] >= 0 {
+			continue
+		}
+		axis := normals[seed]
+		if axis.Length() == 0 {
+			axis = dvec3.T{0, 0, 1}
+		}
+		c := &uvChart{faces: []int{seed}}
+		chart[seed] = len(charts)
+		for q := 0; q < len(c.faces); q++ {
+			f := faces[c.faces[q]]
+			for k := 0; k < 3; k++ {
+				key := [2]uint32{weld[f.Vertex[k]], weld[f.Vertex[(k+1)%3]]}
+				if key[0] > key[1] {
+					key[0], key[1] = key[1], key[0]
+				}
+				nb := edges[key]
+				if len(nb) != 2 {
+					continue
+				}
+				for _, g := range nb {
+					if chart[g] >= 0 || dvec3.Dot(&normals[g], &axis) < minCos && normals[g].Length() > 0 {
+						continue
+					}
+					chart[g] = len(charts)
+					c.faces = append(c.faces, g)
+				}
+			}
+		}
+		u, v := tangentFrame(&axis)
+		min := [2]float64{math.Inf(1), math.Inf(1)}
+		max := [2]float64{math.Inf(-1), math.Inf(-1)}
+		c.uvs = make([][3][2]float64, len(c.faces))
+		for i, fi := range c.faces {
+			for k, vi := range faces[fi].Vertex {
+				p := n.Vertices[vi]
+				dp := dvec3.T{float64(p[0]), float64(p[1]), float64(p[2])}
+				uv := [2]float64{dvec3.Dot(&dp, &u), dvec3.Dot(&dp, &v)}
+				for a := 0; a < 2; a++ {
+					min[a], max[a] = math.Min(min[a], uv[a]), math.Max(max[a], uv[a])
+				}
+				c.uvs[i][k] = uv
+			}
+		}
+		c.w, c.h = max[0]-min[0], max[1]-min[1]
+		rotate := c.h > c.w
+		for i := range c.uvs {
+			for k, uv := range c.uvs[i] {
+				uv = [2]float64{uv[0] - min[0], uv[1] - min[1]}
+				if rotate {
+					uv = [2]float64{c.h - uv[1], uv[0]}
+				}
+				c.uvs[i][k] = uv
+			}
+		}
+		if rotate {
+			c.w, c.h = c.h, c.w
+		}
+		charts = append(charts, c)
+	}
+	return charts
+}
+
+func shelfPack(charts []*uvChart, pad float64) ([][2]float64, float64) {
+	area, widest := 0.0, 0.0
+	for _, c := range charts {
+		area += (c.w + pad) * (c.h + pad)
+		widest = math.Max(widest, c.w+pad)
+	}
+	limit := math.Max(math.Sqrt(area), widest)
+	pos := make([][2]float64, len(charts))
+	x, y, shelf, width := 0.0, 0.0, 0.0, 0.0
+	for i, c := range charts {
+		if x > 0 && x+c.w+pad > limit {
+			x, y, shelf = 0, y+shelf, 0
+		}
+		pos[i] = [2]float64{x + pad/2, y + pad/2}
+		x += c.w + pad
+		shelf = math.Max(shelf, c.h+pad)
+		width = math.Max(width, x)
+	}
+	return pos, math.Max(width, y+shelf)
+}
+
+func packUVCharts(charts []*uvChart, padding float64) {
+	sort.SliceStable(charts, func(i, j int) bool { return charts[i].h > charts[j].h })
+	area := 0.0
+	for _, c := range charts {
+		area += c.w * c.h
+	}
+	// The padding is specified in atlas texels but packing happens in model
+	// units, so grow it until it covers the requested fraction of the final
+	// atlas extent.
+	extent := math.Sqrt(area)
+	var pos [][2]float64
+	for i := 0; i < 8; i++ {
+		pad := extent * padding
+		var size float64
+		pos, size = shelfPack(charts, pad)
+		done := size*padding <= pad
+		extent = size
+		if done {
+			break
+		}
+	}
+	if extent == 0 {
+		extent = 1
+	}
+	for ci, c := range charts {
+		for i := range c.uvs {
+			for k, uv := range c.uvs[i] {
+				c.uvs[i][k] = [2]float64{(uv[0] + pos[ci][0]) / extent, (uv[1] + pos[ci][1]) / extent}
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package mst
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	AXIS_X = 0
+	AXIS_Y = 1
+	AXIS_Z = 2
+)
+
+func validProjection(axis uint8, scale float64) error {
+	if axis > AXIS_Z {
+		return fmt.Errorf("mst: invalid projection axis %d", axis)
+	}
+	if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return fmt.Errorf("mst: invalid projection scale %v", scale)
+	}
+	return nil
+}
+
+func planarUV(p vec3.T, axis uint8, sign float32, scale float64) vec2.T {
+	var u, v float32
+	switch axis {
+	case AXIS_X:
+		u, v = sign*p[1], p[2]
+	case AXIS_Y:
+		u, v = -sign*p[0], p[2]
+	default:
+		u, v = p[0], sign*p[1]
+	}
+	return vec2.T{float32(float64(u) * scale), float32(float64(v) * scale)}
+}
+
+func (n *MeshNode) ProjectUVPlanar(axis uint8, scale float64) error {
+	if err := validProjection(axis, scale); err != nil {
+		return err
+	}
+	n.TexCoords = make([]vec2.T, len(n.Vertices))
+	for i, p := range n.Vertices {
+		n.TexCoords[i] = planarUV(p, axis, 1, scale)
+	}
+	n.clearFaceUvs()
+	return nil
+}
+
+func (n *MeshNode) DrapeUV(extent [4]float64) error {
+	w, h := extent[2]-extent[0], extent[3]-extent[1]
+	if !(w > 0) || !(h > 0) || math.IsInf(w, 0) || math.IsInf(h, 0) {
+		return fmt.Errorf("mst: invalid drape extent %v", extent)
+	}
+	n.TexCoords = make([]vec2.T, len(n.Vertices))
+	for i, p := range n.Vertices {
+		n.TexCoords[i] = vec2.T{float32((float64(p[0]) - extent[0]) / w), float32((extent[3] - float64(p[1])) / h)}
+	}
+	n.clearFaceUvs()
+	return nil
+}
+
+func (n *MeshNode) ProjectUVBox(scale float64) error {
+	if err := validProjection(AXIS_Z, scale); err != nil {
+		return err
+	}
+	return n.setCornerUVs(func(f *Face, corner int) vec2.T {
+		fn := faceNormal(n.Vertices, f)
+		axis, best := uint8(AXIS_Z), math.Abs(float64(fn[2]))
+		for a := uint8(AXIS_X); a < AXIS_Z; a++ {
+			if c := math.Abs(float64(fn[a])); c > best {
+				axis, best = a, c
+			}
+		}
+		sign := float32(1)
+		if fn[axis] < 0 {
+			sign = -1
+		}
+		return planarUV(n.Vertices[f.Vertex[corner]], axis, sign, scale)
+	})
+}
+
+func (n *MeshNode) ProjectUVCylindrical(axis uint8, scale float64) error {
+	if err := validProjection(axis, scale); err != nil {
+		return err
+	}
+	bx := n.GetBoundbox()
+	center := [3]float64{(bx[0] + bx[3]) / 2, (bx[1] + bx[4]) / 2, (bx[2] + bx[5]) / 2}
+	a, b := (axis+1)%3, (axis+2)%3
+	angle := func(p vec3.T) float64 {
+		u := math.Atan2(float64(p[b])-center[b], float64(p[a])-center[a]) / (2 * math.Pi)
+		if u < 0 {
+			u++
+		}
+		return u
+	}
+	return n.setCornerUVs(func(f *Face, corner int) vec2.T {
+		u := angle(n.Vertices[f.Vertex[corner]])
+		var max float64
+		for _, v := range f.Vertex {
+			max = math.Max(max, angle(n.Vertices[v]))
+		}
+		if max-u > 0.5 {
+			u++
+		}
+		return vec2.T{float32(u), float32((float64(n.Vertices[f.Vertex[corner]][axis]) - bx[axis]) * scale)}
+	})
+}
+
+func faceNormal(vs []vec3.T, f *Face) vec3.T {
+	a, b, c := vs[f.Vertex[0]], vs[f.Vertex[1]], vs[f.Vertex[2]]
+	e1, e2 := vec3.Sub(&b, &a), vec3.Sub(&c, &a)
+	return vec3.Cross(&e1, &e2)
+}
+
+func (n *MeshNode) clearFaceUvs() {
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			f.Uv = nil
+		}
+	}
+}
+
+func (n *MeshNode) setCornerUVs(uv func(f *Face, corner int) vec2.T) error {
+	if err := n.validateVertexIndices(); err != nil {
+		return err
+	}
+	if n.DetectIndexingMode() == INDEXING_MODE_SEPARATE {
+		n.ResortVtVn(nil)
+	}
+	count := len(n.Vertices)
+	parallel := func(l int) bool { return l == count }
+	normals, colors, uvs2 := parallel(len(n.Normals)), parallel(len(n.Colors)), parallel(len(n.TexCoords2))
+	n.TexCoords = make([]vec2.T, count)
+	assigned := make([]bool, count)
+	split := make(map[uint32]map[vec2.T]uint32)
+	for _, g := range n.FaceGroup {
+		for _, f := range g.Faces {
+			for k, v := range f.Vertex {
+				t := uv(f, k)
+				if !assigned[v] {
+					assigned[v] = true
+					n.TexCoords[v] = t
+					continue
+				}
+				if n.TexCoords[v] == t {
+					continue
+				}
+				dups := split[v]
+				if dups == nil {
+					dups = make(map[vec2.T]uint32)
+					split[v] = dups
+				}
+				if d, ok := dups[t]; ok {
+					f.Vertex[k] = d
+					continue
+				}
+				d := uint32(len(n.Vertices))
+				n.Vertices = append(n.Vertices, n.Vertices[v])
+				n.TexCoords = append(n.TexCoords, t)
+				if normals {
+					n.Normals = append(n.Normals, n.Normals[v])
+				}
+				if colors {
+					n.Colors = append(n.Colors, n.Colors[v])
+				}
+				if uvs2 {
+					n.TexCoords2 = append(n.TexCoords2, n.TexCoords2[v])
+				}
+				for _, mt := range n.MorphTargets {
+					if len(mt.Positions) > int(v) {
+						mt.Positions = append(mt.Positions, mt.Positions[v])
+					}
+					if len(mt.Normals) > int(v) {
+						mt.Normals = append(mt.Normals, mt.Normals[v])
+					}
+				}
+				dups[t] = d
+				f.Vertex[k] = d
+			}
+		}
+	}
+	return nil
+}
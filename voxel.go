@@ -0,0 +1,265 @@
+package mst
+
+import (
+	"errors"
+
+	dvec3 "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	VOXEL_MODE_SOLID   = 0
+	VOXEL_MODE_SURFACE = 1
+)
+
+const VOXEL_MAX_CELLS = 1 << 27
+
+var ErrVoxelGridTooLarge = errors.New("mst: voxel grid exceeds the maximum cell count")
+
+type VoxelizeOptions struct {
+	CellSize float64
+	Mode     uint8
+}
+
+type VoxelGrid struct {
+	Origin   dvec3.T
+	CellSize float64
+	Dims     [3]int
+	Cells    []bool
+}
+
+func Voxelize(ms *Mesh, cellSize float64) (*VoxelGrid, error) {
+	return VoxelizeWithOptions(ms, &VoxelizeOptions{CellSize: cellSize})
+}
+
+func VoxelizeWithOptions(ms *Mesh, opts *VoxelizeOptions) (*VoxelGrid, error) {
+	if opts == nil || !(opts.CellSize > 0) {
+		return nil, errors.New("mst: voxel cell size must be positive")
+	}
+	if opts.Mode != VOXEL_MODE_SOLID && opts.Mode != VOXEL_MODE_SURFACE {
+		return nil, errors.New("mst: unknown voxel mode")
+	}
+	tris, err := meshTriangles(ms)
+	if err != nil {
+		return nil, err
+	}
+	if len(tris) == 0 {
+		return nil, ErrEmptyMesh
+	}
+	bx := trianglesBox(tris)
+	ext := bx.Diagonal()
+	cells := 1.0
+	for a := 0; a < 3; a++ {
+		cells *= ext[a]/opts.CellSize + 3
+	}
+	if cells > VOXEL_MAX_CELLS {
+		return nil, ErrVoxelGridTooLarge
+	}
+	r := voxelizeTriangles(tris, bx, opts.CellSize)
+	g := &VoxelGrid{Origin: r.origin, CellSize: r.cell, Dims: r.dims, Cells: make([]bool, len(r.cells))}
+	for i, c := range r.cells {
+		if opts.Mode == VOXEL_MODE_SURFACE {
+			g.Cells[i] = c == voxelSurface
+		} else {
+			g.Cells[i] = c != voxelExterior
+		}
+	}
+	return g, nil
+}
+
+func (g *VoxelGrid) index(x, y, z int) int {
+	return (z*g.Dims[1]+y)*g.Dims[0] + x
+}
+
+func (g *VoxelGrid) inside(x, y, z int) bool {
+	return x >= 0 && y >= 0 && z >= 0 && x < g.Dims[0] && y < g.Dims[1] && z < g.Dims[2]
+}
+
+func (g *VoxelGrid) Get(x, y, z int) bool {
+	return g.inside(x, y, z) && g.Cells[g.index(x, y, z)]
+}
+
+func (g *VoxelGrid) Set(x, y, z int, v bool) {
+	if g.inside(x, y, z) {
+		g.Cells[g.index(x, y, z)] = v
+	}
+}
+
+func (g *VoxelGrid) CellOf(p dvec3.T) (x, y, z int, ok bool) {
+	for a := 0; a < 3; a++ {
+		p[a] = (p[a] - g.Origin[a]) / g.CellSize
+		if p[a] < 0 {
+			return 0, 0, 0, false
+		}
+	}
+	x, y, z = int(p[0]), int(p[1]), int(p[2])
+	return x, y, z, g.inside(x, y, z)
+}
+
+func (g *VoxelGrid) Center(x, y, z int) dvec3.T {
+	return dvec3.T{
+		g.Origin[0] + (float64(x)+0.5)*g.CellSize,
+		g.Origin[1] + (float64(y)+0.5)*g.CellSize,
+		g.Origin[2] + (float64(z)+0.5)*g.CellSize,
+	}
+}
+
+func (g *VoxelGrid) Count() int {
+	n := 0
+	for _, c := range g.Cells {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+var marchingEdges, marchingLoops = buildMarchingCubes()
+
+func buildMarchingCubes() ([12][2]int, [256][][]int) {
+	var edges [12][2]int
+	edgeID := make(map[[2]int]int)
+	for a, n := 0, 0; a < 3; a++ {
+		for c := 0; c < 8; c++ {
+			if c&(1<<uint(a)) == 0 {
+				edges[n] = [2]int{c, c | 1<<uint(a)}
+				edgeID[edges[n]] = n
+				n++
+			}
+		}
+	}
+	edge := func(a, b int) int {
+		if a > b {
+			a, b = b, a
+		}
+		return edgeID[[2]int{a, b}]
+	}
+	corner := func(c int) dvec3.T {
+		return dvec3.T{float64(c & 1), float64(c >> 1 & 1), float64(c >> 2 & 1)}
+	}
+	var loops [256][][]int
+	for cfg := 1; cfg < 255; cfg++ {
+		in := func(c int) bool { return cfg&(1<<uint(c)) != 0 }
+		next := make(map[int][]int)
+		link := func(e0, e1 int) {
+			next[e0] = append(next[e0], e1)
+			next[e1] = append(next[e1], e0)
+		}
+		for a := 0; a < 3; a++ {
+			u, v := 1<<uint((a+1)%3), 1<<uint((a+2)%3)
+			for _, s := range [2]int{0, 1 << uint(a)} {
+				q := [4]int{s, s | u, s | u | v, s | v}
+				var cross []int
+				for k := 0; k < 4; k++ {
+					if in(q[k]) != in(q[(k+1)%4]) {
+						cross = append(cross, k)
+					}
+				}
+				switch len(cross) {
+				case 2:
+					k0, k1 := cross[0], cross[1]
+					link(edge(q[k0], q[(k0+1)%4]), edge(q[k1], q[(k1+1)%4]))
+				case 4:
+					for k := 0; k < 4; k++ {
+						if in(q[k]) {
+							link(edge(q[(k+3)%4], q[k]), edge(q[k], q[(k+1)%4]))
+						}
+					}
+				}
+			}
+		}
+		seen := make(map[int]bool)
+		for e := 0; e < 12; e++ {
+			if len(next[e]) == 0 || seen[e] {
+				continue
+			}
+			loop := []int{e}
+			seen[e] = true
+			for prev, cur := e, next[e][0]; cur != e; {
+				loop = append(loop, cur)
+				seen[cur] = true
+				nb := next[cur][0]
+				if nb == prev {
+					nb = next[cur][1]
+				}
+				prev, cur = cur, nb
+			}
+			var normal, grad dvec3.T
+			mid := func(e int) dvec3.T {
+				a, b := corner(edges[e][0]), corner(edges[e][1])
+				m := dvec3.Add(&a, &b)
+				return m.Scaled(0.5)
+			}
+			for i, e := range loop {
+				p, q := mid(e), mid(loop[(i+1)%len(loop)])
+				normal[0] += (p[1] - q[1]) * (p[2] + q[2])
+				normal[1] += (p[2] - q[2]) * (p[0] + q[0])
+				normal[2] += (p[0] - q[0]) * (p[1] + q[1])
+				a, b := corner(edges[e][0]), corner(edges[e][1])
+				d := dvec3.Sub(&b, &a)
+				if in(edges[e][1]) {
+					d.Invert()
+				}
+				grad.Add(&d)
+			}
+			if dvec3.Dot(&normal, &grad) < 0 {
+				for i, j := 0, len(loop)-1; i < j; i, j = i+1, j-1 {
+					loop[i], loop[j] = loop[j], loop[i]
+				}
+			}
+			loops[cfg] = append(loops[cfg], loop)
+		}
+	}
+	return edges, loops
+}
+
+func (g *VoxelGrid) ToMesh() (*Mesh, error) {
+	nd := &MeshNode{}
+	tg := &MeshTriangle{}
+	verts := make(map[[4]int]uint32)
+	vertex := func(x, y, z, e int) uint32 {
+		a, b := marchingEdges[e][0], marchingEdges[e][1]
+		base := [3]int{x + a&1, y + a>>1&1, z + a>>2&1}
+		axis := 0
+		for d := b ^ a; d > 1; d >>= 1 {
+			axis++
+		}
+		key := [4]int{base[0], base[1], base[2], axis}
+		if i, ok := verts[key]; ok {
+			return i
+		}
+		p := g.Center(base[0], base[1], base[2])
+		p[axis] += g.CellSize / 2
+		i := uint32(len(nd.Vertices))
+		verts[key] = i
+		nd.Vertices = append(nd.Vertices, vec3.T{float32(p[0]), float32(p[1]), float32(p[2])})
+		return i
+	}
+	for z := -1; z < g.Dims[2]; z++ {
+		for y := -1; y < g.Dims[1]; y++ {
+			for x := -1; x < g.Dims[0]; x++ {
+				cfg := 0
+				for c := 0; c < 8; c++ {
+					if g.Get(x+c&1, y+c>>1&1, z+c>>2&1) {
+						cfg |= 1 << uint(c)
+					}
+				}
+				for _, loop := range marchingLoops[cfg] {
+					v0 := vertex(x, y, z, loop[0])
+					for i := 1; i+1 < len(loop); i++ {
+						tg.Faces = append(tg.Faces, &Face{Vertex: [3]uint32{v0, vertex(x, y, z, loop[i]), vertex(x, y, z, loop[i+1])}})
+					}
+				}
+			}
+		}
+	}
+	if len(tg.Faces) == 0 {
+		return nil, ErrEmptyMesh
+	}
+	nd.FaceGroup = []*MeshTriangle{tg}
+	nd.ReComputeNormal()
+	ms := NewMesh()
+	ms.Materials = []MeshMaterial{&BaseMaterial{Color: [3]byte{128, 128, 128}}}
+	ms.Nodes = []*MeshNode{nd}
+	return ms, nil
+}